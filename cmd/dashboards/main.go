@@ -0,0 +1,35 @@
+// Command dashboards writes the Grafana RED dashboard of each metrics
+// domain, generated from the telemetry metric definitions, as
+// <domain>.json. The packaged copies in deploy/grafana/dashboards are
+// refreshed with it whenever the metrics change.
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	out := flag.String("out", "deploy/grafana/dashboards", "directory to write the dashboards to")
+	flag.Parse()
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		logrus.Fatalf("Failed to create %s: %v", *out, err)
+	}
+	for _, domain := range telemetry.Domains() {
+		data, err := telemetry.Dashboard(domain)
+		if err != nil {
+			logrus.Fatalf("Failed to generate the %s dashboard: %v", domain, err)
+		}
+		path := filepath.Join(*out, domain+".json")
+		if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+			logrus.Fatalf("Failed to write %s: %v", path, err)
+		}
+		logrus.Infof("Wrote %s", path)
+	}
+}
@@ -0,0 +1,85 @@
+// Command loadtest drives the paywall hot path of a running server at a
+// fixed request rate and prints latency percentiles per endpoint and the
+// server's cache hit rates. Users are read from the server's database,
+// which should have been filled by the seed command; the configuration is
+// the server's.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/loadtest"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	var opts loadtest.Options
+	flag.StringVar(&opts.BaseURL, "url", "http://localhost:8080/api/v1", "API root of the server under test")
+	metricsURL := flag.String("metrics-url", "http://localhost:8080/metrics", "server's Prometheus metrics, for cache hit rates; empty to skip")
+	flag.IntVar(&opts.RPS, "rps", 200, "requests per second to send")
+	flag.DurationVar(&opts.Duration, "duration", 30*time.Second, "how long to send requests for")
+	flag.IntVar(&opts.Concurrency, "concurrency", 100, "most requests in flight; requests due beyond it are skipped")
+	flag.Float64Var(&opts.CheckRatio, "check-ratio", 0.5, "share of requests to /paywall/check, the rest going to /paywall/enforce")
+	flag.IntVar(&opts.Contents, "contents", 1000, "number of distinct content IDs to request")
+	flag.DurationVar(&opts.Timeout, "timeout", 5*time.Second, "timeout of each request")
+	flag.Int64Var(&opts.Seed, "seed", 1, "random seed for the choice of users, content and endpoints")
+	users := flag.Int("users", 1000, "most users to send requests for")
+	flag.Parse()
+
+	if opts.RPS <= 0 || opts.Concurrency <= 0 || opts.Contents <= 0 || *users <= 0 || opts.Duration <= 0 {
+		logrus.Fatal("-rps, -concurrency, -contents, -users and -duration must be positive")
+	}
+	if opts.CheckRatio < 0 || opts.CheckRatio > 1 {
+		logrus.Fatal("-check-ratio must be between 0 and 1")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		logrus.Fatalf("Failed to load config: %v", err)
+	}
+	conn, err := db.NewConnection(cfg.Database)
+	if err != nil {
+		logrus.Fatalf("Failed to connect to database: %v", err)
+	}
+	targets, err := loadtest.LoadTargets(context.Background(), conn, *users)
+	conn.Close()
+	if err != nil {
+		logrus.Fatalf("Failed to load users: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	var before loadtest.CacheCounts
+	if *metricsURL != "" {
+		if before, err = loadtest.ScrapeCacheCounts(ctx, client, *metricsURL); err != nil {
+			logrus.Fatalf("Failed to read metrics: %v", err)
+		}
+	}
+
+	logrus.Infof("Sending %d requests/s for %s for %d users", opts.RPS, opts.Duration, len(targets))
+	report := loadtest.NewRunner(opts, targets).Run(ctx)
+
+	if *metricsURL != "" {
+		after, err := loadtest.ScrapeCacheCounts(context.Background(), client, *metricsURL)
+		if err != nil {
+			logrus.Errorf("Failed to read metrics: %v", err)
+		} else {
+			report.CacheHits = after.Since(before)
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+}
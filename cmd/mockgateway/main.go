@@ -0,0 +1,24 @@
+// Command mockgateway serves fake Stripe and PayPal APIs for local
+// development. Point payment.gateway_url and payment.paypal.base_url at it
+// and pick payment methods from the gatewaymock package documentation to
+// get declines, timeouts and bank debits.
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"scalable-paywall/internal/payment/gatewaymock"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	flag.Parse()
+
+	logrus.Infof("Mock payment gateway listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, gatewaymock.New()); err != nil {
+		logrus.Fatalf("Mock payment gateway failed: %v", err)
+	}
+}
@@ -0,0 +1,328 @@
+// Command paywallctl runs one-off administration tasks against the server's
+// database and Redis: creating plans, inspecting subscriptions, replaying
+// webhook events, granting credit, applying migrations, renewing a user's
+// subscriptions, migrating subscribers from Stripe Billing and warming the
+// cache after a deploy. It reads the same configuration as the server and
+// prints its results as JSON.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/encryption"
+	"scalable-paywall/internal/featureflag"
+	"scalable-paywall/internal/i18n"
+	"scalable-paywall/internal/imports"
+	"scalable-paywall/internal/jobs"
+	"scalable-paywall/internal/notification"
+	"scalable-paywall/internal/payment"
+	"scalable-paywall/internal/plan"
+	"scalable-paywall/internal/risk"
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/user"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+const usage = `Usage: paywallctl <command> [flags] [args]
+
+Commands:
+  plans create [-file plan.json]                   create a plan from a JSON body as POST /plans takes (stdin by default)
+  subscriptions get <subscription-id>              print a subscription
+  subscriptions user <user-id>                     print the subscription a user has access through, with its entitlement
+  webhooks replay [-force] <event-id>              run a stored webhook event through the handlers again
+  credit grant -amount 5.00 [-reason r] <subscription-id>
+                                                   credit part of a subscription's latest charge to the user
+  migrate                                          apply pending database migrations
+  renew <user-id>                                  charge a user's auto-renewing subscriptions for their next period now
+  import stripe                                    migrate Stripe Billing customers and subscriptions, as imports.stripe configures
+  cache warm                                       preload plans and recently used subscriptions into Redis, as cache.warmup configures
+`
+
+// command is one subcommand; args are those after its name
+type command func(ctx context.Context, app *app, args []string) (interface{}, error)
+
+var commands = map[string]command{
+	"plans create":       createPlan,
+	"subscriptions get":  getSubscription,
+	"subscriptions user": getUserSubscription,
+	"webhooks replay":    replayWebhook,
+	"credit grant":       grantCredit,
+	"migrate":            migrate,
+	"renew":              renew,
+	"import stripe":      importStripe,
+	"cache warm":         warmCache,
+}
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	cmd, args, ok := lookup(flag.Args())
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		logrus.Fatalf("Failed to load config: %v", err)
+	}
+	app, err := newApp(cfg)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer app.close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := cmd(ctx, app, args)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(result)
+}
+
+// lookup finds the command named by the first one or two arguments
+func lookup(args []string) (command, []string, bool) {
+	if len(args) >= 2 {
+		if cmd, ok := commands[args[0]+" "+args[1]]; ok {
+			return cmd, args[2:], true
+		}
+	}
+	if len(args) >= 1 {
+		if cmd, ok := commands[args[0]]; ok {
+			return cmd, args[1:], true
+		}
+	}
+	return nil, nil, false
+}
+
+// app holds the connections and services the commands share
+type app struct {
+	cfg      *config.Config
+	conn     *db.Connection
+	redis    *cache.RedisClient
+	users    *user.Service
+	subs     *subscription.Service
+	payments *payment.Service
+}
+
+func newApp(cfg *config.Config) (*app, error) {
+	conn, err := db.NewConnection(cfg.Database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	redis, err := cache.NewRedisClient(cfg.Cache)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	keyring, err := encryption.NewKeyring(cfg.Encryption)
+	if err != nil {
+		conn.Close()
+		redis.Close()
+		return nil, fmt.Errorf("failed to load encryption keyring: %w", err)
+	}
+	bundle, err := i18n.Load(cfg.I18n)
+	if err != nil {
+		conn.Close()
+		redis.Close()
+		return nil, fmt.Errorf("failed to load translations: %w", err)
+	}
+
+	subs := subscription.NewService(&cfg.Subscription, conn, redis, notification.NewNotifier(cfg.Notification, notification.NewTemplates(conn, bundle)), nil)
+	return &app{
+		cfg:   cfg,
+		conn:  conn,
+		redis: redis,
+		users: user.NewService(&cfg.Auth, conn, redis, keyring),
+		subs:  subs,
+		payments: payment.NewService(&cfg.Payment, conn, redis, jobs.NewQueue(conn, cfg.Jobs.MaxAttempts), featureflag.NewService(cfg.FeatureFlags, redis),
+			subs, risk.NewService(cfg.Payment.Risk, conn, redis), keyring),
+	}, nil
+}
+
+func (a *app) plans() *plan.Service {
+	return plan.NewService(&a.cfg.Pricing, a.cfg.Features, a.conn, a.redis, nil, nil)
+}
+
+func (a *app) close() {
+	a.redis.Close()
+	a.conn.Close()
+}
+
+// parse parses a command's flags and checks it got want positional args
+func parse(fs *flag.FlagSet, args []string, want int, names string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != want {
+		return nil, fmt.Errorf("usage: paywallctl %s %s", fs.Name(), names)
+	}
+	return fs.Args(), nil
+}
+
+func createPlan(ctx context.Context, a *app, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("plans create", flag.ContinueOnError)
+	file := fs.String("file", "-", "JSON plan to create; - reads stdin")
+	if _, err := parse(fs, args, 0, "[-file plan.json]"); err != nil {
+		return nil, err
+	}
+
+	in := os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	var req plan.CreatePlanRequest
+	if err := json.NewDecoder(in).Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid plan JSON: %w", err)
+	}
+	return a.plans().ImportPlan(ctx, req)
+}
+
+func getSubscription(ctx context.Context, a *app, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("subscriptions get", flag.ContinueOnError)
+	ids, err := parse(fs, args, 1, "<subscription-id>")
+	if err != nil {
+		return nil, err
+	}
+	sub, err := a.subs.GetSubscriptionByID(ctx, ids[0])
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("subscription %s not found", ids[0])
+	}
+	return sub, err
+}
+
+func getUserSubscription(ctx context.Context, a *app, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("subscriptions user", flag.ContinueOnError)
+	ids, err := parse(fs, args, 1, "<user-id>")
+	if err != nil {
+		return nil, err
+	}
+	entitlement, err := a.subs.GetEntitlementByUserID(ctx, ids[0])
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("user %s has no active subscription", ids[0])
+	}
+	return entitlement, err
+}
+
+func replayWebhook(ctx context.Context, a *app, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("webhooks replay", flag.ContinueOnError)
+	force := fs.Bool("force", false, "replay the event even if it was already processed")
+	ids, err := parse(fs, args, 1, "[-force] <event-id>")
+	if err != nil {
+		return nil, err
+	}
+	event, err := a.payments.ReplayWebhook(ctx, ids[0], *force)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("webhook event %s not found", ids[0])
+	}
+	if errors.Is(err, payment.ErrWebhookProcessed) {
+		return nil, fmt.Errorf("webhook event %s was already processed; pass -force to replay it", ids[0])
+	}
+	return event, err
+}
+
+func grantCredit(ctx context.Context, a *app, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("credit grant", flag.ContinueOnError)
+	amount := fs.String("amount", "", "credit to grant, in the charge's currency")
+	reason := fs.String("reason", "", "reason recorded with the credit")
+	ids, err := parse(fs, args, 1, "-amount 5.00 [-reason r] <subscription-id>")
+	if err != nil {
+		return nil, err
+	}
+	value, err := decimal.NewFromString(*amount)
+	if err != nil {
+		return nil, fmt.Errorf("-amount must be a decimal amount: %w", err)
+	}
+	refund, err := a.payments.GrantCredit(ctx, ids[0], value, *reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("subscription %s not found", ids[0])
+	}
+	return refund, err
+}
+
+func migrate(ctx context.Context, a *app, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if _, err := parse(fs, args, 0, ""); err != nil {
+		return nil, err
+	}
+	applied, err := a.conn.Migrate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if applied == nil {
+		applied = []string{}
+	}
+	return map[string][]string{"applied": applied}, nil
+}
+
+func renew(ctx context.Context, a *app, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("renew", flag.ContinueOnError)
+	ids, err := parse(fs, args, 1, "<user-id>")
+	if err != nil {
+		return nil, err
+	}
+	renewed, err := a.payments.RenewUser(ctx, ids[0], a.cfg.Subscription)
+	if err != nil {
+		return nil, err
+	}
+	if len(renewed) == 0 {
+		return nil, fmt.Errorf("user %s has no subscription to renew now", ids[0])
+	}
+	return renewed, nil
+}
+
+func importStripe(ctx context.Context, a *app, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("import stripe", flag.ContinueOnError)
+	if _, err := parse(fs, args, 0, ""); err != nil {
+		return nil, err
+	}
+	migration := imports.NewStripeMigration(a.cfg.Imports.Stripe, a.conn, a.plans(), a.users, a.subs)
+	report, err := migration.Run(ctx)
+	if err != nil && report != nil {
+		// Show what was migrated before the run stopped
+		encoder := json.NewEncoder(os.Stderr)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	}
+	return report, err
+}
+
+func warmCache(ctx context.Context, a *app, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("cache warm", flag.ContinueOnError)
+	if _, err := parse(fs, args, 0, ""); err != nil {
+		return nil, err
+	}
+	if !a.cfg.Cache.Warmup.Enabled {
+		return nil, errors.New("cache warmup is disabled (cache.warmup.enabled)")
+	}
+	if a.redis.Degraded() {
+		return nil, errors.New("redis is unreachable")
+	}
+	a.plans().RegisterWarmers(a.cfg.Cache.Warmup)
+	a.subs.RegisterWarmers(a.cfg.Cache.Warmup)
+	return a.redis.Warm(ctx)
+}
@@ -0,0 +1,82 @@
+// Command seed fills a development database with sample plans, users,
+// subscriptions, payments and usage. It reads the same configuration as the
+// server and expects the migrations to have been applied.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/encryption"
+	"scalable-paywall/internal/i18n"
+	"scalable-paywall/internal/notification"
+	"scalable-paywall/internal/plan"
+	"scalable-paywall/internal/seed"
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/user"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	var scale seed.Scale
+	flag.IntVar(&scale.Users, "users", 100, "number of users to create, each with a subscription")
+	flag.IntVar(&scale.HistoryDays, "history-days", 365, "how many days back subscriptions may have started")
+	flag.IntVar(&scale.UsageDays, "usage-days", 30, "days of usage to log for live subscriptions")
+	flag.IntVar(&scale.UsagePerDay, "usage-per-day", 20, "most usage events a subscriber logs in a day")
+	randSeed := flag.Int64("seed", 1, "random seed; the same seed and scale create the same mix of data")
+	flag.Parse()
+
+	if scale.Users < 0 || scale.HistoryDays < 0 || scale.UsageDays < 0 || scale.UsagePerDay < 0 {
+		logrus.Fatal("-users, -history-days, -usage-days and -usage-per-day must not be negative")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		logrus.Fatalf("Failed to load config: %v", err)
+	}
+
+	conn, err := db.NewConnection(cfg.Database)
+	if err != nil {
+		logrus.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	redis, err := cache.NewRedisClient(cfg.Cache)
+	if err != nil {
+		logrus.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer redis.Close()
+
+	keyring, err := encryption.NewKeyring(cfg.Encryption)
+	if err != nil {
+		logrus.Fatalf("Failed to load encryption keyring: %v", err)
+	}
+
+	bundle, err := i18n.Load(cfg.I18n)
+	if err != nil {
+		logrus.Fatalf("Failed to load translations: %v", err)
+	}
+
+	seeder := seed.NewSeeder(
+		conn,
+		user.NewService(&cfg.Auth, conn, redis, keyring),
+		plan.NewService(&cfg.Pricing, cfg.Features, conn, redis, nil, nil),
+		subscription.NewService(&cfg.Subscription, conn, redis, notification.NewNotifier(cfg.Notification, notification.NewTemplates(conn, bundle)), nil),
+		*randSeed,
+	)
+
+	start := time.Now()
+	summary, err := seeder.Run(context.Background(), scale)
+	if err != nil {
+		logrus.Fatalf("Seeding stopped after %+v: %v", *summary, err)
+	}
+	logrus.Infof("Seeded in %s", time.Since(start).Round(time.Millisecond))
+	json.NewEncoder(os.Stdout).Encode(summary)
+}
@@ -0,0 +1,175 @@
+package accounting
+
+import (
+	"encoding/csv"
+	"io"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/shopspring/decimal"
+)
+
+// Kinds of ledger items the export books.
+const (
+	KindInvoice = "invoice"
+	KindPayment = "payment"
+	KindRefund  = "refund"
+	KindCredit  = "credit"
+)
+
+// Item is an invoice, payment, refund or credit to book. PlanID picks the
+// revenue account and is empty when the item has no subscription.
+type Item struct {
+	Kind      string
+	ID        string
+	Date      time.Time
+	Amount    decimal.Decimal
+	Tax       decimal.Decimal
+	Currency  string
+	PlanID    string
+	Customer  string
+	Reference string
+}
+
+// JournalEntry is a balanced journal of one item
+type JournalEntry struct {
+	Number   string
+	Date     time.Time
+	Currency string
+	Memo     string
+	Customer string
+	Lines    []JournalLine
+}
+
+// JournalLine debits or credits one account
+type JournalLine struct {
+	Account     string
+	Debit       decimal.Decimal
+	Credit      decimal.Decimal
+	Description string
+	TaxRate     string
+}
+
+// Journal books an item double-entry. An invoice is receivable against
+// revenue and tax, and its payment banks the receivable; card charges,
+// which are paid at once, are both. A refund pays revenue back from the
+// bank and a credit against the receivable.
+func Journal(cfg config.AccountingConfig, item Item) JournalEntry {
+	revenue := cfg.DefaultRevenueAccount
+	if account, ok := cfg.RevenueAccounts[item.PlanID]; ok {
+		revenue = account
+	}
+	refunds := cfg.RefundAccount
+	if refunds == "" {
+		refunds = revenue
+	}
+	net := item.Amount.Sub(item.Tax)
+
+	entry := JournalEntry{
+		Number:   item.Kind + "-" + item.ID,
+		Date:     item.Date,
+		Currency: item.Currency,
+		Memo:     item.Kind + " " + item.Reference,
+		Customer: item.Customer,
+	}
+	line := func(account string, debit, credit decimal.Decimal) {
+		entry.Lines = append(entry.Lines, JournalLine{Account: account, Debit: debit, Credit: credit, Description: entry.Memo, TaxRate: cfg.TaxRate})
+	}
+	zero := decimal.Zero
+	switch item.Kind {
+	case KindInvoice:
+		line(cfg.ReceivableAccount, item.Amount, zero)
+		line(revenue, zero, net)
+		if !item.Tax.IsZero() {
+			line(cfg.TaxAccount, zero, item.Tax)
+		}
+	case KindPayment:
+		line(cfg.BankAccount, item.Amount, zero)
+		line(cfg.ReceivableAccount, zero, item.Amount)
+	case KindRefund:
+		line(refunds, item.Amount, zero)
+		line(cfg.BankAccount, zero, item.Amount)
+	case KindCredit:
+		line(refunds, item.Amount, zero)
+		line(cfg.ReceivableAccount, zero, item.Amount)
+	}
+	return entry
+}
+
+// Writer writes journal entries in an accounting package's import format.
+type Writer interface {
+	Write(entry JournalEntry) error
+	Flush() error
+}
+
+// NewWriter returns the writer of format, quickbooks or xero, and whether
+// the format is known. It writes the header row straight away.
+func NewWriter(w io.Writer, format string) (Writer, bool) {
+	cw := csv.NewWriter(w)
+	switch format {
+	case "quickbooks":
+		cw.Write([]string{"Journal No", "Journal Date", "Currency", "Memo", "Account Name", "Debits", "Credits", "Description", "Name"})
+		return &quickBooksWriter{csv: cw}, true
+	case "xero":
+		cw.Write([]string{"*Narration", "*Date", "Description", "*AccountCode", "*TaxRate", "*Amount", "Reference"})
+		return &xeroWriter{csv: cw}, true
+	default:
+		return nil, false
+	}
+}
+
+// quickBooksWriter writes the QuickBooks Online journal entry import: one
+// row per line, grouped by journal number
+type quickBooksWriter struct {
+	csv *csv.Writer
+}
+
+func (q *quickBooksWriter) Write(entry JournalEntry) error {
+	for _, l := range entry.Lines {
+		err := q.csv.Write([]string{
+			entry.Number, entry.Date.UTC().Format("01/02/2006"), entry.Currency, entry.Memo,
+			l.Account, amountOrBlank(l.Debit), amountOrBlank(l.Credit), l.Description, entry.Customer,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *quickBooksWriter) Flush() error {
+	q.csv.Flush()
+	return q.csv.Error()
+}
+
+// xeroWriter writes the Xero manual journal import, where a debit is a
+// positive amount and a credit a negative one
+type xeroWriter struct {
+	csv *csv.Writer
+}
+
+func (x *xeroWriter) Write(entry JournalEntry) error {
+	for _, l := range entry.Lines {
+		err := x.csv.Write([]string{
+			entry.Memo + " (" + entry.Customer + ")", entry.Date.UTC().Format("2006-01-02"), l.Description,
+			l.Account, l.TaxRate, l.Debit.Sub(l.Credit).StringFixed(2), entry.Number,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *xeroWriter) Flush() error {
+	x.csv.Flush()
+	return x.csv.Error()
+}
+
+func amountOrBlank(amount decimal.Decimal) string {
+	if amount.IsZero() {
+		return ""
+	}
+	return amount.StringFixed(2)
+}
@@ -0,0 +1,72 @@
+package accounting
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testAccounts = config.AccountingConfig{
+	ReceivableAccount:     "1200",
+	BankAccount:           "1100",
+	DefaultRevenueAccount: "4000",
+	RevenueAccounts:       map[string]string{"plan-pro": "4010"},
+	RefundAccount:         "4900",
+	TaxAccount:            "2200",
+	TaxRate:               "Tax Exempt",
+}
+
+func TestJournal(t *testing.T) {
+	at := time.Date(2026, 5, 3, 10, 0, 0, 0, time.UTC)
+	invoice := Journal(testAccounts, Item{Kind: KindInvoice, ID: "t1", Date: at, Amount: decimal.RequireFromString("12.00"),
+		Tax: decimal.RequireFromString("2.00"), Currency: "EUR", PlanID: "plan-pro", Customer: "ada", Reference: "ch_1"})
+
+	assert.Equal(t, "invoice-t1", invoice.Number)
+	require.Len(t, invoice.Lines, 3)
+	assert.Equal(t, "1200", invoice.Lines[0].Account)
+	assert.True(t, decimal.RequireFromString("12").Equal(invoice.Lines[0].Debit))
+	assert.Equal(t, "4010", invoice.Lines[1].Account)
+	assert.True(t, decimal.RequireFromString("10").Equal(invoice.Lines[1].Credit))
+	assert.Equal(t, "2200", invoice.Lines[2].Account)
+
+	refund := Journal(testAccounts, Item{Kind: KindRefund, ID: "r1", Date: at, Amount: decimal.RequireFromString("5"), Tax: decimal.Zero, PlanID: "plan-basic"})
+	assert.Equal(t, []string{"4900", "1100"}, []string{refund.Lines[0].Account, refund.Lines[1].Account})
+
+	noRefundAccount := testAccounts
+	noRefundAccount.RefundAccount = ""
+	credit := Journal(noRefundAccount, Item{Kind: KindCredit, ID: "c1", Date: at, Amount: decimal.RequireFromString("5"), Tax: decimal.Zero, PlanID: "plan-basic"})
+	assert.Equal(t, []string{"4000", "1200"}, []string{credit.Lines[0].Account, credit.Lines[1].Account})
+}
+
+func TestWriters(t *testing.T) {
+	at := time.Date(2026, 5, 3, 10, 0, 0, 0, time.UTC)
+	entry := Journal(testAccounts, Item{Kind: KindPayment, ID: "t1", Date: at, Amount: decimal.RequireFromString("9.99"),
+		Tax: decimal.Zero, Currency: "USD", Customer: "ada", Reference: "ch_1"})
+
+	var qb bytes.Buffer
+	w, ok := NewWriter(&qb, "quickbooks")
+	require.True(t, ok)
+	require.NoError(t, w.Write(entry))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "Journal No,Journal Date,Currency,Memo,Account Name,Debits,Credits,Description,Name\n"+
+		"payment-t1,05/03/2026,USD,payment ch_1,1100,9.99,,payment ch_1,ada\n"+
+		"payment-t1,05/03/2026,USD,payment ch_1,1200,,9.99,payment ch_1,ada\n", qb.String())
+
+	var xero bytes.Buffer
+	w, ok = NewWriter(&xero, "xero")
+	require.True(t, ok)
+	require.NoError(t, w.Write(entry))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "*Narration,*Date,Description,*AccountCode,*TaxRate,*Amount,Reference\n"+
+		"payment ch_1 (ada),2026-05-03,payment ch_1,1100,Tax Exempt,9.99,payment-t1\n"+
+		"payment ch_1 (ada),2026-05-03,payment ch_1,1200,Tax Exempt,-9.99,payment-t1\n", xero.String())
+
+	_, ok = NewWriter(&xero, "sage")
+	assert.False(t, ok)
+}
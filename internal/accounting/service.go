@@ -0,0 +1,153 @@
+package accounting
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// maxExportDays bounds the period of one export
+const maxExportDays = 366
+
+// Service exports the ledger for accounting packages.
+type Service struct {
+	db  *db.Connection
+	cfg config.AccountingConfig
+}
+
+func NewService(db *db.Connection, cfg config.AccountingConfig) *Service {
+	return &Service{db: db, cfg: cfg}
+}
+
+// ExportJournal downloads the invoices, payments, refunds and credits of a
+// period as journal entries in the CSV import format of QuickBooks Online
+// or Xero (format). from and to are inclusive dates, last month by
+// default; currency keeps one currency, as Xero journals need.
+func (s *Service) ExportJournal(c *gin.Context) {
+	format := c.Query("format")
+	if format != "quickbooks" && format != "xero" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be quickbooks or xero"})
+		telemetry.RecordPaymentOperation("accounting_export", "validation_error")
+		return
+	}
+	from, to, err := exportPeriod(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPaymentOperation("accounting_export", "validation_error")
+		return
+	}
+
+	items, err := s.items(c.Request.Context(), from, to, c.Query("currency"))
+	if err != nil {
+		logrus.Errorf("Failed to read ledger for accounting export: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("accounting_export", "db_error")
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="journal-%s-%s-%s.csv"`,
+		format, from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102")))
+	c.Status(http.StatusOK)
+
+	w, _ := NewWriter(c.Writer, format)
+	for _, item := range items {
+		if err := w.Write(Journal(s.cfg, item)); err != nil {
+			logrus.Errorf("Failed to write accounting export: %v", err)
+			break
+		}
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("Failed to write accounting export: %v", err)
+	}
+	telemetry.RecordPaymentOperation("accounting_export", "success")
+}
+
+// exportPeriod parses the inclusive from and to dates into a half-open
+// range in UTC, defaulting to the calendar month before now
+func exportPeriod(fromStr, toStr string, now time.Time) (time.Time, time.Time, error) {
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from, to := thisMonth.AddDate(0, -1, 0), thisMonth
+	var err error
+	if fromStr != "" {
+		if from, err = time.Parse("2006-01-02", fromStr); err != nil {
+			return from, to, fmt.Errorf("from must be a date (YYYY-MM-DD)")
+		}
+	}
+	if toStr != "" {
+		if to, err = time.Parse("2006-01-02", toStr); err != nil {
+			return from, to, fmt.Errorf("to must be a date (YYYY-MM-DD)")
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		return from, to, fmt.Errorf("to must not be before from")
+	}
+	if to.Sub(from) > maxExportDays*24*time.Hour {
+		return from, to, fmt.Errorf("the period must not exceed %d days", maxExportDays)
+	}
+	return from, to, nil
+}
+
+// items reads what to book in [from, to). Card charges are their own
+// invoice; charges paying a manual invoice are only the payment of the
+// invoice issued earlier. Refunds and credits book against the plan the
+// subscription is on now. Tax is zero until tax is calculated on charges.
+func (s *Service) items(ctx context.Context, from, to time.Time, currency string) ([]Item, error) {
+	rows, err := s.db.Reader().QueryContext(ctx, `
+		SELECT kind, id, at, amount, currency, plan_id, customer, reference FROM (
+			SELECT 'invoice' AS kind, t.id::text AS id, t.created_at AS at, t.amount,
+				COALESCE(t.currency, 'USD') AS currency, COALESCE(sub.plan_id::text, '') AS plan_id,
+				u.username AS customer, COALESCE(t.gateway_transaction_id, '') AS reference
+			FROM payment_transactions t
+			JOIN users u ON u.id = t.user_id
+			LEFT JOIN subscriptions sub ON sub.id = t.subscription_id
+			WHERE t.status IN ('completed', 'refunded') AND t.payment_method IS DISTINCT FROM 'manual_invoice'
+			UNION ALL
+			SELECT 'invoice', i.id::text, i.created_at, i.amount, i.currency, i.plan_id::text, u.username, i.number
+			FROM manual_invoices i
+			JOIN users u ON u.id = i.user_id
+			WHERE i.status <> 'void'
+			UNION ALL
+			SELECT 'payment', t.id::text, t.created_at, t.amount, COALESCE(t.currency, 'USD'),
+				COALESCE(sub.plan_id::text, ''), u.username, COALESCE(t.gateway_transaction_id, '')
+			FROM payment_transactions t
+			JOIN users u ON u.id = t.user_id
+			LEFT JOIN subscriptions sub ON sub.id = t.subscription_id
+			WHERE t.status IN ('completed', 'refunded')
+			UNION ALL
+			SELECT r.kind, r.id::text, r.created_at, r.amount, r.currency, COALESCE(sub.plan_id::text, ''),
+				u.username, COALESCE(r.gateway_refund_id, '')
+			FROM refunds r
+			JOIN users u ON u.id = r.user_id
+			LEFT JOIN subscriptions sub ON sub.id = r.subscription_id
+			WHERE r.status = 'completed'
+		) ledger
+		WHERE at >= $1 AND at < $2 AND ($3 = '' OR currency = $3)
+		ORDER BY at, kind, id
+	`, from, to, currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		item := Item{Tax: decimal.Zero}
+		if err := rows.Scan(&item.Kind, &item.ID, &item.Date, &item.Amount, &item.Currency,
+			&item.PlanID, &item.Customer, &item.Reference); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
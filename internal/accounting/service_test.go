@@ -0,0 +1,30 @@
+package accounting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportPeriod(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	from, to, err := exportPeriod("", "", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), to)
+
+	from, to, err = exportPeriod("2026-01-01", "2026-01-31", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), to)
+
+	_, _, err = exportPeriod("2026-02-01", "2026-01-31", now)
+	assert.EqualError(t, err, "to must not be before from")
+	_, _, err = exportPeriod("2024-01-01", "2026-01-31", now)
+	assert.EqualError(t, err, "the period must not exceed 366 days")
+	_, _, err = exportPeriod("01/02/2026", "", now)
+	assert.EqualError(t, err, "from must be a date (YYYY-MM-DD)")
+}
@@ -0,0 +1,46 @@
+package apikey
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllows(t *testing.T) {
+	readOnly := &APIKey{Scopes: []Scope{ScopeReadOnly}}
+	assert.True(t, readOnly.Allows(ScopeBillingAdmin, http.MethodGet))
+	assert.True(t, readOnly.Allows(ScopePaywallCheck, http.MethodHead))
+	assert.False(t, readOnly.Allows(ScopeBillingAdmin, http.MethodPost))
+	assert.False(t, readOnly.Allows(ScopeReadOnly, http.MethodDelete))
+
+	paywall := &APIKey{Scopes: []Scope{ScopePaywallCheck}}
+	assert.True(t, paywall.Allows(ScopePaywallCheck, http.MethodPost))
+	assert.False(t, paywall.Allows(ScopeBillingAdmin, http.MethodGet))
+
+	both := &APIKey{Scopes: []Scope{ScopePaywallCheck, ScopeReadOnly}}
+	assert.True(t, both.Allows(ScopeBillingAdmin, http.MethodGet))
+	assert.True(t, both.Allows(ScopePaywallCheck, http.MethodPost))
+	assert.False(t, both.Allows(ScopeBillingAdmin, http.MethodPut))
+
+	admin := &APIKey{Scopes: []Scope{ScopeBillingAdmin}}
+	assert.True(t, admin.Allows(ScopeBillingAdmin, http.MethodDelete))
+	assert.True(t, admin.Allows(ScopePaywallCheck, http.MethodPost))
+
+	assert.False(t, (&APIKey{}).Allows(ScopePaywallCheck, http.MethodGet))
+}
+
+func TestGenerateKey(t *testing.T) {
+	key, err := generateKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, keyPrefix))
+	assert.Len(t, key, len(keyPrefix)+48)
+
+	other, err := generateKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+	assert.NotEqual(t, hashKey(key), hashKey(other))
+	assert.Len(t, hashKey(key), 64)
+}
@@ -0,0 +1,156 @@
+//go:build integration
+
+package apikey_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/apikey"
+	testenv "scalable-paywall/internal/testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var env *testenv.Env
+
+func TestMain(m *testing.M) { os.Exit(testenv.Main(m, &env)) }
+
+func createKey(t *testing.T, keys *apikey.Service, body string) apikey.CreateKeyResponse {
+	t.Helper()
+	w := testenv.Serve(keys.CreateKey, http.MethodPost, "/api/v1/admin/api-keys", body)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created apikey.CreateKeyResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	return created
+}
+
+// call makes a request with key through Authenticate(scope) and returns
+// its status, 204 when the route was reached
+func call(keys *apikey.Service, scope apikey.Scope, method, key string) int {
+	router := gin.New()
+	router.Handle(method, "/route", keys.Authenticate(scope), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	req := httptest.NewRequest(method, "/route", nil)
+	req.Header.Set(apikey.Header, key)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAuthenticateEnforcesScopesAndRevocation(t *testing.T) {
+	env.Reset(t)
+	keys := env.APIKeys()
+	created := createKey(t, keys, `{"name": "edge", "scopes": ["paywall_check"]}`)
+	assert.Equal(t, created.Key[:11], created.Prefix)
+
+	assert.Equal(t, http.StatusNoContent, call(keys, apikey.ScopePaywallCheck, http.MethodPost, created.Key))
+	assert.Equal(t, http.StatusForbidden, call(keys, apikey.ScopeBillingAdmin, http.MethodGet, created.Key))
+	assert.Equal(t, http.StatusUnauthorized, call(keys, apikey.ScopePaywallCheck, http.MethodPost, "sk_guessed"))
+	assert.Equal(t, http.StatusUnauthorized, call(keys, apikey.ScopePaywallCheck, http.MethodPost, ""))
+
+	w := testenv.Serve(keys.RevokeKey, http.MethodDelete, "/api/v1/admin/api-keys/"+created.ID, "", gin.Param{Key: "id", Value: created.ID})
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, call(keys, apikey.ScopePaywallCheck, http.MethodPost, created.Key))
+
+	w = testenv.Serve(keys.ListKeys, http.MethodGet, "/api/v1/admin/api-keys", "")
+	var list apikey.KeyListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Keys, 1)
+	assert.NotNil(t, list.Keys[0].RevokedAt)
+	assert.NotNil(t, list.Keys[0].LastUsedAt)
+}
+
+func TestAuthenticateEnforcesAllowlist(t *testing.T) {
+	env.Reset(t)
+	keys := env.APIKeys()
+
+	// httptest requests come from 192.0.2.1
+	office := createKey(t, keys, `{"name": "office", "scopes": ["read_only"], "allowed_cidrs": ["203.0.113.0/24"]}`)
+	assert.Equal(t, http.StatusForbidden, call(keys, apikey.ScopeBillingAdmin, http.MethodGet, office.Key))
+
+	local := createKey(t, keys, `{"name": "local", "scopes": ["read_only"], "allowed_cidrs": ["192.0.2.0/24"]}`)
+	assert.Equal(t, http.StatusNoContent, call(keys, apikey.ScopeBillingAdmin, http.MethodGet, local.Key))
+	assert.Equal(t, http.StatusForbidden, call(keys, apikey.ScopeBillingAdmin, http.MethodPost, local.Key))
+
+	w := testenv.Serve(keys.CreateKey, http.MethodPost, "/api/v1/admin/api-keys", `{"name": "bad", "scopes": ["read_only"], "allowed_cidrs": ["office"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = testenv.Serve(keys.CreateKey, http.MethodPost, "/api/v1/admin/api-keys", `{"name": "bad", "scopes": ["superuser"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// signed makes a POST request with body through VerifySignature, signed
+// with secret unless keyID is empty, and returns its status, 204 when the
+// route was reached
+func signed(keys *apikey.Service, keyID, secret, timestamp, body string) int {
+	router := gin.New()
+	router.POST("/api/v1/paywall/check", keys.VerifySignature(), func(c *gin.Context) {
+		data, _ := io.ReadAll(c.Request.Body)
+		if string(data) != body {
+			c.Status(http.StatusTeapot)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/paywall/check", strings.NewReader(body))
+	if keyID != "" {
+		req.Header.Set(apikey.SignatureKeyHeader, keyID)
+		req.Header.Set(apikey.SignatureTimestampHeader, timestamp)
+		req.Header.Set(apikey.SignatureHeader, apikey.Sign(secret, timestamp, http.MethodPost, "/api/v1/paywall/check", []byte(body)))
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestVerifySignature(t *testing.T) {
+	env.Reset(t)
+	keys := env.APIKeys()
+	w := testenv.Serve(keys.CreateSigningKey, http.MethodPost, "/api/v1/admin/signing-keys", `{"name": "edge"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created apikey.CreateSigningKeyResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, created.Secret[:16], created.Prefix)
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	body := `{"user_id": "u1", "content_id": "c1"}`
+	assert.Equal(t, http.StatusNoContent, signed(keys, created.ID, created.Secret, now, body))
+	// The same signature can't be replayed
+	assert.Equal(t, http.StatusUnauthorized, signed(keys, created.ID, created.Secret, now, body))
+
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	assert.Equal(t, http.StatusUnauthorized, signed(keys, created.ID, created.Secret, stale, body))
+	assert.Equal(t, http.StatusUnauthorized, signed(keys, created.ID, "sk_sign_guessed", now, `{}`))
+	assert.Equal(t, http.StatusUnauthorized, signed(keys, "unknown", created.Secret, now, `{"a": 1}`))
+
+	// Unsigned requests pass unless signing is required
+	assert.Equal(t, http.StatusNoContent, signed(keys, "", "", "", body))
+	cfg := env.Config.Auth.RequestSigning
+	cfg.Required = true
+	required := apikey.NewService(cfg, env.DB, env.Cache, nil)
+	assert.Equal(t, http.StatusUnauthorized, signed(required, "", "", "", body))
+
+	w = testenv.Serve(keys.RevokeSigningKey, http.MethodDelete, "/api/v1/admin/signing-keys/"+created.ID, "", gin.Param{Key: "id", Value: created.ID})
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, signed(keys, created.ID, created.Secret, now, `{"after": "revoke"}`))
+	w = testenv.Serve(keys.RevokeSigningKey, http.MethodDelete, "/api/v1/admin/signing-keys/"+created.ID, "", gin.Param{Key: "id", Value: created.ID})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = testenv.Serve(keys.ListSigningKeys, http.MethodGet, "/api/v1/admin/signing-keys", "")
+	var list apikey.SigningKeyListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Keys, 1)
+	assert.NotNil(t, list.Keys[0].RevokedAt)
+	assert.NotNil(t, list.Keys[0].LastUsedAt)
+	assert.NotContains(t, w.Body.String(), created.Secret)
+}
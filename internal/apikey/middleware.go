@@ -0,0 +1,82 @@
+package apikey
+
+import (
+	"database/sql"
+	"net/http"
+
+	"scalable-paywall/internal/middleware"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Header carries the API key of server-to-server calls
+const Header = "X-API-Key"
+
+// KeyIDKey is the gin context key Authenticate sets to the ID of the
+// request's key
+const KeyIDKey = "api_key_id"
+
+// Authenticate admits requests whose API key, in the X-API-Key header, is
+// used from an address it allows and has a scope allowing the route, which
+// requires scope: billing_admin keys may do anything, read_only keys make
+// GET and HEAD requests anywhere, and other scopes only reach routes that
+// require them.
+func (s *Service) Authenticate(scope Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := c.GetHeader(Header)
+		if raw == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key required"})
+			return
+		}
+		key, err := s.lookup(c.Request.Context(), hashKey(raw))
+		if err == sql.ErrNoRows {
+			telemetry.RecordSecurityEvent("api_key_invalid")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			return
+		}
+		if err != nil {
+			logrus.Errorf("Failed to look up API key: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		// ClientIP believes forwarding headers only from server.trusted_proxies
+		if ip := c.ClientIP(); !middleware.IPAllowed(ip, key.AllowedCIDRs) {
+			telemetry.RecordSecurityEvent("api_key_ip_denied")
+			logrus.Warnf("Refused API key %s from %s outside its allowlist", key.ID, ip)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access from this address is not allowed"})
+			return
+		}
+		if !key.Allows(scope, c.Request.Method) {
+			telemetry.RecordSecurityEvent("api_key_scope_denied")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the required scope"})
+			return
+		}
+
+		c.Set(KeyIDKey, key.ID)
+		c.Next()
+	}
+}
+
+// Allows reports whether the key may make a request with method on a
+// route requiring scope
+func (k *APIKey) Allows(scope Scope, method string) bool {
+	safe := method == http.MethodGet || method == http.MethodHead
+	for _, granted := range k.Scopes {
+		switch granted {
+		case ScopeBillingAdmin:
+			return true
+		case ScopeReadOnly:
+			if safe {
+				return true
+			}
+		default:
+			if granted == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
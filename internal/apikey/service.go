@@ -0,0 +1,267 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/encryption"
+	"scalable-paywall/internal/middleware"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Scope is what an API key may do
+type Scope string
+
+const (
+	// ScopeReadOnly allows GET and HEAD requests on any route keys may use
+	ScopeReadOnly Scope = "read_only"
+	// ScopePaywallCheck allows the paywall check routes only
+	ScopePaywallCheck Scope = "paywall_check"
+	// ScopeBillingAdmin allows everything, including billing and admin
+	// changes
+	ScopeBillingAdmin Scope = "billing_admin"
+)
+
+// keyPrefix starts every key, so leaked keys are recognized (and scrubbed
+// from logs) like other secret keys
+const keyPrefix = "sk_"
+
+// lookupTTL is how long a key looked up is cached; revoking a key drops it
+// at once
+const lookupTTL = time.Minute
+
+// Service manages API keys and request signing keys and authenticates
+// requests made with them.
+type Service struct {
+	cfg     config.RequestSigningConfig
+	db      *db.Connection
+	cache   *cache.RedisClient
+	keyring *encryption.Keyring
+}
+
+// APIKey is a key for server-to-server calls. Prefix is the start of the
+// key, to tell keys apart; the key itself is only shown when created.
+// AllowedCIDRs are the addresses it may be used from, any when empty.
+type APIKey struct {
+	ID           string     `json:"id" db:"id"`
+	Name         string     `json:"name" db:"name"`
+	Prefix       string     `json:"prefix" db:"prefix"`
+	Scopes       []Scope    `json:"scopes" db:"scopes"`
+	AllowedCIDRs []string   `json:"allowed_cidrs" db:"allowed_cidrs"`
+	CreatedBy    *string    `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+type CreateKeyRequest struct {
+	Name         string   `json:"name" binding:"required,max=100"`
+	Scopes       []Scope  `json:"scopes" binding:"required,min=1,dive,oneof=read_only paywall_check billing_admin"`
+	AllowedCIDRs []string `json:"allowed_cidrs" binding:"max=100"`
+}
+
+// CreateKeyResponse holds the new key, which can't be read again
+type CreateKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type KeyListResponse struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// NewService creates the API key service. cfg configures request signing;
+// signing secrets are stored encrypted when keyring encrypts.
+func NewService(cfg config.RequestSigningConfig, db *db.Connection, cache *cache.RedisClient, keyring *encryption.Keyring) *Service {
+	return &Service{cfg: cfg, db: db, cache: cache, keyring: keyring}
+}
+
+// CreateKey issues an API key with scopes, usable from allowed_cidrs. The
+// admin is taken from X-User-ID.
+func (s *Service) CreateKey(c *gin.Context) {
+	var req CreateKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := middleware.ParseCIDRs(req.AllowedCIDRs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.AllowedCIDRs == nil {
+		req.AllowedCIDRs = []string{}
+	}
+
+	key, err := generateKey()
+	if err != nil {
+		logrus.Errorf("Failed to generate API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	created, err := s.createKey(c.Request.Context(), key, req, c.GetHeader(middleware.UserHeader))
+	if err != nil {
+		logrus.Errorf("Failed to create API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	logrus.Infof("Created API key %s (%s) with scopes %v", created.ID, created.Name, created.Scopes)
+	telemetry.RecordSecurityEvent("api_key_created")
+	c.JSON(http.StatusCreated, CreateKeyResponse{APIKey: *created, Key: key})
+}
+
+// ListKeys returns every API key, newest first, revoked ones included.
+func (s *Service) ListKeys(c *gin.Context) {
+	keys, err := s.listKeys(c.Request.Context())
+	if err != nil {
+		logrus.Errorf("Failed to list API keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, KeyListResponse{Keys: keys})
+}
+
+// RevokeKey revokes an API key at once. Revoking it again answers 404.
+func (s *Service) RevokeKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	var hash string
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE id::text = $1 AND revoked_at IS NULL
+		RETURNING key_hash
+	`, id).Scan(&hash)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to revoke API key %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if err := s.cache.Del(ctx, lookupKey(s.cache, hash)); err != nil {
+		logrus.Errorf("Failed to drop cached API key %s: %v", id, err)
+	}
+
+	logrus.Infof("Revoked API key %s", id)
+	telemetry.RecordSecurityEvent("api_key_revoked")
+	c.Status(http.StatusNoContent)
+}
+
+const keyColumns = `id, name, prefix, scopes, allowed_cidrs, created_by, created_at, last_used_at, revoked_at`
+
+func scanKey(scan func(dest ...interface{}) error) (*APIKey, error) {
+	var key APIKey
+	var scopes, cidrs []byte
+	if err := scan(&key.ID, &key.Name, &key.Prefix, &scopes, &cidrs, &key.CreatedBy,
+		&key.CreatedAt, &key.LastUsedAt, &key.RevokedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(scopes, &key.Scopes); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(cidrs, &key.AllowedCIDRs); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *Service) createKey(ctx context.Context, key string, req CreateKeyRequest, createdBy string) (*APIKey, error) {
+	scopes, err := json.Marshal(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	cidrs, err := json.Marshal(req.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return scanKey(s.db.QueryRowContext(ctx, `
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, allowed_cidrs, created_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		RETURNING `+keyColumns,
+		req.Name, key[:len(keyPrefix)+8], hashKey(key), string(scopes), string(cidrs), createdBy).Scan)
+}
+
+func (s *Service) listKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.db.Reader().QueryContext(ctx, `
+		SELECT `+keyColumns+` FROM api_keys ORDER BY created_at DESC, id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// lookup finds the active key with hash, cached for lookupTTL. It returns
+// sql.ErrNoRows for unknown and revoked keys.
+func (s *Service) lookup(ctx context.Context, hash string) (*APIKey, error) {
+	cacheKey := lookupKey(s.cache, hash)
+	if data, err := s.cache.Get(ctx, cacheKey); err == nil {
+		var key APIKey
+		if err := json.Unmarshal([]byte(data), &key); err == nil {
+			telemetry.RecordCacheLookup("api_key", true)
+			return &key, nil
+		}
+	}
+	telemetry.RecordCacheLookup("api_key", false)
+
+	// Reading the key from the database marks it used, so last_used_at is
+	// accurate to the cache time
+	key, err := scanKey(s.db.QueryRowContext(ctx, `
+		UPDATE api_keys SET last_used_at = NOW()
+		WHERE key_hash = $1 AND revoked_at IS NULL
+		RETURNING `+keyColumns, hash).Scan)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(key); err == nil {
+		if err := s.cache.Set(ctx, cacheKey, string(data), lookupTTL); err != nil {
+			logrus.Warnf("Failed to cache API key %s: %v", key.ID, err)
+		}
+	}
+	return key, nil
+}
+
+func lookupKey(cache *cache.RedisClient, hash string) string {
+	return cache.Key(fmt.Sprintf("api_key:%s", hash))
+}
+
+// generateKey returns a new key: keyPrefix and 48 hex digits
+func generateKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return keyPrefix + hex.EncodeToString(b), nil
+}
+
+// hashKey is how a key is stored and looked up
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,313 @@
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/encryption"
+	"scalable-paywall/internal/middleware"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Request signing headers: the signing key's ID, the Unix time the request
+// was signed at, and the hex HMAC-SHA256 signature
+const (
+	SignatureKeyHeader       = "X-Signature-Key-ID"
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	SignatureHeader          = "X-Signature"
+)
+
+// SigningKeyIDKey is the gin context key VerifySignature sets to the ID of
+// the key a request was signed with
+const SigningKeyIDKey = "signing_key_id"
+
+// signingSecretPrefix starts every signing secret, scrubbed from logs like
+// API keys
+const signingSecretPrefix = "sk_sign_"
+
+// SigningKey is a shared secret edge services sign requests with. Prefix is
+// the start of the secret, to tell keys apart; the secret is only shown when
+// created.
+type SigningKey struct {
+	ID         string     `json:"id" db:"id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	CreatedBy  *string    `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+type CreateSigningKeyRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// CreateSigningKeyResponse holds the new secret, which can't be read again
+type CreateSigningKeyResponse struct {
+	SigningKey
+	Secret string `json:"secret"`
+}
+
+type SigningKeyListResponse struct {
+	Keys []SigningKey `json:"keys"`
+}
+
+// CreateSigningKey issues a request signing key. The admin is taken from
+// X-User-ID.
+func (s *Service) CreateSigningKey(c *gin.Context) {
+	var req CreateSigningKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := generateSigningSecret()
+	if err != nil {
+		logrus.Errorf("Failed to generate signing key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	created, err := s.createSigningKey(c.Request.Context(), req.Name, secret, c.GetHeader(middleware.UserHeader))
+	if err != nil {
+		logrus.Errorf("Failed to create signing key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	logrus.Infof("Created signing key %s (%s)", created.ID, created.Name)
+	telemetry.RecordSecurityEvent("signing_key_created")
+	c.JSON(http.StatusCreated, CreateSigningKeyResponse{SigningKey: *created, Secret: secret})
+}
+
+// ListSigningKeys returns every signing key, newest first, revoked ones
+// included.
+func (s *Service) ListSigningKeys(c *gin.Context) {
+	keys, err := s.listSigningKeys(c.Request.Context())
+	if err != nil {
+		logrus.Errorf("Failed to list signing keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, SigningKeyListResponse{Keys: keys})
+}
+
+// RevokeSigningKey revokes a signing key at once. Revoking it again answers
+// 404.
+func (s *Service) RevokeSigningKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE signing_keys SET revoked_at = NOW()
+		WHERE id::text = $1 AND revoked_at IS NULL
+	`, id)
+	if err != nil {
+		logrus.Errorf("Failed to revoke signing key %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Signing key not found"})
+		return
+	}
+	if err := s.cache.Del(ctx, signingLookupKey(s.cache, id)); err != nil {
+		logrus.Errorf("Failed to drop cached signing key %s: %v", id, err)
+	}
+
+	logrus.Infof("Revoked signing key %s", id)
+	telemetry.RecordSecurityEvent("signing_key_revoked")
+	c.Status(http.StatusNoContent)
+}
+
+// VerifySignature admits requests signed with an active signing key, as
+// Sign computes it, within auth.request_signing.tolerance seconds of their
+// timestamp. Each signature is accepted once. Unsigned requests pass on to
+// other authentication unless auth.request_signing.required is set.
+func (s *Service) VerifySignature() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyID := c.GetHeader(SignatureKeyHeader)
+		timestamp := c.GetHeader(SignatureTimestampHeader)
+		signature := strings.ToLower(c.GetHeader(SignatureHeader))
+		if keyID == "" && timestamp == "" && signature == "" {
+			if s.cfg.Required {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Request signature required"})
+				return
+			}
+			c.Next()
+			return
+		}
+		if keyID == "" || timestamp == "" || signature == "" {
+			telemetry.RecordSecurityEvent("request_signature_invalid")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid request signature"})
+			return
+		}
+
+		tolerance := time.Duration(s.cfg.Tolerance) * time.Second
+		if !signatureFresh(timestamp, time.Now(), tolerance) {
+			telemetry.RecordSecurityEvent("request_signature_expired")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Request signature expired"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		secret, err := s.signingSecret(ctx, keyID)
+		if err == sql.ErrNoRows {
+			telemetry.RecordSecurityEvent("request_signature_invalid")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid request signature"})
+			return
+		}
+		if err != nil {
+			logrus.Errorf("Failed to look up signing key %s: %v", keyID, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		expected := Sign(secret, timestamp, c.Request.Method, c.Request.URL.RequestURI(), body)
+		if !hmac.Equal([]byte(expected), []byte(signature)) {
+			telemetry.RecordSecurityEvent("request_signature_invalid")
+			logrus.Warnf("Refused request with a bad signature for signing key %s", keyID)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid request signature"})
+			return
+		}
+
+		// A signature is remembered while its timestamp could still pass, so
+		// a captured request can't be replayed. Without Redis the window is
+		// all that limits replays.
+		fresh, err := s.cache.SetNX(ctx, s.cache.Key("signature:"+signature), keyID, 2*tolerance)
+		if err != nil {
+			logrus.Warnf("Failed to record request signature, skipping replay check: %v", err)
+		} else if !fresh {
+			telemetry.RecordSecurityEvent("request_signature_replayed")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Request signature already used"})
+			return
+		}
+
+		c.Set(SigningKeyIDKey, keyID)
+		c.Next()
+	}
+}
+
+// Sign returns the hex HMAC-SHA256, keyed with secret, of timestamp,
+// method, the request URI (path and query) and body, joined by newlines.
+// Edge services send it in X-Signature.
+func Sign(secret, timestamp, method, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + strings.ToUpper(method) + "\n" + requestURI + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signatureFresh reports whether timestamp, in Unix seconds, is within
+// tolerance of now either way
+func signatureFresh(timestamp string, now time.Time, tolerance time.Duration) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := now.Sub(time.Unix(unix, 0))
+	return skew <= tolerance && skew >= -tolerance
+}
+
+const signingKeyColumns = `id, name, prefix, created_by, created_at, last_used_at, revoked_at`
+
+func scanSigningKey(scan func(dest ...interface{}) error) (*SigningKey, error) {
+	var key SigningKey
+	if err := scan(&key.ID, &key.Name, &key.Prefix, &key.CreatedBy, &key.CreatedAt,
+		&key.LastUsedAt, &key.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (s *Service) createSigningKey(ctx context.Context, name, secret, createdBy string) (*SigningKey, error) {
+	sealed, err := s.keyring.Encrypt(secret, encryption.SigningKeySecret)
+	if err != nil {
+		return nil, err
+	}
+	return scanSigningKey(s.db.QueryRowContext(ctx, `
+		INSERT INTO signing_keys (name, prefix, secret, created_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+		RETURNING `+signingKeyColumns,
+		name, secret[:len(signingSecretPrefix)+8], sealed, createdBy).Scan)
+}
+
+func (s *Service) listSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	rows, err := s.db.Reader().QueryContext(ctx, `
+		SELECT `+signingKeyColumns+` FROM signing_keys ORDER BY created_at DESC, id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		key, err := scanSigningKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// signingSecret returns the secret of the active signing key id, cached for
+// lookupTTL as stored, so sealed when encryption is on. It returns
+// sql.ErrNoRows for unknown and revoked keys.
+func (s *Service) signingSecret(ctx context.Context, id string) (string, error) {
+	cacheKey := signingLookupKey(s.cache, id)
+	sealed, err := s.cache.Get(ctx, cacheKey)
+	telemetry.RecordCacheLookup("signing_key", err == nil)
+	if err != nil {
+		// Reading the secret from the database marks the key used, so
+		// last_used_at is accurate to the cache time
+		err = s.db.QueryRowContext(ctx, `
+			UPDATE signing_keys SET last_used_at = NOW()
+			WHERE id::text = $1 AND revoked_at IS NULL
+			RETURNING secret
+		`, id).Scan(&sealed)
+		if err != nil {
+			return "", err
+		}
+		if err := s.cache.Set(ctx, cacheKey, sealed, lookupTTL); err != nil {
+			logrus.Warnf("Failed to cache signing key %s: %v", id, err)
+		}
+	}
+	return s.keyring.Decrypt(sealed, encryption.SigningKeySecret)
+}
+
+func signingLookupKey(cache *cache.RedisClient, id string) string {
+	return cache.Key(fmt.Sprintf("signing_key:%s", id))
+}
+
+// generateSigningSecret returns a new secret: signingSecretPrefix and 64
+// hex digits
+func generateSigningSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return signingSecretPrefix + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,53 @@
+package apikey
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"user_id":"u1","content_id":"c1"}`)
+	mac := hmac.New(sha256.New, []byte("sk_sign_secret"))
+	mac.Write([]byte("1700000000\nPOST\n/api/v1/paywall/check?debug=1\n" + string(body)))
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	assert.Equal(t, want, Sign("sk_sign_secret", "1700000000", http.MethodPost, "/api/v1/paywall/check?debug=1", body))
+	assert.Equal(t, want, Sign("sk_sign_secret", "1700000000", "post", "/api/v1/paywall/check?debug=1", body))
+
+	// Every part is signed
+	assert.NotEqual(t, want, Sign("sk_sign_other", "1700000000", http.MethodPost, "/api/v1/paywall/check?debug=1", body))
+	assert.NotEqual(t, want, Sign("sk_sign_secret", "1700000001", http.MethodPost, "/api/v1/paywall/check?debug=1", body))
+	assert.NotEqual(t, want, Sign("sk_sign_secret", "1700000000", http.MethodPut, "/api/v1/paywall/check?debug=1", body))
+	assert.NotEqual(t, want, Sign("sk_sign_secret", "1700000000", http.MethodPost, "/api/v1/paywall/check", body))
+	assert.NotEqual(t, want, Sign("sk_sign_secret", "1700000000", http.MethodPost, "/api/v1/paywall/check?debug=1", body[1:]))
+}
+
+func TestSignatureFresh(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	assert.True(t, signatureFresh("1700000000", now, 5*time.Minute))
+	assert.True(t, signatureFresh("1699999700", now, 5*time.Minute))
+	assert.True(t, signatureFresh("1700000300", now, 5*time.Minute))
+	assert.False(t, signatureFresh("1699999699", now, 5*time.Minute))
+	assert.False(t, signatureFresh("1700000301", now, 5*time.Minute))
+	assert.False(t, signatureFresh("yesterday", now, 5*time.Minute))
+	assert.False(t, signatureFresh("", now, 5*time.Minute))
+}
+
+func TestGenerateSigningSecret(t *testing.T) {
+	secret, err := generateSigningSecret()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, signingSecretPrefix))
+	assert.Len(t, secret, len(signingSecretPrefix)+64)
+
+	other, err := generateSigningSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+}
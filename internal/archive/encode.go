@@ -0,0 +1,31 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"time"
+)
+
+// encodeRows writes rows as gzipped newline-delimited JSON
+func encodeRows(lines [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for _, line := range lines {
+		if _, err := zw.Write(line); err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write([]byte{'\n'}); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// batchName names a file of rows after its first row's time and ID, so
+// files sort by age and writing the same batch again replaces it
+func batchName(first time.Time, firstID string) string {
+	return first.UTC().Format("20060102T150405.000000Z") + "-" + firstID
+}
@@ -0,0 +1,174 @@
+package archive
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/jobs"
+	"scalable-paywall/internal/objectstore"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JobExport copies new events of the export streams to the object store.
+const JobExport = "export.events"
+
+// exportStart is the cursor of a stream not exported yet
+var exportStart = cursor{at: time.Unix(0, 0).UTC(), id: "00000000-0000-0000-0000-000000000000"}
+
+// cursor is the last event of a stream that was exported
+type cursor struct {
+	at time.Time
+	id string
+}
+
+// Exporter writes the events of each stream to the object store in
+// created_at order, picking up after the last event it wrote, so the data
+// team loads them into the warehouse instead of querying production.
+// Events are read from a replica when one is configured.
+type Exporter struct {
+	conn  *db.Connection
+	store objectstore.Store
+	cfg   config.ExportConfig
+}
+
+func NewExporter(conn *db.Connection, store objectstore.Store, cfg config.ExportConfig) *Exporter {
+	return &Exporter{conn: conn, store: store, cfg: cfg}
+}
+
+// RegisterJobs schedules the export job when any stream is configured
+func (e *Exporter) RegisterJobs(runner *jobs.Runner) {
+	if len(e.cfg.Streams) == 0 || e.store == nil {
+		return
+	}
+	runner.Every(JobExport, time.Duration(e.cfg.Interval)*time.Second, func(ctx context.Context, job *jobs.Job) error {
+		return e.Run(ctx)
+	})
+}
+
+// Run exports what each stream gained since the last run. A failing stream
+// doesn't stop the others; the first error is returned after all have run.
+func (e *Exporter) Run(ctx context.Context) error {
+	var firstErr error
+	until := time.Now().Add(-time.Duration(e.cfg.Lag) * time.Second)
+	for _, stream := range e.cfg.Streams {
+		n, err := e.export(ctx, stream, until)
+		if n > 0 {
+			logrus.Infof("Exported %d %s", n, stream)
+		}
+		if err != nil {
+			logrus.Errorf("Export of %s failed: %v", stream, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// export writes the stream's events created before until, one file per
+// batch, advancing the cursor after each file. A run stops early when
+// another moved the cursor first.
+func (e *Exporter) export(ctx context.Context, stream string, until time.Time) (int, error) {
+	if _, ok := tables[stream]; !ok {
+		return 0, fmt.Errorf("no export for table %q", stream)
+	}
+	from, err := e.cursor(ctx, stream)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for i := 0; i < maxBatchesPerRun && ctx.Err() == nil; i++ {
+		lines, first, last, err := e.read(ctx, stream, from, until)
+		if err != nil || len(lines) == 0 {
+			return total, err
+		}
+		body, err := encodeRows(lines)
+		if err != nil {
+			return total, err
+		}
+		if err := e.store.Put(ctx, exportKey(stream, first), body, "application/gzip"); err != nil {
+			return total, fmt.Errorf("failed to export %d %s: %w", len(lines), stream, err)
+		}
+		advanced, err := e.advance(ctx, stream, from, last)
+		if err != nil || !advanced {
+			return total, err
+		}
+		total += len(lines)
+		from = last
+		if len(lines) < e.cfg.BatchSize {
+			return total, nil
+		}
+	}
+	return total, ctx.Err()
+}
+
+// read fetches the batch of events after from and before until, and the
+// first and last of them
+func (e *Exporter) read(ctx context.Context, stream string, from cursor, until time.Time) ([][]byte, cursor, cursor, error) {
+	rows, err := e.conn.Reader().QueryContext(ctx, `
+		SELECT id::text, created_at, row_to_json(e)::text FROM `+stream+` e
+		WHERE created_at < $1 AND (created_at, id) > ($2, $3::uuid)
+		ORDER BY created_at, id
+		LIMIT $4
+	`, until, from.at, from.id, e.cfg.BatchSize)
+	if err != nil {
+		return nil, cursor{}, cursor{}, err
+	}
+	defer rows.Close()
+
+	var lines [][]byte
+	var first, last cursor
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&last.id, &last.at, &line); err != nil {
+			return nil, cursor{}, cursor{}, err
+		}
+		if len(lines) == 0 {
+			first = last
+		}
+		lines = append(lines, []byte(line))
+	}
+	return lines, first, last, rows.Err()
+}
+
+func (e *Exporter) cursor(ctx context.Context, stream string) (cursor, error) {
+	var c cursor
+	err := e.conn.QueryRowContext(ctx,
+		`SELECT last_created_at, last_id::text FROM export_cursors WHERE stream = $1`, stream).Scan(&c.at, &c.id)
+	if err == sql.ErrNoRows {
+		return exportStart, nil
+	}
+	return c, err
+}
+
+// advance moves the stream's cursor from one position to the next, unless
+// it is no longer at from because another run got there first
+func (e *Exporter) advance(ctx context.Context, stream string, from, to cursor) (bool, error) {
+	result, err := e.conn.ExecContext(ctx, `
+		INSERT INTO export_cursors (stream, last_created_at, last_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (stream) DO UPDATE
+		SET last_created_at = EXCLUDED.last_created_at, last_id = EXCLUDED.last_id, updated_at = NOW()
+		WHERE export_cursors.last_created_at = $4 AND export_cursors.last_id = $5::uuid
+	`, stream, to.at, to.id, from.at, from.id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if n == 0 && err == nil {
+		logrus.Warnf("Export cursor of %s moved during the run; stopping", stream)
+	}
+	return n > 0, err
+}
+
+// exportKey partitions files by the day of their first event, the layout
+// warehouse loaders expect
+func exportKey(stream string, first cursor) string {
+	return fmt.Sprintf("exports/%s/dt=%s/%s.ndjson.gz", stream, first.at.UTC().Format("2006-01-02"), batchName(first.at, first.id))
+}
@@ -0,0 +1,26 @@
+package archive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportKey(t *testing.T) {
+	first := cursor{at: time.Date(2026, 3, 4, 23, 30, 0, 0, time.FixedZone("EST", -5*3600)), id: "0b6c9e4e-1d7a-4b7e-9a51-7e8e0c2f1a3d"}
+
+	assert.Equal(t,
+		"exports/paywall_events/dt=2026-03-05/20260305T043000.000000Z-0b6c9e4e-1d7a-4b7e-9a51-7e8e0c2f1a3d.ndjson.gz",
+		exportKey("paywall_events", first))
+}
+
+func TestExportRejectsUnknownStreams(t *testing.T) {
+	e := NewExporter(nil, nil, config.ExportConfig{BatchSize: 10})
+
+	_, err := e.export(context.Background(), "users", time.Now())
+	assert.EqualError(t, err, `no export for table "users"`)
+}
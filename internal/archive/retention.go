@@ -0,0 +1,178 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/jobs"
+	"scalable-paywall/internal/objectstore"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JobRetention applies the retention policies, archiving or purging rows
+// that have aged out of their table.
+const JobRetention = "retention.apply"
+
+// maxBatchesPerRun bounds one run of a policy so a large backlog is worked
+// off over several runs instead of holding a worker for hours
+const maxBatchesPerRun = 200
+
+// table is how retention finds a table's old rows: by the time column,
+// among the rows where also holds. Only rows nothing acts on any more
+// qualify: processed webhooks and checkout sessions left open.
+type table struct {
+	column string
+	where  string
+}
+
+var tables = map[string]table{
+	"webhook_events":      {column: "created_at", where: "processed"},
+	"usage_logs":          {column: "created_at"},
+	"subscription_events": {column: "created_at"},
+	"paywall_events":      {column: "created_at"},
+	"checkout_sessions":   {column: "expires_at", where: "status = 'open'"},
+}
+
+// Retention keeps hot tables small by moving rows past their policy's age
+// to the object store, or deleting them outright.
+type Retention struct {
+	conn  *db.Connection
+	store objectstore.Store
+	cfg   config.RetentionConfig
+}
+
+func NewRetention(conn *db.Connection, store objectstore.Store, cfg config.RetentionConfig) *Retention {
+	return &Retention{conn: conn, store: store, cfg: cfg}
+}
+
+// RegisterJobs schedules the retention job when any policy is configured
+func (r *Retention) RegisterJobs(runner *jobs.Runner) {
+	if len(r.cfg.Policies) == 0 {
+		return
+	}
+	runner.Every(JobRetention, time.Duration(r.cfg.Interval)*time.Second, func(ctx context.Context, job *jobs.Job) error {
+		return r.Run(ctx)
+	})
+}
+
+// Run applies every policy once. A failing policy doesn't stop the others;
+// the first error is returned after all have run.
+func (r *Retention) Run(ctx context.Context) error {
+	var firstErr error
+	for _, policy := range r.cfg.Policies {
+		n, err := r.apply(ctx, policy, time.Now())
+		if n > 0 {
+			logrus.Infof("Retention %s of %s: %d rows older than %d days", policy.Action, policy.Table, n, policy.Days)
+		}
+		if err != nil {
+			logrus.Errorf("Retention of %s failed: %v", policy.Table, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (r *Retention) apply(ctx context.Context, policy config.RetentionPolicyConfig, now time.Time) (int, error) {
+	t, ok := tables[policy.Table]
+	if !ok {
+		return 0, fmt.Errorf("no retention for table %q", policy.Table)
+	}
+	if policy.Action == "archive" && r.store == nil {
+		return 0, fmt.Errorf("archiving %s needs an object store", policy.Table)
+	}
+	cutoff := now.AddDate(0, 0, -policy.Days)
+
+	total := 0
+	for i := 0; i < maxBatchesPerRun && ctx.Err() == nil; i++ {
+		n, err := r.batch(ctx, policy, t, cutoff)
+		total += n
+		if err != nil || n < r.cfg.BatchSize {
+			return total, err
+		}
+	}
+	return total, ctx.Err()
+}
+
+// batch removes the oldest batch of rows before cutoff in one transaction,
+// writing them to the object store first when archiving. Rows are locked
+// while archived, so a run elsewhere skips them, and are deleted only once
+// the object is written; a failed delete archives them again next run,
+// under the same key.
+func (r *Retention) batch(ctx context.Context, policy config.RetentionPolicyConfig, t table, cutoff time.Time) (int, error) {
+	where := t.column + " < $1"
+	if t.where != "" {
+		where += " AND " + t.where
+	}
+
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id::text, `+t.column+`, row_to_json(r)::text FROM `+policy.Table+` r
+		WHERE `+where+`
+		ORDER BY `+t.column+`, id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, cutoff, r.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	var lines [][]byte
+	var first time.Time
+	for rows.Next() {
+		var id, line string
+		var at time.Time
+		if err := rows.Scan(&id, &at, &line); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if len(ids) == 0 {
+			first = at
+		}
+		ids = append(ids, id)
+		lines = append(lines, []byte(line))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if policy.Action == "archive" {
+		body, err := encodeRows(lines)
+		if err != nil {
+			return 0, err
+		}
+		if err := r.store.Put(ctx, archiveKey(policy.Table, first, ids[0]), body, "application/gzip"); err != nil {
+			return 0, fmt.Errorf("failed to archive %d rows of %s: %w", len(ids), policy.Table, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+policy.Table+` WHERE id = ANY($1::uuid[])`, ids); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// archiveKey names a batch by its table, the day and time of its oldest
+// row and that row's ID, so batches sort by age and retrying a batch
+// overwrites its object rather than adding a copy
+func archiveKey(table string, first time.Time, firstID string) string {
+	first = first.UTC()
+	return fmt.Sprintf("retention/%s/%s/%s.ndjson.gz", table, first.Format("2006/01/02"), batchName(first, firstID))
+}
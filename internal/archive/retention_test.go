@@ -0,0 +1,44 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveKey(t *testing.T) {
+	first := time.Date(2026, 3, 4, 5, 6, 7, 890000000, time.FixedZone("CET", 3600))
+
+	assert.Equal(t,
+		"retention/webhook_events/2026/03/04/20260304T040607.890000Z-0b6c9e4e-1d7a-4b7e-9a51-7e8e0c2f1a3d.ndjson.gz",
+		archiveKey("webhook_events", first, "0b6c9e4e-1d7a-4b7e-9a51-7e8e0c2f1a3d"))
+}
+
+func TestEncodeRows(t *testing.T) {
+	body, err := encodeRows([][]byte{[]byte(`{"id":"a"}`), []byte(`{"id":"b"}`)})
+	require.NoError(t, err)
+
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"id\":\"a\"}\n{\"id\":\"b\"}\n", string(data))
+}
+
+func TestApplyRejectsUnusablePolicies(t *testing.T) {
+	r := NewRetention(nil, nil, config.RetentionConfig{BatchSize: 10})
+
+	_, err := r.apply(context.Background(), config.RetentionPolicyConfig{Table: "users", Days: 1, Action: "purge"}, time.Now())
+	assert.EqualError(t, err, `no retention for table "users"`)
+
+	_, err = r.apply(context.Background(), config.RetentionPolicyConfig{Table: "usage_logs", Days: 1, Action: "archive"}, time.Now())
+	assert.EqualError(t, err, "archiving usage_logs needs an object store")
+}
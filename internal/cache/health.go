@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrUnavailable is returned, without reaching Redis, by commands run while
+// Redis is degraded
+var ErrUnavailable = errors.New("redis unavailable")
+
+// breaker tracks whether Redis is reachable. threshold commands in a row
+// failing to reach it, or a failed probe, mark it degraded; only a probe
+// that gets through restores it.
+type breaker struct {
+	threshold int32
+	failures  atomic.Int32
+	degraded  atomic.Bool
+}
+
+// observe counts a command's outcome towards the threshold
+func (b *breaker) observe(err error) {
+	if !unreachable(err) {
+		b.failures.Store(0)
+		return
+	}
+	if b.failures.Add(1) >= b.threshold {
+		b.trip()
+	}
+}
+
+func (b *breaker) trip() {
+	if b.degraded.CompareAndSwap(false, true) {
+		logrus.Warn("Redis is unreachable; running degraded until it answers a ping")
+		telemetry.SetRedisDegraded(true)
+	}
+}
+
+func (b *breaker) reset() {
+	b.failures.Store(0)
+	if b.degraded.CompareAndSwap(true, false) {
+		logrus.Info("Redis is reachable again")
+		telemetry.SetRedisDegraded(false)
+	}
+}
+
+// unreachable reports whether err means Redis couldn't be reached, rather
+// than a miss, an error reply or a caller giving up
+func unreachable(err error) bool {
+	if err == nil || err == redis.Nil || errors.Is(err, ErrUnavailable) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var reply redis.Error
+	return !errors.As(err, &reply)
+}
+
+// breakerHook fails commands at once while Redis is degraded, except the
+// probe's pings, and feeds the outcome of the others to the breaker
+type breakerHook struct {
+	breaker *breaker
+}
+
+func (h breakerHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if h.breaker.degraded.Load() && cmd.Name() != "ping" {
+		return ctx, ErrUnavailable
+	}
+	return ctx, nil
+}
+
+func (h breakerHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.breaker.observe(cmd.Err())
+	return nil
+}
+
+func (h breakerHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	if h.breaker.degraded.Load() {
+		return ctx, ErrUnavailable
+	}
+	return ctx, nil
+}
+
+func (h breakerHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); unreachable(cmdErr) {
+			err = cmdErr
+			break
+		}
+	}
+	h.breaker.observe(err)
+	return nil
+}
+
+// Degraded reports whether Redis is marked unreachable. Commands fail with
+// ErrUnavailable meanwhile, so callers can skip caching and fall back to the
+// database without waiting on timeouts.
+func (r *RedisClient) Degraded() bool {
+	return r.breaker.degraded.Load()
+}
+
+// probe pings Redis every interval until Close, marking it degraded when a
+// ping fails and restoring it when one succeeds
+func (r *RedisClient) probe(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := r.client.Ping(ctx).Err(); err != nil {
+				if !r.Degraded() {
+					logrus.Warnf("Redis ping failed: %v", err)
+				}
+				r.breaker.trip()
+			} else {
+				r.breaker.reset()
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnreachable(t *testing.T) {
+	assert.False(t, unreachable(nil))
+	assert.False(t, unreachable(redis.Nil))
+	assert.False(t, unreachable(ErrUnavailable))
+	assert.False(t, unreachable(context.Canceled))
+	assert.False(t, unreachable(redis.TxFailedErr))
+
+	assert.True(t, unreachable(errors.New("dial tcp 127.0.0.1:6379: connect: connection refused")))
+	assert.True(t, unreachable(fmt.Errorf("read: %w", context.DeadlineExceeded)))
+}
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+	b := &breaker{threshold: 3}
+	h := breakerHook{breaker: b}
+	refused := errors.New("connection refused")
+
+	b.observe(refused)
+	b.observe(refused)
+	b.observe(redis.Nil)
+	b.observe(refused)
+	b.observe(refused)
+	assert.False(t, b.degraded.Load(), "a success in between starts the count again")
+
+	b.observe(refused)
+	assert.True(t, b.degraded.Load())
+
+	_, err := h.BeforeProcess(ctx, redis.NewStringCmd(ctx, "get", "plan:basic"))
+	assert.ErrorIs(t, err, ErrUnavailable)
+	_, err = h.BeforeProcessPipeline(ctx, []redis.Cmder{redis.NewStatusCmd(ctx, "set", "plan:basic", "x")})
+	assert.ErrorIs(t, err, ErrUnavailable)
+	_, err = h.BeforeProcess(ctx, redis.NewStatusCmd(ctx, "ping"))
+	assert.NoError(t, err, "the probe's pings go through")
+
+	b.reset()
+	assert.False(t, b.degraded.Load())
+	_, err = h.BeforeProcess(ctx, redis.NewStringCmd(ctx, "get", "plan:basic"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// keyspace versions the keys of cached values as
+// <namespace>:v<schema>.<generation>:[tenant:<tenant>:]<key>. The schema
+// version comes from config and is raised by deploys that change the shape
+// of cached structs, so old and new instances never read each other's JSON;
+// the generation is a Redis counter that BumpNamespace raises to drop every
+// cached value at once. Counters, sessions and other state that must survive
+// a bump use plain keys.
+type keyspace struct {
+	namespace  string
+	schema     int
+	generation atomic.Int64
+}
+
+func (k *keyspace) key(tenant, key string) string {
+	if tenant != "" {
+		return fmt.Sprintf("%s:v%d.%d:tenant:%s:%s", k.namespace, k.schema, k.generation.Load(), tenant, key)
+	}
+	return fmt.Sprintf("%s:v%d.%d:%s", k.namespace, k.schema, k.generation.Load(), key)
+}
+
+// advance moves to generation unless a later one is already in use, so a
+// refresh that raced a bump can't step back
+func (k *keyspace) advance(generation int64) {
+	for {
+		current := k.generation.Load()
+		if generation <= current || k.generation.CompareAndSwap(current, generation) {
+			return
+		}
+	}
+}
+
+// generationKey holds the namespace's generation; it is shared by every
+// schema version
+func (k *keyspace) generationKey() string {
+	return k.namespace + ":generation"
+}
+
+// strip removes the version and tenant segments from a versioned key, so
+// metrics see the key family it was built from
+func (k *keyspace) strip(key string) string {
+	rest, ok := strings.CutPrefix(key, k.namespace+":v")
+	if !ok {
+		return key
+	}
+	_, rest, ok = strings.Cut(rest, ":")
+	if !ok {
+		return key
+	}
+	if tenanted, ok := strings.CutPrefix(rest, "tenant:"); ok {
+		if _, unscoped, ok := strings.Cut(tenanted, ":"); ok {
+			return unscoped
+		}
+	}
+	return rest
+}
+
+// Key returns the versioned key of a cached value
+func (r *RedisClient) Key(key string) string {
+	return r.keys.key("", key)
+}
+
+// TenantKey returns the versioned key of a cached value scoped to tenant; an
+// empty tenant is the same as Key
+func (r *RedisClient) TenantKey(tenant, key string) string {
+	return r.keys.key(tenant, key)
+}
+
+// BumpNamespace raises the namespace generation, orphaning every versioned
+// key. This instance switches at once and warms the new generation in the
+// background; others pick it up on their next refresh, finding it warm.
+// Orphaned values expire with their TTLs.
+func (r *RedisClient) BumpNamespace(ctx context.Context) (int64, error) {
+	generation, err := r.client.Incr(ctx, r.keys.generationKey()).Result()
+	if err != nil {
+		return 0, err
+	}
+	r.keys.advance(generation)
+	// Warm logs how each warmer did
+	go r.Warm(context.Background())
+	return generation, nil
+}
+
+// InvalidateNamespace is the admin endpoint for BumpNamespace
+func (r *RedisClient) InvalidateNamespace(c *gin.Context) {
+	generation, err := r.BumpNamespace(c.Request.Context())
+	if err != nil {
+		logrus.Errorf("Failed to bump cache namespace: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invalidate cache"})
+		return
+	}
+
+	logrus.Infof("Cache namespace %s bumped to generation %d", r.keys.namespace, generation)
+	c.JSON(http.StatusOK, gin.H{
+		"namespace":  r.keys.namespace,
+		"schema":     r.keys.schema,
+		"generation": generation,
+	})
+}
+
+// loadGeneration reads the namespace generation; a namespace that was never
+// bumped is at generation 0
+func (r *RedisClient) loadGeneration(ctx context.Context) error {
+	data, err := r.client.Get(ctx, r.keys.generationKey()).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	generation, err := strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed cache generation %q: %w", data, err)
+	}
+	r.keys.advance(generation)
+	return nil
+}
+
+// refreshGeneration follows bumps made by other instances until Close
+func (r *RedisClient) refreshGeneration(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := r.loadGeneration(ctx); err != nil {
+				logrus.Warnf("Failed to refresh cache generation: %v", err)
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyspaceKey(t *testing.T) {
+	k := &keyspace{namespace: "sp", schema: 2}
+	assert.Equal(t, "sp:v2.0:plan:basic", k.key("", "plan:basic"))
+
+	k.advance(3)
+	assert.Equal(t, "sp:v2.3:plan:basic", k.key("", "plan:basic"))
+	assert.Equal(t, "sp:v2.3:tenant:acme:plan:basic", k.key("acme", "plan:basic"))
+}
+
+func TestKeyspaceAdvanceNeverStepsBack(t *testing.T) {
+	k := &keyspace{namespace: "sp", schema: 1}
+	k.advance(5)
+	k.advance(4)
+	assert.Equal(t, int64(5), k.generation.Load())
+}
+
+func TestKeyspaceStrip(t *testing.T) {
+	k := &keyspace{namespace: "sp", schema: 1}
+
+	assert.Equal(t, "plan:basic", k.strip("sp:v1.7:plan:basic"))
+	assert.Equal(t, "plan:basic", k.strip("sp:v1.7:tenant:acme:plan:basic"))
+	assert.Equal(t, "session:abc", k.strip("session:abc"))
+	assert.Equal(t, "sp:generation", k.strip("sp:generation"))
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// keyPrefixes maps the leading segment of a key to the prefix label it is
+// reported under; keys outside these families are reported as other, which
+// keeps the label set bounded
+var keyPrefixes = map[string]string{
+	"plan":             "plan",
+	"plans":            "plan",
+	"subscription":     "subscription",
+	"session":          "session",
+	"sessions_revoked": "session",
+	"paywall":          "paywall",
+}
+
+// readCommands report hit or miss instead of ok
+var readCommands = map[string]bool{
+	"get":    true,
+	"exists": true,
+}
+
+type startKey struct{}
+
+// metricsHook times every Redis command and pipeline, counts hits and misses
+// per key prefix and logs commands slower than slow
+type metricsHook struct {
+	slow time.Duration
+	keys *keyspace
+}
+
+func (metricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (h metricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.observe(ctx, cmd.Name(), h.keyPrefix(cmd), commandResult(cmd))
+	return nil
+}
+
+func (metricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (h metricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	prefix, result := "other", "ok"
+	if len(cmds) > 0 {
+		prefix = h.keyPrefix(cmds[0])
+	}
+	for _, cmd := range cmds {
+		if commandResult(cmd) == "error" {
+			result = "error"
+			break
+		}
+	}
+	h.observe(ctx, "pipeline", prefix, result)
+	return nil
+}
+
+func (h metricsHook) observe(ctx context.Context, command, prefix, result string) {
+	start, ok := ctx.Value(startKey{}).(time.Time)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start)
+	telemetry.RecordRedisCommand(command, prefix, result, elapsed)
+
+	// Log the prefix, never the key: keys carry user and session IDs
+	if h.slow > 0 && elapsed >= h.slow {
+		logrus.WithFields(logrus.Fields{
+			"command":     command,
+			"prefix":      prefix,
+			"duration_ms": elapsed.Milliseconds(),
+		}).Warn("Slow Redis command")
+	}
+}
+
+// keyPrefix returns the prefix label of the first key cmd touches
+func (h metricsHook) keyPrefix(cmd redis.Cmder) string {
+	args := cmd.Args()
+	pos := 1
+	switch cmd.Name() {
+	case "eval", "evalsha":
+		// EVAL script numkeys key [key ...]
+		pos = 3
+	}
+	if len(args) <= pos {
+		return "other"
+	}
+	key := h.keys.strip(fmt.Sprint(args[pos]))
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		key = key[:i]
+	}
+	if prefix, ok := keyPrefixes[key]; ok {
+		return prefix
+	}
+	return "other"
+}
+
+func commandResult(cmd redis.Cmder) string {
+	err := cmd.Err()
+	if err != nil && err != redis.Nil {
+		return "error"
+	}
+	if !readCommands[cmd.Name()] {
+		return "ok"
+	}
+	if err == redis.Nil {
+		return "miss"
+	}
+	if c, ok := cmd.(*redis.IntCmd); ok && c.Val() == 0 {
+		return "miss"
+	}
+	return "hit"
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyPrefix(t *testing.T) {
+	ctx := context.Background()
+	h := metricsHook{keys: &keyspace{namespace: "sp", schema: 1}}
+
+	assert.Equal(t, "plan", h.keyPrefix(redis.NewStringCmd(ctx, "get", "plan:basic")))
+	assert.Equal(t, "plan", h.keyPrefix(redis.NewStringCmd(ctx, "get", "plans:active")))
+	assert.Equal(t, "session", h.keyPrefix(redis.NewIntCmd(ctx, "exists", "sessions_revoked:u1")))
+	assert.Equal(t, "paywall", h.keyPrefix(redis.NewCmd(ctx, "evalsha", "abc123", 1, "paywall:access:u1:c1:p1")))
+	assert.Equal(t, "other", h.keyPrefix(redis.NewStringCmd(ctx, "get", "user:u1")))
+	assert.Equal(t, "other", h.keyPrefix(redis.NewStatusCmd(ctx, "ping")))
+	assert.Equal(t, "subscription", h.keyPrefix(redis.NewStringCmd(ctx, "get", "sp:v1.4:subscription:s1")))
+	assert.Equal(t, "plan", h.keyPrefix(redis.NewStringCmd(ctx, "get", "sp:v1.4:tenant:acme:plan:basic")))
+}
+
+func TestCommandResult(t *testing.T) {
+	ctx := context.Background()
+
+	miss := redis.NewStringCmd(ctx, "get", "plan:basic")
+	miss.SetErr(redis.Nil)
+	assert.Equal(t, "miss", commandResult(miss))
+
+	assert.Equal(t, "hit", commandResult(redis.NewStringCmd(ctx, "get", "plan:basic")))
+
+	absent := redis.NewIntCmd(ctx, "exists", "session:s1")
+	assert.Equal(t, "miss", commandResult(absent))
+	absent.SetVal(1)
+	assert.Equal(t, "hit", commandResult(absent))
+
+	failed := redis.NewStatusCmd(ctx, "set", "subscription:s1", "x")
+	failed.SetErr(errors.New("connection refused"))
+	assert.Equal(t, "error", commandResult(failed))
+
+	assert.Equal(t, "ok", commandResult(redis.NewStatusCmd(ctx, "set", "subscription:s1", "x")))
+}
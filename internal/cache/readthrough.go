@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Serializer encodes the values a ReadThrough caches
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonSerializer) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobSerializer struct{}
+
+func (gobSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobSerializer) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var (
+	// JSON is the default serializer, readable with redis-cli
+	JSON Serializer = jsonSerializer{}
+	// Gob is more compact and faster for structs of concrete types; values
+	// held in interface fields must be registered with gob.Register
+	Gob Serializer = gobSerializer{}
+)
+
+// notFound is cached for a lookup that found nothing; no serializer encodes
+// a value as empty
+const notFound = ""
+
+// ReadThroughOptions configures a ReadThrough. Values are cached under
+// <Prefix>:<id> in the versioned keyspace for TTL, and lookups counted in
+// cache_lookups_total under Domain. Loads failing with NotFound (default
+// sql.ErrNoRows) are cached for NegativeTTL, zero not caching them. Cached
+// values Valid rejects are loaded again.
+type ReadThroughOptions[T any] struct {
+	Domain      string
+	Prefix      string
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	NotFound    error
+	Serializer  Serializer
+	Valid       func(T) bool
+}
+
+// ReadThrough caches the values of one type, loading them on a miss.
+// Writers keep it current with Set or Forget. While Redis is degraded it
+// loads every value and caches nothing.
+type ReadThrough[T any] struct {
+	cache *RedisClient
+	opts  ReadThroughOptions[T]
+}
+
+// NewReadThrough creates a read-through cache of T on cache. The serializer
+// defaults to JSON and NotFound to sql.ErrNoRows.
+func NewReadThrough[T any](cache *RedisClient, opts ReadThroughOptions[T]) *ReadThrough[T] {
+	if opts.Serializer == nil {
+		opts.Serializer = JSON
+	}
+	if opts.NotFound == nil {
+		opts.NotFound = sql.ErrNoRows
+	}
+	return &ReadThrough[T]{cache: cache, opts: opts}
+}
+
+// Key returns the cache key of id
+func (r *ReadThrough[T]) Key(id string) string {
+	return r.cache.Key(fmt.Sprintf("%s:%s", r.opts.Prefix, id))
+}
+
+// Get returns the value of id from the cache, else from load, caching what
+// load finds and, with a NegativeTTL, that it found nothing. hit reports
+// whether the answer, value or NotFound, came from the cache.
+func (r *ReadThrough[T]) Get(ctx context.Context, id string, load func(context.Context) (T, error)) (value T, hit bool, err error) {
+	if r.cache.Degraded() {
+		value, err = load(ctx)
+		return value, false, err
+	}
+
+	key := r.Key(id)
+	data, err := r.cache.Get(ctx, key)
+	if err == nil {
+		if data == notFound {
+			telemetry.RecordCacheLookup(r.opts.Domain, true)
+			return value, true, r.opts.NotFound
+		}
+		var cached T
+		if err := r.opts.Serializer.Unmarshal([]byte(data), &cached); err == nil && (r.opts.Valid == nil || r.opts.Valid(cached)) {
+			telemetry.RecordCacheLookup(r.opts.Domain, true)
+			return cached, true, nil
+		}
+	}
+	telemetry.RecordCacheLookup(r.opts.Domain, false)
+
+	value, err = load(ctx)
+	if errors.Is(err, r.opts.NotFound) {
+		if r.opts.NegativeTTL > 0 {
+			if err := r.cache.Set(ctx, key, notFound, r.opts.NegativeTTL); err != nil {
+				logrus.Warnf("Failed to cache missing %s %s: %v", r.opts.Domain, id, err)
+			}
+		}
+		return value, false, err
+	}
+	if err != nil {
+		return value, false, err
+	}
+	r.Set(ctx, id, value)
+	return value, false, nil
+}
+
+// Set caches value as id's, replacing a cached NotFound
+func (r *ReadThrough[T]) Set(ctx context.Context, id string, value T) {
+	if r.cache.Degraded() {
+		return
+	}
+	data, err := r.opts.Serializer.Marshal(value)
+	if err != nil {
+		logrus.Errorf("Failed to marshal %s for cache: %v", r.opts.Domain, err)
+		return
+	}
+	if err := r.cache.Set(ctx, r.Key(id), string(data), r.opts.TTL); err != nil {
+		logrus.Errorf("Failed to cache %s: %v", r.opts.Domain, err)
+	}
+}
+
+// Forget drops the cached values of ids
+func (r *ReadThrough[T]) Forget(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = r.Key(id)
+	}
+	return r.cache.Del(ctx, keys...)
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cachedPlan struct {
+	ID       string
+	Price    float64
+	Features map[string]string
+}
+
+func TestSerializers(t *testing.T) {
+	plan := &cachedPlan{ID: "basic", Price: 9.99, Features: map[string]string{"support": "email"}}
+	for name, s := range map[string]Serializer{"json": JSON, "gob": Gob} {
+		data, err := s.Marshal(plan)
+		require.NoError(t, err, name)
+		assert.NotEqual(t, notFound, string(data), name)
+
+		var decoded *cachedPlan
+		require.NoError(t, s.Unmarshal(data, &decoded), name)
+		assert.Equal(t, plan, decoded, name)
+	}
+}
+
+func TestReadThroughDefaults(t *testing.T) {
+	r := NewReadThrough(&RedisClient{}, ReadThroughOptions[*cachedPlan]{Prefix: "plan", TTL: time.Hour})
+	assert.Equal(t, JSON, r.opts.Serializer)
+	assert.Equal(t, sql.ErrNoRows, r.opts.NotFound)
+}
+
+func TestReadThroughDegraded(t *testing.T) {
+	ctx := context.Background()
+	redis := &RedisClient{breaker: &breaker{threshold: 3}}
+	redis.breaker.trip()
+	r := NewReadThrough(redis, ReadThroughOptions[*cachedPlan]{Domain: "plan", Prefix: "plan", TTL: time.Hour, NegativeTTL: time.Minute})
+
+	// Loads go straight to the source, and nothing is cached
+	loads := 0
+	plan, hit, err := r.Get(ctx, "basic", func(context.Context) (*cachedPlan, error) {
+		loads++
+		return &cachedPlan{ID: "basic"}, nil
+	})
+	require.NoError(t, err)
+	assert.False(t, hit)
+	assert.Equal(t, "basic", plan.ID)
+	assert.Equal(t, 1, loads)
+
+	_, hit, err = r.Get(ctx, "gone", func(context.Context) (*cachedPlan, error) {
+		return nil, sql.ErrNoRows
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.False(t, hit)
+
+	r.Set(ctx, "basic", plan)
+}
@@ -3,15 +3,34 @@ package cache
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/telemetry"
 
 	"github.com/go-redis/redis/v8"
 )
 
+// Script is a Lua script run atomically by Redis
+type Script = redis.Script
+
+// NewScript wraps src for RunScript
+func NewScript(src string) *Script {
+	return redis.NewScript(src)
+}
+
 type RedisClient struct {
-	client *redis.Client
+	client  *redis.Client
+	keys    *keyspace
+	breaker *breaker
+	done    chan struct{}
+
+	warmup  config.WarmupConfig
+	warmMu  sync.Mutex
+	warmers []namedWarmer
+	warming atomic.Bool
 }
 
 func NewRedisClient(cfg config.CacheConfig) (*RedisClient, error) {
@@ -30,7 +49,21 @@ func NewRedisClient(cfg config.CacheConfig) (*RedisClient, error) {
 		return nil, fmt.Errorf("failed to ping Redis: %w", err)
 	}
 
-	return &RedisClient{client: client}, nil
+	keys := &keyspace{namespace: cfg.Namespace, schema: cfg.SchemaVersion}
+	breaker := &breaker{threshold: int32(cfg.FailureThreshold)}
+	client.AddHook(tracingHook{})
+	client.AddHook(metricsHook{slow: time.Duration(cfg.SlowCommandMs) * time.Millisecond, keys: keys})
+	// Last, so commands refused while degraded are still traced and counted
+	client.AddHook(breakerHook{breaker: breaker})
+
+	r := &RedisClient{client: client, keys: keys, breaker: breaker, done: make(chan struct{}), warmup: cfg.Warmup}
+	if err := r.loadGeneration(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load cache generation: %w", err)
+	}
+	go r.refreshGeneration(time.Duration(cfg.GenerationRefresh) * time.Second)
+	go r.probe(time.Duration(cfg.ProbeInterval) * time.Second)
+
+	return r, nil
 }
 
 func (r *RedisClient) Get(ctx context.Context, key string) (string, error) {
@@ -69,10 +102,44 @@ func (r *RedisClient) TTL(ctx context.Context, key string) (time.Duration, error
 	return r.client.TTL(ctx, key).Result()
 }
 
+// PubSub is a subscription to Redis channels
+type PubSub = redis.PubSub
+
+// Publish sends message to the subscribers of channel
+func (r *RedisClient) Publish(ctx context.Context, channel string, message interface{}) error {
+	return r.client.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe listens on channels until the returned PubSub is closed
+func (r *RedisClient) Subscribe(ctx context.Context, channels ...string) *PubSub {
+	return r.client.Subscribe(ctx, channels...)
+}
+
+// RunScript runs script with EVALSHA, loading it on first use
+func (r *RedisClient) RunScript(ctx context.Context, script *Script, keys []string, args ...interface{}) (interface{}, error) {
+	return script.Run(ctx, r.client, keys, args...).Result()
+}
+
+// PoolStats reports the Redis connection pool; go-redis doesn't count waits,
+// only the callers that timed out waiting
+func (r *RedisClient) PoolStats() []telemetry.PoolStats {
+	s := r.client.PoolStats()
+	return []telemetry.PoolStats{{
+		Pool:     "redis",
+		Kind:     "redis",
+		MaxOpen:  r.client.Options().PoolSize,
+		Open:     int(s.TotalConns),
+		InUse:    int(s.TotalConns - s.IdleConns),
+		Idle:     int(s.IdleConns),
+		Timeouts: int64(s.Timeouts),
+	}}
+}
+
 func (r *RedisClient) HealthCheck(ctx context.Context) error {
 	return r.client.Ping(ctx).Err()
 }
 
 func (r *RedisClient) Close() error {
+	close(r.done)
 	return r.client.Close()
 }
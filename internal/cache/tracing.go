@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingHook emits a span for every Redis command and pipeline
+type tracingHook struct{}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = telemetry.StartSpan(ctx, "redis."+cmd.Name(),
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation", cmd.Name()),
+	)
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endSpan(trace.SpanFromContext(ctx), cmd.Err())
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx, _ = telemetry.StartSpan(ctx, "redis.pipeline",
+		attribute.String("db.system", "redis"),
+		attribute.Int("db.redis.num_cmd", len(cmds)),
+	)
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && cmdErr != redis.Nil {
+			err = cmdErr
+			break
+		}
+	}
+	endSpan(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+func endSpan(span trace.Span, err error) {
+	// A cache miss is an expected outcome, not a failed command
+	if err == redis.Nil {
+		err = nil
+	}
+	telemetry.EndSpan(span, err)
+}
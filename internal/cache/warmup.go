@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrWarming is returned by Warm while another warmup is running
+var ErrWarming = errors.New("cache warmup already running")
+
+// Warmer preloads one kind of cached value, returning how many it cached
+type Warmer func(ctx context.Context) (int, error)
+
+type namedWarmer struct {
+	name string
+	warm Warmer
+}
+
+// RegisterWarmer adds a warmer to those Warm runs, in registration order
+func (r *RedisClient) RegisterWarmer(name string, warm Warmer) {
+	r.warmMu.Lock()
+	defer r.warmMu.Unlock()
+	r.warmers = append(r.warmers, namedWarmer{name: name, warm: warm})
+}
+
+// Warm runs every registered warmer, so the first reads after a deploy or
+// a namespace bump don't all go to the database, and returns how many
+// values each cached. A failed warmer doesn't stop the others; their errors
+// are joined. It does nothing while warmup is disabled or Redis is degraded,
+// and gives up after cache.warmup.timeout.
+func (r *RedisClient) Warm(ctx context.Context) (map[string]int, error) {
+	warmed := make(map[string]int)
+	if !r.warmup.Enabled || r.Degraded() {
+		return warmed, nil
+	}
+	if !r.warming.CompareAndSwap(false, true) {
+		return warmed, ErrWarming
+	}
+	defer r.warming.Store(false)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(r.warmup.Timeout)*time.Second)
+	defer cancel()
+
+	r.warmMu.Lock()
+	warmers := append([]namedWarmer(nil), r.warmers...)
+	r.warmMu.Unlock()
+
+	var errs []error
+	for _, w := range warmers {
+		start := time.Now()
+		n, err := w.warm(ctx)
+		warmed[w.name] = n
+		telemetry.RecordCacheWarmup(w.name, n)
+		if err != nil {
+			logrus.Warnf("Cache warmer %s failed after %d values: %v", w.name, n, err)
+			errs = append(errs, fmt.Errorf("%s: %w", w.name, err))
+			continue
+		}
+		logrus.Infof("Cache warmer %s cached %d values in %s", w.name, n, time.Since(start).Round(time.Millisecond))
+	}
+	return warmed, errors.Join(errs...)
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarm(t *testing.T) {
+	ctx := context.Background()
+	r := &RedisClient{breaker: &breaker{threshold: 3}, warmup: config.WarmupConfig{Enabled: true, Timeout: 5}}
+
+	var order []string
+	r.RegisterWarmer("plans.active", func(context.Context) (int, error) {
+		order = append(order, "plans.active")
+		return 4, nil
+	})
+	r.RegisterWarmer("plans.hot", func(context.Context) (int, error) {
+		order = append(order, "plans.hot")
+		return 1, errors.New("replica unreachable")
+	})
+	r.RegisterWarmer("subscriptions.recent", func(ctx context.Context) (int, error) {
+		order = append(order, "subscriptions.recent")
+		_, bounded := ctx.Deadline()
+		assert.True(t, bounded, "warmers run under cache.warmup.timeout")
+		return 250, nil
+	})
+
+	// A failed warmer doesn't stop the others
+	warmed, err := r.Warm(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "plans.hot: replica unreachable")
+	assert.Equal(t, map[string]int{"plans.active": 4, "plans.hot": 1, "subscriptions.recent": 250}, warmed)
+	assert.Equal(t, []string{"plans.active", "plans.hot", "subscriptions.recent"}, order)
+
+	r.warming.Store(true)
+	_, err = r.Warm(ctx)
+	assert.ErrorIs(t, err, ErrWarming)
+	r.warming.Store(false)
+
+	order = nil
+	r.breaker.degraded.Store(true)
+	warmed, err = r.Warm(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, warmed)
+	r.breaker.degraded.Store(false)
+
+	r.warmup.Enabled = false
+	warmed, err = r.Warm(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, warmed)
+	assert.Empty(t, order)
+}
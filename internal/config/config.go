@@ -8,31 +8,82 @@ import (
 )
 
 type Config struct {
-	Server    ServerConfig    `mapstructure:"server"`
-	Database  DatabaseConfig  `mapstructure:"database"`
-	Cache     CacheConfig     `mapstructure:"cache"`
-	Telemetry TelemetryConfig `mapstructure:"telemetry"`
-	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
-	Payment   PaymentConfig   `mapstructure:"payment"`
+	Server         ServerConfig                 `mapstructure:"server"`
+	Database       DatabaseConfig               `mapstructure:"database"`
+	Cache          CacheConfig                  `mapstructure:"cache"`
+	Telemetry      TelemetryConfig              `mapstructure:"telemetry"`
+	RateLimit      RateLimitConfig              `mapstructure:"rate_limit"`
+	Payment        PaymentConfig                `mapstructure:"payment"`
+	Secrets        SecretsConfig                `mapstructure:"secrets"`
+	FeatureFlags   map[string]FeatureFlagConfig `mapstructure:"feature_flags"`
+	Subscription   SubscriptionConfig           `mapstructure:"subscription"`
+	Notification   NotificationConfig           `mapstructure:"notification"`
+	FX             FXConfig                     `mapstructure:"fx"`
+	Jobs           JobsConfig                   `mapstructure:"jobs"`
+	Pricing        PricingConfig                `mapstructure:"pricing"`
+	Features       []FeatureConfig              `mapstructure:"features"`
+	Paywall        PaywallConfig                `mapstructure:"paywall"`
+	Logging        LoggingConfig                `mapstructure:"logging"`
+	Encryption     EncryptionConfig             `mapstructure:"encryption"`
+	ObjectStore    ObjectStoreConfig            `mapstructure:"object_store"`
+	Retention      RetentionConfig              `mapstructure:"retention"`
+	Export         ExportConfig                 `mapstructure:"export"`
+	Warehouse      WarehouseConfig              `mapstructure:"warehouse"`
+	CRM            CRMConfig                    `mapstructure:"crm"`
+	Accounting     AccountingConfig             `mapstructure:"accounting"`
+	I18n           I18nConfig                   `mapstructure:"i18n"`
+	Imports        ImportsConfig                `mapstructure:"imports"`
+	Auth           AuthConfig                   `mapstructure:"auth"`
+	ErrorReporting ErrorReportingConfig         `mapstructure:"error_reporting"`
 }
 
 type ServerConfig struct {
-	Port         int    `mapstructure:"port"`
-	Host         string `mapstructure:"host"`
-	ReadTimeout  int    `mapstructure:"read_timeout"`
-	WriteTimeout int    `mapstructure:"write_timeout"`
+	Port           int                  `mapstructure:"port"`
+	Host           string               `mapstructure:"host"`
+	ReadTimeout    int                  `mapstructure:"read_timeout"`
+	WriteTimeout   int                  `mapstructure:"write_timeout"`
+	RequestTimeout int                  `mapstructure:"request_timeout"`
+	MaxBodyBytes   int64                `mapstructure:"max_body_bytes"`
+	RouteTimeouts  []RouteTimeoutConfig `mapstructure:"route_timeouts"`
+	// TrustedProxies lists the addresses or CIDR ranges of the proxies in
+	// front of the server. Only their X-Forwarded-For and X-Real-IP name
+	// the client; with none, the client is the connection's peer.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// RouteTimeoutConfig overrides the request timeout (seconds) for one route.
+// Path is the gin route pattern, e.g. /api/v1/plans/:id/analytics.
+type RouteTimeoutConfig struct {
+	Method  string `mapstructure:"method"`
+	Path    string `mapstructure:"path"`
+	Timeout int    `mapstructure:"timeout"`
 }
 
 type DatabaseConfig struct {
-	Host            string `mapstructure:"host"`
-	Port            int    `mapstructure:"port"`
-	User            string `mapstructure:"user"`
-	Password        string `mapstructure:"password"`
-	DBName          string `mapstructure:"dbname"`
-	SSLMode         string `mapstructure:"sslmode"`
-	MaxOpenConns    int    `mapstructure:"max_open_conns"`
-	MaxIdleConns    int    `mapstructure:"max_idle_conns"`
-	ConnMaxLifetime int    `mapstructure:"conn_max_lifetime"`
+	Host            string   `mapstructure:"host"`
+	Port            int      `mapstructure:"port"`
+	User            string   `mapstructure:"user"`
+	Password        string   `mapstructure:"password"`
+	DBName          string   `mapstructure:"dbname"`
+	SSLMode         string   `mapstructure:"sslmode"`
+	MaxOpenConns    int      `mapstructure:"max_open_conns"`
+	MaxIdleConns    int      `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime int      `mapstructure:"conn_max_lifetime"`
+	ReplicaHosts    []string `mapstructure:"replica_hosts"`
+	// ReplicaDSN is the connection string of one more read replica, for a
+	// replica that doesn't share the primary's credentials (e.g. a read-only
+	// user). It is used alongside ReplicaHosts with the same pool settings.
+	ReplicaDSN string `mapstructure:"replica_dsn"`
+	// Statement timeouts in seconds, applied to every query on top of the
+	// caller's own deadline so calls made outside a request (jobs, sweepers,
+	// webhook processing) are bounded too; zero leaves them unbounded.
+	// NamedTimeouts overrides them per prepared statement name.
+	QueryTimeout  int            `mapstructure:"query_timeout"`
+	ExecTimeout   int            `mapstructure:"exec_timeout"`
+	NamedTimeouts map[string]int `mapstructure:"named_timeouts"`
+	// SlowQueryMs logs queries that take longer than this many
+	// milliseconds; zero turns slow query logging off
+	SlowQueryMs int `mapstructure:"slow_query_ms"`
 }
 
 type CacheConfig struct {
@@ -41,13 +92,91 @@ type CacheConfig struct {
 	Password string `mapstructure:"password"`
 	DB       int    `mapstructure:"db"`
 	PoolSize int    `mapstructure:"pool_size"`
+	// SlowCommandMs logs Redis commands that take longer than this many
+	// milliseconds; zero turns slow-command logging off
+	SlowCommandMs int `mapstructure:"slow_command_ms"`
+	// Cached values are keyed under Namespace and SchemaVersion; raise
+	// SchemaVersion when a deploy changes the shape of cached structs.
+	// GenerationRefresh is how often, in seconds, an instance picks up a
+	// namespace bump made by another.
+	Namespace         string `mapstructure:"namespace"`
+	SchemaVersion     int    `mapstructure:"schema_version"`
+	GenerationRefresh int    `mapstructure:"generation_refresh"`
+	// Redis is marked degraded after FailureThreshold commands in a row
+	// fail to reach it, or a ping every ProbeInterval seconds fails; while
+	// degraded, commands other than the ping fail at once
+	ProbeInterval    int          `mapstructure:"probe_interval"`
+	FailureThreshold int          `mapstructure:"failure_threshold"`
+	Warmup           WarmupConfig `mapstructure:"warmup"`
+}
+
+// WarmupConfig preloads Redis at boot and after a namespace bump, so a
+// deploy doesn't send every first read to the database. HotPlans is how
+// many plans with the most active subscribers are loaded besides the active
+// plans; RecentSubscriptions caps the subscriptions used within the last
+// RecentWindow hours that are loaded. A warmup gives up after Timeout
+// seconds.
+type WarmupConfig struct {
+	Enabled             bool `mapstructure:"enabled"`
+	HotPlans            int  `mapstructure:"hot_plans"`
+	RecentSubscriptions int  `mapstructure:"recent_subscriptions"`
+	RecentWindow        int  `mapstructure:"recent_window"`
+	Timeout             int  `mapstructure:"timeout"`
 }
 
 type TelemetryConfig struct {
-	Enabled     bool   `mapstructure:"enabled"`
-	ServiceName string `mapstructure:"service_name"`
-	Environment string `mapstructure:"environment"`
-	Version     string `mapstructure:"version"`
+	Enabled                 bool          `mapstructure:"enabled"`
+	ServiceName             string        `mapstructure:"service_name"`
+	Environment             string        `mapstructure:"environment"`
+	Version                 string        `mapstructure:"version"`
+	BusinessMetricsInterval int           `mapstructure:"business_metrics_interval"`
+	PoolStatsInterval       int           `mapstructure:"pool_stats_interval"`
+	Tracing                 TracingConfig `mapstructure:"tracing"`
+	SLO                     SLOConfig     `mapstructure:"slo"`
+}
+
+type TracingConfig struct {
+	Enabled      bool    `mapstructure:"enabled"`
+	OTLPEndpoint string  `mapstructure:"otlp_endpoint"`
+	Insecure     bool    `mapstructure:"insecure"`
+	SampleRatio  float64 `mapstructure:"sample_ratio"`
+}
+
+// SLOConfig sets service level objectives on the domains timed in
+// domain_operation_duration_seconds. Every Interval seconds each objective's
+// burn rate is computed over the windows of BurnRates, and alerts are
+// posted to WebhookURL, signed with SigningSecret, when they start and stop
+// firing. No objectives disables it.
+type SLOConfig struct {
+	Interval      int                  `mapstructure:"interval"`
+	WebhookURL    string               `mapstructure:"webhook_url"`
+	SigningSecret string               `mapstructure:"signing_secret"`
+	Timeout       int                  `mapstructure:"timeout"`
+	Objectives    []SLOObjectiveConfig `mapstructure:"objectives"`
+	BurnRates     []BurnRateConfig     `mapstructure:"burn_rates"`
+}
+
+// SLOObjectiveConfig is an objective on a domain's operations (routes; all
+// when empty): Availability is the share of requests that must not fail
+// with a 5xx status, and LatencyTarget the share that must take at most
+// LatencyMS. Either may be 0 to leave it out.
+type SLOObjectiveConfig struct {
+	Name          string   `mapstructure:"name"`
+	Domain        string   `mapstructure:"domain"`
+	Operations    []string `mapstructure:"operations"`
+	Availability  float64  `mapstructure:"availability"`
+	LatencyMS     int      `mapstructure:"latency_ms"`
+	LatencyTarget float64  `mapstructure:"latency_target"`
+}
+
+// BurnRateConfig is an alert firing with Severity while an objective's
+// error budget burns at Threshold times the sustainable rate or faster over
+// both LongWindow and ShortWindow seconds.
+type BurnRateConfig struct {
+	Severity    string  `mapstructure:"severity"`
+	LongWindow  int     `mapstructure:"long_window"`
+	ShortWindow int     `mapstructure:"short_window"`
+	Threshold   float64 `mapstructure:"threshold"`
 }
 
 type RateLimitConfig struct {
@@ -56,12 +185,121 @@ type RateLimitConfig struct {
 	Window      int64 `mapstructure:"window"`
 }
 
+// PaymentConfig configures the payment gateway. DisputePolicy decides when a
+// chargeback suspends the disputed subscription: suspend_on_open,
+// suspend_on_loss or none. RefundPolicy decides what an immediate
+// cancellation gives back for the unused part of the period: refund, credit
+// or none; TenantRefundPolicies overrides it per tenant (keys are matched
+// case-insensitively). PendingAccess decides whether a subscription paid by a
+// charge that has not settled yet, e.g. a SEPA or ACH bank debit, has access
+// meanwhile: grant, revoked again if the charge fails, or deny.
 type PaymentConfig struct {
-	GatewayURL     string               `mapstructure:"gateway_url"`
-	APIKey         string               `mapstructure:"api_key"`
-	SecretKey      string               `mapstructure:"secret_key"`
-	WebhookSecret  string               `mapstructure:"webhook_secret"`
-	CircuitBreaker CircuitBreakerConfig `mapstructure:"circuit_breaker"`
+	Enabled              bool                 `mapstructure:"enabled"`
+	GatewayURL           string               `mapstructure:"gateway_url"`
+	APIKey               string               `mapstructure:"api_key"`
+	SecretKey            string               `mapstructure:"secret_key"`
+	WebhookSecret        string               `mapstructure:"webhook_secret"`
+	DisputePolicy        string               `mapstructure:"dispute_policy"`
+	RefundPolicy         string               `mapstructure:"refund_policy"`
+	TenantRefundPolicies map[string]string    `mapstructure:"tenant_refund_policies"`
+	PendingAccess        string               `mapstructure:"pending_access"`
+	CircuitBreaker       CircuitBreakerConfig `mapstructure:"circuit_breaker"`
+	Risk                 RiskConfig           `mapstructure:"risk"`
+	Routing              PaymentRoutingConfig `mapstructure:"routing"`
+	PayPal               PayPalConfig         `mapstructure:"paypal"`
+	Invoicing            InvoicingConfig      `mapstructure:"invoicing"`
+	Checkout             CheckoutConfig       `mapstructure:"checkout"`
+	VAT                  VATConfig            `mapstructure:"vat"`
+}
+
+// VATConfig checks the EU VAT IDs business customers give at checkout with
+// the VIES service at VIESURL, waiting at most Timeout seconds for it.
+// SellerCountry is the ISO code of the EU country the seller is registered
+// for VAT in: sales to a business with a valid VAT ID from another member
+// state are reverse charged. Without it every sale is taxed as standard.
+type VATConfig struct {
+	SellerCountry string `mapstructure:"seller_country"`
+	VIESURL       string `mapstructure:"vies_url"`
+	Timeout       int    `mapstructure:"timeout"`
+}
+
+// CheckoutConfig sets up hosted checkout sessions, which can be completed
+// for SessionTTL seconds after they are created. HostedURL is the checkout
+// page; when set, sessions carry its URL with their token appended. Success
+// and cancel URLs must be on one of ReturnHosts when it is set. Coupons are
+// the codes a session may apply.
+type CheckoutConfig struct {
+	SessionTTL  int            `mapstructure:"session_ttl"`
+	HostedURL   string         `mapstructure:"hosted_url"`
+	ReturnHosts []string       `mapstructure:"return_hosts"`
+	Coupons     []CouponConfig `mapstructure:"coupons"`
+}
+
+// CouponConfig is a discount code for checkout, matched case-insensitively.
+// It takes PercentOff off the first charge and the Renewals renewal charges
+// after it. PlanIDs restricts it to those plans; empty allows any paid plan.
+type CouponConfig struct {
+	Code       string   `mapstructure:"code"`
+	PercentOff int      `mapstructure:"percent_off"`
+	Renewals   int      `mapstructure:"renewals"`
+	PlanIDs    []string `mapstructure:"plan_ids"`
+}
+
+// InvoicingConfig sets the terms of manual invoices, paid offline by bank
+// transfer or similar. Invoices are due DueDays after they are issued and
+// become overdue after that; CancelAfterDays later they are voided and the
+// subscription they were for is cancelled or lapses. The overdue check runs
+// every CheckInterval seconds. WebhookSecret signs the bank webhook that
+// reports incoming payments; without it the webhook is refused.
+//
+// Invoice numbers follow NumberFormat, or the tenant's format from
+// TenantNumberFormats. {seq} is the invoice's place in its series, zero
+// padded to NumberDigits; {tenant}, {country} (the customer's), {currency},
+// {year} and {month} fill in the rest. Everything in a number but {seq} names
+// its series, so a format with {country} numbers each country separately and
+// one with {year} starts again at 1 every year.
+type InvoicingConfig struct {
+	DueDays             int               `mapstructure:"due_days"`
+	CancelAfterDays     int               `mapstructure:"cancel_after_days"`
+	CheckInterval       int               `mapstructure:"check_interval"`
+	WebhookSecret       string            `mapstructure:"webhook_secret"`
+	NumberFormat        string            `mapstructure:"number_format"`
+	NumberDigits        int               `mapstructure:"number_digits"`
+	TenantNumberFormats map[string]string `mapstructure:"tenant_number_formats"`
+}
+
+// PaymentRoutingConfig picks the gateway new payments go to: the one named
+// for the payment's currency, else Default. Without either, the new_gateway
+// flag chooses between stripe and the simulated gateway. Gateways are
+// simulated, stripe or paypal.
+type PaymentRoutingConfig struct {
+	Default    string            `mapstructure:"default"`
+	Currencies map[string]string `mapstructure:"currencies"`
+}
+
+// PayPalConfig connects the PayPal gateway to the REST API at BaseURL with
+// an app's client credentials. WebhookID is the ID PayPal gave the webhook
+// subscription, needed to verify the events it sends.
+type PayPalConfig struct {
+	BaseURL      string `mapstructure:"base_url"`
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	WebhookID    string `mapstructure:"webhook_id"`
+}
+
+// RiskConfig tunes the risk checks run before charging at checkout.
+// Attempts per user, client IP and payment method are counted over
+// VelocityWindow seconds; beyond ReviewAfter the payment is held for review
+// and beyond BlockAfter it is blocked. ProviderURL, when set, also asks a
+// third-party scoring service, waiting at most ProviderTimeout seconds.
+type RiskConfig struct {
+	Enabled         bool   `mapstructure:"enabled"`
+	VelocityWindow  int    `mapstructure:"velocity_window"`
+	ReviewAfter     int    `mapstructure:"review_after"`
+	BlockAfter      int    `mapstructure:"block_after"`
+	ProviderURL     string `mapstructure:"provider_url"`
+	ProviderAPIKey  string `mapstructure:"provider_api_key"`
+	ProviderTimeout int    `mapstructure:"provider_timeout"`
 }
 
 type CircuitBreakerConfig struct {
@@ -71,6 +309,461 @@ type CircuitBreakerConfig struct {
 	HalfOpenRequests int   `mapstructure:"half_open_requests"`
 }
 
+// SubscriptionConfig drives the background subscription workers. Renewals
+// charge auto-renewing subscriptions RenewalLeadTime seconds before they end;
+// failed charges are retried after each of DunningRetryDelays (seconds) in
+// turn. ClaimLease bounds how long a worker may hold a subscription.
+// DowngradeToFree enrolls users on the active free plan when a paid
+// subscription is cancelled or expires.
+type SubscriptionConfig struct {
+	ReminderDays       []int `mapstructure:"reminder_days"`
+	ReminderInterval   int   `mapstructure:"reminder_interval"`
+	LifecycleInterval  int   `mapstructure:"lifecycle_interval"`
+	RenewalInterval    int   `mapstructure:"renewal_interval"`
+	RenewalBatchSize   int   `mapstructure:"renewal_batch_size"`
+	RenewalLeadTime    int   `mapstructure:"renewal_lead_time"`
+	ClaimLease         int   `mapstructure:"claim_lease"`
+	DunningRetryDelays []int `mapstructure:"dunning_retry_delays"`
+	DowngradeToFree    bool  `mapstructure:"downgrade_to_free"`
+	// RetentionOffers are win-back offers made to cancelling subscribers,
+	// the first eligible one in order. A subscription that accepted one is
+	// offered nothing more for RetentionCooldownDays.
+	RetentionOffers       []RetentionOfferConfig `mapstructure:"retention_offers"`
+	RetentionCooldownDays int                    `mapstructure:"retention_cooldown_days"`
+}
+
+// RetentionOfferConfig is one win-back offer. A discount takes PercentOff
+// off the next Renewals renewal charges; a pause stops access and billing
+// for PauseMonths once the paid period ends. PlanIDs (any plan when empty)
+// and MinTenureDays, days since the subscription started, decide who is
+// eligible.
+type RetentionOfferConfig struct {
+	Key           string   `mapstructure:"key"`
+	Type          string   `mapstructure:"type"`
+	PercentOff    int      `mapstructure:"percent_off"`
+	Renewals      int      `mapstructure:"renewals"`
+	PauseMonths   int      `mapstructure:"pause_months"`
+	PlanIDs       []string `mapstructure:"plan_ids"`
+	MinTenureDays int      `mapstructure:"min_tenure_days"`
+}
+
+type NotificationConfig struct {
+	WebhookURL    string `mapstructure:"webhook_url"`
+	SigningSecret string `mapstructure:"signing_secret"`
+	Timeout       int    `mapstructure:"timeout"`
+}
+
+// JobsConfig tunes the background job runner. LockTimeout bounds a single
+// run; failed jobs are retried after RetryBackoff seconds, doubling per
+// attempt, until MaxAttempts runs have failed.
+type JobsConfig struct {
+	Workers       int `mapstructure:"workers"`
+	PollInterval  int `mapstructure:"poll_interval"`
+	LockTimeout   int `mapstructure:"lock_timeout"`
+	MaxAttempts   int `mapstructure:"max_attempts"`
+	RetryBackoff  int `mapstructure:"retry_backoff"`
+	RetentionDays int `mapstructure:"retention_days"`
+}
+
+// FXConfig selects the exchange rate source. Source is "ecb" (daily ECB
+// reference rates from URL) or "static" (StaticRates, units per BaseCurrency).
+type FXConfig struct {
+	BaseCurrency    string            `mapstructure:"base_currency"`
+	Source          string            `mapstructure:"source"`
+	URL             string            `mapstructure:"url"`
+	RefreshInterval int               `mapstructure:"refresh_interval"`
+	StaticRates     map[string]string `mapstructure:"static_rates"`
+}
+
+// PricingConfig shapes the public pricing page. MaxAge (seconds) is its
+// Cache-Control lifetime.
+type PricingConfig struct {
+	MaxAge int `mapstructure:"max_age"`
+}
+
+// FeatureConfig defines one entry of the plan feature catalog, in display
+// order. Type is bool, int or enum; enum features take one of Values. An int
+// feature with Metered set to daily or monthly is a usage limit per day or
+// usage month, counted by the paywall.
+type FeatureConfig struct {
+	Key         string   `mapstructure:"key"`
+	Type        string   `mapstructure:"type"`
+	DisplayName string   `mapstructure:"display_name"`
+	Description string   `mapstructure:"description"`
+	Group       string   `mapstructure:"group"`
+	Values      []string `mapstructure:"values"`
+	Metered     string   `mapstructure:"metered"`
+}
+
+// PaywallConfig tunes the paywall event stream behind the paywall
+// analytics. Events are buffered in memory (EventBuffer events, dropped when
+// full) and written in batches of EventBatchSize at least every
+// EventFlushInterval seconds.
+// PaywallConfig's UpgradeURL is the checkout deep link offered on content
+// walls, in which {plan_id} and {content_id} are replaced by the plan and
+// the content.
+type PaywallConfig struct {
+	EventBuffer        int                    `mapstructure:"event_buffer"`
+	EventBatchSize     int                    `mapstructure:"event_batch_size"`
+	EventFlushInterval int                    `mapstructure:"event_flush_interval"`
+	RateLimit          PaywallRateLimitConfig `mapstructure:"rate_limit"`
+	Usage              PaywallUsageConfig     `mapstructure:"usage"`
+	Stream             PaywallStreamConfig    `mapstructure:"stream"`
+	Leases             PaywallLeaseConfig     `mapstructure:"leases"`
+	Abuse              PaywallAbuseConfig     `mapstructure:"abuse"`
+	UpgradeURL         string                 `mapstructure:"upgrade_url"`
+	// DegradedPolicy decides enforcement while Redis is degraded, when
+	// rate limits and usage can't be counted: fail_open allows it
+	// uncounted, fail_closed refuses it
+	DegradedPolicy string `mapstructure:"degraded_policy"`
+}
+
+// PaywallStreamConfig tunes the entitlement change stream. Changes are
+// picked up from subscription history every PollInterval seconds, and
+// connected clients get a keep-alive comment every Heartbeat seconds.
+type PaywallStreamConfig struct {
+	PollInterval int `mapstructure:"poll_interval"`
+	Heartbeat    int `mapstructure:"heartbeat"`
+}
+
+// PaywallLeaseConfig limits how many leases on an action, such as active
+// streams, a user may hold at once. A lease lasts TTL seconds unless renewed
+// by a heartbeat. Actions sets the limit of each action it lists; Limit
+// applies to the others. A plan's paywall_<action>_concurrent feature
+// overrides both for its subscribers.
+type PaywallLeaseConfig struct {
+	TTL     int            `mapstructure:"ttl"`
+	Limit   int            `mapstructure:"limit"`
+	Actions map[string]int `mapstructure:"actions"`
+}
+
+// PaywallAbuseConfig tunes the detection of users getting around metered
+// limits by rotating accounts or IPs. Within each Window of seconds, more
+// than MaxAccountsPerDevice accounts on one device, MaxAccountsPerIP
+// accounts from one IP or MaxIPsPerUser IPs used by one account flag the
+// user for review.
+type PaywallAbuseConfig struct {
+	Enabled              bool `mapstructure:"enabled"`
+	Window               int  `mapstructure:"window"`
+	MaxAccountsPerDevice int  `mapstructure:"max_accounts_per_device"`
+	MaxAccountsPerIP     int  `mapstructure:"max_accounts_per_ip"`
+	MaxIPsPerUser        int  `mapstructure:"max_ips_per_user"`
+}
+
+// PaywallUsageConfig sets where daily usage counters reset at midnight: in
+// the user's own timezone, else their tenant's from TenantTimezones (keyed
+// by tenant ID), else Timezone. Timezones are IANA names; an empty Timezone
+// is the server's local time.
+type PaywallUsageConfig struct {
+	Timezone        string            `mapstructure:"timezone"`
+	TenantTimezones map[string]string `mapstructure:"tenant_timezones"`
+}
+
+// PaywallRateLimitConfig caps paywall enforcement per user and action per
+// minute. Actions sets the cap of each action it lists; PerMinute applies
+// to the others. A plan's paywall_<action>_per_minute feature overrides
+// both for its subscribers.
+type PaywallRateLimitConfig struct {
+	PerMinute int            `mapstructure:"per_minute"`
+	Actions   map[string]int `mapstructure:"actions"`
+}
+
+// LoggingConfig controls what is scrubbed from logs and error responses.
+// RedactFields are regular expressions matched case-insensitively against
+// log field names; matching fields are logged as [REDACTED].
+type LoggingConfig struct {
+	RedactFields []string `mapstructure:"redact_fields"`
+}
+
+// EncryptionConfig turns on application-layer AES-256-GCM encryption of
+// sensitive columns. Keys maps key IDs to base64-encoded 32-byte keys, and
+// new values are encrypted with ActiveKey; the others are kept to decrypt
+// older values until the rotation job, every RotateInterval seconds, has
+// re-encrypted them. IndexKey (base64, at least 32 bytes) keys the blind
+// index that lookups by encrypted email use. With
+// secrets.refs.encryption_keyring set, the keys come from the secret store.
+type EncryptionConfig struct {
+	Enabled        bool              `mapstructure:"enabled"`
+	ActiveKey      string            `mapstructure:"active_key"`
+	Keys           map[string]string `mapstructure:"keys"`
+	IndexKey       string            `mapstructure:"index_key"`
+	EncryptEmail   bool              `mapstructure:"encrypt_email"`
+	RotateInterval int               `mapstructure:"rotate_interval"`
+}
+
+// ObjectStoreConfig is where cold data such as archived rows is written.
+// Provider is s3, gcs or file, or empty for none. GCS is reached through
+// its S3-compatible API with HMAC keys, and both read credentials from the
+// AWS default chain; Endpoint points s3 at a compatible store such as
+// MinIO. Directory is the root of the file provider.
+type ObjectStoreConfig struct {
+	Provider  string `mapstructure:"provider"`
+	Bucket    string `mapstructure:"bucket"`
+	Region    string `mapstructure:"region"`
+	Endpoint  string `mapstructure:"endpoint"`
+	Prefix    string `mapstructure:"prefix"`
+	Directory string `mapstructure:"directory"`
+}
+
+// RetentionConfig keeps hot tables small. Each policy archives (to the
+// object store) or purges rows of a table once they are older than Days;
+// Interval is in seconds and BatchSize is rows per archive file.
+type RetentionConfig struct {
+	Interval  int                     `mapstructure:"interval"`
+	BatchSize int                     `mapstructure:"batch_size"`
+	Policies  []RetentionPolicyConfig `mapstructure:"policies"`
+}
+
+type RetentionPolicyConfig struct {
+	Table  string `mapstructure:"table"`
+	Days   int    `mapstructure:"days"`
+	Action string `mapstructure:"action"`
+}
+
+// ExportConfig copies event streams to the object store for the warehouse.
+// Every Interval seconds each of Streams is exported from where the last
+// run stopped, BatchSize events per file, leaving the last Lag seconds for
+// the next run so events still being committed are not skipped.
+type ExportConfig struct {
+	Interval  int      `mapstructure:"interval"`
+	BatchSize int      `mapstructure:"batch_size"`
+	Lag       int      `mapstructure:"lag"`
+	Streams   []string `mapstructure:"streams"`
+}
+
+// WarehouseConfig replicates subscriptions, transactions and plan changes
+// to BigQuery or Snowflake (Provider; empty disables). Every Interval
+// seconds each of Tables is synced from its watermark, BatchSize rows per
+// insert, leaving the last Lag seconds of changes for the next run.
+type WarehouseConfig struct {
+	Provider  string                   `mapstructure:"provider"`
+	Interval  int                      `mapstructure:"interval"`
+	BatchSize int                      `mapstructure:"batch_size"`
+	Lag       int                      `mapstructure:"lag"`
+	Tables    []string                 `mapstructure:"tables"`
+	BigQuery  BigQueryWarehouseConfig  `mapstructure:"bigquery"`
+	Snowflake SnowflakeWarehouseConfig `mapstructure:"snowflake"`
+}
+
+// BigQueryWarehouseConfig authenticates with application default credentials
+type BigQueryWarehouseConfig struct {
+	ProjectID string `mapstructure:"project_id"`
+	Dataset   string `mapstructure:"dataset"`
+}
+
+// SnowflakeWarehouseConfig reaches the SQL API with key-pair authentication;
+// PrivateKey is the user's PEM-encoded PKCS#8 RSA key.
+type SnowflakeWarehouseConfig struct {
+	Account    string `mapstructure:"account"`
+	User       string `mapstructure:"user"`
+	PrivateKey string `mapstructure:"private_key"`
+	Database   string `mapstructure:"database"`
+	Schema     string `mapstructure:"schema"`
+	Warehouse  string `mapstructure:"warehouse"`
+	Role       string `mapstructure:"role"`
+}
+
+// CRMConfig pushes subscription lifecycle changes to Salesforce or HubSpot
+// (Provider; empty disables). Every Interval seconds new subscription
+// events are read, the last Lag seconds left for the next run, and each of
+// Events is queued as a push of the subscriber's contact and the
+// subscription's deal. ContactFields and DealFields map subscription
+// attributes to CRM field names; Stages maps lifecycle events to the deal
+// stage, available as the stage attribute.
+type CRMConfig struct {
+	Provider      string              `mapstructure:"provider"`
+	Interval      int                 `mapstructure:"interval"`
+	BatchSize     int                 `mapstructure:"batch_size"`
+	Lag           int                 `mapstructure:"lag"`
+	Events        []string            `mapstructure:"events"`
+	ContactFields map[string]string   `mapstructure:"contact_fields"`
+	DealFields    map[string]string   `mapstructure:"deal_fields"`
+	Stages        map[string]string   `mapstructure:"stages"`
+	HubSpot       HubSpotCRMConfig    `mapstructure:"hubspot"`
+	Salesforce    SalesforceCRMConfig `mapstructure:"salesforce"`
+}
+
+// HubSpotCRMConfig authenticates with a private app token. Contacts are
+// matched by email and deals by DealIDProperty, a unique deal property
+// holding the subscription ID.
+type HubSpotCRMConfig struct {
+	Token          string `mapstructure:"token"`
+	DealIDProperty string `mapstructure:"deal_id_property"`
+}
+
+// SalesforceCRMConfig authenticates a connected app with the client
+// credentials flow. Contacts and opportunities are upserted by the external
+// ID fields holding the user and subscription IDs.
+type SalesforceCRMConfig struct {
+	InstanceURL       string `mapstructure:"instance_url"`
+	ClientID          string `mapstructure:"client_id"`
+	ClientSecret      string `mapstructure:"client_secret"`
+	APIVersion        string `mapstructure:"api_version"`
+	ContactExternalID string `mapstructure:"contact_external_id"`
+	DealExternalID    string `mapstructure:"deal_external_id"`
+}
+
+// ErrorReportingConfig forwards panics, with their stack traces, to Sentry
+// (Provider "sentry", addressed by the project's DSN) or Rollbar
+// ("rollbar", with a post_server_item AccessToken), waiting at most Timeout
+// seconds. Without a provider panics are only logged.
+type ErrorReportingConfig struct {
+	Provider    string `mapstructure:"provider"`
+	DSN         string `mapstructure:"dsn"`
+	AccessToken string `mapstructure:"access_token"`
+	Timeout     int    `mapstructure:"timeout"`
+}
+
+// I18nConfig sets how API error messages and notifications are localized.
+// Messages go out in DefaultLocale (a BCP 47 tag) when neither the
+// request's Accept-Language nor the user's locale has translations.
+// Directory holds extra <locale>.json bundles, read over the built-in ones.
+type I18nConfig struct {
+	DefaultLocale string `mapstructure:"default_locale"`
+	Directory     string `mapstructure:"directory"`
+}
+
+// AuthConfig protects session creation from guessing and configures
+// two-factor authentication. AdminCIDRs are the addresses admins without
+// an allowlist of their own may sign in and use sessions from; empty
+// allows any.
+type AuthConfig struct {
+	Lockout        LockoutConfig        `mapstructure:"lockout"`
+	Captcha        CaptchaConfig        `mapstructure:"captcha"`
+	TwoFactor      TwoFactorConfig      `mapstructure:"two_factor"`
+	AdminCIDRs     []string             `mapstructure:"admin_cidrs"`
+	RequestSigning RequestSigningConfig `mapstructure:"request_signing"`
+}
+
+// LockoutConfig throttles failed session creations, counted in Redis over
+// Window seconds from the first failure. An IP with MaxIPFailures failures
+// is refused until the window ends. An account with MaxAccountFailures is
+// locked for LockoutDuration seconds, doubling with each further lockout
+// within a day up to MaxLockoutDuration. From CaptchaAfter failures of the
+// IP or account, 0 for never, a CAPTCHA must be solved too.
+type LockoutConfig struct {
+	Enabled            bool `mapstructure:"enabled"`
+	Window             int  `mapstructure:"window"`
+	MaxIPFailures      int  `mapstructure:"max_ip_failures"`
+	MaxAccountFailures int  `mapstructure:"max_account_failures"`
+	LockoutDuration    int  `mapstructure:"lockout_duration"`
+	MaxLockoutDuration int  `mapstructure:"max_lockout_duration"`
+	CaptchaAfter       int  `mapstructure:"captcha_after"`
+}
+
+// CaptchaConfig verifies CAPTCHA tokens with Provider's siteverify API
+// (hcaptcha, recaptcha or turnstile; empty asks for no CAPTCHA) using
+// Secret, waiting at most Timeout seconds. VerifyURL overrides the
+// provider's endpoint.
+type CaptchaConfig struct {
+	Provider  string `mapstructure:"provider"`
+	VerifyURL string `mapstructure:"verify_url"`
+	Secret    string `mapstructure:"secret"`
+	Timeout   int    `mapstructure:"timeout"`
+}
+
+// TwoFactorConfig configures TOTP two-factor authentication. Issuer names
+// the service in authenticator apps. Codes are accepted up to Skew 30-second
+// steps early or late. Enrollment hands out RecoveryCodes single-use
+// recovery codes. With RequireForAdmins, admins must enroll before they
+// get a session. A session stays stepped up for sensitive operations for
+// StepUpTTL seconds after its last code.
+type TwoFactorConfig struct {
+	Issuer           string `mapstructure:"issuer"`
+	Skew             int    `mapstructure:"skew"`
+	RecoveryCodes    int    `mapstructure:"recovery_codes"`
+	RequireForAdmins bool   `mapstructure:"require_for_admins"`
+	StepUpTTL        int    `mapstructure:"step_up_ttl"`
+}
+
+// RequestSigningConfig configures HMAC-signed server-to-server paywall
+// checks. Signatures are accepted for Tolerance seconds either side of
+// their timestamp. With Required, unsigned requests are refused; otherwise
+// only requests that carry a signature are checked.
+type RequestSigningConfig struct {
+	Required  bool `mapstructure:"required"`
+	Tolerance int  `mapstructure:"tolerance"`
+}
+
+// ImportsConfig configures migrations from other billing systems.
+type ImportsConfig struct {
+	Stripe StripeImportConfig `mapstructure:"stripe"`
+}
+
+// StripeImportConfig reads customers and subscriptions from the Stripe API
+// at APIURL with APIKey, a secret or restricted read key. Plans maps
+// Stripe price or product IDs to the plans their subscribers move to; the
+// keys are matched regardless of case, as they are read lowercased. With
+// CreatePlans a plan is created for a price neither is mapped, from its
+// product. Cancelled subscriptions are migrated only with
+// IncludeCancelled.
+type StripeImportConfig struct {
+	APIURL           string            `mapstructure:"api_url"`
+	APIKey           string            `mapstructure:"api_key"`
+	Plans            map[string]string `mapstructure:"plans"`
+	CreatePlans      bool              `mapstructure:"create_plans"`
+	IncludeCancelled bool              `mapstructure:"include_cancelled"`
+}
+
+// AccountingConfig names the ledger accounts of the accounting export:
+// account names for QuickBooks, account codes for Xero. RevenueAccounts
+// maps plan IDs to their revenue account, DefaultRevenueAccount covering
+// the rest; refunds and credits are booked against RefundAccount, or the
+// revenue account when empty, and tax to TaxAccount. TaxRate is the tax
+// rate Xero lines are imported with.
+type AccountingConfig struct {
+	ReceivableAccount     string            `mapstructure:"receivable_account"`
+	BankAccount           string            `mapstructure:"bank_account"`
+	DefaultRevenueAccount string            `mapstructure:"default_revenue_account"`
+	RevenueAccounts       map[string]string `mapstructure:"revenue_accounts"`
+	RefundAccount         string            `mapstructure:"refund_account"`
+	TaxAccount            string            `mapstructure:"tax_account"`
+	TaxRate               string            `mapstructure:"tax_rate"`
+}
+
+// FeatureFlagConfig is the default state of a flag; runtime changes made
+// through the admin API are stored in Redis and take precedence.
+type FeatureFlagConfig struct {
+	Enabled           bool     `mapstructure:"enabled"`
+	RolloutPercentage int      `mapstructure:"rollout_percentage"`
+	EnabledTenants    []string `mapstructure:"enabled_tenants"`
+	DisabledTenants   []string `mapstructure:"disabled_tenants"`
+}
+
+// SecretsConfig selects an external secret store for credentials that
+// should not live in plaintext config. Refs hold provider-specific secret
+// names; an empty ref keeps the value from config/env.
+type SecretsConfig struct {
+	Provider        string           `mapstructure:"provider"`
+	RefreshInterval int              `mapstructure:"refresh_interval"`
+	Vault           VaultConfig      `mapstructure:"vault"`
+	AWS             AWSSecretsConfig `mapstructure:"aws"`
+	GCP             GCPSecretsConfig `mapstructure:"gcp"`
+	Refs            SecretRefsConfig `mapstructure:"refs"`
+}
+
+type VaultConfig struct {
+	Address   string `mapstructure:"address"`
+	Token     string `mapstructure:"token"`
+	Namespace string `mapstructure:"namespace"`
+}
+
+type AWSSecretsConfig struct {
+	Region string `mapstructure:"region"`
+}
+
+type GCPSecretsConfig struct {
+	ProjectID string `mapstructure:"project_id"`
+}
+
+type SecretRefsConfig struct {
+	PaymentAPIKey        string `mapstructure:"payment_api_key"`
+	PaymentSecretKey     string `mapstructure:"payment_secret_key"`
+	PaymentWebhookSecret string `mapstructure:"payment_webhook_secret"`
+	DatabasePassword     string `mapstructure:"database_password"`
+	EncryptionKeyring    string `mapstructure:"encryption_keyring"`
+}
+
 func Load() (*Config, error) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
@@ -96,6 +789,10 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
 
@@ -105,6 +802,8 @@ func setDefaults() {
 	viper.SetDefault("server.host", "0.0.0.0")
 	viper.SetDefault("server.read_timeout", 15)
 	viper.SetDefault("server.write_timeout", 15)
+	viper.SetDefault("server.request_timeout", 10)
+	viper.SetDefault("server.max_body_bytes", 1<<20)
 
 	// Database defaults
 	viper.SetDefault("database.host", "localhost")
@@ -115,18 +814,46 @@ func setDefaults() {
 	viper.SetDefault("database.max_open_conns", 25)
 	viper.SetDefault("database.max_idle_conns", 5)
 	viper.SetDefault("database.conn_max_lifetime", 300)
+	viper.SetDefault("database.query_timeout", 30)
+	viper.SetDefault("database.exec_timeout", 30)
+	viper.SetDefault("database.slow_query_ms", 200)
 
 	// Cache defaults
 	viper.SetDefault("cache.host", "localhost")
 	viper.SetDefault("cache.port", 6379)
 	viper.SetDefault("cache.db", 0)
 	viper.SetDefault("cache.pool_size", 10)
+	viper.SetDefault("cache.slow_command_ms", 50)
+	viper.SetDefault("cache.namespace", "sp")
+	viper.SetDefault("cache.schema_version", 2)
+	viper.SetDefault("cache.generation_refresh", 10)
+	viper.SetDefault("cache.probe_interval", 5)
+	viper.SetDefault("cache.failure_threshold", 3)
+	viper.SetDefault("cache.warmup.enabled", true)
+	viper.SetDefault("cache.warmup.hot_plans", 20)
+	viper.SetDefault("cache.warmup.recent_subscriptions", 5000)
+	viper.SetDefault("cache.warmup.recent_window", 24)
+	viper.SetDefault("cache.warmup.timeout", 60)
 
 	// Telemetry defaults
 	viper.SetDefault("telemetry.enabled", true)
 	viper.SetDefault("telemetry.service_name", "scalable-paywall")
 	viper.SetDefault("telemetry.environment", "development")
 	viper.SetDefault("telemetry.version", "1.0.0")
+	viper.SetDefault("telemetry.business_metrics_interval", 60)
+	viper.SetDefault("telemetry.pool_stats_interval", 15)
+	viper.SetDefault("telemetry.tracing.enabled", false)
+	viper.SetDefault("telemetry.tracing.otlp_endpoint", "localhost:4318")
+	viper.SetDefault("telemetry.tracing.insecure", true)
+	viper.SetDefault("telemetry.tracing.sample_ratio", 0.1)
+	viper.SetDefault("telemetry.slo.interval", 30)
+	viper.SetDefault("telemetry.slo.timeout", 10)
+	// The multiwindow alerts of the SRE workbook: a page when 2% of a 30-day
+	// budget burns in an hour, a ticket when 5% burns in six
+	viper.SetDefault("telemetry.slo.burn_rates", []map[string]interface{}{
+		{"severity": "page", "long_window": 3600, "short_window": 300, "threshold": 14.4},
+		{"severity": "ticket", "long_window": 21600, "short_window": 1800, "threshold": 6},
+	})
 
 	// Rate limiting defaults
 	viper.SetDefault("rate_limit.enabled", true)
@@ -134,8 +861,168 @@ func setDefaults() {
 	viper.SetDefault("rate_limit.window", 60)
 
 	// Payment gateway defaults
+	viper.SetDefault("payment.enabled", true)
+	viper.SetDefault("payment.dispute_policy", "suspend_on_open")
+	viper.SetDefault("payment.refund_policy", "none")
+	viper.SetDefault("payment.pending_access", "grant")
 	viper.SetDefault("payment.circuit_breaker.enabled", true)
 	viper.SetDefault("payment.circuit_breaker.failure_threshold", 5)
 	viper.SetDefault("payment.circuit_breaker.recovery_timeout", 60)
 	viper.SetDefault("payment.circuit_breaker.half_open_requests", 3)
+	viper.SetDefault("payment.routing.default", "")
+	viper.SetDefault("payment.paypal.base_url", "https://api-m.sandbox.paypal.com")
+	viper.SetDefault("payment.invoicing.due_days", 30)
+	viper.SetDefault("payment.invoicing.cancel_after_days", 14)
+	viper.SetDefault("payment.invoicing.check_interval", 3600)
+	viper.SetDefault("payment.invoicing.number_format", "INV-{year}-{seq}")
+	viper.SetDefault("payment.invoicing.number_digits", 6)
+	viper.SetDefault("payment.checkout.session_ttl", 1800)
+	viper.SetDefault("payment.vat.seller_country", "")
+	viper.SetDefault("payment.vat.vies_url", "https://ec.europa.eu/taxation_customs/vies/rest-api")
+	viper.SetDefault("payment.vat.timeout", 10)
+	viper.SetDefault("payment.checkout.hosted_url", "")
+	viper.SetDefault("payment.risk.enabled", true)
+	viper.SetDefault("payment.risk.velocity_window", 3600)
+	viper.SetDefault("payment.risk.review_after", 5)
+	viper.SetDefault("payment.risk.block_after", 10)
+	viper.SetDefault("payment.risk.provider_timeout", 3)
+
+	// Feature flag defaults
+	viper.SetDefault("feature_flags.metered_paywall.enabled", true)
+	viper.SetDefault("feature_flags.metered_paywall.rollout_percentage", 100)
+	viper.SetDefault("feature_flags.new_gateway.enabled", false)
+	viper.SetDefault("feature_flags.new_gateway.rollout_percentage", 0)
+	viper.SetDefault("feature_flags.dunning.enabled", true)
+	viper.SetDefault("feature_flags.dunning.rollout_percentage", 100)
+
+	// Subscription defaults
+	viper.SetDefault("subscription.reminder_days", []int{7, 1})
+	viper.SetDefault("subscription.reminder_interval", 3600)
+	viper.SetDefault("subscription.lifecycle_interval", 300)
+	viper.SetDefault("subscription.renewal_interval", 60)
+	viper.SetDefault("subscription.renewal_batch_size", 50)
+	viper.SetDefault("subscription.renewal_lead_time", 3600)
+	viper.SetDefault("subscription.claim_lease", 300)
+	viper.SetDefault("subscription.dunning_retry_delays", []int{86400, 259200, 432000})
+	viper.SetDefault("subscription.downgrade_to_free", false)
+	viper.SetDefault("subscription.retention_cooldown_days", 180)
+
+	// Notification defaults
+	viper.SetDefault("notification.timeout", 10)
+
+	// Exchange rate defaults
+	viper.SetDefault("fx.base_currency", "USD")
+	viper.SetDefault("fx.source", "ecb")
+	viper.SetDefault("fx.url", "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml")
+	viper.SetDefault("fx.refresh_interval", 86400)
+
+	// Job runner defaults
+	viper.SetDefault("jobs.workers", 4)
+	viper.SetDefault("jobs.poll_interval", 5)
+	viper.SetDefault("jobs.lock_timeout", 300)
+	viper.SetDefault("jobs.max_attempts", 5)
+	viper.SetDefault("jobs.retry_backoff", 30)
+	viper.SetDefault("jobs.retention_days", 7)
+	viper.SetDefault("pricing.max_age", 300)
+
+	// Paywall defaults
+	viper.SetDefault("paywall.event_buffer", 10000)
+	viper.SetDefault("paywall.event_batch_size", 500)
+	viper.SetDefault("paywall.event_flush_interval", 5)
+	viper.SetDefault("paywall.rate_limit.per_minute", 10)
+	viper.SetDefault("paywall.degraded_policy", "fail_open")
+	viper.SetDefault("paywall.stream.poll_interval", 1)
+	viper.SetDefault("paywall.stream.heartbeat", 25)
+	viper.SetDefault("paywall.leases.ttl", 60)
+	viper.SetDefault("paywall.leases.limit", 1)
+	viper.SetDefault("paywall.abuse.enabled", true)
+	viper.SetDefault("paywall.abuse.window", 86400)
+	viper.SetDefault("paywall.abuse.max_accounts_per_device", 3)
+	viper.SetDefault("paywall.abuse.max_accounts_per_ip", 10)
+	viper.SetDefault("paywall.abuse.max_ips_per_user", 5)
+
+	// Logging defaults
+	viper.SetDefault("logging.redact_fields", []string{
+		"password", "secret", "token", "authorization", "api_?key",
+		"email", "payment_method", "card", "customer",
+	})
+
+	// Encryption defaults
+	viper.SetDefault("encryption.enabled", false)
+	viper.SetDefault("encryption.encrypt_email", false)
+	viper.SetDefault("encryption.rotate_interval", 3600)
+
+	// Object store and retention defaults
+	viper.SetDefault("object_store.provider", "")
+	viper.SetDefault("retention.interval", 3600)
+	viper.SetDefault("retention.batch_size", 5000)
+	viper.SetDefault("export.interval", 300)
+	viper.SetDefault("export.batch_size", 10000)
+	viper.SetDefault("export.lag", 60)
+
+	// Warehouse defaults
+	viper.SetDefault("warehouse.provider", "")
+	viper.SetDefault("warehouse.interval", 300)
+	viper.SetDefault("warehouse.batch_size", 1000)
+	viper.SetDefault("warehouse.lag", 60)
+	viper.SetDefault("warehouse.tables", []string{"subscriptions", "transactions", "plan_changes"})
+	viper.SetDefault("warehouse.snowflake.private_key", "")
+
+	// Accounting defaults
+	viper.SetDefault("accounting.receivable_account", "Accounts Receivable")
+	viper.SetDefault("accounting.bank_account", "Undeposited Funds")
+	viper.SetDefault("accounting.default_revenue_account", "Subscription Revenue")
+	viper.SetDefault("accounting.tax_account", "Sales Tax Payable")
+	viper.SetDefault("accounting.tax_rate", "Tax Exempt")
+
+	// I18n defaults
+	viper.SetDefault("i18n.default_locale", "en")
+	viper.SetDefault("i18n.directory", "")
+
+	// Auth defaults
+	viper.SetDefault("auth.lockout.enabled", true)
+	viper.SetDefault("auth.lockout.window", 900)
+	viper.SetDefault("auth.lockout.max_ip_failures", 20)
+	viper.SetDefault("auth.lockout.max_account_failures", 5)
+	viper.SetDefault("auth.lockout.lockout_duration", 60)
+	viper.SetDefault("auth.lockout.max_lockout_duration", 3600)
+	viper.SetDefault("auth.lockout.captcha_after", 3)
+	viper.SetDefault("auth.captcha.provider", "")
+	viper.SetDefault("auth.captcha.verify_url", "")
+	viper.SetDefault("auth.captcha.secret", "")
+	viper.SetDefault("auth.captcha.timeout", 5)
+	viper.SetDefault("auth.two_factor.issuer", "Subscription API")
+	viper.SetDefault("auth.two_factor.skew", 1)
+	viper.SetDefault("auth.two_factor.recovery_codes", 10)
+	viper.SetDefault("auth.two_factor.require_for_admins", false)
+	viper.SetDefault("auth.two_factor.step_up_ttl", 300)
+	viper.SetDefault("auth.admin_cidrs", []string{})
+	viper.SetDefault("auth.request_signing.required", false)
+	viper.SetDefault("auth.request_signing.tolerance", 300)
+
+	// Error reporting defaults
+	viper.SetDefault("error_reporting.provider", "")
+	viper.SetDefault("error_reporting.dsn", "")
+	viper.SetDefault("error_reporting.access_token", "")
+	viper.SetDefault("error_reporting.timeout", 5)
+
+	// Imports defaults
+	viper.SetDefault("imports.stripe.api_url", "https://api.stripe.com")
+	viper.SetDefault("imports.stripe.api_key", "")
+	viper.SetDefault("imports.stripe.create_plans", false)
+	viper.SetDefault("imports.stripe.include_cancelled", false)
+
+	// CRM defaults
+	viper.SetDefault("crm.provider", "")
+	viper.SetDefault("crm.interval", 60)
+	viper.SetDefault("crm.batch_size", 500)
+	viper.SetDefault("crm.lag", 30)
+	viper.SetDefault("crm.events", []string{"new", "upgraded", "churn_risk", "cancelled"})
+	viper.SetDefault("crm.hubspot.token", "")
+	viper.SetDefault("crm.salesforce.client_secret", "")
+	viper.SetDefault("crm.salesforce.api_version", "v59.0")
+
+	// Secrets defaults
+	viper.SetDefault("secrets.provider", "")
+	viper.SetDefault("secrets.refresh_interval", 300)
 }
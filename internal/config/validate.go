@@ -0,0 +1,1092 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/language"
+)
+
+var validSSLModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+var validDisputePolicies = map[string]bool{
+	"suspend_on_open": true,
+	"suspend_on_loss": true,
+	"none":            true,
+}
+
+var validGateways = map[string]bool{
+	"simulated": true,
+	"stripe":    true,
+	"paypal":    true,
+}
+
+var validPendingAccess = map[string]bool{
+	"grant": true,
+	"deny":  true,
+}
+
+var validRefundPolicies = map[string]bool{
+	"refund": true,
+	"credit": true,
+	"none":   true,
+}
+
+var validSecretsProviders = map[string]bool{
+	"":      true,
+	"vault": true,
+	"aws":   true,
+	"gcp":   true,
+}
+
+var validObjectStoreProviders = map[string]bool{
+	"":     true,
+	"s3":   true,
+	"gcs":  true,
+	"file": true,
+}
+
+// validSLODomains are the domains timed in domain_operation_duration_seconds
+var validSLODomains = map[string]bool{
+	"plan":         true,
+	"subscription": true,
+	"paywall":      true,
+	"payment":      true,
+}
+
+// validDegradedPolicies decide paywall enforcement while Redis is down
+var validDegradedPolicies = map[string]bool{
+	"fail_open":   true,
+	"fail_closed": true,
+}
+
+// validRetentionTables are the tables a retention policy can name
+var validRetentionTables = map[string]bool{
+	"webhook_events":      true,
+	"usage_logs":          true,
+	"subscription_events": true,
+	"paywall_events":      true,
+	"checkout_sessions":   true,
+}
+
+// validExportStreams are the event tables the exporter can copy
+var validExportStreams = map[string]bool{
+	"subscription_events": true,
+	"paywall_events":      true,
+}
+
+var validWarehouseProviders = map[string]bool{
+	"":          true,
+	"bigquery":  true,
+	"snowflake": true,
+}
+
+// validWarehouseTables are the tables the warehouse sync can replicate
+var validWarehouseTables = map[string]bool{
+	"subscriptions": true,
+	"transactions":  true,
+	"plan_changes":  true,
+}
+
+var validCaptchaProviders = map[string]bool{
+	"":          true,
+	"hcaptcha":  true,
+	"recaptcha": true,
+	"turnstile": true,
+}
+
+var validCRMProviders = map[string]bool{
+	"":           true,
+	"hubspot":    true,
+	"salesforce": true,
+}
+
+var validCRMEvents = map[string]bool{
+	"new":        true,
+	"upgraded":   true,
+	"downgraded": true,
+	"churn_risk": true,
+	"cancelled":  true,
+}
+
+// validCRMAttributes are the subscription attributes CRM fields can map
+var validCRMAttributes = map[string]bool{
+	"user_id":         true,
+	"email":           true,
+	"username":        true,
+	"subscription_id": true,
+	"plan_id":         true,
+	"plan_name":       true,
+	"status":          true,
+	"lifecycle":       true,
+	"stage":           true,
+	"amount":          true,
+	"currency":        true,
+	"start_date":      true,
+	"end_date":        true,
+	"auto_renew":      true,
+}
+
+var validRetentionActions = map[string]bool{
+	"archive": true,
+	"purge":   true,
+}
+
+// euMemberStates are the ISO codes of the countries VAT IDs are checked for
+// in VIES
+var euMemberStates = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CY": true, "CZ": true, "DE": true,
+	"DK": true, "EE": true, "ES": true, "FI": true, "FR": true, "GR": true,
+	"HR": true, "HU": true, "IE": true, "IT": true, "LT": true, "LU": true,
+	"LV": true, "MT": true, "NL": true, "PL": true, "PT": true, "RO": true,
+	"SE": true, "SI": true, "SK": true,
+}
+
+var invoiceNumberFields = map[string]bool{
+	"{seq}":      true,
+	"{tenant}":   true,
+	"{country}":  true,
+	"{currency}": true,
+	"{year}":     true,
+	"{month}":    true,
+}
+
+var invoiceNumberField = regexp.MustCompile(`{[^{}]*}`)
+
+// ValidationError lists every problem found in a Config so operators can fix
+// them in one pass instead of restarting once per mistake.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration:\n  - %s", strings.Join(e.Problems, "\n  - "))
+}
+
+// Validate checks required fields and value ranges, plus stricter rules when
+// telemetry.environment is "production". It returns a *ValidationError
+// listing all problems, or nil.
+func (c *Config) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// Server
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		addf("server.port must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if c.Server.ReadTimeout <= 0 {
+		addf("server.read_timeout must be positive")
+	}
+	if c.Server.WriteTimeout <= 0 {
+		addf("server.write_timeout must be positive")
+	}
+	if c.Server.RequestTimeout < 0 {
+		addf("server.request_timeout must not be negative")
+	}
+	if c.Server.MaxBodyBytes < 0 {
+		addf("server.max_body_bytes must not be negative")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			addf("server.trusted_proxies entry %q is not an IP address or CIDR range", proxy)
+		}
+	}
+	for i, route := range c.Server.RouteTimeouts {
+		if route.Method == "" || route.Path == "" {
+			addf("server.route_timeouts[%d] needs both method and path", i)
+		}
+		if route.Timeout <= 0 {
+			addf("server.route_timeouts[%d].timeout must be positive", i)
+		}
+	}
+
+	// Database
+	if c.Database.Host == "" {
+		addf("database.host is required")
+	}
+	if c.Database.DBName == "" {
+		addf("database.dbname is required")
+	}
+	if c.Database.User == "" {
+		addf("database.user is required")
+	}
+	if !validSSLModes[c.Database.SSLMode] {
+		addf("database.sslmode %q is not one of disable, allow, prefer, require, verify-ca, verify-full", c.Database.SSLMode)
+	}
+	if c.Database.MaxOpenConns <= 0 {
+		addf("database.max_open_conns must be positive")
+	}
+	if c.Database.MaxIdleConns <= 0 {
+		addf("database.max_idle_conns must be positive")
+	} else if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		addf("database.max_idle_conns (%d) must not exceed max_open_conns (%d)", c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	}
+	if c.Database.QueryTimeout < 0 {
+		addf("database.query_timeout must not be negative")
+	}
+	if c.Database.ExecTimeout < 0 {
+		addf("database.exec_timeout must not be negative")
+	}
+	if c.Database.SlowQueryMs < 0 {
+		addf("database.slow_query_ms must not be negative")
+	}
+	for name, timeout := range c.Database.NamedTimeouts {
+		if timeout <= 0 {
+			addf("database.named_timeouts.%s must be positive", name)
+		}
+	}
+
+	// Cache
+	if c.Cache.Host == "" {
+		addf("cache.host is required")
+	}
+	if c.Cache.PoolSize <= 0 {
+		addf("cache.pool_size must be positive")
+	}
+	if c.Cache.SlowCommandMs < 0 {
+		addf("cache.slow_command_ms must not be negative")
+	}
+	if c.Cache.Namespace == "" {
+		addf("cache.namespace is required")
+	} else if strings.Contains(c.Cache.Namespace, ":") {
+		addf("cache.namespace %q must not contain ':'", c.Cache.Namespace)
+	}
+	if c.Cache.SchemaVersion <= 0 {
+		addf("cache.schema_version must be positive")
+	}
+	if c.Cache.GenerationRefresh <= 0 {
+		addf("cache.generation_refresh must be positive")
+	}
+	if c.Cache.ProbeInterval <= 0 {
+		addf("cache.probe_interval must be positive")
+	}
+	if c.Cache.FailureThreshold <= 0 {
+		addf("cache.failure_threshold must be positive")
+	}
+	if w := c.Cache.Warmup; w.Enabled {
+		if w.HotPlans < 0 {
+			addf("cache.warmup.hot_plans must not be negative")
+		}
+		if w.RecentSubscriptions < 0 {
+			addf("cache.warmup.recent_subscriptions must not be negative")
+		}
+		if w.RecentWindow <= 0 {
+			addf("cache.warmup.recent_window must be positive")
+		}
+		if w.Timeout <= 0 {
+			addf("cache.warmup.timeout must be positive")
+		}
+	}
+
+	// Telemetry
+	if c.Telemetry.Enabled && c.Telemetry.PoolStatsInterval <= 0 {
+		addf("telemetry.pool_stats_interval must be positive")
+	}
+	if c.Telemetry.Tracing.Enabled {
+		if c.Telemetry.Tracing.OTLPEndpoint == "" {
+			addf("telemetry.tracing.otlp_endpoint is required when tracing is enabled")
+		}
+		if c.Telemetry.Tracing.SampleRatio < 0 || c.Telemetry.Tracing.SampleRatio > 1 {
+			addf("telemetry.tracing.sample_ratio must be between 0 and 1")
+		}
+	}
+	if slo := c.Telemetry.SLO; len(slo.Objectives) > 0 {
+		if slo.Interval <= 0 {
+			addf("telemetry.slo.interval must be positive when objectives are configured")
+		}
+		if slo.WebhookURL != "" {
+			if u, err := url.Parse(slo.WebhookURL); err != nil || u.Scheme == "" || u.Host == "" {
+				addf("telemetry.slo.webhook_url %q is not an absolute URL", slo.WebhookURL)
+			}
+			if slo.Timeout <= 0 {
+				addf("telemetry.slo.timeout must be positive")
+			}
+		}
+		names := map[string]bool{}
+		for i, objective := range slo.Objectives {
+			if objective.Name == "" {
+				addf("telemetry.slo.objectives[%d].name is required", i)
+			} else if names[objective.Name] {
+				addf("telemetry.slo.objectives[%d].name %q is listed twice", i, objective.Name)
+			}
+			names[objective.Name] = true
+			if !validSLODomains[objective.Domain] {
+				addf("telemetry.slo.objectives[%d].domain %q is not one of payment, paywall, plan, subscription", i, objective.Domain)
+			}
+			if objective.Availability == 0 && objective.LatencyTarget == 0 {
+				addf("telemetry.slo.objectives[%d] needs an availability or latency_target", i)
+			}
+			if objective.Availability < 0 || objective.Availability >= 1 {
+				addf("telemetry.slo.objectives[%d].availability must be between 0 and 1", i)
+			}
+			if objective.LatencyTarget < 0 || objective.LatencyTarget >= 1 {
+				addf("telemetry.slo.objectives[%d].latency_target must be between 0 and 1", i)
+			}
+			if objective.LatencyTarget > 0 && objective.LatencyMS <= 0 {
+				addf("telemetry.slo.objectives[%d].latency_ms must be positive with a latency_target", i)
+			}
+		}
+		if len(slo.BurnRates) == 0 {
+			addf("telemetry.slo.burn_rates must not be empty when objectives are configured")
+		}
+		severities := map[string]bool{}
+		for i, rate := range slo.BurnRates {
+			if rate.Severity == "" {
+				addf("telemetry.slo.burn_rates[%d].severity is required", i)
+			} else if severities[rate.Severity] {
+				addf("telemetry.slo.burn_rates[%d].severity %q is listed twice", i, rate.Severity)
+			}
+			severities[rate.Severity] = true
+			if rate.ShortWindow <= 0 || rate.LongWindow <= rate.ShortWindow {
+				addf("telemetry.slo.burn_rates[%d] needs a positive short_window under long_window", i)
+			}
+			if rate.Threshold <= 0 {
+				addf("telemetry.slo.burn_rates[%d].threshold must be positive", i)
+			}
+		}
+	}
+
+	// Rate limiting
+	if c.RateLimit.Enabled && (c.RateLimit.RequestsPer <= 0 || c.RateLimit.Window <= 0) {
+		addf("rate_limit.requests_per and rate_limit.window must be positive when rate limiting is enabled")
+	}
+
+	// Payment
+	if c.Payment.Enabled {
+		if c.Payment.GatewayURL == "" {
+			addf("payment.gateway_url is required when payments are enabled")
+		} else if u, err := url.Parse(c.Payment.GatewayURL); err != nil || u.Scheme == "" || u.Host == "" {
+			addf("payment.gateway_url %q is not an absolute URL", c.Payment.GatewayURL)
+		}
+		if c.Payment.CircuitBreaker.Enabled && c.Payment.CircuitBreaker.FailureThreshold <= 0 {
+			addf("payment.circuit_breaker.failure_threshold must be positive")
+		}
+		if !validDisputePolicies[c.Payment.DisputePolicy] {
+			addf("payment.dispute_policy %q must be suspend_on_open, suspend_on_loss or none", c.Payment.DisputePolicy)
+		}
+		if !validRefundPolicies[c.Payment.RefundPolicy] {
+			addf("payment.refund_policy %q must be refund, credit or none", c.Payment.RefundPolicy)
+		}
+		for tenant, policy := range c.Payment.TenantRefundPolicies {
+			if !validRefundPolicies[policy] {
+				addf("payment.tenant_refund_policies.%s %q must be refund, credit or none", tenant, policy)
+			}
+		}
+		if !validPendingAccess[c.Payment.PendingAccess] {
+			addf("payment.pending_access %q must be grant or deny", c.Payment.PendingAccess)
+		}
+		invoicing := c.Payment.Invoicing
+		if invoicing.DueDays <= 0 || invoicing.CheckInterval <= 0 {
+			addf("payment.invoicing.due_days and check_interval must be positive")
+		}
+		if invoicing.CancelAfterDays < 0 {
+			addf("payment.invoicing.cancel_after_days must not be negative")
+		}
+		if problem := invoiceNumberProblem(invoicing.NumberFormat); problem != "" {
+			addf("payment.invoicing.number_format %q %s", invoicing.NumberFormat, problem)
+		}
+		for _, tenant := range sortedKeys(invoicing.TenantNumberFormats) {
+			format := invoicing.TenantNumberFormats[tenant]
+			if problem := invoiceNumberProblem(format); problem != "" {
+				addf("payment.invoicing.tenant_number_formats.%s %q %s", tenant, format, problem)
+			}
+		}
+		if invoicing.NumberDigits < 1 || invoicing.NumberDigits > 12 {
+			addf("payment.invoicing.number_digits must be between 1 and 12")
+		}
+		checkout := c.Payment.Checkout
+		if checkout.SessionTTL <= 0 {
+			addf("payment.checkout.session_ttl must be positive")
+		}
+		if checkout.HostedURL != "" {
+			if u, err := url.Parse(checkout.HostedURL); err != nil || u.Scheme == "" || u.Host == "" {
+				addf("payment.checkout.hosted_url %q is not an absolute URL", checkout.HostedURL)
+			}
+		}
+		coupons := make(map[string]bool, len(checkout.Coupons))
+		for i, coupon := range checkout.Coupons {
+			code := strings.ToLower(coupon.Code)
+			if code == "" {
+				addf("payment.checkout.coupons[%d].code is required", i)
+			} else if coupons[code] {
+				addf("payment.checkout.coupons[%d].code %q is used by another coupon", i, coupon.Code)
+			}
+			coupons[code] = true
+			if coupon.PercentOff < 1 || coupon.PercentOff > 100 {
+				addf("payment.checkout.coupons[%d].percent_off must be between 1 and 100", i)
+			}
+			if coupon.Renewals < 0 {
+				addf("payment.checkout.coupons[%d].renewals must not be negative", i)
+			}
+		}
+		vat := c.Payment.VAT
+		if vat.SellerCountry != "" && !euMemberStates[vat.SellerCountry] {
+			addf("payment.vat.seller_country %q is not the ISO code of an EU member state", vat.SellerCountry)
+		}
+		if u, err := url.Parse(vat.VIESURL); err != nil || u.Scheme == "" || u.Host == "" {
+			addf("payment.vat.vies_url %q is not an absolute URL", vat.VIESURL)
+		}
+		if vat.Timeout <= 0 {
+			addf("payment.vat.timeout must be positive")
+		}
+		routing := c.Payment.Routing
+		usesPayPal := routing.Default == "paypal"
+		if routing.Default != "" && !validGateways[routing.Default] {
+			addf("payment.routing.default %q is not one of simulated, stripe, paypal", routing.Default)
+		}
+		currencies := make([]string, 0, len(routing.Currencies))
+		for currency := range routing.Currencies {
+			currencies = append(currencies, currency)
+		}
+		sort.Strings(currencies)
+		for _, currency := range currencies {
+			gateway := routing.Currencies[currency]
+			if !validGateways[gateway] {
+				addf("payment.routing.currencies.%s %q is not one of simulated, stripe, paypal", currency, gateway)
+			}
+			usesPayPal = usesPayPal || gateway == "paypal"
+		}
+		if usesPayPal {
+			if u, err := url.Parse(c.Payment.PayPal.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+				addf("payment.paypal.base_url %q is not an absolute URL", c.Payment.PayPal.BaseURL)
+			}
+			if c.Payment.PayPal.ClientID == "" || c.Payment.PayPal.ClientSecret == "" {
+				addf("payment.paypal.client_id and client_secret are required when payments are routed to paypal")
+			}
+			if c.Payment.PayPal.WebhookID == "" {
+				addf("payment.paypal.webhook_id is required when payments are routed to paypal")
+			}
+		}
+		if risk := c.Payment.Risk; risk.Enabled {
+			if risk.VelocityWindow <= 0 {
+				addf("payment.risk.velocity_window must be positive")
+			}
+			if risk.ReviewAfter <= 0 {
+				addf("payment.risk.review_after must be positive")
+			}
+			if risk.BlockAfter < risk.ReviewAfter {
+				addf("payment.risk.block_after must be at least payment.risk.review_after")
+			}
+			if risk.ProviderURL != "" {
+				if u, err := url.Parse(risk.ProviderURL); err != nil || u.Scheme == "" || u.Host == "" {
+					addf("payment.risk.provider_url %q is not an absolute URL", risk.ProviderURL)
+				}
+				if risk.ProviderTimeout <= 0 {
+					addf("payment.risk.provider_timeout must be positive")
+				}
+			}
+		}
+	}
+
+	// Logging
+	for i, pattern := range c.Logging.RedactFields {
+		if _, err := regexp.Compile(pattern); err != nil {
+			addf("logging.redact_fields[%d] %q is not a valid regular expression", i, pattern)
+		}
+	}
+
+	// Encryption; the keys are checked only when they are not sourced from
+	// the secret store
+	if enc := c.Encryption; enc.Enabled {
+		if enc.RotateInterval <= 0 {
+			addf("encryption.rotate_interval must be positive")
+		}
+		if c.Secrets.Refs.EncryptionKeyring == "" {
+			if _, ok := enc.Keys[enc.ActiveKey]; !ok {
+				addf("encryption.active_key %q is not one of encryption.keys", enc.ActiveKey)
+			}
+			ids := make([]string, 0, len(enc.Keys))
+			for id := range enc.Keys {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			for _, id := range ids {
+				if strings.Contains(id, ":") {
+					addf("encryption.keys.%s: key IDs must not contain ':'", id)
+				}
+				if key, err := base64.StdEncoding.DecodeString(enc.Keys[id]); err != nil || len(key) != 32 {
+					addf("encryption.keys.%s must be a base64-encoded 32-byte key", id)
+				}
+			}
+			if enc.EncryptEmail {
+				if key, err := base64.StdEncoding.DecodeString(enc.IndexKey); err != nil || len(key) < 32 {
+					addf("encryption.index_key must be a base64-encoded key of at least 32 bytes when encrypt_email is on")
+				}
+			}
+		}
+	}
+
+	// Object store and retention
+	store := c.ObjectStore
+	switch provider := strings.ToLower(store.Provider); {
+	case !validObjectStoreProviders[provider]:
+		addf("object_store.provider %q is not one of s3, gcs, file", store.Provider)
+	case provider == "file":
+		if store.Directory == "" {
+			addf("object_store.directory is required for the file provider")
+		}
+	case provider != "":
+		if store.Bucket == "" {
+			addf("object_store.bucket is required for the %s provider", provider)
+		}
+		if provider == "s3" && store.Region == "" && store.Endpoint == "" {
+			addf("object_store.region is required for the s3 provider")
+		}
+	}
+	if len(c.Retention.Policies) > 0 {
+		if c.Retention.Interval <= 0 {
+			addf("retention.interval must be positive when retention policies are configured")
+		}
+		if c.Retention.BatchSize <= 0 {
+			addf("retention.batch_size must be positive when retention policies are configured")
+		}
+	}
+	seenTables := map[string]bool{}
+	for i, policy := range c.Retention.Policies {
+		if !validRetentionTables[policy.Table] {
+			addf("retention.policies[%d].table %q is not a table retention can apply to", i, policy.Table)
+		} else if seenTables[policy.Table] {
+			addf("retention.policies[%d].table %q has more than one policy", i, policy.Table)
+		}
+		seenTables[policy.Table] = true
+		if policy.Days < 1 {
+			addf("retention.policies[%d].days must be at least 1", i)
+		}
+		if !validRetentionActions[policy.Action] {
+			addf("retention.policies[%d].action %q is not one of archive, purge", i, policy.Action)
+		} else if policy.Action == "archive" && store.Provider == "" {
+			addf("retention.policies[%d] archives %s but no object_store.provider is set", i, policy.Table)
+		}
+	}
+	if len(c.Export.Streams) > 0 {
+		if store.Provider == "" {
+			addf("export.streams are set but no object_store.provider is set")
+		}
+		if c.Export.Interval <= 0 {
+			addf("export.interval must be positive when export streams are configured")
+		}
+		if c.Export.BatchSize <= 0 {
+			addf("export.batch_size must be positive when export streams are configured")
+		}
+		if c.Export.Lag < 0 {
+			addf("export.lag must not be negative")
+		}
+	}
+	seenStreams := map[string]bool{}
+	for _, stream := range c.Export.Streams {
+		if !validExportStreams[stream] {
+			addf("export.streams: %q is not one of subscription_events, paywall_events", stream)
+		} else if seenStreams[stream] {
+			addf("export.streams: %q is listed more than once", stream)
+		}
+		seenStreams[stream] = true
+	}
+
+	// Warehouse
+	wh := c.Warehouse
+	switch provider := strings.ToLower(wh.Provider); {
+	case !validWarehouseProviders[provider]:
+		addf("warehouse.provider %q is not one of bigquery, snowflake", wh.Provider)
+	case provider == "bigquery":
+		if wh.BigQuery.ProjectID == "" || wh.BigQuery.Dataset == "" {
+			addf("warehouse.bigquery.project_id and dataset are required for the bigquery provider")
+		}
+	case provider == "snowflake":
+		sf := wh.Snowflake
+		if sf.Account == "" || sf.User == "" || sf.PrivateKey == "" {
+			addf("warehouse.snowflake.account, user and private_key are required for the snowflake provider")
+		}
+		if sf.Database == "" || sf.Schema == "" || sf.Warehouse == "" {
+			addf("warehouse.snowflake.database, schema and warehouse are required for the snowflake provider")
+		}
+	}
+	if wh.Provider != "" {
+		if wh.Interval <= 0 {
+			addf("warehouse.interval must be positive when a warehouse provider is set")
+		}
+		if wh.BatchSize <= 0 {
+			addf("warehouse.batch_size must be positive when a warehouse provider is set")
+		}
+		if wh.Lag < 0 {
+			addf("warehouse.lag must not be negative")
+		}
+		for _, table := range wh.Tables {
+			if !validWarehouseTables[table] {
+				addf("warehouse.tables: %q is not one of subscriptions, transactions, plan_changes", table)
+			}
+		}
+	}
+
+	// CRM
+	crm := c.CRM
+	switch provider := strings.ToLower(crm.Provider); {
+	case !validCRMProviders[provider]:
+		addf("crm.provider %q is not one of hubspot, salesforce", crm.Provider)
+	case provider == "hubspot":
+		if crm.HubSpot.Token == "" || crm.HubSpot.DealIDProperty == "" {
+			addf("crm.hubspot.token and deal_id_property are required for the hubspot provider")
+		}
+	case provider == "salesforce":
+		sf := crm.Salesforce
+		if sf.InstanceURL == "" || sf.ClientID == "" || sf.ClientSecret == "" {
+			addf("crm.salesforce.instance_url, client_id and client_secret are required for the salesforce provider")
+		}
+		if sf.ContactExternalID == "" || sf.DealExternalID == "" {
+			addf("crm.salesforce.contact_external_id and deal_external_id are required for the salesforce provider")
+		}
+	}
+	if crm.Provider != "" {
+		if crm.Interval <= 0 {
+			addf("crm.interval must be positive when a CRM provider is set")
+		}
+		if crm.BatchSize <= 0 {
+			addf("crm.batch_size must be positive when a CRM provider is set")
+		}
+		if crm.Lag < 0 {
+			addf("crm.lag must not be negative")
+		}
+	}
+	for _, event := range crm.Events {
+		if !validCRMEvents[event] {
+			addf("crm.events: %q is not one of new, upgraded, downgraded, churn_risk, cancelled", event)
+		}
+	}
+	for _, fields := range []struct {
+		name   string
+		fields map[string]string
+	}{{"crm.contact_fields", crm.ContactFields}, {"crm.deal_fields", crm.DealFields}} {
+		for _, attribute := range sortedKeys(fields.fields) {
+			if !validCRMAttributes[attribute] {
+				addf("%s.%s is not a subscription attribute", fields.name, attribute)
+			}
+		}
+	}
+	for _, event := range sortedKeys(crm.Stages) {
+		if !validCRMEvents[event] {
+			addf("crm.stages.%s is not a lifecycle event", event)
+		}
+	}
+
+	// Accounting
+	if c.Accounting.ReceivableAccount == "" || c.Accounting.BankAccount == "" || c.Accounting.DefaultRevenueAccount == "" {
+		addf("accounting.receivable_account, bank_account and default_revenue_account are required")
+	}
+	for _, planID := range sortedKeys(c.Accounting.RevenueAccounts) {
+		if c.Accounting.RevenueAccounts[planID] == "" {
+			addf("accounting.revenue_accounts.%s must name an account", planID)
+		}
+	}
+
+	// I18n
+	if _, err := language.Parse(c.I18n.DefaultLocale); err != nil || c.I18n.DefaultLocale == "" {
+		addf("i18n.default_locale %q is not a BCP 47 language tag", c.I18n.DefaultLocale)
+	}
+
+	// Auth
+	lockout := c.Auth.Lockout
+	if lockout.Enabled {
+		if lockout.Window <= 0 || lockout.MaxIPFailures <= 0 || lockout.MaxAccountFailures <= 0 || lockout.LockoutDuration <= 0 {
+			addf("auth.lockout.window, max_ip_failures, max_account_failures and lockout_duration must be positive when lockout is enabled")
+		}
+		if lockout.MaxLockoutDuration < lockout.LockoutDuration {
+			addf("auth.lockout.max_lockout_duration must be at least lockout_duration")
+		}
+		if lockout.CaptchaAfter < 0 {
+			addf("auth.lockout.captcha_after must not be negative")
+		}
+	}
+	captcha := c.Auth.Captcha
+	if !validCaptchaProviders[strings.ToLower(captcha.Provider)] {
+		addf("auth.captcha.provider %q is not one of hcaptcha, recaptcha, turnstile", captcha.Provider)
+	} else if captcha.Provider != "" {
+		if captcha.Secret == "" {
+			addf("auth.captcha.secret is required when a CAPTCHA provider is set")
+		}
+		if captcha.Timeout <= 0 {
+			addf("auth.captcha.timeout must be positive when a CAPTCHA provider is set")
+		}
+	}
+	if captcha.VerifyURL != "" {
+		if u, err := url.Parse(captcha.VerifyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			addf("auth.captcha.verify_url %q is not an absolute URL", captcha.VerifyURL)
+		}
+	}
+	twoFactor := c.Auth.TwoFactor
+	if twoFactor.Issuer == "" || strings.Contains(twoFactor.Issuer, ":") {
+		addf("auth.two_factor.issuer %q must be set and not contain a colon", twoFactor.Issuer)
+	}
+	if twoFactor.Skew < 0 || twoFactor.Skew > 10 {
+		addf("auth.two_factor.skew must be between 0 and 10")
+	}
+	if twoFactor.RecoveryCodes < 1 || twoFactor.RecoveryCodes > 50 {
+		addf("auth.two_factor.recovery_codes must be between 1 and 50")
+	}
+	if twoFactor.StepUpTTL <= 0 {
+		addf("auth.two_factor.step_up_ttl must be positive")
+	}
+	for _, cidr := range c.Auth.AdminCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			addf("auth.admin_cidrs entry %q is not an IP address or CIDR range", cidr)
+		}
+	}
+	if c.Auth.RequestSigning.Tolerance <= 0 {
+		addf("auth.request_signing.tolerance must be positive")
+	}
+
+	// Error reporting
+	reporting := c.ErrorReporting
+	switch strings.ToLower(reporting.Provider) {
+	case "":
+	case "sentry":
+		if u, err := url.Parse(reporting.DSN); err != nil || u.Scheme == "" || u.Host == "" || u.User == nil || strings.Trim(u.Path, "/") == "" {
+			addf("error_reporting.dsn must be a Sentry DSN (https://<key>@<host>/<project>) for the sentry provider")
+		}
+	case "rollbar":
+		if reporting.AccessToken == "" {
+			addf("error_reporting.access_token is required for the rollbar provider")
+		}
+	default:
+		addf("error_reporting.provider %q is not one of sentry, rollbar", reporting.Provider)
+	}
+	if reporting.Provider != "" && reporting.Timeout <= 0 {
+		addf("error_reporting.timeout must be positive")
+	}
+
+	// Imports
+	stripe := c.Imports.Stripe
+	if u, err := url.Parse(stripe.APIURL); err != nil || u.Scheme == "" || u.Host == "" {
+		addf("imports.stripe.api_url %q is not an absolute URL", stripe.APIURL)
+	}
+	for _, stripeID := range sortedKeys(stripe.Plans) {
+		if _, err := uuid.Parse(stripe.Plans[stripeID]); err != nil {
+			addf("imports.stripe.plans.%s %q is not a plan ID", stripeID, stripe.Plans[stripeID])
+		}
+	}
+
+	// Secrets
+	if !validSecretsProviders[strings.ToLower(c.Secrets.Provider)] {
+		addf("secrets.provider %q is not one of vault, aws, gcp", c.Secrets.Provider)
+	}
+	if c.Secrets.Provider != "" && c.Secrets.RefreshInterval <= 0 {
+		addf("secrets.refresh_interval must be positive when a secrets provider is set")
+	}
+
+	// Subscriptions
+	for _, days := range c.Subscription.ReminderDays {
+		if days <= 0 {
+			addf("subscription.reminder_days must contain positive day counts, got %d", days)
+		}
+	}
+	if len(c.Subscription.ReminderDays) > 0 && c.Subscription.ReminderInterval <= 0 {
+		addf("subscription.reminder_interval must be positive when reminders are configured")
+	}
+	if c.Subscription.RenewalInterval > 0 {
+		if c.Subscription.RenewalBatchSize <= 0 {
+			addf("subscription.renewal_batch_size must be positive when renewals are enabled")
+		}
+		if c.Subscription.RenewalLeadTime < 0 {
+			addf("subscription.renewal_lead_time must not be negative")
+		}
+		if c.Subscription.ClaimLease <= 0 {
+			addf("subscription.claim_lease must be positive when renewals are enabled")
+		}
+		for _, delay := range c.Subscription.DunningRetryDelays {
+			if delay <= 0 {
+				addf("subscription.dunning_retry_delays must contain positive delays, got %d", delay)
+			}
+		}
+	}
+
+	offerKeys := make(map[string]bool, len(c.Subscription.RetentionOffers))
+	for i, offer := range c.Subscription.RetentionOffers {
+		if offer.Key == "" {
+			addf("subscription.retention_offers[%d].key is required", i)
+		} else if offerKeys[offer.Key] {
+			addf("subscription.retention_offers[%d].key %q is listed twice", i, offer.Key)
+		}
+		offerKeys[offer.Key] = true
+		switch offer.Type {
+		case "discount":
+			if offer.PercentOff <= 0 || offer.PercentOff > 100 {
+				addf("subscription.retention_offers[%d].percent_off must be between 1 and 100", i)
+			}
+			if offer.Renewals <= 0 {
+				addf("subscription.retention_offers[%d].renewals must be positive for a discount", i)
+			}
+		case "pause":
+			if offer.PauseMonths <= 0 || offer.PauseMonths > 12 {
+				addf("subscription.retention_offers[%d].pause_months must be between 1 and 12", i)
+			}
+		default:
+			addf("subscription.retention_offers[%d].type %q must be discount or pause", i, offer.Type)
+		}
+		if offer.MinTenureDays < 0 {
+			addf("subscription.retention_offers[%d].min_tenure_days must not be negative", i)
+		}
+	}
+	if c.Subscription.RetentionCooldownDays < 0 {
+		addf("subscription.retention_cooldown_days must not be negative")
+	}
+
+	// Notifications
+	if c.Notification.WebhookURL != "" {
+		if u, err := url.Parse(c.Notification.WebhookURL); err != nil || u.Scheme == "" || u.Host == "" {
+			addf("notification.webhook_url %q is not an absolute URL", c.Notification.WebhookURL)
+		}
+	}
+
+	// Exchange rates
+	if len(c.FX.BaseCurrency) != 3 {
+		addf("fx.base_currency must be a 3-letter currency code, got %q", c.FX.BaseCurrency)
+	}
+	switch strings.ToLower(c.FX.Source) {
+	case "ecb":
+		if c.FX.URL == "" {
+			addf("fx.url is required for the ecb source")
+		}
+	case "static":
+		if len(c.FX.StaticRates) == 0 {
+			addf("fx.static_rates must list at least one rate for the static source")
+		}
+	default:
+		addf("fx.source %q is not one of ecb, static", c.FX.Source)
+	}
+	if c.FX.RefreshInterval <= 0 {
+		addf("fx.refresh_interval must be positive")
+	}
+
+	// Jobs
+	if c.Jobs.Workers <= 0 {
+		addf("jobs.workers must be positive")
+	}
+	if c.Jobs.PollInterval <= 0 {
+		addf("jobs.poll_interval must be positive")
+	}
+	if c.Jobs.LockTimeout <= 0 {
+		addf("jobs.lock_timeout must be positive")
+	}
+	if c.Jobs.MaxAttempts <= 0 {
+		addf("jobs.max_attempts must be positive")
+	}
+	if c.Jobs.RetryBackoff <= 0 {
+		addf("jobs.retry_backoff must be positive")
+	}
+	if c.Jobs.RetentionDays <= 0 {
+		addf("jobs.retention_days must be positive")
+	}
+	if c.Subscription.RenewalInterval > 0 && c.Subscription.ClaimLease > c.Jobs.LockTimeout {
+		addf("subscription.claim_lease (%d) must not exceed jobs.lock_timeout (%d)", c.Subscription.ClaimLease, c.Jobs.LockTimeout)
+	}
+
+	// Pricing
+	if c.Pricing.MaxAge < 0 {
+		addf("pricing.max_age must not be negative")
+	}
+
+	// Paywall
+	if c.Paywall.EventBuffer <= 0 {
+		addf("paywall.event_buffer must be positive")
+	}
+	if c.Paywall.EventBatchSize <= 0 {
+		addf("paywall.event_batch_size must be positive")
+	}
+	if c.Paywall.EventFlushInterval <= 0 {
+		addf("paywall.event_flush_interval must be positive")
+	}
+	if c.Paywall.RateLimit.PerMinute <= 0 {
+		addf("paywall.rate_limit.per_minute must be positive")
+	}
+	for action, limit := range c.Paywall.RateLimit.Actions {
+		if limit <= 0 {
+			addf("paywall.rate_limit.actions.%s must be positive", action)
+		}
+	}
+	if c.Paywall.Usage.Timezone != "" && !validTimezone(c.Paywall.Usage.Timezone) {
+		addf("paywall.usage.timezone %q is not a known timezone", c.Paywall.Usage.Timezone)
+	}
+	for tenant, timezone := range c.Paywall.Usage.TenantTimezones {
+		if !validTimezone(timezone) {
+			addf("paywall.usage.tenant_timezones.%s %q is not a known timezone", tenant, timezone)
+		}
+	}
+	if !validDegradedPolicies[c.Paywall.DegradedPolicy] {
+		addf("paywall.degraded_policy %q must be fail_open or fail_closed", c.Paywall.DegradedPolicy)
+	}
+	if c.Paywall.Stream.PollInterval <= 0 {
+		addf("paywall.stream.poll_interval must be positive")
+	}
+	if c.Paywall.Stream.Heartbeat <= 0 {
+		addf("paywall.stream.heartbeat must be positive")
+	}
+	if c.Paywall.Leases.TTL <= 0 {
+		addf("paywall.leases.ttl must be positive")
+	}
+	if c.Paywall.Leases.Limit <= 0 {
+		addf("paywall.leases.limit must be positive")
+	}
+	for action, limit := range c.Paywall.Leases.Actions {
+		if limit <= 0 {
+			addf("paywall.leases.actions.%s must be positive", action)
+		}
+	}
+	if abuse := c.Paywall.Abuse; abuse.Enabled {
+		if abuse.Window <= 0 {
+			addf("paywall.abuse.window must be positive")
+		}
+		if abuse.MaxAccountsPerDevice <= 0 {
+			addf("paywall.abuse.max_accounts_per_device must be positive")
+		}
+		if abuse.MaxAccountsPerIP <= 0 {
+			addf("paywall.abuse.max_accounts_per_ip must be positive")
+		}
+		if abuse.MaxIPsPerUser <= 0 {
+			addf("paywall.abuse.max_ips_per_user must be positive")
+		}
+	}
+	if c.Paywall.UpgradeURL != "" {
+		if u, err := url.Parse(c.Paywall.UpgradeURL); err != nil || u.Scheme == "" || u.Host == "" {
+			addf("paywall.upgrade_url %q is not an absolute URL", c.Paywall.UpgradeURL)
+		} else if !strings.Contains(c.Paywall.UpgradeURL, "{plan_id}") {
+			addf("paywall.upgrade_url must contain {plan_id}")
+		}
+	}
+
+	// Feature catalog
+	seenFeatures := make(map[string]bool)
+	for i, feature := range c.Features {
+		if feature.Key == "" {
+			addf("features[%d].key is required", i)
+		} else if seenFeatures[feature.Key] {
+			addf("features[%d].key %q is listed twice", i, feature.Key)
+		}
+		seenFeatures[feature.Key] = true
+		switch feature.Type {
+		case "bool", "int":
+		case "enum":
+			if len(feature.Values) == 0 {
+				addf("features[%d].values must list the allowed values of enum feature %q", i, feature.Key)
+			}
+		default:
+			addf("features[%d].type %q must be bool, int or enum", i, feature.Type)
+		}
+		switch feature.Metered {
+		case "":
+		case "daily", "monthly":
+			if feature.Type != "int" {
+				addf("features[%d] %q must be an int feature to be metered", i, feature.Key)
+			}
+		default:
+			addf("features[%d].metered %q must be daily or monthly", i, feature.Metered)
+		}
+	}
+
+	// Feature flags
+	for name, flag := range c.FeatureFlags {
+		if flag.RolloutPercentage < 0 || flag.RolloutPercentage > 100 {
+			addf("feature_flags.%s.rollout_percentage must be between 0 and 100", name)
+		}
+	}
+
+	if c.Telemetry.Environment == "production" {
+		problems = append(problems, c.productionProblems()...)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (c *Config) productionProblems() []string {
+	var problems []string
+
+	if c.Database.SSLMode == "disable" || c.Database.SSLMode == "allow" {
+		problems = append(problems, "database.sslmode must be require, verify-ca or verify-full in production")
+	}
+	if c.Database.Password == "" && c.Secrets.Refs.DatabasePassword == "" {
+		problems = append(problems, "database.password (or secrets.refs.database_password) is required in production")
+	}
+
+	if c.Payment.Enabled {
+		if strings.HasPrefix(c.Payment.GatewayURL, "http://") {
+			problems = append(problems, "payment.gateway_url must use https in production")
+		}
+		for _, secret := range []struct {
+			name, value, ref string
+		}{
+			{"payment.api_key", c.Payment.APIKey, c.Secrets.Refs.PaymentAPIKey},
+			{"payment.secret_key", c.Payment.SecretKey, c.Secrets.Refs.PaymentSecretKey},
+			{"payment.webhook_secret", c.Payment.WebhookSecret, c.Secrets.Refs.PaymentWebhookSecret},
+		} {
+			if secret.ref != "" {
+				continue
+			}
+			if secret.value == "" || strings.HasSuffix(secret.value, "...") || strings.Contains(secret.value, "_test_") {
+				problems = append(problems, fmt.Sprintf("%s must be a live credential or a secrets ref in production", secret.name))
+			}
+		}
+
+		// The simulated gateway approves every intent; without a default
+		// route the new_gateway flag leaves users outside it there
+		routing := c.Payment.Routing
+		if routing.Default != "stripe" && routing.Default != "paypal" {
+			problems = append(problems, "payment.routing.default must be stripe or paypal in production")
+		}
+		for _, currency := range sortedKeys(routing.Currencies) {
+			if routing.Currencies[currency] == "simulated" {
+				problems = append(problems, fmt.Sprintf("payment.routing.currencies.%s must not be simulated in production", currency))
+			}
+		}
+	}
+
+	return problems
+}
+
+// validTimezone reports whether name is an IANA timezone name
+func validTimezone(name string) bool {
+	if name == "" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// invoiceNumberProblem says what is wrong with an invoice number format, or
+// returns "" when it holds {seq} once and no unknown fields
+func invoiceNumberProblem(format string) string {
+	if strings.Count(format, "{seq}") != 1 {
+		return "must contain {seq} once"
+	}
+	for _, field := range invoiceNumberField.FindAllString(format, -1) {
+		if !invoiceNumberFields[field] {
+			return fmt.Sprintf("has unknown field %s", field)
+		}
+	}
+	return ""
+}
+
+// sortedKeys lists a map's keys in order, so problems are reported stably
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,658 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validConfig() *Config {
+	return &Config{
+		Server:     ServerConfig{Port: 8080, ReadTimeout: 15, WriteTimeout: 15, RequestTimeout: 10},
+		Database:   DatabaseConfig{Host: "localhost", Port: 5432, User: "postgres", DBName: "paywall", SSLMode: "disable", MaxOpenConns: 25, MaxIdleConns: 5},
+		Cache:      CacheConfig{Host: "localhost", Port: 6379, PoolSize: 10, Namespace: "sp", SchemaVersion: 1, GenerationRefresh: 10, ProbeInterval: 5, FailureThreshold: 3, Warmup: WarmupConfig{Enabled: true, HotPlans: 20, RecentSubscriptions: 5000, RecentWindow: 24, Timeout: 60}},
+		Telemetry:  TelemetryConfig{Environment: "development"},
+		RateLimit:  RateLimitConfig{Enabled: true, RequestsPer: 100, Window: 60},
+		Payment:    PaymentConfig{Enabled: true, GatewayURL: "https://api.stripe.com", APIKey: "sk_test_...", DisputePolicy: "suspend_on_open", RefundPolicy: "none", PendingAccess: "grant", Invoicing: InvoicingConfig{DueDays: 30, CancelAfterDays: 14, CheckInterval: 3600, NumberFormat: "INV-{year}-{seq}", NumberDigits: 6}, Checkout: CheckoutConfig{SessionTTL: 1800}, VAT: VATConfig{VIESURL: "https://ec.europa.eu/taxation_customs/vies/rest-api", Timeout: 10}},
+		FX:         FXConfig{BaseCurrency: "USD", Source: "ecb", URL: "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml", RefreshInterval: 86400},
+		Jobs:       JobsConfig{Workers: 4, PollInterval: 5, LockTimeout: 300, MaxAttempts: 5, RetryBackoff: 30, RetentionDays: 7},
+		Paywall:    PaywallConfig{EventBuffer: 10000, EventBatchSize: 500, EventFlushInterval: 5, DegradedPolicy: "fail_open", RateLimit: PaywallRateLimitConfig{PerMinute: 10}, Stream: PaywallStreamConfig{PollInterval: 1, Heartbeat: 25}, Leases: PaywallLeaseConfig{TTL: 60, Limit: 1}},
+		Accounting: AccountingConfig{ReceivableAccount: "Accounts Receivable", BankAccount: "Undeposited Funds", DefaultRevenueAccount: "Subscription Revenue"},
+		I18n:       I18nConfig{DefaultLocale: "en"},
+		Imports:    ImportsConfig{Stripe: StripeImportConfig{APIURL: "https://api.stripe.com"}},
+		Auth:       AuthConfig{Lockout: LockoutConfig{Enabled: true, Window: 900, MaxIPFailures: 20, MaxAccountFailures: 5, LockoutDuration: 60, MaxLockoutDuration: 3600, CaptchaAfter: 3}, Captcha: CaptchaConfig{Timeout: 5}, TwoFactor: TwoFactorConfig{Issuer: "Subscription API", Skew: 1, RecoveryCodes: 10, StepUpTTL: 300}, RequestSigning: RequestSigningConfig{Tolerance: 300}},
+	}
+}
+
+func TestValidateAcceptsDefaults(t *testing.T) {
+	assert.NoError(t, validConfig().Validate())
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	cfg := validConfig()
+	cfg.Database.SSLMode = "sometimes"
+	cfg.Database.MaxOpenConns = 0
+	cfg.Payment.GatewayURL = ""
+
+	err := cfg.Validate()
+
+	var verr *ValidationError
+	assert.True(t, errors.As(err, &verr))
+	assert.Len(t, verr.Problems, 3)
+}
+
+func TestValidateSkipsGatewayWhenPaymentsDisabled(t *testing.T) {
+	cfg := validConfig()
+	cfg.Payment.Enabled = false
+	cfg.Payment.GatewayURL = ""
+
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidateRejectsUnknownDisputePolicy(t *testing.T) {
+	cfg := validConfig()
+	cfg.Payment.DisputePolicy = "refund"
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Contains(t, verr.Problems, `payment.dispute_policy "refund" must be suspend_on_open, suspend_on_loss or none`)
+}
+
+func TestValidateRejectsUnknownRefundPolicy(t *testing.T) {
+	cfg := validConfig()
+	cfg.Payment.RefundPolicy = "store_credit"
+	cfg.Payment.TenantRefundPolicies = map[string]string{"acme": "credit", "globex": "partial"}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Len(t, verr.Problems, 2)
+	assert.Contains(t, verr.Problems, `payment.refund_policy "store_credit" must be refund, credit or none`)
+	assert.Contains(t, verr.Problems, `payment.tenant_refund_policies.globex "partial" must be refund, credit or none`)
+}
+
+func TestValidateRiskChecks(t *testing.T) {
+	cfg := validConfig()
+	cfg.Payment.Risk = RiskConfig{Enabled: true, VelocityWindow: 3600, ReviewAfter: 5, BlockAfter: 10}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Payment.Risk.BlockAfter = 3
+	cfg.Payment.Risk.ProviderURL = "risk.example.com"
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.ElementsMatch(t, []string{
+		"payment.risk.block_after must be at least payment.risk.review_after",
+		`payment.risk.provider_url "risk.example.com" is not an absolute URL`,
+		"payment.risk.provider_timeout must be positive",
+	}, verr.Problems)
+}
+
+func TestValidateRedactFields(t *testing.T) {
+	cfg := validConfig()
+	cfg.Logging.RedactFields = []string{"email", "api_?key", "card(_number"}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{`logging.redact_fields[2] "card(_number" is not a valid regular expression`}, verr.Problems)
+}
+
+func TestValidateEncryptionKeys(t *testing.T) {
+	cfg := validConfig()
+	cfg.Encryption = EncryptionConfig{
+		Enabled:        true,
+		ActiveKey:      "2026-10",
+		Keys:           map[string]string{"2026-01": base64.StdEncoding.EncodeToString(make([]byte, 32)), "2026-04": "c2hvcnQ="},
+		EncryptEmail:   true,
+		RotateInterval: 3600,
+	}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		`encryption.active_key "2026-10" is not one of encryption.keys`,
+		"encryption.keys.2026-04 must be a base64-encoded 32-byte key",
+		"encryption.index_key must be a base64-encoded key of at least 32 bytes when encrypt_email is on",
+	}, verr.Problems)
+
+	cfg.Secrets.Refs.EncryptionKeyring = "paywall/encryption"
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidatePaymentRouting(t *testing.T) {
+	cfg := validConfig()
+	cfg.Payment.Routing = PaymentRoutingConfig{
+		Default:    "stripe",
+		Currencies: map[string]string{"eur": "paypal", "gbp": "adyen"},
+	}
+	cfg.Payment.PayPal = PayPalConfig{BaseURL: "https://api-m.paypal.com", ClientID: "client"}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		`payment.routing.currencies.gbp "adyen" is not one of simulated, stripe, paypal`,
+		"payment.paypal.client_id and client_secret are required when payments are routed to paypal",
+		"payment.paypal.webhook_id is required when payments are routed to paypal",
+	}, verr.Problems)
+}
+
+func TestValidatePendingAccess(t *testing.T) {
+	cfg := validConfig()
+	cfg.Payment.PendingAccess = "allow"
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{`payment.pending_access "allow" must be grant or deny`}, verr.Problems)
+}
+
+func TestValidateInvoicing(t *testing.T) {
+	cfg := validConfig()
+	cfg.Payment.Invoicing = InvoicingConfig{DueDays: 0, CancelAfterDays: -1, CheckInterval: 3600, NumberFormat: "INV-{year}-{seq}", NumberDigits: 6}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"payment.invoicing.due_days and check_interval must be positive",
+		"payment.invoicing.cancel_after_days must not be negative",
+	}, verr.Problems)
+}
+
+func TestValidateInvoiceNumberFormats(t *testing.T) {
+	cfg := validConfig()
+	cfg.Payment.Invoicing.NumberFormat = "INV-{year}"
+	cfg.Payment.Invoicing.NumberDigits = 0
+	cfg.Payment.Invoicing.TenantNumberFormats = map[string]string{
+		"acme":    "ACME-{country}-{year}-{seq}",
+		"globex":  "GX-{day}-{seq}",
+		"initech": "{seq}-{seq}",
+	}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		`payment.invoicing.number_format "INV-{year}" must contain {seq} once`,
+		`payment.invoicing.tenant_number_formats.globex "GX-{day}-{seq}" has unknown field {day}`,
+		`payment.invoicing.tenant_number_formats.initech "{seq}-{seq}" must contain {seq} once`,
+		"payment.invoicing.number_digits must be between 1 and 12",
+	}, verr.Problems)
+}
+
+func TestValidateVAT(t *testing.T) {
+	cfg := validConfig()
+	cfg.Payment.VAT = VATConfig{SellerCountry: "UK", VIESURL: "vies", Timeout: 0}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		`payment.vat.seller_country "UK" is not the ISO code of an EU member state`,
+		`payment.vat.vies_url "vies" is not an absolute URL`,
+		"payment.vat.timeout must be positive",
+	}, verr.Problems)
+
+	cfg.Payment.VAT = VATConfig{SellerCountry: "DE", VIESURL: "https://ec.europa.eu/taxation_customs/vies/rest-api", Timeout: 10}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidateCheckout(t *testing.T) {
+	cfg := validConfig()
+	cfg.Payment.Checkout = CheckoutConfig{
+		SessionTTL: 0,
+		HostedURL:  "/checkout",
+		Coupons: []CouponConfig{
+			{Code: "LAUNCH", PercentOff: 20},
+			{Code: "launch", PercentOff: 120, Renewals: -1},
+			{PercentOff: 10},
+		},
+	}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"payment.checkout.session_ttl must be positive",
+		`payment.checkout.hosted_url "/checkout" is not an absolute URL`,
+		`payment.checkout.coupons[1].code "launch" is used by another coupon`,
+		"payment.checkout.coupons[1].percent_off must be between 1 and 100",
+		"payment.checkout.coupons[1].renewals must not be negative",
+		"payment.checkout.coupons[2].code is required",
+	}, verr.Problems)
+}
+
+func TestValidateTrustedProxies(t *testing.T) {
+	cfg := validConfig()
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.7", "load-balancer"}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{`server.trusted_proxies entry "load-balancer" is not an IP address or CIDR range`}, verr.Problems)
+}
+
+func TestValidatePaywallRateLimits(t *testing.T) {
+	cfg := validConfig()
+	cfg.Paywall.RateLimit.Actions = map[string]int{"view": 30, "share": 0}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{"paywall.rate_limit.actions.share must be positive"}, verr.Problems)
+}
+
+func TestValidatePaywallStream(t *testing.T) {
+	cfg := validConfig()
+	cfg.Paywall.Stream.Heartbeat = 0
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{"paywall.stream.heartbeat must be positive"}, verr.Problems)
+}
+
+func TestValidatePaywallLeases(t *testing.T) {
+	cfg := validConfig()
+	cfg.Paywall.Leases.TTL = 0
+	cfg.Paywall.Leases.Actions = map[string]int{"stream": 2, "download": 0}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"paywall.leases.ttl must be positive",
+		"paywall.leases.actions.download must be positive",
+	}, verr.Problems)
+}
+
+func TestValidatePaywallAbuse(t *testing.T) {
+	cfg := validConfig()
+	cfg.Paywall.Abuse = PaywallAbuseConfig{Enabled: true, Window: 86400, MaxAccountsPerDevice: 3, MaxIPsPerUser: 5}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{"paywall.abuse.max_accounts_per_ip must be positive"}, verr.Problems)
+
+	cfg.Paywall.Abuse = PaywallAbuseConfig{}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidatePaywallUpgradeURL(t *testing.T) {
+	cfg := validConfig()
+	cfg.Paywall.UpgradeURL = "https://example.com/checkout?content={content_id}"
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{"paywall.upgrade_url must contain {plan_id}"}, verr.Problems)
+}
+
+func TestValidatePaywallUsageTimezones(t *testing.T) {
+	cfg := validConfig()
+	cfg.Paywall.Usage = PaywallUsageConfig{
+		Timezone:        "Mars/Olympus_Mons",
+		TenantTimezones: map[string]string{"acme": "Europe/Berlin"},
+	}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{`paywall.usage.timezone "Mars/Olympus_Mons" is not a known timezone`}, verr.Problems)
+}
+
+func TestValidateFeatureCatalog(t *testing.T) {
+	cfg := validConfig()
+	cfg.Features = []FeatureConfig{
+		{Key: "storage_gb", Type: "int"},
+		{Key: "storage_gb", Type: "string"},
+		{Key: "support_channel", Type: "enum"},
+		{Key: "api_calls", Type: "int", Metered: "monthly"},
+		{Key: "exports", Type: "bool", Metered: "daily"},
+		{Key: "seats", Type: "int", Metered: "yearly"},
+	}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Contains(t, verr.Problems, `features[1].key "storage_gb" is listed twice`)
+	assert.Contains(t, verr.Problems, `features[1].type "string" must be bool, int or enum`)
+	assert.Contains(t, verr.Problems, `features[2].values must list the allowed values of enum feature "support_channel"`)
+	assert.Len(t, verr.Problems, 5)
+	assert.Contains(t, verr.Problems, `features[4] "exports" must be an int feature to be metered`)
+	assert.Contains(t, verr.Problems, `features[5].metered "yearly" must be daily or monthly`)
+}
+
+func TestValidateRetentionOffers(t *testing.T) {
+	cfg := validConfig()
+	cfg.Subscription.RetentionOffers = []RetentionOfferConfig{
+		{Key: "save_25", Type: "discount", PercentOff: 25, Renewals: 3},
+		{Key: "save_25", Type: "pause", PauseMonths: 1},
+		{Key: "free_month", Type: "credit"},
+		{Key: "save_all", Type: "discount", PercentOff: 120},
+	}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Contains(t, verr.Problems, `subscription.retention_offers[1].key "save_25" is listed twice`)
+	assert.Contains(t, verr.Problems, `subscription.retention_offers[2].type "credit" must be discount or pause`)
+	assert.Contains(t, verr.Problems, `subscription.retention_offers[3].percent_off must be between 1 and 100`)
+	assert.Contains(t, verr.Problems, `subscription.retention_offers[3].renewals must be positive for a discount`)
+}
+
+func TestValidateSLO(t *testing.T) {
+	cfg := validConfig()
+	cfg.Telemetry.SLO = SLOConfig{
+		Interval:   30,
+		WebhookURL: "https://alerts.example.com/slo",
+		Timeout:    10,
+		Objectives: []SLOObjectiveConfig{
+			{Name: "paywall", Domain: "paywall", Availability: 0.999, LatencyMS: 50, LatencyTarget: 0.99},
+		},
+		BurnRates: []BurnRateConfig{{Severity: "page", LongWindow: 3600, ShortWindow: 300, Threshold: 14.4}},
+	}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Telemetry.SLO.WebhookURL = "alerts"
+	cfg.Telemetry.SLO.Objectives = append(cfg.Telemetry.SLO.Objectives,
+		SLOObjectiveConfig{Name: "paywall", Domain: "billing", Availability: 1},
+		SLOObjectiveConfig{Name: "plans", Domain: "plan", LatencyTarget: 0.95},
+		SLOObjectiveConfig{Domain: "payment"},
+	)
+	cfg.Telemetry.SLO.BurnRates = append(cfg.Telemetry.SLO.BurnRates, BurnRateConfig{Severity: "page", LongWindow: 300, ShortWindow: 300})
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		`telemetry.slo.webhook_url "alerts" is not an absolute URL`,
+		`telemetry.slo.objectives[1].name "paywall" is listed twice`,
+		`telemetry.slo.objectives[1].domain "billing" is not one of payment, paywall, plan, subscription`,
+		"telemetry.slo.objectives[1].availability must be between 0 and 1",
+		"telemetry.slo.objectives[2].latency_ms must be positive with a latency_target",
+		"telemetry.slo.objectives[3].name is required",
+		"telemetry.slo.objectives[3] needs an availability or latency_target",
+		`telemetry.slo.burn_rates[1].severity "page" is listed twice`,
+		"telemetry.slo.burn_rates[1] needs a positive short_window under long_window",
+		"telemetry.slo.burn_rates[1].threshold must be positive",
+	}, verr.Problems)
+}
+
+func TestValidateErrorReporting(t *testing.T) {
+	cfg := validConfig()
+	cfg.ErrorReporting = ErrorReportingConfig{Provider: "sentry", DSN: "https://abc123@o1.ingest.sentry.io/42", Timeout: 5}
+	assert.NoError(t, cfg.Validate())
+	cfg.ErrorReporting = ErrorReportingConfig{Provider: "rollbar", AccessToken: "post-server-token", Timeout: 5}
+	assert.NoError(t, cfg.Validate())
+
+	var verr *ValidationError
+	cfg.ErrorReporting = ErrorReportingConfig{Provider: "sentry", DSN: "https://o1.ingest.sentry.io/42"}
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"error_reporting.dsn must be a Sentry DSN (https://<key>@<host>/<project>) for the sentry provider",
+		"error_reporting.timeout must be positive",
+	}, verr.Problems)
+
+	cfg.ErrorReporting = ErrorReportingConfig{Provider: "rollbar", Timeout: 5}
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{"error_reporting.access_token is required for the rollbar provider"}, verr.Problems)
+
+	cfg.ErrorReporting = ErrorReportingConfig{Provider: "bugsnag", Timeout: 5}
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{`error_reporting.provider "bugsnag" is not one of sentry, rollbar`}, verr.Problems)
+}
+
+func TestValidateDegradation(t *testing.T) {
+	cfg := validConfig()
+	cfg.Paywall.DegradedPolicy = "fail_closed"
+	assert.NoError(t, cfg.Validate())
+
+	var verr *ValidationError
+	cfg.Cache.ProbeInterval = 0
+	cfg.Cache.FailureThreshold = -1
+	cfg.Paywall.DegradedPolicy = "allow"
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"cache.probe_interval must be positive",
+		"cache.failure_threshold must be positive",
+		`paywall.degraded_policy "allow" must be fail_open or fail_closed`,
+	}, verr.Problems)
+}
+
+func TestValidateWarmup(t *testing.T) {
+	cfg := validConfig()
+	cfg.Cache.Warmup.HotPlans = 0
+	cfg.Cache.Warmup.RecentSubscriptions = 0
+	assert.NoError(t, cfg.Validate())
+
+	var verr *ValidationError
+	cfg.Cache.Warmup.HotPlans = -1
+	cfg.Cache.Warmup.RecentWindow = 0
+	cfg.Cache.Warmup.Timeout = 0
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"cache.warmup.hot_plans must not be negative",
+		"cache.warmup.recent_window must be positive",
+		"cache.warmup.timeout must be positive",
+	}, verr.Problems)
+
+	// Only checked when warmup is on
+	cfg.Cache.Warmup.Enabled = false
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidateRetentionPolicies(t *testing.T) {
+	cfg := validConfig()
+	cfg.Retention = RetentionConfig{Interval: 3600, BatchSize: 5000, Policies: []RetentionPolicyConfig{
+		{Table: "webhook_events", Days: 30, Action: "archive"},
+		{Table: "usage_logs", Days: 0, Action: "purge"},
+		{Table: "webhook_events", Days: 30, Action: "purge"},
+		{Table: "users", Days: 30, Action: "shred"},
+	}}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"retention.policies[0] archives webhook_events but no object_store.provider is set",
+		"retention.policies[1].days must be at least 1",
+		`retention.policies[2].table "webhook_events" has more than one policy`,
+		`retention.policies[3].table "users" is not a table retention can apply to`,
+		`retention.policies[3].action "shred" is not one of archive, purge`,
+	}, verr.Problems)
+
+	cfg.ObjectStore = ObjectStoreConfig{Provider: "s3", Bucket: "paywall-archive", Region: "eu-west-1"}
+	cfg.Retention.Policies = cfg.Retention.Policies[:1]
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidateExport(t *testing.T) {
+	cfg := validConfig()
+	cfg.Export = ExportConfig{Interval: 300, BatchSize: 10000, Lag: -1, Streams: []string{"paywall_events", "usage_logs", "paywall_events"}}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"export.streams are set but no object_store.provider is set",
+		"export.lag must not be negative",
+		`export.streams: "usage_logs" is not one of subscription_events, paywall_events`,
+		`export.streams: "paywall_events" is listed more than once`,
+	}, verr.Problems)
+
+	cfg.ObjectStore = ObjectStoreConfig{Provider: "file", Directory: "/var/lib/paywall/export"}
+	cfg.Export = ExportConfig{Interval: 300, BatchSize: 10000, Lag: 60, Streams: []string{"subscription_events", "paywall_events"}}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidateWarehouse(t *testing.T) {
+	cfg := validConfig()
+	cfg.Warehouse = WarehouseConfig{
+		Provider:  "snowflake",
+		Interval:  300,
+		BatchSize: 1000,
+		Tables:    []string{"subscriptions", "users"},
+		Snowflake: SnowflakeWarehouseConfig{Account: "acme-eu", User: "PAYWALL_SYNC", Database: "ANALYTICS", Schema: "PAYWALL", Warehouse: "LOAD_WH"},
+	}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"warehouse.snowflake.account, user and private_key are required for the snowflake provider",
+		`warehouse.tables: "users" is not one of subscriptions, transactions, plan_changes`,
+	}, verr.Problems)
+
+	cfg.Warehouse.Provider = "bigquery"
+	cfg.Warehouse.Tables = nil
+	cfg.Warehouse.BigQuery = BigQueryWarehouseConfig{ProjectID: "acme-analytics", Dataset: "paywall"}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidateCRM(t *testing.T) {
+	cfg := validConfig()
+	cfg.CRM = CRMConfig{
+		Provider:      "hubspot",
+		Interval:      60,
+		BatchSize:     500,
+		Events:        []string{"new", "renewed"},
+		HubSpot:       HubSpotCRMConfig{Token: "pat-eu1-..."},
+		ContactFields: map[string]string{"email": "email", "password": "password"},
+		DealFields:    map[string]string{"stage": "dealstage"},
+		Stages:        map[string]string{"cancelled": "closedlost", "paused": "appointmentscheduled"},
+	}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"crm.hubspot.token and deal_id_property are required for the hubspot provider",
+		`crm.events: "renewed" is not one of new, upgraded, downgraded, churn_risk, cancelled`,
+		"crm.contact_fields.password is not a subscription attribute",
+		"crm.stages.paused is not a lifecycle event",
+	}, verr.Problems)
+
+	cfg.CRM.Provider = "salesforce"
+	cfg.CRM.Events = nil
+	cfg.CRM.ContactFields = map[string]string{"email": "Email"}
+	cfg.CRM.Stages = nil
+	cfg.CRM.Salesforce = SalesforceCRMConfig{
+		InstanceURL:       "https://acme.my.salesforce.com",
+		ClientID:          "3MVG9...",
+		ClientSecret:      "secret",
+		ContactExternalID: "Paywall_User_Id__c",
+		DealExternalID:    "Paywall_Subscription_Id__c",
+	}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidateAccountingAccounts(t *testing.T) {
+	cfg := validConfig()
+	cfg.Accounting.BankAccount = ""
+	cfg.Accounting.RevenueAccounts = map[string]string{"plan-pro": "4010", "plan-basic": ""}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"accounting.receivable_account, bank_account and default_revenue_account are required",
+		"accounting.revenue_accounts.plan-basic must name an account",
+	}, verr.Problems)
+}
+
+func TestValidateObjectStore(t *testing.T) {
+	cfg := validConfig()
+	cfg.ObjectStore = ObjectStoreConfig{Provider: "s3"}
+
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"object_store.bucket is required for the s3 provider",
+		"object_store.region is required for the s3 provider",
+	}, verr.Problems)
+
+	cfg.ObjectStore = ObjectStoreConfig{Provider: "file"}
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{"object_store.directory is required for the file provider"}, verr.Problems)
+
+	cfg.ObjectStore = ObjectStoreConfig{Provider: "gcs", Bucket: "paywall-archive"}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidateProductionConstraints(t *testing.T) {
+	cfg := validConfig()
+	cfg.Telemetry.Environment = "production"
+
+	err := cfg.Validate()
+
+	var verr *ValidationError
+	assert.True(t, errors.As(err, &verr))
+	assert.Contains(t, verr.Problems, "database.sslmode must be require, verify-ca or verify-full in production")
+	assert.Contains(t, verr.Problems, "payment.api_key must be a live credential or a secrets ref in production")
+	assert.Contains(t, verr.Problems, "payment.routing.default must be stripe or paypal in production")
+
+	// Nothing may be routed to the simulated gateway
+	cfg.Payment.Routing = PaymentRoutingConfig{Default: "stripe", Currencies: map[string]string{"eur": "paypal", "gbp": "simulated"}}
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Contains(t, verr.Problems, "payment.routing.currencies.gbp must not be simulated in production")
+	assert.NotContains(t, verr.Problems, "payment.routing.default must be stripe or paypal in production")
+
+	cfg.Payment.Routing.Currencies = nil
+	cfg.Database.SSLMode = "verify-full"
+	cfg.Secrets.Refs = SecretRefsConfig{
+		PaymentAPIKey:        "paywall/api_key",
+		PaymentSecretKey:     "paywall/secret_key",
+		PaymentWebhookSecret: "paywall/webhook_secret",
+		DatabasePassword:     "paywall/db_password",
+	}
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestValidateI18n(t *testing.T) {
+	cfg := validConfig()
+	cfg.I18n.DefaultLocale = "pt-BR"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.I18n.DefaultLocale = "english!"
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{`i18n.default_locale "english!" is not a BCP 47 language tag`}, verr.Problems)
+}
+
+func TestValidateStripeImport(t *testing.T) {
+	cfg := validConfig()
+	cfg.Imports.Stripe.Plans = map[string]string{"price_1nqx": "7f3e9a8c-5b2d-4e1f-9c6a-0d8b7e2f1a3c"}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Imports.Stripe.APIURL = "api.stripe.com"
+	cfg.Imports.Stripe.Plans["prod_pro"] = "pro"
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		`imports.stripe.api_url "api.stripe.com" is not an absolute URL`,
+		`imports.stripe.plans.prod_pro "pro" is not a plan ID`,
+	}, verr.Problems)
+}
+
+func TestValidateAuth(t *testing.T) {
+	cfg := validConfig()
+	cfg.Auth.Captcha.Provider = "turnstile"
+	cfg.Auth.Captcha.Secret = "0x4AAAA"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Auth.Lockout.MaxLockoutDuration = 30
+	cfg.Auth.Captcha.Provider = "geetest"
+	var verr *ValidationError
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		"auth.lockout.max_lockout_duration must be at least lockout_duration",
+		`auth.captcha.provider "geetest" is not one of hcaptcha, recaptcha, turnstile`,
+	}, verr.Problems)
+
+	cfg = validConfig()
+	cfg.Auth.Captcha.Provider = "hcaptcha"
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{"auth.captcha.secret is required when a CAPTCHA provider is set"}, verr.Problems)
+
+	cfg = validConfig()
+	cfg.Auth.TwoFactor.Issuer = "Acme: Billing"
+	cfg.Auth.TwoFactor.RecoveryCodes = 0
+	cfg.Auth.TwoFactor.StepUpTTL = 0
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		`auth.two_factor.issuer "Acme: Billing" must be set and not contain a colon`,
+		"auth.two_factor.recovery_codes must be between 1 and 50",
+		"auth.two_factor.step_up_ttl must be positive",
+	}, verr.Problems)
+
+	cfg = validConfig()
+	cfg.Auth.AdminCIDRs = []string{"10.0.0.0/8", "203.0.113.7", "office"}
+	cfg.Auth.RequestSigning.Tolerance = 0
+	assert.True(t, errors.As(cfg.Validate(), &verr))
+	assert.Equal(t, []string{
+		`auth.admin_cidrs entry "office" is not an IP address or CIDR range`,
+		"auth.request_signing.tolerance must be positive",
+	}, verr.Problems)
+}
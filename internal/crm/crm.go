@@ -0,0 +1,88 @@
+package crm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"scalable-paywall/internal/config"
+)
+
+// Lifecycle events pushed to the CRM, as named in crm.events.
+const (
+	EventNew        = "new"
+	EventUpgraded   = "upgraded"
+	EventDowngraded = "downgraded"
+	EventChurnRisk  = "churn_risk"
+	EventCancelled  = "cancelled"
+)
+
+// Update is one push to the CRM: the subscriber's contact and the
+// subscription's deal, by CRM field name. The IDs identify both records.
+type Update struct {
+	UserID         string
+	Email          string
+	SubscriptionID string
+	Contact        map[string]interface{}
+	Deal           map[string]interface{}
+}
+
+// Client creates or updates the contact and deal of an Update, linking them.
+type Client interface {
+	Push(ctx context.Context, update *Update) error
+}
+
+// NewClient builds the Client selected by cfg.Provider. It returns a nil
+// Client when no CRM is configured.
+func NewClient(cfg config.CRMConfig) (Client, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "":
+		return nil, nil
+	case "hubspot":
+		return newHubSpotClient(cfg.HubSpot), nil
+	case "salesforce":
+		return newSalesforceClient(cfg.Salesforce), nil
+	default:
+		return nil, fmt.Errorf("unknown CRM provider %q", cfg.Provider)
+	}
+}
+
+// lifecycleOf names the lifecycle event a subscription event is, or ""
+// for events the CRM isn't told about. upgrade is whether a plan change
+// moved to a pricier plan.
+func lifecycleOf(event string, to *string, upgrade bool) string {
+	switch event {
+	case "created":
+		return EventNew
+	case "plan_changed":
+		if upgrade {
+			return EventUpgraded
+		}
+		return EventDowngraded
+	case "renewal_failed":
+		return EventChurnRisk
+	case "status_changed":
+		if to == nil {
+			return ""
+		}
+		switch *to {
+		case "past_due", "suspended":
+			return EventChurnRisk
+		case "cancelled", "expired":
+			return EventCancelled
+		}
+	}
+	return ""
+}
+
+// mapFields names the attributes of a subscription by the CRM fields
+// mapping assigns them to, leaving out attributes without a value
+func mapFields(attributes map[string]interface{}, mapping map[string]string) map[string]interface{} {
+	fields := make(map[string]interface{}, len(mapping))
+	for attribute, field := range mapping {
+		if value, ok := attributes[attribute]; ok && value != nil {
+			fields[field] = value
+		}
+	}
+	return fields
+}
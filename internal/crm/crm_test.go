@@ -0,0 +1,28 @@
+package crm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycleOf(t *testing.T) {
+	status := func(s string) *string { return &s }
+
+	assert.Equal(t, EventNew, lifecycleOf("created", status("active"), false))
+	assert.Equal(t, EventUpgraded, lifecycleOf("plan_changed", status("plan-pro"), true))
+	assert.Equal(t, EventDowngraded, lifecycleOf("plan_changed", status("plan-basic"), false))
+	assert.Equal(t, EventChurnRisk, lifecycleOf("renewal_failed", status("1"), false))
+	assert.Equal(t, EventChurnRisk, lifecycleOf("status_changed", status("past_due"), false))
+	assert.Equal(t, EventCancelled, lifecycleOf("status_changed", status("expired"), false))
+	assert.Equal(t, "", lifecycleOf("status_changed", status("active"), false))
+	assert.Equal(t, "", lifecycleOf("period_extended", nil, false))
+}
+
+func TestMapFields(t *testing.T) {
+	attributes := map[string]interface{}{"email": "ada@example.com", "plan_name": "Pro", "stage": nil}
+
+	fields := mapFields(attributes, map[string]string{"email": "Email", "plan_name": "Plan__c", "stage": "StageName", "amount": "Amount"})
+
+	assert.Equal(t, map[string]interface{}{"Email": "ada@example.com", "Plan__c": "Pro"}, fields)
+}
@@ -0,0 +1,100 @@
+package crm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+)
+
+const hubSpotURL = "https://api.hubapi.com"
+
+// hubSpotClient upserts contacts by email and deals by a unique deal
+// property holding the subscription ID, then associates the two.
+type hubSpotClient struct {
+	client  *http.Client
+	baseURL string
+	cfg     config.HubSpotCRMConfig
+}
+
+func newHubSpotClient(cfg config.HubSpotCRMConfig) *hubSpotClient {
+	return &hubSpotClient{client: &http.Client{Timeout: 30 * time.Second}, baseURL: hubSpotURL, cfg: cfg}
+}
+
+func (h *hubSpotClient) Push(ctx context.Context, update *Update) error {
+	contactID, err := h.upsert(ctx, "contacts", "email", update.Email, update.Contact)
+	if err != nil {
+		return fmt.Errorf("failed to upsert HubSpot contact: %w", err)
+	}
+	deal := make(map[string]interface{}, len(update.Deal)+1)
+	for field, value := range update.Deal {
+		deal[field] = value
+	}
+	deal[h.cfg.DealIDProperty] = update.SubscriptionID
+	dealID, err := h.upsert(ctx, "deals", h.cfg.DealIDProperty, update.SubscriptionID, deal)
+	if err != nil {
+		return fmt.Errorf("failed to upsert HubSpot deal: %w", err)
+	}
+	path := fmt.Sprintf("/crm/v4/objects/deals/%s/associations/default/contacts/%s", dealID, contactID)
+	if err := h.do(ctx, http.MethodPut, path, nil, nil); err != nil {
+		return fmt.Errorf("failed to associate HubSpot deal with contact: %w", err)
+	}
+	return nil
+}
+
+// upsert creates or updates the object whose idProperty is id and returns
+// its HubSpot ID
+func (h *hubSpotClient) upsert(ctx context.Context, object, idProperty, id string, properties map[string]interface{}) (string, error) {
+	body := map[string]interface{}{
+		"inputs": []map[string]interface{}{{"idProperty": idProperty, "id": id, "properties": properties}},
+	}
+	var result struct {
+		Results []struct {
+			ID string `json:"id"`
+		} `json:"results"`
+	}
+	if err := h.do(ctx, http.MethodPost, "/crm/v3/objects/"+object+"/batch/upsert", body, &result); err != nil {
+		return "", err
+	}
+	if len(result.Results) == 0 {
+		return "", fmt.Errorf("no %s returned", object)
+	}
+	return result.Results[0].ID, nil
+}
+
+func (h *hubSpotClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("HubSpot returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,68 @@
+package crm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubSpotPush(t *testing.T) {
+	var calls []string
+	var deal map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer pat-test", r.Header.Get("Authorization"))
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/crm/v3/objects/contacts/batch/upsert":
+			w.Write([]byte(`{"results":[{"id":"101"}]}`))
+		case "/crm/v3/objects/deals/batch/upsert":
+			var body struct {
+				Inputs []map[string]interface{} `json:"inputs"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			deal = body.Inputs[0]
+			w.Write([]byte(`{"results":[{"id":"202"}]}`))
+		}
+	}))
+	defer server.Close()
+	client := newHubSpotClient(config.HubSpotCRMConfig{Token: "pat-test", DealIDProperty: "paywall_subscription_id"})
+	client.baseURL = server.URL
+
+	err := client.Push(context.Background(), &Update{
+		UserID:         "user-1",
+		Email:          "ada@example.com",
+		SubscriptionID: "sub-1",
+		Contact:        map[string]interface{}{"email": "ada@example.com"},
+		Deal:           map[string]interface{}{"dealstage": "closedlost"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"POST /crm/v3/objects/contacts/batch/upsert",
+		"POST /crm/v3/objects/deals/batch/upsert",
+		"PUT /crm/v4/objects/deals/202/associations/default/contacts/101",
+	}, calls)
+	assert.Equal(t, "paywall_subscription_id", deal["idProperty"])
+	assert.Equal(t, "sub-1", deal["id"])
+	assert.Equal(t, map[string]interface{}{"dealstage": "closedlost", "paywall_subscription_id": "sub-1"}, deal["properties"])
+}
+
+func TestHubSpotPushReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"message":"rate limited"}`))
+	}))
+	defer server.Close()
+	client := newHubSpotClient(config.HubSpotCRMConfig{Token: "pat-test", DealIDProperty: "paywall_subscription_id"})
+	client.baseURL = server.URL
+
+	err := client.Push(context.Background(), &Update{Email: "ada@example.com", SubscriptionID: "sub-1"})
+	assert.EqualError(t, err, `failed to upsert HubSpot contact: HubSpot returned 429: {"message":"rate limited"}`)
+}
@@ -0,0 +1,121 @@
+package crm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"scalable-paywall/internal/config"
+)
+
+// salesforceClient upserts contacts and opportunities by their external ID
+// fields over the REST API, pointing the opportunity at the contact by the
+// contact's external ID.
+type salesforceClient struct {
+	client *http.Client
+	cfg    config.SalesforceCRMConfig
+
+	mu    sync.Mutex
+	token string
+}
+
+func newSalesforceClient(cfg config.SalesforceCRMConfig) *salesforceClient {
+	return &salesforceClient{client: &http.Client{Timeout: 30 * time.Second}, cfg: cfg}
+}
+
+func (s *salesforceClient) Push(ctx context.Context, update *Update) error {
+	if err := s.upsert(ctx, "Contact", s.cfg.ContactExternalID, update.UserID, update.Contact); err != nil {
+		return fmt.Errorf("failed to upsert Salesforce contact: %w", err)
+	}
+	deal := make(map[string]interface{}, len(update.Deal)+1)
+	for field, value := range update.Deal {
+		deal[field] = value
+	}
+	deal["Contact"] = map[string]interface{}{s.cfg.ContactExternalID: update.UserID}
+	if err := s.upsert(ctx, "Opportunity", s.cfg.DealExternalID, update.SubscriptionID, deal); err != nil {
+		return fmt.Errorf("failed to upsert Salesforce opportunity: %w", err)
+	}
+	return nil
+}
+
+// upsert creates or updates the record of sobject whose externalID field is id
+func (s *salesforceClient) upsert(ctx context.Context, sobject, externalID, id string, fields map[string]interface{}) error {
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/services/data/%s/sobjects/%s/%s/%s", s.cfg.APIVersion, sobject, externalID, url.PathEscape(id))
+
+	// A token that expired is refreshed once
+	for attempt := 0; ; attempt++ {
+		token, err := s.accessToken(ctx, attempt > 0)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPatch, strings.TrimRight(s.cfg.InstanceURL, "/")+path, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			continue
+		}
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("Salesforce returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+		}
+		return nil
+	}
+}
+
+// accessToken returns the cached token, or a new one from the client
+// credentials flow when there is none or refresh is set
+func (s *salesforceClient) accessToken(ctx context.Context, refresh bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && !refresh {
+		return s.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.cfg.ClientID},
+		"client_secret": {s.cfg.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(s.cfg.InstanceURL, "/")+"/services/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("Salesforce token request returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	s.token = result.AccessToken
+	return s.token, nil
+}
@@ -0,0 +1,64 @@
+package crm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSalesforcePushRefreshesExpiredToken(t *testing.T) {
+	tokens := 0
+	var calls []string
+	var opportunity map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/oauth2/token" {
+			tokens++
+			assert.Equal(t, "client_credentials", r.FormValue("grant_type"))
+			json.NewEncoder(w).Encode(map[string]string{"access_token": map[int]string{1: "stale", 2: "fresh"}[tokens]})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/services/data/v59.0/sobjects/Opportunity/Paywall_Subscription_Id__c/sub-1" {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&opportunity))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	client := newSalesforceClient(config.SalesforceCRMConfig{
+		InstanceURL:       server.URL,
+		ClientID:          "id",
+		ClientSecret:      "secret",
+		APIVersion:        "v59.0",
+		ContactExternalID: "Paywall_User_Id__c",
+		DealExternalID:    "Paywall_Subscription_Id__c",
+	})
+
+	err := client.Push(context.Background(), &Update{
+		UserID:         "user-1",
+		SubscriptionID: "sub-1",
+		Contact:        map[string]interface{}{"Email": "ada@example.com"},
+		Deal:           map[string]interface{}{"StageName": "Closed Lost"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, tokens)
+	assert.Equal(t, []string{
+		"PATCH /services/data/v59.0/sobjects/Contact/Paywall_User_Id__c/user-1",
+		"PATCH /services/data/v59.0/sobjects/Opportunity/Paywall_Subscription_Id__c/sub-1",
+	}, calls)
+	assert.Equal(t, map[string]interface{}{
+		"StageName": "Closed Lost",
+		"Contact":   map[string]interface{}{"Paywall_User_Id__c": "user-1"},
+	}, opportunity)
+}
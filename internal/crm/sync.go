@@ -0,0 +1,234 @@
+package crm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/encryption"
+	"scalable-paywall/internal/jobs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JobSync queues a push for each new lifecycle event; JobPush pushes one.
+const (
+	JobSync = "crm.sync"
+	JobPush = "crm.push"
+)
+
+// maxBatchesPerRun bounds one sync run
+const maxBatchesPerRun = 20
+
+// cursorName names the sync's position in crm_cursors
+const cursorName = "subscription_events"
+
+// cursor is the last subscription event the sync queued
+type cursor struct {
+	at time.Time
+	id string
+}
+
+var syncStart = cursor{at: time.Unix(0, 0).UTC(), id: "00000000-0000-0000-0000-000000000000"}
+
+// pushPayload is a queued push of a subscription after a lifecycle event
+type pushPayload struct {
+	EventID        string `json:"event_id"`
+	SubscriptionID string `json:"subscription_id"`
+	Lifecycle      string `json:"lifecycle"`
+}
+
+// Syncer follows the subscription history and pushes lifecycle changes to
+// the CRM. Pushes are jobs, so a CRM outage is retried with backoff and
+// pushes that keep failing wait in the dead-letter list.
+type Syncer struct {
+	conn    *db.Connection
+	queue   *jobs.Queue
+	keyring *encryption.Keyring
+	client  Client
+	cfg     config.CRMConfig
+	events  map[string]bool
+}
+
+func NewSyncer(conn *db.Connection, queue *jobs.Queue, keyring *encryption.Keyring, client Client, cfg config.CRMConfig) *Syncer {
+	events := make(map[string]bool, len(cfg.Events))
+	for _, event := range cfg.Events {
+		events[event] = true
+	}
+	return &Syncer{conn: conn, queue: queue, keyring: keyring, client: client, cfg: cfg, events: events}
+}
+
+// RegisterJobs schedules the sync and handles pushes when a CRM is
+// configured
+func (s *Syncer) RegisterJobs(runner *jobs.Runner) {
+	if s.client == nil {
+		return
+	}
+	runner.Every(JobSync, time.Duration(s.cfg.Interval)*time.Second, func(ctx context.Context, job *jobs.Job) error {
+		n, err := s.Run(ctx)
+		if n > 0 {
+			logrus.Infof("Queued %d CRM pushes", n)
+		}
+		return err
+	})
+	runner.Handle(JobPush, s.push)
+}
+
+// Run queues a push for each configured lifecycle event since the last
+// run, up to Lag seconds ago, and returns how many it queued. Each event
+// is queued once, however often it is read.
+func (s *Syncer) Run(ctx context.Context) (int, error) {
+	from, err := s.cursor(ctx)
+	if err != nil {
+		return 0, err
+	}
+	until := time.Now().Add(-time.Duration(s.cfg.Lag) * time.Second)
+
+	queued := 0
+	for i := 0; i < maxBatchesPerRun && ctx.Err() == nil; i++ {
+		rows, err := s.conn.QueryContext(ctx, `
+			SELECT e.id::text, e.created_at, e.subscription_id::text, e.event, e.to_value,
+				COALESCE(tp.price > fp.price, false)
+			FROM subscription_events e
+			LEFT JOIN plans fp ON e.event = 'plan_changed' AND fp.id::text = e.from_value
+			LEFT JOIN plans tp ON e.event = 'plan_changed' AND tp.id::text = e.to_value
+			WHERE e.created_at < $1 AND (e.created_at, e.id) > ($2, $3::uuid)
+			ORDER BY e.created_at, e.id
+			LIMIT $4
+		`, until, from.at, from.id, s.cfg.BatchSize)
+		if err != nil {
+			return queued, err
+		}
+		var pushes []pushPayload
+		read, last := 0, from
+		for rows.Next() {
+			var p pushPayload
+			var event string
+			var to *string
+			var upgrade bool
+			if err := rows.Scan(&p.EventID, &last.at, &p.SubscriptionID, &event, &to, &upgrade); err != nil {
+				rows.Close()
+				return queued, err
+			}
+			last.id = p.EventID
+			read++
+			if p.Lifecycle = lifecycleOf(event, to, upgrade); s.events[p.Lifecycle] {
+				pushes = append(pushes, p)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || read == 0 {
+			return queued, err
+		}
+
+		for _, p := range pushes {
+			id, err := s.queue.Enqueue(ctx, JobPush, p, jobs.EnqueueOptions{UniqueKey: "crm:" + p.EventID})
+			if err != nil {
+				return queued, err
+			}
+			if id != "" {
+				queued++
+			}
+		}
+		if err := s.advance(ctx, last); err != nil {
+			return queued, err
+		}
+		from = last
+		if read < s.cfg.BatchSize {
+			return queued, nil
+		}
+	}
+	return queued, ctx.Err()
+}
+
+// push sends the subscription as it is now, labelled with the lifecycle
+// event that queued the push
+func (s *Syncer) push(ctx context.Context, job *jobs.Job) error {
+	var p pushPayload
+	if err := job.Decode(&p); err != nil {
+		return err
+	}
+	attributes, err := s.attributes(ctx, p.SubscriptionID)
+	if err == sql.ErrNoRows {
+		logrus.Warnf("Skipping CRM push of deleted subscription %s", p.SubscriptionID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	attributes["lifecycle"] = p.Lifecycle
+	if stage, ok := s.cfg.Stages[p.Lifecycle]; ok {
+		attributes["stage"] = stage
+	}
+
+	update := &Update{
+		UserID:         attributes["user_id"].(string),
+		Email:          attributes["email"].(string),
+		SubscriptionID: p.SubscriptionID,
+		Contact:        mapFields(attributes, s.cfg.ContactFields),
+		Deal:           mapFields(attributes, s.cfg.DealFields),
+	}
+	return s.client.Push(ctx, update)
+}
+
+// attributes are the subscription's values that CRM fields can map
+func (s *Syncer) attributes(ctx context.Context, subscriptionID string) (map[string]interface{}, error) {
+	var userID, email, username, planID, planName, status, amount, currency string
+	var startDate, endDate time.Time
+	var autoRenew bool
+	err := s.conn.QueryRowContext(ctx, `
+		SELECT s.user_id::text, u.email, u.username, s.plan_id::text, p.name, s.status,
+			s.amount::text, s.currency, s.start_date, s.end_date, COALESCE(s.auto_renew, false)
+		FROM subscriptions s
+		JOIN users u ON u.id = s.user_id
+		JOIN plans p ON p.id = s.plan_id
+		WHERE s.id = $1
+	`, subscriptionID).Scan(&userID, &email, &username, &planID, &planName, &status,
+		&amount, &currency, &startDate, &endDate, &autoRenew)
+	if err != nil {
+		return nil, err
+	}
+	if email, err = s.keyring.Decrypt(email, encryption.UserEmail); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"user_id":         userID,
+		"email":           email,
+		"username":        username,
+		"subscription_id": subscriptionID,
+		"plan_id":         planID,
+		"plan_name":       planName,
+		"status":          status,
+		"amount":          json.Number(amount),
+		"currency":        currency,
+		"start_date":      startDate.UTC().Format("2006-01-02"),
+		"end_date":        endDate.UTC().Format("2006-01-02"),
+		"auto_renew":      autoRenew,
+	}, nil
+}
+
+func (s *Syncer) cursor(ctx context.Context) (cursor, error) {
+	var c cursor
+	err := s.conn.QueryRowContext(ctx,
+		`SELECT last_created_at, last_id::text FROM crm_cursors WHERE name = $1`, cursorName).Scan(&c.at, &c.id)
+	if err == sql.ErrNoRows {
+		return syncStart, nil
+	}
+	return c, err
+}
+
+// advance records the last event read. Runs don't overlap, and an event
+// read twice is queued once, so the latest position simply wins.
+func (s *Syncer) advance(ctx context.Context, to cursor) error {
+	_, err := s.conn.ExecContext(ctx, `
+		INSERT INTO crm_cursors (name, last_created_at, last_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE
+		SET last_created_at = EXCLUDED.last_created_at, last_id = EXCLUDED.last_id, updated_at = NOW()
+		WHERE (crm_cursors.last_created_at, crm_cursors.last_id) < (EXCLUDED.last_created_at, EXCLUDED.last_id)
+	`, cursorName, to.at, to.id)
+	return err
+}
@@ -1,34 +1,133 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/telemetry"
 
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/sirupsen/logrus"
 )
 
 type Connection struct {
 	*sql.DB
+
+	// name labels the pool in telemetry
+	name      string
+	timeouts  timeouts
+	slowQuery time.Duration
+
+	// Prepared statements for hot queries, keyed by query name
+	stmtMu sync.RWMutex
+	stmts  map[string]*sql.Stmt
+
+	replicas []*Connection
+	next     uint64
 }
 
 func NewConnection(cfg config.DatabaseConfig) (*Connection, error) {
+	return NewConnectionWithPassword(cfg, nil)
+}
+
+// NewConnectionWithPassword is like NewConnection but asks password for the
+// credential each time the pool dials, so a rotated database password is used
+// by new connections without a restart. Existing connections are recycled by
+// ConnMaxLifetime.
+func NewConnectionWithPassword(cfg config.DatabaseConfig, password func() string) (*Connection, error) {
+	db, err := open(cfg, cfg.Host, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// Export pool statistics
+	if err := telemetry.RegisterDBStats(db, cfg.DBName); err != nil {
+		logrus.Warnf("Failed to register database pool metrics: %v", err)
+	}
+
+	conn := &Connection{
+		DB:        db,
+		name:      cfg.DBName,
+		timeouts:  newTimeouts(cfg),
+		slowQuery: time.Duration(cfg.SlowQueryMs) * time.Millisecond,
+		stmts:     make(map[string]*sql.Stmt),
+	}
+
+	// Open read replicas; heavy read paths are routed to them via Reader
+	for _, host := range cfg.ReplicaHosts {
+		replica, err := open(cfg, host, password)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("replica %s: %w", host, err)
+		}
+		conn.addReplica(replica, cfg.DBName)
+	}
+	if cfg.ReplicaDSN != "" {
+		// The DSN carries its own credentials, so the rotated primary
+		// password is not applied to it. It is not quoted in errors either.
+		replica, err := openDSN(cfg, cfg.ReplicaDSN, nil)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("replica_dsn: %w", err)
+		}
+		conn.addReplica(replica, cfg.DBName)
+	}
+
+	return conn, nil
+}
+
+// addReplica routes Reader calls to db too, named <dbName>_replica_<n>
+func (c *Connection) addReplica(db *sql.DB, dbName string) {
+	name := fmt.Sprintf("%s_replica_%d", dbName, len(c.replicas))
+	if err := telemetry.RegisterDBStats(db, name); err != nil {
+		logrus.Warnf("Failed to register replica pool metrics: %v", err)
+	}
+	c.replicas = append(c.replicas, &Connection{
+		DB:        db,
+		name:      name,
+		timeouts:  c.timeouts,
+		slowQuery: c.slowQuery,
+		stmts:     make(map[string]*sql.Stmt),
+	})
+}
+
+func open(cfg config.DatabaseConfig, host string, password func() string) (*sql.DB, error) {
 	// Build connection string with proper handling of empty password
 	var dsn string
 	if cfg.Password == "" {
 		dsn = fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=%s",
-			cfg.Host, cfg.Port, cfg.User, cfg.DBName, cfg.SSLMode)
+			host, cfg.Port, cfg.User, cfg.DBName, cfg.SSLMode)
 	} else {
 		dsn = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-			cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
+			host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
 	}
+	return openDSN(cfg, dsn, password)
+}
 
-	db, err := sql.Open("postgres", dsn)
+// openDSN opens a pool on dsn, a keyword/value or URL connection string,
+// with cfg's pool settings
+func openDSN(cfg config.DatabaseConfig, dsn string, password func() string) (*sql.DB, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		// The parse error may quote the DSN, password included
+		return nil, errors.New("failed to parse database config")
+	}
+
+	var opts []stdlib.OptionOpenDB
+	if password != nil {
+		opts = append(opts, stdlib.OptionBeforeConnect(func(ctx context.Context, cc *pgx.ConnConfig) error {
+			cc.Password = password()
+			return nil
+		}))
 	}
+	db := stdlib.OpenDB(*connConfig, opts...)
 
 	// Configure connection pool
 	db.SetMaxOpenConns(cfg.MaxOpenConns)
@@ -37,10 +136,116 @@ func NewConnection(cfg config.DatabaseConfig) (*Connection, error) {
 
 	// Test connection
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &Connection{DB: db}, nil
+	return db, nil
+}
+
+// Reader returns a handle for read-only queries that tolerate replication
+// lag (analytics, reporting, listings). Replicas are used round-robin; when
+// none are configured the primary is returned. Writes and read-after-write
+// lookups must keep using the Connection itself.
+func (c *Connection) Reader() *Connection {
+	if len(c.replicas) == 0 {
+		return c
+	}
+	n := atomic.AddUint64(&c.next, 1)
+	return c.replicas[n%uint64(len(c.replicas))]
+}
+
+// QueryRowNamed executes a single-row query through a prepared statement
+// registered under name, preparing it on first use.
+func (c *Connection) QueryRowNamed(ctx context.Context, name, query string, args ...interface{}) *sql.Row {
+	stmt, err := c.prepared(ctx, name, query)
+	if err != nil {
+		// Fall back to an unprepared query so the caller sees the error on Scan
+		return c.QueryRowContext(WithQueryName(ctx, name), query, args...)
+	}
+
+	bounded := c.timeouts.boundRows(ctx, "db.query_row", name)
+
+	call := c.begin(ctx, bounded, "db.query_row", name, query, args)
+	row := stmt.QueryRowContext(call.ctx, args...)
+	call.end(row.Err())
+	return row
+}
+
+// QueryNamed executes a multi-row query through a prepared statement
+// registered under name, preparing it on first use.
+func (c *Connection) QueryNamed(ctx context.Context, name, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := c.prepared(ctx, name, query)
+	if err != nil {
+		return nil, err
+	}
+
+	bounded := c.timeouts.boundRows(ctx, "db.query", name)
+
+	call := c.begin(ctx, bounded, "db.query", name, query, args)
+	rows, err := stmt.QueryContext(call.ctx, args...)
+	call.end(err)
+	return rows, err
+}
+
+// ExecNamed executes a statement through a prepared statement registered
+// under name, preparing it on first use.
+func (c *Connection) ExecNamed(ctx context.Context, name, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := c.prepared(ctx, name, query)
+	if err != nil {
+		return nil, err
+	}
+
+	bounded, cancel := c.timeouts.bound(ctx, "db.exec", name)
+	defer cancel()
+
+	call := c.begin(ctx, bounded, "db.exec", name, query, args)
+	result, err := stmt.ExecContext(call.ctx, args...)
+	call.end(err)
+	return result, err
+}
+
+func (c *Connection) prepared(ctx context.Context, name, query string) (*sql.Stmt, error) {
+	c.stmtMu.RLock()
+	stmt, ok := c.stmts[name]
+	c.stmtMu.RUnlock()
+	if ok {
+		return stmt, nil
+	}
+
+	c.stmtMu.Lock()
+	defer c.stmtMu.Unlock()
+
+	// Another goroutine may have prepared it while we waited for the lock
+	if stmt, ok := c.stmts[name]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := c.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare statement %s: %w", name, err)
+	}
+	c.stmts[name] = stmt
+	return stmt, nil
+}
+
+// PoolStats reports the primary's pool followed by each replica's
+func (c *Connection) PoolStats() []telemetry.PoolStats {
+	stats := make([]telemetry.PoolStats, 0, 1+len(c.replicas))
+	for _, conn := range append([]*Connection{c}, c.replicas...) {
+		s := conn.Stats()
+		stats = append(stats, telemetry.PoolStats{
+			Pool:         conn.name,
+			Kind:         "postgres",
+			MaxOpen:      s.MaxOpenConnections,
+			Open:         s.OpenConnections,
+			InUse:        s.InUse,
+			Idle:         s.Idle,
+			WaitCount:    s.WaitCount,
+			WaitDuration: s.WaitDuration.Seconds(),
+		})
+	}
+	return stats
 }
 
 func (c *Connection) HealthCheck() error {
@@ -48,5 +253,20 @@ func (c *Connection) HealthCheck() error {
 }
 
 func (c *Connection) Close() error {
+	c.stmtMu.Lock()
+	for name, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil {
+			logrus.Warnf("Failed to close prepared statement %s: %v", name, err)
+		}
+	}
+	c.stmts = make(map[string]*sql.Stmt)
+	c.stmtMu.Unlock()
+
+	for _, replica := range c.replicas {
+		if err := replica.Close(); err != nil {
+			logrus.Warnf("Failed to close replica connection: %v", err)
+		}
+	}
+
 	return c.DB.Close()
 }
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a listing ordered by (created_at, id) descending.
+// Queries continue after it with WHERE (created_at, id) < ($n, $n+1).
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// Encode returns an opaque, URL-safe representation of the cursor
+func (c Cursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor previously produced by Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{CreatedAt: createdAt, ID: parts[1]}, nil
+}
+
+// ParseCursor decodes a listing's cursor parameter. An empty one asks for
+// the first page and is returned as nil.
+func ParseCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return DecodeCursor(s)
+}
+
+// After returns the position a query continues after, as the parameters of
+// its (created_at, id) < ($n, $n+1) condition: nil and "" for the first
+// page, when the cursor is nil.
+func (c *Cursor) After() (*time.Time, string) {
+	if c == nil {
+		return nil, ""
+	}
+	return &c.CreatedAt, c.ID
+}
+
+// Listing page sizes, for PageLimit
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// PageLimit parses a listing's limit parameter, falling back to def when it
+// is missing, not a number or not between 1 and max.
+func PageLimit(s string, def, max int) int {
+	if limit, err := strconv.Atoi(s); err == nil && limit > 0 && limit <= max {
+		return limit
+	}
+	return def
+}
+
+// NextPage trims rows, fetched with one more than limit to know whether
+// another page exists, to limit, and returns the cursor of the next page or
+// "" if there is none. position gives a row's place in the listing.
+func NextPage[T any](rows []T, limit int, position func(T) Cursor) ([]T, string) {
+	if len(rows) <= limit {
+		return rows, ""
+	}
+	rows = rows[:limit]
+	return rows, position(rows[limit-1]).Encode()
+}
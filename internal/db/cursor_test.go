@@ -0,0 +1,76 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	original := Cursor{
+		CreatedAt: time.Date(2024, 3, 15, 10, 30, 0, 123456789, time.UTC),
+		ID:        "6f1c2a9e-7d1b-4f4e-9a53-0d2f0f6c9b11",
+	}
+
+	decoded, err := DecodeCursor(original.Encode())
+	assert.NoError(t, err)
+	assert.True(t, original.CreatedAt.Equal(decoded.CreatedAt))
+	assert.Equal(t, original.ID, decoded.ID)
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	tests := []string{
+		"",
+		"not-base64!!",
+		Cursor{}.Encode(),
+		"bm8tc2VwYXJhdG9y", // "no-separator"
+	}
+
+	for _, input := range tests {
+		_, err := DecodeCursor(input)
+		assert.ErrorIs(t, err, ErrInvalidCursor, "input %q", input)
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	cursor, err := ParseCursor("")
+	assert.NoError(t, err)
+	assert.Nil(t, cursor)
+	after, afterID := cursor.After()
+	assert.Nil(t, after)
+	assert.Empty(t, afterID)
+
+	at := time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+	cursor, err = ParseCursor(Cursor{CreatedAt: at, ID: "job_1"}.Encode())
+	assert.NoError(t, err)
+	after, afterID = cursor.After()
+	assert.True(t, at.Equal(*after))
+	assert.Equal(t, "job_1", afterID)
+
+	_, err = ParseCursor("not-base64!!")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestPageLimit(t *testing.T) {
+	assert.Equal(t, 20, PageLimit("", DefaultPageLimit, MaxPageLimit))
+	assert.Equal(t, 50, PageLimit("50", DefaultPageLimit, MaxPageLimit))
+	assert.Equal(t, 100, PageLimit("100", DefaultPageLimit, MaxPageLimit))
+	assert.Equal(t, 20, PageLimit("101", DefaultPageLimit, MaxPageLimit))
+	assert.Equal(t, 20, PageLimit("0", DefaultPageLimit, MaxPageLimit))
+	assert.Equal(t, 20, PageLimit("ten", DefaultPageLimit, MaxPageLimit))
+	assert.Equal(t, 150, PageLimit("150", 50, 200))
+}
+
+func TestNextPage(t *testing.T) {
+	at := time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+	position := func(id string) Cursor { return Cursor{CreatedAt: at, ID: id} }
+
+	rows, next := NextPage([]string{"c", "b"}, 2, position)
+	assert.Equal(t, []string{"c", "b"}, rows)
+	assert.Empty(t, next)
+
+	rows, next = NextPage([]string{"c", "b", "a"}, 2, position)
+	assert.Equal(t, []string{"c", "b"}, rows)
+	assert.Equal(t, position("b").Encode(), next)
+}
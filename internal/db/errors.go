@@ -0,0 +1,28 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// uniqueViolation is the PostgreSQL SQLSTATE for unique_violation
+const uniqueViolation = "23505"
+
+// IsUniqueViolation reports whether err is a unique constraint or unique
+// index violation on constraint.
+func IsUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == constraint
+}
+
+// foreignKeyViolation is the PostgreSQL SQLSTATE for foreign_key_violation
+const foreignKeyViolation = "23503"
+
+// IsForeignKeyViolation reports whether err is a violation of the foreign
+// key constraint, on either side: a row referencing a missing key, or a
+// referenced row being deleted.
+func IsForeignKeyViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation && pgErr.ConstraintName == constraint
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+	violation := &pgconn.PgError{Code: "23505", ConstraintName: "idx_subscriptions_one_active"}
+
+	assert.True(t, IsUniqueViolation(violation, "idx_subscriptions_one_active"))
+	assert.True(t, IsUniqueViolation(fmt.Errorf("insert: %w", violation), "idx_subscriptions_one_active"))
+	assert.False(t, IsUniqueViolation(violation, "plans_pkey"))
+	assert.False(t, IsUniqueViolation(&pgconn.PgError{Code: "23503", ConstraintName: "idx_subscriptions_one_active"}, "idx_subscriptions_one_active"))
+	assert.False(t, IsUniqueViolation(errors.New("connection reset"), "idx_subscriptions_one_active"))
+	assert.False(t, IsUniqueViolation(nil, "idx_subscriptions_one_active"))
+}
+
+func TestIsForeignKeyViolation(t *testing.T) {
+	violation := &pgconn.PgError{Code: "23503", ConstraintName: "experiments_segment_fkey"}
+
+	assert.True(t, IsForeignKeyViolation(violation, "experiments_segment_fkey"))
+	assert.True(t, IsForeignKeyViolation(fmt.Errorf("delete: %w", violation), "experiments_segment_fkey"))
+	assert.False(t, IsForeignKeyViolation(violation, "subscriptions_plan_id_fkey"))
+	assert.False(t, IsForeignKeyViolation(&pgconn.PgError{Code: "23505", ConstraintName: "experiments_segment_fkey"}, "experiments_segment_fkey"))
+	assert.False(t, IsForeignKeyViolation(nil, "experiments_segment_fkey"))
+}
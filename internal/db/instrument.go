@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The methods below shadow the embedded *sql.DB so every query issued through
+// a Connection (primary or replica) is traced, timed and bounded by its
+// statement timeout without touching call sites.
+
+func (c *Connection) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	name := QueryName(ctx)
+	bounded, cancel := c.timeouts.bound(ctx, "db.exec", name)
+	defer cancel()
+
+	call := c.begin(ctx, bounded, "db.exec", name, query, args)
+	result, err := c.DB.ExecContext(call.ctx, query, args...)
+	call.end(err)
+	return result, err
+}
+
+func (c *Connection) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	name := QueryName(ctx)
+	bounded := c.timeouts.boundRows(ctx, "db.query", name)
+
+	call := c.begin(ctx, bounded, "db.query", name, query, args)
+	rows, err := c.DB.QueryContext(call.ctx, query, args...)
+	call.end(err)
+	return rows, err
+}
+
+func (c *Connection) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	name := QueryName(ctx)
+	bounded := c.timeouts.boundRows(ctx, "db.query_row", name)
+
+	call := c.begin(ctx, bounded, "db.query_row", name, query, args)
+	row := c.DB.QueryRowContext(call.ctx, query, args...)
+	call.end(row.Err())
+	return row
+}
+
+type queryNameKey struct{}
+
+// WithQueryName names the queries made with ctx in spans, latency metrics,
+// slow query logs and named_timeouts, without preparing them the way the
+// *Named methods do.
+func WithQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameKey{}, name)
+}
+
+// QueryName returns the name given to ctx by WithQueryName, or ""
+func QueryName(ctx context.Context) string {
+	name, _ := ctx.Value(queryNameKey{}).(string)
+	return name
+}
+
+// call is one instrumented database call. Queries are timed until their
+// rows are returned, not until they have been read.
+type call struct {
+	ctx    context.Context
+	parent context.Context
+	span   trace.Span
+	start  time.Time
+	slow   time.Duration
+
+	op    string
+	name  string
+	query string
+	args  []interface{}
+}
+
+// begin starts a call made with bounded, the caller's parent context with
+// the statement timeout applied
+func (c *Connection) begin(parent, bounded context.Context, op, name, query string, args []interface{}) *call {
+	ctx, span := startSpan(bounded, op, name, query)
+	return &call{
+		ctx:    ctx,
+		parent: parent,
+		span:   span,
+		start:  time.Now(),
+		slow:   c.slowQuery,
+		op:     op,
+		name:   name,
+		query:  query,
+		args:   args,
+	}
+}
+
+func (q *call) end(err error) {
+	elapsed := time.Since(q.start)
+	endSpan(q.span, err)
+	recordInterrupted(q.parent, q.ctx, q.op, err)
+
+	name := q.name
+	if name == "" {
+		name = "unnamed"
+	}
+	telemetry.RecordDBQuery(strings.TrimPrefix(q.op, "db."), name, elapsed)
+
+	if q.slow > 0 && elapsed >= q.slow {
+		logrus.WithFields(logrus.Fields{
+			"operation":   strings.TrimPrefix(q.op, "db."),
+			"query_name":  name,
+			"duration_ms": elapsed.Milliseconds(),
+			"statement":   truncate(strings.Join(strings.Fields(q.query), " "), maxLoggedStatement),
+			"args":        formatArgs(q.args),
+		}).Warn("Slow database query")
+	}
+}
+
+const (
+	maxLoggedStatement = 500
+	maxLoggedArgs      = 10
+	maxLoggedArg       = 64
+)
+
+// formatArgs renders query arguments for the slow query log, truncating
+// long values and long argument lists
+func formatArgs(args []interface{}) string {
+	parts := make([]string, 0, len(args))
+	for i, arg := range args {
+		if i == maxLoggedArgs {
+			parts = append(parts, fmt.Sprintf("...(%d more)", len(args)-maxLoggedArgs))
+			break
+		}
+		parts = append(parts, truncate(fmt.Sprintf("%v", arg), maxLoggedArg))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	return strings.ToValidUTF8(s[:limit], "") + "..."
+}
+
+func startSpan(ctx context.Context, op, name, query string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", query),
+	}
+	if name != "" {
+		attrs = append(attrs, attribute.String("db.query_name", name))
+	}
+	return telemetry.StartSpan(ctx, op, attrs...)
+}
+
+func endSpan(span trace.Span, err error) {
+	// A missing row is an expected outcome, not a failed query
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	telemetry.EndSpan(span, err)
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// migrations are the schema migrations, applied in file name order
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// MigrationNames lists the embedded migrations in the order they apply.
+func MigrationNames() ([]string, error) {
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Migrate applies the embedded migrations not yet recorded in
+// schema_migrations, each in its own transaction, and returns the names of
+// those it applied. A database migrated by hand before schema_migrations
+// existed has no record of them, so Migrate must only be pointed at an
+// empty database or one it has migrated before.
+func (c *Connection) Migrate(ctx context.Context) ([]string, error) {
+	if _, err := c.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name VARCHAR(255) PRIMARY KEY,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	names, err := MigrationNames()
+	if err != nil {
+		return nil, err
+	}
+	var applied []string
+	for _, name := range names {
+		var done bool
+		if err := c.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE name = $1)`, name).Scan(&done); err != nil {
+			return applied, err
+		}
+		if done {
+			continue
+		}
+		if err := c.applyMigration(ctx, name); err != nil {
+			return applied, fmt.Errorf("migration %s: %w", name, err)
+		}
+		logrus.Infof("Applied migration %s", name)
+		applied = append(applied, name)
+	}
+	return applied, nil
+}
+
+func (c *Connection) applyMigration(ctx context.Context, name string) error {
+	script, err := migrations.ReadFile("migrations/" + name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Without arguments the script runs over the simple protocol, which
+	// accepts several statements at once
+	if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (name) VALUES ($1)`, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
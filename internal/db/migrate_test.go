@@ -0,0 +1,17 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationNamesAreOrdered(t *testing.T) {
+	names, err := MigrationNames()
+	require.NoError(t, err)
+	require.NotEmpty(t, names)
+
+	assert.Equal(t, "001_initial_schema.sql", names[0])
+	assert.IsIncreasing(t, names)
+}
@@ -0,0 +1,11 @@
+-- Renewal reminders sent per subscription period
+-- Migration: 002_renewal_reminders.sql
+
+-- One row per (subscription, period end, lead time) so each reminder is sent once
+CREATE TABLE IF NOT EXISTS renewal_reminders (
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    days_before INTEGER NOT NULL,
+    sent_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (subscription_id, period_end, days_before)
+);
@@ -0,0 +1,9 @@
+-- Per-plan grace period and past_due subscriptions
+-- Migration: 003_grace_period.sql
+
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS grace_period_days INTEGER NOT NULL DEFAULT 0 CHECK (grace_period_days >= 0);
+
+-- Subscriptions whose payment failed or period lapsed sit in past_due during the grace window
+ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS subscriptions_status_check;
+ALTER TABLE subscriptions ADD CONSTRAINT subscriptions_status_check
+    CHECK (status IN ('active', 'past_due', 'cancelled', 'expired', 'suspended', 'pending'));
@@ -0,0 +1,6 @@
+-- Widen monetary columns so three-decimal currencies (KWD, BHD, ...) fit
+-- Migration: 004_money_precision.sql
+
+ALTER TABLE plans ALTER COLUMN price TYPE NUMERIC(19,4);
+ALTER TABLE subscriptions ALTER COLUMN amount TYPE NUMERIC(19,4);
+ALTER TABLE payment_transactions ALTER COLUMN amount TYPE NUMERIC(19,4);
@@ -0,0 +1,6 @@
+-- Row versions for optimistic concurrency control
+-- Migration: 005_row_versions.sql
+
+-- Every update bumps version; writers only succeed if the version they read is still current
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,14 @@
+-- Claim leases and dunning state for the renewal workers
+-- Migration: 006_renewal_claims.sql
+
+-- A worker owns a subscription until claimed_until; rows are claimed with
+-- FOR UPDATE SKIP LOCKED so concurrent workers split the batch instead of
+-- charging the same subscription twice
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP WITH TIME ZONE;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS renewal_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS next_retry_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_renewal_due ON subscriptions(end_date)
+    WHERE status = 'active' AND auto_renew;
+CREATE INDEX IF NOT EXISTS idx_subscriptions_dunning_due ON subscriptions(next_retry_at)
+    WHERE status = 'past_due' AND auto_renew;
@@ -0,0 +1,8 @@
+-- Track manual webhook replays from the admin API
+-- Migration: 008_webhook_replays.sql
+
+ALTER TABLE webhook_events ADD COLUMN IF NOT EXISTS replay_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE webhook_events ADD COLUMN IF NOT EXISTS last_replayed_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_webhook_events_created_at ON webhook_events(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_webhook_events_type ON webhook_events(event_type);
@@ -0,0 +1,18 @@
+-- Two-phase checkout: payment intents confirmed by the customer (3DS/SCA)
+-- Migration: 009_payment_intents.sql
+
+CREATE TABLE IF NOT EXISTS payment_intents (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    gateway VARCHAR(20) NOT NULL,
+    gateway_intent_id VARCHAR(255) NOT NULL UNIQUE,
+    subscription_id UUID REFERENCES subscriptions(id) ON DELETE SET NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    plan_id UUID NOT NULL REFERENCES plans(id) ON DELETE RESTRICT,
+    amount NUMERIC(19,4) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_intents_subscription_id ON payment_intents(subscription_id);
@@ -0,0 +1,23 @@
+-- Chargebacks raised against payment transactions
+-- Migration: 010_disputes.sql
+
+CREATE TABLE IF NOT EXISTS disputes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    gateway_dispute_id VARCHAR(255) NOT NULL UNIQUE,
+    transaction_id UUID REFERENCES payment_transactions(id) ON DELETE SET NULL,
+    subscription_id UUID REFERENCES subscriptions(id) ON DELETE SET NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    plan_id UUID REFERENCES plans(id) ON DELETE SET NULL,
+    amount NUMERIC(19,4) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    reason VARCHAR(50),
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'won', 'lost')),
+    subscription_suspended BOOLEAN NOT NULL DEFAULT false,
+    closed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_disputes_created_at ON disputes(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_disputes_plan_id ON disputes(plan_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_payment_transactions_gateway_transaction_id ON payment_transactions(gateway_transaction_id);
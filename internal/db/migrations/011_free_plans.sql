@@ -0,0 +1,11 @@
+-- Free plans: zero-price plans that need no payment method
+-- Migration: 011_free_plans.sql
+
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS plan_type VARCHAR(10) NOT NULL DEFAULT 'paid'
+    CHECK (plan_type IN ('paid', 'free'));
+
+ALTER TABLE plans ADD CONSTRAINT plans_free_price_zero CHECK (plan_type <> 'free' OR price = 0);
+
+-- Users are downgraded onto the one active free plan
+CREATE UNIQUE INDEX IF NOT EXISTS idx_plans_active_free ON plans(plan_type) WHERE plan_type = 'free' AND is_active;
+
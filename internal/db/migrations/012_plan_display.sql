@@ -0,0 +1,5 @@
+-- Pricing page display metadata for plans
+-- Migration: 012_plan_display.sql
+
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS display_order INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS badge VARCHAR(50);
@@ -0,0 +1,34 @@
+-- A/B price experiments and their exposure and conversion events
+-- Migration: 013_experiments.sql
+
+CREATE TABLE IF NOT EXISTS experiments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    key VARCHAR(100) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'running', 'stopped')),
+    variants JSONB NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE,
+    stopped_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_experiments_created_at ON experiments(created_at DESC, id DESC);
+
+-- One exposure and at most one conversion per user and experiment
+CREATE TABLE IF NOT EXISTS experiment_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    experiment_id UUID NOT NULL REFERENCES experiments(id) ON DELETE CASCADE,
+    variant VARCHAR(50) NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event_type VARCHAR(20) NOT NULL CHECK (event_type IN ('exposure', 'conversion')),
+    plan_id UUID REFERENCES plans(id) ON DELETE SET NULL,
+    subscription_id UUID REFERENCES subscriptions(id) ON DELETE SET NULL,
+    amount NUMERIC(19,4),
+    currency VARCHAR(3),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (experiment_id, user_id, event_type)
+);
+
+CREATE INDEX IF NOT EXISTS idx_experiment_events_user_id ON experiment_events(user_id, event_type);
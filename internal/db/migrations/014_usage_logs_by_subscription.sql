@@ -0,0 +1,4 @@
+-- Index the usage ledger for per-plan usage statistics
+-- Migration: 014_usage_logs_by_subscription.sql
+
+CREATE INDEX IF NOT EXISTS idx_usage_logs_subscription_id ON usage_logs(subscription_id, created_at);
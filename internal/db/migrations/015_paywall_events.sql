@@ -0,0 +1,18 @@
+-- Paywall decisions for content and conversion analytics
+-- Migration: 015_paywall_events.sql
+
+-- No foreign keys: events are written in batches off the request path and
+-- must not be rejected for IDs the paywall was merely asked about
+CREATE TABLE IF NOT EXISTS paywall_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id VARCHAR(255) NOT NULL,
+    content_id VARCHAR(255) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    plan_id VARCHAR(255),
+    allowed BOOLEAN NOT NULL,
+    reason VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_paywall_events_created_at ON paywall_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_paywall_events_denied ON paywall_events(created_at, user_id) WHERE NOT allowed;
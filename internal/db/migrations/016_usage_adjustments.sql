@@ -0,0 +1,8 @@
+-- Quota adjustments in the usage ledger
+-- Migration: 016_usage_adjustments.sql
+
+-- 'usage' rows are allowed paywall actions; 'adjustment' rows record admin
+-- quota resets and boosts, with the details in metadata
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'usage';
+
+CREATE INDEX IF NOT EXISTS idx_usage_logs_adjustments ON usage_logs(user_id, created_at) WHERE kind = 'adjustment';
@@ -0,0 +1,24 @@
+-- Prorated refunds and credits for immediate cancellations
+-- Migration: 017_refunds.sql
+
+-- The charge that paid for a period doubles as its invoice; these track how
+-- much of it has since been given back
+ALTER TABLE payment_transactions ADD COLUMN IF NOT EXISTS refunded_amount NUMERIC(19,4) NOT NULL DEFAULT 0;
+ALTER TABLE payment_transactions ADD COLUMN IF NOT EXISTS credited_amount NUMERIC(19,4) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    transaction_id UUID NOT NULL REFERENCES payment_transactions(id) ON DELETE CASCADE,
+    subscription_id UUID REFERENCES subscriptions(id) ON DELETE SET NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('refund', 'credit')),
+    amount NUMERIC(19,4) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('completed', 'failed')),
+    gateway_refund_id VARCHAR(255),
+    reason VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_transaction_id ON refunds(transaction_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_user_id ON refunds(user_id, created_at);
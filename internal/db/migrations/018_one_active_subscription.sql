@@ -0,0 +1,9 @@
+-- At most one active subscription per user
+-- Migration: 018_one_active_subscription.sql
+
+-- Enforces what CreateSubscription used to check with a racy read. Creating
+-- the index fails if duplicates already exist; find them with
+--   SELECT user_id FROM subscriptions WHERE status = 'active'
+--   GROUP BY user_id HAVING COUNT(*) > 1;
+-- and cancel the extra subscriptions first.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_subscriptions_one_active ON subscriptions(user_id) WHERE status = 'active';
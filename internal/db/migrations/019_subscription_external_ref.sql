@@ -0,0 +1,6 @@
+-- Caller-supplied order IDs for idempotent subscription creation
+-- Migration: 019_subscription_external_ref.sql
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS external_ref VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_subscriptions_external_ref ON subscriptions(user_id, external_ref) WHERE external_ref IS NOT NULL;
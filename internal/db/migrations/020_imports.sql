@@ -0,0 +1,25 @@
+-- Bulk imports of plans and legacy subscribers
+-- Migration: 020_imports.sql
+
+CREATE TABLE IF NOT EXISTS imports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('plans', 'subscribers')),
+    format VARCHAR(10) NOT NULL CHECK (format IN ('csv', 'jsonl')),
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'completed')),
+    -- The uploaded file, kept so a retried job can resume where it stopped
+    data TEXT NOT NULL,
+    total_rows INTEGER NOT NULL,
+    processed_rows INTEGER NOT NULL DEFAULT 0,
+    imported_rows INTEGER NOT NULL DEFAULT 0,
+    failed_rows INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS import_errors (
+    import_id UUID NOT NULL REFERENCES imports(id) ON DELETE CASCADE,
+    line INTEGER NOT NULL,
+    error TEXT NOT NULL,
+    PRIMARY KEY (import_id, line)
+);
@@ -0,0 +1,16 @@
+-- The plan as bought, so plan edits don't change existing subscriptions
+-- Migration: 021_subscription_plan_snapshot.sql
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS plan_snapshot JSONB;
+
+-- Existing subscriptions get their plan as it is now
+UPDATE subscriptions s
+SET plan_snapshot = jsonb_build_object(
+    'name', p.name, 'price', p.price, 'currency', p.currency, 'billing_cycle', p.billing_cycle,
+    'type', p.plan_type, 'features', p.features, 'max_usage_per_day', p.max_usage_per_day,
+    'max_usage_per_month', p.max_usage_per_month, 'grace_period_days', p.grace_period_days,
+    'captured_at', NOW())
+FROM plans p
+WHERE p.id = s.plan_id AND s.plan_snapshot IS NULL;
+
+ALTER TABLE subscriptions ALTER COLUMN plan_snapshot SET NOT NULL;
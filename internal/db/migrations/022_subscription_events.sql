@@ -0,0 +1,54 @@
+-- Subscription history for the support timeline
+-- Migration: 022_subscription_events.sql
+
+-- Subscriptions are updated from many places (renewals, dunning, the lapse
+-- sweep, cancellations, disputes, webhooks), so changes are recorded by a
+-- trigger rather than by each writer
+CREATE TABLE IF NOT EXISTS subscription_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    event VARCHAR(30) NOT NULL CHECK (event IN ('created', 'status_changed', 'plan_changed', 'period_extended', 'renewal_failed')),
+    from_value TEXT,
+    to_value TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscription_events_subscription_id ON subscription_events(subscription_id, created_at);
+
+CREATE OR REPLACE FUNCTION record_subscription_events()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO subscription_events (subscription_id, event, to_value)
+        VALUES (NEW.id, 'created', NEW.status);
+        RETURN NULL;
+    END IF;
+
+    IF NEW.status IS DISTINCT FROM OLD.status THEN
+        INSERT INTO subscription_events (subscription_id, event, from_value, to_value)
+        VALUES (NEW.id, 'status_changed', OLD.status, NEW.status);
+    END IF;
+    IF NEW.plan_id IS DISTINCT FROM OLD.plan_id THEN
+        INSERT INTO subscription_events (subscription_id, event, from_value, to_value)
+        VALUES (NEW.id, 'plan_changed', OLD.plan_id::text, NEW.plan_id::text);
+    END IF;
+    IF NEW.end_date > OLD.end_date THEN
+        INSERT INTO subscription_events (subscription_id, event, from_value, to_value)
+        VALUES (NEW.id, 'period_extended', OLD.end_date::text, NEW.end_date::text);
+    END IF;
+    -- A dunning attempt: the renewal charge failed and was scheduled again
+    IF NEW.renewal_attempts > OLD.renewal_attempts THEN
+        INSERT INTO subscription_events (subscription_id, event, from_value, to_value)
+        VALUES (NEW.id, 'renewal_failed', OLD.renewal_attempts::text, NEW.renewal_attempts::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS record_subscription_events ON subscriptions;
+CREATE TRIGGER record_subscription_events AFTER INSERT OR UPDATE ON subscriptions FOR EACH ROW EXECUTE FUNCTION record_subscription_events();
+
+-- Webhook events name the subscription, payment intent or dispute they
+-- concern in their payload
+CREATE INDEX IF NOT EXISTS idx_webhook_events_subscription_id ON webhook_events((payload->'data'->>'subscription_id'));
+CREATE INDEX IF NOT EXISTS idx_webhook_events_object_id ON webhook_events((payload->'data'->>'id'));
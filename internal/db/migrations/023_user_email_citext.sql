@@ -0,0 +1,12 @@
+-- Case-insensitive, normalized user emails
+-- Migration: 023_user_email_citext.sql
+
+CREATE EXTENSION IF NOT EXISTS citext;
+
+-- Emails are stored trimmed and lowercased. Users whose emails differ only
+-- in case or surrounding spaces make this fail on users_email_key; merge
+-- them before migrating.
+UPDATE users SET email = LOWER(TRIM(email)) WHERE email <> LOWER(TRIM(email));
+
+-- users_email_key now compares emails case-insensitively
+ALTER TABLE users ALTER COLUMN email TYPE CITEXT;
@@ -0,0 +1,18 @@
+-- User status lifecycle: suspension, bans and unverified accounts
+-- Migration: 024_user_statuses.sql
+
+-- inactive is replaced by suspended, which also pauses billing
+UPDATE users SET status = 'suspended' WHERE status = 'inactive';
+UPDATE users SET status = 'active' WHERE status IS NULL;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_status_check;
+ALTER TABLE users ADD CONSTRAINT users_status_check
+    CHECK (status IN ('active', 'suspended', 'banned', 'pending_verification'));
+ALTER TABLE users ALTER COLUMN status SET NOT NULL;
+
+-- Why and when an admin last changed the status
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_reason TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP WITH TIME ZONE;
+
+-- Renewal, dunning and lapse queries skip blocked users' subscriptions
+CREATE INDEX IF NOT EXISTS idx_users_blocked ON users(id) WHERE status IN ('suspended', 'banned');
@@ -0,0 +1,7 @@
+-- Integrator metadata on users and subscriptions
+-- Migration: 025_metadata.sql
+
+-- Flat key/value pairs such as CRM IDs and campaign attribution, validated
+-- by the API (internal/metadata)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,27 @@
+-- Customer segments for targeted offers
+-- Migration: 026_segments.sql
+
+-- ISO 3166-1 alpha-2 code, for segments by country
+ALTER TABLE users ADD COLUMN IF NOT EXISTS country CHAR(2);
+
+-- Rules over plan, usage, tenure and country (internal/segment); a user is
+-- in a segment when they match all of its rules
+CREATE TABLE IF NOT EXISTS segments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    key VARCHAR(100) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    rules JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_segments_created_at ON segments(created_at DESC, id DESC);
+
+-- An experiment targeted at a segment only assigns its members; a segment
+-- can't be deleted while an experiment targets it
+ALTER TABLE experiments ADD COLUMN IF NOT EXISTS segment VARCHAR(100)
+    CONSTRAINT experiments_segment_fkey REFERENCES segments(key);
+
+-- Usage rules count a user's recent paywall actions
+CREATE INDEX IF NOT EXISTS idx_usage_logs_user_usage ON usage_logs(user_id, created_at) WHERE kind = 'usage';
@@ -0,0 +1,32 @@
+-- Win-back offers made to cancelling subscribers
+-- Migration: 027_retention_offers.sql
+
+-- An accepted discount takes discount_percent off the next
+-- discount_renewals renewal charges
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS discount_percent INTEGER NOT NULL DEFAULT 0
+    CHECK (discount_percent BETWEEN 0 AND 100);
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS discount_renewals INTEGER NOT NULL DEFAULT 0
+    CHECK (discount_renewals >= 0);
+
+-- An accepted pause moves end_date to when billing resumes; access stops
+-- from paused_from, the end of the period already paid for, until then
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS paused_from TIMESTAMP WITH TIME ZONE;
+
+-- Every offer presented, with its terms as offered and how the subscriber
+-- answered; the save rate is accepted over presented
+CREATE TABLE IF NOT EXISTS retention_offers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    offer_key VARCHAR(100) NOT NULL,
+    offer_type VARCHAR(20) NOT NULL CHECK (offer_type IN ('discount', 'pause')),
+    percent_off INTEGER,
+    renewals INTEGER,
+    pause_months INTEGER,
+    status VARCHAR(20) NOT NULL DEFAULT 'presented' CHECK (status IN ('presented', 'accepted', 'declined')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    responded_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_retention_offers_subscription_id ON retention_offers(subscription_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_retention_offers_created_at ON retention_offers(created_at);
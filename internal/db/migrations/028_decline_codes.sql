@@ -0,0 +1,6 @@
+-- Normalized decline codes on declined charges
+-- Migration: 028_decline_codes.sql
+
+-- One of insufficient_funds, card_expired, do_not_honor, fraud_suspected or
+-- other; the gateway's own code is kept in gateway_response
+ALTER TABLE payment_transactions ADD COLUMN IF NOT EXISTS decline_code VARCHAR(30);
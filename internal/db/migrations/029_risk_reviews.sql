@@ -0,0 +1,31 @@
+-- Payments held by the risk checks for manual review
+-- Migration: 029_risk_reviews.sql
+
+-- One pending review per user and payment method; repeated attempts while it
+-- is pending are counted on it. An approval lets the same user and payment
+-- method through review-level checks for a day, a rejection blocks both.
+CREATE TABLE IF NOT EXISTS risk_reviews (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    payment_method VARCHAR(255) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45),
+    plan_id VARCHAR(100),
+    amount NUMERIC(19,4) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    score INTEGER NOT NULL CHECK (score BETWEEN 0 AND 100),
+    reasons JSONB NOT NULL DEFAULT '[]',
+    attempts INTEGER NOT NULL DEFAULT 1,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'rejected')),
+    reviewed_by VARCHAR(255),
+    note TEXT,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_risk_reviews_pending
+    ON risk_reviews(user_id, payment_method) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_risk_reviews_status_created ON risk_reviews(status, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_risk_reviews_payment_method ON risk_reviews(payment_method)
+    WHERE status = 'rejected';
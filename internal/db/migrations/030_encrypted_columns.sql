@@ -0,0 +1,13 @@
+-- Application-layer encryption of sensitive columns
+-- Migration: 030_encrypted_columns.sql
+
+-- With encryption.encrypt_email on, users.email holds an encrypted envelope
+-- and uniqueness and lookups move to email_hash, a keyed hash of the
+-- normalized address. Rows written before then keep a NULL hash until the
+-- rotation job encrypts them.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_hash VARCHAR(64);
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_hash_key ON users(email_hash);
+
+-- Encrypted webhook events keep only their IDs in payload, for lookups and
+-- the subscription timeline; the full event is in payload_ciphertext
+ALTER TABLE webhook_events ADD COLUMN IF NOT EXISTS payload_ciphertext TEXT;
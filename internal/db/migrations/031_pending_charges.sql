@@ -0,0 +1,13 @@
+-- Renewal charges that settle asynchronously (SEPA/ACH bank debits)
+-- Migration: 031_pending_charges.sql
+
+-- A renewal whose charge is still settling records the charge's gateway ID,
+-- the end date the renewal extends to and the end date before it, restored
+-- if the charge fails. Subscriptions with a pending charge are not claimed
+-- for renewal or dunning, so they are never debited twice.
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS pending_charge_id VARCHAR(255);
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS pending_period_end TIMESTAMP WITH TIME ZONE;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS pending_prior_end TIMESTAMP WITH TIME ZONE;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_subscriptions_pending_charge_id
+    ON subscriptions(pending_charge_id) WHERE pending_charge_id IS NOT NULL;
+
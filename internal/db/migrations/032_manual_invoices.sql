@@ -0,0 +1,29 @@
+-- Manual invoices for offline payment (bank transfer, crypto, purchase orders)
+-- Migration: 032_manual_invoices.sql
+
+-- Subscriptions paid by invoice wait in pending_payment until it is paid
+ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS subscriptions_status_check;
+ALTER TABLE subscriptions ADD CONSTRAINT subscriptions_status_check
+    CHECK (status IN ('active', 'past_due', 'cancelled', 'expired', 'suspended', 'pending', 'pending_payment'));
+
+-- number is the reference the customer quotes with their payment; renewal
+-- invoices also hold their subscription's renewal as its pending charge
+CREATE TABLE IF NOT EXISTS manual_invoices (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    number VARCHAR(32) NOT NULL UNIQUE,
+    subscription_id UUID REFERENCES subscriptions(id) ON DELETE SET NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    plan_id UUID NOT NULL REFERENCES plans(id) ON DELETE RESTRICT,
+    amount NUMERIC(19,4) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'overdue', 'paid', 'void')),
+    due_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    paid_at TIMESTAMP WITH TIME ZONE,
+    paid_by VARCHAR(255),
+    payment_reference VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_manual_invoices_user_id ON manual_invoices(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_manual_invoices_due ON manual_invoices(due_at) WHERE status IN ('open', 'overdue');
@@ -0,0 +1,10 @@
+-- Custom billing period lengths and billing-day alignment
+-- Migration: 033_billing_cycles.sql
+
+-- A plan renews every billing_interval billing_cycles (every 3 months, every
+-- 2 weeks); monthly and yearly plans with an anchor day renew on that day of
+-- the month instead of on each subscription's anniversary
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS billing_interval INTEGER NOT NULL DEFAULT 1
+    CHECK (billing_interval BETWEEN 1 AND 52);
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS billing_anchor_day INTEGER
+    CHECK (billing_anchor_day BETWEEN 1 AND 31);
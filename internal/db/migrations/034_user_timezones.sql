@@ -0,0 +1,5 @@
+-- Per-user timezones for usage limits
+-- Migration: 034_user_timezones.sql
+
+-- IANA timezone name; daily paywall usage counters reset at midnight in it
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64);
@@ -0,0 +1,9 @@
+-- Usage rollover and overage grace per plan
+-- Migration: 035_usage_rollover.sql
+
+-- Up to usage_rollover_cap of a usage period's unused limit carries into
+-- the next (NULL: none); usage may run usage_overage_percent past the limit
+-- before it is blocked
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS usage_rollover_cap INTEGER CHECK (usage_rollover_cap >= 0);
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS usage_overage_percent INTEGER NOT NULL DEFAULT 0
+    CHECK (usage_overage_percent BETWEEN 0 AND 100);
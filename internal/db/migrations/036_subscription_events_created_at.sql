@@ -0,0 +1,6 @@
+-- Recent subscription history for the entitlement change stream
+-- Migration: 036_subscription_events_created_at.sql
+
+-- The change relay reads every subscription's events of the last few
+-- seconds
+CREATE INDEX IF NOT EXISTS idx_subscription_events_created_at ON subscription_events(created_at);
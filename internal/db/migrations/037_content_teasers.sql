@@ -0,0 +1,13 @@
+-- Upsell walls shown in place of locked content
+-- Migration: 037_content_teasers.sql
+
+-- How much of a piece of content to preview and which plans the wall
+-- offers (a JSON array of plan IDs; empty offers every active paid plan)
+CREATE TABLE IF NOT EXISTS content_teasers (
+    content_id VARCHAR(255) PRIMARY KEY,
+    preview_percent INTEGER NOT NULL DEFAULT 0 CHECK (preview_percent BETWEEN 0 AND 100),
+    required_plan_ids JSONB NOT NULL DEFAULT '[]',
+    cta_text VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,30 @@
+-- Hosted checkout sessions
+-- Migration: 038_checkout_sessions.sql
+
+-- A session fixes the plan, price and coupon of a checkout for the page
+-- that completes it. Only a hash of its token is kept. amount is the first
+-- charge, after the coupon; subscription_id is set when it completes.
+-- claimed_until leases it to the request charging for it.
+CREATE TABLE IF NOT EXISTS checkout_sessions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    plan_id UUID NOT NULL REFERENCES plans(id) ON DELETE RESTRICT,
+    coupon_code VARCHAR(64),
+    percent_off INTEGER NOT NULL DEFAULT 0 CHECK (percent_off BETWEEN 0 AND 100),
+    discount_renewals INTEGER NOT NULL DEFAULT 0 CHECK (discount_renewals >= 0),
+    price NUMERIC(19,4) NOT NULL,
+    amount NUMERIC(19,4) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    auto_renew BOOLEAN NOT NULL DEFAULT true,
+    success_url TEXT NOT NULL,
+    cancel_url TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'completed')),
+    claimed_until TIMESTAMP WITH TIME ZONE,
+    subscription_id UUID REFERENCES subscriptions(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_checkout_sessions_user_id ON checkout_sessions(user_id, created_at);
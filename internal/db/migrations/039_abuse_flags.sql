@@ -0,0 +1,25 @@
+-- Users flagged for getting around metered paywall limits
+-- Migration: 039_abuse_flags.sql
+
+-- One pending flag per user; later signals while it is pending raise its
+-- score and are counted in hits. A dismissed flag keeps the user from being
+-- flagged again for a day.
+CREATE TABLE IF NOT EXISTS abuse_flags (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id VARCHAR(255),
+    ip_address VARCHAR(45),
+    score INTEGER NOT NULL CHECK (score BETWEEN 0 AND 100),
+    reasons JSONB NOT NULL DEFAULT '[]',
+    hits INTEGER NOT NULL DEFAULT 1,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'confirmed', 'dismissed')),
+    reviewed_by VARCHAR(255),
+    note TEXT,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_abuse_flags_pending ON abuse_flags(user_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_abuse_flags_status_created ON abuse_flags(status, created_at DESC);
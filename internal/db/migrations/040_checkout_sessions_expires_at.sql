@@ -0,0 +1,5 @@
+-- Expired checkout sessions for retention
+-- Migration: 040_checkout_sessions_expires_at.sql
+
+-- Retention purges sessions left open past their expiry, oldest first
+CREATE INDEX IF NOT EXISTS idx_checkout_sessions_open_expires_at ON checkout_sessions(expires_at) WHERE status = 'open';
@@ -0,0 +1,11 @@
+-- Progress of the event export to the object store
+-- Migration: 041_export_cursors.sql
+
+-- One row per exported table: the created_at and id of the last event
+-- written, events being exported in that order
+CREATE TABLE IF NOT EXISTS export_cursors (
+    stream VARCHAR(50) PRIMARY KEY,
+    last_created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_id UUID NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
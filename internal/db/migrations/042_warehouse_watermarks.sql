@@ -0,0 +1,15 @@
+-- Progress of the warehouse sync
+-- Migration: 042_warehouse_watermarks.sql
+
+-- One row per synced table: the watermark (updated_at or created_at) and
+-- id of the last row sent, rows being synced in that order
+CREATE TABLE IF NOT EXISTS warehouse_watermarks (
+    table_name VARCHAR(50) PRIMARY KEY,
+    last_changed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_id UUID NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- The sync reads changed rows in watermark order
+CREATE INDEX IF NOT EXISTS idx_subscriptions_updated_at ON subscriptions(updated_at, id);
+CREATE INDEX IF NOT EXISTS idx_payment_transactions_updated_at ON payment_transactions(updated_at, id);
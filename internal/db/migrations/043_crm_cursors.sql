@@ -0,0 +1,10 @@
+-- Progress of the CRM sync
+-- Migration: 043_crm_cursors.sql
+
+-- The created_at and id of the last subscription event the CRM sync read
+CREATE TABLE IF NOT EXISTS crm_cursors (
+    name VARCHAR(50) PRIMARY KEY,
+    last_created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_id UUID NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,18 @@
+-- Sequential invoice numbers per series
+-- Migration: 044_invoice_series.sql
+
+-- The tenant an invoice was issued for picks its number format; renewal
+-- invoices keep their subscription's. A format with {tenant} can make
+-- numbers longer than the old random ones.
+ALTER TABLE manual_invoices ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE manual_invoices ALTER COLUMN number TYPE VARCHAR(64);
+
+-- series is an invoice number format with everything but {seq} filled in,
+-- e.g. INV-DE-2026-{seq}. last_number is taken and bumped in the
+-- transaction that stores the invoice, so numbers have no gaps.
+CREATE TABLE IF NOT EXISTS invoice_series (
+    series VARCHAR(255) PRIMARY KEY,
+    last_number BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
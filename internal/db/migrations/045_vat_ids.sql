@@ -0,0 +1,16 @@
+-- EU VAT IDs of business customers and the tax treatment of their sales
+-- Migration: 045_vat_ids.sql
+
+-- The VAT ID VIES last confirmed for the user at checkout
+ALTER TABLE users ADD COLUMN IF NOT EXISTS vat_id VARCHAR(20);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS vat_checked_at TIMESTAMP WITH TIME ZONE;
+
+-- Sales to a business with a VAT ID from another member state than the
+-- seller's are reverse_charge; everything else is standard
+ALTER TABLE checkout_sessions ADD COLUMN IF NOT EXISTS vat_id VARCHAR(20);
+ALTER TABLE checkout_sessions ADD COLUMN IF NOT EXISTS tax_treatment VARCHAR(20) NOT NULL DEFAULT 'standard'
+    CHECK (tax_treatment IN ('standard', 'reverse_charge'));
+
+ALTER TABLE manual_invoices ADD COLUMN IF NOT EXISTS vat_id VARCHAR(20);
+ALTER TABLE manual_invoices ADD COLUMN IF NOT EXISTS tax_treatment VARCHAR(20) NOT NULL DEFAULT 'standard'
+    CHECK (tax_treatment IN ('standard', 'reverse_charge'));
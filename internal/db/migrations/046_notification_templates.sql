@@ -0,0 +1,21 @@
+-- Notification templates per tenant
+-- Migration: 046_notification_templates.sql
+
+-- The X-Tenant-ID a user was created under picks their notifications'
+-- templates
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(255) NOT NULL DEFAULT '';
+
+-- A tenant's email and webhook templates for a notification type; the
+-- empty tenant holds the defaults for tenants without their own. Types
+-- and channels without a row use the built-in templates.
+CREATE TABLE IF NOT EXISTS notification_templates (
+    tenant_id VARCHAR(255) NOT NULL DEFAULT '',
+    type VARCHAR(100) NOT NULL,
+    channel VARCHAR(20) NOT NULL CHECK (channel IN ('email', 'webhook')),
+    subject TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    updated_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (tenant_id, type, channel)
+);
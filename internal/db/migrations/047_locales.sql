@@ -0,0 +1,11 @@
+-- Locales for users and notification templates
+-- Migration: 047_locales.sql
+
+-- The BCP 47 tag a user's notifications are translated into
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(35);
+
+-- Templates per locale; the empty locale holds the templates used for
+-- locales without their own
+ALTER TABLE notification_templates ADD COLUMN IF NOT EXISTS locale VARCHAR(35) NOT NULL DEFAULT '';
+ALTER TABLE notification_templates DROP CONSTRAINT IF EXISTS notification_templates_pkey;
+ALTER TABLE notification_templates ADD PRIMARY KEY (tenant_id, locale, type, channel);
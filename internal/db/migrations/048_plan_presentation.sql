@@ -0,0 +1,6 @@
+-- Plan presentation for the pricing page
+-- Migration: 048_plan_presentation.sql
+
+-- Selling points listed under the plan, and the label of its signup button
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS marketing_bullets JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS cta_text VARCHAR(50);
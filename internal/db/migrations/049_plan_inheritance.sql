@@ -0,0 +1,26 @@
+-- Plan feature inheritance
+-- Migration: 049_plan_inheritance.sql
+
+-- A plan inherits its parent's features, its own features overriding them
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS parent_plan_id UUID REFERENCES plans(id) ON DELETE RESTRICT;
+CREATE INDEX IF NOT EXISTS idx_plans_parent_plan_id ON plans(parent_plan_id) WHERE parent_plan_id IS NOT NULL;
+
+-- The features a plan grants: its ancestors' merged from the root down, each
+-- plan's own replacing those it inherits. The walk stops after 10 plans so
+-- a cycle written behind the API's back can't loop.
+CREATE OR REPLACE FUNCTION plan_effective_features(plan UUID) RETURNS JSONB AS $$
+    WITH RECURSIVE chain AS (
+        SELECT id, parent_plan_id, features, 0 AS depth FROM plans WHERE id = plan
+        UNION ALL
+        SELECT p.id, p.parent_plan_id, p.features, c.depth + 1
+        FROM plans p JOIN chain c ON p.id = c.parent_plan_id
+        WHERE c.depth < 9
+    )
+    SELECT COALESCE(jsonb_object_agg(key, value), '{}'::jsonb)
+    FROM (
+        SELECT DISTINCT ON (f.key) f.key, f.value
+        FROM chain, jsonb_each(CASE WHEN jsonb_typeof(chain.features) = 'object'
+            THEN chain.features ELSE '{}'::jsonb END) AS f
+        ORDER BY f.key, chain.depth
+    ) effective
+$$ LANGUAGE SQL STABLE;
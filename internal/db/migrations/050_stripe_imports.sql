@@ -0,0 +1,17 @@
+-- Stripe Billing migrations
+-- Migration: 050_stripe_imports.sql
+
+-- The user each migrated Stripe customer became, so a migration run again
+-- finds them
+CREATE TABLE IF NOT EXISTS stripe_customers (
+    customer_id VARCHAR(255) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- The plan created for each Stripe price not mapped in the configuration
+CREATE TABLE IF NOT EXISTS stripe_prices (
+    price_id VARCHAR(255) PRIMARY KEY,
+    plan_id UUID NOT NULL REFERENCES plans(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,21 @@
+-- API keys and IP allowlists
+-- Migration: 052_api_keys.sql
+
+-- Keys are stored as SHA-256 hashes; prefix is the start of the key, kept
+-- to tell keys apart. scopes and allowed_cidrs are JSON arrays of strings,
+-- an empty allowlist allowing every address.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes JSONB NOT NULL,
+    allowed_cidrs JSONB NOT NULL DEFAULT '[]',
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+-- The addresses a user may sign in and use sessions from
+ALTER TABLE users ADD COLUMN IF NOT EXISTS allowed_cidrs JSONB NOT NULL DEFAULT '[]';
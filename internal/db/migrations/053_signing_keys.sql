@@ -0,0 +1,16 @@
+-- Request signing keys
+-- Migration: 053_signing_keys.sql
+
+-- Shared secrets edge services sign paywall checks with. The secret is
+-- encrypted like other columns when encryption is on; prefix is its start,
+-- kept to tell keys apart.
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    secret TEXT NOT NULL,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
@@ -0,0 +1,17 @@
+-- Database-backed sessions
+-- Migration: 054_user_sessions.sql
+
+-- Sessions are kept here as well as in Redis, so they can be validated
+-- while Redis is down. Tokens are stored as SHA-256 hashes; allowed_cidrs
+-- is the JSON array of addresses the session may be used from, any when
+-- empty. Revoking a user's sessions deletes their rows.
+CREATE TABLE IF NOT EXISTS user_sessions (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issued_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    verified_at TIMESTAMP WITH TIME ZONE,
+    allowed_cidrs JSONB NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id, expires_at);
@@ -0,0 +1,12 @@
+-- Gateway event IDs
+-- Migration: 055_webhook_provider_event_ids.sql
+
+-- Gateways send their own event IDs ("evt_...", "WH-..."), which are not
+-- UUIDs. They are kept in provider_event_id, unique per source so
+-- redeliveries are recognised; id stays the UUID the admin API addresses
+-- events by. Events stored before this migration were keyed by their UUID.
+ALTER TABLE webhook_events ADD COLUMN IF NOT EXISTS provider_event_id TEXT;
+UPDATE webhook_events SET provider_event_id = id::text WHERE provider_event_id IS NULL;
+ALTER TABLE webhook_events ALTER COLUMN provider_event_id SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_webhook_events_provider_event_id ON webhook_events(source, provider_event_id);
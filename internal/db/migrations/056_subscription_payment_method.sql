@@ -0,0 +1,18 @@
+-- Subscription payment method column
+-- Migration: 056_subscription_payment_method.sql
+
+-- The initial schema named the column payment_method_id, but the services
+-- have always read and written payment_method. Databases that were patched
+-- by hand already have payment_method and are left alone.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'subscriptions' AND column_name = 'payment_method_id'
+    ) AND NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'subscriptions' AND column_name = 'payment_method'
+    ) THEN
+        ALTER TABLE subscriptions RENAME COLUMN payment_method_id TO payment_method;
+    END IF;
+END $$;
@@ -0,0 +1,13 @@
+-- Charging gateways and credit use
+-- Migration: 057_transaction_gateways.sql
+
+-- The gateway a charge went through, which refunds it; routing may have
+-- moved the user to another gateway since. Charges stored before this
+-- migration have none and are refunded through the current routing.
+ALTER TABLE payment_transactions ADD COLUMN IF NOT EXISTS gateway VARCHAR(20);
+
+-- How much of each account credit renewals have paid with
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS applied_amount NUMERIC(19,4) NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_refunds_unused_credit ON refunds(user_id, currency, created_at)
+    WHERE kind = 'credit' AND status = 'completed' AND applied_amount < amount;
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/telemetry"
+)
+
+// timeouts bound every call made through a Connection, whatever context the
+// caller passed. A caller's earlier deadline still wins.
+type timeouts struct {
+	query time.Duration
+	exec  time.Duration
+	named map[string]time.Duration
+}
+
+func newTimeouts(cfg config.DatabaseConfig) timeouts {
+	t := timeouts{
+		query: time.Duration(cfg.QueryTimeout) * time.Second,
+		exec:  time.Duration(cfg.ExecTimeout) * time.Second,
+		named: make(map[string]time.Duration, len(cfg.NamedTimeouts)),
+	}
+	for name, timeout := range cfg.NamedTimeouts {
+		t.named[name] = time.Duration(timeout) * time.Second
+	}
+	return t
+}
+
+// forOp returns the timeout of a db.exec, db.query or db.query_row call,
+// overridden by the statement's own for named ones
+func (t timeouts) forOp(op, name string) time.Duration {
+	if timeout, ok := t.named[name]; ok {
+		return timeout
+	}
+	if op == "db.exec" {
+		return t.exec
+	}
+	return t.query
+}
+
+// bound applies the timeout of op to ctx. The returned cancel must be called
+// once the call's result has been consumed.
+func (t timeouts) bound(ctx context.Context, op, name string) (context.Context, context.CancelFunc) {
+	timeout := t.forOp(op, name)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// boundRows is bound for queries whose rows are read after the call returns,
+// where cancelling on return would abort the read. The context is released
+// when its timeout expires instead.
+func (t timeouts) boundRows(ctx context.Context, op, name string) context.Context {
+	ctx, cancel := t.bound(ctx, op, name)
+	if timeout := t.forOp(op, name); timeout > 0 {
+		time.AfterFunc(timeout, cancel)
+	}
+	return ctx
+}
+
+// recordInterrupted counts a call that failed because ctx, bounded from
+// parent, expired or was cancelled
+func recordInterrupted(parent, ctx context.Context, op string, err error) {
+	if err == nil || ctx.Err() == nil {
+		return
+	}
+	op = strings.TrimPrefix(op, "db.")
+	switch {
+	case errors.Is(parent.Err(), context.Canceled):
+		telemetry.RecordDBInterrupted(op, "cancelled")
+	case parent.Err() != nil:
+		telemetry.RecordDBInterrupted(op, "deadline")
+	default:
+		telemetry.RecordDBInterrupted(op, "statement_timeout")
+	}
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutsForOp(t *testing.T) {
+	timeouts := newTimeouts(config.DatabaseConfig{
+		QueryTimeout:  30,
+		ExecTimeout:   10,
+		NamedTimeouts: map[string]int{"plan_analytics": 120},
+	})
+
+	assert.Equal(t, 30*time.Second, timeouts.forOp("db.query", ""))
+	assert.Equal(t, 30*time.Second, timeouts.forOp("db.query_row", "get_plan"))
+	assert.Equal(t, 10*time.Second, timeouts.forOp("db.exec", ""))
+	assert.Equal(t, 120*time.Second, timeouts.forOp("db.query", "plan_analytics"))
+}
+
+func TestTimeoutsBoundKeepsEarlierDeadline(t *testing.T) {
+	timeouts := newTimeouts(config.DatabaseConfig{QueryTimeout: 30})
+
+	parent, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	parentDeadline, _ := parent.Deadline()
+
+	bounded, release := timeouts.bound(parent, "db.query", "")
+	defer release()
+	deadline, ok := bounded.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, parentDeadline, deadline)
+
+	unbounded, release := timeouts.bound(context.Background(), "db.exec", "")
+	defer release()
+	_, ok = unbounded.Deadline()
+	assert.False(t, ok)
+}
+
+func TestFormatArgsTruncates(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	assert.Equal(t, "[plan-1, 42, "+strings.Repeat("x", 64)+"...]", formatArgs([]interface{}{"plan-1", 42, long}))
+
+	many := make([]interface{}, 12)
+	for i := range many {
+		many[i] = i
+	}
+	assert.Equal(t, "[0, 1, 2, 3, 4, 5, 6, 7, 8, 9, ...(2 more)]", formatArgs(many))
+}
+
+func TestQueryName(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, "", QueryName(ctx))
+	assert.Equal(t, "plan_usage_statistics", QueryName(WithQueryName(ctx, "plan_usage_statistics")))
+}
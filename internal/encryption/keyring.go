@@ -0,0 +1,270 @@
+// Package encryption encrypts sensitive column values at rest with
+// AES-256-GCM. Values are stored as envelopes naming the key that sealed
+// them, so keys can be rotated: new values use the active key, older keys
+// stay in the keyring to decrypt, and the rotation job re-encrypts what is
+// left under them.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/secrets"
+
+	"github.com/sirupsen/logrus"
+)
+
+// prefix marks an encrypted value: enc:v1:<key id>:<base64 nonce+ciphertext>
+const prefix = "enc:v1:"
+
+var (
+	ErrUnknownKey = errors.New("encryption key not in keyring")
+	ErrMalformed  = errors.New("malformed encrypted value")
+)
+
+// Column is an encrypted column. Index, if set, is the column holding the
+// blind index of its plaintext.
+type Column struct {
+	Table string
+	Name  string
+	Index string
+}
+
+// String names the column as table.column; it is bound to the ciphertext
+// so a value can't be copied into another column.
+func (c Column) String() string {
+	return c.Table + "." + c.Name
+}
+
+// Encrypted columns
+var (
+	UserEmail        = Column{Table: "users", Name: "email", Index: "email_hash"}
+	UserTOTPSecret   = Column{Table: "users", Name: "totp_secret"}
+	WebhookPayload   = Column{Table: "webhook_events", Name: "payload_ciphertext"}
+	SigningKeySecret = Column{Table: "signing_keys", Name: "secret"}
+)
+
+// Keys is a keyring as held in the secret store, in the same shape as the
+// encryption config: {"active_key": "...", "keys": {"id": "base64"},
+// "index_key": "base64"}.
+type Keys struct {
+	ActiveKey string            `json:"active_key"`
+	Keys      map[string]string `json:"keys"`
+	IndexKey  string            `json:"index_key"`
+}
+
+// Keyring encrypts and decrypts column values. A nil Keyring, which
+// NewKeyring returns when encryption is off, stores values as they are.
+type Keyring struct {
+	encryptEmail bool
+
+	mu       sync.RWMutex
+	active   string
+	aeads    map[string]cipher.AEAD
+	indexKey []byte
+}
+
+// NewKeyring builds the keyring from cfg. With secrets.refs.encryption_keyring
+// set the keys are loaded by WatchSecrets instead, and cfg's may be empty.
+func NewKeyring(cfg config.EncryptionConfig) (*Keyring, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	k := &Keyring{encryptEmail: cfg.EncryptEmail}
+	if cfg.ActiveKey == "" && len(cfg.Keys) == 0 {
+		return k, nil
+	}
+	if err := k.setKeys(Keys{ActiveKey: cfg.ActiveKey, Keys: cfg.Keys, IndexKey: cfg.IndexKey}); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Enabled reports whether values are encrypted.
+func (k *Keyring) Enabled() bool {
+	return k != nil
+}
+
+// EncryptsEmail reports whether user emails are encrypted, and looked up by
+// their blind index.
+func (k *Keyring) EncryptsEmail() bool {
+	return k.Enabled() && k.encryptEmail
+}
+
+// ActiveKey returns the ID of the key new values are encrypted with.
+func (k *Keyring) ActiveKey() string {
+	if !k.Enabled() {
+		return ""
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// WatchSecrets loads the keyring from m if it is sourced from the secret
+// store, and keeps it in step with rotations. A rotated keyring that fails
+// to parse is logged and the current keys kept.
+func (k *Keyring) WatchSecrets(m *secrets.Manager) error {
+	if !k.Enabled() {
+		return nil
+	}
+	if value, ok := m.Get(secrets.EncryptionKeyring); ok {
+		if err := k.LoadSecret(value); err != nil {
+			return err
+		}
+	}
+	m.OnRotate(func(key, value string) {
+		if key != secrets.EncryptionKeyring {
+			return
+		}
+		if err := k.LoadSecret(value); err != nil {
+			logrus.Errorf("Failed to load rotated encryption keyring: %v", err)
+		}
+	})
+	return nil
+}
+
+// LoadSecret replaces the keys with those in a JSON-encoded Keys.
+func (k *Keyring) LoadSecret(value string) error {
+	var keys Keys
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return fmt.Errorf("invalid encryption keyring: %w", err)
+	}
+	return k.setKeys(keys)
+}
+
+func (k *Keyring) setKeys(keys Keys) error {
+	aeads := make(map[string]cipher.AEAD, len(keys.Keys))
+	for id, encoded := range keys.Keys {
+		if strings.Contains(id, ":") {
+			return fmt.Errorf("encryption key ID %q must not contain ':'", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("encryption key %s must be a base64-encoded 32-byte key", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		aeads[id] = aead
+	}
+	if _, ok := aeads[keys.ActiveKey]; !ok {
+		return fmt.Errorf("active encryption key %q: %w", keys.ActiveKey, ErrUnknownKey)
+	}
+
+	var indexKey []byte
+	if keys.IndexKey != "" {
+		var err error
+		if indexKey, err = base64.StdEncoding.DecodeString(keys.IndexKey); err != nil {
+			return fmt.Errorf("invalid encryption index key: %w", err)
+		}
+	}
+	if k.encryptEmail && len(indexKey) < 32 {
+		return errors.New("encryption index key must be at least 32 bytes when emails are encrypted")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.active = keys.ActiveKey
+	k.aeads = aeads
+	k.indexKey = indexKey
+	return nil
+}
+
+// Encrypt seals value for column with the active key.
+func (k *Keyring) Encrypt(value string, column Column) (string, error) {
+	if !k.Enabled() {
+		return value, nil
+	}
+	k.mu.RLock()
+	id, aead := k.active, k.aeads[k.active]
+	k.mu.RUnlock()
+	if aead == nil {
+		return "", ErrUnknownKey
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(column.String()))
+	return prefix + id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value sealed by Encrypt for the same column. Values that
+// aren't encrypted, such as rows written before encryption was turned on,
+// are returned as they are.
+func (k *Keyring) Decrypt(value string, column Column) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if !k.Enabled() {
+		return "", fmt.Errorf("%s is encrypted but encryption is disabled", column)
+	}
+
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !ok {
+		return "", ErrMalformed
+	}
+	k.mu.RLock()
+	aead := k.aeads[id]
+	k.mu.RUnlock()
+	if aead == nil {
+		return "", fmt.Errorf("%s key %q: %w", column, id, ErrUnknownKey)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrMalformed
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(column.String()))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", column, err)
+	}
+	return string(plain), nil
+}
+
+// Columns returns the columns encrypted under this keyring's settings.
+func (k *Keyring) Columns() []Column {
+	if !k.Enabled() {
+		return nil
+	}
+	if k.encryptEmail {
+		return []Column{UserEmail, UserTOTPSecret, WebhookPayload, SigningKeySecret}
+	}
+	return []Column{UserTOTPSecret, WebhookPayload, SigningKeySecret}
+}
+
+// BlindIndex returns a keyed hash of value for equality lookups on an
+// encrypted column. It doesn't change when the encryption keys rotate.
+func (k *Keyring) BlindIndex(value string) string {
+	if !k.Enabled() {
+		return ""
+	}
+	k.mu.RLock()
+	mac := hmac.New(sha256.New, k.indexKey)
+	k.mu.RUnlock()
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsEncrypted reports whether value is an envelope written by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
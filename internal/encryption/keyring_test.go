@@ -0,0 +1,111 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func newTestKeyring(t *testing.T, active string) *Keyring {
+	k, err := NewKeyring(config.EncryptionConfig{
+		Enabled:      true,
+		ActiveKey:    active,
+		Keys:         map[string]string{"k1": testKey(1), "k2": testKey(2)},
+		IndexKey:     testKey(9),
+		EncryptEmail: true,
+	})
+	require.NoError(t, err)
+	return k
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	k := newTestKeyring(t, "k1")
+
+	sealed, err := k.Encrypt("alice@example.com", UserEmail)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, "enc:v1:k1:"))
+	assert.NotContains(t, sealed, "alice")
+
+	again, err := k.Encrypt("alice@example.com", UserEmail)
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+
+	plain, err := k.Decrypt(sealed, UserEmail)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", plain)
+}
+
+func TestDecryptIsBoundToColumn(t *testing.T) {
+	k := newTestKeyring(t, "k1")
+
+	sealed, err := k.Encrypt("secret", WebhookPayload)
+	require.NoError(t, err)
+	_, err = k.Decrypt(sealed, UserEmail)
+	assert.Error(t, err)
+}
+
+func TestDecryptPassesPlaintextThrough(t *testing.T) {
+	k := newTestKeyring(t, "k1")
+	plain, err := k.Decrypt("bob@example.com", UserEmail)
+	require.NoError(t, err)
+	assert.Equal(t, "bob@example.com", plain)
+
+	var disabled *Keyring
+	plain, err = disabled.Decrypt("bob@example.com", UserEmail)
+	require.NoError(t, err)
+	assert.Equal(t, "bob@example.com", plain)
+}
+
+func TestRotatedKeysStillDecrypt(t *testing.T) {
+	k := newTestKeyring(t, "k1")
+	sealed, err := k.Encrypt("carol@example.com", UserEmail)
+	require.NoError(t, err)
+	index := k.BlindIndex("carol@example.com")
+
+	require.NoError(t, k.LoadSecret(`{"active_key": "k2", "keys": {"k1": "`+testKey(1)+`", "k2": "`+testKey(2)+`"}, "index_key": "`+testKey(9)+`"}`))
+	assert.Equal(t, "k2", k.ActiveKey())
+
+	plain, err := k.Decrypt(sealed, UserEmail)
+	require.NoError(t, err)
+	assert.Equal(t, "carol@example.com", plain)
+	assert.Equal(t, index, k.BlindIndex("carol@example.com"))
+
+	resealed, err := k.Encrypt(plain, UserEmail)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resealed, "enc:v1:k2:"))
+
+	require.NoError(t, k.LoadSecret(`{"active_key": "k2", "keys": {"k2": "`+testKey(2)+`"}, "index_key": "`+testKey(9)+`"}`))
+	_, err = k.Decrypt(sealed, UserEmail)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestLoadSecretRejectsBadKeyrings(t *testing.T) {
+	k := newTestKeyring(t, "k1")
+
+	assert.Error(t, k.LoadSecret(`{"active_key": "k3", "keys": {"k1": "`+testKey(1)+`"}, "index_key": "`+testKey(9)+`"}`))
+	assert.Error(t, k.LoadSecret(`{"active_key": "k1", "keys": {"k1": "c2hvcnQ="}, "index_key": "`+testKey(9)+`"}`))
+	assert.Error(t, k.LoadSecret(`{"active_key": "k1", "keys": {"k1": "`+testKey(1)+`"}}`))
+	assert.Equal(t, "k1", k.ActiveKey())
+}
+
+func TestDisabledKeyringStoresPlaintext(t *testing.T) {
+	k, err := NewKeyring(config.EncryptionConfig{})
+	require.NoError(t, err)
+	assert.False(t, k.Enabled())
+	assert.False(t, k.EncryptsEmail())
+
+	value, err := k.Encrypt("dave@example.com", UserEmail)
+	require.NoError(t, err)
+	assert.Equal(t, "dave@example.com", value)
+	assert.Empty(t, k.Columns())
+}
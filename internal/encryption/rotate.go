@@ -0,0 +1,113 @@
+package encryption
+
+import (
+	"context"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/jobs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JobRotate re-encrypts values sealed with retired keys, and encrypts
+// plaintext left from before encryption was turned on.
+const JobRotate = "encryption.rotate"
+
+// rotateBatchSize is how many rows a rotation pass reads at a time
+const rotateBatchSize = 500
+
+// RegisterJobs schedules the rotation job for the keyring's columns. Once a
+// run finds nothing left under a retired key, that key can be dropped.
+func (k *Keyring) RegisterJobs(runner *jobs.Runner, conn *db.Connection, cfg config.EncryptionConfig) {
+	if !k.Enabled() {
+		return
+	}
+	runner.Every(JobRotate, time.Duration(cfg.RotateInterval)*time.Second, func(ctx context.Context, job *jobs.Job) error {
+		for _, column := range k.Columns() {
+			rotated, err := k.rotate(ctx, conn, column)
+			if err != nil {
+				return err
+			}
+			if rotated > 0 {
+				logrus.Infof("Re-encrypted %d values in %s with key %s", rotated, column, k.ActiveKey())
+			}
+		}
+		return nil
+	})
+}
+
+// rotate walks column in id order, re-encrypting each stale value. Rows
+// are updated only if unchanged since they were read, so a concurrent
+// write always wins, and a value that can't be decrypted is logged and
+// skipped rather than stopping the run.
+func (k *Keyring) rotate(ctx context.Context, conn *db.Connection, column Column) (int, error) {
+	set := column.Name + " = $1"
+	if column.Index != "" {
+		set += ", " + column.Index + " = $4"
+	}
+	selectQuery := `
+		SELECT id::text, ` + column.Name + ` FROM ` + column.Table + `
+		WHERE ` + column.Name + ` IS NOT NULL AND ` + column.Name + `::text NOT LIKE $1
+			AND id::text > $2
+		ORDER BY id::text
+		LIMIT $3
+	`
+	updateQuery := `
+		UPDATE ` + column.Table + ` SET ` + set + `
+		WHERE id::text = $2 AND ` + column.Name + ` = $3
+	`
+
+	rotated := 0
+	after := ""
+	for ctx.Err() == nil {
+		current := prefix + k.ActiveKey() + ":%"
+		rows, err := conn.QueryContext(ctx, selectQuery, current, after, rotateBatchSize)
+		if err != nil {
+			return rotated, err
+		}
+		type staleValue struct{ id, value string }
+		var batch []staleValue
+		for rows.Next() {
+			var v staleValue
+			if err := rows.Scan(&v.id, &v.value); err != nil {
+				rows.Close()
+				return rotated, err
+			}
+			batch = append(batch, v)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return rotated, err
+		}
+		if len(batch) == 0 {
+			return rotated, nil
+		}
+
+		for _, v := range batch {
+			after = v.id
+			plain, err := k.Decrypt(v.value, column)
+			if err != nil {
+				logrus.Errorf("Failed to rotate %s of %s: %v", column, v.id, err)
+				continue
+			}
+			sealed, err := k.Encrypt(plain, column)
+			if err != nil {
+				return rotated, err
+			}
+			args := []interface{}{sealed, v.id, v.value}
+			if column.Index != "" {
+				args = append(args, k.BlindIndex(plain))
+			}
+			result, err := conn.ExecContext(ctx, updateQuery, args...)
+			if err != nil {
+				return rotated, err
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				rotated++
+			}
+		}
+	}
+	return rotated, ctx.Err()
+}
@@ -0,0 +1,82 @@
+// Package errorreport forwards panics, with their stack traces, to an error
+// tracker.
+package errorreport
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+)
+
+// Event is a panic to report. Frames are innermost first.
+type Event struct {
+	Message   string
+	Frames    []Frame
+	RequestID string
+	Method    string
+	Route     string
+	Path      string
+	Time      time.Time
+}
+
+// Frame is a function on the stack and where it was
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// Reporter sends an Event to an error tracker.
+type Reporter interface {
+	Report(ctx context.Context, event *Event) error
+}
+
+// NewReporter builds the Reporter selected by cfg.Provider, tagging reports
+// with environment and release. It returns a nil Reporter when no error
+// tracker is configured.
+func NewReporter(cfg config.ErrorReportingConfig, environment, release string) (Reporter, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "":
+		return nil, nil
+	case "sentry":
+		return newSentryReporter(cfg, environment, release)
+	case "rollbar":
+		return newRollbarReporter(cfg, environment, release), nil
+	default:
+		return nil, fmt.Errorf("unknown error reporting provider %q", cfg.Provider)
+	}
+}
+
+// Callers returns the stack from its caller, leaving out skip more frames,
+// innermost first. Runtime frames, such as the panic machinery, are left
+// out, so called in a deferred recover with skip 1 the stack starts where
+// the panic was raised.
+func Callers(skip int) []Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []Frame
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			stack = append(stack, Frame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		}
+		if !more {
+			return stack
+		}
+	}
+}
+
+// oldestFirst returns frames outermost first, as trackers order them
+func oldestFirst(frames []Frame) []Frame {
+	reversed := make([]Frame, len(frames))
+	for i, frame := range frames {
+		reversed[len(frames)-1-i] = frame
+	}
+	return reversed
+}
@@ -0,0 +1,109 @@
+package errorreport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var event = &Event{
+	Message:   "assignment to entry in nil map",
+	RequestID: "req-1",
+	Method:    http.MethodPost,
+	Route:     "/api/v1/plans",
+	Path:      "/api/v1/plans",
+	Time:      time.Unix(1700000000, 0),
+	Frames: []Frame{
+		{Function: "scalable-paywall/internal/plan.(*Service).CreatePlan", File: "/src/internal/plan/service.go", Line: 120},
+		{Function: "github.com/gin-gonic/gin.(*Context).Next", File: "/go/gin/context.go", Line: 174},
+	},
+}
+
+func TestNewReporter(t *testing.T) {
+	reporter, err := NewReporter(config.ErrorReportingConfig{}, "production", "1.0.0")
+	require.NoError(t, err)
+	assert.Nil(t, reporter)
+
+	_, err = NewReporter(config.ErrorReportingConfig{Provider: "bugsnag"}, "production", "1.0.0")
+	assert.Error(t, err)
+	_, err = NewReporter(config.ErrorReportingConfig{Provider: "sentry", DSN: "https://sentry.io/42"}, "production", "1.0.0")
+	assert.Error(t, err)
+}
+
+func TestParseDSN(t *testing.T) {
+	store, key, err := parseDSN("https://abc123@o1.ingest.sentry.io/42")
+	require.NoError(t, err)
+	assert.Equal(t, "https://o1.ingest.sentry.io/api/42/store/", store)
+	assert.Equal(t, "abc123", key)
+
+	store, _, err = parseDSN("http://abc123@sentry.internal:9000/errors/7")
+	require.NoError(t, err)
+	assert.Equal(t, "http://sentry.internal:9000/errors/api/7/store/", store)
+
+	for _, dsn := range []string{"", "https://o1.ingest.sentry.io/42", "https://abc123@o1.ingest.sentry.io/", "not a dsn"} {
+		_, _, err := parseDSN(dsn)
+		assert.Error(t, err, dsn)
+	}
+}
+
+func TestSentryReport(t *testing.T) {
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/42/store/", r.URL.Path)
+		assert.Contains(t, r.Header.Get("X-Sentry-Auth"), "sentry_key=abc123")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		w.Write([]byte(`{"id":"x"}`))
+	}))
+	defer server.Close()
+
+	dsn := strings.Replace(server.URL, "http://", "http://abc123@", 1) + "/42"
+	reporter, err := NewReporter(config.ErrorReportingConfig{Provider: "sentry", DSN: dsn, Timeout: 5}, "production", "1.4.0")
+	require.NoError(t, err)
+	require.NoError(t, reporter.Report(context.Background(), event))
+
+	assert.Equal(t, "production", payload["environment"])
+	assert.Equal(t, "1.4.0", payload["release"])
+	assert.Equal(t, "POST /api/v1/plans", payload["transaction"])
+	assert.Equal(t, map[string]interface{}{"request_id": "req-1"}, payload["tags"])
+	exception := payload["exception"].(map[string]interface{})["values"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "assignment to entry in nil map", exception["value"])
+	frames := exception["stacktrace"].(map[string]interface{})["frames"].([]interface{})
+	require.Len(t, frames, 2)
+	// Sentry wants the innermost frame last
+	assert.Equal(t, "scalable-paywall/internal/plan.(*Service).CreatePlan", frames[1].(map[string]interface{})["function"])
+}
+
+func TestRollbarReport(t *testing.T) {
+	var item rollbarItem
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/1/item/", r.URL.Path)
+		assert.Equal(t, "post-server-token", r.Header.Get("X-Rollbar-Access-Token"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&item))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	reporter := newRollbarReporter(config.ErrorReportingConfig{Provider: "rollbar", AccessToken: "post-server-token", Timeout: 5}, "staging", "1.4.0")
+	reporter.baseURL = server.URL
+	require.NoError(t, reporter.Report(context.Background(), event))
+
+	assert.Equal(t, "staging", item.Data.Environment)
+	assert.Equal(t, "1.4.0", item.Data.CodeVersion)
+	assert.Equal(t, "assignment to entry in nil map", item.Data.Body.Trace.Exception.Message)
+	assert.Equal(t, "req-1", item.Data.Custom["request_id"])
+	require.Len(t, item.Data.Body.Trace.Frames, 2)
+	assert.Equal(t, 120, item.Data.Body.Trace.Frames[1].Lineno)
+
+	status = http.StatusUnauthorized
+	assert.Error(t, reporter.Report(context.Background(), event))
+}
@@ -0,0 +1,108 @@
+package errorreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+)
+
+// rollbarReporter sends items to Rollbar's API with a post_server_item token
+type rollbarReporter struct {
+	baseURL     string
+	token       string
+	environment string
+	release     string
+	client      *http.Client
+}
+
+func newRollbarReporter(cfg config.ErrorReportingConfig, environment, release string) *rollbarReporter {
+	return &rollbarReporter{
+		baseURL:     "https://api.rollbar.com",
+		token:       cfg.AccessToken,
+		environment: environment,
+		release:     release,
+		client:      &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}
+}
+
+type rollbarFrame struct {
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+	Method   string `json:"method"`
+}
+
+type rollbarItem struct {
+	Data struct {
+		Environment string `json:"environment"`
+		Level       string `json:"level"`
+		Timestamp   int64  `json:"timestamp"`
+		CodeVersion string `json:"code_version,omitempty"`
+		Platform    string `json:"platform"`
+		Language    string `json:"language"`
+		Context     string `json:"context,omitempty"`
+		Body        struct {
+			Trace struct {
+				Frames    []rollbarFrame `json:"frames"`
+				Exception struct {
+					Class   string `json:"class"`
+					Message string `json:"message"`
+				} `json:"exception"`
+			} `json:"trace"`
+		} `json:"body"`
+		Request struct {
+			Method string `json:"method"`
+			URL    string `json:"url"`
+		} `json:"request"`
+		Custom map[string]string `json:"custom"`
+	} `json:"data"`
+}
+
+func (r *rollbarReporter) Report(ctx context.Context, event *Event) error {
+	var item rollbarItem
+	data := &item.Data
+	data.Environment = r.environment
+	data.Level = "critical"
+	data.Timestamp = event.Time.Unix()
+	data.CodeVersion = r.release
+	data.Platform = "go"
+	data.Language = "go"
+	data.Context = event.Method + " " + event.Route
+	data.Body.Trace.Exception.Class = "panic"
+	data.Body.Trace.Exception.Message = event.Message
+	for _, frame := range oldestFirst(event.Frames) {
+		data.Body.Trace.Frames = append(data.Body.Trace.Frames,
+			rollbarFrame{Filename: frame.File, Lineno: frame.Line, Method: frame.Function})
+	}
+	data.Request.Method = event.Method
+	data.Request.URL = event.Path
+	data.Custom = map[string]string{"request_id": event.RequestID}
+
+	body, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/api/1/item/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Rollbar-Access-Token", r.token)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Rollbar returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
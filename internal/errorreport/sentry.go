@@ -0,0 +1,135 @@
+package errorreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/google/uuid"
+)
+
+// sentryReporter sends events to Sentry's store endpoint, addressed and
+// authenticated by the project's DSN
+type sentryReporter struct {
+	storeURL    string
+	publicKey   string
+	environment string
+	release     string
+	client      *http.Client
+}
+
+func newSentryReporter(cfg config.ErrorReportingConfig, environment, release string) (*sentryReporter, error) {
+	storeURL, publicKey, err := parseDSN(cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	return &sentryReporter{
+		storeURL:    storeURL,
+		publicKey:   publicKey,
+		environment: environment,
+		release:     release,
+		client:      &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}, nil
+}
+
+// parseDSN splits a DSN, https://<key>@<host>/<project>, into the project's
+// store URL and the public key
+func parseDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("invalid Sentry DSN")
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	prefix, project := "", path
+	if i >= 0 {
+		prefix, project = "/"+path[:i], path[i+1:]
+	}
+	if project == "" {
+		return "", "", fmt.Errorf("invalid Sentry DSN: no project ID")
+	}
+	return fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project), u.User.Username(), nil
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Transaction string            `json:"transaction,omitempty"`
+	Tags        map[string]string `json:"tags"`
+	Request     struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+	} `json:"request"`
+	Exception struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+}
+
+type sentryException struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Stacktrace struct {
+		Frames []sentryFrame `json:"frames"`
+	} `json:"stacktrace"`
+}
+
+func (s *sentryReporter) Report(ctx context.Context, event *Event) error {
+	payload := sentryEvent{
+		EventID:     strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Timestamp:   event.Time.UTC().Format(time.RFC3339),
+		Level:       "fatal",
+		Platform:    "go",
+		Environment: s.environment,
+		Release:     s.release,
+		Transaction: event.Method + " " + event.Route,
+		Tags:        map[string]string{"request_id": event.RequestID},
+	}
+	payload.Request.Method = event.Method
+	payload.Request.URL = event.Path
+	exception := sentryException{Type: "panic", Value: event.Message}
+	for _, frame := range oldestFirst(event.Frames) {
+		exception.Stacktrace.Frames = append(exception.Stacktrace.Frames,
+			sentryFrame{Function: frame.Function, Filename: frame.File, Lineno: frame.Line})
+	}
+	payload.Exception.Values = []sentryException{exception}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=scalable-paywall/1.0, sentry_key=%s", s.publicKey))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Sentry returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package experiment
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	StatusDraft   = "draft"
+	StatusRunning = "running"
+	StatusStopped = "stopped"
+)
+
+// Experiment event types
+const (
+	EventExposure   = "exposure"
+	EventConversion = "conversion"
+)
+
+// Experiment splits users into variants that see different plan prices or
+// orderings on the pricing page. Only running experiments assign users, and
+// one with a Segment only assigns the segment's members.
+type Experiment struct {
+	ID          string     `json:"id" db:"id"`
+	Key         string     `json:"key" db:"key"`
+	Name        string     `json:"name" db:"name"`
+	Description *string    `json:"description,omitempty" db:"description"`
+	Status      string     `json:"status" db:"status"`
+	Segment     *string    `json:"segment,omitempty" db:"segment"`
+	Variants    []Variant  `json:"variants" db:"variants"`
+	StartedAt   *time.Time `json:"started_at,omitempty" db:"started_at"`
+	StoppedAt   *time.Time `json:"stopped_at,omitempty" db:"stopped_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Variant is one arm of an experiment. Weight is its percentage of users.
+// Prices overrides plan prices by plan ID, in the plan's own currency;
+// PlanOrder lists plan IDs to show first, in that order. A variant with
+// neither is a control.
+type Variant struct {
+	Key       string                     `json:"key" binding:"required,max=50"`
+	Weight    int                        `json:"weight" binding:"min=1,max=100"`
+	Prices    map[string]decimal.Decimal `json:"prices,omitempty"`
+	PlanOrder []string                   `json:"plan_order,omitempty"`
+}
+
+// Assignment is the variant of a running experiment a user is bucketed into
+type Assignment struct {
+	ExperimentID string  `json:"experiment_id"`
+	Experiment   string  `json:"experiment"`
+	Variant      Variant `json:"variant"`
+}
+
+// validateVariants checks that variant keys are unique, weights add up to
+// 100 and price overrides are not negative.
+func validateVariants(variants []Variant) error {
+	if len(variants) < 2 {
+		return fmt.Errorf("an experiment needs at least 2 variants")
+	}
+	total := 0
+	seen := make(map[string]bool, len(variants))
+	for _, variant := range variants {
+		if seen[variant.Key] {
+			return fmt.Errorf("variant %q is listed twice", variant.Key)
+		}
+		seen[variant.Key] = true
+		total += variant.Weight
+		for planID, price := range variant.Prices {
+			if price.IsNegative() {
+				return fmt.Errorf("variant %q price for plan %s must not be negative", variant.Key, planID)
+			}
+		}
+	}
+	if total != 100 {
+		return fmt.Errorf("variant weights must add up to 100, got %d", total)
+	}
+	return nil
+}
+
+// Assign picks subject's variant. The same subject always lands in the same
+// variant of an experiment, and buckets are salted by experiment key so
+// concurrent experiments split users independently.
+func (e Experiment) Assign(subject string) Variant {
+	b := bucket(e.Key, subject)
+	for _, variant := range e.Variants {
+		if b < variant.Weight {
+			return variant
+		}
+		b -= variant.Weight
+	}
+	// Unreachable for validated weights
+	return e.Variants[len(e.Variants)-1]
+}
+
+// bucket maps subject to 0-99
+func bucket(key, subject string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	h.Write([]byte{':'})
+	h.Write([]byte(subject))
+	return int(h.Sum32() % 100)
+}
@@ -0,0 +1,59 @@
+package experiment
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssignIsStableAndFollowsWeights(t *testing.T) {
+	experiment := Experiment{
+		Key: "pro_price",
+		Variants: []Variant{
+			{Key: "control", Weight: 80},
+			{Key: "higher", Weight: 20},
+		},
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		subject := fmt.Sprintf("user_%d", i)
+		variant := experiment.Assign(subject)
+		counts[variant.Key]++
+		assert.Equal(t, variant.Key, experiment.Assign(subject).Key)
+	}
+
+	assert.InDelta(t, 8000, counts["control"], 300)
+	assert.InDelta(t, 2000, counts["higher"], 300)
+}
+
+func TestValidateVariants(t *testing.T) {
+	assert.NoError(t, validateVariants([]Variant{{Key: "a", Weight: 50}, {Key: "b", Weight: 50}}))
+	assert.Error(t, validateVariants([]Variant{{Key: "a", Weight: 100}}))
+	assert.Error(t, validateVariants([]Variant{{Key: "a", Weight: 50}, {Key: "a", Weight: 50}}))
+	assert.Error(t, validateVariants([]Variant{{Key: "a", Weight: 50}, {Key: "b", Weight: 40}}))
+	assert.Error(t, validateVariants([]Variant{
+		{Key: "a", Weight: 50},
+		{Key: "b", Weight: 50, Prices: map[string]decimal.Decimal{"plan_pro": decimal.NewFromInt(-1)}},
+	}))
+}
+
+func TestBuildResults(t *testing.T) {
+	variants := []Variant{{Key: "control", Weight: 50}, {Key: "higher", Weight: 50}}
+	totals := []eventTotals{
+		{Variant: "control", EventType: EventExposure, Count: 100},
+		{Variant: "control", EventType: EventConversion, Currency: "USD", Count: 10, Amount: decimal.RequireFromString("99.90")},
+		{Variant: "higher", EventType: EventExposure, Count: 100},
+		{Variant: "higher", EventType: EventConversion, Currency: "USD", Count: 5, Amount: decimal.RequireFromString("74.95")},
+	}
+
+	results := buildResults(variants, totals)
+
+	assert.Len(t, results, 2)
+	assert.Equal(t, 0.1, results[0].ConversionRate)
+	assert.Nil(t, results[0].Lift)
+	assert.InDelta(t, -0.5, *results[1].Lift, 0.0001)
+	assert.True(t, results[1].Revenue["USD"].Equal(decimal.RequireFromString("74.95")))
+}
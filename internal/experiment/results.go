@@ -0,0 +1,138 @@
+package experiment
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// Results compares the variants of an experiment. Lift is each variant's
+// conversion rate relative to the first variant, the control.
+type Results struct {
+	Experiment Experiment      `json:"experiment"`
+	Variants   []VariantResult `json:"variants"`
+}
+
+type VariantResult struct {
+	Variant        string                     `json:"variant"`
+	Exposures      int                        `json:"exposures"`
+	Conversions    int                        `json:"conversions"`
+	ConversionRate float64                    `json:"conversion_rate"`
+	Lift           *float64                   `json:"lift,omitempty"`
+	Revenue        map[string]decimal.Decimal `json:"revenue"`
+}
+
+// eventTotals is the event count and amount of one variant, event type and
+// currency
+type eventTotals struct {
+	Variant   string
+	EventType string
+	Currency  string
+	Count     int
+	Amount    decimal.Decimal
+}
+
+// GetExperimentResults reports exposures, conversions, conversion rate and
+// revenue by currency for each variant.
+func (s *Service) GetExperimentResults(c *gin.Context) {
+	ctx := c.Request.Context()
+	experiment, err := s.getExperiment(ctx, c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Experiment not found"})
+		telemetry.RecordExperimentOperation("results", "not_found")
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to get experiment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordExperimentOperation("results", "db_error")
+		return
+	}
+
+	totals, err := s.eventTotals(ctx, experiment.ID)
+	if err != nil {
+		logrus.Errorf("Failed to get results of experiment %s: %v", experiment.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordExperimentOperation("results", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, Results{
+		Experiment: *experiment,
+		Variants:   buildResults(experiment.Variants, totals),
+	})
+	telemetry.RecordExperimentOperation("results", "success")
+}
+
+// buildResults folds event totals into one result per variant, in the
+// experiment's variant order.
+func buildResults(variants []Variant, totals []eventTotals) []VariantResult {
+	results := make([]VariantResult, len(variants))
+	index := make(map[string]int, len(variants))
+	for i, variant := range variants {
+		results[i] = VariantResult{Variant: variant.Key, Revenue: map[string]decimal.Decimal{}}
+		index[variant.Key] = i
+	}
+
+	for _, t := range totals {
+		i, ok := index[t.Variant]
+		if !ok {
+			continue
+		}
+		switch t.EventType {
+		case EventExposure:
+			results[i].Exposures += t.Count
+		case EventConversion:
+			results[i].Conversions += t.Count
+			if t.Currency != "" {
+				results[i].Revenue[t.Currency] = results[i].Revenue[t.Currency].Add(t.Amount)
+			}
+		}
+	}
+
+	for i := range results {
+		if results[i].Exposures > 0 {
+			results[i].ConversionRate = float64(results[i].Conversions) / float64(results[i].Exposures)
+		}
+	}
+	if len(results) > 0 && results[0].ConversionRate > 0 {
+		control := results[0].ConversionRate
+		for i := range results[1:] {
+			lift := results[i+1].ConversionRate/control - 1
+			results[i+1].Lift = &lift
+		}
+	}
+	return results
+}
+
+func (s *Service) eventTotals(ctx context.Context, experimentID string) ([]eventTotals, error) {
+	ctx = db.WithQueryName(ctx, "experiment_event_totals")
+
+	rows, err := s.db.Reader().QueryContext(ctx, `
+		SELECT variant, event_type, COALESCE(currency, ''), COUNT(*), COALESCE(SUM(amount), 0)
+		FROM experiment_events
+		WHERE experiment_id = $1
+		GROUP BY variant, event_type, currency
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []eventTotals
+	for rows.Next() {
+		var t eventTotals
+		if err := rows.Scan(&t.Variant, &t.EventType, &t.Currency, &t.Count, &t.Amount); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
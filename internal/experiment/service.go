@@ -0,0 +1,413 @@
+package experiment
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/segment"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// runningCacheKey caches the running experiments, which are read on every
+// personalised pricing page
+const runningCacheKey = "experiments:running"
+
+// segmentConstraint is the foreign key from an experiment to its segment
+const segmentConstraint = "experiments_segment_fkey"
+
+// Service manages price experiments. Experiments are created and started
+// through the admin endpoints, so pricing tests need no deploy.
+type Service struct {
+	db       *db.Connection
+	cache    *cache.RedisClient
+	segments *segment.Service
+}
+
+type CreateExperimentRequest struct {
+	Key         string    `json:"key" binding:"required,max=100"`
+	Name        string    `json:"name" binding:"required,max=255"`
+	Description *string   `json:"description"`
+	Segment     *string   `json:"segment" binding:"omitempty,max=100"`
+	Variants    []Variant `json:"variants" binding:"required,dive"`
+}
+
+type ExperimentListResponse struct {
+	Experiments []Experiment `json:"experiments"`
+	Limit       int          `json:"limit"`
+	NextCursor  string       `json:"next_cursor,omitempty"`
+}
+
+var validStatuses = map[string]bool{
+	"":            true,
+	StatusDraft:   true,
+	StatusRunning: true,
+	StatusStopped: true,
+}
+
+func NewService(db *db.Connection, cache *cache.RedisClient, segments *segment.Service) *Service {
+	return &Service{db: db, cache: cache, segments: segments}
+}
+
+// CreateExperiment stores a draft experiment; it assigns no users until it
+// is started.
+func (s *Service) CreateExperiment(c *gin.Context) {
+	var req CreateExperimentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordExperimentOperation("create", "validation_error")
+		return
+	}
+
+	if err := validateVariants(req.Variants); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordExperimentOperation("create", "validation_error")
+		return
+	}
+
+	experiment, err := s.createExperiment(c.Request.Context(), req)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Experiment with this key already exists"})
+		telemetry.RecordExperimentOperation("create", "conflict")
+		return
+	}
+	if db.IsForeignKeyViolation(err, segmentConstraint) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Segment " + *req.Segment + " does not exist"})
+		telemetry.RecordExperimentOperation("create", "validation_error")
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to create experiment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordExperimentOperation("create", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusCreated, experiment)
+	telemetry.RecordExperimentOperation("create", "success")
+}
+
+func (s *Service) GetExperiment(c *gin.Context) {
+	experiment, err := s.getExperiment(c.Request.Context(), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Experiment not found"})
+		telemetry.RecordExperimentOperation("get", "not_found")
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to get experiment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordExperimentOperation("get", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, experiment)
+	telemetry.RecordExperimentOperation("get", "success")
+}
+
+// ListExperiments returns experiments newest first using cursor pagination.
+// Optional filter: status.
+func (s *Service) ListExperiments(c *gin.Context) {
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+
+	status := c.Query("status")
+	if !validStatuses[status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be draft, running or stopped"})
+		telemetry.RecordExperimentOperation("list", "validation_error")
+		return
+	}
+
+	cursor, err := db.ParseCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		telemetry.RecordExperimentOperation("list", "validation_error")
+		return
+	}
+
+	experiments, nextCursor, err := s.listExperiments(c.Request.Context(), status, cursor, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list experiments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordExperimentOperation("list", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, ExperimentListResponse{
+		Experiments: experiments,
+		Limit:       limit,
+		NextCursor:  nextCursor,
+	})
+	telemetry.RecordExperimentOperation("list", "success")
+}
+
+// StartExperiment starts assigning users to a draft experiment.
+func (s *Service) StartExperiment(c *gin.Context) {
+	s.transition(c, "start", StatusDraft, StatusRunning)
+}
+
+// StopExperiment ends a running experiment. Users see the regular pricing
+// again; recorded events are kept for its results.
+func (s *Service) StopExperiment(c *gin.Context) {
+	s.transition(c, "stop", StatusRunning, StatusStopped)
+}
+
+func (s *Service) transition(c *gin.Context, op, from, to string) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	experiment, err := s.updateStatus(ctx, id, from, to)
+	if err == sql.ErrNoRows {
+		// Tell a missing experiment apart from one in the wrong state
+		current, getErr := s.getExperiment(ctx, id)
+		if getErr == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Experiment not found"})
+			telemetry.RecordExperimentOperation(op, "not_found")
+			return
+		}
+		if getErr == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Experiment is " + current.Status + ", not " + from})
+			telemetry.RecordExperimentOperation(op, "conflict")
+			return
+		}
+		err = getErr
+	}
+	if err != nil {
+		logrus.Errorf("Failed to %s experiment %s: %v", op, id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordExperimentOperation(op, "db_error")
+		return
+	}
+
+	if err := s.cache.Del(ctx, s.cache.Key(runningCacheKey)); err != nil {
+		logrus.Warnf("Failed to invalidate running experiments cache: %v", err)
+	}
+
+	logrus.Infof("Experiment %s is now %s", experiment.Key, to)
+	c.JSON(http.StatusOK, experiment)
+	telemetry.RecordExperimentOperation(op, "success")
+}
+
+// Assign buckets userID into every running experiment they are targeted
+// by, oldest first. Later experiments win where two override the same plan.
+func (s *Service) Assign(ctx context.Context, userID string) ([]Assignment, error) {
+	experiments, err := s.runningExperiments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Segments are only looked up when a running experiment targets one
+	var segments map[string]bool
+	assignments := make([]Assignment, 0, len(experiments))
+	for _, experiment := range experiments {
+		if experiment.Segment != nil {
+			if segments == nil {
+				if segments, err = s.segments.Keys(ctx, userID); err != nil {
+					return nil, err
+				}
+			}
+			if !segments[*experiment.Segment] {
+				continue
+			}
+		}
+		assignments = append(assignments, Assignment{
+			ExperimentID: experiment.ID,
+			Experiment:   experiment.Key,
+			Variant:      experiment.Assign(userID),
+		})
+	}
+	return assignments, nil
+}
+
+// RecordExposure records that userID was shown the assigned variant. Only
+// the first exposure per experiment is kept.
+func (s *Service) RecordExposure(ctx context.Context, userID string, assignment Assignment) error {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO experiment_events (experiment_id, variant, user_id, event_type)
+		VALUES ($1, $2, $3, 'exposure')
+		ON CONFLICT (experiment_id, user_id, event_type) DO NOTHING
+	`, assignment.ExperimentID, assignment.Variant.Key, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		telemetry.RecordExperimentEvent(assignment.Experiment, assignment.Variant.Key, EventExposure)
+	}
+	return nil
+}
+
+// RecordConversion attributes a paid subscription to the variant userID was
+// exposed to in each running experiment. Users never exposed are not
+// counted, and only a user's first conversion per experiment is kept.
+func (s *Service) RecordConversion(ctx context.Context, userID, planID, subscriptionID string, amount decimal.Decimal, currency string) error {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH inserted AS (
+			INSERT INTO experiment_events (experiment_id, variant, user_id, event_type,
+				plan_id, subscription_id, amount, currency)
+			SELECT e.experiment_id, e.variant, e.user_id, 'conversion', $2, $3, $4, $5
+			FROM experiment_events e
+			JOIN experiments x ON x.id = e.experiment_id
+			WHERE e.user_id = $1 AND e.event_type = 'exposure' AND x.status = 'running'
+			ON CONFLICT (experiment_id, user_id, event_type) DO NOTHING
+			RETURNING experiment_id, variant
+		)
+		SELECT x.key, i.variant FROM inserted i JOIN experiments x ON x.id = i.experiment_id
+	`, userID, planID, subscriptionID, amount, currency)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, variant string
+		if err := rows.Scan(&key, &variant); err != nil {
+			return err
+		}
+		telemetry.RecordExperimentEvent(key, variant, EventConversion)
+	}
+	return rows.Err()
+}
+
+// runningExperiments returns the running experiments, from cache when
+// possible. Starting or stopping an experiment drops the cache.
+func (s *Service) runningExperiments(ctx context.Context) ([]Experiment, error) {
+	cached, err := s.cache.Get(ctx, s.cache.Key(runningCacheKey))
+	telemetry.RecordCacheLookup("experiment", err == nil)
+	if err == nil && cached != "" {
+		var experiments []Experiment
+		if err := json.Unmarshal([]byte(cached), &experiments); err == nil {
+			return experiments, nil
+		}
+	}
+
+	rows, err := s.db.Reader().QueryContext(ctx, `
+		SELECT `+experimentColumns+`
+		FROM experiments WHERE status = 'running'
+		ORDER BY started_at, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	experiments := []Experiment{}
+	for rows.Next() {
+		experiment, err := scanExperiment(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		experiments = append(experiments, *experiment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(experiments); err == nil {
+		if err := s.cache.Set(ctx, s.cache.Key(runningCacheKey), string(data), time.Minute); err != nil {
+			logrus.Warnf("Failed to cache running experiments: %v", err)
+		}
+	}
+	return experiments, nil
+}
+
+// experimentColumns lists the columns scanExperiment expects, in order
+const experimentColumns = `id, key, name, description, status, segment, variants, started_at,
+	stopped_at, created_at, updated_at`
+
+func scanExperiment(scan func(dest ...interface{}) error) (*Experiment, error) {
+	var e Experiment
+	var variants []byte
+	if err := scan(&e.ID, &e.Key, &e.Name, &e.Description, &e.Status, &e.Segment, &variants,
+		&e.StartedAt, &e.StoppedAt, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(variants, &e.Variants); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// createExperiment inserts a draft experiment. It returns sql.ErrNoRows if
+// the key is taken, and a foreign key violation on segmentConstraint if the
+// segment does not exist.
+func (s *Service) createExperiment(ctx context.Context, req CreateExperimentRequest) (*Experiment, error) {
+	variants, err := json.Marshal(req.Variants)
+	if err != nil {
+		return nil, err
+	}
+	row := s.db.QueryRowContext(ctx, `
+		INSERT INTO experiments (key, name, description, segment, variants)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (key) DO NOTHING
+		RETURNING `+experimentColumns,
+		req.Key, req.Name, req.Description, req.Segment, variants)
+	return scanExperiment(row.Scan)
+}
+
+func (s *Service) getExperiment(ctx context.Context, id string) (*Experiment, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+experimentColumns+`
+		FROM experiments WHERE id = $1
+	`, id)
+	return scanExperiment(row.Scan)
+}
+
+// updateStatus moves an experiment from one status to another. It returns
+// sql.ErrNoRows if the experiment does not exist or is not in from.
+func (s *Service) updateStatus(ctx context.Context, id, from, to string) (*Experiment, error) {
+	row := s.db.QueryRowContext(ctx, `
+		UPDATE experiments
+		SET status = $3,
+			started_at = CASE WHEN $3 = 'running' THEN NOW() ELSE started_at END,
+			stopped_at = CASE WHEN $3 = 'stopped' THEN NOW() ELSE stopped_at END,
+			updated_at = NOW()
+		WHERE id = $1 AND status = $2
+		RETURNING `+experimentColumns,
+		id, from, to)
+	return scanExperiment(row.Scan)
+}
+
+func (s *Service) listExperiments(ctx context.Context, status string, cursor *db.Cursor, limit int) ([]Experiment, string, error) {
+	query := `
+		SELECT ` + experimentColumns + `
+		FROM experiments
+		WHERE ($1 = '' OR status = $1)
+			AND ($2::timestamptz IS NULL OR (created_at, id::text) < ($2, $3))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`
+
+	after, afterID := cursor.After()
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := s.db.Reader().QueryContext(ctx, query, status, after, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var experiments []Experiment
+	for rows.Next() {
+		experiment, err := scanExperiment(rows.Scan)
+		if err != nil {
+			return nil, "", err
+		}
+		experiments = append(experiments, *experiment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	experiments, nextCursor := db.NextPage(experiments, limit, func(row Experiment) db.Cursor {
+		return db.Cursor{CreatedAt: row.CreatedAt, ID: row.ID}
+	})
+
+	return experiments, nextCursor, nil
+}
@@ -0,0 +1,41 @@
+package featureflag
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateTenantOverrideWins(t *testing.T) {
+	flag := Flag{
+		Name:            Dunning,
+		Enabled:         false,
+		TenantOverrides: map[string]bool{"tenant_a": true},
+	}
+
+	assert.True(t, flag.Evaluate("tenant_a", "user_1"))
+	assert.False(t, flag.Evaluate("tenant_b", "user_1"))
+	assert.False(t, flag.Evaluate("", "user_1"))
+}
+
+func TestEvaluateRolloutIsStableAndProportional(t *testing.T) {
+	flag := Flag{Name: NewGateway, Enabled: true, RolloutPercentage: 25}
+
+	on := 0
+	for i := 0; i < 10000; i++ {
+		subject := fmt.Sprintf("user_%d", i)
+		if flag.Evaluate("", subject) {
+			on++
+		}
+		assert.Equal(t, flag.Evaluate("", subject), flag.Evaluate("", subject))
+	}
+
+	assert.InDelta(t, 2500, on, 250)
+}
+
+func TestEvaluateBounds(t *testing.T) {
+	assert.True(t, Flag{Name: MeteredPaywall, Enabled: true, RolloutPercentage: 100}.Evaluate("", "user_1"))
+	assert.False(t, Flag{Name: MeteredPaywall, Enabled: true, RolloutPercentage: 0}.Evaluate("", "user_1"))
+	assert.False(t, Flag{Name: MeteredPaywall, Enabled: false, RolloutPercentage: 100}.Evaluate("", "user_1"))
+}
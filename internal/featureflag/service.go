@@ -0,0 +1,146 @@
+package featureflag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Service evaluates feature flags. Defaults come from config; changes made
+// through the admin endpoints are stored in Redis so every instance sees them
+// without a redeploy.
+type Service struct {
+	cache    *cache.RedisClient
+	defaults map[string]Flag
+}
+
+type UpdateFlagRequest struct {
+	Enabled           *bool           `json:"enabled"`
+	RolloutPercentage *int            `json:"rollout_percentage" binding:"omitempty,min=0,max=100"`
+	TenantOverrides   map[string]bool `json:"tenant_overrides"`
+}
+
+func NewService(cfg map[string]config.FeatureFlagConfig, cache *cache.RedisClient) *Service {
+	defaults := make(map[string]Flag, len(cfg))
+	for name, flagCfg := range cfg {
+		defaults[name] = fromConfig(name, flagCfg)
+	}
+	return &Service{cache: cache, defaults: defaults}
+}
+
+// Enabled reports whether flag name is on for subject within tenant. Unknown
+// flags are off.
+func (s *Service) Enabled(ctx context.Context, name, tenant, subject string) bool {
+	flag, ok := s.get(ctx, name)
+	if !ok {
+		return false
+	}
+	return flag.Evaluate(tenant, subject)
+}
+
+func (s *Service) ListFlags(c *gin.Context) {
+	names := make([]string, 0, len(s.defaults))
+	for name := range s.defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flags := make([]Flag, 0, len(names))
+	for _, name := range names {
+		flag, _ := s.get(c.Request.Context(), name)
+		flags = append(flags, flag)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"flags": flags})
+}
+
+func (s *Service) UpdateFlag(c *gin.Context) {
+	name := c.Param("name")
+	flag, ok := s.get(c.Request.Context(), name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feature flag not found"})
+		return
+	}
+
+	var req UpdateFlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Enabled != nil {
+		flag.Enabled = *req.Enabled
+	}
+	if req.RolloutPercentage != nil {
+		flag.RolloutPercentage = *req.RolloutPercentage
+	}
+	if req.TenantOverrides != nil {
+		flag.TenantOverrides = req.TenantOverrides
+	}
+	flag.Source = "runtime"
+
+	data, err := json.Marshal(flag)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update feature flag"})
+		return
+	}
+	if err := s.cache.Set(c.Request.Context(), flagKey(name), string(data), 0); err != nil {
+		logrus.Errorf("Failed to store feature flag %s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update feature flag"})
+		return
+	}
+
+	logrus.Infof("Feature flag %s updated: enabled=%t rollout=%d%%", name, flag.Enabled, flag.RolloutPercentage)
+	c.JSON(http.StatusOK, flag)
+}
+
+// ResetFlag drops the runtime override so the config default applies again.
+func (s *Service) ResetFlag(c *gin.Context) {
+	name := c.Param("name")
+	flag, ok := s.defaults[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feature flag not found"})
+		return
+	}
+
+	if err := s.cache.Del(c.Request.Context(), flagKey(name)); err != nil {
+		logrus.Errorf("Failed to reset feature flag %s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset feature flag"})
+		return
+	}
+
+	c.JSON(http.StatusOK, flag)
+}
+
+// get returns the runtime override for name if one is stored, else the
+// config default. Redis errors fall back to the default.
+func (s *Service) get(ctx context.Context, name string) (Flag, bool) {
+	flag, ok := s.defaults[name]
+	if !ok {
+		return Flag{}, false
+	}
+
+	data, err := s.cache.Get(ctx, flagKey(name))
+	if err != nil {
+		return flag, true
+	}
+
+	var override Flag
+	if err := json.Unmarshal([]byte(data), &override); err != nil {
+		logrus.Warnf("Ignoring malformed feature flag override %s: %v", name, err)
+		return flag, true
+	}
+	return override, true
+}
+
+func flagKey(name string) string {
+	return fmt.Sprintf("featureflag:%s", name)
+}
@@ -0,0 +1,113 @@
+package fx
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/shopspring/decimal"
+)
+
+// Provider fetches the latest available exchange rates.
+type Provider interface {
+	Latest(ctx context.Context) (*Rates, error)
+}
+
+// NewProvider builds the Provider selected by cfg.Source.
+func NewProvider(cfg config.FXConfig) (Provider, error) {
+	switch strings.ToLower(cfg.Source) {
+	case "ecb":
+		return &ecbProvider{url: cfg.URL, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	case "static":
+		return newStaticProvider(cfg)
+	default:
+		return nil, fmt.Errorf("unknown exchange rate source %q", cfg.Source)
+	}
+}
+
+// ecbProvider reads the European Central Bank's daily reference rates,
+// which are quoted against EUR and published once per working day.
+type ecbProvider struct {
+	url    string
+	client *http.Client
+}
+
+type ecbEnvelope struct {
+	Cube struct {
+		Cube struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string `xml:"currency,attr"`
+				Rate     string `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+func (p *ecbProvider) Latest(ctx context.Context) (*Rates, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ECB returned status %d", resp.StatusCode)
+	}
+
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode ECB rates: %w", err)
+	}
+
+	asOf, err := time.Parse("2006-01-02", envelope.Cube.Cube.Time)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ECB rate date %q: %w", envelope.Cube.Cube.Time, err)
+	}
+
+	rates := &Rates{Base: "EUR", AsOf: asOf, Rates: make(map[string]decimal.Decimal)}
+	for _, r := range envelope.Cube.Cube.Rates {
+		rate, err := decimal.NewFromString(r.Rate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ECB rate for %s: %w", r.Currency, err)
+		}
+		rates.Rates[r.Currency] = rate
+	}
+	return rates, nil
+}
+
+// staticProvider serves fixed rates from config, for development and tests.
+type staticProvider struct {
+	rates *Rates
+}
+
+func newStaticProvider(cfg config.FXConfig) (*staticProvider, error) {
+	rates := &Rates{
+		Base:  strings.ToUpper(cfg.BaseCurrency),
+		AsOf:  time.Now().UTC().Truncate(24 * time.Hour),
+		Rates: make(map[string]decimal.Decimal, len(cfg.StaticRates)),
+	}
+	for currency, value := range cfg.StaticRates {
+		rate, err := decimal.NewFromString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid static rate for %s: %w", currency, err)
+		}
+		// Viper lowercases map keys
+		rates.Rates[strings.ToUpper(currency)] = rate
+	}
+	return &staticProvider{rates: rates}, nil
+}
+
+func (p *staticProvider) Latest(ctx context.Context) (*Rates, error) {
+	return p.rates, nil
+}
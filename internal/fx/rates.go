@@ -0,0 +1,60 @@
+// Package fx provides exchange rates for normalizing amounts in different
+// currencies into a single base currency for comparison and reporting.
+package fx
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/money"
+
+	"github.com/shopspring/decimal"
+)
+
+// Rates is a snapshot of exchange rates quoted against Base: Rates[c] is the
+// number of units of currency c per one unit of Base.
+type Rates struct {
+	Base  string                     `json:"base"`
+	AsOf  time.Time                  `json:"as_of"`
+	Rates map[string]decimal.Decimal `json:"rates"`
+}
+
+// Rate returns how many units of to one unit of from buys.
+func (r *Rates) Rate(from, to string) (decimal.Decimal, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == to {
+		return decimal.NewFromInt(1), nil
+	}
+
+	fromRate, err := r.quote(from)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	toRate, err := r.quote(to)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return toRate.DivRound(fromRate, 8), nil
+}
+
+// Convert converts amount from one currency to another, rounded to the
+// target currency's minor unit, and returns the rate used.
+func (r *Rates) Convert(amount decimal.Decimal, from, to string) (decimal.Decimal, decimal.Decimal, error) {
+	rate, err := r.Rate(from, to)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+	return money.Round(amount.Mul(rate), to), rate, nil
+}
+
+func (r *Rates) quote(currency string) (decimal.Decimal, error) {
+	if currency == r.Base {
+		return decimal.NewFromInt(1), nil
+	}
+	rate, ok := r.Rates[currency]
+	if !ok || !rate.IsPositive() {
+		return decimal.Zero, fmt.Errorf("no exchange rate for %s", currency)
+	}
+	return rate, nil
+}
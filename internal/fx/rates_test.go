@@ -0,0 +1,52 @@
+package fx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRates() *Rates {
+	return &Rates{
+		Base: "EUR",
+		AsOf: time.Date(2023, 10, 13, 0, 0, 0, 0, time.UTC),
+		Rates: map[string]decimal.Decimal{
+			"USD": decimal.RequireFromString("1.05"),
+			"GBP": decimal.RequireFromString("0.84"),
+			"JPY": decimal.RequireFromString("157.5"),
+		},
+	}
+}
+
+func TestConvertThroughBase(t *testing.T) {
+	rates := testRates()
+
+	amount, rate, err := rates.Convert(decimal.RequireFromString("10.50"), "USD", "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, "10", amount.String())
+	assert.Equal(t, "0.95238095", rate.String())
+
+	// Cross rate USD -> GBP goes via EUR and rounds to pence
+	amount, _, err = rates.Convert(decimal.RequireFromString("10.50"), "USD", "GBP")
+	assert.NoError(t, err)
+	assert.Equal(t, "8.4", amount.String())
+
+	// Zero-decimal target currency
+	amount, _, err = rates.Convert(decimal.RequireFromString("9.99"), "EUR", "JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, "1573", amount.String())
+}
+
+func TestConvertUnknownCurrency(t *testing.T) {
+	_, _, err := testRates().Convert(decimal.NewFromInt(1), "USD", "CHF")
+	assert.Error(t, err)
+}
+
+func TestConvertSameCurrency(t *testing.T) {
+	amount, rate, err := testRates().Convert(decimal.RequireFromString("4.20"), "chf", "CHF")
+	assert.NoError(t, err)
+	assert.Equal(t, "4.2", amount.String())
+	assert.Equal(t, "1", rate.String())
+}
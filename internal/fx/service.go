@@ -0,0 +1,162 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+const ratesCacheKey = "fx:rates"
+
+// Refreshes that fail are retried after retryBackoff, doubling with each
+// failure up to the refresh interval. A refresh gives up after
+// refreshTimeout, whoever asked for it.
+const (
+	retryBackoff   = 30 * time.Second
+	refreshTimeout = 30 * time.Second
+)
+
+// Service serves exchange rates, caching them in memory and Redis so the
+// upstream source is hit at most once per refresh interval across instances.
+type Service struct {
+	provider     Provider
+	cache        *cache.RedisClient
+	baseCurrency string
+	ttl          time.Duration
+	load         func(ctx context.Context) (*Rates, error)
+	refreshes    singleflight.Group
+
+	mu        sync.Mutex
+	current   *Rates
+	fetchedAt time.Time
+	failures  int
+	retryAt   time.Time
+	lastErr   error
+}
+
+func NewService(cfg config.FXConfig, cache *cache.RedisClient) (*Service, error) {
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		provider:     provider,
+		cache:        cache,
+		baseCurrency: strings.ToUpper(cfg.BaseCurrency),
+		ttl:          time.Duration(cfg.RefreshInterval) * time.Second,
+	}
+	s.load = s.loadCached
+	return s, nil
+}
+
+// BaseCurrency is the currency comparisons and reports are normalized into.
+func (s *Service) BaseCurrency() string {
+	return s.baseCurrency
+}
+
+// Rates returns the current rate snapshot. Once it is stale it is still
+// served while one refresh runs in the background, and for as long as
+// refreshes fail; callers only wait for the first snapshot. After a failed
+// refresh the next is not tried before the backoff has passed.
+func (s *Service) Rates(ctx context.Context) (*Rates, error) {
+	s.mu.Lock()
+	current, fresh := s.current, time.Since(s.fetchedAt) < s.ttl
+	backingOff, lastErr := time.Now().Before(s.retryAt), s.lastErr
+	s.mu.Unlock()
+
+	if current != nil && fresh {
+		return current, nil
+	}
+	if backingOff {
+		if current != nil {
+			return current, nil
+		}
+		return nil, lastErr
+	}
+
+	refreshed := s.refreshes.DoChan(ratesCacheKey, func() (interface{}, error) {
+		return s.refresh(context.WithoutCancel(ctx))
+	})
+	if current != nil {
+		return current, nil
+	}
+	select {
+	case result := <-refreshed:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*Rates), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// refresh loads a new snapshot, or on failure schedules the next attempt
+func (s *Service) refresh(ctx context.Context) (*Rates, error) {
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+	rates, err := s.load(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failures++
+		s.retryAt = time.Now().Add(s.backoff())
+		s.lastErr = err
+		if s.current != nil {
+			logrus.Warnf("Failed to refresh exchange rates, serving rates as of %s until %s: %v",
+				s.current.AsOf.Format("2006-01-02"), s.retryAt.Format(time.RFC3339), err)
+		}
+		return nil, err
+	}
+	s.current = rates
+	s.fetchedAt = time.Now()
+	s.failures = 0
+	s.retryAt = time.Time{}
+	s.lastErr = nil
+	return rates, nil
+}
+
+// backoff is how long to wait after the latest of s.failures failures
+func (s *Service) backoff() time.Duration {
+	delay := retryBackoff
+	for i := 1; i < s.failures && delay < s.ttl; i++ {
+		delay *= 2
+	}
+	if s.ttl > 0 && delay > s.ttl {
+		return s.ttl
+	}
+	return delay
+}
+
+func (s *Service) loadCached(ctx context.Context) (*Rates, error) {
+	data, err := s.cache.Get(ctx, s.cache.Key(ratesCacheKey))
+	telemetry.RecordCacheLookup("fx", err == nil)
+	if err == nil {
+		var rates Rates
+		if err := json.Unmarshal([]byte(data), &rates); err == nil {
+			return &rates, nil
+		}
+	}
+
+	rates, err := s.provider.Latest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(rates); err == nil {
+		if err := s.cache.Set(ctx, s.cache.Key(ratesCacheKey), string(data), s.ttl); err != nil {
+			logrus.Errorf("Failed to cache exchange rates: %v", err)
+		}
+	}
+	return rates, nil
+}
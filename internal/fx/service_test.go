@@ -0,0 +1,96 @@
+package fx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatesFetchOnceAndServeStale(t *testing.T) {
+	ctx := context.Background()
+	var loads atomic.Int32
+	release := make(chan struct{})
+	s := &Service{ttl: time.Hour}
+	s.load = func(context.Context) (*Rates, error) {
+		loads.Add(1)
+		<-release
+		return testRates(), nil
+	}
+
+	// Concurrent callers without a snapshot share one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rates, err := s.Rates(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, "EUR", rates.Base)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), loads.Load())
+
+	// A stale snapshot is served while the refresh fails, and the refresh
+	// isn't retried before the backoff has passed
+	s.mu.Lock()
+	s.fetchedAt = time.Now().Add(-2 * time.Hour)
+	s.mu.Unlock()
+	failed := make(chan struct{})
+	s.load = func(context.Context) (*Rates, error) {
+		loads.Add(1)
+		defer close(failed)
+		return nil, errors.New("ecb unreachable")
+	}
+	rates, err := s.Rates(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "EUR", rates.Base)
+	<-failed
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return !s.retryAt.IsZero()
+	}, time.Second, 5*time.Millisecond)
+
+	rates, err = s.Rates(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "EUR", rates.Base)
+	assert.Equal(t, int32(2), loads.Load())
+}
+
+func TestRatesBackOffWithoutSnapshot(t *testing.T) {
+	var loads atomic.Int32
+	s := &Service{ttl: time.Hour}
+	s.load = func(context.Context) (*Rates, error) {
+		loads.Add(1)
+		return nil, errors.New("ecb unreachable")
+	}
+
+	_, err := s.Rates(context.Background())
+	assert.EqualError(t, err, "ecb unreachable")
+	_, err = s.Rates(context.Background())
+	assert.EqualError(t, err, "ecb unreachable")
+	assert.Equal(t, int32(1), loads.Load())
+}
+
+func TestBackoff(t *testing.T) {
+	s := &Service{ttl: 5 * time.Minute}
+	for failures, want := range map[int]time.Duration{
+		1: 30 * time.Second,
+		2: time.Minute,
+		4: 4 * time.Minute,
+		5: 5 * time.Minute,
+		9: 5 * time.Minute,
+	} {
+		s.failures = failures
+		assert.Equal(t, want, s.backoff(), "after %d failures", failures)
+	}
+}
@@ -0,0 +1,67 @@
+// Package health reports whether the instance can serve traffic.
+package health
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Database is the primary database, as db.Connection checks it
+type Database interface {
+	HealthCheck() error
+}
+
+// Cache is Redis, as cache.RedisClient tracks whether it is reachable
+type Cache interface {
+	Degraded() bool
+}
+
+// Component states
+const (
+	StateOK          = "ok"
+	StateDegraded    = "degraded"
+	StateUnavailable = "unavailable"
+)
+
+// ReadyResponse is the state of each dependency. Degraded is set while the
+// instance serves without Redis: sessions are validated from the database,
+// nothing is cached and paywall enforcement follows
+// paywall.degraded_policy.
+type ReadyResponse struct {
+	Status   string `json:"status"`
+	Database string `json:"database"`
+	Redis    string `json:"redis"`
+	Degraded bool   `json:"degraded"`
+}
+
+// Checker answers readiness probes
+type Checker struct {
+	db    Database
+	cache Cache
+}
+
+func NewChecker(db Database, cache Cache) *Checker {
+	return &Checker{db: db, cache: cache}
+}
+
+// Readyz answers 503 when the database is unreachable, as nothing can be
+// served without it, and 200 otherwise, flagging a degraded Redis so the
+// instance stays in rotation.
+func (h *Checker) Readyz(c *gin.Context) {
+	response := ReadyResponse{Status: StateOK, Database: StateOK, Redis: StateOK}
+	if h.cache.Degraded() {
+		response.Status = StateDegraded
+		response.Redis = StateDegraded
+		response.Degraded = true
+	}
+	if err := h.db.HealthCheck(); err != nil {
+		logrus.Errorf("Readiness check failed, database unreachable: %v", err)
+		response.Status = StateUnavailable
+		response.Database = StateUnavailable
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
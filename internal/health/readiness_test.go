@@ -0,0 +1,48 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDatabase struct{ err error }
+
+func (d fakeDatabase) HealthCheck() error { return d.err }
+
+type fakeCache struct{ degraded bool }
+
+func (c fakeCache) Degraded() bool { return c.degraded }
+
+func readyz(t *testing.T, db Database, cache Cache) (int, ReadyResponse) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	NewChecker(db, cache).Readyz(c)
+
+	var response ReadyResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	return recorder.Code, response
+}
+
+func TestReadyz(t *testing.T) {
+	code, response := readyz(t, fakeDatabase{}, fakeCache{})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ReadyResponse{Status: "ok", Database: "ok", Redis: "ok"}, response)
+
+	// Without Redis the instance stays ready, flagged degraded
+	code, response = readyz(t, fakeDatabase{}, fakeCache{degraded: true})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ReadyResponse{Status: "degraded", Database: "ok", Redis: "degraded", Degraded: true}, response)
+
+	code, response = readyz(t, fakeDatabase{err: errors.New("connection refused")}, fakeCache{})
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", response.Status)
+	assert.Equal(t, "unavailable", response.Database)
+}
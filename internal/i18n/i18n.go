@@ -0,0 +1,230 @@
+// Package i18n translates API messages and notification templates. A
+// bundle per locale maps English messages, as the code writes them, to
+// their translation; a message without one is looked up in the locale's
+// parents and the default locale, and otherwise stays English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"scalable-paywall/internal/config"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed locales/*.json
+var builtinLocales embed.FS
+
+// placeholder is {0}, {1}, ... in a message, matching any text
+var placeholder = regexp.MustCompile(`\{(\d)\}`)
+
+// pattern is a message with placeholders, e.g. binding errors naming the
+// field that failed
+type pattern struct {
+	match       *regexp.Regexp
+	groups      []int
+	translation string
+}
+
+type catalog struct {
+	messages map[string]string
+	patterns []pattern
+}
+
+// Bundle holds the translations of every locale
+type Bundle struct {
+	catalogs      map[string]*catalog
+	defaultLocale string
+	supported     []language.Tag
+	matcher       language.Matcher
+}
+
+// Load reads the built-in bundles and those in cfg.Directory, whose
+// messages replace the built-in ones. Bundles are <locale>.json files
+// holding an object of English messages and their translations.
+func Load(cfg config.I18nConfig) (*Bundle, error) {
+	b := &Bundle{catalogs: map[string]*catalog{}, defaultLocale: language.Make(cfg.DefaultLocale).String()}
+
+	entries, err := builtinLocales.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		data, err := builtinLocales.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := b.add(entry.Name(), data); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Directory != "" {
+		paths, err := filepath.Glob(filepath.Join(cfg.Directory, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := b.add(filepath.Base(path), data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The default locale is supported even without a bundle; it is
+	// listed first so the matcher falls back to it
+	b.supported = []language.Tag{language.Make(b.defaultLocale)}
+	for locale := range b.catalogs {
+		if locale != b.defaultLocale {
+			b.supported = append(b.supported, language.Make(locale))
+		}
+	}
+	b.matcher = language.NewMatcher(b.supported)
+	return b, nil
+}
+
+// add merges the bundle in file name, e.g. de.json, into the bundle's
+// catalog for its locale
+func (b *Bundle) add(name string, data []byte) error {
+	tag, err := language.Parse(strings.TrimSuffix(name, ".json"))
+	if err != nil {
+		return fmt.Errorf("translation bundle %s is not named after a locale: %w", name, err)
+	}
+	var messages map[string]string
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return fmt.Errorf("failed to decode translation bundle %s: %w", name, err)
+	}
+
+	locale := tag.String()
+	c := b.catalogs[locale]
+	if c == nil {
+		c = &catalog{messages: map[string]string{}}
+		b.catalogs[locale] = c
+	}
+	for message, translation := range messages {
+		if !placeholder.MatchString(message) {
+			c.messages[message] = translation
+			continue
+		}
+		p, err := compilePattern(message, translation)
+		if err != nil {
+			return fmt.Errorf("translation bundle %s: %w", name, err)
+		}
+		c.patterns = append(c.patterns, p)
+	}
+	return nil
+}
+
+func compilePattern(message, translation string) (pattern, error) {
+	p := pattern{translation: translation}
+	var expr strings.Builder
+	expr.WriteString("^")
+	last := 0
+	for _, loc := range placeholder.FindAllStringSubmatchIndex(message, -1) {
+		expr.WriteString(regexp.QuoteMeta(message[last:loc[0]]))
+		expr.WriteString("(.*?)")
+		p.groups = append(p.groups, int(message[loc[2]]-'0'))
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(message[last:]))
+	expr.WriteString("$")
+	match, err := regexp.Compile(expr.String())
+	if err != nil {
+		return pattern{}, fmt.Errorf("message %q: %w", message, err)
+	}
+	p.match = match
+	return p, nil
+}
+
+// Match picks the supported locale that best fits an Accept-Language
+// header, or the default locale
+func (b *Bundle) Match(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return b.defaultLocale
+	}
+	_, index, confidence := b.matcher.Match(tags...)
+	if confidence == language.No {
+		return b.defaultLocale
+	}
+	return b.supported[index].String()
+}
+
+// DefaultLocale is the locale messages fall back to
+func (b *Bundle) DefaultLocale() string {
+	return b.defaultLocale
+}
+
+// Translate returns message in locale. A message missing from the locale
+// is looked up in its parents (de-AT, then de), then in the default
+// locale; one missing everywhere is returned as it is. Messages spanning
+// several lines, like binding errors, are translated line by line.
+func (b *Bundle) Translate(locale, message string) string {
+	if b == nil || message == "" {
+		return message
+	}
+	lines := strings.Split(message, "\n")
+	for i, line := range lines {
+		lines[i] = b.translateLine(locale, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (b *Bundle) translateLine(locale, message string) string {
+	for _, candidate := range b.Fallbacks(locale) {
+		c := b.catalogs[candidate]
+		if c == nil {
+			continue
+		}
+		if translation, ok := c.messages[message]; ok {
+			return translation
+		}
+		for _, p := range c.patterns {
+			if values := p.match.FindStringSubmatch(message); values != nil {
+				return p.fill(values[1:])
+			}
+		}
+	}
+	return message
+}
+
+// fill puts the values matched for each placeholder into the translation
+func (p pattern) fill(values []string) string {
+	byIndex := make(map[int]string, len(values))
+	for i, group := range p.groups {
+		byIndex[group] = values[i]
+	}
+	return placeholder.ReplaceAllStringFunc(p.translation, func(ref string) string {
+		return byIndex[int(ref[1]-'0')]
+	})
+}
+
+// Fallbacks lists where a message in locale is looked for, in order: the
+// locale, its parents and the default locale
+func (b *Bundle) Fallbacks(locale string) []string {
+	var fallbacks []string
+	if tag, err := language.Parse(locale); err == nil {
+		for ; tag != language.Und; tag = tag.Parent() {
+			fallbacks = append(fallbacks, tag.String())
+		}
+	}
+	if b == nil {
+		return fallbacks
+	}
+	for _, fallback := range fallbacks {
+		if fallback == b.defaultLocale {
+			return fallbacks
+		}
+	}
+	return append(fallbacks, b.defaultLocale)
+}
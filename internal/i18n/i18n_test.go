@@ -0,0 +1,67 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslate(t *testing.T) {
+	bundle, err := Load(config.I18nConfig{DefaultLocale: "en"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Abonnement nicht gefunden", bundle.Translate("de", "Subscription not found"))
+	// Regional locales fall back to their language
+	assert.Equal(t, "Abonnement nicht gefunden", bundle.Translate("de-AT", "Subscription not found"))
+	assert.Equal(t, "Subscription not found", bundle.Translate("en", "Subscription not found"))
+	assert.Equal(t, "Something new", bundle.Translate("de", "Something new"))
+
+	// Placeholders carry what the message names into the translation
+	assert.Equal(t, "Feld 'Email' ist ungültig (Regel 'email')\nFeld 'Username' ist ungültig (Regel 'min')",
+		bundle.Translate("de", "Key: 'CreateUserRequest.Email' Error:Field validation for 'Email' failed on the 'email' tag\n"+
+			"Key: 'CreateUserRequest.Username' Error:Field validation for 'Username' failed on the 'min' tag"))
+	assert.Equal(t, `L'URL de retour "https://evil.example" n'est pas autorisée`,
+		bundle.Translate("fr", `Return URL "https://evil.example" is not allowed`))
+
+	var missing *Bundle
+	assert.Equal(t, "Subscription not found", missing.Translate("de", "Subscription not found"))
+}
+
+func TestLoadDirectoryAndDefaultLocale(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "de.json"),
+		[]byte(`{"Subscription not found": "Kein Abo gefunden"}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pt-BR.json"),
+		[]byte(`{"Plan not found": "Plano não encontrado"}`), 0o600))
+
+	bundle, err := Load(config.I18nConfig{DefaultLocale: "de", Directory: dir})
+	require.NoError(t, err)
+
+	// The directory's messages replace the built-in ones, others stay
+	assert.Equal(t, "Kein Abo gefunden", bundle.Translate("de", "Subscription not found"))
+	assert.Equal(t, "Benutzer nicht gefunden", bundle.Translate("de", "User not found"))
+	// Messages missing from a locale come in the default one
+	assert.Equal(t, "Plano não encontrado", bundle.Translate("pt-BR", "Plan not found"))
+	assert.Equal(t, "Benutzer nicht gefunden", bundle.Translate("pt-BR", "User not found"))
+	assert.Equal(t, []string{"pt-BR", "pt", "de"}, bundle.Fallbacks("pt-BR"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "deutsch!.json"), []byte(`{}`), 0o600))
+	_, err = Load(config.I18nConfig{DefaultLocale: "de", Directory: dir})
+	assert.ErrorContains(t, err, "not named after a locale")
+}
+
+func TestMatch(t *testing.T) {
+	bundle, err := Load(config.I18nConfig{DefaultLocale: "en"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "de", bundle.Match("de-CH,de;q=0.9,en;q=0.8"))
+	assert.Equal(t, "fr", bundle.Match("ja;q=0.9,fr;q=0.5"))
+	assert.Equal(t, "en", bundle.Match("ja"))
+	assert.Equal(t, "en", bundle.Match(""))
+	assert.Equal(t, "en", bundle.Match("not a header;;"))
+}
@@ -0,0 +1,299 @@
+package imports
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/plan"
+	"scalable-paywall/internal/subscription"
+
+	"github.com/shopspring/decimal"
+)
+
+// errInvalidRow marks a row that could not be read
+var errInvalidRow = errors.New("invalid row")
+
+// columns are the CSV columns each kind accepts, named as the JSON fields
+var columns = map[string][]string{
+	KindPlans: {
+		"name", "description", "price", "currency", "billing_cycle", "billing_interval",
+		"billing_anchor_day", "type", "parent_plan_id", "features", "max_usage_per_day", "max_usage_per_month",
+		"grace_period_days", "usage_rollover_cap", "usage_overage_percent", "is_active",
+		"display_order", "badge", "marketing_bullets", "cta_text",
+	},
+	KindSubscribers: {
+		"user_id", "plan_id", "status", "start_date", "end_date", "auto_renew",
+		"payment_method", "amount", "currency", "external_ref", "metadata", "billing_anchor_day",
+	},
+}
+
+// record is one row of an import file: a CSV row's non-empty cells by
+// column, or a JSONL line's object. err is set for a row that can't be
+// read, so it is reported without failing the rest of the file.
+type record struct {
+	line   int
+	fields map[string]string
+	object json.RawMessage
+	err    error
+}
+
+// parseRecords splits an import file into rows. It fails only when the file
+// as a whole is unusable: unknown CSV columns or broken CSV quoting.
+func parseRecords(format, data string, allowed []string) ([]record, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(data, allowed)
+	case FormatJSONL:
+		return parseJSONL(data), nil
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+func parseCSV(data string, allowed []string) ([]record, error) {
+	known := make(map[string]bool, len(allowed))
+	for _, column := range allowed {
+		known[column] = true
+	}
+
+	r := csv.NewReader(strings.NewReader(data))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if !known[header[i]] {
+			return nil, fmt.Errorf("unknown column %q", header[i])
+		}
+	}
+
+	var records []record
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := r.FieldPos(0)
+		rec := record{line: line}
+		if len(row) != len(header) {
+			rec.err = fmt.Errorf("%w: %d fields, header has %d", errInvalidRow, len(row), len(header))
+			records = append(records, rec)
+			continue
+		}
+		rec.fields = make(map[string]string, len(row))
+		for i, value := range row {
+			if value = strings.TrimSpace(value); value != "" {
+				rec.fields[header[i]] = value
+			}
+		}
+		records = append(records, rec)
+	}
+}
+
+// parseJSONL reads one object per line, skipping blank lines
+func parseJSONL(data string) []record {
+	var records []record
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		rec := record{line: line, object: json.RawMessage(append([]byte(nil), text...))}
+		if !json.Valid(text) || text[0] != '{' {
+			rec.err = fmt.Errorf("%w: not a JSON object", errInvalidRow)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+// decodeObject unmarshals a JSONL row, refusing fields the kind doesn't have
+func decodeObject(object json.RawMessage, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(object))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidRow, err)
+	}
+	return nil
+}
+
+func planRequest(rec record) (plan.CreatePlanRequest, error) {
+	var req plan.CreatePlanRequest
+	if rec.fields == nil {
+		return req, decodeObject(rec.object, &req)
+	}
+
+	c := cells{fields: rec.fields}
+	req.Name = rec.fields["name"]
+	req.Description = c.optString("description")
+	req.Price = c.decimal("price")
+	req.Currency = rec.fields["currency"]
+	req.BillingCycle = rec.fields["billing_cycle"]
+	req.BillingInterval = c.optInt("billing_interval")
+	req.BillingAnchorDay = c.optInt("billing_anchor_day")
+	req.Type = rec.fields["type"]
+	req.ParentPlanID = c.optString("parent_plan_id")
+	req.Features = c.object("features")
+	req.MaxUsagePerDay = c.optInt("max_usage_per_day")
+	req.MaxUsagePerMonth = c.optInt("max_usage_per_month")
+	req.GracePeriodDays = c.optInt("grace_period_days")
+	req.RolloverCap = c.optInt("usage_rollover_cap")
+	req.OveragePercent = c.optInt("usage_overage_percent")
+	req.IsActive = c.optBool("is_active")
+	if displayOrder := c.optInt("display_order"); displayOrder != nil {
+		req.DisplayOrder = *displayOrder
+	}
+	req.Badge = c.optString("badge")
+	req.MarketingBullets = c.stringList("marketing_bullets")
+	req.CTAText = c.optString("cta_text")
+	return req, c.err
+}
+
+func subscriberRequest(rec record) (subscription.ImportSubscriptionRequest, error) {
+	var req subscription.ImportSubscriptionRequest
+	if rec.fields == nil {
+		return req, decodeObject(rec.object, &req)
+	}
+
+	c := cells{fields: rec.fields}
+	req.UserID = rec.fields["user_id"]
+	req.PlanID = rec.fields["plan_id"]
+	req.Status = rec.fields["status"]
+	req.StartDate = c.optTime("start_date")
+	req.EndDate = c.optTime("end_date")
+	if autoRenew := c.optBool("auto_renew"); autoRenew != nil {
+		req.AutoRenew = *autoRenew
+	}
+	req.PaymentMethod = rec.fields["payment_method"]
+	if _, ok := rec.fields["amount"]; ok {
+		amount := c.decimal("amount")
+		req.Amount = &amount
+	}
+	req.Currency = rec.fields["currency"]
+	req.ExternalRef = rec.fields["external_ref"]
+	req.Metadata = c.object("metadata")
+	req.BillingAnchorDay = c.optInt("billing_anchor_day")
+	return req, c.err
+}
+
+// cells converts a CSV row's cells, keeping the first conversion error.
+// Missing cells convert to zero values or nil.
+type cells struct {
+	fields map[string]string
+	err    error
+}
+
+func (c *cells) fail(column string, err error) {
+	if c.err == nil {
+		c.err = fmt.Errorf("%w: %s: %v", errInvalidRow, column, err)
+	}
+}
+
+func (c *cells) optString(column string) *string {
+	value, ok := c.fields[column]
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+func (c *cells) decimal(column string) decimal.Decimal {
+	value, ok := c.fields[column]
+	if !ok {
+		return decimal.Zero
+	}
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		c.fail(column, errors.New("not a number"))
+	}
+	return d
+}
+
+func (c *cells) optInt(column string) *int {
+	value, ok := c.fields[column]
+	if !ok {
+		return nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		c.fail(column, errors.New("not a whole number"))
+		return nil
+	}
+	return &i
+}
+
+func (c *cells) optBool(column string) *bool {
+	value, ok := c.fields[column]
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		c.fail(column, errors.New("not true or false"))
+		return nil
+	}
+	return &b
+}
+
+// optTime accepts RFC 3339 timestamps or dates (midnight UTC)
+func (c *cells) optTime(column string) *time.Time {
+	value, ok := c.fields[column]
+	if !ok {
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+	c.fail(column, errors.New("not an RFC 3339 timestamp or YYYY-MM-DD date"))
+	return nil
+}
+
+// stringList reads a cell holding a JSON array of strings, such as plan
+// marketing bullets
+func (c *cells) stringList(column string) []string {
+	value, ok := c.fields[column]
+	if !ok {
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(value), &list); err != nil {
+		c.fail(column, errors.New("not a JSON array of strings"))
+		return nil
+	}
+	return list
+}
+
+// object reads a cell holding a JSON object, such as plan features or
+// subscriber metadata
+func (c *cells) object(column string) map[string]interface{} {
+	value, ok := c.fields[column]
+	if !ok {
+		return nil
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		c.fail(column, errors.New("not a JSON object"))
+		return nil
+	}
+	return object
+}
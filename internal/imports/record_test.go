@@ -0,0 +1,85 @@
+package imports
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCSV(t *testing.T) {
+	data := "name,price,currency,billing_cycle,is_active\n" +
+		"Pro,19.99,USD,monthly,true\n" +
+		"\"Team, annual\",199,USD,yearly,\n" +
+		"Broken,1\n"
+
+	records, err := parseRecords(FormatCSV, data, columns[KindPlans])
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	req, err := planRequest(records[0])
+	require.NoError(t, err)
+	assert.Equal(t, 2, records[0].line)
+	assert.Equal(t, "Pro", req.Name)
+	assert.Equal(t, "19.99", req.Price.String())
+	require.NotNil(t, req.IsActive)
+	assert.True(t, *req.IsActive)
+
+	req, err = planRequest(records[1])
+	require.NoError(t, err)
+	assert.Equal(t, "Team, annual", req.Name)
+	assert.Nil(t, req.IsActive)
+
+	assert.Equal(t, 4, records[2].line)
+	assert.True(t, errors.Is(records[2].err, errInvalidRow))
+}
+
+func TestParseCSVRejectsUnknownColumns(t *testing.T) {
+	_, err := parseRecords(FormatCSV, "name,prise\nPro,19.99\n", columns[KindPlans])
+	assert.Error(t, err)
+}
+
+func TestCSVCellErrorsAreRowErrors(t *testing.T) {
+	records, err := parseRecords(FormatCSV, "name,price,max_usage_per_day\nPro,free,10\n", columns[KindPlans])
+	require.NoError(t, err)
+
+	_, err = planRequest(records[0])
+	assert.True(t, isRowError(err))
+	assert.Contains(t, err.Error(), "price")
+}
+
+func TestParseJSONL(t *testing.T) {
+	data := `{"user_id":"u1","plan_id":"p1","end_date":"2025-01-31T00:00:00Z","external_ref":"legacy-1"}
+
+not json
+{"user_id":"u2","plan_id":"p1","externl_ref":"legacy-2"}
+`
+	records, err := parseRecords(FormatJSONL, data, columns[KindSubscribers])
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	req, err := subscriberRequest(records[0])
+	require.NoError(t, err)
+	assert.Equal(t, "legacy-1", req.ExternalRef)
+	require.NotNil(t, req.EndDate)
+
+	assert.Equal(t, 3, records[1].line)
+	assert.True(t, isRowError(records[1].err))
+
+	_, err = subscriberRequest(records[2])
+	assert.True(t, isRowError(err), "unknown fields are refused")
+}
+
+func TestSubscriberCSVDates(t *testing.T) {
+	data := "user_id,plan_id,start_date,end_date,external_ref\n" +
+		"u1,p1,2024-01-01,2024-02-01T12:00:00Z,legacy-1\n"
+	records, err := parseRecords(FormatCSV, data, columns[KindSubscribers])
+	require.NoError(t, err)
+
+	req, err := subscriberRequest(records[0])
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-01", req.StartDate.Format("2006-01-02"))
+	assert.Equal(t, 12, req.EndDate.Hour())
+	assert.Nil(t, req.Amount)
+}
@@ -0,0 +1,383 @@
+package imports
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/jobs"
+	"scalable-paywall/internal/plan"
+	"scalable-paywall/internal/subscription"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// What an import creates
+const (
+	KindPlans       = "plans"
+	KindSubscribers = "subscribers"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// Import states. A failed run is retried by the job runner and resumes
+// after the last row it processed.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+)
+
+// JobProcess processes one uploaded import.
+const JobProcess = "imports.process"
+
+// Service runs bulk imports of plans and of subscribers migrated from a
+// legacy system. Files are stored on upload and processed row by row by a
+// background job; rows that fail validation are kept for the error report
+// and don't stop the rest of the file.
+type Service struct {
+	db            *db.Connection
+	queue         *jobs.Queue
+	plans         *plan.Service
+	subscriptions *subscription.Service
+}
+
+type Import struct {
+	ID            string     `json:"id" db:"id"`
+	Kind          string     `json:"kind" db:"kind"`
+	Format        string     `json:"format" db:"format"`
+	Status        string     `json:"status" db:"status"`
+	TotalRows     int        `json:"total_rows" db:"total_rows"`
+	ProcessedRows int        `json:"processed_rows" db:"processed_rows"`
+	ImportedRows  int        `json:"imported_rows" db:"imported_rows"`
+	FailedRows    int        `json:"failed_rows" db:"failed_rows"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+type processPayload struct {
+	ImportID string `json:"import_id"`
+}
+
+func NewService(db *db.Connection, queue *jobs.Queue, plans *plan.Service, subscriptions *subscription.Service) *Service {
+	return &Service{db: db, queue: queue, plans: plans, subscriptions: subscriptions}
+}
+
+// RegisterJobs processes uploaded imports on runner.
+func (s *Service) RegisterJobs(runner *jobs.Runner) {
+	runner.Handle(JobProcess, func(ctx context.Context, job *jobs.Job) error {
+		var payload processPayload
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+		return s.process(ctx, payload.ImportID)
+	})
+}
+
+// ImportPlans accepts a CSV or JSONL file of plans to create.
+func (s *Service) ImportPlans(c *gin.Context) {
+	s.startImport(c, KindPlans)
+}
+
+// ImportSubscribers accepts a CSV or JSONL file of subscribers to migrate.
+func (s *Service) ImportSubscribers(c *gin.Context) {
+	s.startImport(c, KindSubscribers)
+}
+
+// startImport stores the uploaded file and queues it, answering 202 with
+// the import to poll. Files that can't be read at all are rejected here;
+// problems with single rows are only found while processing.
+func (s *Service) startImport(c *gin.Context, kind string) {
+	format := importFormat(c)
+	if format == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Send text/csv or application/x-ndjson, or set format to csv or jsonl"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	data := string(body)
+	records, err := parseRecords(format, data, columns[kind])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s file: %v", format, err)})
+		return
+	}
+	if len(records) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File has no rows"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	imp := &Import{
+		ID:        uuid.New().String(),
+		Kind:      kind,
+		Format:    format,
+		Status:    StatusQueued,
+		TotalRows: len(records),
+	}
+	if err := s.createImport(ctx, imp, data); err != nil {
+		logrus.Errorf("Failed to create import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	// Queued after the insert so the job always finds the import
+	if _, err := s.queue.Enqueue(ctx, JobProcess, processPayload{ImportID: imp.ID}, jobs.EnqueueOptions{}); err != nil {
+		logrus.Errorf("Failed to queue import %s: %v", imp.ID, err)
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM imports WHERE id = $1`, imp.ID); err != nil {
+			logrus.Errorf("Failed to remove unqueued import %s: %v", imp.ID, err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	logrus.Infof("Queued %s import %s with %d rows", kind, imp.ID, imp.TotalRows)
+
+	c.JSON(http.StatusAccepted, imp)
+}
+
+// importFormat takes the format from the format query parameter or else
+// the Content-Type. It returns "" when neither names a supported format.
+func importFormat(c *gin.Context) string {
+	switch c.Query("format") {
+	case FormatCSV, FormatJSONL:
+		return c.Query("format")
+	case "":
+	default:
+		return ""
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return FormatCSV
+	case "application/x-ndjson", "application/jsonl":
+		return FormatJSONL
+	}
+	return ""
+}
+
+// GetImport reports an import's status and row counts.
+func (s *Service) GetImport(c *gin.Context) {
+	imp, err := s.getImport(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+			return
+		}
+		logrus.Errorf("Failed to get import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, imp)
+}
+
+// GetImportErrors downloads the rows that failed so far as CSV, one line
+// per row with its line number in the uploaded file.
+func (s *Service) GetImportErrors(c *gin.Context) {
+	ctx := c.Request.Context()
+	imp, err := s.getImport(ctx, c.Param("id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Import not found"})
+			return
+		}
+		logrus.Errorf("Failed to get import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT line, error FROM import_errors WHERE import_id = $1 ORDER BY line
+	`, imp.ID)
+	if err != nil {
+		logrus.Errorf("Failed to get import errors: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%s-errors.csv"`, imp.ID))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"line", "error"})
+	for rows.Next() {
+		var line int
+		var message string
+		if err := rows.Scan(&line, &message); err != nil {
+			logrus.Errorf("Failed to read import error: %v", err)
+			break
+		}
+		w.Write([]string{strconv.Itoa(line), message})
+	}
+	if err := rows.Err(); err != nil {
+		logrus.Errorf("Failed to read import errors: %v", err)
+	}
+	w.Flush()
+}
+
+// process imports the rows not yet processed. A row that fails validation
+// is recorded and skipped; any other error stops the run so the job is
+// retried from that row.
+func (s *Service) process(ctx context.Context, id string) error {
+	imp, data, err := s.getImportData(ctx, id)
+	if err == sql.ErrNoRows {
+		logrus.Warnf("Import %s no longer exists", id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if imp.Status == StatusCompleted {
+		return nil
+	}
+
+	records, err := parseRecords(imp.Format, data, columns[imp.Kind])
+	if err != nil {
+		return fmt.Errorf("import %s can no longer be parsed: %w", id, err)
+	}
+	if imp.ProcessedRows > len(records) {
+		return fmt.Errorf("import %s processed %d of %d rows", id, imp.ProcessedRows, len(records))
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE imports SET status = 'running', updated_at = NOW() WHERE id = $1
+	`, id); err != nil {
+		return err
+	}
+
+	for _, rec := range records[imp.ProcessedRows:] {
+		rowErr := s.importRecord(ctx, imp.Kind, rec)
+		if rowErr != nil && !isRowError(rowErr) {
+			return rowErr
+		}
+		if err := s.recordRow(ctx, id, rec.line, rowErr); err != nil {
+			return err
+		}
+	}
+
+	if err := s.db.QueryRowContext(ctx, `
+		UPDATE imports SET status = 'completed', finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+		RETURNING imported_rows, failed_rows
+	`, id).Scan(&imp.ImportedRows, &imp.FailedRows); err != nil {
+		return err
+	}
+	logrus.Infof("Finished %s import %s: %d imported, %d failed", imp.Kind, id, imp.ImportedRows, imp.FailedRows)
+	return nil
+}
+
+func (s *Service) importRecord(ctx context.Context, kind string, rec record) error {
+	if rec.err != nil {
+		return rec.err
+	}
+
+	switch kind {
+	case KindPlans:
+		req, err := planRequest(rec)
+		if err != nil {
+			return err
+		}
+		_, err = s.plans.ImportPlan(ctx, req)
+		return err
+	case KindSubscribers:
+		req, err := subscriberRequest(rec)
+		if err != nil {
+			return err
+		}
+		_, err = s.subscriptions.ImportSubscription(ctx, req)
+		return err
+	}
+	return fmt.Errorf("unknown import kind %q", kind)
+}
+
+// isRowError reports whether err is a problem with the row rather than with
+// the database or the run
+func isRowError(err error) bool {
+	return errors.Is(err, errInvalidRow) ||
+		errors.Is(err, plan.ErrInvalidPlanData) ||
+		errors.Is(err, plan.ErrPlanNameExists) ||
+		errors.Is(err, subscription.ErrInvalidImport) ||
+		errors.Is(err, subscription.ErrAlreadySubscribed)
+}
+
+// recordRow counts a processed row and stores its error, if any, in one
+// statement, so a retried run resumes after it.
+func (s *Service) recordRow(ctx context.Context, id string, line int, rowErr error) error {
+	var message *string
+	if rowErr != nil {
+		text := rowErr.Error()
+		message = &text
+	}
+	_, err := s.db.ExecContext(ctx, `
+		WITH failure AS (
+			INSERT INTO import_errors (import_id, line, error)
+			SELECT $1, $2, $3::text WHERE $3::text IS NOT NULL
+			ON CONFLICT (import_id, line) DO NOTHING
+		)
+		UPDATE imports
+		SET processed_rows = processed_rows + 1,
+			imported_rows = imported_rows + CASE WHEN $3::text IS NULL THEN 1 ELSE 0 END,
+			failed_rows = failed_rows + CASE WHEN $3::text IS NULL THEN 0 ELSE 1 END,
+			updated_at = NOW()
+		WHERE id = $1
+	`, id, line, message)
+	return err
+}
+
+func (s *Service) createImport(ctx context.Context, imp *Import, data string) error {
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO imports (id, kind, format, status, data, total_rows)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at, updated_at
+	`, imp.ID, imp.Kind, imp.Format, imp.Status, data, imp.TotalRows).Scan(&imp.CreatedAt, &imp.UpdatedAt)
+}
+
+// getImport reads from the primary, as does the error report: both are
+// polled while the job writes them.
+func (s *Service) getImport(ctx context.Context, id string) (*Import, error) {
+	var imp Import
+	err := s.db.QueryRowContext(ctx, `
+		SELECT `+importColumns+` FROM imports WHERE id = $1
+	`, id).Scan(&imp.ID, &imp.Kind, &imp.Format, &imp.Status, &imp.TotalRows, &imp.ProcessedRows,
+		&imp.ImportedRows, &imp.FailedRows, &imp.CreatedAt, &imp.UpdatedAt, &imp.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &imp, nil
+}
+
+func (s *Service) getImportData(ctx context.Context, id string) (*Import, string, error) {
+	var imp Import
+	var data string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT `+importColumns+`, data FROM imports WHERE id = $1
+	`, id).Scan(&imp.ID, &imp.Kind, &imp.Format, &imp.Status, &imp.TotalRows, &imp.ProcessedRows,
+		&imp.ImportedRows, &imp.FailedRows, &imp.CreatedAt, &imp.UpdatedAt, &imp.FinishedAt, &data)
+	if err != nil {
+		return nil, "", err
+	}
+	return &imp, data, nil
+}
+
+// importColumns lists the columns of Import in scan order
+const importColumns = `id, kind, format, status, total_rows, processed_rows, imported_rows,
+	failed_rows, created_at, updated_at, finished_at`
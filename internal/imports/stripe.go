@@ -0,0 +1,526 @@
+package imports
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/money"
+	"scalable-paywall/internal/plan"
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/user"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stripeAPIVersion pins the shape of the objects read, so a change of the
+// account's default version can't break a migration
+const stripeAPIVersion = "2024-06-20"
+
+// stripePageSize is how many subscriptions each list request reads
+const stripePageSize = 100
+
+// errUnmigratable marks a Stripe subscription that can't be migrated as
+// it is, reported without stopping the others
+var errUnmigratable = errors.New("can't migrate")
+
+// StripeMigration moves the customers and subscriptions of a Stripe Billing
+// account into users and subscriptions. Each subscription's price is mapped
+// to a plan by configuration, or a plan is created from its product, and
+// each customer becomes the user with their email. Subscriptions keep their
+// Stripe ID as external_ref, so running the migration again only picks up
+// those created since.
+type StripeMigration struct {
+	cfg           config.StripeImportConfig
+	client        *http.Client
+	db            *db.Connection
+	plans         *plan.Service
+	users         *user.Service
+	subscriptions *subscription.Service
+
+	// planMap is cfg.Plans with lowercased keys
+	planMap  map[string]string
+	products map[string]*stripeProduct
+}
+
+// StripeReport reconciles a migration run: every Stripe subscription read
+// was imported, found imported already, skipped for its status or failed.
+type StripeReport struct {
+	Subscriptions   int             `json:"subscriptions"`
+	Imported        int             `json:"imported"`
+	AlreadyImported int             `json:"already_imported"`
+	Skipped         int             `json:"skipped"`
+	Failed          int             `json:"failed"`
+	UsersCreated    int             `json:"users_created"`
+	PlansCreated    int             `json:"plans_created"`
+	Failures        []StripeFailure `json:"failures"`
+}
+
+// StripeFailure is a Stripe subscription left behind and why
+type StripeFailure struct {
+	SubscriptionID string `json:"subscription_id"`
+	CustomerID     string `json:"customer_id"`
+	Error          string `json:"error"`
+}
+
+type stripeSubscription struct {
+	ID                   string         `json:"id"`
+	Status               string         `json:"status"`
+	Customer             stripeCustomer `json:"customer"`
+	StartDate            int64          `json:"start_date"`
+	BillingCycleAnchor   int64          `json:"billing_cycle_anchor"`
+	CurrentPeriodEnd     int64          `json:"current_period_end"`
+	CancelAtPeriodEnd    bool           `json:"cancel_at_period_end"`
+	EndedAt              *int64         `json:"ended_at"`
+	DefaultPaymentMethod *string        `json:"default_payment_method"`
+	Items                struct {
+		Data []stripeItem `json:"data"`
+	} `json:"items"`
+}
+
+type stripeItem struct {
+	Price    stripePrice `json:"price"`
+	Quantity int64       `json:"quantity"`
+}
+
+type stripePrice struct {
+	ID         string           `json:"id"`
+	Product    string           `json:"product"`
+	Nickname   *string          `json:"nickname"`
+	Currency   string           `json:"currency"`
+	UnitAmount *int64           `json:"unit_amount"`
+	Recurring  *stripeRecurring `json:"recurring"`
+}
+
+type stripeRecurring struct {
+	Interval      string `json:"interval"`
+	IntervalCount int    `json:"interval_count"`
+}
+
+type stripeCustomer struct {
+	ID               string   `json:"id"`
+	Email            *string  `json:"email"`
+	Deleted          bool     `json:"deleted"`
+	PreferredLocales []string `json:"preferred_locales"`
+	Address          *struct {
+		Country *string `json:"country"`
+	} `json:"address"`
+}
+
+type stripeProduct struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+}
+
+// stripeCycles are the plan billing cycles of Stripe's price intervals
+var stripeCycles = map[string]string{
+	"day":   "daily",
+	"week":  "weekly",
+	"month": "monthly",
+	"year":  "yearly",
+}
+
+func NewStripeMigration(cfg config.StripeImportConfig, db *db.Connection, plans *plan.Service, users *user.Service, subscriptions *subscription.Service) *StripeMigration {
+	planMap := make(map[string]string, len(cfg.Plans))
+	for stripeID, planID := range cfg.Plans {
+		planMap[strings.ToLower(stripeID)] = planID
+	}
+	return &StripeMigration{
+		cfg:           cfg,
+		client:        &http.Client{Timeout: 30 * time.Second},
+		db:            db,
+		plans:         plans,
+		users:         users,
+		subscriptions: subscriptions,
+		planMap:       planMap,
+		products:      map[string]*stripeProduct{},
+	}
+}
+
+// Run migrates every subscription of the Stripe account, page by page. A
+// subscription that can't be migrated is reported and the run carries on;
+// a failure of Stripe or the database stops it, with the report so far.
+func (m *StripeMigration) Run(ctx context.Context) (*StripeReport, error) {
+	if m.cfg.APIKey == "" {
+		return nil, errors.New("imports.stripe.api_key is not set")
+	}
+
+	report := &StripeReport{Failures: []StripeFailure{}}
+	query := url.Values{}
+	query.Set("status", "all")
+	query.Set("limit", fmt.Sprint(stripePageSize))
+	query.Add("expand[]", "data.customer")
+	for {
+		var page struct {
+			Data    []stripeSubscription `json:"data"`
+			HasMore bool                 `json:"has_more"`
+		}
+		if err := m.get(ctx, "/v1/subscriptions", query, &page); err != nil {
+			return report, fmt.Errorf("failed to list Stripe subscriptions: %w", err)
+		}
+
+		for _, sub := range page.Data {
+			report.Subscriptions++
+			err := m.migrate(ctx, sub, report)
+			if err == nil {
+				continue
+			}
+			if !isStripeRowError(err) {
+				return report, fmt.Errorf("failed to migrate Stripe subscription %s: %w", sub.ID, err)
+			}
+			report.Failed++
+			report.Failures = append(report.Failures, StripeFailure{
+				SubscriptionID: sub.ID,
+				CustomerID:     sub.Customer.ID,
+				Error:          err.Error(),
+			})
+		}
+
+		if !page.HasMore || len(page.Data) == 0 {
+			break
+		}
+		query.Set("starting_after", page.Data[len(page.Data)-1].ID)
+	}
+
+	logrus.Infof("Migrated Stripe subscriptions: %d imported, %d already imported, %d skipped, %d failed",
+		report.Imported, report.AlreadyImported, report.Skipped, report.Failed)
+	return report, nil
+}
+
+// migrate imports one Stripe subscription, counting the outcome in report
+func (m *StripeMigration) migrate(ctx context.Context, sub stripeSubscription, report *StripeReport) error {
+	status, ok := stripeStatus(sub.Status, m.cfg.IncludeCancelled)
+	if !ok {
+		report.Skipped++
+		return nil
+	}
+
+	var imported bool
+	if err := m.db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM subscriptions WHERE external_ref = $1)
+	`, sub.ID).Scan(&imported); err != nil {
+		return err
+	}
+	if imported {
+		report.AlreadyImported++
+		return nil
+	}
+
+	if len(sub.Items.Data) != 1 {
+		return fmt.Errorf("%w: subscription has %d items, only single-price subscriptions are supported",
+			errUnmigratable, len(sub.Items.Data))
+	}
+	price := sub.Items.Data[0].Price
+	planID, err := m.planFor(ctx, price, report)
+	if err != nil {
+		return err
+	}
+	userID, err := m.userFor(ctx, sub.Customer, report)
+	if err != nil {
+		return err
+	}
+
+	req, err := stripeSubscriptionRequest(sub, status, userID, planID)
+	if err != nil {
+		return err
+	}
+	if _, err := m.subscriptions.ImportSubscription(ctx, req); err != nil {
+		return err
+	}
+	report.Imported++
+	return nil
+}
+
+// stripeStatus is the status a subscription in a Stripe status migrates
+// with. Incomplete subscriptions never started and aren't migrated;
+// cancelled ones only when includeCancelled.
+func stripeStatus(status string, includeCancelled bool) (string, bool) {
+	switch status {
+	case "active", "trialing":
+		return "active", true
+	case "past_due":
+		return "past_due", true
+	case "unpaid", "paused":
+		return "suspended", true
+	case "canceled":
+		return "cancelled", includeCancelled
+	}
+	return "", false
+}
+
+// stripeSubscriptionRequest imports sub for userID on planID. The current
+// period ends where Stripe's does, and monthly and yearly subscriptions
+// keep renewing on the day of Stripe's billing cycle anchor. The amount is
+// the price times the quantity.
+func stripeSubscriptionRequest(sub stripeSubscription, status, userID, planID string) (subscription.ImportSubscriptionRequest, error) {
+	item := sub.Items.Data[0]
+	price := item.Price
+	if price.UnitAmount == nil {
+		return subscription.ImportSubscriptionRequest{}, fmt.Errorf("%w: price %s has no unit amount", errUnmigratable, price.ID)
+	}
+	quantity := item.Quantity
+	if quantity < 1 {
+		quantity = 1
+	}
+	currency := strings.ToUpper(price.Currency)
+	amount := money.FromMinor(*price.UnitAmount*quantity, currency)
+
+	start := time.Unix(sub.StartDate, 0).UTC()
+	end := time.Unix(sub.CurrentPeriodEnd, 0).UTC()
+	if status == "cancelled" && sub.EndedAt != nil {
+		end = time.Unix(*sub.EndedAt, 0).UTC()
+	}
+
+	req := subscription.ImportSubscriptionRequest{
+		UserID:      userID,
+		PlanID:      planID,
+		Status:      status,
+		StartDate:   &start,
+		EndDate:     &end,
+		AutoRenew:   status != "cancelled" && !sub.CancelAtPeriodEnd,
+		Amount:      &amount,
+		Currency:    currency,
+		ExternalRef: sub.ID,
+		Metadata: map[string]interface{}{
+			"stripe_customer_id": sub.Customer.ID,
+			"stripe_price_id":    price.ID,
+		},
+	}
+	if sub.DefaultPaymentMethod != nil {
+		req.PaymentMethod = *sub.DefaultPaymentMethod
+	}
+	if price.Recurring != nil && (price.Recurring.Interval == "month" || price.Recurring.Interval == "year") {
+		anchorDay := time.Unix(sub.BillingCycleAnchor, 0).UTC().Day()
+		req.BillingAnchorDay = &anchorDay
+	}
+	return req, nil
+}
+
+// planFor finds the plan subscribers of price move to: the one configured
+// for the price or its product, else the one created for it by an earlier
+// run. Without either the plan is created, when allowed.
+func (m *StripeMigration) planFor(ctx context.Context, price stripePrice, report *StripeReport) (string, error) {
+	if planID, ok := m.planMap[strings.ToLower(price.ID)]; ok {
+		return planID, nil
+	}
+	if planID, ok := m.planMap[strings.ToLower(price.Product)]; ok {
+		return planID, nil
+	}
+
+	var planID string
+	err := m.db.QueryRowContext(ctx, `SELECT plan_id FROM stripe_prices WHERE price_id = $1`, price.ID).Scan(&planID)
+	if err == nil {
+		return planID, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+	if !m.cfg.CreatePlans {
+		return "", fmt.Errorf("%w: neither price %s nor product %s is mapped to a plan in imports.stripe.plans",
+			errUnmigratable, price.ID, price.Product)
+	}
+
+	product, err := m.product(ctx, price.Product)
+	if err != nil {
+		return "", err
+	}
+	req, err := stripePlanRequest(price, product)
+	if err != nil {
+		return "", err
+	}
+	created, err := m.plans.ImportPlan(ctx, req)
+	if errors.Is(err, plan.ErrPlanNameExists) {
+		// Another price of the product took the name
+		req.Name = fmt.Sprintf("%s (%s)", req.Name, price.ID)
+		created, err = m.plans.ImportPlan(ctx, req)
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := m.db.ExecContext(ctx, `
+		INSERT INTO stripe_prices (price_id, plan_id) VALUES ($1, $2)
+	`, price.ID, created.ID); err != nil {
+		return "", err
+	}
+	report.PlansCreated++
+	logrus.Infof("Created plan %s for Stripe price %s", created.ID, price.ID)
+	return created.ID, nil
+}
+
+// stripePlanRequest is a plan selling product at price: named after the
+// product and the price's nickname, billed on its interval
+func stripePlanRequest(price stripePrice, product *stripeProduct) (plan.CreatePlanRequest, error) {
+	if price.Recurring == nil {
+		return plan.CreatePlanRequest{}, fmt.Errorf("%w: price %s is not recurring", errUnmigratable, price.ID)
+	}
+	cycle, ok := stripeCycles[price.Recurring.Interval]
+	if !ok {
+		return plan.CreatePlanRequest{}, fmt.Errorf("%w: price %s has unknown interval %q",
+			errUnmigratable, price.ID, price.Recurring.Interval)
+	}
+	if price.UnitAmount == nil {
+		return plan.CreatePlanRequest{}, fmt.Errorf("%w: price %s has no unit amount", errUnmigratable, price.ID)
+	}
+
+	currency := strings.ToUpper(price.Currency)
+	name := product.Name
+	if price.Nickname != nil && *price.Nickname != "" {
+		name = fmt.Sprintf("%s (%s)", name, *price.Nickname)
+	}
+	interval := price.Recurring.IntervalCount
+	return plan.CreatePlanRequest{
+		Name:            name,
+		Description:     product.Description,
+		Price:           money.FromMinor(*price.UnitAmount, currency),
+		Currency:        currency,
+		BillingCycle:    cycle,
+		BillingInterval: &interval,
+	}, nil
+}
+
+// product reads a Stripe product once per run
+func (m *StripeMigration) product(ctx context.Context, id string) (*stripeProduct, error) {
+	if product, ok := m.products[id]; ok {
+		return product, nil
+	}
+	var product stripeProduct
+	if err := m.get(ctx, "/v1/products/"+url.PathEscape(id), nil, &product); err != nil {
+		return nil, fmt.Errorf("failed to read Stripe product %s: %w", id, err)
+	}
+	m.products[id] = &product
+	return &product, nil
+}
+
+// userFor finds the user customer became in an earlier run, else the user
+// with their email, else creates one
+func (m *StripeMigration) userFor(ctx context.Context, customer stripeCustomer, report *StripeReport) (string, error) {
+	var userID string
+	err := m.db.QueryRowContext(ctx, `SELECT user_id FROM stripe_customers WHERE customer_id = $1`, customer.ID).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+	if customer.Deleted || customer.Email == nil || *customer.Email == "" {
+		return "", fmt.Errorf("%w: customer %s has no email", errUnmigratable, customer.ID)
+	}
+
+	existing, err := m.users.GetUserByEmail(ctx, *customer.Email)
+	switch {
+	case err == nil:
+		userID = existing.ID
+	case err == sql.ErrNoRows:
+		created, err := m.createUser(ctx, customer)
+		if err != nil {
+			return "", err
+		}
+		userID = created.ID
+		report.UsersCreated++
+	default:
+		return "", err
+	}
+
+	if _, err := m.db.ExecContext(ctx, `
+		INSERT INTO stripe_customers (customer_id, user_id) VALUES ($1, $2)
+	`, customer.ID, userID); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// createUser creates the user of a customer, named after their email; a
+// taken username gets the customer ID appended
+func (m *StripeMigration) createUser(ctx context.Context, customer stripeCustomer) (*user.User, error) {
+	req := user.CreateUserRequest{
+		Email:    *customer.Email,
+		Username: stripeUsername(*customer.Email, ""),
+		Metadata: map[string]interface{}{"stripe_customer_id": customer.ID},
+	}
+	if customer.Address != nil && customer.Address.Country != nil && *customer.Address.Country != "" {
+		req.Country = customer.Address.Country
+	}
+	if len(customer.PreferredLocales) > 0 {
+		req.Locale = &customer.PreferredLocales[0]
+	}
+
+	created, err := m.users.ImportUser(ctx, req)
+	if errors.Is(err, user.ErrUsernameTaken) {
+		req.Username = stripeUsername(*customer.Email, customer.ID)
+		created, err = m.users.ImportUser(ctx, req)
+	}
+	return created, err
+}
+
+// stripeUsername is the local part of email, with suffix after a dash when
+// set, fitted to the 3 to 50 characters usernames take
+func stripeUsername(email, suffix string) string {
+	name := email
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		name = email[:at]
+	}
+	if suffix != "" {
+		suffix = "-" + suffix
+	}
+	if limit := 50 - len(suffix); len(name) > limit {
+		name = name[:limit]
+	}
+	name += suffix
+	for len(name) < 3 {
+		name += "_"
+	}
+	return name
+}
+
+// isStripeRowError reports whether err is a problem with one subscription
+// rather than with Stripe or the database
+func isStripeRowError(err error) bool {
+	return errors.Is(err, errUnmigratable) ||
+		isRowError(err) ||
+		errors.Is(err, user.ErrInvalidUser) ||
+		errors.Is(err, user.ErrEmailTaken) ||
+		errors.Is(err, user.ErrUsernameTaken)
+}
+
+// get reads a Stripe API resource into out
+func (m *StripeMigration) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	target := strings.TrimRight(m.cfg.APIURL, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.cfg.APIKey)
+	req.Header.Set("Stripe-Version", stripeAPIVersion)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(detail, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("Stripe returned %d: %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return fmt.Errorf("Stripe returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
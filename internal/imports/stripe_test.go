@@ -0,0 +1,151 @@
+package imports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stripeFixture(t *testing.T, data string) stripeSubscription {
+	t.Helper()
+	var sub stripeSubscription
+	require.NoError(t, json.Unmarshal([]byte(data), &sub))
+	return sub
+}
+
+func TestStripeStatus(t *testing.T) {
+	for stripe, want := range map[string]string{
+		"active":   "active",
+		"trialing": "active",
+		"past_due": "past_due",
+		"unpaid":   "suspended",
+		"paused":   "suspended",
+	} {
+		status, ok := stripeStatus(stripe, false)
+		assert.True(t, ok, stripe)
+		assert.Equal(t, want, status, stripe)
+	}
+
+	_, ok := stripeStatus("canceled", false)
+	assert.False(t, ok)
+	status, ok := stripeStatus("canceled", true)
+	assert.True(t, ok)
+	assert.Equal(t, "cancelled", status)
+
+	_, ok = stripeStatus("incomplete_expired", true)
+	assert.False(t, ok)
+}
+
+func TestStripeSubscriptionRequest(t *testing.T) {
+	sub := stripeFixture(t, `{
+		"id": "sub_1NqX", "status": "active",
+		"customer": {"id": "cus_Pq1", "email": "jane@example.com"},
+		"start_date": 1767261600, "billing_cycle_anchor": 1767261600, "current_period_end": 1775124000,
+		"cancel_at_period_end": false, "default_payment_method": "pm_1NqXcard",
+		"items": {"data": [{"quantity": 3, "price": {
+			"id": "price_Team", "product": "prod_Team", "currency": "eur", "unit_amount": 1250,
+			"recurring": {"interval": "month", "interval_count": 1}
+		}}]}
+	}`)
+
+	req, err := stripeSubscriptionRequest(sub, "active", "user-1", "plan-1")
+	require.NoError(t, err)
+	assert.Equal(t, "sub_1NqX", req.ExternalRef)
+	assert.Equal(t, "active", req.Status)
+	assert.True(t, req.AutoRenew)
+	assert.Equal(t, "pm_1NqXcard", req.PaymentMethod)
+	assert.Equal(t, "EUR", req.Currency)
+	assert.Equal(t, "37.5", req.Amount.String())
+	assert.Equal(t, time.Date(2026, time.January, 1, 10, 0, 0, 0, time.UTC), *req.StartDate)
+	assert.Equal(t, time.Date(2026, time.April, 2, 10, 0, 0, 0, time.UTC), *req.EndDate)
+	require.NotNil(t, req.BillingAnchorDay)
+	assert.Equal(t, 1, *req.BillingAnchorDay)
+	assert.Equal(t, "cus_Pq1", req.Metadata["stripe_customer_id"])
+
+	sub.Status, sub.CancelAtPeriodEnd = "canceled", true
+	ended := int64(1770000000)
+	sub.EndedAt = &ended
+	req, err = stripeSubscriptionRequest(sub, "cancelled", "user-1", "plan-1")
+	require.NoError(t, err)
+	assert.False(t, req.AutoRenew)
+	assert.Equal(t, time.Unix(ended, 0).UTC(), *req.EndDate)
+
+	sub.Items.Data[0].Price.Recurring.Interval = "week"
+	req, err = stripeSubscriptionRequest(sub, "active", "user-1", "plan-1")
+	require.NoError(t, err)
+	assert.Nil(t, req.BillingAnchorDay)
+
+	sub.Items.Data[0].Price.UnitAmount = nil
+	_, err = stripeSubscriptionRequest(sub, "active", "user-1", "plan-1")
+	assert.True(t, errors.Is(err, errUnmigratable))
+}
+
+func TestStripePlanRequest(t *testing.T) {
+	amount := int64(19900)
+	nickname := "Annual"
+	description := "For growing teams"
+	price := stripePrice{ID: "price_TeamYear", Product: "prod_Team", Nickname: &nickname, Currency: "usd", UnitAmount: &amount}
+	product := &stripeProduct{ID: "prod_Team", Name: "Team", Description: &description}
+
+	_, err := stripePlanRequest(price, product)
+	assert.True(t, errors.Is(err, errUnmigratable))
+
+	price.Recurring = &stripeRecurring{Interval: "year", IntervalCount: 1}
+	req, err := stripePlanRequest(price, product)
+	require.NoError(t, err)
+	assert.Equal(t, "Team (Annual)", req.Name)
+	assert.Equal(t, &description, req.Description)
+	assert.Equal(t, "199", req.Price.String())
+	assert.Equal(t, "USD", req.Currency)
+	assert.Equal(t, "yearly", req.BillingCycle)
+	require.NotNil(t, req.BillingInterval)
+	assert.Equal(t, 1, *req.BillingInterval)
+}
+
+func TestStripeUsername(t *testing.T) {
+	assert.Equal(t, "jane.doe", stripeUsername("jane.doe@example.com", ""))
+	assert.Equal(t, "jane.doe-cus_Pq1", stripeUsername("jane.doe@example.com", "cus_Pq1"))
+	assert.Equal(t, "jo_", stripeUsername("jo@example.com", ""))
+	long := stripeUsername("a123456789b123456789c123456789d123456789e123456789f@example.com", "cus_Pq1")
+	assert.Len(t, long, 50)
+	assert.Equal(t, "-cus_Pq1", long[42:])
+}
+
+func TestStripeGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer rk_test_123", r.Header.Get("Authorization"))
+		assert.Equal(t, stripeAPIVersion, r.Header.Get("Stripe-Version"))
+		switch r.URL.Path {
+		case "/v1/products/prod_Team":
+			w.Write([]byte(`{"id": "prod_Team", "name": "Team"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "No such product: 'prod_Gone'"}}`))
+		}
+	}))
+	defer server.Close()
+
+	m := NewStripeMigration(config.StripeImportConfig{APIURL: server.URL + "/", APIKey: "rk_test_123"}, nil, nil, nil, nil)
+	product, err := m.product(context.Background(), "prod_Team")
+	require.NoError(t, err)
+	assert.Equal(t, "Team", product.Name)
+
+	_, err = m.product(context.Background(), "prod_Gone")
+	assert.EqualError(t, err, "failed to read Stripe product prod_Gone: Stripe returned 404: No such product: 'prod_Gone'")
+}
+
+func TestStripeMigrationMatchesPlanMapRegardlessOfCase(t *testing.T) {
+	m := NewStripeMigration(config.StripeImportConfig{Plans: map[string]string{"prod_team": "plan-1"}}, nil, nil, nil, nil)
+	planID, err := m.planFor(context.Background(), stripePrice{ID: "price_TeamYear", Product: "prod_Team"}, &StripeReport{})
+	require.NoError(t, err)
+	assert.Equal(t, "plan-1", planID)
+}
@@ -0,0 +1,90 @@
+package jobs
+
+import (
+	"errors"
+	"net/http"
+
+	"scalable-paywall/internal/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type JobListResponse struct {
+	Jobs       []Job  `json:"jobs"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+var validStatuses = map[string]bool{
+	"":              true,
+	StatusQueued:    true,
+	StatusRunning:   true,
+	StatusSucceeded: true,
+	StatusDead:      true,
+}
+
+// ListJobs returns jobs newest first using cursor pagination. Optional
+// filters: status, kind. status=dead is the dead-letter list.
+func (q *Queue) ListJobs(c *gin.Context) {
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+
+	status := c.Query("status")
+	if !validStatuses[status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	cursor, err := db.ParseCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	jobs, nextCursor, err := q.List(c.Request.Context(), status, c.Query("kind"), cursor, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, JobListResponse{
+		Jobs:       jobs,
+		Limit:      limit,
+		NextCursor: nextCursor,
+	})
+}
+
+func (q *Queue) GetJob(c *gin.Context) {
+	job, err := q.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		logrus.Errorf("Failed to get job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// RetryJob requeues a dead job with a fresh attempt budget.
+func (q *Queue) RetryJob(c *gin.Context) {
+	job, err := q.Retry(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrJobNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		case errors.Is(err, ErrNotDead):
+			c.JSON(http.StatusConflict, gin.H{"error": "Only dead jobs can be retried"})
+		default:
+			logrus.Errorf("Failed to retry job: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,88 @@
+//go:build integration
+
+package jobs_test
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/jobs"
+	testenv "scalable-paywall/internal/testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var env *testenv.Env
+
+func TestMain(m *testing.M) { os.Exit(testenv.Main(m, &env)) }
+
+var jobsConfig = config.JobsConfig{Workers: 2, PollInterval: 1, LockTimeout: 60, MaxAttempts: 3, RetryBackoff: 30, RetentionDays: 7}
+
+func TestShutdownRecordsInterruptedRun(t *testing.T) {
+	env.Reset(t)
+	queue := jobs.NewQueue(env.DB, jobsConfig.MaxAttempts)
+	runner := jobs.NewRunner(queue, jobsConfig)
+	started := make(chan struct{})
+	runner.Handle("test.block", func(ctx context.Context, job *jobs.Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	id, err := queue.Enqueue(context.Background(), "test.block", struct{}{}, jobs.EnqueueOptions{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runner.Run(ctx)
+		close(done)
+	}()
+	<-started
+	cancel()
+	<-done
+
+	// The failure is recorded although the runner's context is gone, and
+	// the attempt was counted when the job was claimed
+	job, err := queue.Get(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.StatusQueued, job.Status)
+	assert.Equal(t, 1, job.Attempts)
+	require.NotNil(t, job.LastError)
+	assert.Contains(t, *job.LastError, "context canceled")
+}
+
+func TestExpiredLastAttemptIsDead(t *testing.T) {
+	env.Reset(t)
+	queue := jobs.NewQueue(env.DB, jobsConfig.MaxAttempts)
+	runner := jobs.NewRunner(queue, jobsConfig)
+	var runs atomic.Int32
+	runner.Handle("test.crash", func(ctx context.Context, job *jobs.Job) error {
+		runs.Add(1)
+		return nil
+	})
+	id, err := queue.Enqueue(context.Background(), "test.crash", struct{}{}, jobs.EnqueueOptions{MaxAttempts: 1})
+	require.NoError(t, err)
+
+	// Its only attempt was claimed by an instance that died mid-run
+	_, err = env.DB.Exec(`UPDATE jobs SET status = 'running', attempts = 1, locked_until = NOW() - INTERVAL '1 second' WHERE id = $1`, id)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runner.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		job, err := queue.Get(context.Background(), id)
+		return err == nil && job.Status == jobs.StatusDead
+	}, 5*time.Second, 50*time.Millisecond)
+	cancel()
+	<-done
+	assert.Zero(t, runs.Load())
+}
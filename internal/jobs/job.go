@@ -0,0 +1,66 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Job states. Jobs move queued -> running -> succeeded, or back to queued
+// for a retry after a failure; once MaxAttempts runs have failed they are
+// dead and stay in the dead-letter list until retried by an operator.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrNotDead     = errors.New("only dead jobs can be retried")
+)
+
+type Job struct {
+	ID          string          `json:"id" db:"id"`
+	Kind        string          `json:"kind" db:"kind"`
+	Payload     json.RawMessage `json:"payload" db:"payload"`
+	Status      string          `json:"status" db:"status"`
+	Attempts    int             `json:"attempts" db:"attempts"`
+	MaxAttempts int             `json:"max_attempts" db:"max_attempts"`
+	RunAt       time.Time       `json:"run_at" db:"run_at"`
+	LastError   *string         `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at" db:"updated_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+// Decode unmarshals the job payload into v.
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Handler runs one job. Returning an error schedules a retry with backoff.
+type Handler func(ctx context.Context, job *Job) error
+
+// EnqueueOptions tune a single job. Zero values mean run now with the
+// runner's default attempt limit and no de-duplication.
+type EnqueueOptions struct {
+	RunAt       time.Time
+	MaxAttempts int
+	UniqueKey   string
+}
+
+// backoff is the delay before retrying a job that has failed attempts times:
+// base doubled per attempt, capped at an hour.
+func backoff(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
@@ -0,0 +1,31 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDoublesPerAttempt(t *testing.T) {
+	base := 30 * time.Second
+
+	assert.Equal(t, 30*time.Second, backoff(base, 1))
+	assert.Equal(t, 60*time.Second, backoff(base, 2))
+	assert.Equal(t, 120*time.Second, backoff(base, 3))
+}
+
+func TestBackoffIsCappedAtAnHour(t *testing.T) {
+	assert.Equal(t, time.Hour, backoff(30*time.Second, 20))
+	assert.Equal(t, time.Hour, backoff(2*time.Hour, 1))
+}
+
+func TestDecodePayload(t *testing.T) {
+	job := &Job{Payload: []byte(`{"user_id":"u1"}`)}
+
+	var payload struct {
+		UserID string `json:"user_id"`
+	}
+	assert.NoError(t, job.Decode(&payload))
+	assert.Equal(t, "u1", payload.UserID)
+}
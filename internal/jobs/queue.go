@@ -0,0 +1,228 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"scalable-paywall/internal/db"
+)
+
+// Queue stores jobs in PostgreSQL. Runners on every instance claim due jobs
+// with FOR UPDATE SKIP LOCKED, so each job runs on one instance at a time.
+type Queue struct {
+	db          *db.Connection
+	maxAttempts int
+}
+
+func NewQueue(db *db.Connection, maxAttempts int) *Queue {
+	return &Queue{db: db, maxAttempts: maxAttempts}
+}
+
+// Enqueue adds a job of kind with payload marshalled to JSON. When
+// opts.UniqueKey is already taken the job is not added and "" is returned.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload interface{}, opts EnqueueOptions) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal %s payload: %w", kind, err)
+	}
+
+	runAt := opts.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = q.maxAttempts
+	}
+	var uniqueKey *string
+	if opts.UniqueKey != "" {
+		uniqueKey = &opts.UniqueKey
+	}
+
+	var id string
+	err = q.db.QueryRowContext(ctx, `
+		INSERT INTO jobs (kind, payload, max_attempts, run_at, unique_key)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (unique_key) DO NOTHING
+		RETURNING id
+	`, kind, string(data), maxAttempts, runAt, uniqueKey).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// Get returns a job by ID.
+func (q *Queue) Get(ctx context.Context, id string) (*Job, error) {
+	job, err := scanJob(q.db.QueryRowContext(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs WHERE id = $1
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// List returns jobs newest first, optionally filtered by status and kind.
+func (q *Queue) List(ctx context.Context, status, kind string, cursor *db.Cursor, limit int) ([]Job, string, error) {
+	after, afterID := cursor.After()
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := q.db.Reader().QueryContext(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE ($1 = '' OR status = $1)
+			AND ($2 = '' OR kind = $2)
+			AND ($3::timestamptz IS NULL OR (created_at, id::text) < ($3, $4))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5
+	`, status, kind, after, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		jobs = append(jobs, *job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	jobs, nextCursor := db.NextPage(jobs, limit, func(row Job) db.Cursor {
+		return db.Cursor{CreatedAt: row.CreatedAt, ID: row.ID}
+	})
+	return jobs, nextCursor, nil
+}
+
+// Retry moves a dead job back to the queue with a fresh attempt budget.
+func (q *Queue) Retry(ctx context.Context, id string) (*Job, error) {
+	job, err := scanJob(q.db.QueryRowContext(ctx, `
+		UPDATE jobs
+		SET status = 'queued', attempts = 0, run_at = NOW(), locked_until = NULL,
+			finished_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND status = 'dead'
+		RETURNING `+jobColumns, id))
+	if err != sql.ErrNoRows {
+		return job, err
+	}
+
+	// Distinguish a missing job from one that isn't dead
+	if _, err := q.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	return nil, ErrNotDead
+}
+
+// claim leases up to limit due jobs of the given kinds for lock. Jobs whose
+// lease expired (their runner died mid-run) are claimed again while they
+// have attempts left.
+func (q *Queue) claim(ctx context.Context, kinds []string, limit int, lock time.Duration) ([]Job, error) {
+	// The attempt is counted when a job is claimed, so a run whose instance
+	// died before recording the outcome still used one up. A job whose
+	// lease expired on its last attempt is dead rather than run again.
+	if _, err := q.db.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = 'dead', last_error = 'lease expired before the run finished', locked_until = NULL,
+			finished_at = NOW(), updated_at = NOW()
+		WHERE kind = ANY($1) AND status = 'running' AND locked_until < NOW() AND attempts >= max_attempts
+	`, kinds); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.db.QueryContext(ctx, `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1,
+			locked_until = NOW() + make_interval(secs => $3), updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE kind = ANY($1)
+				AND run_at <= NOW()
+				AND (status = 'queued' OR (status = 'running' AND locked_until < NOW() AND attempts < max_attempts))
+			ORDER BY run_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns, kinds, limit, lock.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+func (q *Queue) succeed(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = 'succeeded', locked_until = NULL, last_error = NULL,
+			finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`, id)
+	return err
+}
+
+// fail records a failed run. The job is retried at retryAt, or moved to the
+// dead-letter list when dead is set.
+func (q *Queue) fail(ctx context.Context, id string, runErr error, retryAt time.Time, dead bool) error {
+	status := StatusQueued
+	var finishedAt *time.Time
+	if dead {
+		status = StatusDead
+		now := time.Now()
+		finishedAt = &now
+	}
+	_, err := q.db.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = $2, last_error = $3, run_at = $4, locked_until = NULL,
+			finished_at = $5, updated_at = NOW()
+		WHERE id = $1
+	`, id, status, runErr.Error(), retryAt, finishedAt)
+	return err
+}
+
+// deleteSucceededBefore prunes succeeded jobs. Dead jobs are kept until an
+// operator retries them.
+func (q *Queue) deleteSucceededBefore(ctx context.Context, before time.Time) error {
+	_, err := q.db.ExecContext(ctx, `
+		DELETE FROM jobs WHERE status = 'succeeded' AND finished_at < $1
+	`, before)
+	return err
+}
+
+// jobColumns lists the columns scanJob expects, in order
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, run_at, last_error,
+	created_at, updated_at, finished_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (*Job, error) {
+	var job Job
+	var payload []byte
+	err := row.Scan(&job.ID, &job.Kind, &payload, &job.Status, &job.Attempts, &job.MaxAttempts,
+		&job.RunAt, &job.LastError, &job.CreatedAt, &job.UpdatedAt, &job.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.Payload = json.RawMessage(payload)
+	return &job, nil
+}
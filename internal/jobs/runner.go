@@ -0,0 +1,201 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Runner executes queued jobs with registered handlers and enqueues
+// periodic jobs. Every instance may run one; jobs are claimed with row locks
+// so each runs once.
+type Runner struct {
+	queue        *Queue
+	workers      int
+	pollInterval time.Duration
+	lockTimeout  time.Duration
+	retryBackoff time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	periodic []periodicJob
+}
+
+type periodicJob struct {
+	kind     string
+	interval time.Duration
+}
+
+// kindCleanup prunes finished jobs older than the configured retention.
+const kindCleanup = "jobs.cleanup"
+
+// recordTimeout bounds recording a run's outcome, which still happens when
+// the runner is shutting down
+const recordTimeout = 5 * time.Second
+
+func NewRunner(queue *Queue, cfg config.JobsConfig) *Runner {
+	r := &Runner{
+		queue:        queue,
+		workers:      cfg.Workers,
+		pollInterval: time.Duration(cfg.PollInterval) * time.Second,
+		lockTimeout:  time.Duration(cfg.LockTimeout) * time.Second,
+		retryBackoff: time.Duration(cfg.RetryBackoff) * time.Second,
+		handlers:     make(map[string]Handler),
+	}
+
+	retention := time.Duration(cfg.RetentionDays) * 24 * time.Hour
+	r.Every(kindCleanup, time.Hour, func(ctx context.Context, job *Job) error {
+		return queue.deleteSucceededBefore(ctx, time.Now().Add(-retention))
+	})
+	return r
+}
+
+// Handle registers the handler for jobs of kind.
+func (r *Runner) Handle(kind string, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[kind] = handler
+}
+
+// Every registers handler for kind and enqueues one run per interval across
+// all instances. A failed periodic run is not retried; the next interval
+// runs it again.
+func (r *Runner) Every(kind string, interval time.Duration, handler Handler) {
+	if interval <= 0 {
+		return
+	}
+	r.Handle(kind, handler)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.periodic = append(r.periodic, periodicJob{kind: kind, interval: interval})
+}
+
+// Run polls for due jobs until ctx is done, then waits for running jobs.
+func (r *Runner) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// Bounds the jobs running at once on this instance
+	slots := make(chan struct{}, r.workers)
+
+	for {
+		r.schedulePeriodic(ctx)
+
+		if free := r.workers - len(slots); free > 0 {
+			jobs, err := r.queue.claim(ctx, r.kinds(), free, r.lockTimeout)
+			if err != nil {
+				logrus.Errorf("Failed to claim jobs: %v", err)
+			}
+			for i := range jobs {
+				job := jobs[i]
+				slots <- struct{}{}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer func() { <-slots }()
+					r.execute(ctx, &job)
+				}()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) schedulePeriodic(ctx context.Context) {
+	r.mu.RLock()
+	periodic := append([]periodicJob(nil), r.periodic...)
+	r.mu.RUnlock()
+
+	now := time.Now()
+	for _, p := range periodic {
+		// One job per interval window; the unique key makes the insert a
+		// no-op on every instance but the first
+		window := now.Truncate(p.interval)
+		key := fmt.Sprintf("%s@%d", p.kind, window.Unix())
+		if _, err := r.queue.Enqueue(ctx, p.kind, struct{}{}, EnqueueOptions{
+			RunAt:       window,
+			MaxAttempts: 1,
+			UniqueKey:   key,
+		}); err != nil {
+			logrus.Errorf("Failed to schedule %s: %v", p.kind, err)
+		}
+	}
+}
+
+func (r *Runner) kinds() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kinds := make([]string, 0, len(r.handlers))
+	for kind := range r.handlers {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+func (r *Runner) execute(ctx context.Context, job *Job) {
+	r.mu.RLock()
+	handler := r.handlers[job.Kind]
+	r.mu.RUnlock()
+
+	// Stop before the lease runs out so another instance can't start the
+	// same job while this run is still going
+	runCtx, cancel := context.WithTimeout(ctx, r.lockTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := runHandler(runCtx, handler, job)
+	duration := time.Since(start)
+
+	// Record the outcome even if ctx was cancelled by a shutdown, so the job
+	// is retried on schedule rather than when its lease runs out
+	ctx, cancelRecord := context.WithTimeout(context.WithoutCancel(ctx), recordTimeout)
+	defer cancelRecord()
+
+	if err == nil {
+		if err := r.queue.succeed(ctx, job.ID); err != nil {
+			logrus.Errorf("Failed to mark job %s succeeded: %v", job.ID, err)
+		}
+		telemetry.RecordJobRun(job.Kind, StatusSucceeded, duration)
+		return
+	}
+
+	dead := job.Attempts >= job.MaxAttempts
+	retryAt := time.Now().Add(backoff(r.retryBackoff, job.Attempts))
+	if err := r.queue.fail(ctx, job.ID, err, retryAt, dead); err != nil {
+		logrus.Errorf("Failed to record failure of job %s: %v", job.ID, err)
+	}
+
+	if dead {
+		logrus.Errorf("Job %s (%s) failed permanently after %d attempts: %v", job.ID, job.Kind, job.Attempts, err)
+		telemetry.RecordJobRun(job.Kind, StatusDead, duration)
+		return
+	}
+	logrus.Warnf("Job %s (%s) failed, retrying at %s: %v", job.ID, job.Kind, retryAt.Format(time.RFC3339), err)
+	telemetry.RecordJobRun(job.Kind, "retry", duration)
+}
+
+// runHandler turns a handler panic into a job failure.
+func runHandler(ctx context.Context, handler Handler, job *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return handler(ctx, job)
+}
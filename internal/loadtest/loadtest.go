@@ -0,0 +1,321 @@
+// Package loadtest drives the paywall hot path, CheckAccess and
+// EnforcePaywall, over HTTP at a fixed request rate and reports latency
+// percentiles and the server's cache hit rates.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"scalable-paywall/internal/db"
+)
+
+// Endpoints driven by a run.
+const (
+	EndpointCheck   = "check"
+	EndpointEnforce = "enforce"
+)
+
+// enforceActions are the metered actions, in the proportion users take them
+var enforceActions = []string{"view", "view", "view", "view", "view", "view", "download", "download", "share"}
+
+// Options configure a run.
+type Options struct {
+	// BaseURL is the API root, e.g. http://localhost:8080/api/v1
+	BaseURL string
+	// RPS is the request rate to hold, across both endpoints
+	RPS int
+	// Duration is how long to send requests for
+	Duration time.Duration
+	// Concurrency caps requests in flight; requests due while it is reached
+	// are skipped and counted rather than queued, so a slow server can't
+	// make the run report latencies for a lower rate than asked
+	Concurrency int
+	// CheckRatio is the share of requests sent to CheckAccess, the rest
+	// going to EnforcePaywall
+	CheckRatio float64
+	// Contents is the number of distinct content IDs requested
+	Contents int
+	// Timeout bounds each request
+	Timeout time.Duration
+	// Seed fixes the random choice of users, content and endpoints
+	Seed int64
+}
+
+// Target is a user to send requests for, with the plan checked for them.
+type Target struct {
+	UserID string
+	PlanID string
+}
+
+// LoadTargets reads up to limit users from a seeded database, each with the
+// plan of their latest subscription, in random order. Lapsed and cancelled
+// subscriptions are kept so denials are part of the mix.
+func LoadTargets(ctx context.Context, conn *db.Connection, limit int) ([]Target, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT user_id, plan_id FROM (
+			SELECT DISTINCT ON (user_id) user_id, plan_id
+			FROM subscriptions
+			ORDER BY user_id, created_at DESC
+		) latest
+		ORDER BY random()
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []Target
+	for rows.Next() {
+		var t Target
+		if err := rows.Scan(&t.UserID, &t.PlanID); err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no subscriptions to drive requests for; seed the database first")
+	}
+	return targets, nil
+}
+
+// Runner sends a run's requests and records their outcomes.
+type Runner struct {
+	opts    Options
+	targets []Target
+	client  *http.Client
+	rand    *rand.Rand
+
+	mu      sync.Mutex
+	results map[string]*recorder
+	skipped int
+}
+
+// NewRunner creates a runner sending requests for targets.
+func NewRunner(opts Options, targets []Target) *Runner {
+	return &Runner{
+		opts:    opts,
+		targets: targets,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConns:        opts.Concurrency,
+				MaxIdleConnsPerHost: opts.Concurrency,
+			},
+		},
+		rand: rand.New(rand.NewSource(opts.Seed)),
+		results: map[string]*recorder{
+			EndpointCheck:   {},
+			EndpointEnforce: {},
+		},
+	}
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Seconds   float64                   `json:"duration_seconds"`
+	TargetRPS int                       `json:"target_rps"`
+	Skipped   int                       `json:"skipped"`
+	Endpoints map[string]EndpointReport `json:"endpoints"`
+	CacheHits map[string]CacheHitReport `json:"cache_hit_rates,omitempty"`
+}
+
+// EndpointReport is the latency and outcomes of one endpoint's requests.
+// Latencies are in milliseconds and cover every answered request; Errors
+// are requests that got no answer.
+type EndpointReport struct {
+	Requests    int            `json:"requests"`
+	RPS         float64        `json:"rps"`
+	Errors      int            `json:"errors"`
+	StatusCodes map[string]int `json:"status_codes"`
+	P50         float64        `json:"p50_ms"`
+	P95         float64        `json:"p95_ms"`
+	P99         float64        `json:"p99_ms"`
+	Max         float64        `json:"max_ms"`
+}
+
+// Run sends requests at the configured rate until the duration is up or
+// ctx is done, then waits for those in flight.
+func (r *Runner) Run(ctx context.Context) *Report {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Duration)
+	defer cancel()
+
+	slots := make(chan struct{}, r.opts.Concurrency)
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(time.Second / time.Duration(r.opts.RPS))
+	defer ticker.Stop()
+
+	started := time.Now()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+		}
+
+		req := r.next()
+		select {
+		case slots <- struct{}{}:
+		default:
+			r.mu.Lock()
+			r.skipped++
+			r.mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			r.send(req)
+		}()
+	}
+	wg.Wait()
+	return r.report(time.Since(started))
+}
+
+// request is one request of a run
+type request struct {
+	endpoint string
+	userID   string
+	body     []byte
+}
+
+// next picks the user, content and endpoint of the next request
+func (r *Runner) next() request {
+	target := r.targets[r.rand.Intn(len(r.targets))]
+	content := fmt.Sprintf("article-%d", r.rand.Intn(r.opts.Contents)+1)
+
+	req := request{endpoint: EndpointEnforce, userID: target.UserID}
+	body := map[string]string{"user_id": target.UserID, "content_id": content}
+	if r.rand.Float64() < r.opts.CheckRatio {
+		req.endpoint = EndpointCheck
+		body["plan_id"] = target.PlanID
+	} else {
+		body["action"] = enforceActions[r.rand.Intn(len(enforceActions))]
+	}
+	req.body, _ = json.Marshal(body)
+	return req
+}
+
+// send makes one request, timing it until the whole response is read. The
+// user goes in X-User-ID so rate limits apply per user, as for real
+// traffic.
+func (r *Runner) send(req request) {
+	httpReq, err := http.NewRequest(http.MethodPost, strings.TrimRight(r.opts.BaseURL, "/")+"/paywall/"+req.endpoint, bytes.NewReader(req.body))
+	if err != nil {
+		r.record(req.endpoint, 0, 0, err)
+		return
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-User-ID", req.userID)
+
+	start := time.Now()
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		r.record(req.endpoint, 0, 0, err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	r.record(req.endpoint, resp.StatusCode, time.Since(start), nil)
+}
+
+func (r *Runner) record(endpoint string, status int, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results[endpoint].add(status, latency, err)
+}
+
+func (r *Runner) report(elapsed time.Duration) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := &Report{
+		Seconds:   elapsed.Seconds(),
+		TargetRPS: r.opts.RPS,
+		Skipped:   r.skipped,
+		Endpoints: make(map[string]EndpointReport),
+	}
+	for endpoint, rec := range r.results {
+		report.Endpoints[endpoint] = rec.report(elapsed)
+	}
+	return report
+}
+
+// recorder collects one endpoint's outcomes
+type recorder struct {
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    int
+}
+
+func (rec *recorder) add(status int, latency time.Duration, err error) {
+	if err != nil {
+		rec.errors++
+		return
+	}
+	if rec.statuses == nil {
+		rec.statuses = make(map[int]int)
+	}
+	rec.statuses[status]++
+	rec.latencies = append(rec.latencies, latency)
+}
+
+func (rec *recorder) report(elapsed time.Duration) EndpointReport {
+	sort.Slice(rec.latencies, func(i, j int) bool { return rec.latencies[i] < rec.latencies[j] })
+
+	requests := len(rec.latencies) + rec.errors
+	report := EndpointReport{
+		Requests:    requests,
+		Errors:      rec.errors,
+		StatusCodes: make(map[string]int, len(rec.statuses)),
+		P50:         millis(percentile(rec.latencies, 50)),
+		P95:         millis(percentile(rec.latencies, 95)),
+		P99:         millis(percentile(rec.latencies, 99)),
+	}
+	if elapsed > 0 {
+		report.RPS = float64(requests) / elapsed.Seconds()
+	}
+	if n := len(rec.latencies); n > 0 {
+		report.Max = millis(rec.latencies[n-1])
+	}
+	for status, count := range rec.statuses {
+		report.StatusCodes[fmt.Sprint(status)] = count
+	}
+	return report
+}
+
+// percentile is the nearest-rank p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,89 @@
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, percentile(latencies, 50))
+	assert.Equal(t, 95*time.Millisecond, percentile(latencies, 95))
+	assert.Equal(t, 99*time.Millisecond, percentile(latencies, 99))
+	assert.Equal(t, 7*time.Millisecond, percentile([]time.Duration{7 * time.Millisecond}, 99))
+	assert.Zero(t, percentile(nil, 50))
+}
+
+func TestCacheCountsSince(t *testing.T) {
+	before, err := parseCacheCounts(strings.NewReader(`# TYPE cache_lookups_total counter
+cache_lookups_total{domain="paywall",result="hit"} 10
+cache_lookups_total{domain="paywall",result="miss"} 5
+cache_lookups_total{domain="plan",result="hit"} 3
+`))
+	require.NoError(t, err)
+	after, err := parseCacheCounts(strings.NewReader(`# TYPE cache_lookups_total counter
+cache_lookups_total{domain="paywall",result="hit"} 100
+cache_lookups_total{domain="paywall",result="miss"} 15
+cache_lookups_total{domain="plan",result="hit"} 3
+cache_lookups_total{domain="entitlement",result="miss"} 4
+# TYPE http_requests_total counter
+http_requests_total{method="POST"} 7
+`))
+	require.NoError(t, err)
+
+	rates := after.Since(before)
+	assert.Equal(t, CacheHitReport{Hits: 90, Misses: 10, HitRate: 0.9}, rates["paywall"])
+	assert.Equal(t, CacheHitReport{Misses: 4}, rates["entitlement"])
+	assert.NotContains(t, rates, "plan")
+}
+
+func TestRunHoldsRateAndRecordsStatuses(t *testing.T) {
+	var checks, enforces atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, body["user_id"], r.Header.Get("X-User-ID"))
+		switch r.URL.Path {
+		case "/api/v1/paywall/check":
+			checks.Add(1)
+			assert.NotEmpty(t, body["plan_id"])
+		case "/api/v1/paywall/enforce":
+			enforces.Add(1)
+			assert.NotEmpty(t, body["action"])
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	runner := NewRunner(Options{
+		BaseURL:     server.URL + "/api/v1",
+		RPS:         200,
+		Duration:    500 * time.Millisecond,
+		Concurrency: 10,
+		CheckRatio:  0.5,
+		Contents:    10,
+		Timeout:     time.Second,
+		Seed:        1,
+	}, []Target{{UserID: "user-1", PlanID: "plan-1"}, {UserID: "user-2", PlanID: "plan-2"}})
+	report := runner.Run(context.Background())
+
+	check, enforce := report.Endpoints[EndpointCheck], report.Endpoints[EndpointEnforce]
+	assert.InDelta(t, 100, check.Requests+enforce.Requests+report.Skipped, 15)
+	assert.Equal(t, int(checks.Load()), check.StatusCodes["200"])
+	assert.Equal(t, int(enforces.Load()), enforce.StatusCodes["403"])
+	assert.Positive(t, check.Requests)
+	assert.Positive(t, enforce.Requests)
+	assert.LessOrEqual(t, check.P50, check.P99)
+}
@@ -0,0 +1,94 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// CacheHitReport is the cache lookups a server made for one domain during
+// a run.
+type CacheHitReport struct {
+	Hits    float64 `json:"hits"`
+	Misses  float64 `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// CacheCounts are cache_lookups_total hits and misses by domain.
+type CacheCounts map[string]*CacheHitReport
+
+// ScrapeCacheCounts reads cache_lookups_total from a server's Prometheus
+// metrics at metricsURL.
+func ScrapeCacheCounts(ctx context.Context, client *http.Client, metricsURL string) (CacheCounts, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metricsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metrics returned status %d", resp.StatusCode)
+	}
+	return parseCacheCounts(resp.Body)
+}
+
+func parseCacheCounts(r io.Reader) (CacheCounts, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+
+	counts := make(CacheCounts)
+	family, ok := families["cache_lookups_total"]
+	if !ok {
+		return counts, nil
+	}
+	for _, metric := range family.GetMetric() {
+		var domain, result string
+		for _, label := range metric.GetLabel() {
+			switch label.GetName() {
+			case "domain":
+				domain = label.GetValue()
+			case "result":
+				result = label.GetValue()
+			}
+		}
+		count := counts[domain]
+		if count == nil {
+			count = &CacheHitReport{}
+			counts[domain] = count
+		}
+		switch result {
+		case "hit":
+			count.Hits += metric.GetCounter().GetValue()
+		case "miss":
+			count.Misses += metric.GetCounter().GetValue()
+		}
+	}
+	return counts, nil
+}
+
+// Since is the lookups made after before was scraped, with their hit rate,
+// for the domains that had any.
+func (c CacheCounts) Since(before CacheCounts) map[string]CacheHitReport {
+	rates := make(map[string]CacheHitReport)
+	for domain, after := range c {
+		delta := *after
+		if prior, ok := before[domain]; ok {
+			delta.Hits -= prior.Hits
+			delta.Misses -= prior.Misses
+		}
+		if total := delta.Hits + delta.Misses; total > 0 {
+			delta.HitRate = delta.Hits / total
+			rates[domain] = delta
+		}
+	}
+	return rates
+}
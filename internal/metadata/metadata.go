@@ -0,0 +1,108 @@
+// Package metadata validates and merges the free-form key/value metadata
+// integrators attach to users and subscriptions, such as CRM IDs or
+// campaign attribution.
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Limits on metadata, in line with what payment gateways accept
+const (
+	MaxKeys        = 50
+	MaxKeyLength   = 40
+	MaxValueLength = 500
+	MaxBytes       = 8 * 1024
+)
+
+var ErrInvalid = errors.New("invalid metadata")
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Validate checks metadata against the limits. Values are strings, numbers
+// or booleans; nested objects and arrays are refused so metadata stays a
+// flat lookup table.
+func Validate(m map[string]interface{}) error {
+	if len(m) > MaxKeys {
+		return fmt.Errorf("%w: at most %d keys", ErrInvalid, MaxKeys)
+	}
+	for key, value := range m {
+		if err := validateKey(key); err != nil {
+			return err
+		}
+		switch v := value.(type) {
+		case string:
+			if utf8.RuneCountInString(v) > MaxValueLength {
+				return fmt.Errorf("%w: value of %q is longer than %d characters", ErrInvalid, key, MaxValueLength)
+			}
+		case float64, bool:
+		default:
+			return fmt.Errorf("%w: value of %q must be a string, number or boolean", ErrInvalid, key)
+		}
+	}
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if len(encoded) > MaxBytes {
+		return fmt.Errorf("%w: larger than %d bytes", ErrInvalid, MaxBytes)
+	}
+	return nil
+}
+
+func validateKey(key string) error {
+	if len(key) == 0 || len(key) > MaxKeyLength {
+		return fmt.Errorf("%w: keys must be 1 to %d characters", ErrInvalid, MaxKeyLength)
+	}
+	if !keyPattern.MatchString(key) {
+		return fmt.Errorf("%w: key %q may only contain letters, digits, '_', '-' and '.'", ErrInvalid, key)
+	}
+	return nil
+}
+
+// Merge applies patch to current and validates the result: keys in patch
+// are set, and keys set to null are removed. current is not modified.
+func Merge(current, patch map[string]interface{}) (map[string]interface{}, error) {
+	merged := make(map[string]interface{}, len(current)+len(patch))
+	for key, value := range current {
+		merged[key] = value
+	}
+	for key, value := range patch {
+		if err := validateKey(key); err != nil {
+			return nil, err
+		}
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = value
+	}
+	if err := Validate(merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// Encode returns metadata as stored, {} when empty
+func Encode(m map[string]interface{}) ([]byte, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+// Decode reads stored metadata
+func Decode(data []byte) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if len(data) == 0 {
+		return m, nil
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
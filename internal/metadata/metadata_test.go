@@ -0,0 +1,61 @@
+package metadata
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(nil))
+	assert.NoError(t, Validate(map[string]interface{}{
+		"crm_id": "0031x00000abc", "utm.campaign": "spring-sale", "seats": float64(3), "vip": true,
+	}))
+
+	tooMany := map[string]interface{}{}
+	for i := 0; i <= MaxKeys; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "v"
+	}
+
+	for name, m := range map[string]map[string]interface{}{
+		"empty key":     {"": "v"},
+		"long key":      {strings.Repeat("k", MaxKeyLength+1): "v"},
+		"bad key":       {"crm id": "v"},
+		"long value":    {"note": strings.Repeat("v", MaxValueLength+1)},
+		"nested":        {"address": map[string]interface{}{"city": "Oslo"}},
+		"array":         {"tags": []interface{}{"a"}},
+		"null value":    {"crm_id": nil},
+		"too many keys": tooMany,
+	} {
+		assert.ErrorIs(t, Validate(m), ErrInvalid, name)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	current := map[string]interface{}{"crm_id": "1", "source": "ads"}
+
+	merged, err := Merge(current, map[string]interface{}{"crm_id": "2", "source": nil, "seats": float64(5)})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"crm_id": "2", "seats": float64(5)}, merged)
+	assert.Equal(t, "1", current["crm_id"])
+
+	_, err = Merge(current, map[string]interface{}{"bad key": nil})
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	encoded, err := Encode(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(encoded))
+
+	decoded, err := Decode([]byte(`{"crm_id":"1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"crm_id": "1"}, decoded)
+
+	decoded, err = Decode(nil)
+	require.NoError(t, err)
+	assert.Empty(t, decoded)
+}
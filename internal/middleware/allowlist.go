@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseCIDRs checks an allowlist of CIDR ranges ("203.0.113.0/24",
+// "2001:db8::/32") or single addresses, which stand for themselves.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		entry := strings.TrimSpace(cidr)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR range", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// IPAllowed reports whether ip falls in one of cidrs. An empty allowlist
+// allows every address; entries that don't parse allow none.
+func IPAllowed(ip string, cidrs []string) bool {
+	if len(cidrs) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	nets, err := ParseCIDRs(cidrs)
+	if err != nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"203.0.113.0/24", " 198.51.100.7 ", "2001:db8::/32", "2001:db8::1"})
+	require.NoError(t, err)
+	require.Len(t, nets, 4)
+	assert.Equal(t, "198.51.100.7/32", nets[1].String())
+	assert.Equal(t, "2001:db8::1/128", nets[3].String())
+
+	_, err = ParseCIDRs([]string{"203.0.113.0/24", "office"})
+	assert.EqualError(t, err, `"office" is not an IP address or CIDR range`)
+	_, err = ParseCIDRs([]string{"203.0.113.0/33"})
+	assert.Error(t, err)
+}
+
+func TestIPAllowed(t *testing.T) {
+	assert.True(t, IPAllowed("192.0.2.1", nil))
+
+	cidrs := []string{"203.0.113.0/24", "198.51.100.7", "2001:db8::/32"}
+	assert.True(t, IPAllowed("203.0.113.200", cidrs))
+	assert.True(t, IPAllowed("198.51.100.7", cidrs))
+	assert.True(t, IPAllowed("2001:db8:1::5", cidrs))
+	assert.False(t, IPAllowed("198.51.100.8", cidrs))
+	assert.False(t, IPAllowed("192.0.2.1", cidrs))
+	assert.False(t, IPAllowed("", cidrs))
+	assert.False(t, IPAllowed("203.0.113.5", []string{"not a range"}))
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrInvalidIfMatch is returned for an If-Match header that is not a single
+// version ETag.
+var ErrInvalidIfMatch = errors.New("If-Match must be a single ETag such as \"3\"")
+
+// SetETag sets the ETag header for a resource at version.
+func SetETag(c *gin.Context, version int) {
+	c.Header("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// IfMatchVersion returns the resource version the client expects from the
+// If-Match header. ok is false when the header is absent or "*".
+func IfMatchVersion(c *gin.Context) (version int, ok bool, err error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+
+	// Weak validators compare equal to strong ones for our purposes
+	tag := strings.TrimPrefix(header, "W/")
+	unquoted, err := strconv.Unquote(tag)
+	if err != nil {
+		return 0, false, ErrInvalidIfMatch
+	}
+	version, err = strconv.Atoi(unquoted)
+	if err != nil {
+		return 0, false, ErrInvalidIfMatch
+	}
+	return version, true, nil
+}
+
+// NoneMatch reports whether the request's If-None-Match header matches etag,
+// so a cached copy can be answered with 304. The header is a comma-separated
+// list of entity tags, or "*" for any. Comparison is weak, as RFC 9110
+// requires for If-None-Match: W/"a" matches "a".
+func NoneMatch(c *gin.Context, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, header := range c.Request.Header.Values("If-None-Match") {
+		if strings.TrimSpace(header) == "*" {
+			return true
+		}
+		for _, tag := range entityTags(header) {
+			if strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// entityTags splits a list of entity tags, which may contain commas within
+// their quotes. Parsing stops at the first malformed tag.
+func entityTags(header string) []string {
+	var tags []string
+	for {
+		header = strings.TrimLeft(header, " \t,")
+		if header == "" {
+			return tags
+		}
+		weak := strings.HasPrefix(header, "W/")
+		rest := strings.TrimPrefix(header, "W/")
+		if !strings.HasPrefix(rest, `"`) {
+			return tags
+		}
+		end := strings.IndexByte(rest[1:], '"')
+		if end < 0 {
+			return tags
+		}
+		tag := rest[:end+2]
+		if weak {
+			tag = "W/" + tag
+		}
+		tags = append(tags, tag)
+		header = rest[end+2:]
+	}
+}
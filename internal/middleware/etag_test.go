@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func ifMatchContext(header string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("PUT", "/", nil)
+	if header != "" {
+		c.Request.Header.Set("If-Match", header)
+	}
+	return c
+}
+
+func TestIfMatchVersion(t *testing.T) {
+	version, ok, err := IfMatchVersion(ifMatchContext(`"3"`))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 3, version)
+
+	version, ok, err = IfMatchVersion(ifMatchContext(`W/"7"`))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 7, version)
+
+	for _, header := range []string{"", "*"} {
+		_, ok, err = IfMatchVersion(ifMatchContext(header))
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+
+	for _, header := range []string{"3", `"abc"`, `"1", "2"`} {
+		_, _, err = IfMatchVersion(ifMatchContext(header))
+		assert.ErrorIs(t, err, ErrInvalidIfMatch)
+	}
+}
+
+func TestSetETagRoundTrips(t *testing.T) {
+	c := ifMatchContext("")
+	SetETag(c, 12)
+
+	version, ok, err := IfMatchVersion(ifMatchContext(c.Writer.Header().Get("ETag")))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 12, version)
+}
+
+func TestNoneMatch(t *testing.T) {
+	noneMatch := func(etag string, headers ...string) bool {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/", nil)
+		for _, header := range headers {
+			c.Request.Header.Add("If-None-Match", header)
+		}
+		return NoneMatch(c, etag)
+	}
+
+	assert.True(t, noneMatch(`"abc"`, `"abc"`))
+	assert.True(t, noneMatch(`"abc"`, `"old", "abc"`))
+	assert.True(t, noneMatch(`"abc"`, `"old"`, `"abc"`))
+	assert.True(t, noneMatch(`"abc"`, `*`))
+	assert.True(t, noneMatch(`"abc"`, `W/"abc"`))
+	assert.True(t, noneMatch(`W/"abc"`, `"abc"`))
+	assert.True(t, noneMatch(`"a,b"`, `"x", "a,b"`))
+
+	assert.False(t, noneMatch(`"abc"`))
+	assert.False(t, noneMatch(`"abc"`, `"old"`))
+	assert.False(t, noneMatch(`"abc"`, `abc`))
+	assert.False(t, noneMatch(`"abc"`, `"old", "ab`))
+}
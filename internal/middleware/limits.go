@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeout bounds each request with a deadline carried on the request
+// context, so DB and Redis calls made with c.Request.Context() are cancelled
+// once it expires. Per-route overrides come from cfg.RouteTimeouts.
+func Timeout(cfg config.ServerConfig) gin.HandlerFunc {
+	defaultTimeout := time.Duration(cfg.RequestTimeout) * time.Second
+
+	overrides := make(map[string]time.Duration, len(cfg.RouteTimeouts))
+	for _, route := range cfg.RouteTimeouts {
+		overrides[routeKey(route.Method, route.Path)] = time.Duration(route.Timeout) * time.Second
+	}
+
+	return gin.HandlerFunc(func(c *gin.Context) {
+		timeout := defaultTimeout
+		if override, ok := overrides[routeKey(c.Request.Method, c.FullPath())]; ok {
+			timeout = override
+		}
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		// Handlers that gave up on a cancelled query may not have responded
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Writer.Written() {
+			c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "Request timed out"})
+		}
+	})
+}
+
+// BodyLimit rejects request bodies larger than maxBytes. Declared lengths are
+// rejected up front; chunked bodies fail when the handler reads past the limit.
+func BodyLimit(maxBytes int64) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		if maxBytes <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > maxBytes {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	})
+}
+
+func routeKey(method, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout(t *testing.T) {
+	router := gin.New()
+	router.Use(Timeout(config.ServerConfig{
+		RequestTimeout: 1,
+		RouteTimeouts:  []config.RouteTimeoutConfig{{Method: "get", Path: "/reports/:id", Timeout: 0}},
+	}))
+
+	// A handler giving up on its cancelled context without responding
+	var deadline time.Time
+	router.GET("/plans/:id", func(c *gin.Context) {
+		deadline, _ = c.Request.Context().Deadline()
+		<-c.Request.Context().Done()
+	})
+	router.GET("/fast", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	router.GET("/reports/:id", func(c *gin.Context) {
+		_, bounded := c.Request.Context().Deadline()
+		assert.False(t, bounded, "a zero route override disables the timeout")
+		c.Status(http.StatusNoContent)
+	})
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/plans/basic", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.JSONEq(t, `{"error": "Request timed out"}`, w.Body.String())
+	assert.WithinDuration(t, start.Add(time.Second), deadline, 100*time.Millisecond)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/reports/q3", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestBodyLimit(t *testing.T) {
+	router := gin.New()
+	router.Use(BodyLimit(16))
+	router.POST("/plans", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			return
+		}
+		c.String(http.StatusOK, string(body))
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/plans", strings.NewReader(`{"name":"Pro"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"name":"Pro"}`, w.Body.String())
+
+	// A declared length over the limit is refused before the handler runs
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/plans", strings.NewReader(`{"name":"Professional"}`)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.JSONEq(t, `{"error": "Request body too large"}`, w.Body.String())
+
+	// A chunked body fails once the handler reads past the limit
+	req := httptest.NewRequest(http.MethodPost, "/plans", io.NopCloser(strings.NewReader(`{"name":"Professional"}`)))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestTimeoutLeavesWrittenResponses(t *testing.T) {
+	router := gin.New()
+	router.Use(Timeout(config.ServerConfig{RequestTimeout: 1}))
+	router.GET("/slow", func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+		defer cancel()
+		<-ctx.Done()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
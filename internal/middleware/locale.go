@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"scalable-paywall/internal/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+const localeKey = "locale"
+
+// Localize picks the locale that best fits the request's Accept-Language
+// among those with translations, answers it in Content-Language and
+// translates the "error" message of JSON error responses (status 400 and
+// up) into it. Other fields and successful responses are untouched.
+func Localize(bundle *i18n.Bundle) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		locale := bundle.Match(c.GetHeader("Accept-Language"))
+		c.Set(localeKey, locale)
+		c.Header("Content-Language", locale)
+		c.Writer = &localizeWriter{ResponseWriter: c.Writer, bundle: bundle, locale: locale}
+		c.Next()
+	})
+}
+
+// Locale returns the locale Localize picked for the request, or "" without
+// it
+func Locale(c *gin.Context) string {
+	return c.GetString(localeKey)
+}
+
+type localizeWriter struct {
+	gin.ResponseWriter
+	bundle *i18n.Bundle
+	locale string
+}
+
+func (w *localizeWriter) Write(b []byte) (int, error) {
+	if w.Status() < http.StatusBadRequest {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, err := w.ResponseWriter.Write(w.translate(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *localizeWriter) WriteString(s string) (int, error) {
+	if w.Status() < http.StatusBadRequest {
+		return w.ResponseWriter.WriteString(s)
+	}
+	if _, err := w.ResponseWriter.Write(w.translate([]byte(s))); err != nil {
+		return 0, err
+	}
+	return len(s), nil
+}
+
+// translate rewrites the "error" of a JSON object body; anything else is
+// written as it is
+func (w *localizeWriter) translate(b []byte) []byte {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(b, &body); err != nil {
+		return b
+	}
+	var message string
+	if err := json.Unmarshal(body["error"], &message); err != nil {
+		return b
+	}
+	translated := w.bundle.Translate(w.locale, message)
+	if translated == message {
+		return b
+	}
+	body["error"], _ = json.Marshal(translated)
+	out, err := json.Marshal(body)
+	if err != nil {
+		return b
+	}
+	return out
+}
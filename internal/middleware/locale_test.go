@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/i18n"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalizeTranslatesErrorResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	bundle, err := i18n.Load(config.I18nConfig{DefaultLocale: "en"})
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(Localize(bundle))
+	router.GET("/error", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found", "subscription_id": "s1"})
+	})
+	router.GET("/ok", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"error": "Subscription not found", "locale": Locale(c)})
+	})
+
+	get := func(path, acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := get("/error", "de-DE,de;q=0.9")
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, "de", recorder.Header().Get("Content-Language"))
+	assert.JSONEq(t, `{"error":"Abonnement nicht gefunden","subscription_id":"s1"}`, recorder.Body.String())
+
+	recorder = get("/error", "ja")
+	assert.Equal(t, "en", recorder.Header().Get("Content-Language"))
+	assert.JSONEq(t, `{"error":"Subscription not found","subscription_id":"s1"}`, recorder.Body.String())
+
+	recorder = get("/ok", "fr")
+	assert.JSONEq(t, `{"error":"Subscription not found","locale":"fr"}`, recorder.Body.String())
+}
@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"scalable-paywall/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrustProxies makes engine take the client IP from X-Forwarded-For or
+// X-Real-IP only for requests arriving from cfg.TrustedProxies. gin trusts
+// every proxy by default, which lets any caller pick the IP that
+// c.ClientIP() reports, so call this before the engine serves.
+func TrustProxies(engine *gin.Engine, cfg config.ServerConfig) error {
+	return engine.SetTrustedProxies(cfg.TrustedProxies)
+}
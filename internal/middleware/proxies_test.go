@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustProxies(t *testing.T) {
+	clientIP := func(proxies []string, remoteAddr string) string {
+		router := gin.New()
+		require.NoError(t, TrustProxies(router, config.ServerConfig{TrustedProxies: proxies}))
+		router.GET("/ip", func(c *gin.Context) {
+			c.String(http.StatusOK, c.ClientIP())
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	// Without trusted proxies a forwarded address is ignored
+	assert.Equal(t, "198.51.100.4", clientIP(nil, "198.51.100.4:51000"))
+
+	// A trusted proxy's is believed, anyone else's is not
+	assert.Equal(t, "203.0.113.9", clientIP([]string{"10.0.0.0/8"}, "10.1.2.3:51000"))
+	assert.Equal(t, "198.51.100.4", clientIP([]string{"10.0.0.0/8"}, "198.51.100.4:51000"))
+
+	router := gin.New()
+	assert.Error(t, TrustProxies(router, config.ServerConfig{TrustedProxies: []string{"load-balancer"}}))
+}
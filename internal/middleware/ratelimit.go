@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// UserHeader carries the user a request is made by.
+const UserHeader = "X-User-ID"
+
+// LimitResolver returns the per-minute request limit of userID's plan, or 0
+// when the plan sets none and the configured default applies.
+type LimitResolver func(ctx context.Context, userID string) (int, error)
+
+// RateLimit allows each user cfg.RequestsPer requests per cfg.Window
+// seconds, counted in Redis so the limit holds across instances. A user's
+// plan may raise or lower that through resolve, scaled from per minute to
+// the window. Requests without UserHeader are limited by client IP at the
+// default rate. Redis or resolver failures let the request through.
+func RateLimit(cfg config.RateLimitConfig, cache *cache.RedisClient, resolve LimitResolver) gin.HandlerFunc {
+	window := time.Duration(cfg.Window) * time.Second
+
+	return gin.HandlerFunc(func(c *gin.Context) {
+		if !cfg.Enabled {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+
+		limit := cfg.RequestsPer
+		subject := "ip:" + c.ClientIP()
+		if userID := c.GetHeader(UserHeader); userID != "" {
+			subject = "user:" + userID
+			perMinute, err := resolve(ctx, userID)
+			if err != nil {
+				logrus.Warnf("Failed to resolve rate limit for user %s: %v", userID, err)
+			} else if perMinute > 0 {
+				limit = scaleLimit(perMinute, cfg.Window)
+			}
+		}
+
+		now := time.Now()
+		windowStart := now.Truncate(window)
+		key := fmt.Sprintf("ratelimit:%s:%d", subject, windowStart.Unix())
+		count, err := cache.Incr(ctx, key)
+		if err != nil {
+			logrus.Warnf("Rate limiting unavailable: %v", err)
+			c.Next()
+			return
+		}
+		if count == 1 {
+			cache.Expire(ctx, key, window)
+		}
+
+		remaining := limit - int(count)
+		if remaining < 0 {
+			remaining = 0
+		}
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+
+		if int(count) > limit {
+			retryAfter := int(windowStart.Add(window).Sub(now).Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			return
+		}
+		c.Next()
+	})
+}
+
+// scaleLimit converts a per-minute limit into requests per window seconds,
+// allowing at least one
+func scaleLimit(perMinute int, window int64) int {
+	limit := int(int64(perMinute) * window / 60)
+	if limit < 1 {
+		return 1
+	}
+	return limit
+}
@@ -0,0 +1,14 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleLimit(t *testing.T) {
+	assert.Equal(t, 600, scaleLimit(600, 60))
+	assert.Equal(t, 100, scaleLimit(600, 10))
+	assert.Equal(t, 1200, scaleLimit(600, 120))
+	assert.Equal(t, 1, scaleLimit(5, 1))
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"scalable-paywall/internal/errorreport"
+	"scalable-paywall/internal/scrub"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Recover turns a handler panic into a 500 with the error and the request's
+// ID, logs it with its stack and, with a reporter, sends it to the error
+// tracker in the background. Panic messages and stacks are scrubbed first.
+// Panics caused by the client going away are only dropped. Mount it in
+// place of gin.Recovery, after RequestID.
+func Recover(reporter errorreport.Reporter, scrubber *scrub.Scrubber) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if brokenConnection(p) {
+				logrus.Warnf("Client went away during %s %s: %v", c.Request.Method, c.Request.URL.Path, p)
+				c.Abort()
+				return
+			}
+
+			route := c.FullPath()
+			if route == "" {
+				route = "unmatched"
+			}
+			event := &errorreport.Event{
+				Message:   scrubber.String(fmt.Sprint(p)),
+				Frames:    errorreport.Callers(1),
+				RequestID: requestIDOf(c),
+				Method:    c.Request.Method,
+				Route:     route,
+				Path:      c.Request.URL.Path,
+				Time:      time.Now(),
+			}
+			logrus.WithField("request_id", event.RequestID).Errorf("Panic serving %s %s: %s\n%s",
+				event.Method, route, event.Message, scrubber.String(string(debug.Stack())))
+			telemetry.RecordPanic(route)
+
+			if reporter != nil {
+				go func() {
+					if err := reporter.Report(context.Background(), event); err != nil {
+						logrus.Errorf("Failed to report panic of request %s: %v", event.RequestID, err)
+					}
+				}()
+			}
+
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error":      "Internal server error",
+				"request_id": event.RequestID,
+			})
+		}()
+		c.Next()
+	})
+}
+
+// brokenConnection reports whether a panic comes from writing to a client
+// that closed its connection, or from a handler aborting on purpose
+func brokenConnection(p interface{}) bool {
+	err, ok := p.(error)
+	if !ok {
+		return false
+	}
+	if errors.Is(err, http.ErrAbortHandler) {
+		return true
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	var sysErr *os.SyscallError
+	return errors.As(opErr, &sysErr) && (errors.Is(sysErr.Err, syscall.EPIPE) || errors.Is(sysErr.Err, syscall.ECONNRESET))
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/errorreport"
+	"scalable-paywall/internal/scrub"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingReporter struct {
+	mu     sync.Mutex
+	events []*errorreport.Event
+}
+
+func (r *recordingReporter) Report(ctx context.Context, event *errorreport.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recordingReporter) reported() []*errorreport.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events
+}
+
+func explode() {
+	panic("card sk_live_abcdef123456 declined for ada@example.com")
+}
+
+func recoverRouter(t *testing.T, reporter errorreport.Reporter) *gin.Engine {
+	t.Helper()
+	scrubber, err := scrub.New(config.LoggingConfig{})
+	require.NoError(t, err)
+	router := gin.New()
+	router.Use(RequestID(), Recover(reporter, scrubber))
+	router.GET("/plans/:id", func(c *gin.Context) { explode() })
+	router.GET("/ok", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return router
+}
+
+func TestRecoverAnswersWithRequestIDAndReports(t *testing.T) {
+	reporter := &recordingReporter{}
+	router := recoverRouter(t, reporter)
+
+	req := httptest.NewRequest(http.MethodGet, "/plans/42", nil)
+	req.Header.Set(RequestIDHeader, "edge-7f3a")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "edge-7f3a", w.Header().Get(RequestIDHeader))
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, map[string]string{"error": "Internal server error", "request_id": "edge-7f3a"}, body)
+
+	require.Eventually(t, func() bool { return len(reporter.reported()) == 1 }, time.Second, 10*time.Millisecond)
+	event := reporter.reported()[0]
+	assert.Equal(t, "edge-7f3a", event.RequestID)
+	assert.Equal(t, "/plans/:id", event.Route)
+	assert.Equal(t, "/plans/42", event.Path)
+	assert.NotContains(t, event.Message, "sk_live_abcdef123456")
+	assert.NotContains(t, event.Message, "ada@example.com")
+	require.NotEmpty(t, event.Frames)
+	assert.True(t, strings.HasSuffix(event.Frames[0].Function, "middleware.explode"), event.Frames[0].Function)
+}
+
+func TestRecoverWithoutReporter(t *testing.T) {
+	router := recoverRouter(t, nil)
+
+	// An unusable caller ID is replaced
+	req := httptest.NewRequest(http.MethodGet, "/plans/42", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	id := w.Header().Get(RequestIDHeader)
+	assert.Len(t, id, 36)
+	assert.Contains(t, w.Body.String(), id)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ok", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.NotEmpty(t, w.Header().Get(RequestIDHeader))
+}
+
+func TestBrokenConnection(t *testing.T) {
+	assert.True(t, brokenConnection(http.ErrAbortHandler))
+	assert.False(t, brokenConnection("nil map"))
+	assert.False(t, brokenConnection(context.Canceled))
+}
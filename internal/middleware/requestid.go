@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the ID of a request, in and out
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDKey is the gin context key RequestID sets to the request's ID
+const RequestIDKey = "request_id"
+
+// validRequestID is an ID a caller may pass on: short, with no characters
+// that would need escaping in logs or JSON
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID gives each request an ID, the caller's X-Request-ID when it is
+// usable and a new UUID otherwise, and echoes it in the response, so errors
+// reported to users can be found in logs and error reports.
+func RequestID() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	})
+}
+
+// requestIDOf returns the request's ID, giving it one when RequestID isn't
+// mounted
+func requestIDOf(c *gin.Context) string {
+	if id := c.GetString(RequestIDKey); id != "" {
+		return id
+	}
+	id := uuid.NewString()
+	c.Set(RequestIDKey, id)
+	c.Header(RequestIDHeader, id)
+	return id
+}
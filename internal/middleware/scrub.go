@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+
+	"scalable-paywall/internal/scrub"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScrubErrors scrubs the bodies of error responses (status 400 and up), so
+// personal data or credentials echoed back by error details, such as raw
+// binding errors, never leave the API. Successful responses are untouched.
+func ScrubErrors(scrubber *scrub.Scrubber) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		c.Writer = &scrubWriter{ResponseWriter: c.Writer, scrubber: scrubber}
+		c.Next()
+	})
+}
+
+type scrubWriter struct {
+	gin.ResponseWriter
+	scrubber *scrub.Scrubber
+}
+
+func (w *scrubWriter) Write(b []byte) (int, error) {
+	if w.Status() < http.StatusBadRequest {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, err := w.ResponseWriter.WriteString(w.scrubber.String(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *scrubWriter) WriteString(s string) (int, error) {
+	if w.Status() < http.StatusBadRequest {
+		return w.ResponseWriter.WriteString(s)
+	}
+	if _, err := w.ResponseWriter.WriteString(w.scrubber.String(s)); err != nil {
+		return 0, err
+	}
+	return len(s), nil
+}
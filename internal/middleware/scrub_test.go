@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/scrub"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrubErrorsOnlyScrubsErrorResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	scrubber, err := scrub.New(config.LoggingConfig{})
+	assert.NoError(t, err)
+
+	router := gin.New()
+	router.Use(ScrubErrors(scrubber))
+	router.GET("/error", func(c *gin.Context) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": "email jane@example.com is taken"})
+	})
+	router.GET("/ok", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"email": "jane@example.com"})
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/error", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.JSONEq(t, `{"error":"Invalid request format","details":"email [REDACTED] is taken"}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ok", nil))
+	assert.JSONEq(t, `{"email":"jane@example.com"}`, recorder.Body.String())
+}
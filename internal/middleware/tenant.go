@@ -0,0 +1,15 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+// TenantHeader carries the tenant a request is made on behalf of.
+const TenantHeader = "X-Tenant-ID"
+
+// TenantID returns the tenant for the request, or "" for single-tenant calls.
+// The header is set by the caller, so it only picks the tenant of a user
+// being created and of the admin resources it scopes. Anything decided about
+// an existing user, like billing or paywall gating, uses the tenant stored on
+// the user instead.
+func TenantID(c *gin.Context) string {
+	return c.GetHeader(TenantHeader)
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetUsageHeaders reports a metered user's quota so API consumers can
+// throttle without parsing bodies: X-Usage-Limit, X-Usage-Remaining after
+// this request, and X-Usage-Reset, when the counter resets, in Unix seconds.
+func SetUsageHeaders(c *gin.Context, limit, remaining int, reset time.Time) {
+	if remaining < 0 {
+		remaining = 0
+	}
+	c.Header("X-Usage-Limit", strconv.Itoa(limit))
+	c.Header("X-Usage-Remaining", strconv.Itoa(remaining))
+	c.Header("X-Usage-Reset", strconv.FormatInt(reset.Unix(), 10))
+}
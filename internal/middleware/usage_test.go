@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetUsageHeaders(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	reset := time.Date(2024, time.March, 1, 23, 59, 59, 0, time.UTC)
+
+	SetUsageHeaders(c, 100, -1, reset)
+
+	assert.Equal(t, "100", recorder.Header().Get("X-Usage-Limit"))
+	assert.Equal(t, "0", recorder.Header().Get("X-Usage-Remaining"))
+	assert.Equal(t, "1709337599", recorder.Header().Get("X-Usage-Reset"))
+}
@@ -0,0 +1,59 @@
+// Package money holds the shared rules for monetary amounts: amounts are
+// exact decimals, rounded to the minor-unit exponent of their ISO 4217
+// currency.
+package money
+
+import (
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// currencyExponents lists currencies whose minor unit is not 1/100
+var currencyExponents = map[string]int32{
+	// Zero-decimal currencies
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	// Three-decimal currencies
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+func init() {
+	// Keep amounts as JSON numbers so API payloads are unchanged
+	decimal.MarshalJSONWithoutQuotes = true
+}
+
+// Exponent returns the number of minor-unit digits for currency (2 unless
+// listed otherwise).
+func Exponent(currency string) int32 {
+	if exp, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exp
+	}
+	return 2
+}
+
+// Round rounds amount half away from zero to currency's minor unit.
+func Round(amount decimal.Decimal, currency string) decimal.Decimal {
+	return amount.Round(Exponent(currency))
+}
+
+// IsValid reports whether amount has no more precision than currency allows.
+func IsValid(amount decimal.Decimal, currency string) bool {
+	return Round(amount, currency).Equal(amount)
+}
+
+// ToMinor converts amount to integer minor units (e.g. cents), as payment
+// gateways expect.
+func ToMinor(amount decimal.Decimal, currency string) int64 {
+	return Round(amount, currency).Shift(Exponent(currency)).IntPart()
+}
+
+// FromMinor converts integer minor units back to a decimal amount.
+func FromMinor(minor int64, currency string) decimal.Decimal {
+	return decimal.New(minor, -Exponent(currency))
+}
+
+// Format renders amount with currency's minor-unit digits, e.g. "9.99 USD".
+func Format(amount decimal.Decimal, currency string) string {
+	return amount.StringFixed(Exponent(currency)) + " " + strings.ToUpper(currency)
+}
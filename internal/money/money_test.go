@@ -0,0 +1,33 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponent(t *testing.T) {
+	assert.Equal(t, int32(2), Exponent("USD"))
+	assert.Equal(t, int32(0), Exponent("jpy"))
+	assert.Equal(t, int32(3), Exponent("KWD"))
+}
+
+func TestMinorUnitsRoundTrip(t *testing.T) {
+	amount := decimal.RequireFromString("19.99")
+
+	assert.Equal(t, int64(1999), ToMinor(amount, "USD"))
+	assert.True(t, FromMinor(1999, "USD").Equal(amount))
+	assert.Equal(t, int64(20), ToMinor(amount, "JPY"))
+}
+
+func TestRoundAndFormat(t *testing.T) {
+	// Three payments of a third must not drift
+	third := Round(decimal.RequireFromString("10").Div(decimal.NewFromInt(3)), "USD")
+	assert.Equal(t, "3.33 USD", Format(third, "usd"))
+	assert.Equal(t, "1.234 KWD", Format(decimal.RequireFromString("1.2344"), "KWD"))
+
+	assert.True(t, IsValid(decimal.RequireFromString("9.99"), "USD"))
+	assert.False(t, IsValid(decimal.RequireFromString("9.999"), "USD"))
+	assert.False(t, IsValid(decimal.RequireFromString("100.5"), "JPY"))
+}
@@ -0,0 +1,184 @@
+package notification
+
+import (
+	"errors"
+	"net/http"
+
+	"scalable-paywall/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/text/language"
+)
+
+// PreviewTemplateRequest renders a draft without saving it. Subject and
+// Body default to the template in use; Data replaces the sample
+// notification's data.
+type PreviewTemplateRequest struct {
+	Subject *string                `json:"subject"`
+	Body    *string                `json:"body"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+// UpdateTemplateRequest replaces a template. Emails need a Subject.
+type UpdateTemplateRequest struct {
+	Subject string `json:"subject" binding:"max=998"`
+	Body    string `json:"body" binding:"required,max=65536"`
+}
+
+type TemplateListResponse struct {
+	Templates []Template `json:"templates"`
+}
+
+// templateLocale reads the ?locale= templates are listed, previewed or
+// saved for, empty for any locale. It answers 400 and returns false for
+// one that isn't a BCP 47 tag.
+func templateLocale(c *gin.Context) (string, bool) {
+	raw := c.Query("locale")
+	if raw == "" {
+		return "", true
+	}
+	tag, err := language.Parse(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "locale must be a BCP 47 language tag"})
+		return "", false
+	}
+	return tag.String(), true
+}
+
+// ListTemplates lists the template in use for every notification type and
+// channel for the X-Tenant-ID, or the defaults without one, in the ?locale=,
+// with where each comes from.
+func (t *Templates) ListTemplates(c *gin.Context) {
+	locale, ok := templateLocale(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	tenantID := middleware.TenantID(c)
+
+	templates := []Template{}
+	for _, notificationType := range Types() {
+		for _, channel := range []string{ChannelEmail, ChannelWebhook} {
+			tmpl, err := t.Get(ctx, tenantID, locale, notificationType, channel)
+			if err != nil {
+				logrus.Errorf("Failed to get %s %s template: %v", notificationType, channel, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				return
+			}
+			templates = append(templates, *tmpl)
+		}
+	}
+	c.JSON(http.StatusOK, TemplateListResponse{Templates: templates})
+}
+
+// PreviewTemplate renders a template, or a draft of it, for the X-Tenant-ID
+// and ?locale= with sample data. A webhook payload is previewed with the
+// tenant's email in it. Templates that fail to render answer 400 with the
+// error.
+func (t *Templates) PreviewTemplate(c *gin.Context) {
+	var req PreviewTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	locale, ok := templateLocale(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := middleware.TenantID(c)
+	notificationType, channel := c.Param("type"), c.Param("channel")
+	tmpl, err := t.Get(ctx, tenantID, locale, notificationType, channel)
+	if err != nil {
+		if errors.Is(err, ErrUnknownTemplate) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+		logrus.Errorf("Failed to get %s %s template: %v", notificationType, channel, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if req.Subject != nil {
+		tmpl.Subject = *req.Subject
+	}
+	if req.Body != nil {
+		tmpl.Body = *req.Body
+	}
+
+	message := Message{Notification: sampleNotifications[notificationType]}
+	message.TenantID, message.Locale = tenantID, locale
+	if req.Data != nil {
+		message.Data = req.Data
+	}
+	if channel == ChannelWebhook {
+		email, err := t.Get(ctx, tenantID, locale, notificationType, ChannelEmail)
+		if err == nil {
+			message.Email, err = email.Render(message)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email template: " + err.Error()})
+			return
+		}
+	}
+
+	rendered, err := tmpl.Render(message)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rendered)
+}
+
+// UpdateTemplate saves the X-Tenant-ID's template for a notification type
+// and channel, or the default for all tenants without one, for users in
+// the ?locale= or any locale without one. It must render with sample data;
+// the admin is taken from X-User-ID.
+func (t *Templates) UpdateTemplate(c *gin.Context) {
+	var req UpdateTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	locale, ok := templateLocale(c)
+	if !ok {
+		return
+	}
+
+	tmpl := &Template{
+		TenantID: middleware.TenantID(c),
+		Locale:   locale,
+		Type:     c.Param("type"),
+		Channel:  c.Param("channel"),
+		Subject:  req.Subject,
+		Body:     req.Body,
+		Source:   SourceDefault,
+	}
+	if tmpl.TenantID != "" {
+		tmpl.Source = SourceTenant
+	}
+	if tmpl.Channel == ChannelEmail && tmpl.Subject == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email templates need a subject"})
+		return
+	}
+	if tmpl.Channel == ChannelWebhook {
+		tmpl.Subject = ""
+	}
+
+	err := t.Save(c.Request.Context(), tmpl, c.GetHeader(middleware.UserHeader))
+	var renderErr *RenderError
+	switch {
+	case errors.Is(err, ErrUnknownTemplate):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	case errors.As(err, &renderErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": renderErr.Error()})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to save %s %s template: %v", tmpl.Type, tmpl.Channel, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, tmpl)
+}
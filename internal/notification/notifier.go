@@ -0,0 +1,101 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Notification types emitted to users or downstream systems.
+const (
+	TypeRenewalReminder = "subscription.renewal_reminder"
+	TypeExpiring        = "subscription.expiring"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the webhook body.
+const SignatureHeader = "X-Paywall-Signature"
+
+// Notification is an event for a user. TenantID picks the templates it is
+// rendered with and Locale, the user's, their language; see Templates.
+type Notification struct {
+	Type           string                 `json:"type"`
+	TenantID       string                 `json:"tenant_id,omitempty"`
+	Locale         string                 `json:"locale,omitempty"`
+	UserID         string                 `json:"user_id"`
+	SubscriptionID string                 `json:"subscription_id,omitempty"`
+	Data           map[string]interface{} `json:"data,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+}
+
+// Notifier delivers notifications. Email/push delivery is expected to live
+// behind the webhook receiver.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NewNotifier returns a webhook notifier when a webhook URL is configured and
+// a log-only notifier otherwise. Webhook payloads are rendered with
+// templates, or the built-in templates when it is nil.
+func NewNotifier(cfg config.NotificationConfig, templates *Templates) Notifier {
+	if cfg.WebhookURL == "" {
+		return logNotifier{}
+	}
+	return &webhookNotifier{
+		url:       cfg.WebhookURL,
+		secret:    cfg.SigningSecret,
+		templates: templates,
+		client:    &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}
+}
+
+type logNotifier struct{}
+
+func (logNotifier) Notify(ctx context.Context, n Notification) error {
+	logrus.Infof("Notification %s for user %s (subscription %s)", n.Type, n.UserID, n.SubscriptionID)
+	return nil
+}
+
+type webhookNotifier struct {
+	url       string
+	secret    string
+	templates *Templates
+	client    *http.Client
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := w.templates.Render(ctx, n)
+	if err != nil {
+		return fmt.Errorf("failed to render notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
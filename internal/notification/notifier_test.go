@@ -0,0 +1,57 @@
+package notification
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifierSignsBody(t *testing.T) {
+	var received Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), r.Header.Get(SignatureHeader))
+		require.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(config.NotificationConfig{WebhookURL: server.URL, SigningSecret: "secret", Timeout: 5}, nil)
+	err := notifier.Notify(context.Background(), Notification{
+		Type:           TypeRenewalReminder,
+		UserID:         "user-1",
+		SubscriptionID: "sub-1",
+		Data:           map[string]interface{}{"days_left": 3},
+		CreatedAt:      time.Now(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, TypeRenewalReminder, received.Type)
+	assert.Equal(t, "sub-1", received.SubscriptionID)
+	assert.Equal(t, float64(3), received.Data["days_left"])
+}
+
+func TestWebhookNotifierFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(config.NotificationConfig{WebhookURL: server.URL, Timeout: 5}, nil)
+	err := notifier.Notify(context.Background(), Notification{Type: TypeExpiring, UserID: "user-1"})
+	assert.EqualError(t, err, "notification webhook returned status 502")
+}
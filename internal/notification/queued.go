@@ -0,0 +1,38 @@
+package notification
+
+import (
+	"context"
+
+	"scalable-paywall/internal/jobs"
+)
+
+// JobDeliver delivers one queued notification.
+const JobDeliver = "notification.deliver"
+
+// NewQueuedNotifier returns a Notifier that enqueues notifications as jobs,
+// so webhook delivery is retried with backoff and survives restarts.
+// Register delivery with RegisterJobs on every instance running the jobs.
+func NewQueuedNotifier(queue *jobs.Queue) Notifier {
+	return &queuedNotifier{queue: queue}
+}
+
+type queuedNotifier struct {
+	queue *jobs.Queue
+}
+
+func (q *queuedNotifier) Notify(ctx context.Context, n Notification) error {
+	_, err := q.queue.Enqueue(ctx, JobDeliver, n, jobs.EnqueueOptions{})
+	return err
+}
+
+// RegisterJobs delivers queued notifications through delivery, typically
+// the notifier returned by NewNotifier.
+func RegisterJobs(runner *jobs.Runner, delivery Notifier) {
+	runner.Handle(JobDeliver, func(ctx context.Context, job *jobs.Job) error {
+		var n Notification
+		if err := job.Decode(&n); err != nil {
+			return err
+		}
+		return delivery.Notify(ctx, n)
+	})
+}
@@ -0,0 +1,332 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/i18n"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Template channels. The email is rendered first and handed to the webhook
+// payload, so the receiver sending emails gets it ready to send.
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// Where a template in use comes from: the tenant's own, the default stored
+// for every tenant, or the one built in.
+const (
+	SourceTenant  = "tenant"
+	SourceDefault = "default"
+	SourceBuiltin = "builtin"
+)
+
+var ErrUnknownTemplate = errors.New("unknown notification type or channel")
+
+// RenderError is returned for a template that fails to parse or render,
+// or for a webhook that doesn't render to JSON
+type RenderError struct {
+	Err error
+}
+
+func (e *RenderError) Error() string { return e.Err.Error() }
+
+func (e *RenderError) Unwrap() error { return e.Err }
+
+// Template renders one channel of a notification type. Email templates
+// have a text Subject and an HTML Body; webhook templates only a Body,
+// which must render to JSON. Templates use Go template syntax over a
+// Message, with the json and date functions. Locale is the BCP 47 tag of
+// the users a stored template is for, or empty for any locale.
+type Template struct {
+	TenantID  string     `json:"tenant_id,omitempty"`
+	Locale    string     `json:"locale,omitempty"`
+	Type      string     `json:"type"`
+	Channel   string     `json:"channel"`
+	Subject   string     `json:"subject,omitempty"`
+	Body      string     `json:"body"`
+	Source    string     `json:"source"`
+	UpdatedBy *string    `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Message is what templates render: the notification and, for webhook
+// payloads, the email rendered for it
+type Message struct {
+	Notification
+	Email *Rendered `json:"email,omitempty"`
+}
+
+// Rendered is a rendered template; only emails have a Subject
+type Rendered struct {
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body"`
+}
+
+// builtinTemplates are used for a type and channel no template is stored
+// for. The webhook payload is the notification as JSON, with its email.
+var builtinTemplates = map[string]map[string]Template{
+	TypeRenewalReminder: {
+		ChannelEmail: {
+			Subject: `Your subscription renews in {{.Data.days_left}} days`,
+			Body: `<p>Your subscription renews on {{date .Data.end_date}} for {{.Data.amount}} {{.Data.currency}}.</p>
+<p>No action is needed to keep it.</p>`,
+		},
+		ChannelWebhook: {Body: `{{json .}}`},
+	},
+	TypeExpiring: {
+		ChannelEmail: {
+			Subject: `Your subscription ends in {{.Data.days_left}} days`,
+			Body: `<p>Your subscription ends on {{date .Data.end_date}} and won't renew.</p>
+<p>Turn on auto-renew to keep your access.</p>`,
+		},
+		ChannelWebhook: {Body: `{{json .}}`},
+	},
+}
+
+// sampleNotifications are what previews render when no data is given
+var sampleNotifications = map[string]Notification{
+	TypeRenewalReminder: {
+		Type:           TypeRenewalReminder,
+		UserID:         "00000000-0000-0000-0000-000000000001",
+		SubscriptionID: "00000000-0000-0000-0000-000000000002",
+		Data: map[string]interface{}{
+			"plan_id":    "00000000-0000-0000-0000-000000000003",
+			"end_date":   "2026-01-31T00:00:00Z",
+			"days_left":  7,
+			"auto_renew": true,
+			"amount":     "9.99",
+			"currency":   "USD",
+		},
+	},
+	TypeExpiring: {
+		Type:           TypeExpiring,
+		UserID:         "00000000-0000-0000-0000-000000000001",
+		SubscriptionID: "00000000-0000-0000-0000-000000000002",
+		Data: map[string]interface{}{
+			"plan_id":    "00000000-0000-0000-0000-000000000003",
+			"end_date":   "2026-01-31T00:00:00Z",
+			"days_left":  1,
+			"auto_renew": false,
+			"amount":     "9.99",
+			"currency":   "USD",
+		},
+	},
+}
+
+// sampleMessage is the sample notification of a type with its email
+// rendered by the built-in template, which updates are checked against
+func sampleMessage(notificationType string) Message {
+	message := Message{Notification: sampleNotifications[notificationType]}
+	email := builtinTemplates[notificationType][ChannelEmail]
+	email.Channel = ChannelEmail
+	message.Email, _ = email.Render(message)
+	return message
+}
+
+var templateFuncs = map[string]interface{}{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	// date formats a time, or one in RFC 3339 as notifications carry them
+	// once queued, as e.g. 31 January 2026
+	"date": func(v interface{}) string {
+		switch t := v.(type) {
+		case time.Time:
+			return t.Format("2 January 2006")
+		case string:
+			if parsed, err := time.Parse(time.RFC3339, t); err == nil {
+				return parsed.Format("2 January 2006")
+			}
+			return t
+		}
+		return fmt.Sprint(v)
+	},
+}
+
+// Templates keeps the notification templates of each tenant and locale,
+// and translates the built-in ones with the bundle. A nil *Templates
+// renders with the built-in templates, in English, only.
+type Templates struct {
+	db     *db.Connection
+	bundle *i18n.Bundle
+}
+
+func NewTemplates(db *db.Connection, bundle *i18n.Bundle) *Templates {
+	return &Templates{db: db, bundle: bundle}
+}
+
+// Types lists the notification types that have templates
+func Types() []string {
+	types := make([]string, 0, len(builtinTemplates))
+	for notificationType := range builtinTemplates {
+		types = append(types, notificationType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Get returns the template tenantID uses for a type and channel in locale:
+// its own, else the stored default, else the built-in one translated. The
+// tenant's and then the default's templates are looked for in the locale's
+// fallbacks (de-AT, de, the default locale), then for any locale.
+func (t *Templates) Get(ctx context.Context, tenantID, locale, notificationType, channel string) (*Template, error) {
+	builtin, ok := t.builtin(locale, notificationType, channel)
+	if !ok {
+		return nil, ErrUnknownTemplate
+	}
+	if t == nil {
+		return &builtin, nil
+	}
+
+	locales := append(t.bundle.Fallbacks(locale), "")
+	var tmpl Template
+	err := t.db.QueryRowContext(ctx, `
+		SELECT tenant_id, locale, subject, body, updated_by, updated_at
+		FROM notification_templates
+		WHERE tenant_id IN ($1, '') AND locale = ANY($4::text[]) AND type = $2 AND channel = $3
+		ORDER BY tenant_id DESC, array_position($4::text[], locale::text)
+		LIMIT 1
+	`, tenantID, notificationType, channel, locales).Scan(&tmpl.TenantID, &tmpl.Locale, &tmpl.Subject,
+		&tmpl.Body, &tmpl.UpdatedBy, &tmpl.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return &builtin, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tmpl.Type, tmpl.Channel, tmpl.Source = notificationType, channel, SourceDefault
+	if tmpl.TenantID != "" {
+		tmpl.Source = SourceTenant
+	}
+	return &tmpl, nil
+}
+
+// builtin returns the built-in template of a type and channel, its text
+// translated into locale line by line
+func (t *Templates) builtin(locale, notificationType, channel string) (Template, bool) {
+	tmpl, ok := builtinTemplates[notificationType][channel]
+	if !ok {
+		return Template{}, false
+	}
+	tmpl.Type, tmpl.Channel, tmpl.Source = notificationType, channel, SourceBuiltin
+	if t != nil {
+		tmpl.Subject = t.bundle.Translate(locale, tmpl.Subject)
+		tmpl.Body = t.bundle.Translate(locale, tmpl.Body)
+	}
+	return tmpl, true
+}
+
+// Save stores tenantID's template for a type, channel and locale, or the
+// default for every tenant when tenantID is empty, after checking it
+// renders.
+func (t *Templates) Save(ctx context.Context, tmpl *Template, updatedBy string) error {
+	if _, ok := builtinTemplates[tmpl.Type][tmpl.Channel]; !ok {
+		return ErrUnknownTemplate
+	}
+	if _, err := tmpl.Render(sampleMessage(tmpl.Type)); err != nil {
+		return &RenderError{Err: err}
+	}
+	return t.db.QueryRowContext(ctx, `
+		INSERT INTO notification_templates (tenant_id, locale, type, channel, subject, body, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+		ON CONFLICT (tenant_id, locale, type, channel) DO UPDATE
+		SET subject = EXCLUDED.subject, body = EXCLUDED.body,
+			updated_by = EXCLUDED.updated_by, updated_at = NOW()
+		RETURNING updated_by, updated_at
+	`, tmpl.TenantID, tmpl.Locale, tmpl.Type, tmpl.Channel, tmpl.Subject, tmpl.Body, updatedBy,
+	).Scan(&tmpl.UpdatedBy, &tmpl.UpdatedAt)
+}
+
+// Render renders n with its tenant's templates in its locale: its email,
+// then the webhook payload carrying it. A stored template that fails to
+// render is logged and the built-in one used instead, so a bad edit can't
+// stop notifications.
+func (t *Templates) Render(ctx context.Context, n Notification) ([]byte, error) {
+	if _, ok := builtinTemplates[n.Type]; !ok {
+		// Types without templates are sent as they are
+		return json.Marshal(Message{Notification: n})
+	}
+
+	message := Message{Notification: n}
+	render := func(channel string) (*Rendered, error) {
+		tmpl, err := t.Get(ctx, n.TenantID, n.Locale, n.Type, channel)
+		if err != nil {
+			return nil, err
+		}
+		rendered, err := tmpl.Render(message)
+		if err != nil && tmpl.Source != SourceBuiltin {
+			logrus.Errorf("Failed to render %s %s template of tenant %q, using the built-in one: %v",
+				n.Type, channel, n.TenantID, err)
+			builtin, _ := t.builtin(n.Locale, n.Type, channel)
+			rendered, err = builtin.Render(message)
+		}
+		return rendered, err
+	}
+
+	email, err := render(ChannelEmail)
+	if err != nil {
+		return nil, err
+	}
+	message.Email = email
+	payload, err := render(ChannelWebhook)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(payload.Body), nil
+}
+
+// Render renders the template over message. The email subject is plain
+// text and its body HTML-escaped; a webhook body must come out as JSON.
+func (tmpl *Template) Render(message Message) (*Rendered, error) {
+	if tmpl.Channel == ChannelWebhook {
+		body, err := renderText(tmpl.Body, message)
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid([]byte(body)) {
+			return nil, errors.New("webhook template does not render to JSON")
+		}
+		return &Rendered{Body: body}, nil
+	}
+
+	subject, err := renderText(tmpl.Subject, message)
+	if err != nil {
+		return nil, fmt.Errorf("subject: %w", err)
+	}
+	parsed, err := htmltemplate.New("body").Funcs(templateFuncs).Parse(tmpl.Body)
+	if err != nil {
+		return nil, fmt.Errorf("body: %w", err)
+	}
+	var body bytes.Buffer
+	if err := parsed.Execute(&body, message); err != nil {
+		return nil, fmt.Errorf("body: %w", err)
+	}
+	return &Rendered{Subject: strings.TrimSpace(subject), Body: body.String()}, nil
+}
+
+func renderText(text string, data interface{}) (string, error) {
+	parsed, err := texttemplate.New("text").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := parsed.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
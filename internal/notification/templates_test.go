@@ -0,0 +1,148 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/i18n"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderBuiltinTemplates(t *testing.T) {
+	var templates *Templates
+	n := sampleNotifications[TypeRenewalReminder]
+	n.TenantID = "acme"
+	// Queued notifications come back with their data decoded from JSON
+	n.Data = map[string]interface{}{"end_date": "2026-01-31T00:00:00Z", "days_left": float64(7), "amount": "9.99", "currency": "USD"}
+
+	body, err := templates.Render(context.Background(), n)
+	require.NoError(t, err)
+
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, TypeRenewalReminder, payload["type"])
+	assert.Equal(t, "acme", payload["tenant_id"])
+	assert.Equal(t, n.UserID, payload["user_id"])
+	email := payload["email"].(map[string]interface{})
+	assert.Equal(t, "Your subscription renews in 7 days", email["subject"])
+	assert.Contains(t, email["body"], "31 January 2026 for 9.99 USD")
+
+	// Types without templates are sent as they are
+	body, err = templates.Render(context.Background(), Notification{Type: "subscription.other", UserID: "u1"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type": "subscription.other", "user_id": "u1", "created_at": "0001-01-01T00:00:00Z"}`, string(body))
+}
+
+func TestBuiltinTemplatesTranslated(t *testing.T) {
+	bundle, err := i18n.Load(config.I18nConfig{DefaultLocale: "en"})
+	require.NoError(t, err)
+	templates := &Templates{bundle: bundle}
+
+	// Every line of the built-in emails is translated, so a changed English
+	// line doesn't silently go out untranslated
+	for _, locale := range []string{"de", "fr", "es"} {
+		for _, notificationType := range Types() {
+			english := builtinTemplates[notificationType][ChannelEmail]
+			tmpl, ok := templates.builtin(locale, notificationType, ChannelEmail)
+			require.True(t, ok)
+			assert.NotEqual(t, english.Subject, tmpl.Subject, "%s %s", locale, notificationType)
+			englishLines, lines := strings.Split(english.Body, "\n"), strings.Split(tmpl.Body, "\n")
+			for i := range englishLines {
+				assert.NotEqual(t, englishLines[i], lines[i], "%s %s", locale, notificationType)
+			}
+		}
+	}
+
+	n := sampleNotifications[TypeExpiring]
+	n.Locale = "de-AT"
+	n.Data = map[string]interface{}{"end_date": "2026-01-31T00:00:00Z", "days_left": float64(1)}
+	tmpl, _ := templates.builtin(n.Locale, TypeExpiring, ChannelEmail)
+	rendered, err := tmpl.Render(Message{Notification: n})
+	require.NoError(t, err)
+	assert.Equal(t, "Ihr Abonnement endet in 1 Tagen", rendered.Subject)
+}
+
+func TestTemplateRender(t *testing.T) {
+	message := sampleMessage(TypeExpiring)
+	message.Data = map[string]interface{}{"end_date": "2026-01-31T00:00:00Z", "plan_id": "<b>pro</b>"}
+
+	email := Template{Channel: ChannelEmail, Subject: "Ends {{date .Data.end_date}} <{{.Data.plan_id}}>", Body: "<p>{{.Data.plan_id}}</p>"}
+	rendered, err := email.Render(message)
+	require.NoError(t, err)
+	// Subjects are plain text, bodies are escaped HTML
+	assert.Equal(t, "Ends 31 January 2026 <<b>pro</b>>", rendered.Subject)
+	assert.Equal(t, "<p>&lt;b&gt;pro&lt;/b&gt;</p>", rendered.Body)
+
+	webhook := Template{Channel: ChannelWebhook, Body: `{"event": {{json .Type}}, "subject": {{json .Email.Subject}}}`}
+	rendered, err = webhook.Render(message)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"event": "subscription.expiring", "subject": "Your subscription ends in 1 days"}`, rendered.Body)
+
+	_, err = (&Template{Channel: ChannelWebhook, Body: `event={{.Type}}`}).Render(message)
+	assert.EqualError(t, err, "webhook template does not render to JSON")
+
+	_, err = (&Template{Channel: ChannelEmail, Subject: "{{.Missing", Body: ""}).Render(message)
+	assert.Error(t, err)
+
+	date := templateFuncs["date"].(func(interface{}) string)
+	assert.Equal(t, "31 January 2026", date(time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "soon", date("soon"))
+}
+
+func TestPreviewTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var templates *Templates
+
+	preview := func(notificationType, channel, body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		c.Params = gin.Params{{Key: "type", Value: notificationType}, {Key: "channel", Value: channel}}
+		templates.PreviewTemplate(c)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, response := preview(TypeRenewalReminder, ChannelEmail, `{"subject": "Hi, {{.Data.name}}", "data": {"name": "Ada"}}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Hi, Ada", response["subject"])
+
+	code, response = preview(TypeRenewalReminder, ChannelWebhook, `{"body": "{\"subject\": {{json .Email.Subject}}}"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"subject": "Your subscription renews in 7 days"}`, response["body"])
+
+	code, _ = preview(TypeRenewalReminder, ChannelWebhook, `{"body": "not json"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = preview("subscription.unknown", ChannelEmail, `{}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestWebhookNotifierSendsRenderedPayload(t *testing.T) {
+	var received map[string]interface{}
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(config.NotificationConfig{WebhookURL: server.URL, SigningSecret: "secret", Timeout: 5}, nil)
+	require.NoError(t, notifier.Notify(context.Background(), sampleNotifications[TypeExpiring]))
+
+	assert.Equal(t, TypeExpiring, received["type"])
+	assert.Contains(t, received, "email")
+	assert.NotEmpty(t, signature)
+}
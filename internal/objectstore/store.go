@@ -0,0 +1,66 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"scalable-paywall/internal/config"
+)
+
+// Store writes objects to cold storage. Keys are slash-separated paths
+// under the configured prefix; writing an existing key replaces it.
+type Store interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+}
+
+// New builds the Store selected by cfg.Provider. It returns a nil Store
+// when no object store is configured.
+func New(ctx context.Context, cfg config.ObjectStoreConfig) (Store, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "":
+		return nil, nil
+	case "s3", "gcs":
+		return newS3Store(ctx, cfg)
+	case "file":
+		return &fileStore{dir: cfg.Directory, prefix: cfg.Prefix}, nil
+	default:
+		return nil, fmt.Errorf("unknown object store provider %q", cfg.Provider)
+	}
+}
+
+// fileStore keeps objects as files under a directory, for development and
+// for stores mounted into the filesystem.
+type fileStore struct {
+	dir    string
+	prefix string
+}
+
+func (s *fileStore) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	name := filepath.Join(s.dir, filepath.FromSlash(objectKey(s.prefix, key)))
+	if err := os.MkdirAll(filepath.Dir(name), 0o750); err != nil {
+		return err
+	}
+	// Write aside and rename so a reader never sees a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// objectKey places key under prefix
+func objectKey(prefix, key string) string {
+	return strings.TrimPrefix(path.Join(prefix, key), "/")
+}
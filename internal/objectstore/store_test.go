@@ -0,0 +1,40 @@
+package objectstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectURL(t *testing.T) {
+	key := "archive/webhook_events/2026/01/02/a b.ndjson.gz"
+
+	assert.Equal(t, "https://paywall.s3.eu-west-1.amazonaws.com/archive/webhook_events/2026/01/02/a%20b.ndjson.gz",
+		objectURL(config.ObjectStoreConfig{Provider: "s3", Bucket: "paywall", Region: "eu-west-1"}, key))
+	assert.Equal(t, "https://storage.googleapis.com/paywall/archive/webhook_events/2026/01/02/a%20b.ndjson.gz",
+		objectURL(config.ObjectStoreConfig{Provider: "gcs", Bucket: "paywall"}, key))
+	assert.Equal(t, "http://localhost:9000/paywall/archive/webhook_events/2026/01/02/a%20b.ndjson.gz",
+		objectURL(config.ObjectStoreConfig{Provider: "s3", Bucket: "paywall", Endpoint: "http://localhost:9000/"}, key))
+}
+
+func TestFileStorePut(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(context.Background(), config.ObjectStoreConfig{Provider: "file", Directory: dir, Prefix: "/cold"})
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(context.Background(), "retention/usage_logs/part.ndjson.gz", []byte("rows"), "application/gzip"))
+
+	data, err := os.ReadFile(filepath.Join(dir, "cold", "retention", "usage_logs", "part.ndjson.gz"))
+	require.NoError(t, err)
+	assert.Equal(t, "rows", string(data))
+
+	none, err := New(context.Background(), config.ObjectStoreConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, none)
+}
@@ -0,0 +1,570 @@
+package payment
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/money"
+	"scalable-paywall/internal/risk"
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/telemetry"
+	"scalable-paywall/internal/vat"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// Checkout session states. An open session past its expiry is reported as
+// expired.
+const (
+	checkoutOpen      = "open"
+	checkoutCompleted = "completed"
+	checkoutExpired   = "expired"
+)
+
+// checkoutLease is how long completing a session holds it; the charge must
+// finish within half of it, as renewal charges do
+const checkoutLease = time.Minute
+
+// errCheckoutClosed rolls back the subscription a completion would create
+// when the session is no longer open
+var errCheckoutClosed = errors.New("checkout session is not open")
+
+// CheckoutSession fixes the plan, price and coupon of a checkout for the
+// hosted or embedded page that completes it. Amount is the first charge,
+// after the coupon; Price is what renewals charge, of which PercentOff
+// comes off the DiscountRenewals renewals after the first. A business
+// customer's VATID, confirmed by VIES, decides the TaxTreatment.
+type CheckoutSession struct {
+	ID               string          `json:"id"`
+	UserID           string          `json:"user_id"`
+	PlanID           string          `json:"plan_id"`
+	Coupon           *string         `json:"coupon,omitempty"`
+	PercentOff       int             `json:"percent_off,omitempty"`
+	DiscountRenewals int             `json:"discount_renewals,omitempty"`
+	Price            decimal.Decimal `json:"price"`
+	Amount           decimal.Decimal `json:"amount"`
+	Currency         string          `json:"currency"`
+	VATID            *string         `json:"vat_id,omitempty"`
+	TaxTreatment     string          `json:"tax_treatment"`
+	AutoRenew        bool            `json:"auto_renew"`
+	SuccessURL       string          `json:"success_url"`
+	CancelURL        string          `json:"cancel_url"`
+	Status           string          `json:"status"`
+	SubscriptionID   *string         `json:"subscription_id,omitempty"`
+	ExpiresAt        time.Time       `json:"expires_at"`
+	CompletedAt      *time.Time      `json:"completed_at,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+type CreateCheckoutSessionRequest struct {
+	UserID     string `json:"user_id" binding:"required"`
+	PlanID     string `json:"plan_id" binding:"required"`
+	Coupon     string `json:"coupon" binding:"max=64"`
+	VATID      string `json:"vat_id" binding:"max=32"`
+	AutoRenew  *bool  `json:"auto_renew"`
+	SuccessURL string `json:"success_url" binding:"required,url"`
+	CancelURL  string `json:"cancel_url" binding:"required,url"`
+}
+
+// CheckoutSessionResponse is a new session with the token that completes
+// it, which is only shown here. URL is the hosted checkout page for it,
+// when payment.checkout.hosted_url is set.
+type CheckoutSessionResponse struct {
+	*CheckoutSession
+	Token string `json:"token"`
+	URL   string `json:"url,omitempty"`
+}
+
+type CompleteCheckoutRequest struct {
+	PaymentMethod string `json:"payment_method" binding:"required,payment_token"`
+}
+
+// CheckoutCompletion is a completed session with the subscription it
+// created and, unless a coupon made the first period free, the charge that
+// paid for it. RedirectURL is where the page sends the customer next.
+type CheckoutCompletion struct {
+	Session      *CheckoutSession           `json:"session"`
+	Subscription *subscription.Subscription `json:"subscription"`
+	Payment      *PaymentResponse           `json:"payment,omitempty"`
+	RedirectURL  string                     `json:"redirect_url"`
+}
+
+// CreateCheckoutSession prices a paid plan for the user, applies the coupon
+// if any, and returns a session that can be completed until it expires
+// (payment.checkout.session_ttl) by the page holding its token. Nothing is
+// charged or created yet.
+func (s *Service) CreateCheckoutSession(c *gin.Context) {
+	var req CreateCheckoutSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPaymentOperation("create_checkout", "validation_error")
+		return
+	}
+	for _, returnURL := range []string{req.SuccessURL, req.CancelURL} {
+		if !returnURLAllowed(s.cfg.Checkout.ReturnHosts, returnURL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Return URL %q is not allowed", returnURL)})
+			telemetry.RecordPaymentOperation("create_checkout", "validation_error")
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+
+	if !s.checkoutAllowed(c, "create_checkout", req.UserID, req.PlanID) {
+		return
+	}
+	vatCheck, ok := s.checkVATID(c, "create_checkout", req.UserID, req.VATID)
+	if !ok {
+		return
+	}
+
+	charge, err := s.subscriptionSvc.QuoteCharge(ctx, req.UserID, req.PlanID)
+	if err != nil {
+		if errors.Is(err, subscription.ErrPlanUnavailable) || errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Plan is not available"})
+			telemetry.RecordPaymentOperation("create_checkout", "validation_error")
+			return
+		}
+		logrus.Errorf("Failed to price plan %s: %v", req.PlanID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("create_checkout", "db_error")
+		return
+	}
+
+	session := &CheckoutSession{
+		UserID:       req.UserID,
+		PlanID:       req.PlanID,
+		Price:        charge.Total,
+		Amount:       charge.Total,
+		Currency:     charge.Currency,
+		TaxTreatment: vat.TreatmentStandard,
+		AutoRenew:    req.AutoRenew == nil || *req.AutoRenew,
+		SuccessURL:   req.SuccessURL,
+		CancelURL:    req.CancelURL,
+		Status:       checkoutOpen,
+	}
+	if vatCheck != nil {
+		session.VATID = &vatCheck.VATID
+		session.TaxTreatment = s.taxTreatment(vatCheck.VATID)
+	}
+	if req.Coupon != "" {
+		coupon := findCoupon(s.cfg.Checkout.Coupons, req.Coupon, req.PlanID)
+		if coupon == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon is not valid for this plan"})
+			telemetry.RecordPaymentOperation("create_checkout", "invalid_coupon")
+			return
+		}
+		session.applyCoupon(coupon)
+	}
+
+	token := randomHex(32)
+	if err := s.storeCheckoutSession(ctx, session, hashCheckoutToken(token)); err != nil {
+		logrus.Errorf("Failed to store checkout session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("create_checkout", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusCreated, CheckoutSessionResponse{
+		CheckoutSession: session,
+		Token:           token,
+		URL:             hostedCheckoutURL(s.cfg.Checkout.HostedURL, token),
+	})
+	telemetry.RecordPaymentOperation("create_checkout", "success")
+}
+
+// GetCheckoutSession returns the session a token belongs to, for the page
+// completing it to show the plan, price and coupon.
+func (s *Service) GetCheckoutSession(c *gin.Context) {
+	session, err := s.getCheckoutSession(c.Request.Context(), hashCheckoutToken(c.Param("token")))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Checkout session not found"})
+			telemetry.RecordPaymentOperation("get_checkout", "not_found")
+			return
+		}
+		logrus.Errorf("Failed to get checkout session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("get_checkout", "db_error")
+		return
+	}
+	c.JSON(http.StatusOK, session)
+	telemetry.RecordPaymentOperation("get_checkout", "success")
+}
+
+// CompleteCheckoutSession charges the session's first payment to the
+// customer's payment method and, in one transaction, creates the
+// subscription and marks the session completed, so a session buys exactly
+// one subscription. Completing a completed session answers with what it
+// created. A declined charge answers 402 and leaves the session open for
+// another payment method; an expired one answers 410. A charge that has not
+// settled yet creates a pending subscription, activated by the gateway's
+// webhook as for payment intents.
+func (s *Service) CompleteCheckoutSession(c *gin.Context) {
+	var req CompleteCheckoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPaymentOperation("complete_checkout", "validation_error")
+		return
+	}
+
+	ctx := c.Request.Context()
+	tokenHash := hashCheckoutToken(c.Param("token"))
+
+	session, err := s.getCheckoutSession(ctx, tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Checkout session not found"})
+			telemetry.RecordPaymentOperation("complete_checkout", "not_found")
+			return
+		}
+		logrus.Errorf("Failed to get checkout session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("complete_checkout", "db_error")
+		return
+	}
+	switch session.Status {
+	case checkoutCompleted:
+		s.respondCompletedCheckout(c, session)
+		return
+	case checkoutExpired:
+		c.JSON(http.StatusGone, gin.H{"error": "Checkout session has expired"})
+		telemetry.RecordPaymentOperation("complete_checkout", "expired")
+		return
+	}
+
+	charged := session.Amount.IsPositive()
+	if charged {
+		if !s.checkRisk(c, "complete_checkout", risk.Attempt{
+			UserID:        session.UserID,
+			IP:            c.ClientIP(),
+			PaymentMethod: req.PaymentMethod,
+			PlanID:        session.PlanID,
+			Amount:        session.Amount,
+			Currency:      session.Currency,
+		}) {
+			return
+		}
+		if !s.circuitBreaker.CanExecute() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment service temporarily unavailable"})
+			telemetry.RecordPaymentOperation("complete_checkout", "circuit_breaker_open")
+			return
+		}
+	}
+
+	claimed, err := s.claimCheckoutSession(ctx, session.ID, checkoutLease)
+	if err != nil {
+		logrus.Errorf("Failed to claim checkout session %s: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("complete_checkout", "db_error")
+		return
+	}
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Checkout session is being completed"})
+		telemetry.RecordPaymentOperation("complete_checkout", "conflict")
+		return
+	}
+
+	methodHash := sha256.Sum256([]byte(req.PaymentMethod))
+	payment := PaymentRequest{
+		UserID:        session.UserID,
+		PlanID:        session.PlanID,
+		Amount:        session.Amount,
+		Currency:      session.Currency,
+		PaymentMethod: req.PaymentMethod,
+		Description:   "Subscription checkout",
+		// Retrying the same payment method replays its charge
+		IdempotencyKey: "checkout-" + session.ID + "-" + hex.EncodeToString(methodHash[:8]),
+	}
+	var response *PaymentResponse
+	if charged {
+		chargeCtx, cancel := context.WithTimeout(ctx, checkoutLease/2)
+		response, err = s.processPaymentThroughGateway(chargeCtx, payment)
+		cancel()
+		if err != nil {
+			s.releaseCheckoutSession(ctx, session.ID)
+			var decline *DeclineError
+			if errors.As(err, &decline) {
+				if err := s.storeDeclinedTransaction(ctx, payment, decline); err != nil {
+					logrus.Errorf("Failed to store declined transaction for checkout session %s: %v", session.ID, err)
+				}
+				c.JSON(http.StatusPaymentRequired, declinedResponse(decline))
+				telemetry.RecordPaymentOperation("complete_checkout", "declined")
+				return
+			}
+			s.circuitBreaker.RecordFailure()
+			logrus.Errorf("Checkout charge for session %s failed: %v", session.ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Payment gateway error"})
+			telemetry.RecordPaymentOperation("complete_checkout", "gateway_error")
+			return
+		}
+		s.circuitBreaker.RecordSuccess()
+	}
+
+	pending := response != nil && response.Status == chargePending
+	sub, err := s.subscriptionSvc.CreateFromCheckout(ctx, subscription.CheckoutOrder{
+		UserID:           session.UserID,
+		PlanID:           session.PlanID,
+		PaymentMethod:    req.PaymentMethod,
+		Amount:           session.Price,
+		Currency:         session.Currency,
+		AutoRenew:        session.AutoRenew,
+		DiscountPercent:  session.PercentOff,
+		DiscountRenewals: session.DiscountRenewals,
+		Pending:          pending,
+	}, func(tx *sql.Tx, sub *subscription.Subscription) error {
+		if err := completeCheckoutSession(ctx, tx, session.ID, sub.ID); err != nil {
+			return err
+		}
+		if !pending {
+			return nil
+		}
+		// Settled by the payment_intent webhooks, as checkout intents are
+		return storeIntent(ctx, tx, &PaymentIntent{
+			Gateway:         s.gatewayFor(ctx, session.UserID, session.Currency).Name(),
+			GatewayIntentID: response.GatewayID,
+			SubscriptionID:  sub.ID,
+			UserID:          session.UserID,
+			PlanID:          session.PlanID,
+			Amount:          session.Amount,
+			Currency:        session.Currency,
+			Status:          intentPending,
+		})
+	})
+	if err != nil {
+		s.releaseCheckoutSession(ctx, session.ID)
+		if response != nil {
+			s.refundUnusedCheckout(ctx, session, response)
+		}
+		if errors.Is(err, subscription.ErrAlreadySubscribed) {
+			c.JSON(http.StatusConflict, gin.H{"error": "User already has an active subscription"})
+			telemetry.RecordPaymentOperation("complete_checkout", "conflict")
+			return
+		}
+		logrus.Errorf("Failed to complete checkout session %s: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("complete_checkout", "db_error")
+		return
+	}
+
+	if response != nil {
+		payment.SubscriptionID = sub.ID
+		if err := s.storeTransaction(ctx, payment, response); err != nil {
+			logrus.Errorf("Failed to store transaction for checkout session %s: %v", session.ID, err)
+		}
+	}
+	if pending && s.grantsPendingAccess() {
+		if _, err := s.subscriptionSvc.ActivatePending(ctx, sub.ID); err != nil {
+			logrus.Errorf("Failed to activate subscription %s while its payment settles: %v", sub.ID, err)
+		}
+	}
+
+	completed, err := s.getCheckoutSession(ctx, tokenHash)
+	if err != nil {
+		logrus.Errorf("Failed to reload checkout session %s: %v", session.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("complete_checkout", "db_error")
+		return
+	}
+	subscriptionID := sub.ID
+	if sub, err = s.subscriptionSvc.GetSubscriptionByID(ctx, subscriptionID); err != nil {
+		logrus.Errorf("Failed to get subscription %s: %v", subscriptionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("complete_checkout", "db_error")
+		return
+	}
+
+	completion := CheckoutCompletion{Session: completed, Subscription: sub, Payment: response, RedirectURL: completed.SuccessURL}
+	if pending {
+		c.JSON(http.StatusAccepted, completion)
+		telemetry.RecordPaymentOperation("complete_checkout", "pending")
+		return
+	}
+	c.JSON(http.StatusOK, completion)
+	telemetry.RecordPaymentOperation("complete_checkout", "success")
+}
+
+// respondCompletedCheckout answers a repeated completion with the
+// subscription the session created
+func (s *Service) respondCompletedCheckout(c *gin.Context, session *CheckoutSession) {
+	completion := CheckoutCompletion{Session: session, RedirectURL: session.SuccessURL}
+	if session.SubscriptionID != nil {
+		sub, err := s.subscriptionSvc.GetSubscriptionByID(c.Request.Context(), *session.SubscriptionID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			logrus.Errorf("Failed to get subscription %s: %v", *session.SubscriptionID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			telemetry.RecordPaymentOperation("complete_checkout", "db_error")
+			return
+		}
+		completion.Subscription = sub
+	}
+	c.JSON(http.StatusOK, completion)
+	telemetry.RecordPaymentOperation("complete_checkout", "already_completed")
+}
+
+// refundUnusedCheckout gives back a charge whose subscription could not be
+// created, e.g. because the user subscribed elsewhere meanwhile
+func (s *Service) refundUnusedCheckout(ctx context.Context, session *CheckoutSession, response *PaymentResponse) {
+	if _, err := s.gatewayFor(ctx, session.UserID, session.Currency).Refund(ctx, response.GatewayID, response.Amount, response.Currency); err != nil {
+		logrus.Errorf("Failed to refund charge %s of checkout session %s: %v", response.GatewayID, session.ID, err)
+	}
+}
+
+// applyCoupon discounts the first charge and records the renewals the
+// coupon also discounts
+func (session *CheckoutSession) applyCoupon(coupon *config.CouponConfig) {
+	code := coupon.Code
+	session.Coupon = &code
+	session.PercentOff = coupon.PercentOff
+	session.DiscountRenewals = coupon.Renewals
+	factor := decimal.NewFromInt(int64(100 - coupon.PercentOff)).Div(decimal.NewFromInt(100))
+	session.Amount = money.Round(session.Price.Mul(factor), session.Currency)
+}
+
+// findCoupon returns the coupon with code, matched case-insensitively, if it
+// applies to planID
+func findCoupon(coupons []config.CouponConfig, code, planID string) *config.CouponConfig {
+	for i := range coupons {
+		coupon := &coupons[i]
+		if !strings.EqualFold(coupon.Code, code) {
+			continue
+		}
+		if len(coupon.PlanIDs) == 0 {
+			return coupon
+		}
+		for _, id := range coupon.PlanIDs {
+			if id == planID {
+				return coupon
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// returnURLAllowed reports whether raw is an http(s) URL on one of hosts, or
+// on any host when hosts is empty
+func returnURLAllowed(hosts []string, raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return false
+	}
+	if len(hosts) == 0 {
+		return true
+	}
+	for _, host := range hosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostedCheckoutURL is the hosted page's URL with the session token added,
+// or empty when there is no hosted page
+func hostedCheckoutURL(hostedURL, token string) string {
+	if hostedURL == "" {
+		return ""
+	}
+	u, err := url.Parse(hostedURL)
+	if err != nil {
+		return ""
+	}
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// hashCheckoutToken is what is stored of a session token, so a database
+// leak doesn't hand out sessions that can be paid for
+func hashCheckoutToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Service) storeCheckoutSession(ctx context.Context, session *CheckoutSession, tokenHash string) error {
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO checkout_sessions (token_hash, user_id, plan_id, coupon_code, percent_off,
+			discount_renewals, price, amount, currency, vat_id, tax_treatment, auto_renew, success_url,
+			cancel_url, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NOW() + make_interval(secs => $15))
+		RETURNING id, expires_at, created_at
+	`, tokenHash, session.UserID, session.PlanID, session.Coupon, session.PercentOff,
+		session.DiscountRenewals, session.Price, session.Amount, session.Currency, session.VATID,
+		session.TaxTreatment, session.AutoRenew, session.SuccessURL, session.CancelURL, s.cfg.Checkout.SessionTTL,
+	).Scan(&session.ID, &session.ExpiresAt, &session.CreatedAt)
+}
+
+func (s *Service) getCheckoutSession(ctx context.Context, tokenHash string) (*CheckoutSession, error) {
+	var session CheckoutSession
+	var expired bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, plan_id, coupon_code, percent_off, discount_renewals, price, amount,
+			currency, vat_id, tax_treatment, auto_renew, success_url, cancel_url, status,
+			subscription_id, expires_at, completed_at, created_at, expires_at <= NOW()
+		FROM checkout_sessions WHERE token_hash = $1
+	`, tokenHash).Scan(&session.ID, &session.UserID, &session.PlanID, &session.Coupon,
+		&session.PercentOff, &session.DiscountRenewals, &session.Price, &session.Amount,
+		&session.Currency, &session.VATID, &session.TaxTreatment, &session.AutoRenew, &session.SuccessURL, &session.CancelURL,
+		&session.Status, &session.SubscriptionID, &session.ExpiresAt, &session.CompletedAt,
+		&session.CreatedAt, &expired)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status == checkoutOpen && expired {
+		session.Status = checkoutExpired
+	}
+	return &session, nil
+}
+
+// claimCheckoutSession leases an open, unexpired session to the caller and
+// reports whether it got it
+func (s *Service) claimCheckoutSession(ctx context.Context, id string, lease time.Duration) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE checkout_sessions SET claimed_until = NOW() + make_interval(secs => $2)
+		WHERE id = $1 AND status = 'open' AND expires_at > NOW()
+			AND (claimed_until IS NULL OR claimed_until < NOW())
+	`, id, lease.Seconds())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Service) releaseCheckoutSession(ctx context.Context, id string) {
+	if _, err := s.db.ExecContext(ctx, `UPDATE checkout_sessions SET claimed_until = NULL WHERE id = $1`, id); err != nil {
+		logrus.Errorf("Failed to release checkout session %s: %v", id, err)
+	}
+}
+
+// completeCheckoutSession marks a claimed session completed by the
+// subscription it created, within the transaction that creates it
+func completeCheckoutSession(ctx context.Context, tx *sql.Tx, id, subscriptionID string) error {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE checkout_sessions
+		SET status = 'completed', subscription_id = $2, completed_at = NOW(), claimed_until = NULL
+		WHERE id = $1 AND status = 'open'
+	`, id, subscriptionID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errCheckoutClosed
+	}
+	return nil
+}
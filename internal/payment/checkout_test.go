@@ -0,0 +1,56 @@
+package payment
+
+import (
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindCoupon(t *testing.T) {
+	coupons := []config.CouponConfig{
+		{Code: "LAUNCH", PercentOff: 20, Renewals: 2},
+		{Code: "PROONLY", PercentOff: 50, PlanIDs: []string{"plan-pro"}},
+	}
+
+	coupon := findCoupon(coupons, "launch", "plan-basic")
+	require.NotNil(t, coupon)
+	assert.Equal(t, 20, coupon.PercentOff)
+
+	assert.NotNil(t, findCoupon(coupons, "PROONLY", "plan-pro"))
+	assert.Nil(t, findCoupon(coupons, "PROONLY", "plan-basic"))
+	assert.Nil(t, findCoupon(coupons, "UNKNOWN", "plan-pro"))
+}
+
+func TestApplyCoupon(t *testing.T) {
+	session := &CheckoutSession{Price: decimal.RequireFromString("9.99"), Amount: decimal.RequireFromString("9.99"), Currency: "USD"}
+	session.applyCoupon(&config.CouponConfig{Code: "LAUNCH", PercentOff: 25, Renewals: 2})
+
+	assert.Equal(t, "LAUNCH", *session.Coupon)
+	assert.True(t, decimal.RequireFromString("7.49").Equal(session.Amount), session.Amount.String())
+	assert.True(t, decimal.RequireFromString("9.99").Equal(session.Price))
+	assert.Equal(t, 2, session.DiscountRenewals)
+
+	free := &CheckoutSession{Price: decimal.RequireFromString("1000"), Currency: "JPY"}
+	free.applyCoupon(&config.CouponConfig{Code: "TRIAL", PercentOff: 100})
+	assert.True(t, free.Amount.IsZero())
+}
+
+func TestReturnURLAllowed(t *testing.T) {
+	assert.True(t, returnURLAllowed(nil, "https://shop.example.com/thanks"))
+	assert.False(t, returnURLAllowed(nil, "javascript:alert(1)"))
+	assert.False(t, returnURLAllowed(nil, "/relative"))
+
+	hosts := []string{"shop.example.com"}
+	assert.True(t, returnURLAllowed(hosts, "https://SHOP.example.com:8443/thanks"))
+	assert.False(t, returnURLAllowed(hosts, "https://evil.example.net/thanks"))
+}
+
+func TestHostedCheckoutURL(t *testing.T) {
+	assert.Equal(t, "", hostedCheckoutURL("", "abc"))
+	assert.Equal(t, "https://pay.example.com/checkout?token=abc", hostedCheckoutURL("https://pay.example.com/checkout", "abc"))
+	assert.Equal(t, "https://pay.example.com/checkout?lang=de&token=abc", hostedCheckoutURL("https://pay.example.com/checkout?lang=de", "abc"))
+}
@@ -0,0 +1,86 @@
+package payment
+
+import (
+	"context"
+
+	"github.com/shopspring/decimal"
+)
+
+// Account credit, from a cancellation under the credit refund policy or
+// GrantCredit, pays for renewals before the gateway is charged. Each credit
+// is a refunds row of kind credit whose applied_amount is how much of it
+// renewals have used.
+
+// creditedCharge splits amount into what is charged and what credit pays
+func creditedCharge(amount, credit decimal.Decimal) (charge, applied decimal.Decimal) {
+	applied = decimal.Max(decimal.Min(amount, credit), decimal.Zero)
+	return amount.Sub(applied), applied
+}
+
+// availableCredit is what is left of the user's credit in currency
+func (s *Service) availableCredit(ctx context.Context, userID, currency string) (decimal.Decimal, error) {
+	var credit decimal.Decimal
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount - applied_amount), 0)
+		FROM refunds
+		WHERE user_id = $1 AND currency = $2 AND kind = 'credit' AND status = 'completed'
+	`, userID, currency).Scan(&credit)
+	return credit, err
+}
+
+// useCredit takes amount from the user's credit in currency, oldest credit
+// first, and returns how much it took, less than amount if another renewal
+// used the credit meanwhile.
+func (s *Service) useCredit(ctx context.Context, userID, currency string, amount decimal.Decimal) (decimal.Decimal, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, amount - applied_amount
+		FROM refunds
+		WHERE user_id = $1 AND currency = $2 AND kind = 'credit' AND status = 'completed'
+			AND applied_amount < amount
+		ORDER BY created_at, id
+		FOR UPDATE
+	`, userID, currency)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	type credit struct {
+		id   string
+		left decimal.Decimal
+	}
+	var credits []credit
+	for rows.Next() {
+		var c credit
+		if err := rows.Scan(&c.id, &c.left); err != nil {
+			rows.Close()
+			return decimal.Zero, err
+		}
+		credits = append(credits, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return decimal.Zero, err
+	}
+
+	used := decimal.Zero
+	for _, c := range credits {
+		if !used.LessThan(amount) {
+			break
+		}
+		take := decimal.Min(c.left, amount.Sub(used))
+		if _, err := tx.ExecContext(ctx, `UPDATE refunds SET applied_amount = applied_amount + $2 WHERE id = $1`,
+			c.id, take); err != nil {
+			return decimal.Zero, err
+		}
+		used = used.Add(take)
+	}
+	if err := tx.Commit(); err != nil {
+		return decimal.Zero, err
+	}
+	return used, nil
+}
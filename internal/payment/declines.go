@@ -0,0 +1,113 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Normalized decline codes, the same whichever gateway declined the charge,
+// so frontends can tell customers what to do and dunning can tell a
+// retryable decline from a final one.
+const (
+	DeclineInsufficientFunds = "insufficient_funds"
+	DeclineCardExpired       = "card_expired"
+	DeclineDoNotHonor        = "do_not_honor"
+	DeclineFraudSuspected    = "fraud_suspected"
+	// DeclineOther is any decline without a more specific code
+	DeclineOther = "other"
+)
+
+// stripeDeclineCodes maps Stripe decline codes, and error codes for card
+// errors without one, to normalized codes
+var stripeDeclineCodes = map[string]string{
+	"insufficient_funds":              DeclineInsufficientFunds,
+	"card_velocity_exceeded":          DeclineInsufficientFunds,
+	"withdrawal_count_limit_exceeded": DeclineInsufficientFunds,
+	"expired_card":                    DeclineCardExpired,
+	"do_not_honor":                    DeclineDoNotHonor,
+	"generic_decline":                 DeclineDoNotHonor,
+	"card_declined":                   DeclineDoNotHonor,
+	"call_issuer":                     DeclineDoNotHonor,
+	"try_again_later":                 DeclineDoNotHonor,
+	"fraudulent":                      DeclineFraudSuspected,
+	"lost_card":                       DeclineFraudSuspected,
+	"stolen_card":                     DeclineFraudSuspected,
+	"pickup_card":                     DeclineFraudSuspected,
+	"merchant_blacklist":              DeclineFraudSuspected,
+	"security_violation":              DeclineFraudSuspected,
+}
+
+// paypalDeclineCodes maps the issues PayPal reports for refused payments
+// to normalized codes; other issues are errors, not declines
+var paypalDeclineCodes = map[string]string{
+	"instrument_declined":                     DeclineDoNotHonor,
+	"transaction_refused":                     DeclineDoNotHonor,
+	"payer_cannot_pay":                        DeclineInsufficientFunds,
+	"max_number_of_payment_attempts_exceeded": DeclineDoNotHonor,
+	"card_expired":                            DeclineCardExpired,
+	"payer_account_restricted":                DeclineFraudSuspected,
+	"payer_account_locked_or_closed":          DeclineFraudSuspected,
+	"compliance_violation":                    DeclineFraudSuspected,
+}
+
+// NormalizeDeclineCode maps a gateway's decline code to a normalized one.
+// The simulated gateway declines with normalized codes already.
+func NormalizeDeclineCode(gateway, code string) string {
+	code = strings.ToLower(code)
+	switch gateway {
+	case GatewayStripe:
+		if normalized, ok := stripeDeclineCodes[code]; ok {
+			return normalized
+		}
+	case GatewayPayPal:
+		if normalized, ok := paypalDeclineCodes[code]; ok {
+			return normalized
+		}
+	case GatewaySimulated:
+		switch code {
+		case DeclineInsufficientFunds, DeclineCardExpired, DeclineDoNotHonor, DeclineFraudSuspected:
+			return code
+		}
+	}
+	return DeclineOther
+}
+
+// retryableDecline reports whether retrying a declined charge later may
+// succeed. An expired card or a suspected fraud won't go through until the
+// customer changes their payment method, so dunning stops retrying.
+func retryableDecline(code string) bool {
+	return code != DeclineCardExpired && code != DeclineFraudSuspected
+}
+
+// declinedResponse is the 402 body for a declined charge. decline_code is
+// one of the normalized codes, for frontends to pick what to tell the
+// customer.
+func declinedResponse(decline *DeclineError) gin.H {
+	return gin.H{
+		"error":        "Payment declined",
+		"decline_code": decline.Code,
+		"message":      decline.Message,
+	}
+}
+
+// storeDeclinedTransaction records a declined charge as a failed
+// transaction with its normalized decline code; the gateway's own code and
+// message are kept in gateway_response.
+func (s *Service) storeDeclinedTransaction(ctx context.Context, req PaymentRequest, decline *DeclineError) error {
+	gatewayResponse, _ := json.Marshal(map[string]interface{}{
+		"status":       "declined",
+		"decline_code": decline.GatewayCode,
+		"message":      decline.Message,
+	})
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO payment_transactions (user_id, amount, currency, status, payment_method,
+			gateway_response, subscription_id, decline_code)
+		VALUES ($1, $2, $3, 'failed', $4, $5, NULLIF($6, '')::uuid, $7)
+	`, req.UserID, req.Amount, req.Currency, req.PaymentMethod, string(gatewayResponse),
+		req.SubscriptionID, decline.Code)
+	return err
+}
@@ -0,0 +1,30 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeDeclineCode(t *testing.T) {
+	assert.Equal(t, DeclineInsufficientFunds, NormalizeDeclineCode(GatewayStripe, "insufficient_funds"))
+	assert.Equal(t, DeclineCardExpired, NormalizeDeclineCode(GatewayStripe, "expired_card"))
+	assert.Equal(t, DeclineDoNotHonor, NormalizeDeclineCode(GatewayStripe, "generic_decline"))
+	assert.Equal(t, DeclineFraudSuspected, NormalizeDeclineCode(GatewayStripe, "stolen_card"))
+	assert.Equal(t, DeclineOther, NormalizeDeclineCode(GatewayStripe, "processing_error"))
+	assert.Equal(t, DeclineOther, NormalizeDeclineCode(GatewayStripe, ""))
+
+	assert.Equal(t, DeclineDoNotHonor, NormalizeDeclineCode(GatewayPayPal, "INSTRUMENT_DECLINED"))
+	assert.Equal(t, DeclineInsufficientFunds, NormalizeDeclineCode(GatewayPayPal, "PAYER_CANNOT_PAY"))
+
+	assert.Equal(t, DeclineCardExpired, NormalizeDeclineCode(GatewaySimulated, "card_expired"))
+	assert.Equal(t, DeclineOther, NormalizeDeclineCode(GatewaySimulated, "expired_card"))
+}
+
+func TestRetryableDecline(t *testing.T) {
+	assert.True(t, retryableDecline(DeclineInsufficientFunds))
+	assert.True(t, retryableDecline(DeclineDoNotHonor))
+	assert.True(t, retryableDecline(DeclineOther))
+	assert.False(t, retryableDecline(DeclineCardExpired))
+	assert.False(t, retryableDecline(DeclineFraudSuspected))
+}
@@ -0,0 +1,312 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/money"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DisputeOpen = "open"
+	DisputeWon  = "won"
+	DisputeLost = "lost"
+)
+
+// Dispute policies, see config.PaymentConfig.DisputePolicy.
+const (
+	disputeSuspendOnOpen = "suspend_on_open"
+	disputeSuspendOnLoss = "suspend_on_loss"
+)
+
+// Dispute is a chargeback raised against a payment. TransactionID and the
+// fields derived from it are nil when the disputed charge is not one of ours.
+type Dispute struct {
+	ID                    string          `json:"id" db:"id"`
+	GatewayDisputeID      string          `json:"gateway_dispute_id" db:"gateway_dispute_id"`
+	TransactionID         *string         `json:"transaction_id,omitempty" db:"transaction_id"`
+	SubscriptionID        *string         `json:"subscription_id,omitempty" db:"subscription_id"`
+	UserID                *string         `json:"user_id,omitempty" db:"user_id"`
+	PlanID                *string         `json:"plan_id,omitempty" db:"plan_id"`
+	Amount                decimal.Decimal `json:"amount" db:"amount"`
+	Currency              string          `json:"currency" db:"currency"`
+	Reason                string          `json:"reason" db:"reason"`
+	Status                string          `json:"status" db:"status"`
+	SubscriptionSuspended bool            `json:"subscription_suspended" db:"subscription_suspended"`
+	ClosedAt              *time.Time      `json:"closed_at,omitempty" db:"closed_at"`
+	CreatedAt             time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt             time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+type DisputeListResponse struct {
+	Disputes   []Dispute `json:"disputes"`
+	Limit      int       `json:"limit"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+var validDisputeStatuses = map[string]bool{
+	"":          true,
+	DisputeOpen: true,
+	DisputeWon:  true,
+	DisputeLost: true,
+}
+
+// ListDisputes returns disputes newest first using cursor pagination.
+// Optional filters: status, plan_id, user_id.
+func (s *Service) ListDisputes(c *gin.Context) {
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+
+	status := c.Query("status")
+	if !validDisputeStatuses[status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open, won or lost"})
+		telemetry.RecordPaymentOperation("dispute_list", "validation_error")
+		return
+	}
+
+	cursor, err := db.ParseCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		telemetry.RecordPaymentOperation("dispute_list", "validation_error")
+		return
+	}
+
+	disputes, nextCursor, err := s.listDisputes(c.Request.Context(), status, c.Query("plan_id"), c.Query("user_id"), cursor, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list disputes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("dispute_list", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, DisputeListResponse{
+		Disputes:   disputes,
+		Limit:      limit,
+		NextCursor: nextCursor,
+	})
+	telemetry.RecordPaymentOperation("dispute_list", "success")
+}
+
+// handleDispute records a charge.dispute.* event and applies the dispute
+// policy to the disputed subscription. Each status change is acted on once,
+// so redelivered and replayed events are harmless.
+func (s *Service) handleDispute(ctx context.Context, event WebhookEvent) {
+	logrus.Infof("Processing dispute webhook: %s", event.ID)
+
+	gatewayDisputeID, _ := event.Data["id"].(string)
+	if gatewayDisputeID == "" {
+		logrus.Warnf("Dispute webhook %s has no dispute id", event.ID)
+		return
+	}
+
+	inserted, err := s.openDispute(ctx, event, gatewayDisputeID)
+	if err != nil {
+		logrus.Errorf("Failed to record dispute %s: %v", gatewayDisputeID, err)
+		return
+	}
+	dispute, err := s.getDispute(ctx, gatewayDisputeID)
+	if err != nil {
+		logrus.Errorf("Failed to get dispute %s: %v", gatewayDisputeID, err)
+		return
+	}
+	if inserted {
+		telemetry.RecordDispute(planLabel(dispute.PlanID), "opened")
+		if s.cfg.DisputePolicy == disputeSuspendOnOpen {
+			s.suspendForDispute(ctx, dispute)
+		}
+	}
+
+	status := disputeStatus(event.Data["status"])
+	if status == DisputeOpen || dispute.Status != DisputeOpen {
+		return
+	}
+
+	closed, err := s.closeDispute(ctx, dispute.ID, status)
+	if err != nil || !closed {
+		if err != nil {
+			logrus.Errorf("Failed to close dispute %s: %v", gatewayDisputeID, err)
+		}
+		return
+	}
+	telemetry.RecordDispute(planLabel(dispute.PlanID), status)
+
+	switch status {
+	case DisputeLost:
+		if dispute.TransactionID != nil {
+			if _, err := s.db.ExecContext(ctx, `
+				UPDATE payment_transactions SET status = 'refunded', updated_at = NOW() WHERE id = $1
+			`, *dispute.TransactionID); err != nil {
+				logrus.Errorf("Failed to mark transaction %s refunded: %v", *dispute.TransactionID, err)
+			}
+		}
+		if s.cfg.DisputePolicy == disputeSuspendOnLoss {
+			s.suspendForDispute(ctx, dispute)
+		}
+	case DisputeWon:
+		if dispute.SubscriptionSuspended && dispute.SubscriptionID != nil {
+			if err := s.subscriptionSvc.Reinstate(ctx, *dispute.SubscriptionID); err != nil {
+				logrus.Errorf("Failed to reinstate subscription %s: %v", *dispute.SubscriptionID, err)
+			}
+		}
+	}
+}
+
+func (s *Service) suspendForDispute(ctx context.Context, dispute *Dispute) {
+	if dispute.SubscriptionID == nil {
+		return
+	}
+	suspended, err := s.subscriptionSvc.Suspend(ctx, *dispute.SubscriptionID)
+	if err != nil {
+		logrus.Errorf("Failed to suspend subscription %s: %v", *dispute.SubscriptionID, err)
+		return
+	}
+	if !suspended {
+		return
+	}
+	// Remembered so only suspensions made for this dispute are lifted if it is won
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE disputes SET subscription_suspended = true, updated_at = NOW() WHERE id = $1
+	`, dispute.ID); err != nil {
+		logrus.Errorf("Failed to record suspension for dispute %s: %v", dispute.ID, err)
+	}
+	dispute.SubscriptionSuspended = true
+}
+
+// disputeStatus maps a gateway dispute status onto ours. Everything short
+// of a decision (needs_response, under_review, ...) is open.
+func disputeStatus(value interface{}) string {
+	status, _ := value.(string)
+	switch status {
+	case DisputeWon, DisputeLost:
+		return status
+	default:
+		return DisputeOpen
+	}
+}
+
+func planLabel(planID *string) string {
+	if planID == nil {
+		return "unknown"
+	}
+	return *planID
+}
+
+// openDispute inserts a dispute for the event, linked to the disputed
+// transaction when the charge or payment intent is one we recorded. It
+// reports false if the dispute already exists.
+func (s *Service) openDispute(ctx context.Context, event WebhookEvent, gatewayDisputeID string) (bool, error) {
+	currency := strings.ToUpper(stringField(event.Data, "currency"))
+	minor, _ := event.Data["amount"].(float64)
+	amount := money.FromMinor(int64(minor), currency)
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO disputes (gateway_dispute_id, transaction_id, subscription_id, user_id,
+			plan_id, amount, currency, reason)
+		SELECT $1, t.id, t.subscription_id, t.user_id, sub.plan_id, $4, $5, $6
+		FROM (SELECT 1) AS one
+		LEFT JOIN LATERAL (
+			SELECT id, subscription_id, user_id
+			FROM payment_transactions
+			WHERE gateway_transaction_id IN ($2, $3)
+			ORDER BY created_at DESC
+			LIMIT 1
+		) t ON true
+		LEFT JOIN subscriptions sub ON sub.id = t.subscription_id
+		ON CONFLICT (gateway_dispute_id) DO NOTHING
+	`, gatewayDisputeID, stringField(event.Data, "payment_intent"), stringField(event.Data, "charge"),
+		amount, currency, stringField(event.Data, "reason"))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// closeDispute moves an open dispute to status and reports whether this
+// call made the change.
+func (s *Service) closeDispute(ctx context.Context, id, status string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE disputes SET status = $2, closed_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'open'
+	`, id, status)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func stringField(data map[string]interface{}, key string) string {
+	value, _ := data[key].(string)
+	return value
+}
+
+// disputeColumns lists the columns scanDispute expects, in order
+const disputeColumns = `id, gateway_dispute_id, transaction_id, subscription_id, user_id, plan_id,
+	amount, currency, COALESCE(reason, ''), status, subscription_suspended, closed_at,
+	created_at, updated_at`
+
+func scanDispute(scan func(dest ...interface{}) error) (*Dispute, error) {
+	var d Dispute
+	if err := scan(&d.ID, &d.GatewayDisputeID, &d.TransactionID, &d.SubscriptionID, &d.UserID,
+		&d.PlanID, &d.Amount, &d.Currency, &d.Reason, &d.Status, &d.SubscriptionSuspended,
+		&d.ClosedAt, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *Service) getDispute(ctx context.Context, gatewayDisputeID string) (*Dispute, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+disputeColumns+`
+		FROM disputes WHERE gateway_dispute_id = $1
+	`, gatewayDisputeID)
+	return scanDispute(row.Scan)
+}
+
+func (s *Service) listDisputes(ctx context.Context, status, planID, userID string, cursor *db.Cursor, limit int) ([]Dispute, string, error) {
+	query := `
+		SELECT ` + disputeColumns + `
+		FROM disputes
+		WHERE ($1 = '' OR status = $1)
+			AND ($2 = '' OR plan_id::text = $2)
+			AND ($3 = '' OR user_id::text = $3)
+			AND ($4::timestamptz IS NULL OR (created_at, id::text) < ($4, $5))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $6
+	`
+
+	after, afterID := cursor.After()
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := s.db.Reader().QueryContext(ctx, query, status, planID, userID, after, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var disputes []Dispute
+	for rows.Next() {
+		dispute, err := scanDispute(rows.Scan)
+		if err != nil {
+			return nil, "", err
+		}
+		disputes = append(disputes, *dispute)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	disputes, nextCursor := db.NextPage(disputes, limit, func(row Dispute) db.Cursor {
+		return db.Cursor{CreatedAt: row.CreatedAt, ID: row.ID}
+	})
+
+	return disputes, nextCursor, nil
+}
@@ -0,0 +1,30 @@
+package payment
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisputeStatus(t *testing.T) {
+	assert.Equal(t, DisputeWon, disputeStatus("won"))
+	assert.Equal(t, DisputeLost, disputeStatus("lost"))
+	assert.Equal(t, DisputeOpen, disputeStatus("needs_response"))
+	assert.Equal(t, DisputeOpen, disputeStatus(nil))
+}
+
+func TestDisputeWebhooksMustBeSigned(t *testing.T) {
+	s := &Service{stripe: newStripeGateway("", "", "whsec_test")}
+
+	// A forged dispute naming someone's charge is refused before it is
+	// recorded or suspends the subscription
+	body := `{"id": "evt_forged", "type": "charge.dispute.created", "data": {"id": "dp_1", "charge": "ch_1", "payment_intent": "pi_1", "status": "needs_response"}}`
+	assert.Equal(t, http.StatusUnauthorized, sendStripeWebhook(s, body, stripeSignature("whsec_guessed", body, time.Now())))
+	assert.Equal(t, http.StatusUnauthorized, sendStripeWebhook(s, body, ""))
+
+	// So is a genuine one replayed long after it was sent
+	sent := time.Now().Add(-time.Hour)
+	assert.Equal(t, http.StatusUnauthorized, sendStripeWebhook(s, body, stripeSignature("whsec_test", body, sent)))
+}
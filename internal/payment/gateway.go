@@ -0,0 +1,408 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"scalable-paywall/internal/money"
+
+	"github.com/shopspring/decimal"
+)
+
+// Payment intent states, following Stripe's PaymentIntent lifecycle.
+const (
+	IntentRequiresPaymentMethod = "requires_payment_method"
+	IntentRequiresConfirmation  = "requires_confirmation"
+	IntentRequiresAction        = "requires_action"
+	IntentProcessing            = "processing"
+	IntentSucceeded             = "succeeded"
+	IntentCanceled              = "canceled"
+)
+
+// Gateway names stored with each intent so it is confirmed where it was made.
+const (
+	GatewaySimulated = "simulated"
+	GatewayStripe    = "stripe"
+)
+
+var ErrIntentNotFound = errors.New("payment intent not found")
+
+// DeclineError is a charge the gateway refused, as opposed to one it could
+// not process. Code is the normalized decline code, GatewayCode the
+// gateway's own where it gives one and Message its explanation.
+type DeclineError struct {
+	Code        string
+	GatewayCode string
+	Message     string
+}
+
+func (e *DeclineError) Error() string {
+	if e.GatewayCode == "" {
+		return fmt.Sprintf("payment declined (%s): %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("payment declined (%s, %s): %s", e.Code, e.GatewayCode, e.Message)
+}
+
+// Intent is a gateway payment intent. ClientSecret is only set on creation
+// and is handed to the frontend to confirm the payment (3DS/SCA).
+type Intent struct {
+	ID           string          `json:"id"`
+	ClientSecret string          `json:"client_secret,omitempty"`
+	Status       string          `json:"status"`
+	Amount       decimal.Decimal `json:"amount"`
+	Currency     string          `json:"currency"`
+}
+
+// GatewayRefund is a refund made by the gateway against an earlier charge.
+type GatewayRefund struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// Gateway is a payment provider. CreateIntent starts a customer-confirmed
+// payment; Charge makes an off-session payment, e.g. for renewals. Refund
+// returns part or all of a charge, identified by its gateway ID.
+//
+// A charge or intent requested again with the same IdempotencyKey returns
+// the first one instead of paying twice. Every implementation must pass
+// runGatewayContract in gateway_contract_test.go.
+type Gateway interface {
+	Name() string
+	CreateIntent(ctx context.Context, req PaymentRequest) (*Intent, error)
+	GetIntent(ctx context.Context, id string) (*Intent, error)
+	Charge(ctx context.Context, req PaymentRequest) (*PaymentResponse, error)
+	Refund(ctx context.Context, chargeID string, amount decimal.Decimal, currency string) (*GatewayRefund, error)
+}
+
+// simulatedGateway stands in for a real provider in development. Intents
+// succeed once the frontend confirms them; charges fail failPercent% of the
+// time to exercise the circuit breaker.
+type simulatedGateway struct {
+	failPercent int64
+
+	mu      sync.Mutex
+	intents map[string]*Intent
+	charges map[string]*PaymentResponse
+}
+
+func newSimulatedGateway() *simulatedGateway {
+	return &simulatedGateway{
+		failPercent: 5,
+		intents:     make(map[string]*Intent),
+		charges:     make(map[string]*PaymentResponse),
+	}
+}
+
+func (g *simulatedGateway) Name() string { return GatewaySimulated }
+
+func (g *simulatedGateway) CreateIntent(ctx context.Context, req PaymentRequest) (*Intent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if intent, ok := g.intents[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		return intent, nil
+	}
+
+	id := fmt.Sprintf("pi_%d", time.Now().UnixNano())
+	intent := &Intent{
+		ID:           id,
+		ClientSecret: id + "_secret_" + randomHex(12),
+		Status:       IntentRequiresConfirmation,
+		Amount:       req.Amount,
+		Currency:     req.Currency,
+	}
+	g.intents[id] = intent
+	if req.IdempotencyKey != "" {
+		g.intents[req.IdempotencyKey] = intent
+	}
+	return intent, nil
+}
+
+// GetIntent reports the intent as succeeded, as if the customer completed
+// confirmation before the callback arrived.
+func (g *simulatedGateway) GetIntent(ctx context.Context, id string) (*Intent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	intent, ok := g.intents[id]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+	intent.Status = IntentSucceeded
+	return &Intent{ID: intent.ID, Status: intent.Status, Amount: intent.Amount, Currency: intent.Currency}, nil
+}
+
+func (g *simulatedGateway) Charge(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
+	// Simulate network delay; pm_slow never answers, like a hung gateway
+	delay := 100 * time.Millisecond
+	if req.PaymentMethod == "pm_slow" {
+		delay = 30 * time.Second
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(delay):
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if charge, ok := g.charges[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		return charge, nil
+	}
+
+	// Payment methods named pm_decline_<code> are declined with that code
+	if code, ok := strings.CutPrefix(req.PaymentMethod, "pm_decline_"); ok {
+		return nil, &DeclineError{
+			Code:        NormalizeDeclineCode(GatewaySimulated, code),
+			GatewayCode: code,
+			Message:     "Your card was declined.",
+		}
+	}
+
+	// Payment methods named pm_sepa_* or pm_ach_* are bank debits, pending
+	// until a payment_intent.* webhook settles them
+	status := chargeCompleted
+	if strings.HasPrefix(req.PaymentMethod, "pm_sepa_") || strings.HasPrefix(req.PaymentMethod, "pm_ach_") {
+		status = chargePending
+	}
+
+	// Simulate random failures for testing circuit breaker
+	if time.Now().UnixNano()%100 < g.failPercent {
+		return nil, fmt.Errorf("gateway timeout")
+	}
+
+	charge := &PaymentResponse{
+		TransactionID: fmt.Sprintf("txn_%d", time.Now().UnixNano()),
+		Status:        status,
+		Amount:        req.Amount,
+		Currency:      req.Currency,
+		CreatedAt:     time.Now(),
+		GatewayID:     fmt.Sprintf("gw_%d", time.Now().UnixNano()),
+	}
+	if req.IdempotencyKey != "" {
+		g.charges[req.IdempotencyKey] = charge
+	}
+	return charge, nil
+}
+
+func (g *simulatedGateway) Refund(ctx context.Context, chargeID string, amount decimal.Decimal, currency string) (*GatewayRefund, error) {
+	return &GatewayRefund{ID: fmt.Sprintf("re_%d", time.Now().UnixNano()), Status: "succeeded"}, nil
+}
+
+// stripeGateway talks to the Stripe PaymentIntents API at baseURL.
+type stripeGateway struct {
+	baseURL string
+	client  *http.Client
+
+	mu            sync.RWMutex
+	apiKey        string
+	webhookSecret string
+}
+
+func newStripeGateway(baseURL, apiKey, webhookSecret string) *stripeGateway {
+	return &stripeGateway{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		client:        &http.Client{Timeout: 30 * time.Second},
+		apiKey:        apiKey,
+		webhookSecret: webhookSecret,
+	}
+}
+
+func (g *stripeGateway) Name() string { return GatewayStripe }
+
+// setAPIKey swaps the key used for later requests, e.g. after a rotation.
+func (g *stripeGateway) setAPIKey(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.apiKey = key
+}
+
+type stripeIntent struct {
+	ID           string `json:"id"`
+	ClientSecret string `json:"client_secret"`
+	Status       string `json:"status"`
+	Amount       int64  `json:"amount"`
+	Currency     string `json:"currency"`
+}
+
+type stripeError struct {
+	Error struct {
+		Type        string `json:"type"`
+		Code        string `json:"code"`
+		DeclineCode string `json:"decline_code"`
+		Message     string `json:"message"`
+	} `json:"error"`
+}
+
+func (g *stripeGateway) CreateIntent(ctx context.Context, req PaymentRequest) (*Intent, error) {
+	form := intentForm(req)
+	form.Set("automatic_payment_methods[enabled]", "true")
+	if req.PaymentMethod != "" {
+		form.Set("payment_method", req.PaymentMethod)
+	}
+	return g.postIntent(ctx, "/v1/payment_intents", req.IdempotencyKey, form, true)
+}
+
+func (g *stripeGateway) GetIntent(ctx context.Context, id string) (*Intent, error) {
+	var pi stripeIntent
+	if err := g.do(ctx, http.MethodGet, "/v1/payment_intents/"+url.PathEscape(id), "", nil, &pi); err != nil {
+		return nil, err
+	}
+	return pi.toIntent(false), nil
+}
+
+func (g *stripeGateway) Charge(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
+	form := intentForm(req)
+	form.Set("payment_method", req.PaymentMethod)
+	form.Set("confirm", "true")
+	form.Set("off_session", "true")
+
+	intent, err := g.postIntent(ctx, "/v1/payment_intents", req.IdempotencyKey, form, false)
+	if err != nil {
+		return nil, err
+	}
+	return chargeResponse(intent)
+}
+
+// chargeResponse is the outcome of an off-session charge's intent. Bank
+// debits stay processing until they settle days later, so they are pending
+// rather than failed.
+func chargeResponse(intent *Intent) (*PaymentResponse, error) {
+	status := chargeCompleted
+	switch intent.Status {
+	case IntentSucceeded:
+	case IntentProcessing:
+		status = chargePending
+	default:
+		return nil, fmt.Errorf("payment intent %s is %s", intent.ID, intent.Status)
+	}
+	return &PaymentResponse{
+		TransactionID: intent.ID,
+		Status:        status,
+		Amount:        intent.Amount,
+		Currency:      intent.Currency,
+		CreatedAt:     time.Now(),
+		GatewayID:     intent.ID,
+	}, nil
+}
+
+// Refund refunds a payment intent made by Charge or CreateIntent.
+func (g *stripeGateway) Refund(ctx context.Context, chargeID string, amount decimal.Decimal, currency string) (*GatewayRefund, error) {
+	form := url.Values{}
+	form.Set("payment_intent", chargeID)
+	form.Set("amount", strconv.FormatInt(money.ToMinor(amount, currency), 10))
+	form.Set("reason", "requested_by_customer")
+
+	var refund GatewayRefund
+	if err := g.do(ctx, http.MethodPost, "/v1/refunds", "", form, &refund); err != nil {
+		return nil, err
+	}
+	if refund.Status == "failed" || refund.Status == "canceled" {
+		return nil, fmt.Errorf("refund %s is %s", refund.ID, refund.Status)
+	}
+	return &refund, nil
+}
+
+func intentForm(req PaymentRequest) url.Values {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(money.ToMinor(req.Amount, req.Currency), 10))
+	form.Set("currency", strings.ToLower(req.Currency))
+	form.Set("metadata[user_id]", req.UserID)
+	form.Set("metadata[plan_id]", req.PlanID)
+	if req.SubscriptionID != "" {
+		form.Set("metadata[subscription_id]", req.SubscriptionID)
+	}
+	if req.Description != "" {
+		form.Set("description", req.Description)
+	}
+	return form
+}
+
+func (g *stripeGateway) postIntent(ctx context.Context, path, idempotencyKey string, form url.Values, withSecret bool) (*Intent, error) {
+	var pi stripeIntent
+	if err := g.do(ctx, http.MethodPost, path, idempotencyKey, form, &pi); err != nil {
+		return nil, err
+	}
+	return pi.toIntent(withSecret), nil
+}
+
+// do sends a request. idempotencyKey, when set, makes a POST idempotent.
+func (g *stripeGateway) do(ctx context.Context, method, path, idempotencyKey string, form url.Values, out interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, g.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	g.mu.RLock()
+	req.Header.Set("Authorization", "Bearer "+g.apiKey)
+	g.mu.RUnlock()
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrIntentNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr stripeError
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error.Message != "" {
+			if apiErr.Error.Type == "card_error" {
+				reason := apiErr.Error.DeclineCode
+				if reason == "" {
+					reason = apiErr.Error.Code
+				}
+				return &DeclineError{
+					Code:        NormalizeDeclineCode(GatewayStripe, reason),
+					GatewayCode: reason,
+					Message:     apiErr.Error.Message,
+				}
+			}
+			return fmt.Errorf("gateway returned status %d: %s", resp.StatusCode, apiErr.Error.Message)
+		}
+		return fmt.Errorf("gateway returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (pi stripeIntent) toIntent(withSecret bool) *Intent {
+	currency := strings.ToUpper(pi.Currency)
+	intent := &Intent{
+		ID:       pi.ID,
+		Status:   pi.Status,
+		Amount:   money.FromMinor(pi.Amount, currency),
+		Currency: currency,
+	}
+	if withSecret {
+		intent.ClientSecret = pi.ClientSecret
+	}
+	return intent
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,140 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/payment/gatewaymock"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// contractMethods are payment methods a gateway under contract pays,
+// declines for insufficient funds and never answers for.
+type contractMethods struct {
+	approved string
+	declined string
+	slow     string
+}
+
+// runGatewayContract checks the behaviour the payment service relies on
+// from every Gateway. Adapters for new providers should pass it against
+// their provider's test mode or a mock of it.
+func runGatewayContract(t *testing.T, g Gateway, methods contractMethods) {
+	var seq int
+	request := func(method string) PaymentRequest {
+		seq++
+		return PaymentRequest{
+			UserID:         "user-1",
+			PlanID:         "plan-1",
+			Amount:         decimal.RequireFromString("19.99"),
+			Currency:       "USD",
+			PaymentMethod:  method,
+			Description:    "Pro",
+			IdempotencyKey: fmt.Sprintf("contract-%s-%d-%d", g.Name(), time.Now().UnixNano(), seq),
+		}
+	}
+	ctx := context.Background()
+
+	t.Run("charge succeeds", func(t *testing.T) {
+		req := request(methods.approved)
+		charge, err := g.Charge(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, chargeCompleted, charge.Status)
+		assert.True(t, req.Amount.Equal(charge.Amount), charge.Amount.String())
+		assert.Equal(t, "USD", charge.Currency)
+		assert.NotEmpty(t, charge.TransactionID)
+		assert.NotEmpty(t, charge.GatewayID)
+	})
+
+	t.Run("decline", func(t *testing.T) {
+		_, err := g.Charge(ctx, request(methods.declined))
+		var decline *DeclineError
+		require.True(t, errors.As(err, &decline), "want a DeclineError, got %v", err)
+		assert.Equal(t, DeclineInsufficientFunds, decline.Code)
+		assert.NotEmpty(t, decline.Message)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		started := time.Now()
+		_, err := g.Charge(ctx, request(methods.slow))
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), "want the deadline error, got %v", err)
+		var decline *DeclineError
+		assert.False(t, errors.As(err, &decline), "a timeout is not a decline")
+		assert.Less(t, time.Since(started), 5*time.Second)
+	})
+
+	t.Run("duplicate idempotency key", func(t *testing.T) {
+		req := request(methods.approved)
+		first, err := g.Charge(ctx, req)
+		require.NoError(t, err)
+		again, err := g.Charge(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, first.TransactionID, again.TransactionID)
+		assert.Equal(t, first.GatewayID, again.GatewayID)
+
+		other, err := g.Charge(ctx, request(methods.approved))
+		require.NoError(t, err)
+		assert.NotEqual(t, first.GatewayID, other.GatewayID)
+
+		req = request("")
+		intent, err := g.CreateIntent(ctx, req)
+		require.NoError(t, err)
+		intentAgain, err := g.CreateIntent(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, intent.ID, intentAgain.ID)
+	})
+
+	t.Run("refund", func(t *testing.T) {
+		charge, err := g.Charge(ctx, request(methods.approved))
+		require.NoError(t, err)
+
+		refund, err := g.Refund(ctx, charge.GatewayID, decimal.RequireFromString("5.00"), "USD")
+		require.NoError(t, err)
+		assert.NotEmpty(t, refund.ID)
+		assert.Equal(t, "succeeded", refund.Status)
+	})
+}
+
+func TestSimulatedGatewayContract(t *testing.T) {
+	g := newSimulatedGateway()
+	g.failPercent = 0
+	runGatewayContract(t, g, contractMethods{
+		approved: "pm_card_visa",
+		declined: "pm_decline_insufficient_funds",
+		slow:     "pm_slow",
+	})
+}
+
+func TestStripeGatewayContract(t *testing.T) {
+	server := httptest.NewServer(gatewaymock.New())
+	defer server.Close()
+
+	runGatewayContract(t, newStripeGateway(server.URL, "sk_test_contract", ""), contractMethods{
+		approved: "pm_card_visa",
+		declined: "pm_decline_insufficient_funds",
+		slow:     "pm_slow",
+	})
+}
+
+func TestPayPalGatewayContract(t *testing.T) {
+	server := httptest.NewServer(gatewaymock.New())
+	defer server.Close()
+
+	g := newPayPalGateway(config.PayPalConfig{BaseURL: server.URL, ClientID: "id", ClientSecret: "secret"})
+	runGatewayContract(t, g, contractMethods{
+		approved: "paypal_VAULT1",
+		declined: "paypal_decline_payer_cannot_pay",
+		slow:     "paypal_slow",
+	})
+}
@@ -0,0 +1,421 @@
+// Package gatewaymock serves fake Stripe and PayPal APIs, for testing the
+// gateway adapters and for running the service locally without sandbox
+// accounts. Both APIs share one server: point payment.gateway_url and
+// payment.paypal.base_url at it.
+//
+// What a charge does depends on its payment method, a Stripe payment_method
+// or a PayPal vault_id:
+//
+//	pm_decline_<code>, decline_<issue>  declined with that code or issue
+//	pm_slow, slow                       no answer until the client gives up
+//	pm_error, error                     a 500 from the API
+//	pm_sepa_*, pm_ach_*                 left processing (Stripe only)
+//	anything else                       paid
+//
+// Requests repeated with the same Idempotency-Key (Stripe) or
+// PayPal-Request-Id get the first response again without charging twice.
+package gatewaymock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake Stripe and PayPal API. The zero value is not usable;
+// create one with New.
+type Server struct {
+	// SlowDelay is how long slow payment methods hang when the client
+	// doesn't give up first
+	SlowDelay time.Duration
+
+	mux *http.ServeMux
+
+	mu      sync.Mutex
+	seq     int
+	intents map[string]*stripeIntent
+	orders  map[string]*paypalOrder
+	replays map[string]replay
+}
+
+// replay is a response kept for an idempotency key, with a digest of the
+// request that made it
+type replay struct {
+	request string
+	status  int
+	body    []byte
+}
+
+// New creates a server with no payments.
+func New() *Server {
+	s := &Server{
+		SlowDelay: 30 * time.Second,
+		mux:       http.NewServeMux(),
+		intents:   make(map[string]*stripeIntent),
+		orders:    make(map[string]*paypalOrder),
+		replays:   make(map[string]replay),
+	}
+	s.mux.HandleFunc("/v1/payment_intents", s.stripeCreateIntent)
+	s.mux.HandleFunc("/v1/payment_intents/", s.stripeGetIntent)
+	s.mux.HandleFunc("/v1/refunds", s.stripeRefund)
+	s.mux.HandleFunc("/v1/oauth2/token", s.paypalToken)
+	s.mux.HandleFunc("/v2/checkout/orders", s.paypalCreateOrder)
+	s.mux.HandleFunc("/v2/checkout/orders/", s.paypalOrder)
+	s.mux.HandleFunc("/v2/payments/captures/", s.paypalRefund)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": map[string]string{
+			"type": "invalid_request_error", "message": "No API key provided.",
+		}})
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) nextID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s_%06d", prefix, s.seq)
+}
+
+// respond writes the response to an idempotent request, replaying the
+// first one made with key. A reused key with a different request is an
+// error, as it is for Stripe.
+func (s *Server) respond(w http.ResponseWriter, key, request string, handle func() (int, interface{})) {
+	if key != "" {
+		s.mu.Lock()
+		prior, ok := s.replays[key]
+		s.mu.Unlock()
+		if ok {
+			if prior.request != request {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": map[string]string{
+					"type":    "idempotency_error",
+					"message": "Keys for idempotent requests can only be used with the same parameters they were first used with.",
+				}})
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(prior.status)
+			w.Write(prior.body)
+			return
+		}
+	}
+
+	status, body := handle()
+	data, _ := json.Marshal(body)
+	if key != "" && status != http.StatusInternalServerError {
+		s.mu.Lock()
+		s.replays[key] = replay{request: request, status: status, body: data}
+		s.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// hang waits for the client to give up, or SlowDelay
+func (s *Server) hang(r *http.Request) {
+	select {
+	case <-r.Context().Done():
+	case <-time.After(s.SlowDelay):
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Stripe
+
+type stripeIntent struct {
+	ID           string `json:"id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	Status       string `json:"status"`
+	Amount       int64  `json:"amount"`
+	Currency     string `json:"currency"`
+	Refunded     int64  `json:"-"`
+}
+
+func stripeError(errType, code, declineCode, message string) map[string]interface{} {
+	return map[string]interface{}{"error": map[string]string{
+		"type": errType, "code": code, "decline_code": declineCode, "message": message,
+	}}
+}
+
+func (s *Server) stripeCreateIntent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, stripeError("invalid_request_error", "", "", err.Error()))
+		return
+	}
+	form := r.PostForm
+	method := form.Get("payment_method")
+
+	switch {
+	case method == "pm_slow":
+		s.hang(r)
+	case method == "pm_error":
+		writeJSON(w, http.StatusInternalServerError, stripeError("api_error", "", "", "An unknown error occurred."))
+		return
+	}
+
+	s.respond(w, r.Header.Get("Idempotency-Key"), form.Encode(), func() (int, interface{}) {
+		var amount int64
+		if _, err := fmt.Sscan(form.Get("amount"), &amount); err != nil || amount <= 0 {
+			return http.StatusBadRequest, stripeError("invalid_request_error", "parameter_invalid_integer", "", "Invalid amount.")
+		}
+		if code, ok := strings.CutPrefix(method, "pm_decline_"); ok {
+			return http.StatusPaymentRequired, stripeError("card_error", "card_declined", code, "Your card was declined.")
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		intent := &stripeIntent{
+			ID:       s.nextID("pi"),
+			Status:   "requires_confirmation",
+			Amount:   amount,
+			Currency: form.Get("currency"),
+		}
+		intent.ClientSecret = intent.ID + "_secret_mock"
+		if method == "" {
+			intent.Status = "requires_payment_method"
+		}
+		if form.Get("confirm") == "true" {
+			intent.Status = "succeeded"
+			if strings.HasPrefix(method, "pm_sepa_") || strings.HasPrefix(method, "pm_ach_") {
+				intent.Status = "processing"
+			}
+		}
+		s.intents[intent.ID] = intent
+		return http.StatusOK, *intent
+	})
+}
+
+func (s *Server) stripeGetIntent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/payment_intents/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	intent, ok := s.intents[id]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, stripeError("invalid_request_error", "resource_missing", "", "No such payment_intent: "+id))
+		return
+	}
+	// Intents awaiting the customer are confirmed by the time they are read
+	if intent.Status == "requires_confirmation" {
+		intent.Status = "succeeded"
+	}
+	view := *intent
+	view.ClientSecret = ""
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (s *Server) stripeRefund(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, stripeError("invalid_request_error", "", "", err.Error()))
+		return
+	}
+	form := r.PostForm
+
+	s.respond(w, r.Header.Get("Idempotency-Key"), form.Encode(), func() (int, interface{}) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		intent, ok := s.intents[form.Get("payment_intent")]
+		if !ok {
+			return http.StatusNotFound, stripeError("invalid_request_error", "resource_missing", "", "No such payment_intent.")
+		}
+		if intent.Status != "succeeded" {
+			return http.StatusBadRequest, stripeError("invalid_request_error", "charge_not_refundable", "", "This PaymentIntent has not been paid.")
+		}
+		amount := intent.Amount - intent.Refunded
+		if v := form.Get("amount"); v != "" {
+			fmt.Sscan(v, &amount)
+		}
+		if amount <= 0 || amount > intent.Amount-intent.Refunded {
+			return http.StatusBadRequest, stripeError("invalid_request_error", "amount_too_large", "", "Refund amount is greater than unrefunded amount.")
+		}
+		intent.Refunded += amount
+		return http.StatusOK, map[string]interface{}{"id": s.nextID("re"), "status": "succeeded", "amount": amount}
+	})
+}
+
+// PayPal
+
+type paypalAmount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+type paypalCapture struct {
+	ID     string       `json:"id"`
+	Status string       `json:"status"`
+	Amount paypalAmount `json:"amount"`
+}
+
+type paypalUnit struct {
+	Amount   paypalAmount `json:"amount"`
+	CustomID string       `json:"custom_id,omitempty"`
+	Payments struct {
+		Captures []paypalCapture `json:"captures,omitempty"`
+	} `json:"payments"`
+}
+
+type paypalOrder struct {
+	ID            string       `json:"id"`
+	Status        string       `json:"status"`
+	PurchaseUnits []paypalUnit `json:"purchase_units"`
+}
+
+func paypalError(status int, name, issue, description string) (int, interface{}) {
+	body := map[string]interface{}{"name": name, "message": description}
+	if issue != "" {
+		body["details"] = []map[string]string{{"issue": issue, "description": description}}
+	}
+	return status, body
+}
+
+func (s *Server) paypalToken(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := r.BasicAuth(); !ok || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "A21AA-mock", "token_type": "Bearer", "expires_in": 32400,
+	})
+}
+
+func (s *Server) paypalCreateOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var buf bytes.Buffer
+	buf.ReadFrom(r.Body)
+	var req struct {
+		PurchaseUnits []paypalUnit `json:"purchase_units"`
+		PaymentSource struct {
+			PayPal *struct {
+				VaultID string `json:"vault_id"`
+			} `json:"paypal"`
+		} `json:"payment_source"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &req); err != nil || len(req.PurchaseUnits) == 0 {
+		status, body := paypalError(http.StatusBadRequest, "INVALID_REQUEST", "MISSING_REQUIRED_PARAMETER", "purchase_units is required")
+		writeJSON(w, status, body)
+		return
+	}
+
+	vault := ""
+	if req.PaymentSource.PayPal != nil {
+		vault = req.PaymentSource.PayPal.VaultID
+	}
+	switch vault {
+	case "slow":
+		s.hang(r)
+	case "error":
+		status, body := paypalError(http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", "", "An internal server error occurred.")
+		writeJSON(w, status, body)
+		return
+	}
+
+	s.respond(w, r.Header.Get("PayPal-Request-Id"), buf.String(), func() (int, interface{}) {
+		if issue, ok := strings.CutPrefix(vault, "decline_"); ok {
+			return paypalError(http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", strings.ToUpper(issue), "The instrument presented was declined.")
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		unit := req.PurchaseUnits[0]
+		unit.Payments.Captures = nil
+		order := &paypalOrder{ID: s.nextID("ORDER"), Status: "CREATED", PurchaseUnits: []paypalUnit{unit}}
+		if vault != "" {
+			s.capture(order)
+		}
+		s.orders[order.ID] = order
+		return http.StatusCreated, *order
+	})
+}
+
+// capture completes an order with one capture of its amount
+func (s *Server) capture(order *paypalOrder) {
+	order.Status = "COMPLETED"
+	unit := &order.PurchaseUnits[0]
+	unit.Payments.Captures = []paypalCapture{{ID: s.nextID("CAPTURE"), Status: "COMPLETED", Amount: unit.Amount}}
+}
+
+// paypalOrder reads an order, approving ones awaiting the customer as if
+// they had approved it, or captures one
+func (s *Server) paypalOrder(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/checkout/orders/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[id]
+	if !ok {
+		status, body := paypalError(http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID", "Specified resource ID does not exist.")
+		writeJSON(w, status, body)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && action == "":
+		if order.Status == "CREATED" {
+			order.Status = "APPROVED"
+		}
+	case r.Method == http.MethodPost && action == "capture":
+		if order.Status != "APPROVED" {
+			status, body := paypalError(http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_NOT_APPROVED", "Payer has not yet approved the Order for payment.")
+			writeJSON(w, status, body)
+			return
+		}
+		s.capture(order)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, *order)
+}
+
+func (s *Server) paypalRefund(w http.ResponseWriter, r *http.Request) {
+	captureID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v2/payments/captures/"), "/")
+	if r.Method != http.MethodPost || action != "refund" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var buf bytes.Buffer
+	buf.ReadFrom(r.Body)
+
+	s.respond(w, r.Header.Get("PayPal-Request-Id"), url.PathEscape(captureID)+buf.String(), func() (int, interface{}) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, order := range s.orders {
+			for _, capture := range order.PurchaseUnits[0].Payments.Captures {
+				if capture.ID == captureID {
+					return http.StatusCreated, map[string]string{"id": s.nextID("REFUND"), "status": "COMPLETED"}
+				}
+			}
+		}
+		return paypalError(http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID", "Specified resource ID does not exist.")
+	})
+}
@@ -0,0 +1,43 @@
+package gatewaymock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func post(s *Server, key string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/payment_intents", strings.NewReader(form.Encode()))
+	req.Header.Set("Authorization", "Bearer sk_test")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyKeyReuse(t *testing.T) {
+	s := New()
+	form := url.Values{"amount": {"1999"}, "currency": {"usd"}, "payment_method": {"pm_card_visa"}, "confirm": {"true"}}
+
+	first := post(s, "key-1", form)
+	assert.Equal(t, http.StatusOK, first.Code)
+
+	again := post(s, "key-1", form)
+	assert.Equal(t, http.StatusOK, again.Code)
+	assert.Equal(t, "true", again.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), again.Body.String())
+
+	form.Set("amount", "2999")
+	assert.Equal(t, http.StatusBadRequest, post(s, "key-1", form).Code)
+}
+
+func TestRequiresAuthorization(t *testing.T) {
+	w := httptest.NewRecorder()
+	New().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/payment_intents/pi_1", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
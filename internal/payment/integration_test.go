@@ -0,0 +1,225 @@
+//go:build integration
+
+package payment_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/jobs"
+	"scalable-paywall/internal/payment"
+	"scalable-paywall/internal/plan"
+	"scalable-paywall/internal/subscription"
+	testenv "scalable-paywall/internal/testing"
+	"scalable-paywall/internal/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var env *testenv.Env
+
+func TestMain(m *testing.M) { os.Exit(testenv.Main(m, &env)) }
+
+func createPlan(t *testing.T, req plan.CreatePlanRequest) *plan.Plan {
+	t.Helper()
+	p, err := env.Plans().ImportPlan(context.Background(), req)
+	require.NoError(t, err)
+	return p
+}
+
+func createUser(t *testing.T, username string) *user.User {
+	t.Helper()
+	u, err := env.Users().ImportUser(context.Background(), user.CreateUserRequest{
+		Email:    username + "@example.com",
+		Username: username,
+	})
+	require.NoError(t, err)
+	return u
+}
+
+// serveWebhook posts a Stripe webhook signed with the configured secret
+func serveWebhook(payments *payment.Service, body string) *httptest.ResponseRecorder {
+	mac := hmac.New(sha256.New, []byte(env.Config.Payment.WebhookSecret))
+	now := time.Now().Unix()
+	fmt.Fprintf(mac, "%d.%s", now, body)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/payments/webhooks", strings.NewReader(body))
+	c.Request.Header.Set(payment.StripeSignatureHeader, fmt.Sprintf("t=%d,v1=%s", now, hex.EncodeToString(mac.Sum(nil))))
+	payments.HandleWebhook(c)
+	return w
+}
+
+func TestIntentChargesPlanPrice(t *testing.T) {
+	env.Reset(t)
+	payments := env.Payments(env.Subscriptions())
+	pro := createPlan(t, plan.CreatePlanRequest{Name: "Pro", Price: decimal.RequireFromString("19.99"), Currency: "USD", BillingCycle: "monthly"})
+	jane := createUser(t, "jane")
+
+	// Underpaying is refused before anything is created
+	body := fmt.Sprintf(`{"user_id": %q, "plan_id": %q, "amount": "0.01", "currency": "USD"}`, jane.ID, pro.ID)
+	w := testenv.Serve(payments.CreatePaymentIntent, http.MethodPost, "/api/v1/payments/intents", body)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	var pending int
+	require.NoError(t, env.DB.QueryRow(`SELECT COUNT(*) FROM payment_intents`).Scan(&pending))
+	assert.Zero(t, pending)
+
+	// Without an amount the intent is priced from the plan
+	body = fmt.Sprintf(`{"user_id": %q, "plan_id": %q}`, jane.ID, pro.ID)
+	w = testenv.Serve(payments.CreatePaymentIntent, http.MethodPost, "/api/v1/payments/intents", body)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var intent payment.IntentResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &intent))
+	assert.True(t, intent.Amount.Equal(pro.Price), intent.Amount.String())
+	assert.Equal(t, "USD", intent.Currency)
+
+	sub, err := env.Subscriptions().GetSubscriptionByID(context.Background(), intent.SubscriptionID)
+	require.NoError(t, err)
+	assert.True(t, sub.Amount.Equal(pro.Price))
+}
+
+func TestWebhookRedeliveryIsStoredOnce(t *testing.T) {
+	env.Reset(t)
+	payments := env.Payments(env.Subscriptions())
+	body := `{"id": "evt_1NqJ2x", "type": "customer.created", "data": {"id": "cus_1"}}`
+
+	status := func() string {
+		w := serveWebhook(payments, body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response["status"]
+	}
+	assert.Equal(t, "received", status())
+	assert.Equal(t, "duplicate", status())
+
+	var stored int
+	require.NoError(t, env.DB.QueryRow(`
+		SELECT COUNT(*) FROM webhook_events WHERE source = 'stripe' AND provider_event_id = 'evt_1NqJ2x'
+	`).Scan(&stored))
+	assert.Equal(t, 1, stored)
+}
+
+func TestWebhookIsProcessedByJob(t *testing.T) {
+	env.Reset(t)
+	queue := env.Queue()
+	payments := env.Payments(env.Subscriptions())
+	w := serveWebhook(payments, `{"id": "evt_1NqJ3a", "type": "customer.created", "data": {"id": "cus_1"}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Receiving it only queues it, once however often it is delivered
+	processed := func() bool {
+		var done bool
+		require.NoError(t, env.DB.QueryRow(`
+			SELECT COALESCE(processed, false) FROM webhook_events WHERE provider_event_id = 'evt_1NqJ3a'
+		`).Scan(&done))
+		return done
+	}
+	serveWebhook(payments, `{"id": "evt_1NqJ3a", "type": "customer.created", "data": {"id": "cus_1"}}`)
+	var queued int
+	require.NoError(t, env.DB.QueryRow(`SELECT COUNT(*) FROM jobs WHERE kind = $1`, payment.JobWebhookEvent).Scan(&queued))
+	assert.Equal(t, 1, queued)
+	assert.False(t, processed())
+
+	runner := jobs.NewRunner(queue, env.Config.Jobs)
+	payments.RegisterJobs(runner, env.Config.Subscription)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runner.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, processed, 5*time.Second, 50*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestReplayRefusesProcessedEvents(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	payments := env.Payments(subs)
+	basic := createPlan(t, plan.CreatePlanRequest{Name: "Basic", Price: decimal.RequireFromString("9.99"), Currency: "USD", BillingCycle: "monthly"})
+	jane := createUser(t, "jane")
+
+	start := time.Now().AddDate(0, 0, -3)
+	end := start.AddDate(0, 1, 0)
+	sub, err := subs.ImportSubscription(ctx, subscription.ImportSubscriptionRequest{
+		UserID: jane.ID, PlanID: basic.ID, Status: "active", StartDate: &start, EndDate: &end, ExternalRef: "sub_1",
+	})
+	require.NoError(t, err)
+
+	// The failed charge of the period before, handled before the renewal
+	payload := fmt.Sprintf(`{"id": "evt_failed", "type": "payment_intent.payment_failed", "data": {"id": "pi_1", "user_id": %q, "subscription_id": %q}}`, jane.ID, sub.ID)
+	var id string
+	require.NoError(t, env.DB.QueryRow(`
+		INSERT INTO webhook_events (provider_event_id, event_type, source, payload, processed, processed_at)
+		VALUES ('evt_failed', 'payment_intent.payment_failed', 'stripe', $1, true, NOW())
+		RETURNING id
+	`, payload).Scan(&id))
+	param := gin.Param{Key: "id", Value: id}
+
+	w := testenv.Serve(payments.ReplayWebhookEvent, http.MethodPost, "/api/v1/admin/webhook-events/"+id+"/replay", "", param)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	current, err := subs.GetSubscriptionByID(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "active", current.Status)
+
+	// Forcing it runs the handlers again, with their effects
+	w = testenv.Serve(payments.ReplayWebhookEvent, http.MethodPost, "/api/v1/admin/webhook-events/"+id+"/replay?force=true", "", param)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var replayed payment.StoredWebhookEvent
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &replayed))
+	assert.Equal(t, 1, replayed.ReplayCount)
+	current, err = subs.GetSubscriptionByID(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "past_due", current.Status)
+}
+
+func TestRefundPolicyFollowsSubscriberTenant(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	policies := env.Config.Payment.TenantRefundPolicies
+	t.Cleanup(func() { env.Config.Payment.TenantRefundPolicies = policies })
+	env.Config.Payment.TenantRefundPolicies = map[string]string{"acme": "credit"}
+
+	subs := env.Subscriptions()
+	payments := env.Payments(subs)
+	basic := createPlan(t, plan.CreatePlanRequest{Name: "Basic", Price: decimal.RequireFromString("9.99"), Currency: "USD", BillingCycle: "monthly"})
+	cancel := func(username, tenantID string) string {
+		u := createUser(t, username)
+		_, err := env.DB.Exec(`UPDATE users SET tenant_id = $2 WHERE id = $1`, u.ID, tenantID)
+		require.NoError(t, err)
+		start := time.Now().AddDate(0, 0, -3)
+		end := start.AddDate(0, 1, 0)
+		sub, err := subs.ImportSubscription(ctx, subscription.ImportSubscriptionRequest{
+			UserID: u.ID, PlanID: basic.ID, Status: "active", StartDate: &start, EndDate: &end,
+		})
+		require.NoError(t, err)
+
+		w := testenv.Serve(payments.CancelImmediately, http.MethodPost, "/api/v1/subscriptions/"+sub.ID+"/cancel-immediately", "",
+			gin.Param{Key: "id", Value: sub.ID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response payment.CancellationResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.RefundPolicy
+	}
+
+	// The policy is the one of the tenant the user belongs to
+	assert.Equal(t, "credit", cancel("jane", "acme"))
+	assert.Equal(t, "none", cancel("john", ""))
+}
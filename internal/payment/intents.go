@@ -0,0 +1,451 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/risk"
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// Local payment intent states. The gateway's finer-grained states all map to
+// pending until the payment either succeeds or fails for good.
+const (
+	intentPending   = "pending"
+	intentSucceeded = "succeeded"
+	intentFailed    = "failed"
+)
+
+// PaymentIntent links a gateway intent to the pending subscription it pays for.
+type PaymentIntent struct {
+	ID              string          `json:"id" db:"id"`
+	Gateway         string          `json:"gateway" db:"gateway"`
+	GatewayIntentID string          `json:"gateway_intent_id" db:"gateway_intent_id"`
+	SubscriptionID  string          `json:"subscription_id" db:"subscription_id"`
+	UserID          string          `json:"user_id" db:"user_id"`
+	PlanID          string          `json:"plan_id" db:"plan_id"`
+	Amount          decimal.Decimal `json:"amount" db:"amount"`
+	Currency        string          `json:"currency" db:"currency"`
+	Status          string          `json:"status" db:"status"`
+	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// CreateIntentRequest starts checkout for a plan. The intent is priced from
+// the plan by QuoteCharge; Amount and Currency are optional and, when sent,
+// must match that price.
+type CreateIntentRequest struct {
+	UserID        string           `json:"user_id" binding:"required"`
+	PlanID        string           `json:"plan_id" binding:"required"`
+	Amount        *decimal.Decimal `json:"amount"`
+	Currency      string           `json:"currency"`
+	PaymentMethod string           `json:"payment_method" binding:"omitempty,payment_token"`
+	AutoRenew     *bool            `json:"auto_renew"`
+	Description   string           `json:"description"`
+}
+
+// IntentResponse is returned to the frontend. ClientSecret is only present
+// when the intent is created; GatewayStatus tells the frontend whether it
+// still has to confirm or authenticate the payment.
+type IntentResponse struct {
+	IntentID       string          `json:"intent_id"`
+	ClientSecret   string          `json:"client_secret,omitempty"`
+	Gateway        string          `json:"gateway"`
+	Status         string          `json:"status"`
+	GatewayStatus  string          `json:"gateway_status,omitempty"`
+	SubscriptionID string          `json:"subscription_id"`
+	Amount         decimal.Decimal `json:"amount"`
+	Currency       string          `json:"currency"`
+}
+
+// CreatePaymentIntent starts checkout: it creates a pending subscription and
+// a gateway payment intent, and returns the client secret the frontend uses
+// to confirm the payment. The subscription activates once the intent succeeds.
+func (s *Service) CreatePaymentIntent(c *gin.Context) {
+	var req CreateIntentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPaymentOperation("create_intent", "validation_error")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	if !s.checkoutAllowed(c, "create_intent", req.UserID, req.PlanID) {
+		return
+	}
+
+	charge, ok := s.priceIntent(c, req)
+	if !ok {
+		return
+	}
+
+	if !s.checkRisk(c, "create_intent", risk.Attempt{
+		UserID:        req.UserID,
+		IP:            c.ClientIP(),
+		PaymentMethod: req.PaymentMethod,
+		PlanID:        req.PlanID,
+		Amount:        charge.Total,
+		Currency:      charge.Currency,
+	}) {
+		return
+	}
+
+	if !s.circuitBreaker.CanExecute() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment service temporarily unavailable"})
+		telemetry.RecordPaymentOperation("create_intent", "circuit_breaker_open")
+		return
+	}
+
+	autoRenew := true
+	if req.AutoRenew != nil {
+		autoRenew = *req.AutoRenew
+	}
+	sub, err := s.subscriptionSvc.CreatePending(ctx, req.UserID, req.PlanID, req.PaymentMethod, charge.Total, charge.Currency, autoRenew)
+	if err != nil {
+		logrus.Errorf("Failed to create pending subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("create_intent", "db_error")
+		return
+	}
+
+	gateway := s.gatewayFor(ctx, req.UserID, charge.Currency)
+	gwIntent, err := gateway.CreateIntent(ctx, PaymentRequest{
+		UserID:         req.UserID,
+		PlanID:         req.PlanID,
+		Amount:         charge.Total,
+		Currency:       charge.Currency,
+		PaymentMethod:  req.PaymentMethod,
+		Description:    req.Description,
+		SubscriptionID: sub.ID,
+	})
+	if err != nil {
+		s.circuitBreaker.RecordFailure()
+		logrus.Errorf("Failed to create payment intent: %v", err)
+		if err := s.subscriptionSvc.CancelPending(ctx, sub.ID); err != nil {
+			logrus.Errorf("Failed to cancel pending subscription %s: %v", sub.ID, err)
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Payment gateway error"})
+		telemetry.RecordPaymentOperation("create_intent", "gateway_error")
+		return
+	}
+	s.circuitBreaker.RecordSuccess()
+
+	intent := &PaymentIntent{
+		Gateway:         gateway.Name(),
+		GatewayIntentID: gwIntent.ID,
+		SubscriptionID:  sub.ID,
+		UserID:          req.UserID,
+		PlanID:          req.PlanID,
+		Amount:          charge.Total,
+		Currency:        charge.Currency,
+		Status:          intentPending,
+	}
+	if err := storeIntent(ctx, s.db, intent); err != nil {
+		logrus.Errorf("Failed to store payment intent %s: %v", gwIntent.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("create_intent", "db_error")
+		return
+	}
+
+	response := intentResponse(intent, gwIntent.Status)
+	response.ClientSecret = gwIntent.ClientSecret
+	c.JSON(http.StatusCreated, response)
+	telemetry.RecordPaymentOperation("create_intent", "success")
+}
+
+// priceIntent prices the plan of an intent on the server, as checkout
+// sessions do, and checks it against the amount and currency the client
+// sent, if any. Otherwise it responds and records the outcome.
+func (s *Service) priceIntent(c *gin.Context, req CreateIntentRequest) (subscription.Charge, bool) {
+	charge, err := s.subscriptionSvc.QuoteCharge(c.Request.Context(), req.UserID, req.PlanID)
+	if err != nil {
+		if errors.Is(err, subscription.ErrPlanUnavailable) || errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Plan is not available"})
+			telemetry.RecordPaymentOperation("create_intent", "validation_error")
+			return charge, false
+		}
+		logrus.Errorf("Failed to price plan %s: %v", req.PlanID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("create_intent", "db_error")
+		return charge, false
+	}
+	if !charge.MatchesClient(req.Amount, req.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount or currency does not match the plan price", "charge": charge})
+		telemetry.RecordPaymentOperation("create_intent", "price_mismatch")
+		return charge, false
+	}
+	return charge, true
+}
+
+// checkoutAllowed reports whether userID may start paying for planID: the
+// plan must exist and be paid, and the user may only have a free
+// subscription, which the paid one replaces once it activates. Otherwise it
+// responds and records the outcome of op.
+func (s *Service) checkoutAllowed(c *gin.Context, op, userID, planID string) bool {
+	ctx := c.Request.Context()
+
+	planIsFree, err := s.subscriptionSvc.IsFreePlan(ctx, planID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Plan not found"})
+			telemetry.RecordPaymentOperation(op, "validation_error")
+			return false
+		}
+		logrus.Errorf("Failed to get plan type: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation(op, "db_error")
+		return false
+	}
+	if planIsFree {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Free plans need no payment; create the subscription directly"})
+		telemetry.RecordPaymentOperation(op, "validation_error")
+		return false
+	}
+
+	existing, err := s.subscriptionSvc.GetActiveSubscriptionByUserID(ctx, userID)
+	if err != nil && err != sql.ErrNoRows {
+		logrus.Errorf("Failed to check existing subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation(op, "db_error")
+		return false
+	}
+	if existing != nil {
+		// Free subscriptions are replaced once the paid one activates
+		isFree, err := s.subscriptionSvc.IsFreePlan(ctx, existing.PlanID)
+		if err != nil {
+			logrus.Errorf("Failed to get plan type: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			telemetry.RecordPaymentOperation(op, "db_error")
+			return false
+		}
+		if !isFree {
+			c.JSON(http.StatusConflict, gin.H{"error": "User already has an active subscription"})
+			telemetry.RecordPaymentOperation(op, "conflict")
+			return false
+		}
+	}
+	return true
+}
+
+// ConfirmPaymentIntent is called by the frontend after the customer confirms
+// the payment. The outcome is read from the gateway, never from the caller:
+// 200 once the payment succeeded and the subscription is active, 202 while
+// the gateway still waits on the customer or the payment settles, 402 if the
+// payment failed. A payment still settling, e.g. a bank debit, activates the
+// subscription right away when payment.pending_access is grant.
+func (s *Service) ConfirmPaymentIntent(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	intent, err := s.getIntent(ctx, "id", c.Param("id"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment intent not found"})
+			telemetry.RecordPaymentOperation("confirm_intent", "not_found")
+			return
+		}
+		logrus.Errorf("Failed to get payment intent: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("confirm_intent", "db_error")
+		return
+	}
+
+	// Already settled, e.g. by the webhook
+	if intent.Status != intentPending {
+		s.respondIntent(c, intent, "")
+		return
+	}
+
+	gwIntent, err := s.gatewayNamed(intent.Gateway).GetIntent(ctx, intent.GatewayIntentID)
+	if err != nil {
+		logrus.Errorf("Failed to fetch payment intent %s: %v", intent.GatewayIntentID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Payment gateway error"})
+		telemetry.RecordPaymentOperation("confirm_intent", "gateway_error")
+		return
+	}
+
+	if err := s.settleIntent(ctx, intent, intentOutcome(gwIntent.Status)); err != nil {
+		logrus.Errorf("Failed to settle payment intent %s: %v", intent.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("confirm_intent", "db_error")
+		return
+	}
+	if gwIntent.Status == IntentProcessing {
+		s.grantWhileProcessing(ctx, intent)
+	}
+
+	settled, err := s.getIntent(ctx, "id", intent.ID)
+	if err != nil {
+		logrus.Errorf("Failed to reload payment intent %s: %v", intent.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("confirm_intent", "db_error")
+		return
+	}
+	s.respondIntent(c, settled, gwIntent.Status)
+}
+
+func (s *Service) respondIntent(c *gin.Context, intent *PaymentIntent, gatewayStatus string) {
+	switch intent.Status {
+	case intentSucceeded:
+		c.JSON(http.StatusOK, intentResponse(intent, gatewayStatus))
+		telemetry.RecordPaymentOperation("confirm_intent", "success")
+	case intentFailed:
+		c.JSON(http.StatusPaymentRequired, intentResponse(intent, gatewayStatus))
+		telemetry.RecordPaymentOperation("confirm_intent", "failed")
+	default:
+		c.JSON(http.StatusAccepted, intentResponse(intent, gatewayStatus))
+		telemetry.RecordPaymentOperation("confirm_intent", "pending")
+	}
+}
+
+func intentResponse(intent *PaymentIntent, gatewayStatus string) IntentResponse {
+	return IntentResponse{
+		IntentID:       intent.ID,
+		Gateway:        intent.Gateway,
+		Status:         intent.Status,
+		GatewayStatus:  gatewayStatus,
+		SubscriptionID: intent.SubscriptionID,
+		Amount:         intent.Amount,
+		Currency:       intent.Currency,
+	}
+}
+
+// intentOutcome maps a gateway status onto the local intent states. A
+// declined card leaves a Stripe intent in requires_payment_method so the
+// customer can retry, so only cancellation is a failure here; declines are
+// settled by the payment_failed webhook.
+func intentOutcome(gatewayStatus string) string {
+	switch gatewayStatus {
+	case IntentSucceeded:
+		return intentSucceeded
+	case IntentCanceled:
+		return intentFailed
+	default:
+		return intentPending
+	}
+}
+
+// settleIntent moves a pending intent to outcome: success records the
+// transaction and activates the subscription, failure cancels it. Only the
+// first caller to move the intent out of pending acts, so the confirm
+// callback and the webhook can race safely.
+func (s *Service) settleIntent(ctx context.Context, intent *PaymentIntent, outcome string) error {
+	switch outcome {
+	case intentSucceeded:
+		claimed, err := s.transitionIntent(ctx, intent.ID, intentSucceeded)
+		if err != nil || !claimed {
+			return err
+		}
+		req := PaymentRequest{
+			UserID:         intent.UserID,
+			PlanID:         intent.PlanID,
+			Amount:         intent.Amount,
+			Currency:       intent.Currency,
+			SubscriptionID: intent.SubscriptionID,
+		}
+		if err := s.storeTransaction(ctx, req, &PaymentResponse{
+			TransactionID: intent.GatewayIntentID,
+			Status:        "completed",
+			Amount:        intent.Amount,
+			Currency:      intent.Currency,
+			CreatedAt:     time.Now(),
+			GatewayID:     intent.GatewayIntentID,
+			Gateway:       intent.Gateway,
+		}); err != nil {
+			logrus.Errorf("Failed to store transaction for payment intent %s: %v", intent.ID, err)
+		}
+		_, err = s.subscriptionSvc.ActivatePending(ctx, intent.SubscriptionID)
+		return err
+
+	case intentFailed:
+		claimed, err := s.transitionIntent(ctx, intent.ID, intentFailed)
+		if err != nil || !claimed {
+			return err
+		}
+		if err := s.subscriptionSvc.CancelPending(ctx, intent.SubscriptionID); err != nil {
+			return err
+		}
+		// Activated early while the payment was processing
+		return s.subscriptionSvc.RevokeUnpaid(ctx, intent.SubscriptionID)
+	}
+	return nil
+}
+
+// settleIntentFromEvent settles the intent a payment_intent.* webhook event
+// refers to, if it was created through checkout.
+func (s *Service) settleIntentFromEvent(ctx context.Context, event WebhookEvent, outcome string) {
+	gatewayIntentID, _ := event.Data["id"].(string)
+	if gatewayIntentID == "" {
+		return
+	}
+
+	intent, err := s.getIntent(ctx, "gateway_intent_id", gatewayIntentID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logrus.Errorf("Failed to get payment intent %s: %v", gatewayIntentID, err)
+		}
+		return
+	}
+	if intent.Status != intentPending {
+		return
+	}
+	if err := s.settleIntent(ctx, intent, outcome); err != nil {
+		logrus.Errorf("Failed to settle payment intent %s: %v", intent.ID, err)
+	}
+}
+
+// rowQuerier is satisfied by both the connection and a transaction
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func storeIntent(ctx context.Context, q rowQuerier, intent *PaymentIntent) error {
+	return q.QueryRowContext(ctx, `
+		INSERT INTO payment_intents (gateway, gateway_intent_id, subscription_id, user_id,
+			plan_id, amount, currency, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at, updated_at
+	`, intent.Gateway, intent.GatewayIntentID, intent.SubscriptionID, intent.UserID,
+		intent.PlanID, intent.Amount, intent.Currency, intent.Status,
+	).Scan(&intent.ID, &intent.CreatedAt, &intent.UpdatedAt)
+}
+
+// getIntent loads an intent by column, which is either id or gateway_intent_id.
+func (s *Service) getIntent(ctx context.Context, column, value string) (*PaymentIntent, error) {
+	query := `
+		SELECT id, gateway, gateway_intent_id, subscription_id, user_id, plan_id,
+			amount, currency, status, created_at, updated_at
+		FROM payment_intents WHERE ` + column + `::text = $1
+	`
+	var intent PaymentIntent
+	err := s.db.QueryRowContext(ctx, query, value).Scan(
+		&intent.ID, &intent.Gateway, &intent.GatewayIntentID, &intent.SubscriptionID,
+		&intent.UserID, &intent.PlanID, &intent.Amount, &intent.Currency, &intent.Status,
+		&intent.CreatedAt, &intent.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &intent, nil
+}
+
+// transitionIntent moves a pending intent to status and reports whether this
+// call made the change.
+func (s *Service) transitionIntent(ctx context.Context, id, status string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE payment_intents SET status = $2, updated_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`, id, status)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
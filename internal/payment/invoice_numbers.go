@@ -0,0 +1,70 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// seqField marks where an invoice number's place in its series goes
+const seqField = "{seq}"
+
+// invoiceSeries is the series an invoice is numbered in: its number format
+// with every field but {seq} filled in. Each series counts from 1 without
+// gaps in invoice_series.
+type invoiceSeries struct {
+	prefix string
+	suffix string
+}
+
+// newInvoiceSeries fills in format for an invoice of tenant to a customer
+// in country, in currency and issued at issued (in UTC)
+func newInvoiceSeries(format, tenant, country, currency string, issued time.Time) invoiceSeries {
+	issued = issued.UTC()
+	fields := strings.NewReplacer(
+		"{tenant}", strings.ToUpper(tenant),
+		"{country}", strings.ToUpper(country),
+		"{currency}", strings.ToUpper(currency),
+		"{year}", issued.Format("2006"),
+		"{month}", issued.Format("01"),
+	)
+	prefix, suffix, _ := strings.Cut(format, seqField)
+	return invoiceSeries{prefix: fields.Replace(prefix), suffix: fields.Replace(suffix)}
+}
+
+// key names the series in invoice_series
+func (s invoiceSeries) key() string {
+	return s.prefix + seqField + s.suffix
+}
+
+// number is the series' seq-th invoice number, seq zero padded to digits
+func (s invoiceSeries) number(seq int64, digits int) string {
+	return fmt.Sprintf("%s%0*d%s", s.prefix, digits, seq, s.suffix)
+}
+
+// numberFormat is the invoice number format of tenant
+func (s *Service) numberFormat(tenantID string) string {
+	if format, ok := s.cfg.Invoicing.TenantNumberFormats[tenantID]; ok && tenantID != "" {
+		return format
+	}
+	return s.cfg.Invoicing.NumberFormat
+}
+
+// nextInvoiceNumber takes the next number of series within tx. The series
+// row stays locked until tx ends, so concurrent invoices in the series wait
+// their turn, and a rolled back invoice gives its number back.
+func nextInvoiceNumber(ctx context.Context, tx *sql.Tx, series invoiceSeries, digits int) (string, error) {
+	var seq int64
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO invoice_series (series, last_number) VALUES ($1, 1)
+		ON CONFLICT (series) DO UPDATE
+		SET last_number = invoice_series.last_number + 1, updated_at = NOW()
+		RETURNING last_number
+	`, series.key()).Scan(&seq)
+	if err != nil {
+		return "", err
+	}
+	return series.number(seq, digits), nil
+}
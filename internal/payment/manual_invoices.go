@@ -0,0 +1,555 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/middleware"
+	"scalable-paywall/internal/money"
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// PaymentMethodInvoice is the payment method of subscriptions paid by
+// manual invoice. Their renewals issue a new invoice instead of charging a
+// gateway.
+const PaymentMethodInvoice = "manual_invoice"
+
+// Manual invoice states. An open invoice becomes overdue after its due date
+// and is voided once payment.invoicing.cancel_after_days have passed too.
+const (
+	InvoiceOpen    = "open"
+	InvoiceOverdue = "overdue"
+	InvoicePaid    = "paid"
+	InvoiceVoid    = "void"
+)
+
+// BankSignatureHeader carries the hex HMAC-SHA256 of a bank webhook's body,
+// keyed with payment.invoicing.webhook_secret.
+const BankSignatureHeader = "X-Bank-Signature"
+
+// invoicePaidByBank is recorded as the payer of invoices settled by the bank
+// webhook; invoices marked paid by an admin record the admin.
+const invoicePaidByBank = "bank"
+
+var ErrInvoiceClosed = errors.New("invoice is already paid or void")
+
+var validInvoiceStatuses = map[string]bool{
+	"":             true,
+	InvoiceOpen:    true,
+	InvoiceOverdue: true,
+	InvoicePaid:    true,
+	InvoiceVoid:    true,
+}
+
+// ManualInvoice is an invoice paid offline, e.g. by bank transfer. Number is
+// the reference the customer quotes with the payment, sequential within its
+// series (see payment.invoicing.number_format). It pays for the first
+// period of a pending_payment subscription or for a renewal. VATID is the
+// business customer's VAT ID when it was issued, which decides its
+// TaxTreatment.
+type ManualInvoice struct {
+	ID               string          `json:"id" db:"id"`
+	Number           string          `json:"number" db:"number"`
+	TenantID         string          `json:"tenant_id,omitempty" db:"tenant_id"`
+	SubscriptionID   *string         `json:"subscription_id,omitempty" db:"subscription_id"`
+	UserID           string          `json:"user_id" db:"user_id"`
+	PlanID           string          `json:"plan_id" db:"plan_id"`
+	Amount           decimal.Decimal `json:"amount" db:"amount"`
+	Currency         string          `json:"currency" db:"currency"`
+	VATID            *string         `json:"vat_id,omitempty" db:"vat_id"`
+	TaxTreatment     string          `json:"tax_treatment" db:"tax_treatment"`
+	Status           string          `json:"status" db:"status"`
+	DueAt            time.Time       `json:"due_at" db:"due_at"`
+	PaidAt           *time.Time      `json:"paid_at,omitempty" db:"paid_at"`
+	PaidBy           *string         `json:"paid_by,omitempty" db:"paid_by"`
+	PaymentReference *string         `json:"payment_reference,omitempty" db:"payment_reference"`
+	CreatedAt        time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+type ManualInvoiceListResponse struct {
+	Invoices   []ManualInvoice `json:"invoices"`
+	Limit      int             `json:"limit"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type CreateInvoiceRequest struct {
+	UserID    string          `json:"user_id" binding:"required"`
+	PlanID    string          `json:"plan_id" binding:"required"`
+	Amount    decimal.Decimal `json:"amount" binding:"required,gt=0"`
+	Currency  string          `json:"currency" binding:"required"`
+	VATID     string          `json:"vat_id" binding:"max=32"`
+	AutoRenew *bool           `json:"auto_renew"`
+}
+
+// MarkInvoicePaidRequest optionally records the payment's own reference,
+// e.g. the bank transfer or blockchain transaction ID.
+type MarkInvoicePaidRequest struct {
+	Reference string `json:"reference" binding:"max=255"`
+}
+
+// BankPayment is an incoming payment reported by the bank webhook.
+// Reference is the invoice number the payer quoted.
+type BankPayment struct {
+	Reference     string          `json:"reference" binding:"required"`
+	Amount        decimal.Decimal `json:"amount" binding:"required,gt=0"`
+	Currency      string          `json:"currency" binding:"required"`
+	TransactionID string          `json:"transaction_id" binding:"max=255"`
+}
+
+// CreateManualInvoice starts checkout by invoice, for customers who pay
+// offline: it creates a pending_payment subscription and an invoice for its
+// first period. The subscription activates once the invoice is paid.
+func (s *Service) CreateManualInvoice(c *gin.Context) {
+	var req CreateInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPaymentOperation("create_invoice", "validation_error")
+		return
+	}
+
+	if !money.IsValid(req.Amount, req.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Amount has more decimal places than %s allows", req.Currency)})
+		telemetry.RecordPaymentOperation("create_invoice", "validation_error")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	if !s.checkoutAllowed(c, "create_invoice", req.UserID, req.PlanID) {
+		return
+	}
+	if _, ok := s.checkVATID(c, "create_invoice", req.UserID, req.VATID); !ok {
+		return
+	}
+
+	autoRenew := true
+	if req.AutoRenew != nil {
+		autoRenew = *req.AutoRenew
+	}
+	sub, err := s.subscriptionSvc.CreateInvoiced(ctx, req.UserID, req.PlanID, PaymentMethodInvoice, req.Amount, req.Currency, autoRenew)
+	if err != nil {
+		logrus.Errorf("Failed to create invoiced subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("create_invoice", "db_error")
+		return
+	}
+
+	invoice, err := s.issueInvoice(ctx, sub.ID, req.UserID, req.PlanID, req.Amount, req.Currency)
+	if err != nil {
+		logrus.Errorf("Failed to issue invoice for subscription %s: %v", sub.ID, err)
+		if err := s.subscriptionSvc.CancelInvoiced(ctx, sub.ID); err != nil {
+			logrus.Errorf("Failed to cancel invoiced subscription %s: %v", sub.ID, err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("create_invoice", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusCreated, invoice)
+	telemetry.RecordPaymentOperation("create_invoice", "success")
+}
+
+// MarkInvoicePaid records an invoice as paid on an admin's word, e.g. after
+// reconciling a bank statement, and activates or renews the subscription it
+// is for. The admin is taken from X-User-ID. 409 once paid or void.
+func (s *Service) MarkInvoicePaid(c *gin.Context) {
+	var req MarkInvoicePaidRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPaymentOperation("invoice_paid", "validation_error")
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	invoice, err := s.payInvoice(ctx, "id", id, c.GetHeader(middleware.UserHeader), req.Reference)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		telemetry.RecordPaymentOperation("invoice_paid", "not_found")
+		return
+	case errors.Is(err, ErrInvoiceClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Invoice is already " + invoice.Status})
+		telemetry.RecordPaymentOperation("invoice_paid", "invalid_status")
+		return
+	case err != nil:
+		logrus.Errorf("Failed to mark invoice %s paid: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("invoice_paid", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+	telemetry.RecordPaymentOperation("invoice_paid", "success")
+}
+
+// HandleBankWebhook receives incoming payments from the bank, signed with
+// payment.invoicing.webhook_secret. A payment quoting an open or overdue
+// invoice's number, in its currency and for at least its amount, pays the
+// invoice. Payments that match no invoice or fall short are acknowledged
+// and logged for manual reconciliation, so the bank does not redeliver them.
+func (s *Service) HandleBankWebhook(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read webhook body"})
+		return
+	}
+	if !s.verifyBankSignature(body, c.GetHeader(BankSignatureHeader)) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+		return
+	}
+
+	var payment BankPayment
+	if err := binding.JSON.BindBody(body, &payment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	invoice, err := s.getInvoice(ctx, "number", strings.ToUpper(strings.TrimSpace(payment.Reference)))
+	if errors.Is(err, sql.ErrNoRows) {
+		logrus.Warnf("Bank payment %s quotes unknown invoice %q", payment.TransactionID, payment.Reference)
+		c.JSON(http.StatusOK, gin.H{"status": "unmatched"})
+		telemetry.RecordPaymentOperation("bank_webhook", "unmatched")
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to get invoice %s: %v", payment.Reference, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook"})
+		telemetry.RecordPaymentOperation("bank_webhook", "db_error")
+		return
+	}
+	if !strings.EqualFold(payment.Currency, invoice.Currency) || payment.Amount.LessThan(invoice.Amount) {
+		logrus.Warnf("Bank payment %s of %s %s does not cover invoice %s of %s %s",
+			payment.TransactionID, payment.Amount, payment.Currency, invoice.Number, invoice.Amount, invoice.Currency)
+		c.JSON(http.StatusOK, gin.H{"status": "underpaid"})
+		telemetry.RecordPaymentOperation("bank_webhook", "underpaid")
+		return
+	}
+
+	paid, err := s.payInvoice(ctx, "id", invoice.ID, invoicePaidByBank, payment.TransactionID)
+	switch {
+	case errors.Is(err, ErrInvoiceClosed):
+		// A redelivery, or the invoice was voided before the money arrived
+		logrus.Warnf("Bank payment %s for invoice %s arrived after it was %s", payment.TransactionID, invoice.Number, paid.Status)
+		c.JSON(http.StatusOK, gin.H{"status": "already_" + paid.Status})
+		telemetry.RecordPaymentOperation("bank_webhook", "invalid_status")
+		return
+	case err != nil:
+		logrus.Errorf("Failed to mark invoice %s paid: %v", invoice.Number, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook"})
+		telemetry.RecordPaymentOperation("bank_webhook", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "paid"})
+	telemetry.RecordPaymentOperation("bank_webhook", "success")
+}
+
+// ListManualInvoices returns manual invoices newest first using cursor
+// pagination. Optional filters: status, user_id.
+func (s *Service) ListManualInvoices(c *gin.Context) {
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+
+	status := c.Query("status")
+	if !validInvoiceStatuses[status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open, overdue, paid or void"})
+		telemetry.RecordPaymentOperation("invoice_list", "validation_error")
+		return
+	}
+
+	cursor, err := db.ParseCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		telemetry.RecordPaymentOperation("invoice_list", "validation_error")
+		return
+	}
+
+	invoices, nextCursor, err := s.listInvoices(c.Request.Context(), status, c.Query("user_id"), cursor, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list invoices: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("invoice_list", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, ManualInvoiceListResponse{
+		Invoices:   invoices,
+		Limit:      limit,
+		NextCursor: nextCursor,
+	})
+	telemetry.RecordPaymentOperation("invoice_list", "success")
+}
+
+// invoiceRenewal renews a subscription paid by invoice: instead of a charge
+// it issues an invoice for the next period, which is held as the renewal's
+// pending charge until it is paid or voided.
+func (s *Service) invoiceRenewal(ctx context.Context, claim subscription.RenewalClaim, op string) {
+	sub := claim.Subscription
+
+	invoice, err := s.issueInvoice(ctx, sub.ID, sub.UserID, sub.PlanID, claim.Amount(), sub.Currency)
+	if err != nil {
+		logrus.Errorf("Failed to issue renewal invoice for subscription %s: %v", sub.ID, err)
+		if err := s.subscriptionSvc.ReleaseClaim(ctx, sub.ID); err != nil {
+			logrus.Errorf("Failed to release claim on subscription %s: %v", sub.ID, err)
+		}
+		telemetry.RecordPaymentOperation(op, "db_error")
+		return
+	}
+	if err := s.subscriptionSvc.HoldRenewal(ctx, sub.ID, invoice.Number, sub.NextPeriodEnd(), s.grantsPendingAccess()); err != nil {
+		logrus.Errorf("Failed to record renewal invoice %s of subscription %s: %v", invoice.Number, sub.ID, err)
+		telemetry.RecordPaymentOperation(op, "db_error")
+		return
+	}
+	telemetry.RecordPaymentOperation(op, "invoiced")
+}
+
+// processInvoices moves open invoices past their due date to overdue and
+// voids those overdue for longer than cancel_after_days. Voiding cancels a
+// subscription still waiting on its first invoice and undoes a renewal
+// waiting on one, leaving the subscription past_due.
+func (s *Service) processInvoices(ctx context.Context) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE manual_invoices SET status = 'overdue', updated_at = NOW()
+		WHERE status = 'open' AND due_at <= NOW()
+	`)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logrus.Infof("%d manual invoices became overdue", n)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE manual_invoices SET status = 'void', updated_at = NOW()
+		WHERE status = 'overdue' AND due_at + make_interval(days => $1) <= NOW()
+		RETURNING number, subscription_id
+	`, s.cfg.Invoicing.CancelAfterDays)
+	if err != nil {
+		return err
+	}
+	type voided struct {
+		number         string
+		subscriptionID *string
+	}
+	var void []voided
+	for rows.Next() {
+		var v voided
+		if err := rows.Scan(&v.number, &v.subscriptionID); err != nil {
+			rows.Close()
+			return err
+		}
+		void = append(void, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, v := range void {
+		telemetry.RecordPaymentOperation("invoice_void", "success")
+		if v.subscriptionID != nil {
+			if err := s.subscriptionSvc.CancelInvoiced(ctx, *v.subscriptionID); err != nil {
+				logrus.Errorf("Failed to cancel subscription %s of void invoice %s: %v", *v.subscriptionID, v.number, err)
+			}
+		}
+		if _, err := s.subscriptionSvc.FailPendingRenewal(ctx, v.number); err != nil {
+			logrus.Errorf("Failed to undo renewal of void invoice %s: %v", v.number, err)
+		}
+	}
+	return nil
+}
+
+// issueInvoice numbers and stores an invoice in one transaction, so the
+// number is only used up when the invoice is. The tenant the user was
+// created under picks the number format; the series also follows the user's
+// country. The user's VAT ID, if they gave one, is printed on the invoice
+// and decides its treatment.
+func (s *Service) issueInvoice(ctx context.Context, subscriptionID, userID, planID string, amount decimal.Decimal, currency string) (*ManualInvoice, error) {
+	currency = strings.ToUpper(currency)
+	now := time.Now()
+	dueAt := now.AddDate(0, 0, s.cfg.Invoicing.DueDays)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var tenantID, country, vatID string
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(tenant_id, ''), COALESCE(country, ''), COALESCE(vat_id, '') FROM users WHERE id = $1
+	`, userID).Scan(&tenantID, &country, &vatID)
+	if err != nil {
+		return nil, fmt.Errorf("load tenant and country of user %s: %w", userID, err)
+	}
+	series := newInvoiceSeries(s.numberFormat(tenantID), tenantID, country, currency, now)
+	number, err := nextInvoiceNumber(ctx, tx, series, s.cfg.Invoicing.NumberDigits)
+	if err != nil {
+		return nil, fmt.Errorf("number invoice in series %s: %w", series.key(), err)
+	}
+
+	row := tx.QueryRowContext(ctx, `
+		INSERT INTO manual_invoices (number, tenant_id, subscription_id, user_id, plan_id, amount, currency,
+			vat_id, tax_treatment, due_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10)
+		RETURNING `+invoiceColumns,
+		number, tenantID, subscriptionID, userID, planID, amount, currency, vatID, s.taxTreatment(vatID), dueAt)
+	invoice, err := scanInvoice(row.Scan)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// payInvoice marks an open or overdue invoice paid, records its payment as a
+// completed transaction and activates or renews the subscription it is
+// for. An invoice already paid or void is returned as it is with
+// ErrInvoiceClosed.
+func (s *Service) payInvoice(ctx context.Context, column, value, paidBy, reference string) (*ManualInvoice, error) {
+	row := s.db.QueryRowContext(ctx, `
+		UPDATE manual_invoices
+		SET status = 'paid', paid_at = NOW(), paid_by = NULLIF($2, ''),
+			payment_reference = NULLIF($3, ''), updated_at = NOW()
+		WHERE `+column+`::text = $1 AND status IN ('open', 'overdue')
+		RETURNING `+invoiceColumns, value, paidBy, reference)
+	invoice, err := scanInvoice(row.Scan)
+	if err == sql.ErrNoRows {
+		current, err := s.getInvoice(ctx, column, value)
+		if err != nil {
+			return nil, err
+		}
+		return current, ErrInvoiceClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptionID string
+	if invoice.SubscriptionID != nil {
+		subscriptionID = *invoice.SubscriptionID
+	}
+	req := PaymentRequest{
+		UserID:         invoice.UserID,
+		PlanID:         invoice.PlanID,
+		Amount:         invoice.Amount,
+		Currency:       invoice.Currency,
+		PaymentMethod:  PaymentMethodInvoice,
+		SubscriptionID: subscriptionID,
+	}
+	if err := s.storeTransaction(ctx, req, &PaymentResponse{
+		TransactionID: invoice.ID,
+		Status:        chargeCompleted,
+		Amount:        invoice.Amount,
+		Currency:      invoice.Currency,
+		CreatedAt:     time.Now(),
+		GatewayID:     invoice.Number,
+	}); err != nil {
+		logrus.Errorf("Failed to store transaction for invoice %s: %v", invoice.Number, err)
+	}
+
+	if subscriptionID != "" {
+		if _, err := s.subscriptionSvc.ActivateInvoiced(ctx, subscriptionID); err != nil {
+			logrus.Errorf("Failed to activate subscription %s paid by invoice %s: %v", subscriptionID, invoice.Number, err)
+		}
+	}
+	if _, err := s.subscriptionSvc.SettleRenewal(ctx, invoice.Number); err != nil {
+		logrus.Errorf("Failed to complete renewal paid by invoice %s: %v", invoice.Number, err)
+	}
+	return invoice, nil
+}
+
+// verifyBankSignature checks the bank webhook's HMAC. Without a secret
+// configured every notification is refused.
+func (s *Service) verifyBankSignature(body []byte, signature string) bool {
+	if s.cfg.Invoicing.WebhookSecret == "" {
+		return false
+	}
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.cfg.Invoicing.WebhookSecret))
+	mac.Write(body)
+	return hmac.Equal(given, mac.Sum(nil))
+}
+
+// invoiceColumns lists the columns scanInvoice expects, in order
+const invoiceColumns = `id, number, tenant_id, subscription_id, user_id, plan_id, amount, currency,
+	vat_id, tax_treatment, status, due_at, paid_at, paid_by, payment_reference, created_at, updated_at`
+
+func scanInvoice(scan func(dest ...interface{}) error) (*ManualInvoice, error) {
+	var i ManualInvoice
+	if err := scan(&i.ID, &i.Number, &i.TenantID, &i.SubscriptionID, &i.UserID, &i.PlanID, &i.Amount,
+		&i.Currency, &i.VATID, &i.TaxTreatment, &i.Status, &i.DueAt, &i.PaidAt, &i.PaidBy,
+		&i.PaymentReference, &i.CreatedAt, &i.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// getInvoice loads an invoice by column, which is either id or number.
+func (s *Service) getInvoice(ctx context.Context, column, value string) (*ManualInvoice, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+invoiceColumns+` FROM manual_invoices WHERE `+column+`::text = $1`, value)
+	return scanInvoice(row.Scan)
+}
+
+func (s *Service) listInvoices(ctx context.Context, status, userID string, cursor *db.Cursor, limit int) ([]ManualInvoice, string, error) {
+	query := `
+		SELECT ` + invoiceColumns + `
+		FROM manual_invoices
+		WHERE ($1 = '' OR status = $1)
+			AND ($2 = '' OR user_id::text = $2)
+			AND ($3::timestamptz IS NULL OR (created_at, id::text) < ($3, $4))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5
+	`
+
+	after, afterID := cursor.After()
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := s.db.Reader().QueryContext(ctx, query, status, userID, after, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var invoices []ManualInvoice
+	for rows.Next() {
+		invoice, err := scanInvoice(rows.Scan)
+		if err != nil {
+			return nil, "", err
+		}
+		invoices = append(invoices, *invoice)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	invoices, nextCursor := db.NextPage(invoices, limit, func(row ManualInvoice) db.Cursor {
+		return db.Cursor{CreatedAt: row.CreatedAt, ID: row.ID}
+	})
+
+	return invoices, nextCursor, nil
+}
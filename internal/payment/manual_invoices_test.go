@@ -0,0 +1,79 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func bankSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyBankSignature(t *testing.T) {
+	body := []byte(`{"reference": "INV-0A1B2C3D4E5F", "amount": "100.00", "currency": "EUR"}`)
+	s := &Service{cfg: &config.PaymentConfig{Invoicing: config.InvoicingConfig{WebhookSecret: "secret"}}}
+
+	assert.True(t, s.verifyBankSignature(body, bankSignature("secret", string(body))))
+	assert.False(t, s.verifyBankSignature(body, bankSignature("other", string(body))))
+	assert.False(t, s.verifyBankSignature(body, "not hex"))
+
+	// Without a secret nothing is accepted
+	s.cfg.Invoicing.WebhookSecret = ""
+	assert.False(t, s.verifyBankSignature(body, bankSignature("", string(body))))
+}
+
+func TestHandleBankWebhookRejectsBadRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Service{cfg: &config.PaymentConfig{Invoicing: config.InvoicingConfig{WebhookSecret: "secret"}}}
+
+	send := func(body, signature string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/payments/webhooks/bank", strings.NewReader(body))
+		c.Request.Header.Set(BankSignatureHeader, signature)
+		s.HandleBankWebhook(c)
+		return w.Code
+	}
+
+	body := `{"reference": "INV-0A1B2C3D4E5F", "amount": "100.00", "currency": "EUR"}`
+	assert.Equal(t, http.StatusUnauthorized, send(body, bankSignature("other", body)))
+
+	missing := `{"amount": "100.00", "currency": "EUR"}`
+	assert.Equal(t, http.StatusBadRequest, send(missing, bankSignature("secret", missing)))
+}
+
+func TestInvoiceSeries(t *testing.T) {
+	issued := time.Date(2026, 3, 31, 23, 30, 0, 0, time.FixedZone("", -2*3600))
+
+	series := newInvoiceSeries("{tenant}-{country}{year}{month}-{seq}/{currency}", "acme", "de", "eur", issued)
+	assert.Equal(t, "ACME-DE202604-{seq}/EUR", series.key())
+	assert.Equal(t, "ACME-DE202604-000042/EUR", series.number(42, 6))
+	assert.Equal(t, "ACME-DE202604-1234567/EUR", series.number(1234567, 6))
+
+	// Without a tenant or country their fields are left empty
+	assert.Equal(t, "INV--2026-{seq}", newInvoiceSeries("INV-{country}-{year}-{seq}", "", "", "USD", issued).key())
+}
+
+func TestNumberFormat(t *testing.T) {
+	s := &Service{cfg: &config.PaymentConfig{Invoicing: config.InvoicingConfig{
+		NumberFormat:        "INV-{year}-{seq}",
+		TenantNumberFormats: map[string]string{"acme": "ACME-{country}-{year}-{seq}"},
+	}}}
+
+	assert.Equal(t, "ACME-{country}-{year}-{seq}", s.numberFormat("acme"))
+	assert.Equal(t, "INV-{year}-{seq}", s.numberFormat("globex"))
+	assert.Equal(t, "INV-{year}-{seq}", s.numberFormat(""))
+}
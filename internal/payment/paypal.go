@@ -0,0 +1,342 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/money"
+
+	"github.com/shopspring/decimal"
+)
+
+// GatewayPayPal routes payments through PayPal
+const GatewayPayPal = "paypal"
+
+// paypalGateway talks to the PayPal Orders API. An intent is an order the
+// customer approves on PayPal; it is captured when its status is read after
+// approval. Off-session charges are orders paid with a vaulted PayPal
+// payment method, passed as paypal_<vault ID> to fit the payment_token
+// shape. Charges and intents
+// are identified by their order ID, refunds resolve it to the capture.
+type paypalGateway struct {
+	baseURL      string
+	clientID     string
+	clientSecret string
+	webhookID    string
+	client       *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+func newPayPalGateway(cfg config.PayPalConfig) *paypalGateway {
+	return &paypalGateway{
+		baseURL:      strings.TrimRight(cfg.BaseURL, "/"),
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+		webhookID:    cfg.WebhookID,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (g *paypalGateway) Name() string { return GatewayPayPal }
+
+type paypalAmount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+type paypalCapture struct {
+	ID     string       `json:"id"`
+	Status string       `json:"status"`
+	Amount paypalAmount `json:"amount"`
+}
+
+type paypalOrder struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	PurchaseUnits []struct {
+		Amount   paypalAmount `json:"amount"`
+		Payments struct {
+			Captures []paypalCapture `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"`
+}
+
+type paypalError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+	Details []struct {
+		Issue       string `json:"issue"`
+		Description string `json:"description"`
+	} `json:"details"`
+}
+
+// CreateIntent creates an order for the customer to approve. The order ID
+// is also the client secret: PayPal's JS SDK approves orders by ID.
+func (g *paypalGateway) CreateIntent(ctx context.Context, req PaymentRequest) (*Intent, error) {
+	var order paypalOrder
+	if err := g.do(ctx, http.MethodPost, "/v2/checkout/orders", req.IdempotencyKey, paypalOrderBody(req, nil), &order); err != nil {
+		return nil, err
+	}
+	intent := order.toIntent()
+	intent.ClientSecret = order.ID
+	return intent, nil
+}
+
+// GetIntent reads an order, capturing it if the customer has approved it.
+func (g *paypalGateway) GetIntent(ctx context.Context, id string) (*Intent, error) {
+	path := "/v2/checkout/orders/" + url.PathEscape(id)
+	var order paypalOrder
+	if err := g.do(ctx, http.MethodGet, path, "", nil, &order); err != nil {
+		return nil, err
+	}
+	if order.Status == "APPROVED" {
+		// Keyed by order, so a repeated capture returns the first one's result
+		if err := g.do(ctx, http.MethodPost, path+"/capture", "capture-"+id, struct{}{}, &order); err != nil {
+			return nil, err
+		}
+	}
+	return order.toIntent(), nil
+}
+
+// Charge pays an order with the vaulted payment method in req, without the
+// customer present.
+func (g *paypalGateway) Charge(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
+	source := map[string]interface{}{
+		"paypal": map[string]string{"vault_id": strings.TrimPrefix(req.PaymentMethod, "paypal_")},
+	}
+	var order paypalOrder
+	if err := g.do(ctx, http.MethodPost, "/v2/checkout/orders", req.IdempotencyKey, paypalOrderBody(req, source), &order); err != nil {
+		return nil, err
+	}
+	if capture := order.capture(); capture != nil && capture.Status == "DECLINED" {
+		return nil, &DeclineError{
+			Code:    DeclineDoNotHonor,
+			Message: fmt.Sprintf("PayPal declined capture %s", capture.ID),
+		}
+	}
+	// A capture held by PayPal, e.g. an eCheck, is pending until the
+	// PAYMENT.CAPTURE.COMPLETED webhook
+	return chargeResponse(order.toIntent())
+}
+
+// Refund refunds the capture of an order made by Charge or CreateIntent.
+func (g *paypalGateway) Refund(ctx context.Context, chargeID string, amount decimal.Decimal, currency string) (*GatewayRefund, error) {
+	var order paypalOrder
+	if err := g.do(ctx, http.MethodGet, "/v2/checkout/orders/"+url.PathEscape(chargeID), "", nil, &order); err != nil {
+		return nil, err
+	}
+	capture := order.capture()
+	if capture == nil {
+		return nil, fmt.Errorf("paypal order %s has no capture to refund", chargeID)
+	}
+
+	body := map[string]interface{}{"amount": paypalMoney(amount, currency)}
+	var refund struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := g.do(ctx, http.MethodPost, "/v2/payments/captures/"+url.PathEscape(capture.ID)+"/refund", "", body, &refund); err != nil {
+		return nil, err
+	}
+	switch refund.Status {
+	case "FAILED", "CANCELLED":
+		return nil, fmt.Errorf("refund %s is %s", refund.ID, strings.ToLower(refund.Status))
+	case "PENDING":
+		return &GatewayRefund{ID: refund.ID, Status: "pending"}, nil
+	}
+	return &GatewayRefund{ID: refund.ID, Status: "succeeded"}, nil
+}
+
+// paypalOrderBody is an order for req, paid with source when given. The
+// user and subscription travel in custom_id so webhooks can be matched.
+func paypalOrderBody(req PaymentRequest, source map[string]interface{}) map[string]interface{} {
+	unit := map[string]interface{}{
+		"amount":    paypalMoney(req.Amount, req.Currency),
+		"custom_id": paypalCustomID(req.UserID, req.SubscriptionID),
+	}
+	if req.Description != "" {
+		unit["description"] = req.Description
+	}
+	body := map[string]interface{}{
+		"intent":         "CAPTURE",
+		"purchase_units": []interface{}{unit},
+	}
+	if source != nil {
+		body["payment_source"] = source
+	}
+	return body
+}
+
+// paypalMoney formats an amount with the currency's minor unit digits, as
+// PayPal expects
+func paypalMoney(amount decimal.Decimal, currency string) paypalAmount {
+	currency = strings.ToUpper(currency)
+	return paypalAmount{
+		CurrencyCode: currency,
+		Value:        amount.StringFixed(money.Exponent(currency)),
+	}
+}
+
+// paypalCustomID packs the user and subscription a payment is for into the
+// custom_id PayPal echoes back in webhooks
+func paypalCustomID(userID, subscriptionID string) string {
+	if subscriptionID == "" {
+		return userID
+	}
+	return userID + ":" + subscriptionID
+}
+
+func parsePayPalCustomID(customID string) (userID, subscriptionID string) {
+	userID, subscriptionID, _ = strings.Cut(customID, ":")
+	return userID, subscriptionID
+}
+
+// capture is the order's first capture, if it has been captured
+func (o paypalOrder) capture() *paypalCapture {
+	for _, unit := range o.PurchaseUnits {
+		if len(unit.Payments.Captures) > 0 {
+			return &unit.Payments.Captures[0]
+		}
+	}
+	return nil
+}
+
+func (o paypalOrder) toIntent() *Intent {
+	intent := &Intent{ID: o.ID, Status: paypalIntentStatus(o.Status)}
+	if len(o.PurchaseUnits) > 0 {
+		amount := o.PurchaseUnits[0].Amount
+		intent.Currency = strings.ToUpper(amount.CurrencyCode)
+		intent.Amount, _ = decimal.NewFromString(amount.Value)
+	}
+	// An order completes when captured even if the capture is held for
+	// review; only a completed capture counts as paid
+	if capture := o.capture(); capture != nil && capture.Status != "COMPLETED" {
+		intent.Status = IntentProcessing
+		if capture.Status == "DECLINED" || capture.Status == "FAILED" {
+			intent.Status = IntentCanceled
+		}
+	}
+	return intent
+}
+
+// paypalIntentStatus maps an order status onto the intent lifecycle
+func paypalIntentStatus(status string) string {
+	switch status {
+	case "CREATED", "SAVED", "PAYER_ACTION_REQUIRED":
+		return IntentRequiresAction
+	case "APPROVED":
+		return IntentRequiresConfirmation
+	case "COMPLETED":
+		return IntentSucceeded
+	case "VOIDED":
+		return IntentCanceled
+	default:
+		return IntentProcessing
+	}
+}
+
+// token returns an OAuth access token for the app, fetching a new one
+// shortly before the current one expires.
+func (g *paypalGateway) token(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.accessToken != "" && time.Now().Before(g.expiresAt) {
+		return g.accessToken, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.baseURL+"/v1/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(g.clientID, g.clientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("paypal token request returned status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	g.accessToken = token.AccessToken
+	g.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return g.accessToken, nil
+}
+
+// do sends a JSON request. requestID, when set, makes a POST idempotent.
+func (g *paypalGateway) do(ctx context.Context, method, path, requestID string, in, out interface{}) error {
+	token, err := g.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, g.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+	if requestID != "" {
+		req.Header.Set("PayPal-Request-Id", requestID)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrIntentNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr paypalError
+		if json.NewDecoder(resp.Body).Decode(&apiErr) != nil || apiErr.Name == "" {
+			return fmt.Errorf("paypal returned status %d", resp.StatusCode)
+		}
+		if len(apiErr.Details) > 0 {
+			issue := apiErr.Details[0]
+			if _, ok := paypalDeclineCodes[strings.ToLower(issue.Issue)]; ok {
+				return &DeclineError{
+					Code:        NormalizeDeclineCode(GatewayPayPal, issue.Issue),
+					GatewayCode: issue.Issue,
+					Message:     issue.Description,
+				}
+			}
+			return fmt.Errorf("paypal returned status %d: %s: %s", resp.StatusCode, issue.Issue, issue.Description)
+		}
+		return fmt.Errorf("paypal returned status %d: %s", resp.StatusCode, apiErr.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
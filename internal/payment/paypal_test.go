@@ -0,0 +1,133 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapPayPalCaptureEvent(t *testing.T) {
+	event, err := mapPayPalEvent(paypalEvent{
+		ID:        "WH-1",
+		EventType: "PAYMENT.CAPTURE.COMPLETED",
+		Resource: json.RawMessage(`{
+			"id": "CAP123", "status": "COMPLETED", "custom_id": "user-1:sub-1",
+			"amount": {"currency_code": "EUR", "value": "12.50"},
+			"supplementary_data": {"related_ids": {"order_id": "ORDER9"}}
+		}`),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "payment_intent.succeeded", event.Type)
+	assert.Equal(t, "ORDER9", event.Data["id"])
+	assert.Equal(t, "user-1", event.Data["user_id"])
+	assert.Equal(t, "sub-1", event.Data["subscription_id"])
+	assert.Equal(t, float64(1250), event.Data["amount"])
+	assert.Equal(t, "eur", event.Data["currency"])
+}
+
+func TestMapPayPalDisputeEvent(t *testing.T) {
+	event, err := mapPayPalEvent(paypalEvent{
+		ID:        "WH-2",
+		EventType: "CUSTOMER.DISPUTE.RESOLVED",
+		Resource: json.RawMessage(`{
+			"dispute_id": "PP-D-1", "reason": "MERCHANDISE_OR_SERVICE_NOT_RECEIVED", "status": "RESOLVED",
+			"dispute_outcome": {"outcome_code": "RESOLVED_BUYER_FAVOUR"},
+			"dispute_amount": {"currency_code": "USD", "value": "9.99"},
+			"disputed_transactions": [{"seller_transaction_id": "CAP123"}]
+		}`),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "charge.dispute.closed", event.Type)
+	assert.Equal(t, "PP-D-1", event.Data["id"])
+	assert.Equal(t, DisputeLost, event.Data["status"])
+	assert.Equal(t, "CAP123", event.Data["charge"])
+	assert.Equal(t, float64(999), event.Data["amount"])
+}
+
+func TestMapPayPalSubscriptionEvents(t *testing.T) {
+	sale, err := mapPayPalEvent(paypalEvent{
+		EventType: "PAYMENT.SALE.COMPLETED",
+		Resource:  json.RawMessage(`{"id": "SALE1", "billing_agreement_id": "I-SUB", "custom": "user-1:sub-1"}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "invoice.payment_succeeded", sale.Type)
+	assert.Equal(t, "sub-1", sale.Data["subscription_id"])
+
+	failed, err := mapPayPalEvent(paypalEvent{
+		EventType: "BILLING.SUBSCRIPTION.PAYMENT.FAILED",
+		Resource:  json.RawMessage(`{"id": "I-SUB", "custom_id": "user-1:sub-1"}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "payment_intent.payment_failed", failed.Type)
+	assert.Equal(t, "user-1", failed.Data["user_id"])
+
+	other, err := mapPayPalEvent(paypalEvent{EventType: "CHECKOUT.ORDER.APPROVED", Resource: json.RawMessage(`{"id": "ORDER9"}`)})
+	require.NoError(t, err)
+	assert.Equal(t, "CHECKOUT.ORDER.APPROVED", other.Type)
+}
+
+func TestPayPalIntentStatus(t *testing.T) {
+	assert.Equal(t, IntentRequiresAction, paypalIntentStatus("CREATED"))
+	assert.Equal(t, IntentRequiresConfirmation, paypalIntentStatus("APPROVED"))
+	assert.Equal(t, IntentSucceeded, paypalIntentStatus("COMPLETED"))
+	assert.Equal(t, IntentCanceled, paypalIntentStatus("VOIDED"))
+}
+
+func TestPayPalChargeAndDecline(t *testing.T) {
+	var tokens int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/oauth2/token":
+			tokens++
+			w.Write([]byte(`{"access_token": "token", "expires_in": 3600}`))
+		case "/v2/checkout/orders":
+			assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+			var body struct {
+				PaymentSource struct {
+					PayPal struct {
+						VaultID string `json:"vault_id"`
+					} `json:"paypal"`
+				} `json:"payment_source"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.PaymentSource.PayPal.VaultID == "vault_declined" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				w.Write([]byte(`{"name": "UNPROCESSABLE_ENTITY", "details": [{"issue": "INSTRUMENT_DECLINED", "description": "The instrument was declined."}]}`))
+				return
+			}
+			w.Write([]byte(`{"id": "ORDER1", "status": "COMPLETED", "purchase_units": [{
+				"amount": {"currency_code": "USD", "value": "10.00"},
+				"payments": {"captures": [{"id": "CAP1", "status": "COMPLETED"}]}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	g := newPayPalGateway(config.PayPalConfig{BaseURL: server.URL, ClientID: "id", ClientSecret: "secret"})
+	req := PaymentRequest{UserID: "user-1", Amount: decimal.RequireFromString("10"), Currency: "USD", PaymentMethod: "paypal_vault_ok"}
+
+	resp, err := g.Charge(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "ORDER1", resp.GatewayID)
+	assert.True(t, decimal.RequireFromString("10").Equal(resp.Amount))
+
+	req.PaymentMethod = "paypal_vault_declined"
+	_, err = g.Charge(context.Background(), req)
+	var decline *DeclineError
+	require.True(t, errors.As(err, &decline))
+	assert.Equal(t, DeclineDoNotHonor, decline.Code)
+	assert.Equal(t, "INSTRUMENT_DECLINED", decline.GatewayCode)
+	assert.Equal(t, 1, tokens)
+}
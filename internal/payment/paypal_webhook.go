@@ -0,0 +1,241 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"scalable-paywall/internal/money"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// paypalEvent is a PayPal webhook notification. Resource is the order,
+// capture, sale, subscription or dispute it is about.
+type paypalEvent struct {
+	ID           string          `json:"id"`
+	EventType    string          `json:"event_type"`
+	CreateTime   string          `json:"create_time"`
+	ResourceType string          `json:"resource_type"`
+	Resource     json.RawMessage `json:"resource"`
+}
+
+// paypalResource holds the fields read from any resource type
+type paypalResource struct {
+	ID                string       `json:"id"`
+	Status            string       `json:"status"`
+	CustomID          string       `json:"custom_id"`
+	Custom            string       `json:"custom"`
+	Amount            paypalAmount `json:"amount"`
+	BillingAgreement  string       `json:"billing_agreement_id"`
+	SupplementaryData struct {
+		RelatedIDs struct {
+			OrderID string `json:"order_id"`
+		} `json:"related_ids"`
+	} `json:"supplementary_data"`
+
+	// Disputes
+	DisputeID      string       `json:"dispute_id"`
+	Reason         string       `json:"reason"`
+	DisputeAmount  paypalAmount `json:"dispute_amount"`
+	DisputeOutcome struct {
+		OutcomeCode string `json:"outcome_code"`
+	} `json:"dispute_outcome"`
+	DisputedTransactions []struct {
+		SellerTransactionID string `json:"seller_transaction_id"`
+	} `json:"disputed_transactions"`
+}
+
+// HandlePayPalWebhook receives PayPal notifications. Each is verified with
+// PayPal, mapped onto the event types the Stripe webhook delivers and run
+// through the same handlers; events without a counterpart are stored and
+// logged as unhandled.
+func (s *Service) HandlePayPalWebhook(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read webhook body"})
+		return
+	}
+
+	verified, err := s.paypal.verifyWebhook(c.Request.Context(), c.Request.Header, body)
+	if err != nil {
+		logrus.Errorf("Failed to verify PayPal webhook: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to verify webhook"})
+		return
+	}
+	if !verified {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+		return
+	}
+
+	var notification paypalEvent
+	if err := json.Unmarshal(body, &notification); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	event, err := mapPayPalEvent(notification)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.resolveDisputedOrder(c.Request.Context(), &event)
+
+	s.receiveWebhookEvent(c, GatewayPayPal, event)
+}
+
+// mapPayPalEvent translates a PayPal notification into the shared event
+// shape. Captures are reported by order, the ID intents are stored under,
+// and amounts in minor units as Stripe sends them.
+func mapPayPalEvent(notification paypalEvent) (WebhookEvent, error) {
+	var resource paypalResource
+	if len(notification.Resource) > 0 {
+		if err := json.Unmarshal(notification.Resource, &resource); err != nil {
+			return WebhookEvent{}, err
+		}
+	}
+
+	event := WebhookEvent{ID: notification.ID, Type: notification.EventType, Data: map[string]interface{}{}}
+	customID := resource.CustomID
+	if customID == "" {
+		customID = resource.Custom
+	}
+	userID, subscriptionID := parsePayPalCustomID(customID)
+	payer := func() {
+		if userID != "" {
+			event.Data["user_id"] = userID
+		}
+		if subscriptionID != "" {
+			event.Data["subscription_id"] = subscriptionID
+		}
+	}
+
+	switch notification.EventType {
+	case "PAYMENT.CAPTURE.COMPLETED", "PAYMENT.CAPTURE.PENDING", "PAYMENT.CAPTURE.DENIED", "PAYMENT.CAPTURE.DECLINED":
+		switch notification.EventType {
+		case "PAYMENT.CAPTURE.COMPLETED":
+			event.Type = "payment_intent.succeeded"
+		case "PAYMENT.CAPTURE.PENDING":
+			event.Type = "payment_intent.processing"
+		default:
+			event.Type = "payment_intent.payment_failed"
+		}
+		event.Data["id"] = resource.SupplementaryData.RelatedIDs.OrderID
+		event.Data["capture_id"] = resource.ID
+		setPayPalAmount(event.Data, resource.Amount)
+		payer()
+
+	// Payments of subscriptions billed by PayPal's Subscriptions API, which
+	// carry the subscription's custom_id
+	case "PAYMENT.SALE.COMPLETED":
+		event.Type = "invoice.payment_succeeded"
+		event.Data["id"] = resource.ID
+		event.Data["paypal_subscription_id"] = resource.BillingAgreement
+		payer()
+	case "BILLING.SUBSCRIPTION.PAYMENT.FAILED":
+		event.Type = "payment_intent.payment_failed"
+		event.Data["id"] = resource.ID
+		event.Data["paypal_subscription_id"] = resource.ID
+		payer()
+
+	case "CUSTOMER.DISPUTE.CREATED", "CUSTOMER.DISPUTE.UPDATED", "CUSTOMER.DISPUTE.RESOLVED":
+		event.Type = "charge.dispute." + strings.ToLower(strings.TrimPrefix(notification.EventType, "CUSTOMER.DISPUTE."))
+		if notification.EventType == "CUSTOMER.DISPUTE.RESOLVED" {
+			event.Type = "charge.dispute.closed"
+		}
+		event.Data["id"] = resource.DisputeID
+		event.Data["reason"] = strings.ToLower(resource.Reason)
+		event.Data["status"] = paypalDisputeStatus(resource.Status, resource.DisputeOutcome.OutcomeCode)
+		setPayPalAmount(event.Data, resource.DisputeAmount)
+		if len(resource.DisputedTransactions) > 0 {
+			event.Data["charge"] = resource.DisputedTransactions[0].SellerTransactionID
+		}
+
+	default:
+		event.Data["id"] = resource.ID
+		payer()
+	}
+	return event, nil
+}
+
+// setPayPalAmount sets amount, in minor units, and currency from a PayPal
+// amount
+func setPayPalAmount(data map[string]interface{}, amount paypalAmount) {
+	if amount.CurrencyCode == "" {
+		return
+	}
+	value, err := decimal.NewFromString(amount.Value)
+	if err != nil {
+		return
+	}
+	data["currency"] = strings.ToLower(amount.CurrencyCode)
+	data["amount"] = float64(money.ToMinor(value, amount.CurrencyCode))
+}
+
+// paypalDisputeStatus maps a dispute's outcome onto won or lost once it is
+// resolved; until then it is open
+func paypalDisputeStatus(status, outcome string) string {
+	if status != "RESOLVED" {
+		return DisputeOpen
+	}
+	switch outcome {
+	case "RESOLVED_SELLER_FAVOUR", "CANCELED_BY_BUYER", "DENIED":
+		return DisputeWon
+	default:
+		return DisputeLost
+	}
+}
+
+// resolveDisputedOrder adds the order a disputed capture belongs to, which
+// is how its transaction was recorded. Without it the dispute is recorded
+// unlinked.
+func (s *Service) resolveDisputedOrder(ctx context.Context, event *WebhookEvent) {
+	captureID := stringField(event.Data, "charge")
+	if !strings.HasPrefix(event.Type, "charge.dispute.") || captureID == "" {
+		return
+	}
+	orderID, err := s.paypal.captureOrderID(ctx, captureID)
+	if err != nil {
+		logrus.Warnf("Failed to find the PayPal order of capture %s: %v", captureID, err)
+		return
+	}
+	event.Data["payment_intent"] = orderID
+}
+
+// verifyWebhook asks PayPal whether a notification was signed by it for
+// this app's webhook.
+func (g *paypalGateway) verifyWebhook(ctx context.Context, header http.Header, body []byte) (bool, error) {
+	request := map[string]interface{}{
+		"auth_algo":         header.Get("PAYPAL-AUTH-ALGO"),
+		"cert_url":          header.Get("PAYPAL-CERT-URL"),
+		"transmission_id":   header.Get("PAYPAL-TRANSMISSION-ID"),
+		"transmission_sig":  header.Get("PAYPAL-TRANSMISSION-SIG"),
+		"transmission_time": header.Get("PAYPAL-TRANSMISSION-TIME"),
+		"webhook_id":        g.webhookID,
+		"webhook_event":     json.RawMessage(body),
+	}
+	if !json.Valid(body) || header.Get("PAYPAL-TRANSMISSION-SIG") == "" {
+		return false, nil
+	}
+
+	var result struct {
+		VerificationStatus string `json:"verification_status"`
+	}
+	if err := g.do(ctx, http.MethodPost, "/v1/notifications/verify-webhook-signature", "", request, &result); err != nil {
+		return false, err
+	}
+	return result.VerificationStatus == "SUCCESS", nil
+}
+
+// captureOrderID returns the ID of the order a capture was made for
+func (g *paypalGateway) captureOrderID(ctx context.Context, captureID string) (string, error) {
+	var capture paypalResource
+	if err := g.do(ctx, http.MethodGet, "/v2/payments/captures/"+url.PathEscape(captureID), "", nil, &capture); err != nil {
+		return "", err
+	}
+	return capture.SupplementaryData.RelatedIDs.OrderID, nil
+}
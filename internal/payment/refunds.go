@@ -0,0 +1,329 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/money"
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// Refund policies, see config.PaymentConfig.RefundPolicy. The policy is
+// also the kind of refund recorded.
+const (
+	RefundKindRefund = "refund"
+	RefundKindCredit = "credit"
+	refundPolicyNone = "none"
+)
+
+const (
+	RefundCompleted = "completed"
+	RefundFailed    = "failed"
+)
+
+// refundReasonCancellation marks refunds for the unused part of a period
+const refundReasonCancellation = "prorated_cancellation"
+
+// Refund gives back part of a charge, either through the gateway or as
+// account credit. A failed refund is kept so support can follow it up.
+type Refund struct {
+	ID              string          `json:"id"`
+	TransactionID   string          `json:"transaction_id"`
+	SubscriptionID  string          `json:"subscription_id"`
+	UserID          string          `json:"user_id"`
+	Kind            string          `json:"kind"`
+	Amount          decimal.Decimal `json:"amount"`
+	Currency        string          `json:"currency"`
+	Status          string          `json:"status"`
+	GatewayRefundID *string         `json:"gateway_refund_id,omitempty"`
+	Reason          string          `json:"reason"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
+type CancellationResponse struct {
+	Subscription   *subscription.Subscription `json:"subscription"`
+	RefundPolicy   string                     `json:"refund_policy"`
+	UnusedFraction decimal.Decimal            `json:"unused_fraction"`
+	Refund         *Refund                    `json:"refund,omitempty"`
+}
+
+// invoice is the charge that paid for a subscription's current period
+type invoice struct {
+	ID                   string
+	GatewayTransactionID string
+	Gateway              string
+	Amount               decimal.Decimal
+	Currency             string
+	Refunded             decimal.Decimal
+	Credited             decimal.Decimal
+}
+
+// balance is what is left of the charge to give back
+func (i *invoice) balance() decimal.Decimal {
+	return i.Amount.Sub(i.Refunded).Sub(i.Credited)
+}
+
+// CancelImmediately cancels a subscription now rather than at the end of its
+// period and, per the refund policy of the subscriber's tenant, refunds or
+// credits the unused part of the period's charge. The subscription stays cancelled if the
+// gateway refund fails; the refund is then reported with status failed.
+func (s *Service) CancelImmediately(c *gin.Context) {
+	ctx := c.Request.Context()
+	tenantID, err := s.subscriberTenant(ctx, c.Param("id"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logrus.Errorf("Failed to load tenant of subscription %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("cancel_immediately", "db_error")
+		return
+	}
+	policy := s.refundPolicy(tenantID)
+
+	cancellation, err := s.subscriptionSvc.CancelImmediately(ctx, c.Param("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+			telemetry.RecordPaymentOperation("cancel_immediately", "not_found")
+		case errors.Is(err, subscription.ErrNotCancellable):
+			c.JSON(http.StatusConflict, gin.H{"error": "Subscription is not active"})
+			telemetry.RecordPaymentOperation("cancel_immediately", "invalid_status")
+		case errors.Is(err, subscription.ErrVersionConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": "Subscription was modified concurrently, retry"})
+			telemetry.RecordPaymentOperation("cancel_immediately", "version_conflict")
+		default:
+			logrus.Errorf("Failed to cancel subscription: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			telemetry.RecordPaymentOperation("cancel_immediately", "db_error")
+		}
+		return
+	}
+
+	response := CancellationResponse{
+		Subscription:   cancellation.Subscription,
+		RefundPolicy:   policy,
+		UnusedFraction: cancellation.UnusedFraction().Round(4),
+	}
+	status := "success"
+	if policy != refundPolicyNone {
+		refund, err := s.refundUnusedPeriod(ctx, cancellation, policy)
+		if err != nil {
+			logrus.Errorf("Failed to refund cancelled subscription %s: %v", cancellation.Subscription.ID, err)
+			status = "refund_error"
+		} else if refund != nil && refund.Status == RefundFailed {
+			status = "refund_failed"
+		}
+		response.Refund = refund
+	}
+
+	c.JSON(http.StatusOK, response)
+	telemetry.RecordPaymentOperation("cancel_immediately", status)
+}
+
+// refundPolicy returns the tenant's refund policy, or the default one
+func (s *Service) refundPolicy(tenant string) string {
+	if tenant != "" {
+		if policy, ok := s.cfg.TenantRefundPolicies[strings.ToLower(tenant)]; ok {
+			return policy
+		}
+	}
+	if s.cfg.RefundPolicy == "" {
+		return refundPolicyNone
+	}
+	return s.cfg.RefundPolicy
+}
+
+// subscriberTenant is the tenant the subscription's user was created under,
+// which picks the refund policy; the request's tenant header does not.
+func (s *Service) subscriberTenant(ctx context.Context, subscriptionID string) (string, error) {
+	var tenantID string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(u.tenant_id, '') FROM subscriptions s JOIN users u ON u.id = s.user_id WHERE s.id = $1
+	`, subscriptionID).Scan(&tenantID)
+	return tenantID, err
+}
+
+// refundUnusedPeriod refunds or credits the unused fraction of the charge
+// for the cancelled period, never more than is left of it. It returns nil
+// when nothing was paid or nothing is left.
+func (s *Service) refundUnusedPeriod(ctx context.Context, cancellation *subscription.Cancellation, kind string) (*Refund, error) {
+	sub := cancellation.Subscription
+	inv, err := s.currentInvoice(ctx, sub.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	amount := prorate(inv, cancellation.UnusedFraction())
+	if !amount.IsPositive() {
+		return nil, nil
+	}
+
+	refund := &Refund{
+		TransactionID:  inv.ID,
+		SubscriptionID: sub.ID,
+		UserID:         sub.UserID,
+		Kind:           kind,
+		Amount:         amount,
+		Currency:       inv.Currency,
+		Status:         RefundCompleted,
+		Reason:         refundReasonCancellation,
+	}
+	if kind == RefundKindRefund {
+		s.refundThroughGateway(ctx, refund, inv)
+	}
+
+	if err := s.storeRefund(ctx, refund); err != nil {
+		return refund, err
+	}
+	return refund, nil
+}
+
+// Errors returned by GrantCredit.
+var (
+	ErrNoChargeToCredit     = errors.New("subscription has no completed charge to credit")
+	ErrInvalidCreditAmount  = errors.New("credit amount must be positive and in the charge's minor units")
+	ErrCreditExceedsBalance = errors.New("credit exceeds what is left of the charge")
+)
+
+// refundReasonGoodwill marks credit granted by support rather than a policy
+const refundReasonGoodwill = "goodwill_credit"
+
+// GrantCredit credits amount of the subscription's latest charge to the
+// user's account, e.g. as a goodwill gesture. The credit is recorded like
+// the one a cancellation grants and can't exceed what is left of the
+// charge. reason defaults to goodwill_credit. It returns sql.ErrNoRows if
+// the subscription does not exist.
+func (s *Service) GrantCredit(ctx context.Context, subscriptionID string, amount decimal.Decimal, reason string) (*Refund, error) {
+	sub, err := s.subscriptionSvc.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := s.currentInvoice(ctx, sub.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoChargeToCredit
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCredit(inv, amount); err != nil {
+		return nil, err
+	}
+
+	if reason == "" {
+		reason = refundReasonGoodwill
+	}
+	refund := &Refund{
+		TransactionID:  inv.ID,
+		SubscriptionID: sub.ID,
+		UserID:         sub.UserID,
+		Kind:           RefundKindCredit,
+		Amount:         amount,
+		Currency:       inv.Currency,
+		Status:         RefundCompleted,
+		Reason:         reason,
+	}
+	if err := s.storeRefund(ctx, refund); err != nil {
+		return nil, err
+	}
+	telemetry.RecordPaymentOperation("grant_credit", "success")
+	return refund, nil
+}
+
+// checkCredit rejects a credit the invoice can't cover
+func checkCredit(inv *invoice, amount decimal.Decimal) error {
+	if !amount.IsPositive() || !money.IsValid(amount, inv.Currency) {
+		return ErrInvalidCreditAmount
+	}
+	if amount.GreaterThan(inv.balance()) {
+		return ErrCreditExceedsBalance
+	}
+	return nil
+}
+
+// prorate is the unused fraction of the invoice in its currency's minor
+// units, capped at what has not been given back already
+func prorate(inv *invoice, unused decimal.Decimal) decimal.Decimal {
+	amount := money.Round(inv.Amount.Mul(unused), inv.Currency)
+	if balance := inv.balance(); amount.GreaterThan(balance) {
+		return balance
+	}
+	return amount
+}
+
+// refundThroughGateway marks refund failed when the gateway can't be reached
+// or declines it. Refunds go through the gateway that made the charge;
+// charges recorded without one go through the gateway the user's payments
+// in the refund's currency are currently routed to.
+func (s *Service) refundThroughGateway(ctx context.Context, refund *Refund, inv *invoice) {
+	if !s.circuitBreaker.CanExecute() {
+		refund.Status = RefundFailed
+		return
+	}
+
+	gateway := s.gatewayFor(ctx, refund.UserID, refund.Currency)
+	if inv.Gateway != "" {
+		gateway = s.gatewayNamed(inv.Gateway)
+	}
+	gatewayRefund, err := gateway.Refund(ctx, inv.GatewayTransactionID, refund.Amount, refund.Currency)
+	if err != nil {
+		s.circuitBreaker.RecordFailure()
+		logrus.Errorf("Gateway refund of transaction %s failed: %v", inv.ID, err)
+		refund.Status = RefundFailed
+		return
+	}
+	s.circuitBreaker.RecordSuccess()
+	refund.GatewayRefundID = &gatewayRefund.ID
+}
+
+// currentInvoice returns the latest completed charge for the subscription
+func (s *Service) currentInvoice(ctx context.Context, subscriptionID string) (*invoice, error) {
+	var inv invoice
+	var gatewayID, gateway sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, gateway_transaction_id, gateway, amount, currency, refunded_amount, credited_amount
+		FROM payment_transactions
+		WHERE subscription_id = $1 AND status = 'completed'
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, subscriptionID).Scan(&inv.ID, &gatewayID, &gateway, &inv.Amount, &inv.Currency, &inv.Refunded, &inv.Credited)
+	if err != nil {
+		return nil, err
+	}
+	inv.GatewayTransactionID = gatewayID.String
+	inv.Gateway = gateway.String
+	return &inv, nil
+}
+
+// storeRefund records the refund and, if it went through, adds it to the
+// invoice in the same statement. A fully refunded charge becomes refunded.
+func (s *Service) storeRefund(ctx context.Context, refund *Refund) error {
+	return s.db.QueryRowContext(ctx, `
+		WITH refund AS (
+			INSERT INTO refunds (transaction_id, subscription_id, user_id, kind, amount, currency,
+				status, gateway_refund_id, reason)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id, created_at
+		), invoice AS (
+			UPDATE payment_transactions
+			SET refunded_amount = refunded_amount + CASE WHEN $4 = 'refund' THEN $5 ELSE 0 END,
+				credited_amount = credited_amount + CASE WHEN $4 = 'credit' THEN $5 ELSE 0 END,
+				status = CASE WHEN $4 = 'refund' AND refunded_amount + $5 >= amount THEN 'refunded' ELSE status END,
+				updated_at = NOW()
+			WHERE id = $1 AND $7 = 'completed'
+		)
+		SELECT id, created_at FROM refund
+	`, refund.TransactionID, refund.SubscriptionID, refund.UserID, refund.Kind, refund.Amount,
+		refund.Currency, refund.Status, refund.GatewayRefundID, refund.Reason,
+	).Scan(&refund.ID, &refund.CreatedAt)
+}
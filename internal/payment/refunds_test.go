@@ -0,0 +1,40 @@
+package payment
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCredit(t *testing.T) {
+	inv := &invoice{
+		Amount:   decimal.RequireFromString("20.00"),
+		Currency: "USD",
+		Refunded: decimal.RequireFromString("5.00"),
+		Credited: decimal.RequireFromString("5.00"),
+	}
+
+	assert.NoError(t, checkCredit(inv, decimal.RequireFromString("10.00")))
+	assert.ErrorIs(t, checkCredit(inv, decimal.RequireFromString("10.01")), ErrCreditExceedsBalance)
+	assert.ErrorIs(t, checkCredit(inv, decimal.Zero), ErrInvalidCreditAmount)
+	assert.ErrorIs(t, checkCredit(inv, decimal.RequireFromString("-1")), ErrInvalidCreditAmount)
+	assert.ErrorIs(t, checkCredit(inv, decimal.RequireFromString("1.005")), ErrInvalidCreditAmount)
+}
+
+func TestCreditedCharge(t *testing.T) {
+	amount := decimal.RequireFromString("9.99")
+
+	charge, applied := creditedCharge(amount, decimal.RequireFromString("2.50"))
+	assert.Equal(t, "7.49", charge.StringFixed(2))
+	assert.Equal(t, "2.50", applied.StringFixed(2))
+
+	// Credit beyond the charge is kept for the next one
+	charge, applied = creditedCharge(amount, decimal.RequireFromString("25.00"))
+	assert.True(t, charge.IsZero())
+	assert.True(t, applied.Equal(amount))
+
+	charge, applied = creditedCharge(amount, decimal.Zero)
+	assert.True(t, charge.Equal(amount))
+	assert.True(t, applied.IsZero())
+}
@@ -0,0 +1,258 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/featureflag"
+	"scalable-paywall/internal/jobs"
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// Job kinds run by the payment service.
+const (
+	JobRenewals       = "payment.renewals"
+	JobDunningRetries = "payment.dunning_retries"
+	JobInvoices       = "payment.invoices"
+	JobWebhookEvent   = "payment.webhook"
+)
+
+// RegisterJobs schedules the renewal and dunning workers and the manual
+// invoice overdue check, and processes received webhook events. Each run charges auto-renewing subscriptions that
+// are about to end, or retries failed charges on the dunning schedule. Subscriptions are claimed in batches with
+// row locks and a lease, so a run overlapping another never double charges.
+func (s *Service) RegisterJobs(runner *jobs.Runner, cfg config.SubscriptionConfig) {
+	interval := time.Duration(cfg.RenewalInterval) * time.Second
+	runner.Every(JobRenewals, interval, func(ctx context.Context, job *jobs.Job) error {
+		return s.processRenewals(ctx, cfg)
+	})
+	runner.Every(JobDunningRetries, interval, func(ctx context.Context, job *jobs.Job) error {
+		return s.processDunningRetries(ctx, cfg)
+	})
+	runner.Every(JobInvoices, time.Duration(s.cfg.Invoicing.CheckInterval)*time.Second, func(ctx context.Context, job *jobs.Job) error {
+		return s.processInvoices(ctx)
+	})
+	runner.Handle(JobWebhookEvent, s.runWebhookJob)
+}
+
+func (s *Service) processRenewals(ctx context.Context, cfg config.SubscriptionConfig) error {
+	lead := time.Duration(cfg.RenewalLeadTime) * time.Second
+	lease := time.Duration(cfg.ClaimLease) * time.Second
+
+	// Keep claiming until the backlog is drained; other instances take
+	// their own batches concurrently
+	for ctx.Err() == nil {
+		// Leave the backlog for a later sweep while the gateway is failing
+		if !s.circuitBreaker.CanExecute() {
+			return nil
+		}
+		claims, err := s.subscriptionSvc.ClaimDueRenewals(ctx, cfg.RenewalBatchSize, lead, lease)
+		if err != nil {
+			return err
+		}
+		for _, claim := range claims {
+			s.chargeRenewal(ctx, claim, cfg, "renew")
+		}
+		if len(claims) < cfg.RenewalBatchSize {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+func (s *Service) processDunningRetries(ctx context.Context, cfg config.SubscriptionConfig) error {
+	lease := time.Duration(cfg.ClaimLease) * time.Second
+
+	for ctx.Err() == nil {
+		// Leave the backlog for a later sweep while the gateway is failing
+		if !s.circuitBreaker.CanExecute() {
+			return nil
+		}
+		claims, err := s.subscriptionSvc.ClaimDunningRetries(ctx, cfg.RenewalBatchSize, lease)
+		if err != nil {
+			return err
+		}
+		for _, claim := range claims {
+			s.chargeRenewal(ctx, claim, cfg, "dunning_retry")
+		}
+		if len(claims) < cfg.RenewalBatchSize {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// RenewUser charges the user's auto-renewing subscriptions for their next
+// period now, without waiting for the renewal or dunning schedule, and
+// returns them as they are afterwards. The outcome is recorded as the
+// renewal worker would record it, so a declined charge moves an active
+// subscription into dunning.
+func (s *Service) RenewUser(ctx context.Context, userID string, cfg config.SubscriptionConfig) ([]*subscription.Subscription, error) {
+	claims, err := s.subscriptionSvc.ClaimUserRenewals(ctx, userID, time.Duration(cfg.ClaimLease)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	renewed := make([]*subscription.Subscription, 0, len(claims))
+	for _, claim := range claims {
+		s.chargeRenewal(ctx, claim, cfg, "manual_renewal")
+		sub, err := s.subscriptionSvc.GetSubscriptionByID(ctx, claim.Subscription.ID)
+		if err != nil {
+			return renewed, err
+		}
+		renewed = append(renewed, sub)
+	}
+	return renewed, nil
+}
+
+// chargeRenewal charges one claimed subscription for its next period and
+// records the outcome, which also releases the claim. The charge carries a
+// key unique to the period, so charging again after a charge whose outcome
+// was lost, e.g. one that timed out or whose renewal couldn't be recorded,
+// returns the original charge instead of charging twice.
+func (s *Service) chargeRenewal(ctx context.Context, claim subscription.RenewalClaim, cfg config.SubscriptionConfig, op string) {
+	sub := claim.Subscription
+
+	if sub.PaymentMethod == PaymentMethodInvoice {
+		s.invoiceRenewal(ctx, claim, op)
+		return
+	}
+
+	if !s.circuitBreaker.CanExecute() {
+		if err := s.subscriptionSvc.ReleaseClaim(ctx, sub.ID); err != nil {
+			logrus.Errorf("Failed to release claim on subscription %s: %v", sub.ID, err)
+		}
+		telemetry.RecordPaymentOperation(op, "circuit_breaker_open")
+		return
+	}
+
+	// Credit pays first. It is only used once the renewal is recorded, so a
+	// retried charge asks for the same amount as the one it repeats.
+	credit, err := s.availableCredit(ctx, sub.UserID, sub.Currency)
+	if err != nil {
+		logrus.Errorf("Failed to load credit of user %s: %v", sub.UserID, err)
+		if err := s.subscriptionSvc.ReleaseClaim(ctx, sub.ID); err != nil {
+			logrus.Errorf("Failed to release claim on subscription %s: %v", sub.ID, err)
+		}
+		telemetry.RecordPaymentOperation(op, "db_error")
+		return
+	}
+	amount, applied := creditedCharge(claim.Amount(), credit)
+	if !amount.IsPositive() {
+		s.finishRenewal(ctx, claim, op, applied, &PaymentResponse{
+			Status:    chargeCompleted,
+			Amount:    amount,
+			Currency:  sub.Currency,
+			CreatedAt: time.Now(),
+		})
+		return
+	}
+
+	// Finish well inside the lease so no other worker can claim the
+	// subscription while this charge may still succeed
+	chargeCtx, cancel := context.WithTimeout(ctx, time.Duration(cfg.ClaimLease)*time.Second/2)
+	defer cancel()
+
+	req := PaymentRequest{
+		UserID:         sub.UserID,
+		PlanID:         sub.PlanID,
+		Amount:         amount,
+		Currency:       sub.Currency,
+		PaymentMethod:  sub.PaymentMethod,
+		Description:    "Subscription renewal",
+		SubscriptionID: sub.ID,
+		IdempotencyKey: renewalIdempotencyKey(sub),
+	}
+	response, err := s.processPaymentThroughGateway(chargeCtx, req)
+	if err != nil {
+		logrus.Errorf("Renewal charge for subscription %s failed: %v", sub.ID, err)
+		var decline *DeclineError
+		if errors.As(err, &decline) {
+			if err := s.storeDeclinedTransaction(ctx, req, decline); err != nil {
+				logrus.Errorf("Failed to store declined transaction for subscription %s: %v", sub.ID, err)
+			}
+			s.failRenewal(ctx, claim, cfg, decline.Code)
+			telemetry.RecordPaymentOperation(op, "declined")
+			return
+		}
+		s.circuitBreaker.RecordFailure()
+		s.failRenewal(ctx, claim, cfg, "")
+		telemetry.RecordPaymentOperation(op, "gateway_error")
+		return
+	}
+	s.circuitBreaker.RecordSuccess()
+
+	if err := s.storeTransaction(ctx, req, response); err != nil {
+		logrus.Errorf("Failed to store renewal transaction for subscription %s: %v", sub.ID, err)
+	}
+	s.finishRenewal(ctx, claim, op, applied, response)
+}
+
+// finishRenewal records a paid renewal and uses the credit that paid for
+// part of it.
+func (s *Service) finishRenewal(ctx context.Context, claim subscription.RenewalClaim, op string, credit decimal.Decimal, response *PaymentResponse) {
+	sub := claim.Subscription
+
+	if err := s.completeRenewal(ctx, sub, response); err != nil {
+		logrus.Errorf("Failed to record renewal of subscription %s: %v", sub.ID, err)
+		// Give it back rather than let the lease lapse; charging it again
+		// returns this charge, and recording is retried
+		if err := s.subscriptionSvc.ReleaseClaim(ctx, sub.ID); err != nil {
+			logrus.Errorf("Failed to release claim on subscription %s: %v", sub.ID, err)
+		}
+		telemetry.RecordPaymentOperation(op, "db_error")
+		return
+	}
+	if credit.IsPositive() {
+		used, err := s.useCredit(ctx, sub.UserID, sub.Currency, credit)
+		if err != nil {
+			logrus.Errorf("Failed to use %s %s credit of user %s: %v", credit, sub.Currency, sub.UserID, err)
+		} else if used.LessThan(credit) {
+			logrus.Warnf("Renewal of subscription %s was credited %s %s but only %s was left", sub.ID, credit, sub.Currency, used)
+		}
+	}
+	if response.Status == chargePending {
+		telemetry.RecordPaymentOperation(op, "pending")
+		return
+	}
+	if claim.Attempts > 0 {
+		telemetry.RecordDunningEvent("recovered")
+	}
+	telemetry.RecordPaymentOperation(op, "success")
+}
+
+// renewalIdempotencyKey identifies the charge for the period after the one
+// sub is in, the same for every renewal and dunning attempt at it.
+func renewalIdempotencyKey(sub subscription.Subscription) string {
+	return "renewal-" + sub.ID + "-" + sub.EndDate.UTC().Format(time.RFC3339)
+}
+
+// failRenewal schedules the next dunning retry, or stops retrying once the
+// schedule is exhausted, dunning is off for the user or the charge was
+// declined with a code a retry won't get past. declineCode is empty when the
+// gateway failed rather than declined.
+func (s *Service) failRenewal(ctx context.Context, claim subscription.RenewalClaim, cfg config.SubscriptionConfig, declineCode string) {
+	sub := claim.Subscription
+	attempt := claim.Attempts + 1
+
+	var retryAt *time.Time
+	if declineCode != "" && !retryableDecline(declineCode) {
+		telemetry.RecordDunningEvent("hard_decline")
+	} else if attempt <= len(cfg.DunningRetryDelays) && s.flags.Enabled(ctx, featureflag.Dunning, "", sub.UserID) {
+		next := time.Now().Add(time.Duration(cfg.DunningRetryDelays[attempt-1]) * time.Second)
+		retryAt = &next
+		telemetry.RecordDunningEvent("retry_scheduled")
+	} else {
+		telemetry.RecordDunningEvent("retries_exhausted")
+	}
+
+	if err := s.subscriptionSvc.FailRenewal(ctx, sub.ID, retryAt); err != nil {
+		logrus.Errorf("Failed to record failed renewal of subscription %s: %v", sub.ID, err)
+	}
+}
@@ -0,0 +1,36 @@
+package payment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/subscription"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenewalIdempotencyKey(t *testing.T) {
+	periodEnd := time.Date(2026, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	sub := subscription.Subscription{ID: "sub_1", EndDate: periodEnd}
+	key := renewalIdempotencyKey(sub)
+	assert.Equal(t, "renewal-sub_1-2026-03-01T11:00:00Z", key)
+
+	// A retry at the same period reuses the key, so a charge whose outcome
+	// was lost isn't made twice
+	g := newSimulatedGateway()
+	g.failPercent = 0
+	req := PaymentRequest{UserID: "user_1", Amount: decimal.RequireFromString("9.99"), Currency: "USD",
+		PaymentMethod: "pm_card_visa", IdempotencyKey: key}
+	first, err := g.Charge(context.Background(), req)
+	require.NoError(t, err)
+	retried, err := g.Charge(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, first.GatewayID, retried.GatewayID)
+
+	// The next period is charged anew
+	sub.EndDate = sub.NextPeriodEnd()
+	assert.NotEqual(t, key, renewalIdempotencyKey(sub))
+}
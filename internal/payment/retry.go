@@ -0,0 +1,136 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// retryLease is how long a manual retry holds the subscription; the charge
+// must finish within half of it, as renewal charges do
+const retryLease = time.Minute
+
+// RetryPaymentResponse is a past_due subscription brought back to active
+// by a successful retry, with the charge that paid for it.
+type RetryPaymentResponse struct {
+	Subscription *subscription.Subscription `json:"subscription"`
+	Payment      *PaymentResponse           `json:"payment"`
+}
+
+// RetryPayment charges a past_due subscription's failed renewal now rather
+// than at its next dunning retry, for support or the customer after fixing
+// their payment method. Success renews the subscription and resets its
+// dunning state. A declined charge answers 402 with its normalized decline
+// code and leaves the dunning schedule as it was.
+func (s *Service) RetryPayment(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	if !s.circuitBreaker.CanExecute() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment service temporarily unavailable"})
+		telemetry.RecordPaymentOperation("retry_payment", "circuit_breaker_open")
+		return
+	}
+
+	claim, err := s.subscriptionSvc.ClaimPastDue(ctx, id, retryLease)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+			telemetry.RecordPaymentOperation("retry_payment", "not_found")
+		case errors.Is(err, subscription.ErrNotRetryable):
+			c.JSON(http.StatusConflict, gin.H{"error": "Subscription has no failed renewal to retry now"})
+			telemetry.RecordPaymentOperation("retry_payment", "invalid_status")
+		default:
+			logrus.Errorf("Failed to claim subscription %s for a payment retry: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			telemetry.RecordPaymentOperation("retry_payment", "db_error")
+		}
+		return
+	}
+	sub := claim.Subscription
+
+	// Nothing to charge: the customer pays the renewal invoice instead
+	if sub.PaymentMethod == PaymentMethodInvoice {
+		if err := s.subscriptionSvc.ReleaseClaim(ctx, sub.ID); err != nil {
+			logrus.Errorf("Failed to release claim on subscription %s: %v", sub.ID, err)
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "Subscription is paid by invoice; mark its invoice paid instead"})
+		telemetry.RecordPaymentOperation("retry_payment", "invalid_status")
+		return
+	}
+
+	chargeCtx, cancel := context.WithTimeout(ctx, retryLease/2)
+	defer cancel()
+
+	req := PaymentRequest{
+		UserID:         sub.UserID,
+		PlanID:         sub.PlanID,
+		Amount:         claim.Amount(),
+		Currency:       sub.Currency,
+		PaymentMethod:  sub.PaymentMethod,
+		Description:    "Subscription renewal retry",
+		SubscriptionID: sub.ID,
+	}
+	response, err := s.processPaymentThroughGateway(chargeCtx, req)
+	if err != nil {
+		if releaseErr := s.subscriptionSvc.ReleaseClaim(ctx, sub.ID); releaseErr != nil {
+			logrus.Errorf("Failed to release claim on subscription %s: %v", sub.ID, releaseErr)
+		}
+		var decline *DeclineError
+		if errors.As(err, &decline) {
+			if err := s.storeDeclinedTransaction(ctx, req, decline); err != nil {
+				logrus.Errorf("Failed to store declined transaction for subscription %s: %v", sub.ID, err)
+			}
+			c.JSON(http.StatusPaymentRequired, declinedResponse(decline))
+			telemetry.RecordPaymentOperation("retry_payment", "declined")
+			return
+		}
+		s.circuitBreaker.RecordFailure()
+		logrus.Errorf("Payment retry for subscription %s failed: %v", sub.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Payment gateway error", "message": err.Error()})
+		telemetry.RecordPaymentOperation("retry_payment", "gateway_error")
+		return
+	}
+	s.circuitBreaker.RecordSuccess()
+
+	if err := s.storeTransaction(ctx, req, response); err != nil {
+		logrus.Errorf("Failed to store retry transaction for subscription %s: %v", sub.ID, err)
+	}
+
+	// Extend from the end date the failed renewal was for, as the renewal
+	// worker would have
+	if err := s.completeRenewal(ctx, sub, response); err != nil {
+		logrus.Errorf("Failed to record renewal of subscription %s: %v", sub.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("retry_payment", "db_error")
+		return
+	}
+	if response.Status != chargePending {
+		telemetry.RecordDunningEvent("recovered")
+	}
+
+	renewed, err := s.subscriptionSvc.GetSubscriptionByID(ctx, sub.ID)
+	if err != nil {
+		logrus.Errorf("Failed to get subscription %s: %v", sub.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("retry_payment", "db_error")
+		return
+	}
+
+	if response.Status == chargePending {
+		c.JSON(http.StatusAccepted, RetryPaymentResponse{Subscription: renewed, Payment: response})
+		telemetry.RecordPaymentOperation("retry_payment", "pending")
+		return
+	}
+	c.JSON(http.StatusOK, RetryPaymentResponse{Subscription: renewed, Payment: response})
+	telemetry.RecordPaymentOperation("retry_payment", "success")
+}
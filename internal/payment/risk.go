@@ -0,0 +1,41 @@
+package payment
+
+import (
+	"net/http"
+
+	"scalable-paywall/internal/risk"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// checkRisk runs the risk checks on a payment about to be charged. A
+// blocked payment answers 403 and one held for review 202 with the review
+// ID; the checks' reasons stay with the review queue rather than the
+// customer. It returns whether the payment may go ahead.
+func (s *Service) checkRisk(c *gin.Context, op string, attempt risk.Attempt) bool {
+	assessment, err := s.risk.Evaluate(c.Request.Context(), attempt)
+	if err != nil {
+		logrus.Errorf("Failed to run risk checks for user %s: %v", attempt.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation(op, "db_error")
+		return false
+	}
+
+	switch assessment.Decision {
+	case risk.Block:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Payment blocked by risk checks"})
+		telemetry.RecordPaymentOperation(op, "risk_blocked")
+		return false
+	case risk.Review:
+		c.JSON(http.StatusAccepted, gin.H{
+			"status":    "in_review",
+			"review_id": assessment.ReviewID,
+			"message":   "Payment is held for review",
+		})
+		telemetry.RecordPaymentOperation(op, "risk_review")
+		return false
+	}
+	return true
+}
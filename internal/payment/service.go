@@ -2,26 +2,56 @@ package payment
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"scalable-paywall/internal/cache"
 	"scalable-paywall/internal/config"
 	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/encryption"
+	"scalable-paywall/internal/featureflag"
+	"scalable-paywall/internal/jobs"
+	"scalable-paywall/internal/money"
+	_ "scalable-paywall/internal/pci" // payment_token binding tag, log redaction
+	"scalable-paywall/internal/risk"
+	"scalable-paywall/internal/secrets"
+	"scalable-paywall/internal/subscription"
 	"scalable-paywall/internal/telemetry"
+	"scalable-paywall/internal/vat"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Service struct {
-	cfg            *config.PaymentConfig
-	db             *db.Connection
-	cache          *cache.RedisClient
-	circuitBreaker *CircuitBreaker
+	cfg             *config.PaymentConfig
+	db              *db.Connection
+	cache           *cache.RedisClient
+	queue           *jobs.Queue
+	transactions    *cache.ReadThrough[map[string]interface{}]
+	circuitBreaker  *CircuitBreaker
+	flags           *featureflag.Service
+	subscriptionSvc *subscription.Service
+	risk            *risk.Service
+	keyring         *encryption.Keyring
+	vies            *vat.Client
+
+	// Payments go to the gateway routed by payment.routing; without a route,
+	// users in the new_gateway rollout are charged through stripe and
+	// everyone else stays on the simulated gateway
+	simulated *simulatedGateway
+	stripe    *stripeGateway
+	paypal    *paypalGateway
 }
 
 type CircuitBreaker struct {
@@ -41,21 +71,47 @@ const (
 )
 
 type PaymentRequest struct {
-	UserID        string  `json:"user_id" binding:"required"`
-	PlanID        string  `json:"plan_id" binding:"required"`
-	Amount        float64 `json:"amount" binding:"required"`
-	Currency      string  `json:"currency" binding:"required"`
-	PaymentMethod string  `json:"payment_method" binding:"required"`
-	Description   string  `json:"description"`
+	UserID         string          `json:"user_id" binding:"required"`
+	PlanID         string          `json:"plan_id" binding:"required"`
+	Amount         decimal.Decimal `json:"amount" binding:"required,gt=0"`
+	Currency       string          `json:"currency" binding:"required"`
+	PaymentMethod  string          `json:"payment_method" binding:"required,payment_token"`
+	Description    string          `json:"description"`
+	SubscriptionID string          `json:"subscription_id"`
+	// IdempotencyKey makes retrying a request safe: the gateway returns the
+	// first charge made with the key rather than charging again
+	IdempotencyKey string `json:"idempotency_key" binding:"omitempty,max=255"`
 }
 
 type PaymentResponse struct {
-	TransactionID string    `json:"transaction_id"`
-	Status        string    `json:"status"`
-	Amount        float64   `json:"amount"`
-	Currency      string    `json:"currency"`
-	CreatedAt     time.Time `json:"created_at"`
-	GatewayID     string    `json:"gateway_id,omitempty"`
+	TransactionID string          `json:"transaction_id"`
+	Status        string          `json:"status"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency"`
+	CreatedAt     time.Time       `json:"created_at"`
+	GatewayID     string          `json:"gateway_id,omitempty"`
+	// Gateway is the name of the gateway that made the charge
+	Gateway string `json:"gateway,omitempty"`
+}
+
+type Transaction struct {
+	ID                   string          `json:"id" db:"id"`
+	SubscriptionID       *string         `json:"subscription_id,omitempty" db:"subscription_id"`
+	UserID               string          `json:"user_id" db:"user_id"`
+	Amount               decimal.Decimal `json:"amount" db:"amount"`
+	Currency             string          `json:"currency" db:"currency"`
+	Status               string          `json:"status" db:"status"`
+	PaymentMethod        *string         `json:"payment_method,omitempty" db:"payment_method"`
+	GatewayTransactionID *string         `json:"gateway_transaction_id,omitempty" db:"gateway_transaction_id"`
+	DeclineCode          *string         `json:"decline_code,omitempty" db:"decline_code"`
+	CreatedAt            time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt            time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+type TransactionListResponse struct {
+	Transactions []Transaction `json:"transactions"`
+	Limit        int           `json:"limit"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
 }
 
 type WebhookEvent struct {
@@ -66,13 +122,63 @@ type WebhookEvent struct {
 	Processed bool                   `json:"processed"`
 }
 
-func NewService(cfg *config.PaymentConfig, db *db.Connection, cache *cache.RedisClient) *Service {
+func NewService(cfg *config.PaymentConfig, db *db.Connection, cache *cache.RedisClient, queue *jobs.Queue, flags *featureflag.Service, subscriptionSvc *subscription.Service, riskSvc *risk.Service, keyring *encryption.Keyring) *Service {
 	return &Service{
-		cfg:            cfg,
-		db:             db,
-		cache:          cache,
-		circuitBreaker: NewCircuitBreaker(cfg.CircuitBreaker),
+		cfg:             cfg,
+		db:              db,
+		cache:           cache,
+		queue:           queue,
+		transactions:    newTransactionCache(cache),
+		circuitBreaker:  NewCircuitBreaker(cfg.CircuitBreaker),
+		flags:           flags,
+		subscriptionSvc: subscriptionSvc,
+		risk:            riskSvc,
+		keyring:         keyring,
+		vies:            vat.NewClient(cfg.VAT),
+		simulated:       newSimulatedGateway(),
+		stripe:          newStripeGateway(cfg.GatewayURL, cfg.APIKey, cfg.WebhookSecret),
+		paypal:          newPayPalGateway(cfg.PayPal),
+	}
+}
+
+// WatchSecrets keeps the gateway API key and webhook secret in step with
+// rotations in m.
+func (s *Service) WatchSecrets(m *secrets.Manager) {
+	m.OnRotate(func(key, value string) {
+		switch key {
+		case secrets.PaymentAPIKey:
+			s.stripe.setAPIKey(value)
+		case secrets.PaymentWebhookSecret:
+			s.stripe.setWebhookSecret(value)
+		}
+	})
+}
+
+// gatewayFor picks the gateway for a user's new payments in currency.
+func (s *Service) gatewayFor(ctx context.Context, userID, currency string) Gateway {
+	for routed, name := range s.cfg.Routing.Currencies {
+		if strings.EqualFold(routed, currency) {
+			return s.gatewayNamed(name)
+		}
 	}
+	if s.cfg.Routing.Default != "" {
+		return s.gatewayNamed(s.cfg.Routing.Default)
+	}
+	if s.flags.Enabled(ctx, featureflag.NewGateway, "", userID) {
+		return s.stripe
+	}
+	return s.simulated
+}
+
+// gatewayNamed returns the gateway an existing intent was created on.
+func (s *Service) gatewayNamed(name string) Gateway {
+	switch name {
+	case GatewayStripe:
+		return s.stripe
+	case GatewayPayPal:
+		return s.paypal
+	}
+	return s.simulated
 }
 
 func (s *Service) ProcessPayment(c *gin.Context) {
@@ -83,6 +189,23 @@ func (s *Service) ProcessPayment(c *gin.Context) {
 		return
 	}
 
+	if !money.IsValid(req.Amount, req.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Amount has more decimal places than %s allows", req.Currency)})
+		telemetry.RecordPaymentOperation("process", "validation_error")
+		return
+	}
+
+	if !s.checkRisk(c, "process", risk.Attempt{
+		UserID:        req.UserID,
+		IP:            c.ClientIP(),
+		PaymentMethod: req.PaymentMethod,
+		PlanID:        req.PlanID,
+		Amount:        req.Amount,
+		Currency:      req.Currency,
+	}) {
+		return
+	}
+
 	// Check circuit breaker
 	if !s.circuitBreaker.CanExecute() {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment service temporarily unavailable"})
@@ -93,6 +216,15 @@ func (s *Service) ProcessPayment(c *gin.Context) {
 	// Process payment through gateway
 	response, err := s.processPaymentThroughGateway(c.Request.Context(), req)
 	if err != nil {
+		var decline *DeclineError
+		if errors.As(err, &decline) {
+			if err := s.storeDeclinedTransaction(c.Request.Context(), req, decline); err != nil {
+				logrus.Errorf("Failed to store declined transaction: %v", err)
+			}
+			c.JSON(http.StatusPaymentRequired, declinedResponse(decline))
+			telemetry.RecordPaymentOperation("process", "declined")
+			return
+		}
 		s.circuitBreaker.RecordFailure()
 		logrus.Errorf("Payment processing failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Payment processing failed"})
@@ -109,32 +241,60 @@ func (s *Service) ProcessPayment(c *gin.Context) {
 		// Don't fail the request, just log the error
 	}
 
+	if response.Status == chargePending {
+		c.JSON(http.StatusAccepted, response)
+		telemetry.RecordPaymentOperation("process", "pending")
+		return
+	}
 	c.JSON(http.StatusOK, response)
 	telemetry.RecordPaymentOperation("process", "success")
 }
 
+// HandleWebhook receives Stripe events, signed in the Stripe-Signature
+// header with payment.webhook_secret. Events whose signature doesn't verify
+// are refused before anything is stored.
 func (s *Service) HandleWebhook(c *gin.Context) {
-	// Verify webhook signature
-	if !s.verifyWebhookSignature(c) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read webhook body"})
+		return
+	}
+	if !s.stripe.verifyWebhook(body, c.GetHeader(StripeSignatureHeader), time.Now()) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
 		return
 	}
 
 	var event WebhookEvent
-	if err := c.ShouldBindJSON(&event); err != nil {
+	if err := binding.JSON.BindBody(body, &event); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Store webhook event
-	if err := s.storeWebhookEvent(c.Request.Context(), event); err != nil {
+	s.receiveWebhookEvent(c, GatewayStripe, event)
+}
+
+// receiveWebhookEvent stores an event and enqueues a payment.webhook job to
+// process it, so it is processed even if this instance stops first. A
+// redelivery of an event already stored is acknowledged without running it
+// again; one whose processing failed can be replayed by an admin.
+func (s *Service) receiveWebhookEvent(c *gin.Context, source string, event WebhookEvent) {
+	id, stored, err := s.storeWebhookEvent(c.Request.Context(), source, event)
+	if err != nil {
 		logrus.Errorf("Failed to store webhook event: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook"})
 		return
 	}
+	if !stored {
+		logrus.Infof("Ignoring redelivered %s webhook event %s", source, event.ID)
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+		return
+	}
 
-	// Process webhook asynchronously
-	go s.processWebhookEvent(context.Background(), event)
+	if err := s.enqueueWebhookEvent(c.Request.Context(), id); err != nil {
+		logrus.Errorf("Failed to enqueue webhook event %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"status": "received"})
 }
@@ -146,56 +306,70 @@ func (s *Service) GetTransaction(c *gin.Context) {
 		return
 	}
 
-	// Try cache first
-	cached, err := s.getCachedTransaction(c.Request.Context(), transactionID)
-	if err == nil && cached != nil {
-		c.JSON(http.StatusOK, cached)
+	transaction, _, err := s.transactions.Get(c.Request.Context(), transactionID, func(ctx context.Context) (map[string]interface{}, error) {
+		return s.getTransactionByID(ctx, transactionID)
+	})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
 		return
 	}
 
-	// Get from database
-	transaction, err := s.getTransactionByID(c.Request.Context(), transactionID)
+	c.JSON(http.StatusOK, transaction)
+}
+
+// ListTransactions returns payment transactions newest first using cursor
+// pagination. Optional filters: user_id, status.
+func (s *Service) ListTransactions(c *gin.Context) {
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+
+	cursor, err := db.ParseCursor(c.Query("cursor"))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		telemetry.RecordPaymentOperation("list", "validation_error")
 		return
 	}
 
-	// Cache the transaction
-	s.cacheTransaction(c.Request.Context(), transaction)
+	transactions, nextCursor, err := s.listTransactions(c.Request.Context(), c.Query("user_id"), c.Query("status"), cursor, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list transactions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("list", "db_error")
+		return
+	}
 
-	c.JSON(http.StatusOK, transaction)
+	c.JSON(http.StatusOK, TransactionListResponse{
+		Transactions: transactions,
+		Limit:        limit,
+		NextCursor:   nextCursor,
+	})
+	telemetry.RecordPaymentOperation("list", "success")
 }
 
 // Helper methods
-func (s *Service) processPaymentThroughGateway(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
-	// Simulate payment gateway call
-	// In production, this would call Stripe, PayPal, etc.
-
-	// Simulate network delay
-	time.Sleep(100 * time.Millisecond)
+func (s *Service) processPaymentThroughGateway(ctx context.Context, req PaymentRequest) (response *PaymentResponse, err error) {
+	_, span := telemetry.StartSpan(ctx, "payment.gateway.charge",
+		attribute.String("payment.currency", req.Currency),
+		attribute.String("payment.method", req.PaymentMethod),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
 
-	// Simulate random failures for testing circuit breaker
-	if time.Now().UnixNano()%100 < 5 { // 5% failure rate
-		return nil, fmt.Errorf("gateway timeout")
-	}
+	gateway := s.gatewayFor(ctx, req.UserID, req.Currency)
+	span.SetAttributes(attribute.String("payment.gateway", gateway.Name()))
 
-	response := &PaymentResponse{
-		TransactionID: fmt.Sprintf("txn_%d", time.Now().UnixNano()),
-		Status:        "completed",
-		Amount:        req.Amount,
-		Currency:      req.Currency,
-		CreatedAt:     time.Now(),
-		GatewayID:     fmt.Sprintf("gw_%d", time.Now().UnixNano()),
+	response, err = gateway.Charge(ctx, req)
+	if err != nil {
+		return nil, err
 	}
-
+	response.Gateway = gateway.Name()
 	return response, nil
 }
 
 func (s *Service) storeTransaction(ctx context.Context, req PaymentRequest, response *PaymentResponse) error {
 	query := `
 		INSERT INTO payment_transactions (id, user_id, amount, currency, status, 
-			payment_method, gateway_transaction_id, gateway_response)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			payment_method, gateway_transaction_id, gateway_response, subscription_id, gateway)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::uuid, NULLIF($10, '')
+		WHERE NOT EXISTS (SELECT 1 FROM payment_transactions WHERE gateway_transaction_id = $7)
 	`
 
 	gatewayResponse, _ := json.Marshal(map[string]interface{}{
@@ -205,48 +379,110 @@ func (s *Service) storeTransaction(ctx context.Context, req PaymentRequest, resp
 
 	_, err := s.db.ExecContext(ctx, query, response.TransactionID, req.UserID,
 		response.Amount, response.Currency, response.Status, req.PaymentMethod,
-		response.GatewayID, string(gatewayResponse))
+		response.GatewayID, string(gatewayResponse), req.SubscriptionID, response.Gateway)
 
 	return err
 }
 
-func (s *Service) storeWebhookEvent(ctx context.Context, event WebhookEvent) error {
+// storeWebhookEvent records a received event under the gateway's event ID
+// and returns the ID of the stored record, or false if source already
+// delivered the event. When webhook payloads are
+// encrypted, payload keeps only the IDs the event is looked up by and the
+// whole event is stored in payload_ciphertext.
+func (s *Service) storeWebhookEvent(ctx context.Context, source string, event WebhookEvent) (string, bool, error) {
 	query := `
-		INSERT INTO webhook_events (id, event_type, source, payload)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO webhook_events (provider_event_id, event_type, source, payload, payload_ciphertext)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (source, provider_event_id) DO NOTHING
+		RETURNING id
 	`
 
 	payload, _ := json.Marshal(event)
 
-	_, err := s.db.ExecContext(ctx, query, event.ID, event.Type, "stripe", string(payload))
-	return err
+	var ciphertext *string
+	if s.keyring.Enabled() {
+		sealed, err := s.keyring.Encrypt(string(payload), encryption.WebhookPayload)
+		if err != nil {
+			return "", false, err
+		}
+		ciphertext = &sealed
+		payload, _ = json.Marshal(webhookLookupPayload(event))
+	}
+
+	var id string
+	err := s.db.QueryRowContext(ctx, query, event.ID, event.Type, source, string(payload), ciphertext).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return id, true, nil
+}
+
+// webhookLookupPayload is the part of an encrypted event left in plaintext:
+// its ID and type, and the subscription and gateway object it is about,
+// which the subscription timeline matches on
+func webhookLookupPayload(event WebhookEvent) WebhookEvent {
+	data := map[string]interface{}{}
+	for _, key := range []string{"id", "subscription_id"} {
+		if v, ok := event.Data[key]; ok {
+			data[key] = v
+		}
+	}
+	return WebhookEvent{ID: event.ID, Type: event.Type, Data: data, Created: event.Created}
 }
 
-func (s *Service) processWebhookEvent(ctx context.Context, event WebhookEvent) {
+// processWebhookEvent runs event through its handler and marks the stored
+// record id processed
+func (s *Service) processWebhookEvent(ctx context.Context, id string, event WebhookEvent) {
 	// Process different webhook event types
 	switch event.Type {
+	case "payment_intent.processing":
+		s.handlePaymentProcessing(ctx, event)
 	case "payment_intent.succeeded":
 		s.handlePaymentSuccess(ctx, event)
 	case "payment_intent.payment_failed":
 		s.handlePaymentFailure(ctx, event)
 	case "invoice.payment_succeeded":
 		s.handleInvoicePayment(ctx, event)
+	case "charge.dispute.created", "charge.dispute.updated", "charge.dispute.closed":
+		s.handleDispute(ctx, event)
 	default:
 		logrus.Infof("Unhandled webhook event type: %s", event.Type)
 	}
 
 	// Mark as processed
-	s.markWebhookProcessed(ctx, event.ID)
+	s.markWebhookProcessed(ctx, id)
 }
 
 func (s *Service) handlePaymentSuccess(ctx context.Context, event WebhookEvent) {
 	logrus.Infof("Processing payment success webhook: %s", event.ID)
-	// Update subscription status, send confirmation email, etc.
+	s.settleIntentFromEvent(ctx, event, intentSucceeded)
+	s.settlePendingCharge(ctx, event, true)
+	// Send confirmation email, etc.
 }
 
 func (s *Service) handlePaymentFailure(ctx context.Context, event WebhookEvent) {
 	logrus.Infof("Processing payment failure webhook: %s", event.ID)
-	// Update subscription status, send failure notification, etc.
+	s.settleIntentFromEvent(ctx, event, intentFailed)
+	// A bank debit that bounced undoes the renewal it paid for whether or not
+	// dunning is on
+	s.settlePendingCharge(ctx, event, false)
+
+	userID, _ := event.Data["user_id"].(string)
+	if !s.flags.Enabled(ctx, featureflag.Dunning, "", userID) {
+		return
+	}
+	telemetry.RecordDunningEvent("payment_failed")
+
+	// Keep access during the plan's grace period while payment is retried
+	if subscriptionID, ok := event.Data["subscription_id"].(string); ok && subscriptionID != "" {
+		if err := s.subscriptionSvc.MarkPastDue(ctx, subscriptionID); err != nil {
+			logrus.Errorf("Failed to mark subscription %s past due: %v", subscriptionID, err)
+		}
+	}
+	// Send failure notification, etc.
 }
 
 func (s *Service) handleInvoicePayment(ctx context.Context, event WebhookEvent) {
@@ -254,18 +490,12 @@ func (s *Service) handleInvoicePayment(ctx context.Context, event WebhookEvent)
 	// Handle recurring payment, update subscription dates, etc.
 }
 
-func (s *Service) markWebhookProcessed(ctx context.Context, eventID string) error {
+func (s *Service) markWebhookProcessed(ctx context.Context, id string) error {
 	query := `UPDATE webhook_events SET processed = true, processed_at = NOW() WHERE id = $1`
-	_, err := s.db.ExecContext(ctx, query, eventID)
+	_, err := s.db.ExecContext(ctx, query, id)
 	return err
 }
 
-func (s *Service) verifyWebhookSignature(c *gin.Context) bool {
-	// In production, verify webhook signature using the webhook secret
-	// For now, just return true for development
-	return true
-}
-
 func (s *Service) getTransactionByID(ctx context.Context, id string) (map[string]interface{}, error) {
 	// Implementation would query the database
 	// For now, return a mock response
@@ -278,24 +508,59 @@ func (s *Service) getTransactionByID(ctx context.Context, id string) (map[string
 	return transaction, nil
 }
 
-func (s *Service) cacheTransaction(ctx context.Context, transaction map[string]interface{}) {
-	key := fmt.Sprintf("transaction:%s", transaction["id"])
-	data, _ := json.Marshal(transaction)
-	s.cache.Set(ctx, key, string(data), time.Hour)
-}
+func (s *Service) listTransactions(ctx context.Context, userID, status string, cursor *db.Cursor, limit int) ([]Transaction, string, error) {
+	query := `
+		SELECT id, subscription_id, user_id, amount, currency, status, payment_method,
+			gateway_transaction_id, decline_code, created_at, updated_at
+		FROM payment_transactions
+		WHERE ($1 = '' OR user_id::text = $1)
+			AND ($2 = '' OR status = $2)
+			AND ($3::timestamptz IS NULL OR (created_at, id::text) < ($3, $4))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5
+	`
+
+	after, afterID := cursor.After()
 
-func (s *Service) getCachedTransaction(ctx context.Context, id string) (map[string]interface{}, error) {
-	key := fmt.Sprintf("transaction:%s", id)
-	data, err := s.cache.Get(ctx, key)
+	// Fetch one extra row to know whether another page exists
+	rows, err := s.db.Reader().QueryContext(ctx, query, userID, status, after, afterID, limit+1)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-
-	var transaction map[string]interface{}
-	if err := json.Unmarshal([]byte(data), &transaction); err != nil {
-		return nil, err
+	defer rows.Close()
+
+	var transactions []Transaction
+	for rows.Next() {
+		var txn Transaction
+		if err := rows.Scan(
+			&txn.ID, &txn.SubscriptionID, &txn.UserID, &txn.Amount, &txn.Currency,
+			&txn.Status, &txn.PaymentMethod, &txn.GatewayTransactionID, &txn.DeclineCode,
+			&txn.CreatedAt, &txn.UpdatedAt); err != nil {
+			return nil, "", err
+		}
+		transactions = append(transactions, txn)
 	}
-	return transaction, nil
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	transactions, nextCursor := db.NextPage(transactions, limit, func(row Transaction) db.Cursor {
+		return db.Cursor{CreatedAt: row.CreatedAt, ID: row.ID}
+	})
+
+	return transactions, nextCursor, nil
+}
+
+// transactionCacheTTL is how long a transaction read by ID stays cached;
+// settling it drops it
+const transactionCacheTTL = time.Hour
+
+func newTransactionCache(redis *cache.RedisClient) *cache.ReadThrough[map[string]interface{}] {
+	return cache.NewReadThrough(redis, cache.ReadThroughOptions[map[string]interface{}]{
+		Domain: "transaction",
+		Prefix: "transaction",
+		TTL:    transactionCacheTTL,
+	})
 }
 
 // Circuit Breaker Implementation
@@ -0,0 +1,156 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Charges the gateway accepted but has not settled yet, e.g. SEPA or ACH
+// bank debits, which take days to clear. They are recorded as pending
+// transactions and resolved by the payment_intent.succeeded or
+// payment_intent.payment_failed webhook.
+const (
+	chargePending   = "pending"
+	chargeCompleted = "completed"
+	chargeFailed    = "failed"
+)
+
+// pendingAccessGrant is the payment.pending_access policy under which
+// subscriptions paid by an unsettled charge have access meanwhile.
+const pendingAccessGrant = "grant"
+
+func (s *Service) grantsPendingAccess() bool {
+	return s.cfg.PendingAccess == pendingAccessGrant
+}
+
+// completeRenewal records the renewal a charge paid for: at once if the
+// charge succeeded, held until it settles if it is pending. It extends the
+// current end date by one period of the plan's billing cycle, so renewing
+// early doesn't shorten the period the customer already paid for.
+func (s *Service) completeRenewal(ctx context.Context, sub subscription.Subscription, response *PaymentResponse) error {
+	periodEnd := sub.NextPeriodEnd()
+	if response.Status == chargePending {
+		return s.subscriptionSvc.HoldRenewal(ctx, sub.ID, response.GatewayID, periodEnd, s.grantsPendingAccess())
+	}
+	return s.subscriptionSvc.CompleteRenewal(ctx, sub.ID, periodEnd)
+}
+
+// grantWhileProcessing activates a checkout subscription whose payment is
+// still settling, if the pending access policy grants access meanwhile.
+// settleIntent revokes it again if the payment fails.
+func (s *Service) grantWhileProcessing(ctx context.Context, intent *PaymentIntent) {
+	if !s.grantsPendingAccess() || intent.Status != intentPending {
+		return
+	}
+	if _, err := s.subscriptionSvc.ActivatePending(ctx, intent.SubscriptionID); err != nil {
+		logrus.Errorf("Failed to activate subscription %s while its payment settles: %v", intent.SubscriptionID, err)
+	}
+}
+
+// handlePaymentProcessing handles payment_intent.processing, sent when a
+// checkout payment was submitted but settles later.
+func (s *Service) handlePaymentProcessing(ctx context.Context, event WebhookEvent) {
+	gatewayIntentID := stringField(event.Data, "id")
+	if gatewayIntentID == "" {
+		return
+	}
+	intent, err := s.getIntent(ctx, "gateway_intent_id", gatewayIntentID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logrus.Errorf("Failed to get payment intent %s: %v", gatewayIntentID, err)
+		}
+		return
+	}
+	s.grantWhileProcessing(ctx, intent)
+}
+
+// settlePendingCharge resolves the pending transaction and held renewal of
+// the charge a payment_intent.* event refers to. A settled charge completes
+// the renewal; a failed one undoes it, leaving the subscription past_due.
+func (s *Service) settlePendingCharge(ctx context.Context, event WebhookEvent, succeeded bool) {
+	gatewayID := stringField(event.Data, "id")
+	if gatewayID == "" {
+		return
+	}
+
+	status, declineCode := chargeCompleted, ""
+	if !succeeded {
+		status, declineCode = chargeFailed, failedChargeCode(event)
+	}
+	if err := s.settleTransaction(ctx, gatewayID, status, declineCode); err != nil {
+		logrus.Errorf("Failed to settle pending transaction %s: %v", gatewayID, err)
+	}
+
+	if succeeded {
+		settled, err := s.subscriptionSvc.SettleRenewal(ctx, gatewayID)
+		if err != nil {
+			logrus.Errorf("Failed to complete renewal paid by %s: %v", gatewayID, err)
+			telemetry.RecordPaymentOperation("settle_charge", "db_error")
+		} else if settled {
+			telemetry.RecordPaymentOperation("settle_charge", "success")
+		}
+		return
+	}
+
+	subscriptionID, err := s.subscriptionSvc.FailPendingRenewal(ctx, gatewayID)
+	if err != nil {
+		logrus.Errorf("Failed to undo renewal paid by %s: %v", gatewayID, err)
+		telemetry.RecordPaymentOperation("settle_charge", "db_error")
+		return
+	}
+	if subscriptionID != "" {
+		logrus.Warnf("Renewal charge %s of subscription %s failed to settle", gatewayID, subscriptionID)
+		telemetry.RecordPaymentOperation("settle_charge", "failed")
+	}
+}
+
+// settleTransaction moves the pending transactions of a charge to status.
+func (s *Service) settleTransaction(ctx context.Context, gatewayID, status, declineCode string) error {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE payment_transactions
+		SET status = $2, decline_code = NULLIF($3, ''), updated_at = NOW()
+		WHERE gateway_transaction_id = $1 AND status = 'pending'
+		RETURNING id
+	`, gatewayID, status, declineCode)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.transactions.Forget(ctx, ids...)
+	return nil
+}
+
+// failedChargeCode is the normalized decline code of a payment_failed
+// event, from Stripe's last_payment_error; other gateways don't send one.
+func failedChargeCode(event WebhookEvent) string {
+	lastError, _ := event.Data["last_payment_error"].(map[string]interface{})
+	if lastError == nil {
+		return ""
+	}
+	code := stringField(lastError, "decline_code")
+	if code == "" {
+		code = stringField(lastError, "code")
+	}
+	if code == "" {
+		return ""
+	}
+	return NormalizeDeclineCode(GatewayStripe, code)
+}
@@ -0,0 +1,63 @@
+package payment
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChargeResponse(t *testing.T) {
+	amount := decimal.RequireFromString("9.99")
+
+	settled, err := chargeResponse(&Intent{ID: "pi_1", Status: IntentSucceeded, Amount: amount, Currency: "EUR"})
+	require.NoError(t, err)
+	assert.Equal(t, chargeCompleted, settled.Status)
+	assert.Equal(t, "pi_1", settled.GatewayID)
+
+	debit, err := chargeResponse(&Intent{ID: "pi_2", Status: IntentProcessing, Amount: amount, Currency: "EUR"})
+	require.NoError(t, err)
+	assert.Equal(t, chargePending, debit.Status)
+
+	_, err = chargeResponse(&Intent{ID: "pi_3", Status: IntentRequiresAction})
+	assert.Error(t, err)
+}
+
+func TestFailedChargeCode(t *testing.T) {
+	event := WebhookEvent{Data: map[string]interface{}{
+		"id":                 "pi_1",
+		"last_payment_error": map[string]interface{}{"code": "insufficient_funds"},
+	}}
+	assert.Equal(t, DeclineInsufficientFunds, failedChargeCode(event))
+
+	event.Data["last_payment_error"] = map[string]interface{}{"code": "debit_not_authorized"}
+	assert.Equal(t, DeclineOther, failedChargeCode(event))
+
+	assert.Equal(t, "", failedChargeCode(WebhookEvent{Data: map[string]interface{}{"id": "pi_1"}}))
+}
+
+func TestMapPayPalPendingCapture(t *testing.T) {
+	event, err := mapPayPalEvent(paypalEvent{
+		EventType: "PAYMENT.CAPTURE.PENDING",
+		Resource:  json.RawMessage(`{"id": "CAP1", "status": "PENDING", "supplementary_data": {"related_ids": {"order_id": "ORDER1"}}}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "payment_intent.processing", event.Type)
+	assert.Equal(t, "ORDER1", event.Data["id"])
+}
+
+func TestSettlementWebhooksMustBeSigned(t *testing.T) {
+	s := &Service{stripe: newStripeGateway("", "", "whsec_test")}
+
+	// A forged settlement of a pending bank debit is refused before it is
+	// stored or settles anything
+	for _, eventType := range []string{"payment_intent.succeeded", "payment_intent.payment_failed"} {
+		body := `{"id": "evt_forged", "type": "` + eventType + `", "data": {"id": "pi_sepa_1"}}`
+		assert.Equal(t, http.StatusUnauthorized, sendStripeWebhook(s, body, stripeSignature("whsec_guessed", body, time.Now())), eventType)
+		assert.Equal(t, http.StatusUnauthorized, sendStripeWebhook(s, body, ""), eventType)
+	}
+}
@@ -0,0 +1,74 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeSignatureHeader carries the timestamp and HMAC-SHA256 signatures of
+// a Stripe webhook, as in t=1700000000,v1=5257a8...
+const StripeSignatureHeader = "Stripe-Signature"
+
+// stripeWebhookTolerance bounds how far a webhook's timestamp may be from
+// now, so a captured webhook can't be replayed later.
+const stripeWebhookTolerance = 5 * time.Minute
+
+// setWebhookSecret swaps the secret webhooks are verified with, e.g. after
+// a rotation.
+func (g *stripeGateway) setWebhookSecret(secret string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.webhookSecret = secret
+}
+
+// verifyWebhook checks a webhook's Stripe-Signature header: one of its v1
+// signatures must be the HMAC-SHA256 of "<t>.<body>" with the webhook
+// secret, and t must be within stripeWebhookTolerance of now. Without a
+// secret configured every webhook is refused.
+func (g *stripeGateway) verifyWebhook(body []byte, header string, now time.Time) bool {
+	g.mu.RLock()
+	secret := g.webhookSecret
+	g.mu.RUnlock()
+	if secret == "" {
+		return false
+	}
+
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			if signature, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, signature)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return false
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > stripeWebhookTolerance || age < -stripeWebhookTolerance {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		if hmac.Equal(signature, expected) {
+			return true
+		}
+	}
+	return false
+}
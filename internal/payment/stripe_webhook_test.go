@@ -0,0 +1,74 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func stripeSignature(secret, body string, at time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", at.Unix(), body)
+	return fmt.Sprintf("t=%d,v1=%s", at.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+// sendStripeWebhook posts body to the Stripe webhook handler of a service
+// that has no database, so only a refused webhook comes back without
+// panicking
+func sendStripeWebhook(s *Service, body, signature string) int {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/payments/webhooks", strings.NewReader(body))
+	c.Request.Header.Set(StripeSignatureHeader, signature)
+	s.HandleWebhook(c)
+	return w.Code
+}
+
+func TestStripeVerifyWebhook(t *testing.T) {
+	body := []byte(`{"id": "evt_1", "type": "payment_intent.succeeded", "data": {"id": "pi_1"}}`)
+	now := time.Now()
+	g := newStripeGateway("", "", "whsec_test")
+
+	assert.True(t, g.verifyWebhook(body, stripeSignature("whsec_test", string(body), now), now))
+	assert.False(t, g.verifyWebhook(body, stripeSignature("whsec_other", string(body), now), now))
+	assert.False(t, g.verifyWebhook([]byte(`{"id": "evt_2"}`), stripeSignature("whsec_test", string(body), now), now))
+	assert.False(t, g.verifyWebhook(body, "", now))
+	assert.False(t, g.verifyWebhook(body, "t=abc,v1=00", now))
+
+	// Any v1 signature may match, as during a secret rotation
+	signed := stripeSignature("whsec_test", string(body), now)
+	assert.True(t, g.verifyWebhook(body, signed+",v1=deadbeef", now))
+	assert.True(t, g.verifyWebhook(body, strings.Replace(signed, ",v1=", ",v1=deadbeef,v1=", 1), now))
+
+	// Signatures outside the tolerance are refused, whichever side of now
+	old := now.Add(-stripeWebhookTolerance - time.Second)
+	assert.False(t, g.verifyWebhook(body, stripeSignature("whsec_test", string(body), old), now))
+	ahead := now.Add(stripeWebhookTolerance + time.Second)
+	assert.False(t, g.verifyWebhook(body, stripeSignature("whsec_test", string(body), ahead), now))
+
+	// A rotated secret takes over, and without one nothing is accepted
+	g.setWebhookSecret("whsec_rotated")
+	assert.False(t, g.verifyWebhook(body, signed, now))
+	assert.True(t, g.verifyWebhook(body, stripeSignature("whsec_rotated", string(body), now), now))
+	g.setWebhookSecret("")
+	assert.False(t, g.verifyWebhook(body, stripeSignature("", string(body), now), now))
+}
+
+func TestHandleWebhookRejectsBadSignatures(t *testing.T) {
+	s := &Service{stripe: newStripeGateway("", "", "whsec_test")}
+	body := `{"id": "evt_1", "type": "customer.created", "data": {"id": "cus_1"}}`
+
+	assert.Equal(t, http.StatusUnauthorized, sendStripeWebhook(s, body, ""))
+	assert.Equal(t, http.StatusUnauthorized, sendStripeWebhook(s, body, stripeSignature("whsec_other", body, time.Now())))
+	assert.Equal(t, http.StatusBadRequest, sendStripeWebhook(s, "not json", stripeSignature("whsec_test", "not json", time.Now())))
+}
@@ -0,0 +1,194 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/encryption"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// TimelineEntry is one thing that happened to a subscription. Source is
+// where it was recorded (subscription, payment, refund, dispute or webhook)
+// and Event what happened there: a subscription event (created,
+// status_changed, plan_changed, period_extended, renewal_failed), a payment,
+// refund or dispute status, or a webhook event type.
+type TimelineEntry struct {
+	ID      string          `json:"id"`
+	At      time.Time       `json:"at"`
+	Source  string          `json:"source"`
+	Event   string          `json:"event"`
+	Details json.RawMessage `json:"details"`
+}
+
+type TimelineResponse struct {
+	SubscriptionID string          `json:"subscription_id"`
+	Entries        []TimelineEntry `json:"entries"`
+	Limit          int             `json:"limit"`
+	NextCursor     string          `json:"next_cursor,omitempty"`
+}
+
+// timelineQuery merges everything recorded about subscription $1 oldest
+// first, continuing after the cursor ($2, $3) when one is given. Webhook
+// events are matched by the subscription they name or by the payment intent
+// or dispute they're about. Encrypted webhook payloads are decrypted by
+// listTimeline.
+const timelineQuery = `
+	SELECT id, at, source, event, details FROM (
+		SELECT id::text AS id, created_at AS at, 'subscription' AS source, event,
+			jsonb_build_object('from', from_value, 'to', to_value) AS details
+		FROM subscription_events WHERE subscription_id = $1::uuid
+		UNION ALL
+		SELECT id::text, created_at, 'payment', status,
+			jsonb_build_object('amount', amount, 'currency', currency, 'payment_method', payment_method,
+				'gateway_transaction_id', gateway_transaction_id, 'refunded_amount', refunded_amount,
+				'credited_amount', credited_amount)
+		FROM payment_transactions WHERE subscription_id = $1::uuid
+		UNION ALL
+		SELECT id::text, created_at, 'refund', status,
+			jsonb_build_object('kind', kind, 'amount', amount, 'currency', currency, 'reason', reason,
+				'transaction_id', transaction_id, 'gateway_refund_id', gateway_refund_id)
+		FROM refunds WHERE subscription_id = $1::uuid
+		UNION ALL
+		SELECT id::text, created_at, 'dispute', status,
+			jsonb_build_object('gateway_dispute_id', gateway_dispute_id, 'amount', amount, 'currency', currency,
+				'reason', reason, 'transaction_id', transaction_id,
+				'subscription_suspended', subscription_suspended, 'closed_at', closed_at)
+		FROM disputes WHERE subscription_id = $1::uuid
+		UNION ALL
+		SELECT w.id::text, w.created_at, 'webhook', w.event_type,
+			jsonb_build_object('source', w.source, 'processed', COALESCE(w.processed, false),
+				'processed_at', w.processed_at, 'data', w.payload->'data',
+				'ciphertext', w.payload_ciphertext)
+		FROM webhook_events w
+		WHERE w.payload->'data'->>'subscription_id' = $1::uuid::text
+			OR w.payload->'data'->>'id' IN (
+				SELECT gateway_intent_id FROM payment_intents WHERE subscription_id = $1::uuid
+				UNION ALL
+				SELECT gateway_dispute_id FROM disputes WHERE subscription_id = $1::uuid
+			)
+	) AS timeline
+	WHERE $2::timestamptz IS NULL OR (at, id) > ($2, $3)
+	ORDER BY at, id
+	LIMIT $4
+`
+
+// SubscriptionTimeline returns a subscription's history for support: status
+// transitions, plan changes, renewals and failed renewal attempts, payments,
+// refunds, disputes and the webhook events about them, oldest first using
+// cursor pagination. Subscription history is only recorded from migration
+// 022 onward.
+func (s *Service) SubscriptionTimeline(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	limit := db.PageLimit(c.Query("limit"), 50, 200)
+
+	cursor, err := db.ParseCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		telemetry.RecordPaymentOperation("timeline", "validation_error")
+		return
+	}
+
+	var exists bool
+	if err := s.db.Reader().QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM subscriptions WHERE id::text = $1)
+	`, id).Scan(&exists); err != nil {
+		logrus.Errorf("Failed to look up subscription %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("timeline", "db_error")
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		telemetry.RecordPaymentOperation("timeline", "not_found")
+		return
+	}
+
+	entries, nextCursor, err := s.listTimeline(ctx, id, cursor, limit)
+	if err != nil {
+		logrus.Errorf("Failed to build timeline for subscription %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("timeline", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, TimelineResponse{
+		SubscriptionID: id,
+		Entries:        entries,
+		Limit:          limit,
+		NextCursor:     nextCursor,
+	})
+	telemetry.RecordPaymentOperation("timeline", "success")
+}
+
+func (s *Service) listTimeline(ctx context.Context, subscriptionID string, cursor *db.Cursor, limit int) ([]TimelineEntry, string, error) {
+	after, afterID := cursor.After()
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := s.db.Reader().QueryContext(ctx, timelineQuery, subscriptionID, after, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	entries := []TimelineEntry{}
+	for rows.Next() {
+		var entry TimelineEntry
+		var details []byte
+		if err := rows.Scan(&entry.ID, &entry.At, &entry.Source, &entry.Event, &details); err != nil {
+			return nil, "", err
+		}
+		if entry.Source == "webhook" {
+			if details, err = s.webhookDetails(details); err != nil {
+				return nil, "", err
+			}
+		}
+		entry.Details = json.RawMessage(details)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	entries, nextCursor := db.NextPage(entries, limit, func(row TimelineEntry) db.Cursor {
+		return db.Cursor{CreatedAt: row.At, ID: row.ID}
+	})
+
+	return entries, nextCursor, nil
+}
+
+// webhookDetails replaces the data of an encrypted webhook event's details
+// with the decrypted event's, and drops the ciphertext
+func (s *Service) webhookDetails(details []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(details, &fields); err != nil {
+		return nil, err
+	}
+	var ciphertext *string
+	if err := json.Unmarshal(fields["ciphertext"], &ciphertext); err != nil {
+		return nil, err
+	}
+	delete(fields, "ciphertext")
+	if ciphertext != nil {
+		plain, err := s.keyring.Decrypt(*ciphertext, encryption.WebhookPayload)
+		if err != nil {
+			return nil, err
+		}
+		var event struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(plain), &event); err != nil {
+			return nil, err
+		}
+		fields["data"] = event.Data
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,76 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"scalable-paywall/internal/telemetry"
+	"scalable-paywall/internal/vat"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// checkVATID has VIES confirm the VAT ID a business customer gave at
+// checkout and stores it on the user, for their invoices and renewals. It
+// answers the request itself and returns false when the ID can't be used:
+// 400 when it is malformed or not registered, 503 when VIES can't tell
+// right now. Without an ID there is nothing to check.
+func (s *Service) checkVATID(c *gin.Context, op, userID, raw string) (*vat.Check, bool) {
+	if raw == "" {
+		return nil, true
+	}
+	id, err := vat.Parse(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "VAT ID is malformed"})
+		telemetry.RecordPaymentOperation(op, "invalid_vat_id")
+		return nil, false
+	}
+
+	ctx := c.Request.Context()
+	check, err := s.vies.Check(ctx, id)
+	if err != nil {
+		if errors.Is(err, vat.ErrUnavailable) {
+			logrus.Warnf("Failed to check VAT ID %s: %v", id, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "VAT ID could not be verified right now; try again later"})
+			telemetry.RecordPaymentOperation(op, "vat_unavailable")
+			return nil, false
+		}
+		logrus.Errorf("Failed to check VAT ID %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation(op, "vat_error")
+		return nil, false
+	}
+	if !check.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "VAT ID is not registered in VIES"})
+		telemetry.RecordPaymentOperation(op, "invalid_vat_id")
+		return nil, false
+	}
+
+	if err := s.storeVATID(ctx, userID, check); err != nil {
+		logrus.Errorf("Failed to store VAT ID of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation(op, "db_error")
+		return nil, false
+	}
+	return check, true
+}
+
+func (s *Service) storeVATID(ctx context.Context, userID string, check *vat.Check) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE users SET vat_id = $2, vat_checked_at = $3, updated_at = NOW()
+		WHERE id = $1
+	`, userID, check.VATID, check.CheckedAt)
+	return err
+}
+
+// taxTreatment is how a sale to the holder of a confirmed VAT ID is taxed,
+// or standard for a customer without one
+func (s *Service) taxTreatment(vatID string) string {
+	id, err := vat.Parse(vatID)
+	if err != nil {
+		return vat.TreatmentStandard
+	}
+	return vat.Treatment(s.cfg.VAT.SellerCountry, id.Country())
+}
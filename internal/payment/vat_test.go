@@ -0,0 +1,61 @@
+package payment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/vat"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckVATIDRejectsUnusableIDs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	answer := `{"valid": false, "userError": "INVALID"}`
+	vies := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(answer))
+	}))
+	defer vies.Close()
+	cfg := config.VATConfig{VIESURL: vies.URL, Timeout: 5}
+	s := &Service{cfg: &config.PaymentConfig{VAT: cfg}, vies: vat.NewClient(cfg)}
+
+	check := func(raw string) (int, bool) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/payments/checkout", nil)
+		_, ok := s.checkVATID(c, "create_checkout", "user-1", raw)
+		return w.Code, ok
+	}
+
+	// Consumers without a VAT ID are not checked
+	_, ok := check("")
+	assert.True(t, ok)
+
+	code, ok := check("XX123")
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, ok = check("FR40303265045")
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	answer = `{"valid": false, "userError": "MS_UNAVAILABLE"}`
+	code, ok = check("FR40303265045")
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestTaxTreatment(t *testing.T) {
+	s := &Service{cfg: &config.PaymentConfig{VAT: config.VATConfig{SellerCountry: "DE"}}}
+
+	assert.Equal(t, vat.TreatmentReverseCharge, s.taxTreatment("FR40303265045"))
+	assert.Equal(t, vat.TreatmentReverseCharge, s.taxTreatment("EL094259216"))
+	assert.Equal(t, vat.TreatmentStandard, s.taxTreatment("DE123456789"))
+	assert.Equal(t, vat.TreatmentStandard, s.taxTreatment(""))
+
+	s.cfg.VAT.SellerCountry = ""
+	assert.Equal(t, vat.TreatmentStandard, s.taxTreatment("FR40303265045"))
+}
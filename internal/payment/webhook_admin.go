@@ -0,0 +1,261 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/encryption"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// StoredWebhookEvent is a webhook event as received and persisted, with its
+// processing and replay history.
+type StoredWebhookEvent struct {
+	ID              string          `json:"id" db:"id"`
+	ProviderEventID string          `json:"provider_event_id" db:"provider_event_id"`
+	EventType       string          `json:"event_type" db:"event_type"`
+	Source          string          `json:"source" db:"source"`
+	Payload         json.RawMessage `json:"payload" db:"payload"`
+	Processed       bool            `json:"processed" db:"processed"`
+	ProcessedAt     *time.Time      `json:"processed_at,omitempty" db:"processed_at"`
+	ReplayCount     int             `json:"replay_count" db:"replay_count"`
+	LastReplayedAt  *time.Time      `json:"last_replayed_at,omitempty" db:"last_replayed_at"`
+	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
+}
+
+type WebhookEventListResponse struct {
+	Events     []StoredWebhookEvent `json:"events"`
+	Limit      int                  `json:"limit"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// webhookEventFilter narrows ListWebhookEvents. Zero values match everything.
+type webhookEventFilter struct {
+	eventType string
+	processed *bool
+	from      *time.Time
+	to        *time.Time
+}
+
+// ListWebhookEvents returns stored webhook events newest first using cursor
+// pagination. Optional filters: type, processed (true/false), from and to
+// (RFC 3339 timestamps or YYYY-MM-DD dates, on the received time).
+func (s *Service) ListWebhookEvents(c *gin.Context) {
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+
+	filter := webhookEventFilter{eventType: c.Query("type")}
+	if processedStr := c.Query("processed"); processedStr != "" {
+		processed, err := strconv.ParseBool(processedStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "processed must be true or false"})
+			telemetry.RecordPaymentOperation("webhook_list", "validation_error")
+			return
+		}
+		filter.processed = &processed
+	}
+	for _, bound := range []struct {
+		param string
+		dest  **time.Time
+	}{{"from", &filter.from}, {"to", &filter.to}} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeBound(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", bound.param)})
+			telemetry.RecordPaymentOperation("webhook_list", "validation_error")
+			return
+		}
+		*bound.dest = &t
+	}
+
+	cursor, err := db.ParseCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		telemetry.RecordPaymentOperation("webhook_list", "validation_error")
+		return
+	}
+
+	events, nextCursor, err := s.listWebhookEvents(c.Request.Context(), filter, cursor, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list webhook events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("webhook_list", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, WebhookEventListResponse{
+		Events:     events,
+		Limit:      limit,
+		NextCursor: nextCursor,
+	})
+	telemetry.RecordPaymentOperation("webhook_list", "success")
+}
+
+// ReplayWebhookEvent runs a stored webhook event through the handlers again,
+// e.g. after a dropped Stripe event, and returns the updated record.
+// Handlers don't tolerate repeats (a payment failure replayed after the
+// renewal moves the subscription back to past_due), so an event that was
+// already processed is refused with 409 unless force=true.
+func (s *Service) ReplayWebhookEvent(c *gin.Context) {
+	id := c.Param("id")
+
+	replayed, err := s.ReplayWebhook(c.Request.Context(), id, c.Query("force") == "true")
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook event not found"})
+			telemetry.RecordPaymentOperation("webhook_replay", "not_found")
+		case errors.Is(err, ErrWebhookProcessed):
+			c.JSON(http.StatusConflict, gin.H{"error": "Webhook event was already processed; replay with force=true to run it again"})
+			telemetry.RecordPaymentOperation("webhook_replay", "already_processed")
+		case errors.Is(err, ErrUndecodableWebhook):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Stored webhook payload cannot be decoded"})
+			telemetry.RecordPaymentOperation("webhook_replay", "invalid_payload")
+		default:
+			logrus.Errorf("Failed to replay webhook event %s: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			telemetry.RecordPaymentOperation("webhook_replay", "db_error")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, replayed)
+	telemetry.RecordPaymentOperation("webhook_replay", "success")
+}
+
+// ErrUndecodableWebhook is returned when a stored webhook payload is not a
+// webhook event and so can't be replayed.
+var ErrUndecodableWebhook = errors.New("stored webhook payload cannot be decoded")
+
+// ErrWebhookProcessed is returned when replaying an event that was already
+// processed without forcing it.
+var ErrWebhookProcessed = errors.New("webhook event already processed")
+
+// ReplayWebhook runs the stored webhook event id through the handlers again
+// and returns the updated record. It returns sql.ErrNoRows if there is no
+// such event, ErrWebhookProcessed if it was processed and force is false,
+// and ErrUndecodableWebhook if its payload can't be decoded.
+func (s *Service) ReplayWebhook(ctx context.Context, id string, force bool) (*StoredWebhookEvent, error) {
+	stored, err := s.getWebhookEvent(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Processed && !force {
+		return nil, ErrWebhookProcessed
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(stored.Payload, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUndecodableWebhook, err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_events
+		SET replay_count = replay_count + 1, last_replayed_at = NOW()
+		WHERE id = $1
+	`, id); err != nil {
+		return nil, fmt.Errorf("failed to record replay: %w", err)
+	}
+
+	// Synchronous, unlike HandleWebhook, so the caller sees the outcome
+	logrus.Infof("Replaying webhook event %s (%s)", event.ID, event.Type)
+	s.processWebhookEvent(ctx, stored.ID, event)
+
+	return s.getWebhookEvent(ctx, id)
+}
+
+// webhookEventColumns lists the columns scanWebhookEvent expects, in order
+const webhookEventColumns = `id, provider_event_id, event_type, source, payload, payload_ciphertext, COALESCE(processed, false),
+	processed_at, replay_count, last_replayed_at, created_at`
+
+// scanWebhookEvent reads a stored event, decrypting its payload if it was
+// stored encrypted
+func (s *Service) scanWebhookEvent(scan func(dest ...interface{}) error) (*StoredWebhookEvent, error) {
+	var event StoredWebhookEvent
+	var payload []byte
+	var ciphertext sql.NullString
+	if err := scan(&event.ID, &event.ProviderEventID, &event.EventType, &event.Source, &payload, &ciphertext, &event.Processed,
+		&event.ProcessedAt, &event.ReplayCount, &event.LastReplayedAt, &event.CreatedAt); err != nil {
+		return nil, err
+	}
+	if ciphertext.Valid {
+		plain, err := s.keyring.Decrypt(ciphertext.String, encryption.WebhookPayload)
+		if err != nil {
+			return nil, err
+		}
+		payload = []byte(plain)
+	}
+	event.Payload = json.RawMessage(payload)
+	return &event, nil
+}
+
+func (s *Service) getWebhookEvent(ctx context.Context, id string) (*StoredWebhookEvent, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+webhookEventColumns+`
+		FROM webhook_events WHERE id::text = $1
+	`, id)
+	return s.scanWebhookEvent(row.Scan)
+}
+
+func (s *Service) listWebhookEvents(ctx context.Context, filter webhookEventFilter, cursor *db.Cursor, limit int) ([]StoredWebhookEvent, string, error) {
+	query := `
+		SELECT ` + webhookEventColumns + `
+		FROM webhook_events
+		WHERE ($1 = '' OR event_type = $1)
+			AND ($2::boolean IS NULL OR COALESCE(processed, false) = $2)
+			AND ($3::timestamptz IS NULL OR created_at >= $3)
+			AND ($4::timestamptz IS NULL OR created_at < $4)
+			AND ($5::timestamptz IS NULL OR (created_at, id::text) < ($5, $6))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $7
+	`
+
+	after, afterID := cursor.After()
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := s.db.Reader().QueryContext(ctx, query, filter.eventType, filter.processed,
+		filter.from, filter.to, after, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var events []StoredWebhookEvent
+	for rows.Next() {
+		event, err := s.scanWebhookEvent(rows.Scan)
+		if err != nil {
+			return nil, "", err
+		}
+		events = append(events, *event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	events, nextCursor := db.NextPage(events, limit, func(row StoredWebhookEvent) db.Cursor {
+		return db.Cursor{CreatedAt: row.CreatedAt, ID: row.ID}
+	})
+
+	return events, nextCursor, nil
+}
+
+// parseTimeBound accepts an RFC 3339 timestamp or a date, which is read as
+// midnight UTC.
+func parseTimeBound(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
@@ -0,0 +1,56 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"scalable-paywall/internal/jobs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// webhookJob is the payload of a payment.webhook job. It names the stored
+// event rather than carrying it, so encrypted payloads stay encrypted.
+type webhookJob struct {
+	EventID string `json:"event_id"`
+}
+
+// enqueueWebhookEvent queues the stored event id for processing, once
+func (s *Service) enqueueWebhookEvent(ctx context.Context, id string) error {
+	_, err := s.queue.Enqueue(ctx, JobWebhookEvent, webhookJob{EventID: id}, jobs.EnqueueOptions{
+		UniqueKey: JobWebhookEvent + ":" + id,
+	})
+	return err
+}
+
+// runWebhookJob processes the stored event a payment.webhook job names. An
+// event already processed, say by a run that stopped before it could record
+// its outcome, or by an admin replay, is not run again.
+func (s *Service) runWebhookJob(ctx context.Context, job *jobs.Job) error {
+	var payload webhookJob
+	if err := job.Decode(&payload); err != nil {
+		return err
+	}
+
+	stored, err := s.getWebhookEvent(ctx, payload.EventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		logrus.Warnf("Webhook event %s is gone, skipping it", payload.EventID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load webhook event %s: %w", payload.EventID, err)
+	}
+	if stored.Processed {
+		return nil
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(stored.Payload, &event); err != nil {
+		return fmt.Errorf("%w: %v", ErrUndecodableWebhook, err)
+	}
+	s.processWebhookEvent(ctx, stored.ID, event)
+	return nil
+}
@@ -0,0 +1,339 @@
+package paywall
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Abuse flag states
+const (
+	AbuseFlagPending   = "pending"
+	AbuseFlagConfirmed = "confirmed"
+	AbuseFlagDismissed = "dismissed"
+)
+
+// ErrAbuseFlagResolved is returned when resolving a flag that is no longer
+// pending
+var ErrAbuseFlagResolved = errors.New("abuse flag already resolved")
+
+// abuseScript adds a member to each of the sets in KEYS, ARGV[i] to
+// KEYS[i], giving new sets a TTL of ARGV[#KEYS + 1] milliseconds. It
+// returns, per set, whether the member was new and the set's size.
+var abuseScript = cache.NewScript(`
+local result = {}
+for i, key in ipairs(KEYS) do
+	local added = redis.call('SADD', key, ARGV[i])
+	if redis.call('PTTL', key) < 0 then
+		redis.call('PEXPIRE', key, ARGV[#KEYS + 1])
+	end
+	result[#result + 1] = added
+	result[#result + 1] = redis.call('SCARD', key)
+end
+return result
+`)
+
+// AbuseDetector spots users rotating accounts or IPs to get around metered
+// limits. It counts the distinct accounts seen per device and per IP, and
+// the IPs seen per account, in fixed windows in Redis so the counts hold
+// across instances, and flags users past a limit for review.
+type AbuseDetector struct {
+	cfg   config.PaywallAbuseConfig
+	db    *db.Connection
+	cache *cache.RedisClient
+}
+
+// AbuseFlag is a user flagged for review by the abuse detection.
+type AbuseFlag struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	DeviceID   *string    `json:"device_id,omitempty"`
+	IPAddress  *string    `json:"ip_address,omitempty"`
+	Score      int        `json:"score"`
+	Reasons    []string   `json:"reasons"`
+	Hits       int        `json:"hits"`
+	Status     string     `json:"status"`
+	ReviewedBy *string    `json:"reviewed_by,omitempty"`
+	Note       *string    `json:"note,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+type AbuseFlagListResponse struct {
+	Flags      []AbuseFlag `json:"flags"`
+	Limit      int         `json:"limit"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+type ResolveAbuseFlagRequest struct {
+	Decision string `json:"decision" binding:"required,oneof=confirm dismiss"`
+	Note     string `json:"note" binding:"max=1000"`
+}
+
+// abuseAssessment is the abuse score of a request, from 0 to 100, with the
+// rules it broke. flag is set when a rule was broken by something new this
+// request, so a flagged user isn't written again on every request.
+type abuseAssessment struct {
+	score   int
+	reasons []string
+	flag    bool
+}
+
+// abuseRule is one distinct count: members of subject seen within the window
+type abuseRule struct {
+	subject string
+	value   string
+	member  string
+	max     int
+	reason  string
+}
+
+func NewAbuseDetector(cfg config.PaywallAbuseConfig, db *db.Connection, cache *cache.RedisClient) *AbuseDetector {
+	return &AbuseDetector{cfg: cfg, db: db, cache: cache}
+}
+
+// Assess counts a metered request by userID from deviceID (which may be
+// empty) and ip, and flags the user when it breaks a rule. It fails open:
+// when Redis or the database is unavailable the request scores 0.
+func (d *AbuseDetector) Assess(ctx context.Context, userID, deviceID, ip string) int {
+	if d == nil || !d.cfg.Enabled {
+		return 0
+	}
+
+	window := time.Duration(d.cfg.Window) * time.Second
+	rules := d.rules(userID, deviceID, ip)
+	keys := make([]string, len(rules))
+	args := make([]interface{}, 0, len(rules)+1)
+	windowStart := time.Now().Truncate(window)
+	for i, rule := range rules {
+		keys[i] = fmt.Sprintf("abuse:%s:%s:%d", rule.subject, rule.value, windowStart.Unix())
+		args = append(args, rule.member)
+	}
+	args = append(args, window.Milliseconds())
+
+	result, err := d.cache.RunScript(ctx, abuseScript, keys, args...)
+	if err != nil {
+		logrus.Warnf("Abuse check unavailable for user %s: %v", userID, err)
+		return 0
+	}
+	values, _ := result.([]interface{})
+	if len(values) != 2*len(rules) {
+		logrus.Warnf("Unexpected abuse check reply for user %s: %v", userID, result)
+		return 0
+	}
+
+	assessment := &abuseAssessment{}
+	for i, rule := range rules {
+		added, _ := values[2*i].(int64)
+		count, _ := values[2*i+1].(int64)
+		assessment.add(rule, int(count), added == 1, window)
+	}
+
+	if assessment.flag {
+		if err := d.flag(ctx, userID, deviceID, ip, assessment); err != nil {
+			logrus.Warnf("Failed to flag user %s for abuse: %v", userID, err)
+		}
+	}
+	return assessment.score
+}
+
+func (d *AbuseDetector) rules(userID, deviceID, ip string) []abuseRule {
+	var rules []abuseRule
+	if deviceID != "" {
+		rules = append(rules, abuseRule{subject: "device", value: deviceID, member: userID,
+			max: d.cfg.MaxAccountsPerDevice, reason: "accounts on one device"})
+	}
+	if ip != "" {
+		rules = append(rules,
+			abuseRule{subject: "ip", value: ip, member: userID,
+				max: d.cfg.MaxAccountsPerIP, reason: "accounts from one IP"},
+			abuseRule{subject: "user_ips", value: userID, member: ip,
+				max: d.cfg.MaxIPsPerUser, reason: "IPs used by the account"})
+	}
+	return rules
+}
+
+// add scores count distinct members against rule: 50 at its limit and 100
+// at twice the limit. Going past the limit breaks the rule.
+func (a *abuseAssessment) add(rule abuseRule, count int, added bool, window time.Duration) {
+	if score := min(100, count*50/rule.max); score > a.score {
+		a.score = score
+	}
+	if count > rule.max {
+		a.reasons = append(a.reasons, fmt.Sprintf("%d %s within %s", count, rule.reason, window))
+		a.flag = a.flag || added
+	}
+}
+
+// flag queues the user for review, adding to their pending flag if they
+// have one. A flag dismissed within the last day keeps them off the list.
+func (d *AbuseDetector) flag(ctx context.Context, userID, deviceID, ip string, assessment *abuseAssessment) error {
+	reasons, err := json.Marshal(assessment.reasons)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO abuse_flags (user_id, device_id, ip_address, score, reasons)
+		SELECT $1, NULLIF($2, ''), NULLIF($3, ''), $4, $5
+		WHERE NOT EXISTS (SELECT 1 FROM abuse_flags
+			WHERE user_id = $1 AND status = 'dismissed' AND reviewed_at > NOW() - INTERVAL '1 day')
+		ON CONFLICT (user_id) WHERE status = 'pending'
+		DO UPDATE SET device_id = COALESCE(EXCLUDED.device_id, abuse_flags.device_id),
+			ip_address = COALESCE(EXCLUDED.ip_address, abuse_flags.ip_address),
+			score = GREATEST(abuse_flags.score, EXCLUDED.score), reasons = EXCLUDED.reasons,
+			hits = abuse_flags.hits + 1, updated_at = NOW()
+	`, userID, deviceID, ip, assessment.score, string(reasons))
+	return err
+}
+
+// ListAbuseFlags returns flagged users newest first using cursor
+// pagination, optionally filtered by status.
+func (d *AbuseDetector) ListAbuseFlags(c *gin.Context) {
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+
+	status := c.Query("status")
+	if status != "" && status != AbuseFlagPending && status != AbuseFlagConfirmed && status != AbuseFlagDismissed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, confirmed or dismissed"})
+		return
+	}
+
+	cursor, err := db.ParseCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	flags, nextCursor, err := d.listFlags(c.Request.Context(), status, cursor, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list abuse flags: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, AbuseFlagListResponse{
+		Flags:      flags,
+		Limit:      limit,
+		NextCursor: nextCursor,
+	})
+}
+
+// ResolveAbuseFlag confirms or dismisses a pending flag. Confirming only
+// records the decision; suspending the account is a separate step.
+func (d *AbuseDetector) ResolveAbuseFlag(c *gin.Context) {
+	id := c.Param("id")
+	var req ResolveAbuseFlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	status := AbuseFlagConfirmed
+	if req.Decision == "dismiss" {
+		status = AbuseFlagDismissed
+	}
+	flag, err := d.resolveFlag(c.Request.Context(), id, status, c.GetHeader(middleware.UserHeader), req.Note)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Abuse flag not found"})
+		return
+	case errors.Is(err, ErrAbuseFlagResolved):
+		c.JSON(http.StatusConflict, gin.H{"error": "Abuse flag is already " + flag.Status})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to resolve abuse flag %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, flag)
+}
+
+const abuseFlagColumns = `id, user_id, device_id, ip_address, score, reasons, hits, status,
+	reviewed_by, note, reviewed_at, created_at, updated_at`
+
+func scanAbuseFlag(scan func(dest ...interface{}) error) (*AbuseFlag, error) {
+	var flag AbuseFlag
+	var reasons []byte
+	if err := scan(
+		&flag.ID, &flag.UserID, &flag.DeviceID, &flag.IPAddress, &flag.Score, &reasons,
+		&flag.Hits, &flag.Status, &flag.ReviewedBy, &flag.Note, &flag.ReviewedAt,
+		&flag.CreatedAt, &flag.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(reasons, &flag.Reasons); err != nil {
+		return nil, err
+	}
+	return &flag, nil
+}
+
+// resolveFlag records the decision on a pending flag. When the flag is no
+// longer pending it returns it as it is with ErrAbuseFlagResolved.
+func (d *AbuseDetector) resolveFlag(ctx context.Context, id, status, reviewedBy, note string) (*AbuseFlag, error) {
+	row := d.db.QueryRowContext(ctx, `
+		UPDATE abuse_flags
+		SET status = $2, reviewed_by = NULLIF($3, ''), note = NULLIF($4, ''),
+			reviewed_at = NOW(), updated_at = NOW()
+		WHERE id::text = $1 AND status = 'pending'
+		RETURNING `+abuseFlagColumns, id, status, reviewedBy, note)
+	flag, err := scanAbuseFlag(row.Scan)
+	if err == sql.ErrNoRows {
+		current, err := scanAbuseFlag(d.db.QueryRowContext(ctx,
+			`SELECT `+abuseFlagColumns+` FROM abuse_flags WHERE id::text = $1`, id).Scan)
+		if err != nil {
+			return nil, err
+		}
+		return current, ErrAbuseFlagResolved
+	}
+	return flag, err
+}
+
+func (d *AbuseDetector) listFlags(ctx context.Context, status string, cursor *db.Cursor, limit int) ([]AbuseFlag, string, error) {
+	query := `
+		SELECT ` + abuseFlagColumns + `
+		FROM abuse_flags
+		WHERE ($1 = '' OR status = $1)
+			AND ($2::timestamptz IS NULL OR (created_at, id::text) < ($2, $3))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`
+
+	after, afterID := cursor.After()
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := d.db.Reader().QueryContext(ctx, query, status, after, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	flags := []AbuseFlag{}
+	for rows.Next() {
+		flag, err := scanAbuseFlag(rows.Scan)
+		if err != nil {
+			return nil, "", err
+		}
+		flags = append(flags, *flag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	flags, nextCursor := db.NextPage(flags, limit, func(row AbuseFlag) db.Cursor {
+		return db.Cursor{CreatedAt: row.CreatedAt, ID: row.ID}
+	})
+
+	return flags, nextCursor, nil
+}
@@ -0,0 +1,48 @@
+package paywall
+
+import (
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAbuseRules(t *testing.T) {
+	d := &AbuseDetector{cfg: config.PaywallAbuseConfig{MaxAccountsPerDevice: 3, MaxAccountsPerIP: 10, MaxIPsPerUser: 5}}
+
+	rules := d.rules("user-1", "device-1", "203.0.113.7")
+	assert.Len(t, rules, 3)
+	assert.Equal(t, abuseRule{subject: "device", value: "device-1", member: "user-1", max: 3, reason: "accounts on one device"}, rules[0])
+	assert.Equal(t, "user_ips", rules[2].subject)
+	assert.Equal(t, "203.0.113.7", rules[2].member)
+
+	// Without a device fingerprint only the IP rules apply
+	assert.Len(t, d.rules("user-1", "", "203.0.113.7"), 2)
+}
+
+func TestAbuseAssessment(t *testing.T) {
+	device := abuseRule{subject: "device", max: 3, reason: "accounts on one device"}
+	ips := abuseRule{subject: "user_ips", max: 5, reason: "IPs used by the account"}
+
+	a := &abuseAssessment{}
+	a.add(device, 3, true, 24*time.Hour)
+	a.add(ips, 1, false, 24*time.Hour)
+	assert.Equal(t, 50, a.score)
+	assert.Empty(t, a.reasons)
+	assert.False(t, a.flag)
+
+	// A fourth account breaks the rule; seeing it again scores but doesn't
+	// flag again
+	a = &abuseAssessment{}
+	a.add(device, 4, true, 24*time.Hour)
+	assert.Equal(t, 66, a.score)
+	assert.Equal(t, []string{"4 accounts on one device within 24h0m0s"}, a.reasons)
+	assert.True(t, a.flag)
+
+	a = &abuseAssessment{}
+	a.add(device, 9, false, 24*time.Hour)
+	assert.Equal(t, 100, a.score)
+	assert.False(t, a.flag)
+}
@@ -0,0 +1,260 @@
+package paywall
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"scalable-paywall/internal/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAnalyticsDays = 30
+	maxAnalyticsDays     = 366
+	// defaultConversionWindow is how many days after a denial a purchase
+	// still counts as converted
+	defaultConversionWindow = 7
+)
+
+// Analytics reports on the paywall events written by EventRecorder
+type Analytics struct {
+	db *db.Connection
+}
+
+func NewAnalytics(db *db.Connection) *Analytics {
+	return &Analytics{db: db}
+}
+
+// ContentUsage breaks the paywall decisions for one piece of content down
+// by action (check, view, download, share).
+type ContentUsage struct {
+	ContentID string         `json:"content_id"`
+	Total     int            `json:"total"`
+	Allowed   int            `json:"allowed"`
+	Denied    int            `json:"denied"`
+	Actions   map[string]int `json:"actions"`
+}
+
+// DeniedContent is content users were denied, with how many of those users
+// went on to pay within the conversion window.
+type DeniedContent struct {
+	ContentID      string  `json:"content_id"`
+	Denials        int     `json:"denials"`
+	DeniedUsers    int     `json:"denied_users"`
+	ConvertedUsers int     `json:"converted_users"`
+	ConversionRate float64 `json:"conversion_rate"`
+}
+
+// DenialConversionReport covers all denied users in the range; a user
+// converts with a completed payment within WindowDays of their first denial.
+type DenialConversionReport struct {
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	WindowDays     int             `json:"window_days"`
+	DeniedUsers    int             `json:"denied_users"`
+	ConvertedUsers int             `json:"converted_users"`
+	ConversionRate float64         `json:"conversion_rate"`
+	TopContent     []DeniedContent `json:"top_content"`
+}
+
+// GetContentAnalytics serves the most requested content over ?from= to ?to=
+// (YYYY-MM-DD, default the last 30 days) with its per-action breakdown.
+// ?action= narrows to one action; ?limit= caps the list (default 20).
+func (a *Analytics) GetContentAnalytics(c *gin.Context) {
+	from, to, limit, err := parseAnalyticsQuery(c, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	content, err := a.contentUsage(c.Request.Context(), from, to, c.Query("action"), limit)
+	if err != nil {
+		logrus.Errorf("Failed to get paywall content analytics: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "content": content})
+}
+
+// GetDenialAnalytics serves the most denied content and how denied users
+// convert to paying customers within ?window= days (default 7).
+func (a *Analytics) GetDenialAnalytics(c *gin.Context) {
+	from, to, limit, err := parseAnalyticsQuery(c, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	window := defaultConversionWindow
+	if windowStr := c.Query("window"); windowStr != "" {
+		w, err := strconv.Atoi(windowStr)
+		if err != nil || w < 1 || w > 90 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "window must be between 1 and 90 days"})
+			return
+		}
+		window = w
+	}
+
+	report, err := a.denialConversions(c.Request.Context(), from, to, window, limit)
+	if err != nil {
+		logrus.Errorf("Failed to get paywall denial analytics: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// parseAnalyticsQuery reads from/to (to inclusive) and limit
+func parseAnalyticsQuery(c *gin.Context, now time.Time) (time.Time, time.Time, int, error) {
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+
+	now = now.UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	if toStr := c.Query("to"); toStr != "" {
+		day, err := time.Parse("2006-01-02", toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("to must be a date (YYYY-MM-DD)")
+		}
+		to = day.AddDate(0, 0, 1)
+	}
+	from := to.AddDate(0, 0, -defaultAnalyticsDays)
+	if fromStr := c.Query("from"); fromStr != "" {
+		day, err := time.Parse("2006-01-02", fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("from must be a date (YYYY-MM-DD)")
+		}
+		from = day
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("from must not be after to")
+	}
+	if to.Sub(from) > maxAnalyticsDays*24*time.Hour {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("time range must not exceed %d days", maxAnalyticsDays)
+	}
+	return from, to, limit, nil
+}
+
+func (a *Analytics) contentUsage(ctx context.Context, from, to time.Time, action string, limit int) ([]ContentUsage, error) {
+	ctx = db.WithQueryName(ctx, "paywall_content_usage")
+
+	rows, err := a.db.Reader().QueryContext(ctx, `
+		WITH top AS (
+			SELECT content_id, COUNT(*) AS total
+			FROM paywall_events
+			WHERE created_at >= $1 AND created_at < $2 AND ($3 = '' OR action = $3)
+			GROUP BY content_id
+			ORDER BY total DESC, content_id
+			LIMIT $4
+		)
+		SELECT e.content_id, e.action, e.allowed, COUNT(*)
+		FROM paywall_events e
+		JOIN top ON top.content_id = e.content_id
+		WHERE e.created_at >= $1 AND e.created_at < $2 AND ($3 = '' OR e.action = $3)
+		GROUP BY e.content_id, e.action, e.allowed, top.total
+		ORDER BY top.total DESC, e.content_id
+	`, from, to, action, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	content := []ContentUsage{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var contentID, eventAction string
+		var allowed bool
+		var count int
+		if err := rows.Scan(&contentID, &eventAction, &allowed, &count); err != nil {
+			return nil, err
+		}
+		i, ok := index[contentID]
+		if !ok {
+			i = len(content)
+			index[contentID] = i
+			content = append(content, ContentUsage{ContentID: contentID, Actions: map[string]int{}})
+		}
+		usage := &content[i]
+		usage.Total += count
+		usage.Actions[eventAction] += count
+		if allowed {
+			usage.Allowed += count
+		} else {
+			usage.Denied += count
+		}
+	}
+	return content, rows.Err()
+}
+
+// denialConversions finds each user's first denial in [from, to) and checks
+// for a completed payment in the window after it. Per content, a user counts
+// once for every piece of content they were denied.
+func (a *Analytics) denialConversions(ctx context.Context, from, to time.Time, window, limit int) (*DenialConversionReport, error) {
+	ctx = db.WithQueryName(ctx, "paywall_denial_conversions")
+
+	reader := a.db.Reader()
+	report := &DenialConversionReport{From: from, To: to, WindowDays: window, TopContent: []DeniedContent{}}
+
+	err := reader.QueryRowContext(ctx, `
+		WITH denied AS (
+			SELECT user_id, MIN(created_at) AS first_denied
+			FROM paywall_events
+			WHERE NOT allowed AND created_at >= $1 AND created_at < $2
+			GROUP BY user_id
+		)
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE EXISTS (
+			SELECT 1 FROM payment_transactions pt
+			WHERE pt.user_id::text = d.user_id AND pt.status = 'completed'
+				AND pt.created_at >= d.first_denied
+				AND pt.created_at < d.first_denied + make_interval(days => $3)
+		))
+		FROM denied d
+	`, from, to, window).Scan(&report.DeniedUsers, &report.ConvertedUsers)
+	if err != nil {
+		return nil, err
+	}
+	if report.DeniedUsers > 0 {
+		report.ConversionRate = float64(report.ConvertedUsers) / float64(report.DeniedUsers)
+	}
+
+	rows, err := reader.QueryContext(ctx, `
+		WITH denied AS (
+			SELECT content_id, user_id, COUNT(*) AS denials, MIN(created_at) AS first_denied
+			FROM paywall_events
+			WHERE NOT allowed AND created_at >= $1 AND created_at < $2
+			GROUP BY content_id, user_id
+		)
+		SELECT content_id, SUM(denials)::bigint, COUNT(*), COUNT(*) FILTER (WHERE EXISTS (
+			SELECT 1 FROM payment_transactions pt
+			WHERE pt.user_id::text = d.user_id AND pt.status = 'completed'
+				AND pt.created_at >= d.first_denied
+				AND pt.created_at < d.first_denied + make_interval(days => $3)
+		))
+		FROM denied d
+		GROUP BY content_id
+		ORDER BY SUM(denials) DESC, content_id
+		LIMIT $4
+	`, from, to, window, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var content DeniedContent
+		if err := rows.Scan(&content.ContentID, &content.Denials, &content.DeniedUsers, &content.ConvertedUsers); err != nil {
+			return nil, err
+		}
+		if content.DeniedUsers > 0 {
+			content.ConversionRate = float64(content.ConvertedUsers) / float64(content.DeniedUsers)
+		}
+		report.TopContent = append(report.TopContent, content)
+	}
+	return report, rows.Err()
+}
@@ -0,0 +1,141 @@
+package paywall
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/featureflag"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// PaywallBatchCheckItem is one piece of content to check. Action is
+// optional; when set, the item also reports the action's current usage.
+type PaywallBatchCheckItem struct {
+	ContentID string `json:"content_id" binding:"required"`
+	Action    string `json:"action"`
+}
+
+// PaywallBatchCheckRequest checks up to 100 items for one user
+type PaywallBatchCheckRequest struct {
+	UserID string                  `json:"user_id" binding:"required"`
+	PlanID string                  `json:"plan_id"`
+	Items  []PaywallBatchCheckItem `json:"items" binding:"required,min=1,max=100,dive"`
+}
+
+// PaywallBatchCheckResult is the outcome for one item. Usage is read
+// without being counted, so checking content doesn't use up its quota.
+type PaywallBatchCheckResult struct {
+	ContentID    string     `json:"content_id"`
+	Action       string     `json:"action,omitempty"`
+	HasAccess    bool       `json:"has_access"`
+	Limited      bool       `json:"limited,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	ExpiresAt    time.Time  `json:"expires_at,omitempty"`
+	Usage        *UsageInfo `json:"usage,omitempty"`
+	MonthlyUsage *UsageInfo `json:"monthly_usage,omitempty"`
+}
+
+type PaywallBatchCheckResponse struct {
+	Results []PaywallBatchCheckResult `json:"results"`
+}
+
+// CheckAccessBatch evaluates access to several items with one subscription
+// lookup, for pages that render many pieces of gated content
+func (s *Service) CheckAccessBatch(c *gin.Context) {
+	var req PaywallBatchCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPaywallCheck("validation_error")
+		return
+	}
+
+	ctx := c.Request.Context()
+	var access access
+	if s.userBlocked(ctx, req.UserID) {
+		access.reason = reasonAccountBlocked
+	} else {
+		var err error
+		access, err = s.checkSubscriptionAccess(ctx, req.UserID, req.PlanID)
+		if err != nil {
+			logrus.Errorf("Failed to check subscription access: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			telemetry.RecordPaywallCheck("error")
+			return
+		}
+	}
+
+	metered := access.granted && (access.free() || s.flags.Enabled(ctx, featureflag.MeteredPaywall, access.tenantID(), req.UserID))
+	usage := batchUsage{service: s, access: access, userID: req.UserID, now: time.Now()}
+	if metered {
+		usage.day = dailyPeriod(usage.now, s.usageLocation(access))
+	}
+
+	response := PaywallBatchCheckResponse{Results: make([]PaywallBatchCheckResult, 0, len(req.Items))}
+	for _, item := range req.Items {
+		result := PaywallBatchCheckResult{
+			ContentID: item.ContentID,
+			Action:    item.Action,
+			HasAccess: access.granted,
+			Limited:   access.free(),
+			Reason:    access.reason,
+			ExpiresAt: access.expiresAt,
+		}
+		if metered && item.Action != "" {
+			daily, monthly, err := usage.of(ctx, item.Action)
+			if err != nil {
+				logrus.Errorf("Failed to check usage limits: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				telemetry.RecordPaywallCheck("error")
+				return
+			}
+			result.Usage, result.MonthlyUsage = &daily, monthly
+			if access.free() && (daily.exhausted() || (monthly != nil && monthly.exhausted())) {
+				result.HasAccess, result.Reason = false, reasonFreeLimitReached
+			}
+		}
+		response.Results = append(response.Results, result)
+		s.recordBatchCheck(req, item, result)
+	}
+
+	c.JSON(http.StatusOK, response)
+	telemetry.RecordPaywallCheck("batch")
+}
+
+// batchUsage reads each action's usage once per batch, however many items
+// share it
+type batchUsage struct {
+	service *Service
+	access  access
+	userID  string
+	now     time.Time
+	day     usagePeriod
+	read    map[string]batchActionUsage
+}
+
+type batchActionUsage struct {
+	daily   UsageInfo
+	monthly *UsageInfo
+}
+
+func (b *batchUsage) of(ctx context.Context, action string) (UsageInfo, *UsageInfo, error) {
+	if cached, ok := b.read[action]; ok {
+		return cached.daily, cached.monthly, nil
+	}
+	daily, err := b.service.checkUsageLimits(ctx, b.access, b.userID, action, b.day)
+	if err != nil {
+		return UsageInfo{}, nil, err
+	}
+	monthly, err := b.service.checkMonthlyUsage(ctx, b.access, b.userID, action, b.now)
+	if err != nil {
+		return UsageInfo{}, nil, err
+	}
+	if b.read == nil {
+		b.read = make(map[string]batchActionUsage)
+	}
+	b.read[action] = batchActionUsage{daily: daily, monthly: monthly}
+	return daily, monthly, nil
+}
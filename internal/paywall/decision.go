@@ -0,0 +1,95 @@
+package paywall
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// decisionMaxAge bounds how long an edge may reuse a decision granting
+// access. Denials are never reused, so a purchase unlocks content at once.
+const decisionMaxAge = time.Minute
+
+// DecisionHeader carries the decision for edges that only read headers,
+// e.g. of a HEAD request: allow, limited or deny
+const DecisionHeader = "X-Paywall-Decision"
+
+// PaywallDecisionRequest is CheckAccess's request as query parameters;
+// plan_id is optional
+type PaywallDecisionRequest struct {
+	UserID    string `form:"user_id" binding:"required"`
+	ContentID string `form:"content_id" binding:"required"`
+	PlanID    string `form:"plan_id"`
+}
+
+// PaywallDecisionResponse is the decision with nothing else an edge worker
+// would have to parse
+type PaywallDecisionResponse struct {
+	Allow   bool `json:"allow"`
+	Limited bool `json:"limited,omitempty"`
+}
+
+// Decision is CheckAccess for CDN edge workers and ESI includes: a GET
+// answered from the access cache with a minimal body, the decision in
+// DecisionHeader and a Cache-Control header saying how long it may be
+// reused.
+func (s *Service) Decision(c *gin.Context) {
+	var query PaywallDecisionRequest
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPaywallCheck("validation_error")
+		return
+	}
+	req := PaywallCheckRequest{UserID: query.UserID, ContentID: query.ContentID, PlanID: query.PlanID}
+
+	response, result, err := s.checkAccess(c.Request.Context(), req)
+	if err != nil {
+		logrus.Errorf("Failed to check subscription access: %v", err)
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaywallCheck(result)
+		return
+	}
+	s.recordCheck(req, response)
+
+	c.Header(DecisionHeader, decisionOf(response))
+	c.Header("Cache-Control", decisionCacheControl(response, time.Now()))
+	c.JSON(http.StatusOK, PaywallDecisionResponse{Allow: response.HasAccess, Limited: response.Limited})
+	telemetry.RecordPaywallCheck(result)
+}
+
+func decisionOf(response *PaywallCheckResponse) string {
+	switch {
+	case !response.HasAccess:
+		return "deny"
+	case response.Limited:
+		return "limited"
+	default:
+		return "allow"
+	}
+}
+
+// decisionCacheControl lets a private cache reuse a grant for
+// decisionMaxAge, but not past the access's expiry. Decisions depend on the
+// user, so shared caches must key them by user_id or not store them.
+func decisionCacheControl(response *PaywallCheckResponse, now time.Time) string {
+	if !response.HasAccess {
+		return "no-store"
+	}
+	maxAge := decisionMaxAge
+	if !response.ExpiresAt.IsZero() {
+		if remaining := response.ExpiresAt.Sub(now); remaining < maxAge {
+			maxAge = remaining
+		}
+	}
+	if maxAge <= 0 {
+		return "no-store"
+	}
+	return fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds()))
+}
@@ -0,0 +1,26 @@
+package paywall
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionCacheControl(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, "no-store", decisionCacheControl(&PaywallCheckResponse{Reason: "No active subscription found"}, now))
+	assert.Equal(t, "private, max-age=60", decisionCacheControl(&PaywallCheckResponse{HasAccess: true, ExpiresAt: now.AddDate(0, 1, 0)}, now))
+	assert.Equal(t, "private, max-age=60", decisionCacheControl(&PaywallCheckResponse{HasAccess: true, Limited: true}, now))
+
+	// Grants about to expire are reused only until they do
+	assert.Equal(t, "private, max-age=20", decisionCacheControl(&PaywallCheckResponse{HasAccess: true, ExpiresAt: now.Add(20 * time.Second)}, now))
+	assert.Equal(t, "no-store", decisionCacheControl(&PaywallCheckResponse{HasAccess: true, ExpiresAt: now.Add(-time.Second)}, now))
+}
+
+func TestDecisionOf(t *testing.T) {
+	assert.Equal(t, "deny", decisionOf(&PaywallCheckResponse{}))
+	assert.Equal(t, "limited", decisionOf(&PaywallCheckResponse{HasAccess: true, Limited: true}))
+	assert.Equal(t, "allow", decisionOf(&PaywallCheckResponse{HasAccess: true}))
+}
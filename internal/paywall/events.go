@@ -0,0 +1,161 @@
+package paywall
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+)
+
+// actionCheck is the action recorded for CheckAccess, which has none
+const actionCheck = "check"
+
+// Event is one paywall decision, kept for the paywall analytics
+type Event struct {
+	UserID    string
+	ContentID string
+	Action    string
+	PlanID    string
+	Allowed   bool
+	Reason    string
+	CreatedAt time.Time
+}
+
+// EventRecorder persists paywall events off the request path. Record only
+// buffers; Run writes the buffer in batches. Events are dropped (and
+// counted) rather than slowing the paywall down when the buffer is full.
+type EventRecorder struct {
+	db            *db.Connection
+	events        chan Event
+	batchSize     int
+	flushInterval time.Duration
+}
+
+func NewEventRecorder(cfg *config.PaywallConfig, db *db.Connection) *EventRecorder {
+	return &EventRecorder{
+		db:            db,
+		events:        make(chan Event, cfg.EventBuffer),
+		batchSize:     cfg.EventBatchSize,
+		flushInterval: time.Duration(cfg.EventFlushInterval) * time.Second,
+	}
+}
+
+// Record queues an event without blocking.
+func (r *EventRecorder) Record(event Event) {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	select {
+	case r.events <- event:
+	default:
+		telemetry.RecordPaywallEventsDropped(1)
+	}
+}
+
+// Run writes buffered events until ctx is done, then flushes what is left.
+// Run it once per instance.
+func (r *EventRecorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, r.batchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := r.insert(ctx, batch); err != nil {
+			logrus.Errorf("Failed to write %d paywall events: %v", len(batch), err)
+			telemetry.RecordPaywallEventsDropped(len(batch))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Drain with a fresh context; ctx is already cancelled
+			drainCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			for {
+				select {
+				case event := <-r.events:
+					batch = append(batch, event)
+					if len(batch) >= r.batchSize {
+						flush(drainCtx)
+					}
+				default:
+					flush(drainCtx)
+					return
+				}
+			}
+		case event := <-r.events:
+			batch = append(batch, event)
+			if len(batch) >= r.batchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+// insert writes events with one multi-row INSERT
+func (r *EventRecorder) insert(ctx context.Context, events []Event) error {
+	const columns = 7
+	placeholders := make([]string, 0, len(events))
+	args := make([]interface{}, 0, len(events)*columns)
+	for i, e := range events {
+		n := i * columns
+		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, NULLIF($%d, ''), $%d, NULLIF($%d, ''), $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7))
+		args = append(args, e.UserID, e.ContentID, e.Action, e.PlanID, e.Allowed, e.Reason, e.CreatedAt)
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO paywall_events (user_id, content_id, action, plan_id, allowed, reason, created_at)
+		VALUES `+strings.Join(placeholders, ", "), args...)
+	return err
+}
+
+func (s *Service) recordCheck(req PaywallCheckRequest, response *PaywallCheckResponse) {
+	s.events.Record(Event{
+		UserID:    req.UserID,
+		ContentID: req.ContentID,
+		Action:    actionCheck,
+		PlanID:    req.PlanID,
+		Allowed:   response.HasAccess,
+		Reason:    response.Reason,
+	})
+}
+
+func (s *Service) recordEnforce(req PaywallEnforceRequest, access access, allowed bool, reason string) {
+	s.events.Record(Event{
+		UserID:    req.UserID,
+		ContentID: req.ContentID,
+		Action:    req.Action,
+		PlanID:    access.planID(),
+		Allowed:   allowed,
+		Reason:    reason,
+	})
+}
+
+func (s *Service) recordBatchCheck(req PaywallBatchCheckRequest, item PaywallBatchCheckItem, result PaywallBatchCheckResult) {
+	action := item.Action
+	if action == "" {
+		action = actionCheck
+	}
+	s.events.Record(Event{
+		UserID:    req.UserID,
+		ContentID: item.ContentID,
+		Action:    action,
+		PlanID:    req.PlanID,
+		Allowed:   result.HasAccess,
+		Reason:    result.Reason,
+	})
+}
@@ -0,0 +1,173 @@
+package paywall
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// MeterFeatureRequest records Amount uses of a metered feature, 1 if unset
+type MeterFeatureRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+	Amount int    `json:"amount" binding:"omitempty,min=1,max=10000"`
+}
+
+// FeatureUsage is a user's counter for one metered catalog feature in the
+// current day or usage month. The plan's value of the feature is the
+// limit; a plan that doesn't set it leaves the feature Unlimited, counted
+// but never blocked.
+type FeatureUsage struct {
+	Feature string `json:"feature"`
+	Period  string `json:"period"`
+	UsageInfo
+	Unlimited bool      `json:"unlimited,omitempty"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// FeatureUsageReport lists a user's usage of every metered feature
+type FeatureUsageReport struct {
+	UserID   string         `json:"user_id"`
+	PlanID   string         `json:"plan_id,omitempty"`
+	Features []FeatureUsage `json:"features"`
+}
+
+// meteredFeatures are the catalog features the paywall counts
+func meteredFeatures(catalog []config.FeatureConfig) []config.FeatureConfig {
+	var metered []config.FeatureConfig
+	for _, feature := range catalog {
+		if feature.Metered != "" {
+			metered = append(metered, feature)
+		}
+	}
+	return metered
+}
+
+func (s *Service) meteredFeature(key string) (config.FeatureConfig, bool) {
+	for _, feature := range s.features {
+		if feature.Key == key {
+			return feature, true
+		}
+	}
+	return config.FeatureConfig{}, false
+}
+
+// MeterFeature counts uses of a metered feature, refusing them with 403
+// once the plan's limit and overage grace are used up
+func (s *Service) MeterFeature(c *gin.Context) {
+	feature, ok := s.meteredFeature(c.Param("key"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feature is not metered"})
+		return
+	}
+	var req MeterFeatureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Amount == 0 {
+		req.Amount = 1
+	}
+
+	ctx := c.Request.Context()
+	access, err := s.checkSubscriptionAccess(ctx, req.UserID, "")
+	if err != nil {
+		logrus.Errorf("Failed to check subscription access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !access.granted {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied", "reason": access.reason})
+		return
+	}
+
+	now := time.Now()
+	period := s.featurePeriod(access, feature, now)
+	usage := s.featureUsage(ctx, access, req.UserID, feature, period)
+	if !usage.Unlimited && usage.Current+req.Amount > usage.Limit+usage.Grace {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied", "reason": "Feature usage limit reached", "usage": usage})
+		return
+	}
+
+	key := featureUsageKey(req.UserID, feature.Key, period.end)
+	if err := s.incrementCounterBy(ctx, key, req.Amount, period.ttl(now)); err != nil {
+		logrus.Errorf("Failed to meter feature %s: %v", feature.Key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if err := s.subscriptionSvc.RecordFeatureUsage(ctx, req.UserID, access.subscriptionID(), feature.Key, req.Amount); err != nil {
+		logrus.Errorf("Failed to record feature usage: %v", err)
+	}
+
+	c.JSON(http.StatusOK, s.featureUsage(ctx, access, req.UserID, feature, period))
+}
+
+// GetFeatureUsage reports a user's usage of every metered feature
+func (s *Service) GetFeatureUsage(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	access, err := s.checkSubscriptionAccess(ctx, userID, "")
+	if err != nil {
+		logrus.Errorf("Failed to check subscription access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, FeatureUsageReport{
+		UserID:   userID,
+		PlanID:   access.planID(),
+		Features: s.featureUsages(ctx, access, userID),
+	})
+}
+
+// featureUsages reads the user's counter of every metered feature; none
+// when they have no access
+func (s *Service) featureUsages(ctx context.Context, access access, userID string) []FeatureUsage {
+	usages := make([]FeatureUsage, 0, len(s.features))
+	if !access.granted {
+		return usages
+	}
+	now := time.Now()
+	for _, feature := range s.features {
+		period := s.featurePeriod(access, feature, now)
+		usages = append(usages, s.featureUsage(ctx, access, userID, feature, period))
+	}
+	return usages
+}
+
+// featurePeriod is the day or usage month feature is counted in
+func (s *Service) featurePeriod(access access, feature config.FeatureConfig, now time.Time) usagePeriod {
+	if feature.Metered == "monthly" {
+		return access.monthlyPeriod(now)
+	}
+	return dailyPeriod(now, s.usageLocation(access))
+}
+
+func (s *Service) featureUsage(ctx context.Context, access access, userID string, feature config.FeatureConfig, period usagePeriod) FeatureUsage {
+	keyOf := func(end time.Time) string { return featureUsageKey(userID, feature.Key, end) }
+	usage := FeatureUsage{Feature: feature.Key, Period: feature.Metered, ResetsAt: period.end}
+
+	// Features decoded from JSON hold numbers as float64
+	limit, ok := access.entitlement.Features[feature.Key].(float64)
+	if !ok {
+		usage.Unlimited = true
+		usage.Current = s.counter(ctx, keyOf(period.end))
+		return usage
+	}
+	usage.UsageInfo = s.readUsage(ctx, access, keyOf, period, int(limit), 0)
+	return usage
+}
+
+func featureUsageKey(userID, feature string, end time.Time) string {
+	return fmt.Sprintf("usage_feature:%s:%s:%d", userID, feature, end.Unix())
+}
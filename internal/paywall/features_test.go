@@ -0,0 +1,36 @@
+package paywall
+
+import (
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeteredFeatures(t *testing.T) {
+	catalog := []config.FeatureConfig{
+		{Key: "api_access", Type: "bool"},
+		{Key: "api_calls", Type: "int", Metered: "monthly"},
+		{Key: "storage_gb", Type: "int"},
+		{Key: "exports", Type: "int", Metered: "daily"},
+	}
+	s := &Service{features: meteredFeatures(catalog)}
+
+	assert.Len(t, s.features, 2)
+	feature, ok := s.meteredFeature("exports")
+	assert.True(t, ok)
+	assert.Equal(t, "daily", feature.Metered)
+	_, ok = s.meteredFeature("storage_gb")
+	assert.False(t, ok)
+}
+
+func TestFeaturePeriod(t *testing.T) {
+	s := &Service{usage: config.PaywallUsageConfig{Timezone: "UTC"}}
+	now := time.Date(2024, time.March, 10, 15, 0, 0, 0, time.UTC)
+
+	daily := s.featurePeriod(access{}, config.FeatureConfig{Metered: "daily"}, now)
+	assert.Equal(t, 24*time.Hour, daily.end.Sub(daily.start))
+	assert.True(t, daily.end.After(now))
+}
@@ -0,0 +1,197 @@
+//go:build integration
+
+package paywall_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/paywall"
+	"scalable-paywall/internal/plan"
+	"scalable-paywall/internal/subscription"
+	testenv "scalable-paywall/internal/testing"
+	"scalable-paywall/internal/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var env *testenv.Env
+
+func TestMain(m *testing.M) { os.Exit(testenv.Main(m, &env)) }
+
+func createPlan(t *testing.T, req plan.CreatePlanRequest) *plan.Plan {
+	t.Helper()
+	p, err := env.Plans().ImportPlan(context.Background(), req)
+	require.NoError(t, err)
+	return p
+}
+
+func createUser(t *testing.T, username string) *user.User {
+	t.Helper()
+	u, err := env.Users().ImportUser(context.Background(), user.CreateUserRequest{
+		Email:    username + "@example.com",
+		Username: username,
+	})
+	require.NoError(t, err)
+	return u
+}
+
+func check(t *testing.T, svc *paywall.Service, userID, contentID, planID string) paywall.PaywallCheckResponse {
+	t.Helper()
+	body := fmt.Sprintf(`{"user_id": %q, "content_id": %q, "plan_id": %q}`, userID, contentID, planID)
+	w := testenv.Serve(svc.CheckAccess, http.MethodPost, "/api/v1/paywall/check", body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response paywall.PaywallCheckResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func enforce(svc *paywall.Service, userID, contentID, action string) int {
+	body := fmt.Sprintf(`{"user_id": %q, "content_id": %q, "action": %q}`, userID, contentID, action)
+	return testenv.Serve(svc.EnforcePaywall, http.MethodPost, "/api/v1/paywall/enforce", body).Code
+}
+
+func TestCheckAccess(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	svc := env.Paywall(subs)
+	pro := createPlan(t, plan.CreatePlanRequest{Name: "Pro", Price: decimal.RequireFromString("19.99"), Currency: "USD", BillingCycle: "monthly"})
+	basic := createPlan(t, plan.CreatePlanRequest{Name: "Basic", Price: decimal.RequireFromString("9.99"), Currency: "USD", BillingCycle: "monthly"})
+	jane := createUser(t, "jane")
+	john := createUser(t, "john")
+
+	start := time.Now().AddDate(0, 0, -3)
+	end := start.AddDate(0, 1, 0)
+	_, err := subs.ImportSubscription(ctx, subscription.ImportSubscriptionRequest{
+		UserID:      jane.ID,
+		PlanID:      pro.ID,
+		StartDate:   &start,
+		EndDate:     &end,
+		ExternalRef: "jane-pro",
+	})
+	require.NoError(t, err)
+
+	granted := check(t, svc, jane.ID, "article-1", pro.ID)
+	assert.True(t, granted.HasAccess)
+	assert.False(t, granted.Limited)
+	assert.WithinDuration(t, end, granted.ExpiresAt, time.Second)
+
+	// The cached decision is the same
+	assert.Equal(t, granted.HasAccess, check(t, svc, jane.ID, "article-1", pro.ID).HasAccess)
+
+	mismatch := check(t, svc, jane.ID, "article-1", basic.ID)
+	assert.False(t, mismatch.HasAccess)
+	assert.Equal(t, "Plan mismatch", mismatch.Reason)
+
+	denied := check(t, svc, john.ID, "article-1", pro.ID)
+	assert.False(t, denied.HasAccess)
+	assert.Equal(t, "No active subscription found", denied.Reason)
+}
+
+func TestEnforcePaywallMetersFreePlan(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	svc := env.Paywall(subs)
+	limit := 2
+	createPlan(t, plan.CreatePlanRequest{Name: "Free", Price: decimal.Zero, Currency: "USD", BillingCycle: "monthly", Type: plan.PlanTypeFree, MaxUsagePerDay: &limit})
+	jane := createUser(t, "jane")
+	john := createUser(t, "john")
+
+	free, err := subs.EnrollFree(ctx, jane.ID)
+	require.NoError(t, err)
+	require.NotNil(t, free)
+
+	assert.Equal(t, http.StatusOK, enforce(svc, jane.ID, "article-1", "view"))
+	assert.Equal(t, http.StatusOK, enforce(svc, jane.ID, "article-2", "view"))
+	assert.Equal(t, http.StatusForbidden, enforce(svc, jane.ID, "article-3", "view"))
+
+	// Each action has its own counter
+	assert.Equal(t, http.StatusOK, enforce(svc, jane.ID, "article-3", "share"))
+
+	// Without a subscription nothing is allowed
+	assert.Equal(t, http.StatusForbidden, enforce(svc, john.ID, "article-1", "view"))
+
+	var logged int
+	require.NoError(t, env.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM usage_logs WHERE user_id = $1`, jane.ID).Scan(&logged))
+	assert.Equal(t, 3, logged)
+}
+
+func TestLeasesLimitConcurrentUse(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	svc := env.Paywall(subs)
+	pro := createPlan(t, plan.CreatePlanRequest{Name: "Pro", Price: decimal.RequireFromString("19.99"), Currency: "USD", BillingCycle: "monthly"})
+	jane := createUser(t, "jane")
+	john := createUser(t, "john")
+	_, err := subs.ImportSubscription(ctx, subscription.ImportSubscriptionRequest{UserID: jane.ID, PlanID: pro.ID, ExternalRef: "jane-pro"})
+	require.NoError(t, err)
+
+	// "watch" is not listed in paywall.leases.actions, so the default limit
+	// of one applies
+	body := fmt.Sprintf(`{"user_id": %q, "action": "watch", "content_id": "movie-1"}`, jane.ID)
+	w := testenv.Serve(svc.AcquireLease, http.MethodPost, "/api/v1/paywall/leases", body)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var lease paywall.Lease
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &lease))
+	assert.Equal(t, 1, lease.Active)
+	assert.Equal(t, 1, lease.Limit)
+
+	assert.Equal(t, http.StatusConflict, testenv.Serve(svc.AcquireLease, http.MethodPost, "/api/v1/paywall/leases", body).Code)
+
+	id := gin.Param{Key: "id", Value: lease.ID}
+	assert.Equal(t, http.StatusOK, testenv.Serve(svc.HeartbeatLease, http.MethodPost, "/api/v1/paywall/leases/"+lease.ID+"/heartbeat", body, id).Code)
+	assert.Equal(t, http.StatusOK, testenv.Serve(svc.ReleaseLease, http.MethodPost, "/api/v1/paywall/leases/"+lease.ID+"/release", body, id).Code)
+
+	// A released lease can't be renewed, and its slot is free again
+	assert.Equal(t, http.StatusNotFound, testenv.Serve(svc.HeartbeatLease, http.MethodPost, "/api/v1/paywall/leases/"+lease.ID+"/heartbeat", body, id).Code)
+	assert.Equal(t, http.StatusCreated, testenv.Serve(svc.AcquireLease, http.MethodPost, "/api/v1/paywall/leases", body).Code)
+
+	// Without a subscription no lease is granted
+	denied := fmt.Sprintf(`{"user_id": %q, "action": "watch"}`, john.ID)
+	assert.Equal(t, http.StatusForbidden, testenv.Serve(svc.AcquireLease, http.MethodPost, "/api/v1/paywall/leases", denied).Code)
+}
+
+func TestEnforcePaywallFlagsAccountsSharingADevice(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	svc := env.Paywall(subs)
+	detector := paywall.NewAbuseDetector(env.Config.Paywall.Abuse, env.DB, env.Cache)
+	createPlan(t, plan.CreatePlanRequest{Name: "Free", Price: decimal.Zero, Currency: "USD", BillingCycle: "monthly", Type: plan.PlanTypeFree})
+
+	// The default limit is three accounts per device
+	var last string
+	for i := 0; i < 4; i++ {
+		u := createUser(t, fmt.Sprintf("reader%d", i))
+		_, err := subs.EnrollFree(ctx, u.ID)
+		require.NoError(t, err)
+		body := fmt.Sprintf(`{"user_id": %q, "content_id": "article-1", "action": "view", "device_id": "device-1"}`, u.ID)
+		w := testenv.Serve(svc.EnforcePaywall, http.MethodPost, "/api/v1/paywall/enforce", body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		last = u.ID
+	}
+
+	w := testenv.Serve(detector.ListAbuseFlags, http.MethodGet, "/api/v1/admin/abuse-flags?status=pending", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var list paywall.AbuseFlagListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Flags, 1)
+	assert.Equal(t, last, list.Flags[0].UserID)
+	assert.Equal(t, []string{"4 accounts on one device within 24h0m0s"}, list.Flags[0].Reasons)
+
+	id := gin.Param{Key: "id", Value: list.Flags[0].ID}
+	resolve := `{"decision": "dismiss", "note": "family sharing a tablet"}`
+	assert.Equal(t, http.StatusOK, testenv.Serve(detector.ResolveAbuseFlag, http.MethodPost, "/api/v1/admin/abuse-flags/"+list.Flags[0].ID+"/resolve", resolve, id).Code)
+	assert.Equal(t, http.StatusConflict, testenv.Serve(detector.ResolveAbuseFlag, http.MethodPost, "/api/v1/admin/abuse-flags/"+list.Flags[0].ID+"/resolve", resolve, id).Code)
+}
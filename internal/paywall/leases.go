@@ -0,0 +1,238 @@
+package paywall
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// A user's leases on an action are a sorted set of lease IDs scored by
+// when they expire, so expired leases are dropped before counting and a
+// client that stops sending heartbeats gives its slot back on its own.
+
+// acquireLeaseScript adds a lease unless the user already holds the limit,
+// in one step so concurrent acquires can't both take the last slot. KEYS[1]
+// is the set, ARGV[1] now and ARGV[2] the TTL in milliseconds, ARGV[3] the
+// limit and ARGV[4] the lease ID. It returns whether the lease was added and
+// how many are held after it.
+var acquireLeaseScript = cache.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local active = redis.call('ZCARD', KEYS[1])
+if active >= tonumber(ARGV[3]) then
+	return {0, active}
+end
+redis.call('ZADD', KEYS[1], tonumber(ARGV[1]) + tonumber(ARGV[2]), ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return {1, active + 1}
+`)
+
+// renewLeaseScript extends an unexpired lease by the TTL. KEYS[1] is the
+// set, ARGV[1] now and ARGV[2] the TTL in milliseconds and ARGV[3] the lease
+// ID. It returns 1 when the lease was renewed.
+var renewLeaseScript = cache.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if not redis.call('ZSCORE', KEYS[1], ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], 'XX', tonumber(ARGV[1]) + tonumber(ARGV[2]), ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// releaseLeaseScript removes lease ARGV[1] from set KEYS[1]
+var releaseLeaseScript = cache.NewScript(`
+return redis.call('ZREM', KEYS[1], ARGV[1])
+`)
+
+const reasonLeaseLimitReached = "Concurrent limit reached"
+
+// LeaseRequest names the user and action of a lease: the action to acquire
+// one for, or of the lease a heartbeat or release is for.
+type LeaseRequest struct {
+	UserID    string `json:"user_id" binding:"required"`
+	Action    string `json:"action" binding:"required"`
+	ContentID string `json:"content_id"`
+}
+
+// Lease is a held slot of an action the user's plan limits concurrent use
+// of. Active is how many leases on the action the user holds with it.
+type Lease struct {
+	ID        string    `json:"lease_id"`
+	UserID    string    `json:"user_id"`
+	Action    string    `json:"action"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Active    int       `json:"active,omitempty"`
+	Limit     int       `json:"limit"`
+}
+
+// AcquireLease takes one of the user's concurrent slots for an action, such
+// as starting a stream. The lease expires after paywall.leases.ttl seconds
+// unless renewed by HeartbeatLease, so clients that vanish free their slot.
+func (s *Service) AcquireLease(c *gin.Context) {
+	var req LeaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	access, err := s.checkSubscriptionAccess(ctx, req.UserID, "")
+	if err != nil {
+		logrus.Errorf("Failed to check subscription access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	enforce := PaywallEnforceRequest{UserID: req.UserID, ContentID: req.ContentID, Action: req.Action}
+	if !access.granted {
+		s.recordEnforce(enforce, access, false, access.reason)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied", "reason": access.reason})
+		return
+	}
+
+	lease := &Lease{
+		ID:     newLeaseID(),
+		UserID: req.UserID,
+		Action: req.Action,
+		Limit:  leaseLimit(s.leases, access, req.Action),
+	}
+	now := time.Now()
+	ttl := time.Duration(s.leases.TTL) * time.Second
+	result, err := s.cache.RunScript(ctx, acquireLeaseScript, []string{leaseKey(req.UserID, req.Action)},
+		now.UnixMilli(), ttl.Milliseconds(), lease.Limit, lease.ID)
+	if err != nil {
+		logrus.Errorf("Failed to acquire %s lease for user %s: %v", req.Action, req.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	acquired, active := scriptPair(result)
+	if !acquired {
+		s.recordEnforce(enforce, access, false, reasonLeaseLimitReached)
+		c.JSON(http.StatusConflict, gin.H{"error": reasonLeaseLimitReached, "active": active, "limit": lease.Limit})
+		return
+	}
+
+	s.recordEnforce(enforce, access, true, access.reason)
+	lease.Active = active
+	lease.ExpiresAt = now.Add(ttl)
+	c.JSON(http.StatusCreated, lease)
+}
+
+// HeartbeatLease renews a lease for another TTL. A lease that expired or
+// was released is gone and has to be acquired again, and a user who lost
+// access loses their leases.
+func (s *Service) HeartbeatLease(c *gin.Context) {
+	var req LeaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	access, err := s.checkSubscriptionAccess(ctx, req.UserID, "")
+	if err != nil {
+		logrus.Errorf("Failed to check subscription access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !access.granted {
+		if err := s.releaseLease(ctx, req.UserID, req.Action, id); err != nil {
+			logrus.Warnf("Failed to release %s lease of user %s without access: %v", req.Action, req.UserID, err)
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied", "reason": access.reason})
+		return
+	}
+
+	now := time.Now()
+	ttl := time.Duration(s.leases.TTL) * time.Second
+	renewed, err := s.cache.RunScript(ctx, renewLeaseScript, []string{leaseKey(req.UserID, req.Action)},
+		now.UnixMilli(), ttl.Milliseconds(), id)
+	if err != nil {
+		logrus.Errorf("Failed to renew %s lease for user %s: %v", req.Action, req.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if renewed != int64(1) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lease not found or expired"})
+		return
+	}
+
+	c.JSON(http.StatusOK, &Lease{
+		ID:        id,
+		UserID:    req.UserID,
+		Action:    req.Action,
+		ExpiresAt: now.Add(ttl),
+		Limit:     leaseLimit(s.leases, access, req.Action),
+	})
+}
+
+// ReleaseLease gives a lease's slot back straight away, when a stream
+// stops. Releasing a lease that already expired succeeds too.
+func (s *Service) ReleaseLease(c *gin.Context) {
+	var req LeaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.releaseLease(c.Request.Context(), req.UserID, req.Action, c.Param("id")); err != nil {
+		logrus.Errorf("Failed to release %s lease for user %s: %v", req.Action, req.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"released": true})
+}
+
+func (s *Service) releaseLease(ctx context.Context, userID, action, id string) error {
+	_, err := s.cache.RunScript(ctx, releaseLeaseScript, []string{leaseKey(userID, action)}, id)
+	return err
+}
+
+// leaseLimit is how many leases on action a user may hold: their plan's
+// paywall_<action>_concurrent feature when set, else the configured one
+func leaseLimit(cfg config.PaywallLeaseConfig, access access, action string) int {
+	if access.entitlement != nil {
+		// Features decoded from JSON hold numbers as float64
+		if limit, ok := access.entitlement.Features[leaseFeature(action)].(float64); ok && limit > 0 {
+			return int(limit)
+		}
+	}
+	if limit, ok := cfg.Actions[action]; ok {
+		return limit
+	}
+	return cfg.Limit
+}
+
+// scriptPair reads the {flag, count} reply of acquireLeaseScript
+func scriptPair(result interface{}) (bool, int) {
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0
+	}
+	flag, _ := values[0].(int64)
+	count, _ := values[1].(int64)
+	return flag == 1, int(count)
+}
+
+func newLeaseID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func leaseFeature(action string) string {
+	return fmt.Sprintf("paywall_%s_concurrent", action)
+}
+
+func leaseKey(userID, action string) string {
+	return fmt.Sprintf("paywall_leases:%s:%s", userID, action)
+}
@@ -0,0 +1,34 @@
+package paywall
+
+import (
+	"testing"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/subscription"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseLimit(t *testing.T) {
+	cfg := config.PaywallLeaseConfig{TTL: 60, Limit: 1, Actions: map[string]int{"stream": 2}}
+
+	assert.Equal(t, 2, leaseLimit(cfg, access{}, "stream"))
+	assert.Equal(t, 1, leaseLimit(cfg, access{}, "download"))
+
+	family := access{entitlement: &subscription.Entitlement{Features: map[string]interface{}{"paywall_stream_concurrent": float64(4)}}}
+	assert.Equal(t, 4, leaseLimit(cfg, family, "stream"))
+	assert.Equal(t, 1, leaseLimit(cfg, family, "download"))
+}
+
+func TestScriptPair(t *testing.T) {
+	acquired, active := scriptPair([]interface{}{int64(1), int64(2)})
+	assert.True(t, acquired)
+	assert.Equal(t, 2, active)
+
+	acquired, active = scriptPair([]interface{}{int64(0), int64(3)})
+	assert.False(t, acquired)
+	assert.Equal(t, 3, active)
+
+	acquired, _ = scriptPair(nil)
+	assert.False(t, acquired)
+}
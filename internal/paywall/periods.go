@@ -0,0 +1,163 @@
+package paywall
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// locations caches loaded timezones by IANA name
+var locations sync.Map
+
+// loadLocation returns the named timezone, or nil if it is unknown
+func loadLocation(name string) *time.Location {
+	if cached, ok := locations.Load(name); ok {
+		return cached.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		logrus.Warnf("Unknown usage timezone %q: %v", name, err)
+		return nil
+	}
+	locations.Store(name, loc)
+	return loc
+}
+
+// usageLocation is the timezone daily counters reset in: the user's own,
+// else their tenant's, else the configured default or server local time.
+func (s *Service) usageLocation(access access) *time.Location {
+	if access.entitlement != nil && access.entitlement.UserTimezone != "" {
+		if loc := loadLocation(access.entitlement.UserTimezone); loc != nil {
+			return loc
+		}
+	}
+	if name, ok := s.usage.TenantTimezones[access.tenantID()]; ok && access.tenantID() != "" {
+		if loc := loadLocation(name); loc != nil {
+			return loc
+		}
+	}
+	if s.usage.Timezone != "" {
+		if loc := loadLocation(s.usage.Timezone); loc != nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// usagePeriod is the span of one daily or monthly usage counter. Counters
+// are keyed by the end of their period and kept for one more period after
+// it, so the next period can roll over what was left unused.
+type usagePeriod struct {
+	start time.Time
+	end   time.Time
+}
+
+// ttl is how long a counter of the period is kept from now
+func (p usagePeriod) ttl(now time.Time) time.Duration {
+	return p.end.Sub(now) + p.end.Sub(p.start)
+}
+
+// usageResetAt is when daily usage counters reset: the next midnight in loc
+func usageResetAt(now time.Time, loc *time.Location) time.Time {
+	local := now.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+}
+
+// dailyPeriod is the day containing now in loc
+func dailyPeriod(now time.Time, loc *time.Location) usagePeriod {
+	end := usageResetAt(now, loc)
+	return usagePeriod{start: end.AddDate(0, 0, -1), end: end}
+}
+
+// monthlyPeriod is the usage month containing now, which runs between the
+// subscription's billing anchor days
+func (a access) monthlyPeriod(now time.Time) usagePeriod {
+	start, end := a.entitlement.Subscription.UsageMonth(now)
+	return usagePeriod{start: start, end: end}
+}
+
+// monthlyLimit is the plan's monthly cap for each action, if it has one
+func (a access) monthlyLimit() (int, bool) {
+	if a.entitlement == nil || a.entitlement.MaxUsagePerMonth == nil {
+		return 0, false
+	}
+	return *a.entitlement.MaxUsagePerMonth, true
+}
+
+// rollover is how much of limit the previous period left unused that
+// carries into period, up to the plan's rollover cap, which the caller has
+// checked is set. Nothing carries over into the subscription's first period.
+func (a access) rollover(period usagePeriod, limit, previous int) int {
+	if period.start.Before(a.entitlement.Subscription.StartDate) {
+		return 0
+	}
+	unused := limit - previous
+	if unused < 0 {
+		unused = 0
+	}
+	if unused > *a.entitlement.RolloverCap {
+		unused = *a.entitlement.RolloverCap
+	}
+	return unused
+}
+
+// grace is how far usage may run past limit before it is blocked
+func (a access) grace(limit int) int {
+	if a.entitlement == nil {
+		return 0
+	}
+	return limit * a.entitlement.OveragePercent / 100
+}
+
+// readUsage reads the counter for period against the plan's limit plus
+// boost, adding the previous period's rollover and the overage grace.
+// keyOf names the counter of the period ending at a given time.
+func (s *Service) readUsage(ctx context.Context, access access, keyOf func(time.Time) string, period usagePeriod, limit, boost int) UsageInfo {
+	info := UsageInfo{Current: s.counter(ctx, keyOf(period.end)), Limit: limit + boost}
+	if access.entitlement != nil && access.entitlement.RolloverCap != nil {
+		info.Rollover = access.rollover(period, limit, s.counter(ctx, keyOf(period.start)))
+		info.Limit += info.Rollover
+	}
+	info.Grace = access.grace(info.Limit)
+
+	info.Remaining = info.Limit - info.Current
+	if info.Remaining < 0 {
+		info.Overage = -info.Remaining
+		info.Remaining = 0
+	}
+	return info
+}
+
+// counter reads a usage counter; a missing or unreadable one counts as no
+// usage
+func (s *Service) counter(ctx context.Context, key string) int {
+	value, err := s.cache.Get(ctx, key)
+	if err != nil {
+		return 0
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+// checkMonthlyUsage reads the monthly counter for action. It returns nil
+// when the plan has no monthly cap.
+func (s *Service) checkMonthlyUsage(ctx context.Context, access access, userID, action string, now time.Time) (*UsageInfo, error) {
+	limit, ok := access.monthlyLimit()
+	if !ok {
+		return nil, nil
+	}
+	keyOf := func(end time.Time) string { return monthlyUsageKey(userID, action, end) }
+	info := s.readUsage(ctx, access, keyOf, access.monthlyPeriod(now), limit, 0)
+	return &info, nil
+}
+
+func monthlyUsageKey(userID, action string, end time.Time) string {
+	return fmt.Sprintf("usage_month:%s:%s:%d", userID, action, end.Unix())
+}
@@ -0,0 +1,75 @@
+package paywall
+
+import (
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/subscription"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageResetAt(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+
+	// 20:00 UTC is already 05:00 the next day in Tokyo
+	now := time.Date(2024, time.March, 10, 20, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC), usageResetAt(now, time.UTC))
+	assert.Equal(t, time.Date(2024, time.March, 12, 0, 0, 0, 0, tokyo), usageResetAt(now, tokyo))
+}
+
+func TestUsageLocation(t *testing.T) {
+	s := &Service{usage: config.PaywallUsageConfig{
+		Timezone:        "Europe/Berlin",
+		TenantTimezones: map[string]string{"acme": "America/New_York"},
+	}}
+	withTimezone := access{entitlement: &subscription.Entitlement{UserTimezone: "Asia/Tokyo", UserTenant: "acme"}}
+	acme := access{entitlement: &subscription.Entitlement{UserTenant: "acme"}}
+	other := access{entitlement: &subscription.Entitlement{UserTenant: "other"}}
+
+	assert.Equal(t, "Asia/Tokyo", s.usageLocation(withTimezone).String())
+	assert.Equal(t, "America/New_York", s.usageLocation(acme).String())
+	assert.Equal(t, "Europe/Berlin", s.usageLocation(other).String())
+	assert.Equal(t, "Europe/Berlin", s.usageLocation(access{}).String())
+}
+
+func TestDailyPeriodAcrossDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+
+	// Clocks go forward on 31 March 2024, so that day is 23 hours long
+	day := dailyPeriod(time.Date(2024, time.March, 31, 12, 0, 0, 0, berlin), berlin)
+	assert.Equal(t, time.Date(2024, time.March, 31, 0, 0, 0, 0, berlin), day.start)
+	assert.Equal(t, 23*time.Hour, day.end.Sub(day.start))
+}
+
+func TestRollover(t *testing.T) {
+	rolloverCap := 20
+	sub := &subscription.Subscription{StartDate: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)}
+	a := access{entitlement: &subscription.Entitlement{Subscription: sub, RolloverCap: &rolloverCap}}
+	period := usagePeriod{
+		start: time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC),
+		end:   time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC),
+	}
+
+	assert.Equal(t, 5, a.rollover(period, 100, 95))
+	assert.Equal(t, 20, a.rollover(period, 100, 10))
+	assert.Equal(t, 0, a.rollover(period, 100, 130))
+
+	// Nothing carries over into the subscription's first day
+	first := usagePeriod{start: sub.StartDate.Add(-time.Hour), end: sub.StartDate.Add(23 * time.Hour)}
+	assert.Equal(t, 0, a.rollover(first, 100, 0))
+}
+
+func TestOverageGrace(t *testing.T) {
+	a := access{entitlement: &subscription.Entitlement{OveragePercent: 10}}
+	assert.Equal(t, 10, a.grace(100))
+
+	within := UsageInfo{Current: 105, Limit: 100, Grace: 10}
+	assert.False(t, within.exhausted())
+	over := UsageInfo{Current: 110, Limit: 100, Grace: 10}
+	assert.True(t, over.exhausted())
+	assert.True(t, UsageInfo{Current: 100, Limit: 100}.exhausted())
+}
@@ -0,0 +1,314 @@
+package paywall
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// meteredActions are the paywall actions with daily usage counters
+var meteredActions = []string{"view", "download", "share"}
+
+// maxBoostDuration bounds how long a quota boost can last
+const maxBoostDuration = 30 * 24 * time.Hour
+
+// Ledger actions for quota adjustments
+const (
+	adjustmentReset = "quota_reset"
+	adjustmentBoost = "quota_boost"
+)
+
+// QuotaUsage is a user's daily counter for one action. Limit includes any
+// active boost and rollover; Grace and Overage are as in UsageInfo. Monthly
+// is the monthly counter, for plans that cap it.
+type QuotaUsage struct {
+	Action         string        `json:"action"`
+	Current        int           `json:"current"`
+	Limit          int           `json:"limit"`
+	Remaining      int           `json:"remaining"`
+	Rollover       int           `json:"rollover,omitempty"`
+	Grace          int           `json:"grace,omitempty"`
+	Overage        int           `json:"overage,omitempty"`
+	Boost          int           `json:"boost"`
+	BoostExpiresAt *time.Time    `json:"boost_expires_at,omitempty"`
+	ResetsAt       *time.Time    `json:"resets_at,omitempty"`
+	Monthly        *MonthlyQuota `json:"monthly,omitempty"`
+}
+
+// MonthlyQuota is a user's monthly counter for one action, reset on their
+// subscription's billing anchor day.
+type MonthlyQuota struct {
+	UsageInfo
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// UserQuota is a user's counters for the paywall actions and, in Features,
+// the metered catalog features
+type UserQuota struct {
+	UserID   string         `json:"user_id"`
+	PlanID   string         `json:"plan_id,omitempty"`
+	Usage    []QuotaUsage   `json:"usage"`
+	Features []FeatureUsage `json:"features,omitempty"`
+}
+
+// ResetQuotaRequest resets one action's counter, or all of them when Action
+// is empty.
+type ResetQuotaRequest struct {
+	Action string `json:"action"`
+	Reason string `json:"reason" binding:"required"`
+}
+
+// BoostQuotaRequest raises the daily limit of one action, or all of them
+// when Action is empty, by Amount until ExpiresAt.
+type BoostQuotaRequest struct {
+	Action    string    `json:"action"`
+	Amount    int       `json:"amount" binding:"required,min=1"`
+	ExpiresAt time.Time `json:"expires_at" binding:"required"`
+	Reason    string    `json:"reason" binding:"required"`
+}
+
+// GetUserQuota serves a user's current usage counters, limits and boosts.
+func (s *Service) GetUserQuota(c *gin.Context) {
+	quota, _, err := s.userQuota(c.Request.Context(), c.Param("user_id"))
+	if err != nil {
+		logrus.Errorf("Failed to get usage quota: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, quota)
+}
+
+// ResetUserQuota zeroes a user's usage counters so a customer hit by the
+// daily limit is unblocked straight away. Each reset is written to the usage
+// ledger with the counter's previous value.
+func (s *Service) ResetUserQuota(c *gin.Context) {
+	userID := c.Param("user_id")
+	var req ResetQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	actions, err := quotaActions(req.Action)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	quota, access, err := s.userQuota(ctx, userID)
+	if err != nil {
+		logrus.Errorf("Failed to get usage quota: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	for _, action := range actions {
+		details := map[string]interface{}{
+			"action":   action,
+			"previous": quota.usage(action).Current,
+			"reason":   req.Reason,
+		}
+		if err := s.subscriptionSvc.RecordUsageAdjustment(ctx, userID, access.subscriptionID(), adjustmentReset, details); err != nil {
+			logrus.Errorf("Failed to record quota reset: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset usage quota"})
+			return
+		}
+		if err := s.cache.Del(ctx, s.currentUsageKeys(access, userID, action)...); err != nil {
+			logrus.Errorf("Failed to reset usage counter: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset usage quota"})
+			return
+		}
+	}
+	logrus.Infof("Usage quota reset for user %s (%v): %s", userID, actions, req.Reason)
+
+	s.respondWithQuota(c, userID)
+}
+
+// BoostUserQuota grants extra daily usage until the boost expires. Boosts
+// stack: a second boost adds its amount and keeps the later expiry.
+func (s *Service) BoostUserQuota(c *gin.Context) {
+	userID := c.Param("user_id")
+	var req BoostQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	actions, err := quotaActions(req.Action)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ttl := time.Until(req.ExpiresAt)
+	if ttl <= 0 || ttl > maxBoostDuration {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future and within 30 days"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	access, err := s.checkSubscriptionAccess(ctx, userID, "")
+	if err != nil {
+		logrus.Errorf("Failed to check subscription access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	for _, action := range actions {
+		details := map[string]interface{}{
+			"action":     action,
+			"amount":     req.Amount,
+			"expires_at": req.ExpiresAt,
+			"reason":     req.Reason,
+		}
+		if err := s.subscriptionSvc.RecordUsageAdjustment(ctx, userID, access.subscriptionID(), adjustmentBoost, details); err != nil {
+			logrus.Errorf("Failed to record quota boost: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to boost usage quota"})
+			return
+		}
+		if err := s.addBoost(ctx, userID, action, req.Amount, ttl); err != nil {
+			logrus.Errorf("Failed to store quota boost: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to boost usage quota"})
+			return
+		}
+	}
+	logrus.Infof("Usage quota boosted by %d for user %s (%v) until %s: %s",
+		req.Amount, userID, actions, req.ExpiresAt.Format(time.RFC3339), req.Reason)
+
+	s.respondWithQuota(c, userID)
+}
+
+func (s *Service) respondWithQuota(c *gin.Context, userID string) {
+	quota, _, err := s.userQuota(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Failed to get usage quota: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, quota)
+}
+
+// quotaActions returns the metered actions an adjustment applies to
+func quotaActions(action string) ([]string, error) {
+	if action == "" {
+		return meteredActions, nil
+	}
+	for _, metered := range meteredActions {
+		if action == metered {
+			return []string{action}, nil
+		}
+	}
+	return nil, fmt.Errorf("action must be one of %v", meteredActions)
+}
+
+func (q *UserQuota) usage(action string) QuotaUsage {
+	for _, usage := range q.Usage {
+		if usage.Action == action {
+			return usage
+		}
+	}
+	return QuotaUsage{Action: action}
+}
+
+func (s *Service) userQuota(ctx context.Context, userID string) (*UserQuota, access, error) {
+	access, err := s.checkSubscriptionAccess(ctx, userID, "")
+	if err != nil {
+		return nil, access, err
+	}
+
+	quota := &UserQuota{UserID: userID, PlanID: access.planID(), Usage: make([]QuotaUsage, 0, len(meteredActions))}
+	now := time.Now()
+	day := dailyPeriod(now, s.usageLocation(access))
+	for _, action := range meteredActions {
+		boost, boostTTL := s.boost(ctx, userID, action)
+		info, err := s.checkUsageLimits(ctx, access, userID, action, day)
+		if err != nil {
+			return nil, access, err
+		}
+
+		usage := QuotaUsage{
+			Action:    action,
+			Current:   info.Current,
+			Limit:     info.Limit,
+			Remaining: info.Remaining,
+			Rollover:  info.Rollover,
+			Grace:     info.Grace,
+			Overage:   info.Overage,
+			Boost:     boost,
+			ResetsAt:  &day.end,
+		}
+		if boost > 0 && boostTTL > 0 {
+			expiresAt := now.Add(boostTTL)
+			usage.BoostExpiresAt = &expiresAt
+		}
+		monthly, err := s.checkMonthlyUsage(ctx, access, userID, action, now)
+		if err != nil {
+			return nil, access, err
+		}
+		if monthly != nil {
+			usage.Monthly = &MonthlyQuota{UsageInfo: *monthly, ResetsAt: access.monthlyPeriod(now).end}
+		}
+		quota.Usage = append(quota.Usage, usage)
+	}
+	quota.Features = s.featureUsages(ctx, access, userID)
+	return quota, access, nil
+}
+
+// currentUsageKeys are the keys of action's counters for the current day
+// and, if the plan caps it, month
+func (s *Service) currentUsageKeys(access access, userID, action string) []string {
+	now := time.Now()
+	keys := []string{usageKey(userID, action, dailyPeriod(now, s.usageLocation(access)).end)}
+	if _, ok := access.monthlyLimit(); ok {
+		keys = append(keys, monthlyUsageKey(userID, action, access.monthlyPeriod(now).end))
+	}
+	return keys
+}
+
+// boost returns the active quota boost for action and how long it has left;
+// Redis errors count as no boost
+func (s *Service) boost(ctx context.Context, userID, action string) (int, time.Duration) {
+	key := boostKey(userID, action)
+	value, err := s.cache.Get(ctx, key)
+	if err != nil {
+		return 0, 0
+	}
+	amount, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, 0
+	}
+	ttl, err := s.cache.TTL(ctx, key)
+	if err != nil {
+		return amount, 0
+	}
+	return amount, ttl
+}
+
+func (s *Service) addBoost(ctx context.Context, userID, action string, amount int, ttl time.Duration) error {
+	key := boostKey(userID, action)
+	remaining, err := s.cache.TTL(ctx, key)
+	if err != nil {
+		return err
+	}
+	if _, err := s.cache.IncrBy(ctx, key, int64(amount)); err != nil {
+		return err
+	}
+	// Keep the later expiry of the existing and the new boost
+	if remaining > ttl {
+		ttl = remaining
+	}
+	_, err = s.cache.Expire(ctx, key, ttl)
+	return err
+}
+
+func usageKey(userID, action string, end time.Time) string {
+	return fmt.Sprintf("usage:%s:%s:%d", userID, action, end.Unix())
+}
+
+func boostKey(userID, action string) string {
+	return fmt.Sprintf("quota_boost:%s:%s", userID, action)
+}
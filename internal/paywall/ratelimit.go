@@ -0,0 +1,75 @@
+package paywall
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rateLimitWindow is the fixed window paywall rate limits count in
+const rateLimitWindow = time.Minute
+
+// rateLimitScript counts a request unless the window's limit is already
+// reached, in one step so concurrent requests can't both take the last
+// slot. KEYS[1] is the counter, ARGV[1] the limit and ARGV[2] the window in
+// milliseconds. It returns 1 when the request is allowed.
+var rateLimitScript = cache.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if current >= tonumber(ARGV[1]) then
+	return 0
+end
+current = redis.call('INCR', KEYS[1])
+if current == 1 or redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// checkRateLimit counts a paywall request against the user's per-minute
+// limit for action. It fails open when Redis is unavailable.
+func (s *Service) checkRateLimit(ctx context.Context, userID, action string) bool {
+	limit := s.rateLimit(ctx, userID, action)
+	allowed, err := s.cache.RunScript(ctx, rateLimitScript, []string{rateLimitKey(userID, action)},
+		limit, rateLimitWindow.Milliseconds())
+	if err != nil {
+		logrus.Warnf("Paywall rate limit check failed for user %s: %v", userID, err)
+		return true
+	}
+	return allowed == int64(1)
+}
+
+// rateLimit is the per-minute limit for action: the user's plan's
+// paywall_<action>_per_minute feature when set, else the configured one
+func (s *Service) rateLimit(ctx context.Context, userID, action string) int {
+	entitlement, err := s.subscriptionSvc.GetCachedEntitlement(ctx, userID)
+	if err != nil {
+		logrus.Warnf("Failed to get entitlement for paywall rate limit of user %s: %v", userID, err)
+	}
+	if entitlement != nil {
+		// Features decoded from JSON hold numbers as float64
+		if limit, ok := entitlement.Features[rateLimitFeature(action)].(float64); ok && limit > 0 {
+			return int(limit)
+		}
+	}
+	return configuredRateLimit(s.rateLimits, action)
+}
+
+func configuredRateLimit(cfg config.PaywallRateLimitConfig, action string) int {
+	if limit, ok := cfg.Actions[action]; ok {
+		return limit
+	}
+	return cfg.PerMinute
+}
+
+func rateLimitFeature(action string) string {
+	return fmt.Sprintf("paywall_%s_per_minute", action)
+}
+
+func rateLimitKey(userID, action string) string {
+	return fmt.Sprintf("rate_limit:%s:%s", userID, action)
+}
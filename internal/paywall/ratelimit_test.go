@@ -0,0 +1,21 @@
+package paywall
+
+import (
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfiguredRateLimit(t *testing.T) {
+	cfg := config.PaywallRateLimitConfig{PerMinute: 10, Actions: map[string]int{"view": 30, "share": 5}}
+
+	assert.Equal(t, 30, configuredRateLimit(cfg, "view"))
+	assert.Equal(t, 5, configuredRateLimit(cfg, "share"))
+	assert.Equal(t, 10, configuredRateLimit(cfg, "download"))
+}
+
+func TestRateLimitFeature(t *testing.T) {
+	assert.Equal(t, "paywall_download_per_minute", rateLimitFeature("download"))
+}
@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
 	"time"
 
 	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/featureflag"
+	"scalable-paywall/internal/middleware"
 	"scalable-paywall/internal/subscription"
 	"scalable-paywall/internal/telemetry"
+	"scalable-paywall/internal/user"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -19,6 +22,15 @@ import (
 type Service struct {
 	cache           *cache.RedisClient
 	subscriptionSvc *subscription.Service
+	flags           *featureflag.Service
+	events          *EventRecorder
+	abuse           *AbuseDetector
+	rateLimits      config.PaywallRateLimitConfig
+	usage           config.PaywallUsageConfig
+	stream          config.PaywallStreamConfig
+	leases          config.PaywallLeaseConfig
+	degradedPolicy  string
+	features        []config.FeatureConfig
 }
 
 type PaywallCheckRequest struct {
@@ -27,8 +39,11 @@ type PaywallCheckRequest struct {
 	PlanID    string `json:"plan_id" binding:"required"`
 }
 
+// PaywallCheckResponse reports Limited when access comes from the free
+// plan, whose entitlements are capped.
 type PaywallCheckResponse struct {
 	HasAccess bool      `json:"has_access"`
+	Limited   bool      `json:"limited,omitempty"`
 	Reason    string    `json:"reason,omitempty"`
 	ExpiresAt time.Time `json:"expires_at,omitempty"`
 }
@@ -37,25 +52,56 @@ type PaywallEnforceRequest struct {
 	UserID    string `json:"user_id" binding:"required"`
 	ContentID string `json:"content_id" binding:"required"`
 	Action    string `json:"action" binding:"required"` // "view", "download", "share"
+	// DeviceID is the client's device fingerprint, for abuse detection
+	DeviceID string `json:"device_id"`
 }
 
+// PaywallEnforceResponse reports the action's daily Usage and, when the
+// plan caps it per month, its MonthlyUsage. AbuseScore, from 0 to 100, is
+// how likely the user is rotating accounts or IPs around metered limits.
+// Degraded is set when Redis was down and the action went uncounted.
 type PaywallEnforceResponse struct {
-	Allowed   bool      `json:"allowed"`
-	Reason    string    `json:"reason,omitempty"`
-	ExpiresAt time.Time `json:"expires_at,omitempty"`
-	Usage     UsageInfo `json:"usage,omitempty"`
+	Allowed      bool       `json:"allowed"`
+	Limited      bool       `json:"limited,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	ExpiresAt    time.Time  `json:"expires_at,omitempty"`
+	Usage        UsageInfo  `json:"usage,omitempty"`
+	MonthlyUsage *UsageInfo `json:"monthly_usage,omitempty"`
+	AbuseScore   int        `json:"abuse_score,omitempty"`
+	Degraded     bool       `json:"degraded,omitempty"`
 }
 
+// UsageInfo is one usage counter. Limit includes Rollover, the part of the
+// previous period's limit left unused that the plan carries over. Grace is
+// how many uses past Limit are still allowed before usage is blocked, and
+// Overage how many of them have been used.
 type UsageInfo struct {
 	Current   int `json:"current"`
 	Limit     int `json:"limit"`
 	Remaining int `json:"remaining"`
+	Rollover  int `json:"rollover,omitempty"`
+	Grace     int `json:"grace,omitempty"`
+	Overage   int `json:"overage,omitempty"`
 }
 
-func NewService(cache *cache.RedisClient, subscriptionSvc *subscription.Service) *Service {
+// exhausted reports whether the counter has used up its limit and grace
+func (u UsageInfo) exhausted() bool {
+	return u.Current >= u.Limit+u.Grace
+}
+
+func NewService(cache *cache.RedisClient, subscriptionSvc *subscription.Service, flags *featureflag.Service, events *EventRecorder, abuse *AbuseDetector, rateLimits config.PaywallRateLimitConfig, usage config.PaywallUsageConfig, stream config.PaywallStreamConfig, leases config.PaywallLeaseConfig, degradedPolicy string, catalog []config.FeatureConfig) *Service {
 	return &Service{
 		cache:           cache,
 		subscriptionSvc: subscriptionSvc,
+		flags:           flags,
+		events:          events,
+		abuse:           abuse,
+		rateLimits:      rateLimits,
+		usage:           usage,
+		stream:          stream,
+		leases:          leases,
+		degradedPolicy:  degradedPolicy,
+		features:        meteredFeatures(catalog),
 	}
 }
 
@@ -67,39 +113,67 @@ func (s *Service) CheckAccess(c *gin.Context) {
 		return
 	}
 
-	// Try cache first
-	cacheKey := fmt.Sprintf("paywall:access:%s:%s:%s", req.UserID, req.ContentID, req.PlanID)
-	cached, err := s.getCachedAccess(c.Request.Context(), cacheKey)
-	if err == nil && cached != nil {
-		c.JSON(http.StatusOK, cached)
-		telemetry.RecordPaywallCheck("cache_hit")
+	response, result, err := s.checkAccess(c.Request.Context(), req)
+	if err != nil {
+		logrus.Errorf("Failed to check subscription access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaywallCheck(result)
 		return
 	}
+	s.recordCheck(req, response)
+
+	c.JSON(http.StatusOK, response)
+	telemetry.RecordPaywallCheck(result)
+}
+
+// checkAccess decides req through the access cache, or from the database
+// alone while Redis is degraded. It also returns the outcome recorded in
+// the paywall check metrics.
+func (s *Service) checkAccess(ctx context.Context, req PaywallCheckRequest) (*PaywallCheckResponse, string, error) {
+	degraded := s.cache.Degraded()
+
+	// Blocked users are denied before the cache, which may hold a result
+	// from before they were suspended
+	if !degraded && s.userBlocked(ctx, req.UserID) {
+		return &PaywallCheckResponse{Reason: reasonAccountBlocked}, "account_blocked", nil
+	}
+
+	// Try cache first
+	cacheKey := s.cache.Key(fmt.Sprintf("paywall:access:%s:%s:%s", req.UserID, req.ContentID, req.PlanID))
+	if !degraded {
+		cached, err := s.getCachedAccess(ctx, cacheKey)
+		if err == nil && cached != nil {
+			return cached, "cache_hit", nil
+		}
+	}
 
 	// Check subscription status
-	hasAccess, reason, expiresAt, err := s.checkSubscriptionAccess(c.Request.Context(), req.UserID, req.PlanID)
+	access, err := s.checkSubscriptionAccess(ctx, req.UserID, req.PlanID)
 	if err != nil {
-		logrus.Errorf("Failed to check subscription access: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-		telemetry.RecordPaywallCheck("error")
-		return
+		return nil, "error", err
 	}
+	hasAccess, reason := access.granted, access.reason
 
 	response := &PaywallCheckResponse{
 		HasAccess: hasAccess,
+		Limited:   access.free(),
 		Reason:    reason,
-		ExpiresAt: expiresAt,
+		ExpiresAt: access.expiresAt,
 	}
 
 	// Cache the result for 5 minutes
-	s.cacheAccessResult(c.Request.Context(), cacheKey, response)
+	if !degraded {
+		s.cacheAccessResult(ctx, cacheKey, response)
+	}
 
-	c.JSON(http.StatusOK, response)
-	if hasAccess {
-		telemetry.RecordPaywallCheck("access_granted")
-	} else {
-		telemetry.RecordPaywallCheck("access_denied")
+	if hasAccess && reason == reasonGracePeriod {
+		return response, "grace_access", nil
+	} else if hasAccess && response.Limited {
+		return response, "free_access", nil
+	} else if hasAccess {
+		return response, "access_granted", nil
 	}
+	return response, "access_denied", nil
 }
 
 func (s *Service) EnforcePaywall(c *gin.Context) {
@@ -109,145 +183,233 @@ func (s *Service) EnforcePaywall(c *gin.Context) {
 		return
 	}
 
+	// Rate limits and usage are counted in Redis; while it is degraded the
+	// policy decides whether enforcement goes on without them
+	degraded := s.cache.Degraded()
+	if degraded && !s.failsOpen() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Service temporarily unavailable", "reason": reasonDegraded})
+		return
+	}
+
 	// Check rate limiting
-	if !s.checkRateLimit(c.Request.Context(), req.UserID, req.Action) {
+	if !degraded && !s.checkRateLimit(c.Request.Context(), req.UserID, req.Action) {
 		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
 		return
 	}
 
 	// Check subscription access
-	hasAccess, reason, expiresAt, err := s.checkSubscriptionAccess(c.Request.Context(), req.UserID, "")
+	access, err := s.checkSubscriptionAccess(c.Request.Context(), req.UserID, "")
 	if err != nil {
 		logrus.Errorf("Failed to check subscription access: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	if !hasAccess {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied", "reason": reason})
+	if !access.granted {
+		s.recordEnforce(req, access, false, access.reason)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied", "reason": access.reason})
 		return
 	}
 
-	// Check usage limits
-	usage, err := s.checkUsageLimits(c.Request.Context(), req.UserID, req.Action)
-	if err != nil {
-		logrus.Errorf("Failed to check usage limits: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-		return
+	// Usage metering is rolled out behind the metered_paywall flag; free
+	// plans are always metered against their daily and monthly caps
+	var usage UsageInfo
+	var monthly *UsageInfo
+	var abuseScore int
+	metered := access.free() || s.flags.Enabled(c.Request.Context(), featureflag.MeteredPaywall, access.tenantID(), req.UserID)
+	if metered && !degraded {
+		// Daily counters reset at the customer's midnight
+		now := time.Now()
+		day := dailyPeriod(now, s.usageLocation(access))
+
+		// Check usage limits
+		usage, err = s.checkUsageLimits(c.Request.Context(), access, req.UserID, req.Action, day)
+		if err == nil {
+			monthly, err = s.checkMonthlyUsage(c.Request.Context(), access, req.UserID, req.Action, now)
+		}
+		if err != nil {
+			logrus.Errorf("Failed to check usage limits: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		abuseScore = s.abuse.Assess(c.Request.Context(), req.UserID, req.DeviceID, c.ClientIP())
+
+		if access.free() && (usage.exhausted() || (monthly != nil && monthly.exhausted())) {
+			s.recordEnforce(req, access, false, reasonFreeLimitReached)
+			middleware.SetUsageHeaders(c, usage.Limit, 0, day.end)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied", "reason": reasonFreeLimitReached, "usage": usage, "monthly_usage": monthly, "abuse_score": abuseScore})
+			return
+		}
+
+		// Increment usage
+		if err := s.incrementUsage(c.Request.Context(), access, req.UserID, req.Action, day, now); err != nil {
+			logrus.Errorf("Failed to increment usage: %v", err)
+			// Don't fail the request, just log the error
+		}
+		middleware.SetUsageHeaders(c, usage.Limit, usage.Remaining-1, day.end)
 	}
 
-	// Increment usage
-	if err := s.incrementUsage(c.Request.Context(), req.UserID, req.Action); err != nil {
-		logrus.Errorf("Failed to increment usage: %v", err)
-		// Don't fail the request, just log the error
+	if err := s.subscriptionSvc.RecordUsage(c.Request.Context(), req.UserID, access.subscriptionID(), req.Action, req.ContentID); err != nil {
+		logrus.Errorf("Failed to record usage: %v", err)
 	}
+	s.recordEnforce(req, access, true, access.reason)
 
 	response := &PaywallEnforceResponse{
-		Allowed:   true,
-		ExpiresAt: expiresAt,
-		Usage:     usage,
+		Allowed:      true,
+		Limited:      access.free(),
+		ExpiresAt:    access.expiresAt,
+		Usage:        usage,
+		MonthlyUsage: monthly,
+		AbuseScore:   abuseScore,
+		Degraded:     degraded,
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// Helper methods
-func (s *Service) checkSubscriptionAccess(ctx context.Context, userID, planID string) (bool, string, time.Time, error) {
-	// Get active subscription for user
-	sub, err := s.subscriptionSvc.GetActiveSubscriptionByUserID(ctx, userID)
-	if err != nil {
-		return false, "No active subscription found", time.Time{}, nil
-	}
+// reasonGracePeriod marks access granted only because the subscription is
+// within its plan's grace period
+const reasonGracePeriod = "Subscription is past due, within grace period"
 
-	// Check if subscription is active
-	if sub.Status != "active" {
-		return false, "Subscription is not active", time.Time{}, nil
-	}
+// reasonFreePlan marks access granted by the free plan, whose entitlements
+// are limited
+const reasonFreePlan = "Free plan, limited access"
 
-	// Check if subscription has expired
-	if time.Now().After(sub.EndDate) {
-		return false, "Subscription has expired", time.Time{}, nil
-	}
+const reasonFreeLimitReached = "Free plan usage limit reached"
 
-	// If planID is specified, check if it matches
-	if planID != "" && sub.PlanID != planID {
-		return false, "Plan mismatch", time.Time{}, nil
-	}
+// reasonAccountBlocked denies a suspended or banned user whatever their
+// subscription grants
+const reasonAccountBlocked = "Account suspended"
+
+// reasonDegraded refuses enforcement while Redis is degraded under the
+// fail_closed policy
+const reasonDegraded = "Usage metering unavailable"
+
+// degradedFailOpen is the paywall.degraded_policy under which enforcement
+// goes on uncounted while Redis is degraded
+const degradedFailOpen = "fail_open"
 
-	return true, "Valid subscription", sub.EndDate, nil
+func (s *Service) failsOpen() bool {
+	return s.degradedPolicy == degradedFailOpen
 }
 
-func (s *Service) checkRateLimit(ctx context.Context, userID, action string) bool {
-	key := fmt.Sprintf("rate_limit:%s:%s", userID, action)
+// defaultDailyLimit applies when a plan sets no max_usage_per_day
+const defaultDailyLimit = 100
 
-	// Get current count
-	current, err := s.cache.Get(ctx, key)
-	if err != nil {
-		// Key doesn't exist, start counting
-		s.cache.Set(ctx, key, "1", time.Minute)
-		return true
-	}
+// access is the outcome of an entitlement check. entitlement is nil when
+// the user has no subscription granting access.
+type access struct {
+	granted     bool
+	reason      string
+	expiresAt   time.Time
+	entitlement *subscription.Entitlement
+}
 
-	count, err := strconv.Atoi(current)
-	if err != nil {
-		// Invalid count, reset
-		s.cache.Set(ctx, key, "1", time.Minute)
-		return true
+func (a access) free() bool {
+	return a.entitlement != nil && a.entitlement.PlanType == "free"
+}
+
+func (a access) subscriptionID() string {
+	if a.entitlement == nil {
+		return ""
 	}
+	return a.entitlement.Subscription.ID
+}
 
-	// Check if limit exceeded (10 requests per minute per action)
-	if count >= 10 {
-		return false
+func (a access) planID() string {
+	if a.entitlement == nil {
+		return ""
 	}
+	return a.entitlement.Subscription.PlanID
+}
 
-	// Increment count
-	s.cache.Incr(ctx, key)
-	return true
+// tenantID is the tenant the user was created under, "" for none or without
+// an entitlement
+func (a access) tenantID() string {
+	if a.entitlement == nil {
+		return ""
+	}
+	return a.entitlement.UserTenant
 }
 
-func (s *Service) checkUsageLimits(ctx context.Context, userID, action string) (UsageInfo, error) {
-	key := fmt.Sprintf("usage:%s:%s", userID, action)
+func (a access) dailyLimit() int {
+	if a.entitlement != nil && a.entitlement.MaxUsagePerDay != nil {
+		return *a.entitlement.MaxUsagePerDay
+	}
+	return defaultDailyLimit
+}
 
-	// Get current usage
-	current, err := s.cache.Get(ctx, key)
+// Helper methods
+func (s *Service) checkSubscriptionAccess(ctx context.Context, userID, planID string) (access, error) {
+	// Get the subscription currently granting access, grace period included
+	entitlement, err := s.subscriptionSvc.GetEntitlementByUserID(ctx, userID)
 	if err != nil {
-		// No usage recorded yet
-		return UsageInfo{Current: 0, Limit: 100, Remaining: 100}, nil
+		return access{reason: "No active subscription found"}, nil
 	}
+	sub := entitlement.Subscription
 
-	count, err := strconv.Atoi(current)
-	if err != nil {
-		return UsageInfo{Current: 0, Limit: 100, Remaining: 100}, nil
+	if user.Status(entitlement.UserStatus).Blocked() {
+		return access{reason: reasonAccountBlocked}, nil
 	}
 
-	limit := 100 // Default limit per day
-	remaining := limit - count
-	if remaining < 0 {
-		remaining = 0
+	// If planID is specified, check if it matches
+	if planID != "" && sub.PlanID != planID {
+		return access{reason: "Plan mismatch", entitlement: entitlement}, nil
 	}
 
-	return UsageInfo{
-		Current:   count,
-		Limit:     limit,
-		Remaining: remaining,
-	}, nil
-}
+	if entitlement.PlanType == "free" {
+		return access{granted: true, reason: reasonFreePlan, entitlement: entitlement}, nil
+	}
 
-func (s *Service) incrementUsage(ctx context.Context, userID, action string) error {
-	key := fmt.Sprintf("usage:%s:%s", userID, action)
+	if entitlement.InGracePeriod(time.Now()) {
+		return access{granted: true, reason: reasonGracePeriod, expiresAt: entitlement.GraceUntil, entitlement: entitlement}, nil
+	}
 
-	// Increment usage counter
-	_, err := s.cache.Incr(ctx, key)
+	return access{granted: true, reason: "Valid subscription", expiresAt: sub.EndDate, entitlement: entitlement}, nil
+}
+
+// userBlocked reports whether the user is marked suspended or banned in the
+// cache. It fails open; checkSubscriptionAccess denies them from the
+// database too.
+func (s *Service) userBlocked(ctx context.Context, userID string) bool {
+	n, err := s.cache.Exists(ctx, user.BlockedKey(userID))
 	if err != nil {
+		logrus.Warnf("Failed to check whether user %s is blocked: %v", userID, err)
+		return false
+	}
+	return n > 0
+}
+
+// checkUsageLimits reads the daily counter for action in day, whose limit
+// includes any quota boost
+func (s *Service) checkUsageLimits(ctx context.Context, access access, userID, action string, day usagePeriod) (UsageInfo, error) {
+	boost, _ := s.boost(ctx, userID, action)
+	keyOf := func(end time.Time) string { return usageKey(userID, action, end) }
+	return s.readUsage(ctx, access, keyOf, day, access.dailyLimit(), boost), nil
+}
+
+// incrementUsage counts one use of action against the daily counter for
+// day, and the monthly one if the plan caps it
+func (s *Service) incrementUsage(ctx context.Context, access access, userID, action string, day usagePeriod, now time.Time) error {
+	if err := s.incrementCounter(ctx, usageKey(userID, action, day.end), day.ttl(now)); err != nil {
 		return err
 	}
+	if _, ok := access.monthlyLimit(); ok {
+		month := access.monthlyPeriod(now)
+		return s.incrementCounter(ctx, monthlyUsageKey(userID, action, month.end), month.ttl(now))
+	}
+	return nil
+}
 
-	// Set expiration to end of day
-	now := time.Now()
-	endOfDay := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 59, 0, now.Location())
-	ttl := endOfDay.Sub(now)
+func (s *Service) incrementCounter(ctx context.Context, key string, ttl time.Duration) error {
+	return s.incrementCounterBy(ctx, key, 1, ttl)
+}
 
-	_, err = s.cache.Expire(ctx, key, ttl)
+func (s *Service) incrementCounterBy(ctx context.Context, key string, amount int, ttl time.Duration) error {
+	if _, err := s.cache.IncrBy(ctx, key, int64(amount)); err != nil {
+		return err
+	}
+	_, err := s.cache.Expire(ctx, key, ttl)
 	return err
 }
 
@@ -266,6 +428,7 @@ func (s *Service) cacheAccessResult(ctx context.Context, key string, result *Pay
 
 func (s *Service) getCachedAccess(ctx context.Context, key string) (*PaywallCheckResponse, error) {
 	data, err := s.cache.Get(ctx, key)
+	telemetry.RecordCacheLookup("paywall", err == nil)
 	if err != nil {
 		return nil, err
 	}
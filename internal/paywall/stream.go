@@ -0,0 +1,126 @@
+package paywall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/subscription"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// streamRetry is how long clients wait before reconnecting a closed stream
+const streamRetry = time.Second
+
+// EntitlementEvent is the user's access after Change, or when the stream
+// opens, when Change is nil, with their usage of metered features
+type EntitlementEvent struct {
+	UserID    string                          `json:"user_id"`
+	HasAccess bool                            `json:"has_access"`
+	Limited   bool                            `json:"limited,omitempty"`
+	Reason    string                          `json:"reason,omitempty"`
+	PlanID    string                          `json:"plan_id,omitempty"`
+	ExpiresAt time.Time                       `json:"expires_at,omitempty"`
+	Features  []FeatureUsage                  `json:"features,omitempty"`
+	Change    *subscription.EntitlementChange `json:"change,omitempty"`
+}
+
+// StreamEntitlements sends the user's access as server-sent events: once
+// when the stream opens and again whenever their subscription changes, so a
+// page can unlock content as soon as a payment goes through. The stream
+// lasts until the request times out (see server.route_timeouts); clients
+// reconnect and get their current access again, so no change is missed.
+func (s *Service) StreamEntitlements(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+		return
+	}
+
+	// Subscribe before reading the current access so a change made in
+	// between is still delivered
+	ctx := c.Request.Context()
+	pubsub := s.cache.Subscribe(ctx, subscription.ChangesChannel(userID))
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		logrus.Errorf("Failed to subscribe to entitlement changes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	current, err := s.entitlementEvent(ctx, userID, nil)
+	if err != nil {
+		logrus.Errorf("Failed to check subscription access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// The server's write timeout would cut the stream short of the request's
+	// own deadline; a zero deadline (no request timeout) removes it
+	deadline, _ := ctx.Deadline()
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(deadline); err != nil {
+		logrus.Debugf("Failed to extend write deadline of entitlement stream: %v", err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", streamRetry.Milliseconds())
+	c.SSEvent("entitlement", current)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(time.Duration(s.stream.Heartbeat) * time.Second)
+	defer heartbeat.Stop()
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			var change subscription.EntitlementChange
+			if err := json.Unmarshal([]byte(message.Payload), &change); err != nil {
+				logrus.Warnf("Ignoring malformed entitlement change: %v", err)
+				continue
+			}
+			event, err := s.entitlementEvent(ctx, userID, &change)
+			if err != nil {
+				logrus.Errorf("Failed to check subscription access: %v", err)
+				return
+			}
+			c.SSEvent("entitlement", event)
+		}
+		c.Writer.Flush()
+	}
+}
+
+// entitlementEvent checks the user's access as CheckAccess does, without
+// its cache, which may predate the change
+func (s *Service) entitlementEvent(ctx context.Context, userID string, change *subscription.EntitlementChange) (*EntitlementEvent, error) {
+	event := &EntitlementEvent{UserID: userID, Change: change}
+	if s.userBlocked(ctx, userID) {
+		event.Reason = reasonAccountBlocked
+		return event, nil
+	}
+
+	access, err := s.checkSubscriptionAccess(ctx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	event.HasAccess = access.granted
+	event.Limited = access.free()
+	event.Reason = access.reason
+	event.PlanID = access.planID()
+	event.ExpiresAt = access.expiresAt
+	event.Features = s.featureUsages(ctx, access, userID)
+	return event, nil
+}
@@ -0,0 +1,234 @@
+package paywall
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/db"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// teaserTTL is how long a content's wall is cached; edits drop it at once
+const teaserTTL = 5 * time.Minute
+
+// Teasers keeps the upsell walls frontends render in place of locked
+// content. The paywall grants any active subscription whatever the wall
+// says; RequiredPlanIDs only chooses the plans it offers.
+type Teasers struct {
+	db         *db.Connection
+	cache      *cache.RedisClient
+	upgradeURL string
+}
+
+// NewTeasers builds upgrade links from upgradeURL, in which {plan_id} and
+// {content_id} are replaced by the offered plan and the locked content
+func NewTeasers(db *db.Connection, cache *cache.RedisClient, upgradeURL string) *Teasers {
+	return &Teasers{db: db, cache: cache, upgradeURL: upgradeURL}
+}
+
+type SetTeaserRequest struct {
+	PreviewPercent  int      `json:"preview_percent" binding:"min=0,max=100"`
+	RequiredPlanIDs []string `json:"required_plan_ids" binding:"max=20,dive,uuid"`
+	CTAText         *string  `json:"cta_text" binding:"omitempty,max=255"`
+}
+
+// Teaser is the wall for one piece of content. Plans are ordered as on the
+// pricing page; UpgradeURL is the first one's.
+type Teaser struct {
+	ContentID      string       `json:"content_id"`
+	PreviewPercent int          `json:"preview_percent"`
+	CTAText        *string      `json:"cta_text,omitempty"`
+	Plans          []TeaserPlan `json:"plans"`
+	UpgradeURL     string       `json:"upgrade_url,omitempty"`
+}
+
+type TeaserPlan struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	Price        decimal.Decimal `json:"price"`
+	Currency     string          `json:"currency"`
+	BillingCycle string          `json:"billing_cycle"`
+	Badge        *string         `json:"badge,omitempty"`
+	UpgradeURL   string          `json:"upgrade_url,omitempty"`
+}
+
+// GetTeaser returns the wall for a locked piece of content. Content without
+// a teaser policy previews nothing and offers every active paid plan.
+func (t *Teasers) GetTeaser(c *gin.Context) {
+	contentID := c.Param("content_id")
+	ctx := c.Request.Context()
+
+	if data, err := t.cache.Get(ctx, t.cache.Key(teaserKey(contentID))); err == nil {
+		var cached Teaser
+		if err := json.Unmarshal([]byte(data), &cached); err == nil {
+			c.JSON(http.StatusOK, cached)
+			return
+		}
+	}
+
+	teaser, err := t.teaser(ctx, contentID)
+	if err != nil {
+		logrus.Errorf("Failed to get teaser for %s: %v", contentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if data, err := json.Marshal(teaser); err == nil {
+		if err := t.cache.Set(ctx, t.cache.Key(teaserKey(contentID)), string(data), teaserTTL); err != nil {
+			logrus.Warnf("Failed to cache teaser for %s: %v", contentID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, teaser)
+}
+
+// SetTeaser creates or replaces a content's teaser policy
+func (t *Teasers) SetTeaser(c *gin.Context) {
+	var req SetTeaserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.RequiredPlanIDs == nil {
+		req.RequiredPlanIDs = []string{}
+	}
+
+	contentID := c.Param("content_id")
+	ctx := c.Request.Context()
+	planIDs, _ := json.Marshal(req.RequiredPlanIDs)
+
+	// Only active paid plans can be offered
+	var offerable int
+	err := t.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM plans
+		WHERE id::text IN (SELECT jsonb_array_elements_text($1::jsonb)) AND is_active AND plan_type = 'paid'`,
+		string(planIDs)).Scan(&offerable)
+	if err != nil {
+		logrus.Errorf("Failed to check teaser plans: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if offerable != len(planIDSet(req.RequiredPlanIDs)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "required_plan_ids must be active paid plans"})
+		return
+	}
+
+	_, err = t.db.ExecContext(ctx, `
+		INSERT INTO content_teasers (content_id, preview_percent, required_plan_ids, cta_text)
+		VALUES ($1, $2, $3::jsonb, $4)
+		ON CONFLICT (content_id) DO UPDATE SET preview_percent = EXCLUDED.preview_percent,
+			required_plan_ids = EXCLUDED.required_plan_ids, cta_text = EXCLUDED.cta_text, updated_at = NOW()`,
+		contentID, req.PreviewPercent, string(planIDs), req.CTAText)
+	if err != nil {
+		logrus.Errorf("Failed to save teaser for %s: %v", contentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	t.invalidate(ctx, contentID)
+
+	teaser, err := t.teaser(ctx, contentID)
+	if err != nil {
+		logrus.Errorf("Failed to get teaser for %s: %v", contentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, teaser)
+}
+
+// DeleteTeaser drops a content's teaser policy, returning it to the default
+func (t *Teasers) DeleteTeaser(c *gin.Context) {
+	contentID := c.Param("content_id")
+	ctx := c.Request.Context()
+
+	result, err := t.db.ExecContext(ctx, `DELETE FROM content_teasers WHERE content_id = $1`, contentID)
+	if err != nil {
+		logrus.Errorf("Failed to delete teaser for %s: %v", contentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Teaser not found"})
+		return
+	}
+	t.invalidate(ctx, contentID)
+
+	c.Status(http.StatusNoContent)
+}
+
+func (t *Teasers) teaser(ctx context.Context, contentID string) (*Teaser, error) {
+	teaser := &Teaser{ContentID: contentID, Plans: []TeaserPlan{}}
+	planIDs := "[]"
+	err := t.db.QueryRowContext(ctx, `
+		SELECT preview_percent, required_plan_ids, cta_text FROM content_teasers WHERE content_id = $1`,
+		contentID).Scan(&teaser.PreviewPercent, &planIDs, &teaser.CTAText)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	// Plans offered by the policy that have since been retired are left out
+	rows, err := t.db.QueryContext(ctx, `
+		SELECT id, name, price, currency, billing_cycle, badge FROM plans
+		WHERE is_active AND plan_type = 'paid'
+			AND (jsonb_array_length($1::jsonb) = 0 OR id::text IN (SELECT jsonb_array_elements_text($1::jsonb)))
+		ORDER BY display_order, price, name`, planIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var plan TeaserPlan
+		if err := rows.Scan(&plan.ID, &plan.Name, &plan.Price, &plan.Currency, &plan.BillingCycle, &plan.Badge); err != nil {
+			return nil, err
+		}
+		plan.UpgradeURL = t.upgradeLink(plan.ID, contentID)
+		teaser.Plans = append(teaser.Plans, plan)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(teaser.Plans) > 0 {
+		teaser.UpgradeURL = teaser.Plans[0].UpgradeURL
+	}
+	return teaser, nil
+}
+
+// upgradeLink is the checkout deep link for planID, prefilled from the
+// configured template; empty when none is configured
+func (t *Teasers) upgradeLink(planID, contentID string) string {
+	if t.upgradeURL == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{plan_id}", url.QueryEscape(planID),
+		"{content_id}", url.QueryEscape(contentID),
+	).Replace(t.upgradeURL)
+}
+
+func (t *Teasers) invalidate(ctx context.Context, contentID string) {
+	if err := t.cache.Del(ctx, t.cache.Key(teaserKey(contentID))); err != nil {
+		logrus.Warnf("Failed to invalidate teaser for %s: %v", contentID, err)
+	}
+}
+
+func teaserKey(contentID string) string {
+	return fmt.Sprintf("paywall:teaser:%s", contentID)
+}
+
+func planIDSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[strings.ToLower(v)] = true
+	}
+	return set
+}
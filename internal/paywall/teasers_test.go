@@ -0,0 +1,20 @@
+package paywall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpgradeLink(t *testing.T) {
+	teasers := &Teasers{upgradeURL: "https://example.com/checkout?plan={plan_id}&content={content_id}"}
+	assert.Equal(t, "https://example.com/checkout?plan=p1&content=news%2F2024+election",
+		teasers.upgradeLink("p1", "news/2024 election"))
+
+	assert.Empty(t, (&Teasers{}).upgradeLink("p1", "article-1"))
+}
+
+func TestPlanIDSet(t *testing.T) {
+	set := planIDSet([]string{"6F9619FF-8B86-D011-B42D-00C04FC964FF", "6f9619ff-8b86-d011-b42d-00c04fc964ff"})
+	assert.Len(t, set, 1)
+}
@@ -0,0 +1,111 @@
+// Package pci keeps raw card data out of the API. Payment methods must be
+// gateway tokens or payment method IDs, never card numbers, and anything
+// that looks like a card number is masked before it reaches the logs.
+package pci
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+)
+
+// TokenTag is the binding tag for fields that must hold a gateway token or
+// payment method ID, e.g. pm_1NqX..., tok_visa or card_1Mz...
+const TokenTag = "payment_token"
+
+// tokenPattern is a gateway object ID: a lowercase prefix, an underscore
+// and an opaque identifier
+var tokenPattern = regexp.MustCompile(`^[a-z]{2,8}_[A-Za-z0-9_]{3,250}$`)
+
+// digitRun is any run of digits, found anywhere, for validation
+var digitRun = regexp.MustCompile(`\d+`)
+
+// loggedPAN is a card number as it may appear in log text: 13 to 19 digits,
+// optionally grouped by spaces or dashes, standing on its own
+var loggedPAN = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+
+func init() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		RegisterValidation(v)
+	}
+	logrus.AddHook(LogHook{})
+}
+
+// RegisterValidation adds the payment_token tag. Gin's binding validator is
+// registered on import.
+func RegisterValidation(v *validator.Validate) {
+	v.RegisterValidation(TokenTag, func(fl validator.FieldLevel) bool {
+		return IsToken(fl.Field().String())
+	})
+}
+
+// IsToken reports whether s has the shape of a gateway token or payment
+// method ID and carries no card number.
+func IsToken(s string) bool {
+	return tokenPattern.MatchString(s) && !ContainsPAN(s)
+}
+
+// ContainsPAN reports whether s holds a card number: 13 to 19 digits,
+// possibly separated by spaces or dashes, that pass the Luhn check.
+func ContainsPAN(s string) bool {
+	compact := strings.NewReplacer(" ", "", "-", "").Replace(s)
+	for _, run := range digitRun.FindAllString(compact, -1) {
+		if len(run) >= 13 && len(run) <= 19 && luhn(run) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact masks every card number in s but its last four digits.
+func Redact(s string) string {
+	return loggedPAN.ReplaceAllStringFunc(s, func(match string) string {
+		digits := strings.NewReplacer(" ", "", "-", "").Replace(match)
+		if !luhn(digits) {
+			return match
+		}
+		return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+	})
+}
+
+// luhn reports whether digits passes the Luhn checksum card numbers carry
+func luhn(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// LogHook masks card numbers in log messages and string or error fields
+// before they are written. It is added to the standard logger on import.
+type LogHook struct{}
+
+func (LogHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (LogHook) Fire(entry *logrus.Entry) error {
+	entry.Message = Redact(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = Redact(v)
+		case error:
+			if redacted := Redact(v.Error()); redacted != v.Error() {
+				entry.Data[key] = redacted
+			}
+		}
+	}
+	return nil
+}
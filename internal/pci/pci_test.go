@@ -0,0 +1,54 @@
+package pci
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsToken(t *testing.T) {
+	assert.True(t, IsToken("pm_1NqXkR2eZvKYlo2C8bQ9aZxY"))
+	assert.True(t, IsToken("tok_visa"))
+	assert.True(t, IsToken("pm_decline_card_expired"))
+
+	assert.False(t, IsToken("4242424242424242"))
+	assert.False(t, IsToken("4242 4242 4242 4242"))
+	assert.False(t, IsToken("pm_4242424242424242"))
+	assert.False(t, IsToken("credit card"))
+	assert.False(t, IsToken(""))
+}
+
+func TestContainsPAN(t *testing.T) {
+	assert.True(t, ContainsPAN("card 4111-1111-1111-1111 exp 12/30"))
+	assert.True(t, ContainsPAN("378282246310005"))
+	// Fails the Luhn check
+	assert.False(t, ContainsPAN("4242424242424241"))
+	// Too short to be a card number
+	assert.False(t, ContainsPAN("424242424242"))
+}
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "charging ************4242 now", Redact("charging 4242424242424242 now"))
+	assert.Equal(t, "card ************1111", Redact("card 4111 1111 1111 1111"))
+	assert.Equal(t, "order 4242424242424241", Redact("order 4242424242424241"))
+	assert.Equal(t, "pm_1NqXkR2eZvKYlo2C", Redact("pm_1NqXkR2eZvKYlo2C"))
+}
+
+func TestLogHookRedactsMessageAndFields(t *testing.T) {
+	entry := &logrus.Entry{
+		Message: "payment with 4242424242424242 failed",
+		Data: logrus.Fields{
+			"payment_method": "4111111111111111",
+			"error":          errors.New("bad card 5555555555554444"),
+			"attempts":       3,
+		},
+	}
+
+	assert.NoError(t, LogHook{}.Fire(entry))
+	assert.Equal(t, "payment with ************4242 failed", entry.Message)
+	assert.Equal(t, "************1111", entry.Data["payment_method"])
+	assert.Equal(t, "bad card ************4444", entry.Data["error"])
+	assert.Equal(t, 3, entry.Data["attempts"])
+}
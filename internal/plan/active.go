@@ -0,0 +1,118 @@
+package plan
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Active plans are cached stale-while-revalidate: for activePlansFresh a
+// cached list is served as is, and for the rest of activePlansTTL it is
+// still served while one request refreshes it in the background. Only a
+// cold cache makes a request wait for the database.
+const (
+	activePlansKey        = "plans:active"
+	activePlansRefreshKey = "plans:active:refresh"
+	// activePlansGenerationKey is bumped by every plan write so a refresh
+	// that read the database before the write doesn't cache its result
+	activePlansGenerationKey = "plans:active:generation"
+
+	activePlansFresh = 5 * time.Minute
+	activePlansTTL   = 30 * time.Minute
+	// activePlansRefreshTimeout bounds a background refresh, and how long
+	// other requests leave it to the one that started it
+	activePlansRefreshTimeout = 30 * time.Second
+)
+
+// cachedActivePlans is the cache record of the active plans
+type cachedActivePlans struct {
+	Plans      []Plan    `json:"plans"`
+	FreshUntil time.Time `json:"fresh_until"`
+}
+
+// activePlans returns the active plans from the cache, falling back to the
+// database and caching the result. It reports whether the cache answered,
+// fresh or stale.
+func (s *Service) activePlans(ctx context.Context) ([]Plan, bool, error) {
+	data, err := s.cache.Get(ctx, s.cache.Key(activePlansKey))
+	telemetry.RecordCacheLookup("plan", err == nil)
+	if err == nil && data != "" {
+		var cached cachedActivePlans
+		if err := json.Unmarshal([]byte(data), &cached); err == nil {
+			if time.Now().After(cached.FreshUntil) {
+				s.revalidateActivePlans(ctx)
+			}
+			return cached.Plans, true, nil
+		}
+	}
+
+	generation := s.activePlansGeneration(ctx)
+	plans, err := s.getActivePlans(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	s.cacheActivePlans(ctx, plans, generation)
+	return plans, false, nil
+}
+
+// revalidateActivePlans refreshes stale active plans in the background,
+// unless another request already is
+func (s *Service) revalidateActivePlans(ctx context.Context) {
+	started, err := s.cache.SetNX(ctx, s.cache.Key(activePlansRefreshKey), 1, activePlansRefreshTimeout)
+	if err != nil || !started {
+		return
+	}
+
+	// The request's context ends with the request
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), activePlansRefreshTimeout)
+		defer cancel()
+		defer s.cache.Del(ctx, s.cache.Key(activePlansRefreshKey))
+
+		generation := s.activePlansGeneration(ctx)
+		plans, err := s.getActivePlans(ctx)
+		if err != nil {
+			logrus.Errorf("Failed to refresh active plans: %v", err)
+			return
+		}
+		s.cacheActivePlans(ctx, plans, generation)
+	}()
+}
+
+// cacheActivePlans caches plans read at generation, unless a plan has been
+// written since
+func (s *Service) cacheActivePlans(ctx context.Context, plans []Plan, generation string) {
+	if s.activePlansGeneration(ctx) != generation {
+		return
+	}
+
+	data, err := json.Marshal(cachedActivePlans{Plans: plans, FreshUntil: time.Now().Add(activePlansFresh)})
+	if err != nil {
+		logrus.Errorf("Failed to marshal active plans for cache: %v", err)
+		return
+	}
+	if err := s.cache.Set(ctx, s.cache.Key(activePlansKey), string(data), activePlansTTL); err != nil {
+		logrus.Errorf("Failed to cache active plans: %v", err)
+	}
+}
+
+// invalidateActivePlans drops the cached active plans after a plan is
+// created, updated or deleted
+func (s *Service) invalidateActivePlans(ctx context.Context) {
+	if _, err := s.cache.Incr(ctx, s.cache.Key(activePlansGenerationKey)); err != nil {
+		logrus.Warnf("Failed to bump active plans generation: %v", err)
+	}
+	if err := s.cache.Del(ctx, s.cache.Key(activePlansKey)); err != nil {
+		logrus.Warnf("Failed to invalidate active plans cache: %v", err)
+	}
+}
+
+// activePlansGeneration counts plan writes; empty before the first
+func (s *Service) activePlansGeneration(ctx context.Context) string {
+	generation, _ := s.cache.Get(ctx, s.cache.Key(activePlansGenerationKey))
+	return generation
+}
@@ -0,0 +1,162 @@
+package plan
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Feature value types
+const (
+	FeatureBool = "bool"
+	FeatureInt  = "int"
+	FeatureEnum = "enum"
+)
+
+// FeatureDefinition describes a plan feature key: the type its value must
+// have and how it is presented.
+type FeatureDefinition struct {
+	Key         string   `json:"key"`
+	Type        string   `json:"type"`
+	DisplayName string   `json:"display_name"`
+	Description string   `json:"description,omitempty"`
+	Group       string   `json:"group,omitempty"`
+	Values      []string `json:"values,omitempty"`
+}
+
+// Catalog is the set of known plan features, in display order. An empty
+// catalog accepts any features, as before the catalog existed.
+type Catalog struct {
+	definitions []FeatureDefinition
+	byKey       map[string]FeatureDefinition
+}
+
+// NewCatalog builds the catalog from config, which Config.Validate has
+// already checked.
+func NewCatalog(features []config.FeatureConfig) *Catalog {
+	catalog := &Catalog{byKey: make(map[string]FeatureDefinition, len(features))}
+	for _, f := range features {
+		def := FeatureDefinition{
+			Key:         f.Key,
+			Type:        f.Type,
+			DisplayName: f.DisplayName,
+			Description: f.Description,
+			Group:       f.Group,
+			Values:      f.Values,
+		}
+		if def.DisplayName == "" {
+			def.DisplayName = def.Key
+		}
+		catalog.definitions = append(catalog.definitions, def)
+		catalog.byKey[def.Key] = def
+	}
+	return catalog
+}
+
+// Definitions returns the catalog in display order.
+func (c *Catalog) Definitions() []FeatureDefinition {
+	if c == nil {
+		return nil
+	}
+	return c.definitions
+}
+
+func (c *Catalog) empty() bool {
+	return c == nil || len(c.definitions) == 0
+}
+
+// Validate checks every feature against its definition, rejecting keys the
+// catalog does not know and values of the wrong type.
+func (c *Catalog) Validate(features map[string]interface{}) error {
+	if c.empty() {
+		return nil
+	}
+
+	keys := make([]string, 0, len(features))
+	for key := range features {
+		keys = append(keys, key)
+	}
+	// Report the same problem first on every attempt
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		def, ok := c.byKey[key]
+		if !ok {
+			return fmt.Errorf("unknown feature %q", key)
+		}
+		if err := def.check(features[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d FeatureDefinition) check(value interface{}) error {
+	switch d.Type {
+	case FeatureBool:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("feature %q must be true or false", d.Key)
+		}
+	case FeatureInt:
+		if !isInteger(value) {
+			return fmt.Errorf("feature %q must be an integer", d.Key)
+		}
+	case FeatureEnum:
+		s, ok := value.(string)
+		if !ok || !contains(d.Values, s) {
+			return fmt.Errorf("feature %q must be one of: %s", d.Key, strings.Join(d.Values, ", "))
+		}
+	}
+	return nil
+}
+
+// zero is the value shown for a feature a plan does not set.
+func (d FeatureDefinition) zero() interface{} {
+	switch d.Type {
+	case FeatureBool:
+		return false
+	case FeatureInt:
+		return 0
+	default:
+		return nil
+	}
+}
+
+// isInteger accepts whole numbers, including the float64 that JSON
+// decoding produces.
+func isInteger(value interface{}) bool {
+	switch v := value.(type) {
+	case int, int32, int64:
+		return true
+	case float64:
+		return v == math.Trunc(v) && !math.IsInf(v, 0)
+	default:
+		return false
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFeatureCatalog lists the plan feature definitions.
+func (s *Service) GetFeatureCatalog(c *gin.Context) {
+	definitions := s.catalog.Definitions()
+	if definitions == nil {
+		definitions = []FeatureDefinition{}
+	}
+	c.JSON(http.StatusOK, gin.H{"features": definitions})
+	telemetry.RecordPlanOperation("feature_catalog", "success")
+}
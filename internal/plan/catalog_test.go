@@ -0,0 +1,47 @@
+package plan
+
+import (
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCatalog() *Catalog {
+	return NewCatalog([]config.FeatureConfig{
+		{Key: "api_access", Type: FeatureBool, DisplayName: "API access"},
+		{Key: "storage_gb", Type: FeatureInt},
+		{Key: "support_channel", Type: FeatureEnum, Values: []string{"email", "chat"}},
+	})
+}
+
+func TestCatalogValidate(t *testing.T) {
+	catalog := testCatalog()
+
+	assert.NoError(t, catalog.Validate(map[string]interface{}{
+		"api_access":      true,
+		"storage_gb":      float64(100),
+		"support_channel": "chat",
+	}))
+	assert.Error(t, catalog.Validate(map[string]interface{}{"storage_gb": "lots"}))
+	assert.Error(t, catalog.Validate(map[string]interface{}{"storage_gb": 1.5}))
+	assert.Error(t, catalog.Validate(map[string]interface{}{"api_access": "yes"}))
+	assert.Error(t, catalog.Validate(map[string]interface{}{"support_channel": "phone"}))
+	assert.Error(t, catalog.Validate(map[string]interface{}{"beta_access": true}))
+
+	// Without a catalog anything goes
+	assert.NoError(t, NewCatalog(nil).Validate(map[string]interface{}{"storage_gb": "lots"}))
+}
+
+func TestFeatureMatrixFollowsCatalog(t *testing.T) {
+	s := &Service{catalog: testCatalog()}
+	plan := Plan{Name: "Basic", Features: map[string]interface{}{"api_access": true, "legacy": true}}
+
+	matrix := s.createFeatureMatrix(plan)
+
+	assert.Equal(t, true, matrix["api_access"])
+	assert.Equal(t, 0, matrix["storage_gb"])
+	assert.Contains(t, matrix, "support_channel")
+	assert.NotContains(t, matrix, "legacy")
+}
@@ -0,0 +1,231 @@
+package plan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Limits on what the pricing page shows for a plan
+const (
+	maxMarketingBullets = 10
+	maxBulletLength     = 200
+	maxCTALength        = 50
+)
+
+// errUnknownPlan is returned by reorderPlans for an ID no plan has
+type errUnknownPlan struct {
+	id string
+}
+
+func (e *errUnknownPlan) Error() string { return fmt.Sprintf("plan %s not found", e.id) }
+
+// ReorderPlansRequest lists plans in the order the pricing page shows them
+type ReorderPlansRequest struct {
+	PlanIDs []string `json:"plan_ids" validate:"required,min=1,max=100,unique,dive,uuid"`
+}
+
+type PlanOrderResponse struct {
+	Plans []Plan `json:"plans"`
+}
+
+// validatePresentation checks a plan's marketing bullets and call to action
+func validatePresentation(bullets []string, ctaText *string) error {
+	if len(bullets) > maxMarketingBullets {
+		return fmt.Errorf("marketing_bullets must have at most %d bullets", maxMarketingBullets)
+	}
+	for _, bullet := range bullets {
+		if bullet == "" {
+			return errors.New("marketing_bullets must not be empty strings")
+		}
+		if utf8.RuneCountInString(bullet) > maxBulletLength {
+			return fmt.Errorf("marketing_bullets must be at most %d characters each", maxBulletLength)
+		}
+	}
+	if ctaText != nil && utf8.RuneCountInString(*ctaText) > maxCTALength {
+		return fmt.Errorf("cta_text must be at most %d characters", maxCTALength)
+	}
+	return nil
+}
+
+// encodeBullets encodes marketing bullets for the JSONB column; no bullets
+// are stored as an empty list
+func encodeBullets(bullets []string) ([]byte, error) {
+	if bullets == nil {
+		bullets = []string{}
+	}
+	return json.Marshal(bullets)
+}
+
+// ReorderPlans sets the order plans appear in on the pricing page. The
+// listed plans come first, in the order given; the others follow in their
+// current order. Every plan whose display_order changes gets a new version.
+func (s *Service) ReorderPlans(c *gin.Context) {
+	var req ReorderPlansRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request format",
+			Code:    "INVALID_JSON",
+			Details: err.Error(),
+		})
+		telemetry.RecordPlanOperation("reorder", "validation_error")
+		return
+	}
+
+	if err := s.validatePlanRequest(req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Validation failed",
+			Code:    "VALIDATION_ERROR",
+			Details: err.Error(),
+		})
+		telemetry.RecordPlanOperation("reorder", "validation_error")
+		return
+	}
+
+	ctx := c.Request.Context()
+	changed, err := s.reorderPlans(ctx, req.PlanIDs)
+	if err != nil {
+		var unknown *errUnknownPlan
+		if errors.As(err, &unknown) {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error:   fmt.Sprintf("Plan with ID %s not found", unknown.id),
+				Code:    "PLAN_NOT_FOUND",
+				Details: fmt.Sprintf("Plan ID: %s", unknown.id),
+			})
+			telemetry.RecordPlanOperation("reorder", "not_found")
+			return
+		}
+		logrus.Errorf("Failed to reorder plans: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Internal server error",
+			Code:  "DB_ERROR",
+		})
+		telemetry.RecordPlanOperation("reorder", "db_error")
+		return
+	}
+
+	for _, id := range changed {
+		s.removeCachedPlan(ctx, id)
+	}
+	if len(changed) > 0 {
+		s.invalidateActivePlans(ctx)
+	}
+
+	plans, err := s.plansInDisplayOrder(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to list reordered plans: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Internal server error",
+			Code:  "DB_ERROR",
+		})
+		telemetry.RecordPlanOperation("reorder", "db_error")
+		return
+	}
+	c.JSON(http.StatusOK, PlanOrderResponse{Plans: plans})
+	telemetry.RecordPlanOperation("reorder", "success")
+}
+
+// reorderPlans numbers the plans from 1: those in ids first, then the rest
+// in their current order. It returns the IDs of the plans it moved.
+func (s *Service) reorderPlans(ctx context.Context, ids []string) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, display_order FROM plans
+		ORDER BY display_order, price, created_at
+		FOR UPDATE
+	`)
+	if err != nil {
+		return nil, err
+	}
+	var current []string
+	orders := map[string]int{}
+	for rows.Next() {
+		var id string
+		var order int
+		if err := rows.Scan(&id, &order); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		current = append(current, id)
+		orders[id] = order
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if _, ok := orders[id]; !ok {
+			return nil, &errUnknownPlan{id: id}
+		}
+	}
+
+	var changed []string
+	for i, id := range newDisplayOrder(current, ids) {
+		if orders[id] == i+1 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE plans SET display_order = $2, version = version + 1, updated_at = NOW()
+			WHERE id = $1
+		`, id, i+1); err != nil {
+			return nil, err
+		}
+		changed = append(changed, id)
+	}
+	return changed, tx.Commit()
+}
+
+// newDisplayOrder puts the listed plans first, followed by the others in
+// their current order
+func newDisplayOrder(current, listed []string) []string {
+	ordered := make([]string, 0, len(current))
+	seen := make(map[string]bool, len(listed))
+	for _, id := range listed {
+		ordered = append(ordered, id)
+		seen[id] = true
+	}
+	for _, id := range current {
+		if !seen[id] {
+			ordered = append(ordered, id)
+		}
+	}
+	return ordered
+}
+
+// plansInDisplayOrder returns every plan, active or not, in pricing page
+// order
+func (s *Service) plansInDisplayOrder(ctx context.Context) ([]Plan, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+planColumns+`
+		FROM plans
+		ORDER BY display_order, price, created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []Plan{}
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, *plan)
+	}
+	return plans, rows.Err()
+}
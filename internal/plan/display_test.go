@@ -0,0 +1,42 @@
+package plan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePresentation(t *testing.T) {
+	assert.NoError(t, validatePresentation(nil, nil))
+	assert.NoError(t, validatePresentation([]string{"Unlimited downloads", strings.Repeat("é", maxBulletLength)}, stringPtr("Start free trial")))
+
+	assert.EqualError(t, validatePresentation(make([]string, maxMarketingBullets+1), nil),
+		"marketing_bullets must have at most 10 bullets")
+	assert.EqualError(t, validatePresentation([]string{"Unlimited downloads", ""}, nil),
+		"marketing_bullets must not be empty strings")
+	assert.EqualError(t, validatePresentation([]string{strings.Repeat("a", maxBulletLength+1)}, nil),
+		"marketing_bullets must be at most 200 characters each")
+	assert.EqualError(t, validatePresentation(nil, stringPtr(strings.Repeat("a", maxCTALength+1))),
+		"cta_text must be at most 50 characters")
+}
+
+func TestNewDisplayOrder(t *testing.T) {
+	current := []string{"free", "basic", "pro", "team"}
+
+	assert.Equal(t, []string{"pro", "basic", "free", "team"}, newDisplayOrder(current, []string{"pro", "basic"}))
+	assert.Equal(t, []string{"team", "pro", "basic", "free"}, newDisplayOrder(current, []string{"team", "pro", "basic", "free"}))
+}
+
+func TestReorderPlansRequestValidation(t *testing.T) {
+	service := &Service{validator: newValidator()}
+
+	assert.NoError(t, service.validatePlanRequest(ReorderPlansRequest{PlanIDs: []string{
+		"6f1c1c7e-8f57-4c1a-9b5a-0d6c2f2b8a11", "0b6a2d4e-3c1f-4e8a-9d7b-5f2e1a3c4b22",
+	}}))
+	assert.Error(t, service.validatePlanRequest(ReorderPlansRequest{}))
+	assert.Error(t, service.validatePlanRequest(ReorderPlansRequest{PlanIDs: []string{"pro"}}))
+	assert.Error(t, service.validatePlanRequest(ReorderPlansRequest{PlanIDs: []string{
+		"6f1c1c7e-8f57-4c1a-9b5a-0d6c2f2b8a11", "6f1c1c7e-8f57-4c1a-9b5a-0d6c2f2b8a11",
+	}}))
+}
@@ -0,0 +1,63 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"scalable-paywall/internal/money"
+)
+
+// ImportPlan creates a plan from one row of a bulk import under the same
+// rules as CreatePlan. A row that breaks them fails with ErrInvalidPlanData
+// or ErrPlanNameExists; any other error is the database's.
+func (s *Service) ImportPlan(ctx context.Context, req CreatePlanRequest) (*Plan, error) {
+	if err := s.validatePlanRequest(req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPlanData, err)
+	}
+	if err := s.catalog.Validate(req.Features); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPlanData, err)
+	}
+	if !money.IsValid(req.Price, req.Currency) {
+		return nil, fmt.Errorf("%w: price has more decimal places than %s allows", ErrInvalidPlanData, req.Currency)
+	}
+	if err := validateBillingPeriod(req.BillingCycle, req.BillingInterval, req.BillingAnchorDay); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPlanData, err)
+	}
+	if err := validatePresentation(req.MarketingBullets, req.CTAText); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPlanData, err)
+	}
+	if err := s.validateParent(ctx, "", req.ParentPlanID); err != nil {
+		if errors.Is(err, ErrInvalidParent) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPlanData, err)
+		}
+		return nil, err
+	}
+
+	planType := req.planType()
+
+	exists, err := s.planNameExists(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("%w: %s", ErrPlanNameExists, req.Name)
+	}
+
+	plan := newPlan(req, planType)
+	if planType == PlanTypeFree && plan.IsActive {
+		exists, err := s.activeFreePlanExists(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, fmt.Errorf("%w: an active free plan already exists", ErrInvalidPlanData)
+		}
+	}
+
+	if err := s.createPlan(ctx, plan); err != nil {
+		return nil, err
+	}
+	s.cachePlan(ctx, plan)
+	return plan, nil
+}
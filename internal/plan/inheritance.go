@@ -0,0 +1,145 @@
+package plan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxInheritanceDepth is how many plans an inheritance chain may hold, the
+// root included
+const maxInheritanceDepth = 5
+
+// ErrInvalidParent is returned for a parent plan that doesn't exist, or
+// that would make a plan inherit from itself or a chain too long
+var ErrInvalidParent = errors.New("invalid parent plan")
+
+// features returns what the plan grants: its effective features once
+// resolved from the database, else its own
+func (p Plan) features() map[string]interface{} {
+	if p.EffectiveFeatures != nil {
+		return p.EffectiveFeatures
+	}
+	return p.Features
+}
+
+// validateParent checks that plan planID, "" for a new one, may inherit
+// from parentID: the parent exists, isn't planID or one of the plans
+// inheriting from it, and the chain through planID stays within
+// maxInheritanceDepth.
+func (s *Service) validateParent(ctx context.Context, planID string, parentID *string) error {
+	if parentID == nil {
+		return nil
+	}
+	if *parentID == planID {
+		return fmt.Errorf("%w: a plan can't inherit from itself", ErrInvalidParent)
+	}
+
+	// The parent and its ancestors, nearest first
+	rows, err := s.db.QueryContext(ctx, `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_plan_id, 1 AS depth FROM plans WHERE id::text = $1
+			UNION ALL
+			SELECT p.id, p.parent_plan_id, a.depth + 1
+			FROM plans p JOIN ancestors a ON p.id = a.parent_plan_id
+			WHERE a.depth <= $2
+		)
+		SELECT id FROM ancestors ORDER BY depth
+	`, *parentID, maxInheritanceDepth)
+	if err != nil {
+		return err
+	}
+	var ancestors []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ancestors = append(ancestors, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(ancestors) == 0 {
+		return fmt.Errorf("%w: parent plan %s not found", ErrInvalidParent, *parentID)
+	}
+	for _, id := range ancestors {
+		if id == planID {
+			return fmt.Errorf("%w: plan %s inherits from this plan", ErrInvalidParent, *parentID)
+		}
+	}
+
+	below := 0
+	if planID != "" {
+		if err := s.db.QueryRowContext(ctx, `
+			WITH RECURSIVE descendants AS (
+				SELECT id, 0 AS depth FROM plans WHERE id = $1
+				UNION ALL
+				SELECT p.id, d.depth + 1
+				FROM plans p JOIN descendants d ON p.parent_plan_id = d.id
+				WHERE d.depth <= $2
+			)
+			SELECT MAX(depth) FROM descendants
+		`, planID, maxInheritanceDepth).Scan(&below); err != nil {
+			return err
+		}
+	}
+	if len(ancestors)+1+below > maxInheritanceDepth {
+		return fmt.Errorf("%w: inheritance chains may hold at most %d plans", ErrInvalidParent, maxInheritanceDepth)
+	}
+	return nil
+}
+
+// effectiveFeatures resolves what a plan grants through its parents
+func (s *Service) effectiveFeatures(ctx context.Context, id string) (map[string]interface{}, error) {
+	var data []byte
+	if err := s.db.QueryRowContext(ctx, `SELECT plan_effective_features($1)`, id).Scan(&data); err != nil {
+		return nil, err
+	}
+	var features map[string]interface{}
+	if err := json.Unmarshal(data, &features); err != nil {
+		return nil, fmt.Errorf("failed to parse effective features JSON: %w", err)
+	}
+	return features, nil
+}
+
+// removeCachedDescendants drops the cached plans inheriting from id,
+// directly or not, whose effective features follow it
+func (s *Service) removeCachedDescendants(ctx context.Context, id string) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH RECURSIVE descendants AS (
+			SELECT id, 0 AS depth FROM plans WHERE parent_plan_id = $1
+			UNION ALL
+			SELECT p.id, d.depth + 1
+			FROM plans p JOIN descendants d ON p.parent_plan_id = d.id
+			WHERE d.depth < $2
+		)
+		SELECT id FROM descendants
+	`, id, maxInheritanceDepth)
+	if err != nil {
+		logrus.Warnf("Failed to find plans inheriting from %s: %v", id, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var descendant string
+		if err := rows.Scan(&descendant); err != nil {
+			logrus.Warnf("Failed to find plans inheriting from %s: %v", id, err)
+			return
+		}
+		s.removeCachedPlan(ctx, descendant)
+	}
+}
+
+// planHasChildren reports whether other plans inherit from id
+func (s *Service) planHasChildren(ctx context.Context, id string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM plans WHERE parent_plan_id = $1)`, id).Scan(&exists)
+	return exists, err
+}
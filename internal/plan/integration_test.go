@@ -0,0 +1,34 @@
+//go:build integration
+
+package plan_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"scalable-paywall/internal/plan"
+	testenv "scalable-paywall/internal/testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var env *testenv.Env
+
+func TestMain(m *testing.M) { os.Exit(testenv.Main(m, &env)) }
+
+func TestCreateFreePlan(t *testing.T) {
+	env.Reset(t)
+	body := `{"name": "Free", "price": 0, "currency": "USD", "billing_cycle": "monthly", "type": "free"}`
+	w := testenv.Serve(env.Plans().CreatePlan, http.MethodPost, "/api/v1/plans/", body)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var response struct {
+		Data plan.Plan `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(t, response.Data.Price.IsZero())
+	assert.Equal(t, plan.PlanTypeFree, response.Data.Type)
+}
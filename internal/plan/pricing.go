@@ -0,0 +1,318 @@
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/experiment"
+	"scalable-paywall/internal/fx"
+	"scalable-paywall/internal/middleware"
+	"scalable-paywall/internal/money"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// otherFeaturesGroup holds plan features the feature catalog does not list
+const otherFeaturesGroup = "Other"
+
+// PricingPage is the public view of the active plans. It is deliberately
+// separate from Plan so admin fields can change without breaking the page.
+// Experiments maps each experiment the user is in to their variant.
+type PricingPage struct {
+	Currency    string            `json:"currency,omitempty"`
+	RatesAsOf   *time.Time        `json:"rates_as_of,omitempty"`
+	Experiments map[string]string `json:"experiments,omitempty"`
+	Plans       []PricingPlan     `json:"plans"`
+}
+
+// PricingPlan is a plan as shown on the pricing page. Converted is set when
+// Price was converted from the plan's own currency at the current FX rate.
+type PricingPlan struct {
+	ID              string                `json:"id"`
+	Name            string                `json:"name"`
+	Description     string                `json:"description,omitempty"`
+	Type            string                `json:"type"`
+	Badge           string                `json:"badge,omitempty"`
+	Highlighted     bool                  `json:"highlighted"`
+	Bullets         []string              `json:"marketing_bullets"`
+	CTAText         string                `json:"cta_text,omitempty"`
+	BillingCycle    string                `json:"billing_cycle"`
+	BillingInterval int                   `json:"billing_interval"`
+	Price           decimal.Decimal       `json:"price"`
+	Currency        string                `json:"currency"`
+	DisplayPrice    string                `json:"display_price"`
+	Converted       bool                  `json:"converted"`
+	FeatureGroups   []PricingFeatureGroup `json:"feature_groups"`
+}
+
+type PricingFeatureGroup struct {
+	Name     string           `json:"name"`
+	Features []PricingFeature `json:"features"`
+}
+
+type PricingFeature struct {
+	Key   string      `json:"key"`
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// GetPricing serves the public pricing page: active plans in display order,
+// with badges, marketing bullets, calls to action and grouped,
+// display-named features. ?currency= converts
+// prices into that currency. ?user_id= applies the user's price experiment
+// variants and records their exposure. Responses carry Cache-Control and an
+// ETag and honour If-None-Match.
+func (s *Service) GetPricing(c *gin.Context) {
+	currency := strings.ToUpper(c.Query("currency"))
+	if currency != "" && len(currency) != 3 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a 3-letter ISO 4217 code"})
+		telemetry.RecordPlanOperation("pricing", "validation_error")
+		return
+	}
+
+	ctx := c.Request.Context()
+	plans, _, err := s.activePlans(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to get active plans: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPlanOperation("pricing", "db_error")
+		return
+	}
+
+	// Experiments must never take the pricing page down; on error the user
+	// gets the regular prices
+	userID := c.Query("user_id")
+	var assignments []experiment.Assignment
+	if userID != "" {
+		assignments, err = s.experiments.Assign(ctx, userID)
+		if err != nil {
+			logrus.Errorf("Failed to assign experiments for user %s: %v", userID, err)
+		}
+		plans = applyVariants(plans, assignments)
+	}
+
+	var rates *fx.Rates
+	if currency != "" && needsConversion(plans, currency) {
+		rates, err = s.fx.Rates(ctx)
+		if err != nil {
+			logrus.Errorf("Failed to get exchange rates: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Exchange rates unavailable"})
+			telemetry.RecordPlanOperation("pricing", "fx_error")
+			return
+		}
+	}
+
+	page, err := buildPricingPage(plans, currency, rates, s.catalog.Definitions())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPlanOperation("pricing", "validation_error")
+		return
+	}
+
+	for _, assignment := range assignments {
+		if page.Experiments == nil {
+			page.Experiments = make(map[string]string)
+		}
+		page.Experiments[assignment.Experiment] = assignment.Variant.Key
+		if err := s.experiments.RecordExposure(ctx, userID, assignment); err != nil {
+			logrus.Errorf("Failed to record exposure of user %s to experiment %s: %v", userID, assignment.Experiment, err)
+		}
+	}
+
+	body, err := json.Marshal(page)
+	if err != nil {
+		logrus.Errorf("Failed to marshal pricing page: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPlanOperation("pricing", "error")
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := strconv.Quote(hex.EncodeToString(sum[:8]))
+	maxAge := 0
+	if s.cfg != nil {
+		maxAge = s.cfg.MaxAge
+	}
+	// A personalised page must not be served to other users from a shared cache
+	visibility := "public"
+	if userID != "" {
+		visibility = "private"
+	}
+	c.Header("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, maxAge))
+	c.Header("ETag", etag)
+
+	if middleware.NoneMatch(c, etag) {
+		c.Status(http.StatusNotModified)
+		telemetry.RecordPlanOperation("pricing", "not_modified")
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	telemetry.RecordPlanOperation("pricing", "success")
+}
+
+func needsConversion(plans []Plan, currency string) bool {
+	for _, plan := range plans {
+		if !strings.EqualFold(plan.Currency, currency) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyVariants returns a copy of plans with the price overrides and plan
+// order of each assignment applied, later assignments winning. Plans a
+// variant orders come first, in its order; the rest follow in their usual
+// display order.
+func applyVariants(plans []Plan, assignments []experiment.Assignment) []Plan {
+	if len(assignments) == 0 {
+		return plans
+	}
+	varied := make([]Plan, len(plans))
+	copy(varied, plans)
+
+	for _, assignment := range assignments {
+		variant := assignment.Variant
+		position := make(map[string]int, len(variant.PlanOrder))
+		for i, planID := range variant.PlanOrder {
+			position[planID] = i
+		}
+		for i := range varied {
+			if price, ok := variant.Prices[varied[i].ID]; ok {
+				varied[i].Price = price
+			}
+			if len(position) == 0 {
+				continue
+			}
+			if p, ok := position[varied[i].ID]; ok {
+				varied[i].DisplayOrder = p
+			} else {
+				varied[i].DisplayOrder += len(position)
+			}
+		}
+	}
+	return varied
+}
+
+// buildPricingPage orders plans by display order (price breaks ties) and
+// shapes them for the pricing page. When currency is set, prices in other
+// currencies are converted with rates.
+func buildPricingPage(plans []Plan, currency string, rates *fx.Rates, features []FeatureDefinition) (PricingPage, error) {
+	ordered := make([]Plan, len(plans))
+	copy(ordered, plans)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].DisplayOrder != ordered[j].DisplayOrder {
+			return ordered[i].DisplayOrder < ordered[j].DisplayOrder
+		}
+		return ordered[i].Price.LessThan(ordered[j].Price)
+	})
+
+	page := PricingPage{Currency: currency, Plans: make([]PricingPlan, 0, len(ordered))}
+	if rates != nil {
+		asOf := rates.AsOf
+		page.RatesAsOf = &asOf
+	}
+
+	for _, plan := range ordered {
+		entry := PricingPlan{
+			ID:              plan.ID,
+			Name:            plan.Name,
+			Type:            plan.Type,
+			BillingCycle:    plan.BillingCycle,
+			BillingInterval: plan.BillingInterval,
+			Price:           plan.Price,
+			Currency:        strings.ToUpper(plan.Currency),
+			Bullets:         plan.MarketingBullets,
+			FeatureGroups:   groupFeatures(plan.features(), features),
+		}
+		if entry.Bullets == nil {
+			entry.Bullets = []string{}
+		}
+		if plan.CTAText != nil {
+			entry.CTAText = *plan.CTAText
+		}
+		if plan.Description != nil {
+			entry.Description = *plan.Description
+		}
+		if plan.Badge != nil {
+			entry.Badge = *plan.Badge
+			entry.Highlighted = true
+		}
+
+		if currency != "" && entry.Currency != currency {
+			if rates == nil {
+				return PricingPage{}, fmt.Errorf("no exchange rates to convert %s into %s", entry.Currency, currency)
+			}
+			converted, _, err := rates.Convert(plan.Price, entry.Currency, currency)
+			if err != nil {
+				return PricingPage{}, fmt.Errorf("unsupported currency %s", currency)
+			}
+			entry.Price = converted
+			entry.Currency = currency
+			entry.Converted = true
+		}
+		entry.DisplayPrice = money.Format(entry.Price, entry.Currency)
+
+		page.Plans = append(page.Plans, entry)
+	}
+
+	return page, nil
+}
+
+// groupFeatures arranges a plan's features into display groups following
+// the order of the feature catalog. Features it does not list go last, under
+// otherFeaturesGroup with their key as name.
+func groupFeatures(planFeatures map[string]interface{}, catalog []FeatureDefinition) []PricingFeatureGroup {
+	groups := []PricingFeatureGroup{}
+	groupIndex := make(map[string]int)
+	add := func(group string, feature PricingFeature) {
+		i, ok := groupIndex[group]
+		if !ok {
+			i = len(groups)
+			groupIndex[group] = i
+			groups = append(groups, PricingFeatureGroup{Name: group})
+		}
+		groups[i].Features = append(groups[i].Features, feature)
+	}
+
+	listed := make(map[string]bool, len(catalog))
+	for _, def := range catalog {
+		listed[def.Key] = true
+		value, ok := planFeatures[def.Key]
+		if !ok {
+			continue
+		}
+		name := def.DisplayName
+		if name == "" {
+			name = def.Key
+		}
+		group := def.Group
+		if group == "" {
+			group = otherFeaturesGroup
+		}
+		add(group, PricingFeature{Key: def.Key, Name: name, Value: value})
+	}
+
+	var unlisted []string
+	for key := range planFeatures {
+		if !listed[key] {
+			unlisted = append(unlisted, key)
+		}
+	}
+	sort.Strings(unlisted)
+	for _, key := range unlisted {
+		add(otherFeaturesGroup, PricingFeature{Key: key, Name: key, Value: planFeatures[key]})
+	}
+
+	return groups
+}
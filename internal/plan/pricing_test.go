@@ -0,0 +1,121 @@
+package plan
+
+import (
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/experiment"
+	"scalable-paywall/internal/fx"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildPricingPageOrdersAndHighlights(t *testing.T) {
+	plans := []Plan{
+		{ID: "pro", Name: "Pro", Price: decimal.RequireFromString("29.99"), Currency: "USD", DisplayOrder: 2, Badge: stringPtr("Most popular"),
+			MarketingBullets: []string{"Unlimited downloads", "Priority support"}, CTAText: stringPtr("Go Pro")},
+		{ID: "basic", Name: "Basic", Price: decimal.RequireFromString("9.99"), Currency: "USD", DisplayOrder: 1},
+		{ID: "free", Name: "Free", Price: decimal.Zero, Currency: "USD", DisplayOrder: 1, Type: PlanTypeFree},
+	}
+
+	page, err := buildPricingPage(plans, "", nil, nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Plans, 3)
+	assert.Equal(t, "free", page.Plans[0].ID)
+	assert.Equal(t, "basic", page.Plans[1].ID)
+	assert.Equal(t, "pro", page.Plans[2].ID)
+	assert.True(t, page.Plans[2].Highlighted)
+	assert.Equal(t, "Most popular", page.Plans[2].Badge)
+	assert.Equal(t, []string{"Unlimited downloads", "Priority support"}, page.Plans[2].Bullets)
+	assert.Equal(t, "Go Pro", page.Plans[2].CTAText)
+	assert.Equal(t, []string{}, page.Plans[1].Bullets)
+	assert.Equal(t, "9.99 USD", page.Plans[1].DisplayPrice)
+}
+
+func TestBuildPricingPageShowsEffectiveFeatures(t *testing.T) {
+	plans := []Plan{{
+		ID: "pro", Price: decimal.RequireFromString("19.99"), Currency: "USD",
+		Features:          map[string]interface{}{"downloads": 100},
+		EffectiveFeatures: map[string]interface{}{"downloads": 100, "hd_video": true},
+	}}
+
+	page, err := buildPricingPage(plans, "", nil, nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Plans[0].FeatureGroups[0].Features, 2)
+}
+
+func TestBuildPricingPageConvertsPrices(t *testing.T) {
+	rates := &fx.Rates{
+		Base:  "EUR",
+		AsOf:  time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Rates: map[string]decimal.Decimal{"USD": decimal.RequireFromString("1.10")},
+	}
+	plans := []Plan{{ID: "basic", Price: decimal.RequireFromString("11.00"), Currency: "USD"}}
+
+	page, err := buildPricingPage(plans, "EUR", rates, nil)
+
+	assert.NoError(t, err)
+	assert.True(t, page.Plans[0].Price.Equal(decimal.RequireFromString("10.00")))
+	assert.Equal(t, "EUR", page.Plans[0].Currency)
+	assert.True(t, page.Plans[0].Converted)
+	assert.Equal(t, rates.AsOf, *page.RatesAsOf)
+
+	_, err = buildPricingPage(plans, "XYZ", rates, nil)
+	assert.Error(t, err)
+}
+
+func TestGroupFeaturesFollowsCatalog(t *testing.T) {
+	catalog := []FeatureDefinition{
+		{Key: "storage_gb", DisplayName: "Storage (GB)", Group: "Platform"},
+		{Key: "priority_support", DisplayName: "Priority support", Group: "Support"},
+		{Key: "api_access", DisplayName: "API access", Group: "Platform"},
+	}
+	features := map[string]interface{}{
+		"api_access":       true,
+		"storage_gb":       100,
+		"priority_support": false,
+		"beta_access":      true,
+	}
+
+	groups := groupFeatures(features, catalog)
+
+	assert.Len(t, groups, 3)
+	assert.Equal(t, "Platform", groups[0].Name)
+	assert.Equal(t, []PricingFeature{
+		{Key: "storage_gb", Name: "Storage (GB)", Value: 100},
+		{Key: "api_access", Name: "API access", Value: true},
+	}, groups[0].Features)
+	assert.Equal(t, "Support", groups[1].Name)
+	assert.Equal(t, otherFeaturesGroup, groups[2].Name)
+	assert.Equal(t, "beta_access", groups[2].Features[0].Name)
+}
+
+func TestApplyVariantsOverridesPricesAndOrder(t *testing.T) {
+	plans := []Plan{
+		{ID: "basic", Price: decimal.RequireFromString("9.99"), DisplayOrder: 1},
+		{ID: "pro", Price: decimal.RequireFromString("29.99"), DisplayOrder: 2},
+		{ID: "team", Price: decimal.RequireFromString("99.00"), DisplayOrder: 3},
+	}
+	assignments := []experiment.Assignment{{
+		Experiment: "pro_first",
+		Variant: experiment.Variant{
+			Key:       "treatment",
+			Prices:    map[string]decimal.Decimal{"pro": decimal.RequireFromString("24.99")},
+			PlanOrder: []string{"pro"},
+		},
+	}}
+
+	varied := applyVariants(plans, assignments)
+	page, err := buildPricingPage(varied, "", nil, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "pro", page.Plans[0].ID)
+	assert.True(t, page.Plans[0].Price.Equal(decimal.RequireFromString("24.99")))
+	assert.Equal(t, "basic", page.Plans[1].ID)
+	assert.Equal(t, "team", page.Plans[2].ID)
+	// The cached plans are left alone
+	assert.True(t, plans[1].Price.Equal(decimal.RequireFromString("29.99")))
+}
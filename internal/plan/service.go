@@ -7,15 +7,22 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
 	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/experiment"
+	"scalable-paywall/internal/fx"
+	"scalable-paywall/internal/middleware"
+	"scalable-paywall/internal/money"
 	"scalable-paywall/internal/telemetry"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 )
 
@@ -25,6 +32,14 @@ var (
 	ErrPlanNameExists       = errors.New("plan with this name already exists")
 	ErrPlanHasSubscriptions = errors.New("cannot delete plan with active subscriptions")
 	ErrInvalidPlanData      = errors.New("invalid plan data")
+	ErrVersionConflict      = errors.New("plan was modified concurrently")
+)
+
+// Plan types. Free plans cost nothing, need no payment method and are what
+// users are downgraded to when subscription.downgrade_to_free is set.
+const (
+	PlanTypePaid = "paid"
+	PlanTypeFree = "free"
 )
 
 // Response structures
@@ -40,55 +55,125 @@ type SuccessResponse struct {
 }
 
 type Service struct {
-	db        *db.Connection
-	cache     *cache.RedisClient
-	validator *validator.Validate
+	cfg         *config.PricingConfig
+	catalog     *Catalog
+	db          *db.Connection
+	cache       *cache.RedisClient
+	plans       *cache.ReadThrough[*Plan]
+	usage       *cache.ReadThrough[*UsageStatistics]
+	fx          *fx.Service
+	experiments *experiment.Service
+	validator   *validator.Validate
 }
 
+// Plan is a purchasable plan. RolloverCap caps how much of a usage
+// period's unused limit carries into the next; nil carries nothing over.
+// OveragePercent lets usage run that far past the limit before it is
+// blocked. DisplayOrder, Badge, MarketingBullets and CTAText are how the
+// pricing page presents the plan. A plan with a ParentPlanID inherits its
+// parent's features: Features are its own, overriding inherited ones, and
+// EffectiveFeatures what it grants.
 type Plan struct {
-	ID               string                 `json:"id" db:"id"`
-	Name             string                 `json:"name" db:"name"`
-	Description      *string                `json:"description" db:"description"`
-	Price            float64                `json:"price" db:"price"`
-	Currency         string                 `json:"currency" db:"currency"`
-	BillingCycle     string                 `json:"billing_cycle" db:"billing_cycle"`
-	Features         map[string]interface{} `json:"features" db:"features"`
-	MaxUsagePerDay   *int                   `json:"max_usage_per_day" db:"max_usage_per_day"`
-	MaxUsagePerMonth *int                   `json:"max_usage_per_month" db:"max_usage_per_month"`
-	IsActive         bool                   `json:"is_active" db:"is_active"`
-	CreatedAt        time.Time              `json:"created_at" db:"created_at"`
-	UpdatedAt        time.Time              `json:"updated_at" db:"updated_at"`
+	ID                string                 `json:"id" db:"id"`
+	Name              string                 `json:"name" db:"name"`
+	Description       *string                `json:"description" db:"description"`
+	Price             decimal.Decimal        `json:"price" db:"price"`
+	Currency          string                 `json:"currency" db:"currency"`
+	BillingCycle      string                 `json:"billing_cycle" db:"billing_cycle"`
+	BillingInterval   int                    `json:"billing_interval" db:"billing_interval"`
+	BillingAnchorDay  *int                   `json:"billing_anchor_day" db:"billing_anchor_day"`
+	Type              string                 `json:"type" db:"plan_type"`
+	ParentPlanID      *string                `json:"parent_plan_id" db:"parent_plan_id"`
+	Features          map[string]interface{} `json:"features" db:"features"`
+	EffectiveFeatures map[string]interface{} `json:"effective_features" db:"-"`
+	MaxUsagePerDay    *int                   `json:"max_usage_per_day" db:"max_usage_per_day"`
+	MaxUsagePerMonth  *int                   `json:"max_usage_per_month" db:"max_usage_per_month"`
+	GracePeriodDays   int                    `json:"grace_period_days" db:"grace_period_days"`
+	RolloverCap       *int                   `json:"usage_rollover_cap" db:"usage_rollover_cap"`
+	OveragePercent    int                    `json:"usage_overage_percent" db:"usage_overage_percent"`
+	IsActive          bool                   `json:"is_active" db:"is_active"`
+	DisplayOrder      int                    `json:"display_order" db:"display_order"`
+	Badge             *string                `json:"badge" db:"badge"`
+	MarketingBullets  []string               `json:"marketing_bullets" db:"marketing_bullets"`
+	CTAText           *string                `json:"cta_text" db:"cta_text"`
+	Version           int                    `json:"version" db:"version"`
+	CreatedAt         time.Time              `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time              `json:"updated_at" db:"updated_at"`
 }
 
 type CreatePlanRequest struct {
 	Name             string                 `json:"name" validate:"required"`
 	Description      *string                `json:"description"`
-	Price            float64                `json:"price" validate:"required,min=0"`
+	Price            decimal.Decimal        `json:"price" validate:"min=0"`
 	Currency         string                 `json:"currency" validate:"required,len=3"`
 	BillingCycle     string                 `json:"billing_cycle" validate:"required,oneof=monthly yearly weekly daily"`
+	BillingInterval  *int                   `json:"billing_interval" validate:"omitempty,min=1,max=52"`
+	BillingAnchorDay *int                   `json:"billing_anchor_day" validate:"omitempty,min=1,max=31"`
+	Type             string                 `json:"type" validate:"omitempty,oneof=paid free"`
+	ParentPlanID     *string                `json:"parent_plan_id" validate:"omitempty,uuid"`
 	Features         map[string]interface{} `json:"features"`
 	MaxUsagePerDay   *int                   `json:"max_usage_per_day" validate:"omitempty,min=0"`
 	MaxUsagePerMonth *int                   `json:"max_usage_per_month" validate:"omitempty,min=0"`
+	GracePeriodDays  *int                   `json:"grace_period_days" validate:"omitempty,min=0,max=90"`
+	RolloverCap      *int                   `json:"usage_rollover_cap" validate:"omitempty,min=0"`
+	OveragePercent   *int                   `json:"usage_overage_percent" validate:"omitempty,min=0,max=100"`
 	IsActive         *bool                  `json:"is_active"`
+	DisplayOrder     int                    `json:"display_order"`
+	Badge            *string                `json:"badge" validate:"omitempty,max=50"`
+	MarketingBullets []string               `json:"marketing_bullets"`
+	CTAText          *string                `json:"cta_text" validate:"omitempty,max=50"`
+}
+
+// planType is the requested type, paid unless it says otherwise
+func (r CreatePlanRequest) planType() string {
+	if r.Type != "" {
+		return r.Type
+	}
+	return PlanTypePaid
 }
 
+// UpdatePlanRequest replaces the fields it sets. An empty badge, CTA text
+// or parent plan clears it, and an empty list of marketing bullets drops
+// them.
 type UpdatePlanRequest struct {
 	Name             *string                 `json:"name" validate:"omitempty"`
 	Description      *string                 `json:"description"`
-	Price            *float64                `json:"price" validate:"omitempty,min=0"`
+	Price            *decimal.Decimal        `json:"price" validate:"omitempty,min=0"`
 	Currency         *string                 `json:"currency" validate:"omitempty,len=3"`
 	BillingCycle     *string                 `json:"billing_cycle" validate:"omitempty,oneof=monthly yearly weekly daily"`
+	BillingInterval  *int                    `json:"billing_interval" validate:"omitempty,min=1,max=52"`
+	BillingAnchorDay *int                    `json:"billing_anchor_day" validate:"omitempty,min=0,max=31"`
+	ParentPlanID     *string                 `json:"parent_plan_id"`
 	Features         *map[string]interface{} `json:"features"`
 	MaxUsagePerDay   *int                    `json:"max_usage_per_day" validate:"omitempty,min=0"`
 	MaxUsagePerMonth *int                    `json:"max_usage_per_month" validate:"omitempty,min=0"`
+	GracePeriodDays  *int                    `json:"grace_period_days" validate:"omitempty,min=0,max=90"`
+	RolloverCap      *int                    `json:"usage_rollover_cap" validate:"omitempty,min=0"`
+	OveragePercent   *int                    `json:"usage_overage_percent" validate:"omitempty,min=0,max=100"`
 	IsActive         *bool                   `json:"is_active"`
+	DisplayOrder     *int                    `json:"display_order"`
+	Badge            *string                 `json:"badge" validate:"omitempty,max=50"`
+	MarketingBullets *[]string               `json:"marketing_bullets"`
+	CTAText          *string                 `json:"cta_text" validate:"omitempty,max=50"`
 }
 
+// PlanListResponse carries either page-based (Total, Page) or cursor-based
+// results; NextCursor is set in both modes while more plans remain.
 type PlanListResponse struct {
-	Plans []Plan `json:"plans"`
-	Total int    `json:"total"`
-	Page  int    `json:"page"`
-	Limit int    `json:"limit"`
+	Plans      []Plan `json:"plans"`
+	Total      *int   `json:"total,omitempty"`
+	Page       int    `json:"page,omitempty"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type BatchGetPlansRequest struct {
+	PlanIDs []string `json:"plan_ids" validate:"required,min=1,max=100"`
+}
+
+type BatchGetPlansResponse struct {
+	Plans    []Plan   `json:"plans"`
+	NotFound []string `json:"not_found"`
 }
 
 // Plan comparison structures
@@ -104,18 +189,25 @@ type PlanComparisonItem struct {
 	Recommendation string                 `json:"recommendation,omitempty"`
 }
 
+// PriceAnalysis amounts are in the plan's currency. When compared plans use
+// different currencies, BaseMonthlyCost and ExchangeRate give the monthly
+// cost normalized into the summary's base currency.
 type PriceAnalysis struct {
-	MonthlyCost    float64  `json:"monthly_cost"`
-	YearlyCost     float64  `json:"yearly_cost"`
-	CostPerFeature float64  `json:"cost_per_feature,omitempty"`
-	Savings        *float64 `json:"savings,omitempty"`
+	MonthlyCost     decimal.Decimal  `json:"monthly_cost"`
+	YearlyCost      decimal.Decimal  `json:"yearly_cost"`
+	CostPerFeature  decimal.Decimal  `json:"cost_per_feature"`
+	Savings         *decimal.Decimal `json:"savings,omitempty"`
+	BaseMonthlyCost *decimal.Decimal `json:"base_monthly_cost,omitempty"`
+	ExchangeRate    *decimal.Decimal `json:"exchange_rate,omitempty"`
 }
 
 type PlanComparisonSummary struct {
-	CheapestPlan      string  `json:"cheapest_plan"`
-	MostExpensivePlan string  `json:"most_expensive_plan"`
-	BestValuePlan     string  `json:"best_value_plan,omitempty"`
-	PriceRange        float64 `json:"price_range"`
+	CheapestPlan      string          `json:"cheapest_plan"`
+	MostExpensivePlan string          `json:"most_expensive_plan"`
+	BestValuePlan     string          `json:"best_value_plan,omitempty"`
+	PriceRange        decimal.Decimal `json:"price_range"`
+	Currency          string          `json:"currency"`
+	RatesAsOf         *time.Time      `json:"rates_as_of,omitempty"`
 }
 
 // Plan analytics structures
@@ -128,13 +220,24 @@ type PlanAnalytics struct {
 	Recommendations []string           `json:"recommendations"`
 }
 
+// UsageStatistics aggregates the usage ledger over [From, To). Averages are
+// per subscription that recorded usage in the range.
 type UsageStatistics struct {
-	TotalSubscriptions   int     `json:"total_subscriptions"`
-	ActiveSubscriptions  int     `json:"active_subscriptions"`
-	AverageUsagePerDay   float64 `json:"average_usage_per_day"`
-	AverageUsagePerMonth float64 `json:"average_usage_per_month"`
-	PeakUsageDay         string  `json:"peak_usage_day,omitempty"`
-	PeakUsageMonth       string  `json:"peak_usage_month,omitempty"`
+	TotalSubscriptions   int          `json:"total_subscriptions"`
+	ActiveSubscriptions  int          `json:"active_subscriptions"`
+	From                 time.Time    `json:"from"`
+	To                   time.Time    `json:"to"`
+	TotalUsage           int          `json:"total_usage"`
+	AverageUsagePerDay   float64      `json:"average_usage_per_day"`
+	AverageUsagePerMonth float64      `json:"average_usage_per_month"`
+	PeakUsageDay         string       `json:"peak_usage_day,omitempty"`
+	PeakUsageMonth       string       `json:"peak_usage_month,omitempty"`
+	Daily                []DailyUsage `json:"daily"`
+}
+
+type DailyUsage struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
 }
 
 type PopularityMetrics struct {
@@ -146,17 +249,25 @@ type PopularityMetrics struct {
 }
 
 type PerformanceMetrics struct {
-	RevenueGenerated     float64 `json:"revenue_generated"`
-	AverageLifetime      float64 `json:"average_lifetime_days"`
-	ConversionRate       float64 `json:"conversion_rate"`
-	CustomerSatisfaction float64 `json:"customer_satisfaction,omitempty"`
+	RevenueGenerated     float64    `json:"revenue_generated"`
+	RevenueCurrency      string     `json:"revenue_currency,omitempty"`
+	RatesAsOf            *time.Time `json:"rates_as_of,omitempty"`
+	AverageLifetime      float64    `json:"average_lifetime_days"`
+	ConversionRate       float64    `json:"conversion_rate"`
+	CustomerSatisfaction float64    `json:"customer_satisfaction,omitempty"`
 }
 
-func NewService(db *db.Connection, cache *cache.RedisClient) *Service {
+func NewService(cfg *config.PricingConfig, features []config.FeatureConfig, db *db.Connection, cache *cache.RedisClient, fxSvc *fx.Service, experiments *experiment.Service) *Service {
 	return &Service{
-		db:        db,
-		cache:     cache,
-		validator: validator.New(),
+		cfg:         cfg,
+		catalog:     NewCatalog(features),
+		db:          db,
+		cache:       cache,
+		plans:       newPlanCache(cache),
+		usage:       newUsageCache(cache),
+		fx:          fxSvc,
+		experiments: experiments,
+		validator:   newValidator(),
 	}
 }
 
@@ -183,6 +294,67 @@ func (s *Service) CreatePlan(c *gin.Context) {
 		return
 	}
 
+	if err := s.catalog.Validate(req.Features); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Validation failed",
+			Code:    "VALIDATION_ERROR",
+			Details: err.Error(),
+		})
+		telemetry.RecordPlanOperation("create", "validation_error")
+		return
+	}
+
+	if !money.IsValid(req.Price, req.Currency) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Validation failed",
+			Code:    "VALIDATION_ERROR",
+			Details: fmt.Sprintf("Price has more decimal places than %s allows", req.Currency),
+		})
+		telemetry.RecordPlanOperation("create", "validation_error")
+		return
+	}
+
+	if err := validateBillingPeriod(req.BillingCycle, req.BillingInterval, req.BillingAnchorDay); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Validation failed",
+			Code:    "VALIDATION_ERROR",
+			Details: err.Error(),
+		})
+		telemetry.RecordPlanOperation("create", "validation_error")
+		return
+	}
+
+	if err := validatePresentation(req.MarketingBullets, req.CTAText); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Validation failed",
+			Code:    "VALIDATION_ERROR",
+			Details: err.Error(),
+		})
+		telemetry.RecordPlanOperation("create", "validation_error")
+		return
+	}
+
+	if err := s.validateParent(c.Request.Context(), "", req.ParentPlanID); err != nil {
+		if errors.Is(err, ErrInvalidParent) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Validation failed",
+				Code:    "VALIDATION_ERROR",
+				Details: err.Error(),
+			})
+			telemetry.RecordPlanOperation("create", "validation_error")
+			return
+		}
+		logrus.Errorf("Failed to check parent plan: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Internal server error",
+			Code:  "DB_ERROR",
+		})
+		telemetry.RecordPlanOperation("create", "db_error")
+		return
+	}
+
+	planType := req.planType()
+
 	// Check if plan name already exists
 	exists, err := s.planNameExists(c.Request.Context(), req.Name)
 	if err != nil {
@@ -205,26 +377,28 @@ func (s *Service) CreatePlan(c *gin.Context) {
 		return
 	}
 
-	// Set default values
-	isActive := true
-	if req.IsActive != nil {
-		isActive = *req.IsActive
-	}
+	plan := newPlan(req, planType)
 
-	// Create plan
-	plan := &Plan{
-		ID:               generateID(),
-		Name:             req.Name,
-		Description:      req.Description,
-		Price:            req.Price,
-		Currency:         req.Currency,
-		BillingCycle:     req.BillingCycle,
-		Features:         req.Features,
-		MaxUsagePerDay:   req.MaxUsagePerDay,
-		MaxUsagePerMonth: req.MaxUsagePerMonth,
-		IsActive:         isActive,
-		CreatedAt:        time.Now(),
-		UpdatedAt:        time.Now(),
+	if planType == PlanTypeFree && plan.IsActive {
+		exists, err := s.activeFreePlanExists(c.Request.Context(), "")
+		if err != nil {
+			logrus.Errorf("Failed to check for an active free plan: %v", err)
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Internal server error",
+				Code:  "DB_ERROR",
+			})
+			telemetry.RecordPlanOperation("create", "db_error")
+			return
+		}
+		if exists {
+			c.JSON(http.StatusConflict, ErrorResponse{
+				Error:   "An active free plan already exists",
+				Code:    "FREE_PLAN_EXISTS",
+				Details: "Deactivate the current free plan before creating another",
+			})
+			telemetry.RecordPlanOperation("create", "conflict")
+			return
+		}
 	}
 
 	if err := s.createPlan(c.Request.Context(), plan); err != nil {
@@ -240,6 +414,7 @@ func (s *Service) CreatePlan(c *gin.Context) {
 	// Cache the plan
 	s.cachePlan(c.Request.Context(), plan)
 
+	middleware.SetETag(c, plan.Version)
 	c.JSON(http.StatusCreated, SuccessResponse{
 		Message: "Plan created successfully",
 		Data:    plan,
@@ -254,16 +429,9 @@ func (s *Service) GetPlan(c *gin.Context) {
 		return
 	}
 
-	// Try cache first
-	cached, err := s.getCachedPlan(c.Request.Context(), id)
-	if err == nil && cached != nil {
-		c.JSON(http.StatusOK, cached)
-		telemetry.RecordPlanOperation("get", "cache_hit")
-		return
-	}
-
-	// Get from database
-	plan, err := s.getPlanByID(c.Request.Context(), id)
+	plan, hit, err := s.plans.Get(c.Request.Context(), id, func(ctx context.Context) (*Plan, error) {
+		return s.getPlanByID(ctx, id)
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
@@ -276,11 +444,13 @@ func (s *Service) GetPlan(c *gin.Context) {
 		return
 	}
 
-	// Cache the plan
-	s.cachePlan(c.Request.Context(), plan)
-
+	middleware.SetETag(c, plan.Version)
 	c.JSON(http.StatusOK, plan)
-	telemetry.RecordPlanOperation("get", "success")
+	if hit {
+		telemetry.RecordPlanOperation("get", "cache_hit")
+	} else {
+		telemetry.RecordPlanOperation("get", "success")
+	}
 }
 
 func (s *Service) UpdatePlan(c *gin.Context) {
@@ -290,6 +460,13 @@ func (s *Service) UpdatePlan(c *gin.Context) {
 		return
 	}
 
+	expectedVersion, checkVersion, err := middleware.IfMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPlanOperation("update", "validation_error")
+		return
+	}
+
 	var req UpdatePlanRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -297,6 +474,15 @@ func (s *Service) UpdatePlan(c *gin.Context) {
 		return
 	}
 
+	// Features are replaced wholesale, so the new set is checked on its own
+	if req.Features != nil {
+		if err := s.catalog.Validate(*req.Features); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			telemetry.RecordPlanOperation("update", "validation_error")
+			return
+		}
+	}
+
 	// Get existing plan
 	plan, err := s.getPlanByID(c.Request.Context(), id)
 	if err != nil {
@@ -311,6 +497,11 @@ func (s *Service) UpdatePlan(c *gin.Context) {
 		return
 	}
 
+	if checkVersion && expectedVersion != plan.Version {
+		s.respondVersionConflict(c, http.StatusPreconditionFailed, plan.Version)
+		return
+	}
+
 	// Check name uniqueness if name is being updated
 	if req.Name != nil && *req.Name != plan.Name {
 		exists, err := s.planNameExists(c.Request.Context(), *req.Name)
@@ -343,6 +534,23 @@ func (s *Service) UpdatePlan(c *gin.Context) {
 	if req.BillingCycle != nil {
 		plan.BillingCycle = *req.BillingCycle
 	}
+	if req.BillingInterval != nil {
+		plan.BillingInterval = *req.BillingInterval
+	}
+	if req.BillingAnchorDay != nil {
+		// An anchor day of 0 goes back to renewing on each anniversary
+		plan.BillingAnchorDay = req.BillingAnchorDay
+		if *req.BillingAnchorDay == 0 {
+			plan.BillingAnchorDay = nil
+		}
+	}
+	if req.ParentPlanID != nil {
+		// An empty parent stops inheriting
+		plan.ParentPlanID = req.ParentPlanID
+		if *req.ParentPlanID == "" {
+			plan.ParentPlanID = nil
+		}
+	}
 	if req.Features != nil {
 		plan.Features = *req.Features
 	}
@@ -352,14 +560,107 @@ func (s *Service) UpdatePlan(c *gin.Context) {
 	if req.MaxUsagePerMonth != nil {
 		plan.MaxUsagePerMonth = req.MaxUsagePerMonth
 	}
+	if req.GracePeriodDays != nil {
+		plan.GracePeriodDays = *req.GracePeriodDays
+	}
+	if req.RolloverCap != nil {
+		// A cap of 0 turns rollover off
+		plan.RolloverCap = req.RolloverCap
+		if *req.RolloverCap == 0 {
+			plan.RolloverCap = nil
+		}
+	}
+	if req.OveragePercent != nil {
+		plan.OveragePercent = *req.OveragePercent
+	}
 	if req.IsActive != nil {
 		plan.IsActive = *req.IsActive
 	}
+	if req.DisplayOrder != nil {
+		plan.DisplayOrder = *req.DisplayOrder
+	}
+	if req.Badge != nil {
+		// An empty badge clears it
+		plan.Badge = req.Badge
+		if *req.Badge == "" {
+			plan.Badge = nil
+		}
+	}
+	if req.MarketingBullets != nil {
+		plan.MarketingBullets = *req.MarketingBullets
+	}
+	if req.CTAText != nil {
+		plan.CTAText = req.CTAText
+		if *req.CTAText == "" {
+			plan.CTAText = nil
+		}
+	}
+
+	if !money.IsValid(plan.Price, plan.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Price has more decimal places than %s allows", plan.Currency)})
+		telemetry.RecordPlanOperation("update", "validation_error")
+		return
+	}
+
+	if err := validateBillingPeriod(plan.BillingCycle, &plan.BillingInterval, plan.BillingAnchorDay); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPlanOperation("update", "validation_error")
+		return
+	}
+	if err := validateUsagePolicy(plan.RolloverCap, plan.OveragePercent); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPlanOperation("update", "validation_error")
+		return
+	}
+	if err := validatePresentation(plan.MarketingBullets, plan.CTAText); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPlanOperation("update", "validation_error")
+		return
+	}
+	if req.ParentPlanID != nil {
+		if err := s.validateParent(c.Request.Context(), plan.ID, plan.ParentPlanID); err != nil {
+			if errors.Is(err, ErrInvalidParent) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				telemetry.RecordPlanOperation("update", "validation_error")
+				return
+			}
+			logrus.Errorf("Failed to check parent plan: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			telemetry.RecordPlanOperation("update", "db_error")
+			return
+		}
+	}
+
+	if err := validatePlanPrice(plan.Type, plan.Price); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPlanOperation("update", "validation_error")
+		return
+	}
+	if plan.Type == PlanTypeFree {
+		if req.IsActive != nil && *req.IsActive {
+			exists, err := s.activeFreePlanExists(c.Request.Context(), plan.ID)
+			if err != nil {
+				logrus.Errorf("Failed to check for an active free plan: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				telemetry.RecordPlanOperation("update", "db_error")
+				return
+			}
+			if exists {
+				c.JSON(http.StatusConflict, gin.H{"error": "An active free plan already exists"})
+				telemetry.RecordPlanOperation("update", "conflict")
+				return
+			}
+		}
+	}
 
 	plan.UpdatedAt = time.Now()
 
 	// Update in database
 	if err := s.updatePlan(c.Request.Context(), plan); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			s.respondCurrentVersion(c, id)
+			return
+		}
 		logrus.Errorf("Failed to update plan: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		telemetry.RecordPlanOperation("update", "db_error")
@@ -369,10 +670,39 @@ func (s *Service) UpdatePlan(c *gin.Context) {
 	// Update cache
 	s.cachePlan(c.Request.Context(), plan)
 
+	middleware.SetETag(c, plan.Version)
 	c.JSON(http.StatusOK, plan)
 	telemetry.RecordPlanOperation("update", "success")
 }
 
+// respondCurrentVersion answers an update that lost a compare-and-set race
+// with 409 and the version now stored.
+func (s *Service) respondCurrentVersion(c *gin.Context, id string) {
+	current, err := s.getPlanByID(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+			telemetry.RecordPlanOperation("update", "not_found")
+			return
+		}
+		logrus.Errorf("Failed to get plan: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPlanOperation("update", "db_error")
+		return
+	}
+	s.removeCachedPlan(c.Request.Context(), id)
+	s.respondVersionConflict(c, http.StatusConflict, current.Version)
+}
+
+func (s *Service) respondVersionConflict(c *gin.Context, status, currentVersion int) {
+	middleware.SetETag(c, currentVersion)
+	c.JSON(status, gin.H{
+		"error":           "Plan was modified by another request",
+		"current_version": currentVersion,
+	})
+	telemetry.RecordPlanOperation("update", "conflict")
+}
+
 func (s *Service) DeletePlan(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
@@ -395,6 +725,19 @@ func (s *Service) DeletePlan(c *gin.Context) {
 		return
 	}
 
+	hasChildren, err := s.planHasChildren(c.Request.Context(), id)
+	if err != nil {
+		logrus.Errorf("Failed to check plans inheriting from plan: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPlanOperation("delete", "db_error")
+		return
+	}
+	if hasChildren {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot delete plan other plans inherit from"})
+		telemetry.RecordPlanOperation("delete", "conflict")
+		return
+	}
+
 	// Delete plan
 	if err := s.deletePlan(c.Request.Context(), id); err != nil {
 		logrus.Errorf("Failed to delete plan: %v", err)
@@ -413,7 +756,7 @@ func (s *Service) DeletePlan(c *gin.Context) {
 func (s *Service) ListPlans(c *gin.Context) {
 	// Parse query parameters
 	page := 1
-	limit := 20
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
 	activeOnly := false
 
 	if pageStr := c.Query("page"); pageStr != "" {
@@ -422,16 +765,39 @@ func (s *Service) ListPlans(c *gin.Context) {
 		}
 	}
 
-	if limitStr := c.Query("limit"); limitStr != "" {
-		if l, err := parseInt(limitStr); err == nil && l > 0 && l <= 100 {
-			limit = l
-		}
-	}
-
 	if c.Query("active_only") == "true" {
 		activeOnly = true
 	}
 
+	// Cursor-based pagination avoids OFFSET scans on large tables
+	if cursorStr := c.Query("cursor"); cursorStr != "" {
+		cursor, err := db.DecodeCursor(cursorStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: "Invalid cursor",
+				Code:  "INVALID_CURSOR",
+			})
+			telemetry.RecordPlanOperation("list", "validation_error")
+			return
+		}
+
+		plans, nextCursor, err := s.listPlansAfter(c.Request.Context(), cursor, limit, activeOnly)
+		if err != nil {
+			logrus.Errorf("Failed to list plans: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			telemetry.RecordPlanOperation("list", "db_error")
+			return
+		}
+
+		c.JSON(http.StatusOK, PlanListResponse{
+			Plans:      plans,
+			Limit:      limit,
+			NextCursor: nextCursor,
+		})
+		telemetry.RecordPlanOperation("list", "success")
+		return
+	}
+
 	// Get plans from database
 	plans, total, err := s.listPlans(c.Request.Context(), page, limit, activeOnly)
 	if err != nil {
@@ -443,30 +809,23 @@ func (s *Service) ListPlans(c *gin.Context) {
 
 	response := PlanListResponse{
 		Plans: plans,
-		Total: total,
+		Total: &total,
 		Page:  page,
 		Limit: limit,
 	}
 
+	// Let page-based clients switch to cursors for subsequent pages
+	if (page-1)*limit+len(plans) < total && len(plans) > 0 {
+		last := plans[len(plans)-1]
+		response.NextCursor = db.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+
 	c.JSON(http.StatusOK, response)
 	telemetry.RecordPlanOperation("list", "success")
 }
 
 func (s *Service) GetActivePlans(c *gin.Context) {
-	// Try cache first
-	cacheKey := "plans:active"
-	cached, err := s.cache.Get(c.Request.Context(), cacheKey)
-	if err == nil && cached != "" {
-		var plans []Plan
-		if err := json.Unmarshal([]byte(cached), &plans); err == nil {
-			c.JSON(http.StatusOK, plans)
-			telemetry.RecordPlanOperation("get_active", "cache_hit")
-			return
-		}
-	}
-
-	// Get from database
-	plans, err := s.getActivePlans(c.Request.Context())
+	plans, cacheHit, err := s.activePlans(c.Request.Context())
 	if err != nil {
 		logrus.Errorf("Failed to get active plans: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
@@ -474,11 +833,12 @@ func (s *Service) GetActivePlans(c *gin.Context) {
 		return
 	}
 
-	// Cache active plans
-	s.cacheActivePlans(c.Request.Context(), plans)
-
 	c.JSON(http.StatusOK, plans)
-	telemetry.RecordPlanOperation("get_active", "success")
+	if cacheHit {
+		telemetry.RecordPlanOperation("get_active", "cache_hit")
+	} else {
+		telemetry.RecordPlanOperation("get_active", "success")
+	}
 }
 
 // ComparePlans compares multiple plans and provides analysis
@@ -502,31 +862,67 @@ func (s *Service) ComparePlans(c *gin.Context) {
 		return
 	}
 
-	// Get plans from database
+	// Get plans from database in a single query
+	found, err := s.getPlansByIDs(c.Request.Context(), planIDs)
+	if err != nil {
+		logrus.Errorf("Failed to get plans %v: %v", planIDs, err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Internal server error",
+			Code:  "DB_ERROR",
+		})
+		return
+	}
+
 	plans := make([]Plan, 0, len(planIDs))
 	for _, id := range planIDs {
-		plan, err := s.getPlanByID(c.Request.Context(), id)
+		plan, ok := found[id]
+		if !ok {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error:   fmt.Sprintf("Plan with ID %s not found", id),
+				Code:    "PLAN_NOT_FOUND",
+				Details: fmt.Sprintf("Plan ID: %s", id),
+			})
+			return
+		}
+		plans = append(plans, plan)
+	}
+
+	// Plans in different currencies are compared in the base currency
+	var rates *fx.Rates
+	var base string
+	if mixedCurrencies(plans) {
+		if s.fx == nil {
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+				Error: "Plans use different currencies",
+				Code:  "CURRENCY_MISMATCH",
+			})
+			telemetry.RecordPlanOperation("compare", "validation_error")
+			return
+		}
+		rates, err = s.fx.Rates(c.Request.Context())
 		if err != nil {
-			if err == sql.ErrNoRows {
-				c.JSON(http.StatusNotFound, ErrorResponse{
-					Error:   fmt.Sprintf("Plan with ID %s not found", id),
-					Code:    "PLAN_NOT_FOUND",
-					Details: fmt.Sprintf("Plan ID: %s", id),
-				})
-				return
-			}
-			logrus.Errorf("Failed to get plan %s: %v", id, err)
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Error: "Internal server error",
-				Code:  "DB_ERROR",
+			logrus.Errorf("Failed to load exchange rates: %v", err)
+			c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+				Error: "Exchange rates unavailable",
+				Code:  "FX_UNAVAILABLE",
 			})
+			telemetry.RecordPlanOperation("compare", "fx_error")
 			return
 		}
-		plans = append(plans, *plan)
+		base = s.fx.BaseCurrency()
 	}
 
 	// Generate comparison
-	comparison := s.generatePlanComparison(plans)
+	comparison, err := s.generatePlanComparison(plans, rates, base)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, ErrorResponse{
+			Error:   "Plans cannot be compared",
+			Code:    "CURRENCY_UNSUPPORTED",
+			Details: err.Error(),
+		})
+		telemetry.RecordPlanOperation("compare", "validation_error")
+		return
+	}
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Message: "Plan comparison generated successfully",
@@ -535,7 +931,68 @@ func (s *Service) ComparePlans(c *gin.Context) {
 	telemetry.RecordPlanOperation("compare", "success")
 }
 
-// GetPlanAnalytics provides comprehensive analytics for a specific plan
+// BatchGetPlans returns several plans in one call, preserving request order
+func (s *Service) BatchGetPlans(c *gin.Context) {
+	var req BatchGetPlansRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request format",
+			Code:    "INVALID_JSON",
+			Details: err.Error(),
+		})
+		telemetry.RecordPlanOperation("batch_get", "validation_error")
+		return
+	}
+
+	if err := s.validatePlanRequest(req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Validation failed",
+			Code:    "VALIDATION_ERROR",
+			Details: err.Error(),
+		})
+		telemetry.RecordPlanOperation("batch_get", "validation_error")
+		return
+	}
+
+	found, err := s.getPlansByIDs(c.Request.Context(), req.PlanIDs)
+	if err != nil {
+		logrus.Errorf("Failed to batch get plans: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Internal server error",
+			Code:  "DB_ERROR",
+		})
+		telemetry.RecordPlanOperation("batch_get", "db_error")
+		return
+	}
+
+	response := BatchGetPlansResponse{
+		Plans:    make([]Plan, 0, len(found)),
+		NotFound: []string{},
+	}
+	seen := make(map[string]bool, len(req.PlanIDs))
+	for _, id := range req.PlanIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if plan, ok := found[id]; ok {
+			response.Plans = append(response.Plans, plan)
+		} else {
+			response.NotFound = append(response.NotFound, id)
+		}
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Message: "Plans retrieved successfully",
+		Data:    response,
+	})
+	telemetry.RecordPlanOperation("batch_get", "success")
+}
+
+// GetPlanAnalytics provides comprehensive analytics for a specific plan.
+// Usage statistics cover ?from= to ?to= (YYYY-MM-DD, to inclusive),
+// defaulting to the last 30 days.
 func (s *Service) GetPlanAnalytics(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
@@ -546,6 +1003,17 @@ func (s *Service) GetPlanAnalytics(c *gin.Context) {
 		return
 	}
 
+	from, to, err := parseUsageRange(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid time range",
+			Code:    "INVALID_TIME_RANGE",
+			Details: err.Error(),
+		})
+		telemetry.RecordPlanOperation("analytics", "validation_error")
+		return
+	}
+
 	// Get plan details
 	plan, err := s.getPlanByID(c.Request.Context(), id)
 	if err != nil {
@@ -566,7 +1034,7 @@ func (s *Service) GetPlanAnalytics(c *gin.Context) {
 	}
 
 	// Generate analytics
-	analytics, err := s.generatePlanAnalytics(c.Request.Context(), plan)
+	analytics, err := s.generatePlanAnalytics(c.Request.Context(), plan, from, to)
 	if err != nil {
 		logrus.Errorf("Failed to generate plan analytics: %v", err)
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -584,6 +1052,57 @@ func (s *Service) GetPlanAnalytics(c *gin.Context) {
 }
 
 // Helper methods
+
+// newPlan builds the plan req describes, with defaults for omitted fields
+func newPlan(req CreatePlanRequest, planType string) *Plan {
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	gracePeriodDays := 0
+	if req.GracePeriodDays != nil {
+		gracePeriodDays = *req.GracePeriodDays
+	}
+
+	billingInterval := 1
+	if req.BillingInterval != nil {
+		billingInterval = *req.BillingInterval
+	}
+
+	overagePercent := 0
+	if req.OveragePercent != nil {
+		overagePercent = *req.OveragePercent
+	}
+
+	return &Plan{
+		ID:               generateID(),
+		Name:             req.Name,
+		Description:      req.Description,
+		Price:            req.Price,
+		Currency:         req.Currency,
+		BillingCycle:     req.BillingCycle,
+		BillingInterval:  billingInterval,
+		BillingAnchorDay: req.BillingAnchorDay,
+		Type:             planType,
+		ParentPlanID:     req.ParentPlanID,
+		Features:         req.Features,
+		MaxUsagePerDay:   req.MaxUsagePerDay,
+		MaxUsagePerMonth: req.MaxUsagePerMonth,
+		GracePeriodDays:  gracePeriodDays,
+		RolloverCap:      req.RolloverCap,
+		OveragePercent:   overagePercent,
+		IsActive:         isActive,
+		DisplayOrder:     req.DisplayOrder,
+		Badge:            req.Badge,
+		MarketingBullets: req.MarketingBullets,
+		CTAText:          req.CTAText,
+		Version:          1,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+}
+
 func (s *Service) createPlan(ctx context.Context, plan *Plan) error {
 	// Convert features map to JSON bytes for PostgreSQL JSONB
 	var featuresBytes []byte
@@ -595,32 +1114,117 @@ func (s *Service) createPlan(ctx context.Context, plan *Plan) error {
 		}
 	}
 
+	bullets, err := encodeBullets(plan.MarketingBullets)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		INSERT INTO plans (id, name, description, price, currency, billing_cycle, 
-			features, max_usage_per_day, max_usage_per_month, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			features, max_usage_per_day, max_usage_per_month, grace_period_days, is_active,
+			created_at, updated_at, plan_type, display_order, badge, billing_interval,
+			billing_anchor_day, usage_rollover_cap, usage_overage_percent, marketing_bullets, cta_text,
+			parent_plan_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18,
+			$19, $20, $21, $22, $23)
 	`
 	_, err = s.db.ExecContext(ctx, query, plan.ID, plan.Name, plan.Description, plan.Price,
 		plan.Currency, plan.BillingCycle, featuresBytes, plan.MaxUsagePerDay,
-		plan.MaxUsagePerMonth, plan.IsActive, plan.CreatedAt, plan.UpdatedAt)
+		plan.MaxUsagePerMonth, plan.GracePeriodDays, plan.IsActive, plan.CreatedAt, plan.UpdatedAt,
+		plan.Type, plan.DisplayOrder, plan.Badge, plan.BillingInterval, plan.BillingAnchorDay,
+		plan.RolloverCap, plan.OveragePercent, bullets, plan.CTAText, plan.ParentPlanID)
+	if err != nil {
+		return err
+	}
+	s.invalidateActivePlans(ctx)
+	plan.EffectiveFeatures, err = s.effectiveFeatures(ctx, plan.ID)
 	return err
 }
 
 func (s *Service) getPlanByID(ctx context.Context, id string) (*Plan, error) {
 	query := `
-		SELECT id, name, description, price, currency, billing_cycle, features,
-			max_usage_per_day, max_usage_per_month, is_active, created_at, updated_at
+		SELECT ` + planColumns + `
 		FROM plans WHERE id = $1
 	`
+	return scanPlan(s.db.QueryRowNamed(ctx, "plan_by_id", query, id))
+}
+
+// getPlansByIDs loads the given plans in a single query, keyed by ID.
+// Missing IDs, and ones that aren't UUIDs, are simply absent from the result.
+func (s *Service) getPlansByIDs(ctx context.Context, ids []string) (map[string]Plan, error) {
+	ids = uuidsOnly(ids)
+	if len(ids) == 0 {
+		return map[string]Plan{}, nil
+	}
+
+	// Comparing the uuid column itself keeps its primary key index in use
+	query := `
+		SELECT ` + planColumns + `
+		FROM plans WHERE id = ANY($1::uuid[])
+	`
+	rows, err := s.db.QueryContext(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := make(map[string]Plan, len(ids))
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans[plan.ID] = *plan
+	}
+
+	return plans, rows.Err()
+}
+
+// uuidsOnly drops the IDs that aren't UUIDs, which no plan has and which
+// would fail a query casting them
+func uuidsOnly(ids []string) []string {
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err == nil {
+			valid = append(valid, id)
+		}
+	}
+	return valid
+}
+
+// planColumns lists the columns scanPlan expects, in order
+const planColumns = `id, name, description, price, currency, billing_cycle, plan_type, features,
+	max_usage_per_day, max_usage_per_month, grace_period_days, is_active, display_order, badge,
+	version, created_at, updated_at, billing_interval, billing_anchor_day, usage_rollover_cap,
+	usage_overage_percent, marketing_bullets, cta_text, parent_plan_id, plan_effective_features(id)`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPlan reads a plan selected with planColumns
+func scanPlan(row rowScanner) (*Plan, error) {
 	var plan Plan
-	var featuresBytes []byte
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
+	var featuresBytes, bulletsBytes, effectiveBytes []byte
+	err := row.Scan(
 		&plan.ID, &plan.Name, &plan.Description, &plan.Price, &plan.Currency,
-		&plan.BillingCycle, &featuresBytes, &plan.MaxUsagePerDay, &plan.MaxUsagePerMonth,
-		&plan.IsActive, &plan.CreatedAt, &plan.UpdatedAt)
+		&plan.BillingCycle, &plan.Type, &featuresBytes, &plan.MaxUsagePerDay, &plan.MaxUsagePerMonth,
+		&plan.GracePeriodDays, &plan.IsActive, &plan.DisplayOrder, &plan.Badge, &plan.Version,
+		&plan.CreatedAt, &plan.UpdatedAt, &plan.BillingInterval, &plan.BillingAnchorDay,
+		&plan.RolloverCap, &plan.OveragePercent, &bulletsBytes, &plan.CTAText, &plan.ParentPlanID,
+		&effectiveBytes)
 	if err != nil {
 		return nil, err
 	}
+	if err := json.Unmarshal(effectiveBytes, &plan.EffectiveFeatures); err != nil {
+		return nil, fmt.Errorf("failed to parse effective features JSON: %w", err)
+	}
+
+	plan.MarketingBullets = []string{}
+	if err := json.Unmarshal(bulletsBytes, &plan.MarketingBullets); err != nil {
+		return nil, fmt.Errorf("failed to parse marketing bullets JSON: %w", err)
+	}
 
 	// Parse features JSON if not null
 	if featuresBytes != nil {
@@ -643,28 +1247,60 @@ func (s *Service) updatePlan(ctx context.Context, plan *Plan) error {
 		}
 	}
 
+	bullets, err := encodeBullets(plan.MarketingBullets)
+	if err != nil {
+		return err
+	}
+
+	// Compare-and-set on version so a concurrent update is never overwritten
 	query := `
 		UPDATE plans 
 		SET name = $1, description = $2, price = $3, currency = $4, billing_cycle = $5,
 			features = $6, max_usage_per_day = $7, max_usage_per_month = $8, 
-			is_active = $9, updated_at = $10
-		WHERE id = $11
+			grace_period_days = $9, is_active = $10, updated_at = $11, display_order = $14,
+			badge = $15, billing_interval = $16, billing_anchor_day = $17, usage_rollover_cap = $18,
+			usage_overage_percent = $19, marketing_bullets = $20, cta_text = $21, parent_plan_id = $22,
+			version = version + 1
+		WHERE id = $12 AND version = $13
 	`
-	_, err = s.db.ExecContext(ctx, query, plan.Name, plan.Description, plan.Price,
+	result, err := s.db.ExecContext(ctx, query, plan.Name, plan.Description, plan.Price,
 		plan.Currency, plan.BillingCycle, featuresBytes, plan.MaxUsagePerDay,
-		plan.MaxUsagePerMonth, plan.IsActive, plan.UpdatedAt, plan.ID)
+		plan.MaxUsagePerMonth, plan.GracePeriodDays, plan.IsActive, plan.UpdatedAt, plan.ID,
+		plan.Version, plan.DisplayOrder, plan.Badge, plan.BillingInterval, plan.BillingAnchorDay,
+		plan.RolloverCap, plan.OveragePercent, bullets, plan.CTAText, plan.ParentPlanID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVersionConflict
+	}
+	plan.Version++
+	s.invalidateActivePlans(ctx)
+	// Plans inheriting from this one may now grant something else
+	s.removeCachedDescendants(ctx, plan.ID)
+	plan.EffectiveFeatures, err = s.effectiveFeatures(ctx, plan.ID)
 	return err
 }
 
 func (s *Service) deletePlan(ctx context.Context, id string) error {
 	query := `DELETE FROM plans WHERE id = $1`
 	_, err := s.db.ExecContext(ctx, query, id)
+	if err == nil {
+		s.invalidateActivePlans(ctx)
+	}
 	return err
 }
 
 func (s *Service) listPlans(ctx context.Context, page, limit int, activeOnly bool) ([]Plan, int, error) {
 	offset := (page - 1) * limit
 
+	// Listings tolerate replication lag, so read from a replica
+	reader := s.db.Reader()
+
 	// Build WHERE clause and args
 	var whereClause string
 	var args []interface{}
@@ -676,7 +1312,7 @@ func (s *Service) listPlans(ctx context.Context, page, limit int, activeOnly boo
 	// Get total count
 	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM plans %s", whereClause)
 	var total int
-	err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&total)
+	err := reader.QueryRowContext(ctx, countQuery, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -685,25 +1321,23 @@ func (s *Service) listPlans(ctx context.Context, page, limit int, activeOnly boo
 	var query string
 	if activeOnly {
 		query = `
-			SELECT id, name, description, price, currency, billing_cycle, features,
-				max_usage_per_day, max_usage_per_month, is_active, created_at, updated_at
+			SELECT ` + planColumns + `
 			FROM plans 
 			WHERE is_active = true
-			ORDER BY created_at DESC
+			ORDER BY created_at DESC, id DESC
 			LIMIT $1 OFFSET $2
 		`
 	} else {
 		query = `
-			SELECT id, name, description, price, currency, billing_cycle, features,
-				max_usage_per_day, max_usage_per_month, is_active, created_at, updated_at
+			SELECT ` + planColumns + `
 			FROM plans 
-			ORDER BY created_at DESC
+			ORDER BY created_at DESC, id DESC
 			LIMIT $1 OFFSET $2
 		`
 	}
 
 	args = []interface{}{limit, offset}
-	rows, err := s.db.QueryContext(ctx, query, args...)
+	rows, err := reader.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -711,33 +1345,56 @@ func (s *Service) listPlans(ctx context.Context, page, limit int, activeOnly boo
 
 	var plans []Plan
 	for rows.Next() {
-		var plan Plan
-		var featuresBytes []byte
-		err := rows.Scan(
-			&plan.ID, &plan.Name, &plan.Description, &plan.Price, &plan.Currency,
-			&plan.BillingCycle, &featuresBytes, &plan.MaxUsagePerDay, &plan.MaxUsagePerMonth,
-			&plan.IsActive, &plan.CreatedAt, &plan.UpdatedAt)
+		plan, err := scanPlan(rows)
 		if err != nil {
 			return nil, 0, err
 		}
+		plans = append(plans, *plan)
+	}
 
-		// Parse features JSON if not null
-		if featuresBytes != nil {
-			if err := json.Unmarshal(featuresBytes, &plan.Features); err != nil {
-				return nil, 0, fmt.Errorf("failed to parse features JSON: %w", err)
-			}
-		}
+	return plans, total, nil
+}
 
-		plans = append(plans, plan)
+// listPlansAfter returns up to limit plans created before the cursor position,
+// plus the cursor for the following page (empty when no more plans remain)
+func (s *Service) listPlansAfter(ctx context.Context, cursor *db.Cursor, limit int, activeOnly bool) ([]Plan, string, error) {
+	query := `
+		SELECT ` + planColumns + `
+		FROM plans
+		WHERE (created_at, id) < ($1, $2) AND ($3 = false OR is_active = true)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := s.db.Reader().QueryContext(ctx, query, cursor.CreatedAt, cursor.ID, activeOnly, limit+1)
+	if err != nil {
+		return nil, "", err
 	}
+	defer rows.Close()
 
-	return plans, total, nil
+	var plans []Plan
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		plans = append(plans, *plan)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	plans, nextCursor := db.NextPage(plans, limit, func(row Plan) db.Cursor {
+		return db.Cursor{CreatedAt: row.CreatedAt, ID: row.ID}
+	})
+
+	return plans, nextCursor, nil
 }
 
 func (s *Service) getActivePlans(ctx context.Context) ([]Plan, error) {
 	query := `
-		SELECT id, name, description, price, currency, billing_cycle, features,
-			max_usage_per_day, max_usage_per_month, is_active, created_at, updated_at
+		SELECT ` + planColumns + `
 		FROM plans 
 		WHERE is_active = true
 		ORDER BY price ASC, created_at ASC
@@ -750,24 +1407,11 @@ func (s *Service) getActivePlans(ctx context.Context) ([]Plan, error) {
 
 	var plans []Plan
 	for rows.Next() {
-		var plan Plan
-		var featuresBytes []byte
-		err := rows.Scan(
-			&plan.ID, &plan.Name, &plan.Description, &plan.Price, &plan.Currency,
-			&plan.BillingCycle, &featuresBytes, &plan.MaxUsagePerDay, &plan.MaxUsagePerMonth,
-			&plan.IsActive, &plan.CreatedAt, &plan.UpdatedAt)
+		plan, err := scanPlan(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		// Parse features JSON if not null
-		if featuresBytes != nil {
-			if err := json.Unmarshal(featuresBytes, &plan.Features); err != nil {
-				return nil, fmt.Errorf("failed to parse features JSON: %w", err)
-			}
-		}
-
-		plans = append(plans, plan)
+		plans = append(plans, *plan)
 	}
 
 	return plans, nil
@@ -780,6 +1424,15 @@ func (s *Service) planNameExists(ctx context.Context, name string) (bool, error)
 	return exists, err
 }
 
+// activeFreePlanExists reports whether an active free plan other than
+// excludeID exists; at most one may be active at a time.
+func (s *Service) activeFreePlanExists(ctx context.Context, excludeID string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM plans WHERE plan_type = 'free' AND is_active AND id::text <> $1)`
+	var exists bool
+	err := s.db.QueryRowContext(ctx, query, excludeID).Scan(&exists)
+	return exists, err
+}
+
 func (s *Service) planHasActiveSubscriptions(ctx context.Context, planID string) (bool, error) {
 	query := `SELECT EXISTS(SELECT 1 FROM subscriptions WHERE plan_id = $1 AND status = 'active')`
 	var exists bool
@@ -787,55 +1440,30 @@ func (s *Service) planHasActiveSubscriptions(ctx context.Context, planID string)
 	return exists, err
 }
 
-// Caching methods
-func (s *Service) cachePlan(ctx context.Context, plan *Plan) {
-	key := fmt.Sprintf("plan:%s", plan.ID)
-	data, err := json.Marshal(plan)
-	if err != nil {
-		logrus.Errorf("Failed to marshal plan for cache: %v", err)
-		return
-	}
-
-	// Cache for 1 hour
-	if err := s.cache.Set(ctx, key, string(data), time.Hour); err != nil {
-		logrus.Errorf("Failed to cache plan: %v", err)
-	}
+// Caching
+const (
+	// planCacheTTL is how long a plan read by ID stays cached; writes
+	// refresh or drop it
+	planCacheTTL = time.Hour
+	// missingPlanTTL is how long an unknown plan ID is remembered
+	missingPlanTTL = time.Minute
+)
 
-	// Invalidate active plans cache
-	s.cache.Del(ctx, "plans:active")
+func newPlanCache(redis *cache.RedisClient) *cache.ReadThrough[*Plan] {
+	return cache.NewReadThrough(redis, cache.ReadThroughOptions[*Plan]{
+		Domain:      "plan",
+		Prefix:      "plan",
+		TTL:         planCacheTTL,
+		NegativeTTL: missingPlanTTL,
+	})
 }
 
-func (s *Service) getCachedPlan(ctx context.Context, id string) (*Plan, error) {
-	key := fmt.Sprintf("plan:%s", id)
-	data, err := s.cache.Get(ctx, key)
-	if err != nil {
-		return nil, err
-	}
-
-	var plan Plan
-	if err := json.Unmarshal([]byte(data), &plan); err != nil {
-		return nil, err
-	}
-	return &plan, nil
+func (s *Service) cachePlan(ctx context.Context, plan *Plan) {
+	s.plans.Set(ctx, plan.ID, plan)
 }
 
 func (s *Service) removeCachedPlan(ctx context.Context, id string) {
-	key := fmt.Sprintf("plan:%s", id)
-	s.cache.Del(ctx, key)
-	s.cache.Del(ctx, "plans:active")
-}
-
-func (s *Service) cacheActivePlans(ctx context.Context, plans []Plan) {
-	data, err := json.Marshal(plans)
-	if err != nil {
-		logrus.Errorf("Failed to marshal active plans for cache: %v", err)
-		return
-	}
-
-	// Cache for 30 minutes
-	if err := s.cache.Set(ctx, "plans:active", string(data), 30*time.Minute); err != nil {
-		logrus.Errorf("Failed to cache active plans: %v", err)
-	}
+	s.plans.Forget(ctx, id)
 }
 
 // Utility functions
@@ -851,6 +1479,54 @@ func parseInt(s string) (int, error) {
 	return i, err
 }
 
+// newValidator returns a validator that understands decimal amounts
+func newValidator() *validator.Validate {
+	v := validator.New()
+	money.RegisterValidation(v)
+	return v
+}
+
+// validateBillingPeriod checks a plan's period length and anchor day. Only
+// monthly and yearly periods can be aligned to a day of the month.
+func validateBillingPeriod(cycle string, interval, anchorDay *int) error {
+	if interval != nil && (*interval < 1 || *interval > 52) {
+		return errors.New("billing_interval must be between 1 and 52")
+	}
+	if anchorDay == nil {
+		return nil
+	}
+	if *anchorDay < 1 || *anchorDay > 31 {
+		return errors.New("billing_anchor_day must be between 1 and 31")
+	}
+	if cycle != "monthly" && cycle != "yearly" {
+		return fmt.Errorf("billing_anchor_day needs a monthly or yearly billing_cycle, not %s", cycle)
+	}
+	return nil
+}
+
+// validateUsagePolicy checks a plan's usage rollover cap and overage grace
+func validateUsagePolicy(rolloverCap *int, overagePercent int) error {
+	if rolloverCap != nil && *rolloverCap < 0 {
+		return errors.New("usage_rollover_cap must not be negative")
+	}
+	if overagePercent < 0 || overagePercent > 100 {
+		return errors.New("usage_overage_percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// validatePlanPrice checks a plan's price against its type: free plans cost
+// nothing, and paid plans something, or they would activate without a charge
+func validatePlanPrice(planType string, price decimal.Decimal) error {
+	if planType == PlanTypeFree && !price.IsZero() {
+		return errors.New("Free plans must have a price of 0")
+	}
+	if planType == PlanTypePaid && !price.IsPositive() {
+		return errors.New("Paid plans must have a price above 0")
+	}
+	return nil
+}
+
 // validatePlanRequest validates the plan request and returns detailed error messages
 func (s *Service) validatePlanRequest(req interface{}) error {
 	if err := s.validator.Struct(req); err != nil {
@@ -862,6 +1538,8 @@ func (s *Service) validatePlanRequest(req interface{}) error {
 					errorMessages = append(errorMessages, fmt.Sprintf("%s is required", fieldError.Field()))
 				case "min":
 					errorMessages = append(errorMessages, fmt.Sprintf("%s must be at least %s", fieldError.Field(), fieldError.Param()))
+				case "max":
+					errorMessages = append(errorMessages, fmt.Sprintf("%s must be at most %s", fieldError.Field(), fieldError.Param()))
 				case "len":
 					errorMessages = append(errorMessages, fmt.Sprintf("%s must be exactly %s characters", fieldError.Field(), fieldError.Param()))
 				case "oneof":
@@ -874,53 +1552,80 @@ func (s *Service) validatePlanRequest(req interface{}) error {
 		}
 		return err
 	}
+	if create, ok := req.(CreatePlanRequest); ok {
+		if err := validatePlanPrice(create.planType(), create.Price); err != nil {
+			return fmt.Errorf("validation failed: %v", err)
+		}
+	}
 	return nil
 }
 
-// generatePlanComparison creates a detailed comparison of plans
-func (s *Service) generatePlanComparison(plans []Plan) PlanComparison {
+// generatePlanComparison creates a detailed comparison of plans. When rates
+// is non-nil, prices are normalized into base, the configured base currency,
+// before they are compared; otherwise all plans must share one currency.
+func (s *Service) generatePlanComparison(plans []Plan, rates *fx.Rates, base string) (PlanComparison, error) {
 	comparison := PlanComparison{
 		Plans: make([]PlanComparisonItem, 0, len(plans)),
 	}
 
-	var minPrice, maxPrice float64
+	currency := ""
+	if len(plans) > 0 {
+		currency = plans[0].Currency
+	}
+	if rates != nil {
+		currency = base
+	}
+
+	var minPrice, maxPrice decimal.Decimal
 	var cheapestPlan, mostExpensivePlan string
+	comparableCostPerFeature := make([]decimal.Decimal, 0, len(plans))
 
 	for i, plan := range plans {
 		// Calculate monthly cost
 		monthlyCost := s.calculateMonthlyCost(plan)
 		yearlyCost := s.calculateYearlyCost(plan)
 
+		analysis := PriceAnalysis{
+			MonthlyCost:    monthlyCost,
+			YearlyCost:     yearlyCost,
+			CostPerFeature: s.calculateCostPerFeature(plan, monthlyCost),
+		}
+
+		// Compare in the base currency when currencies differ
+		comparableCost := monthlyCost
+		if rates != nil {
+			converted, rate, err := rates.Convert(monthlyCost, plan.Currency, base)
+			if err != nil {
+				return PlanComparison{}, fmt.Errorf("plan %s: %w", plan.ID, err)
+			}
+			comparableCost = converted
+			analysis.BaseMonthlyCost = &converted
+			analysis.ExchangeRate = &rate
+		}
+		baseCurrencyPlan := plan
+		baseCurrencyPlan.Currency = currency
+		comparableCostPerFeature = append(comparableCostPerFeature, s.calculateCostPerFeature(baseCurrencyPlan, comparableCost))
+
 		// Track price extremes
-		if i == 0 || monthlyCost < minPrice {
-			minPrice = monthlyCost
+		if i == 0 || comparableCost.LessThan(minPrice) {
+			minPrice = comparableCost
 			cheapestPlan = plan.Name
 		}
-		if i == 0 || monthlyCost > maxPrice {
-			maxPrice = monthlyCost
+		if i == 0 || comparableCost.GreaterThan(maxPrice) {
+			maxPrice = comparableCost
 			mostExpensivePlan = plan.Name
 		}
 
-		// Create feature matrix
-		featureMatrix := s.createFeatureMatrix(plan)
-
-		// Calculate cost per feature
-		costPerFeature := s.calculateCostPerFeature(plan, monthlyCost)
-
 		item := PlanComparisonItem{
 			Plan:          plan,
-			FeatureMatrix: featureMatrix,
-			PriceAnalysis: PriceAnalysis{
-				MonthlyCost:    monthlyCost,
-				YearlyCost:     yearlyCost,
-				CostPerFeature: costPerFeature,
-			},
+			FeatureMatrix: s.createFeatureMatrix(plan),
+			PriceAnalysis: analysis,
 		}
 
 		// Add recommendation
-		if monthlyCost == minPrice {
+		if comparableCost.Equal(minPrice) {
 			item.Recommendation = "Best value for money"
-		} else if len(plan.Features) > 5 {
+		} else if len(plan.features()) > 5 {
 			item.Recommendation = "Feature-rich option"
 		}
 
@@ -931,53 +1636,91 @@ func (s *Service) generatePlanComparison(plans []Plan) PlanComparison {
 	comparison.Summary = PlanComparisonSummary{
 		CheapestPlan:      cheapestPlan,
 		MostExpensivePlan: mostExpensivePlan,
-		PriceRange:        maxPrice - minPrice,
+		PriceRange:        maxPrice.Sub(minPrice),
+		Currency:          currency,
+	}
+	if rates != nil {
+		asOf := rates.AsOf
+		comparison.Summary.RatesAsOf = &asOf
 	}
 
 	// Determine best value plan (lowest cost per feature)
 	var bestValuePlan string
-	var lowestCostPerFeature float64
-	for _, item := range comparison.Plans {
-		if item.PriceAnalysis.CostPerFeature > 0 &&
-			(bestValuePlan == "" || item.PriceAnalysis.CostPerFeature < lowestCostPerFeature) {
+	var lowestCostPerFeature decimal.Decimal
+	for i, item := range comparison.Plans {
+		costPerFeature := comparableCostPerFeature[i]
+		if costPerFeature.IsPositive() &&
+			(bestValuePlan == "" || costPerFeature.LessThan(lowestCostPerFeature)) {
 			bestValuePlan = item.Plan.Name
-			lowestCostPerFeature = item.PriceAnalysis.CostPerFeature
+			lowestCostPerFeature = costPerFeature
 		}
 	}
 	comparison.Summary.BestValuePlan = bestValuePlan
 
-	return comparison
+	return comparison, nil
+}
+
+// mixedCurrencies reports whether plans are priced in more than one currency
+func mixedCurrencies(plans []Plan) bool {
+	for _, plan := range plans[1:] {
+		if !strings.EqualFold(plan.Currency, plans[0].Currency) {
+			return true
+		}
+	}
+	return false
 }
 
+// Average periods per month and per year used to normalize billing cycles
+var (
+	weeksPerMonth = decimal.RequireFromString("4.33")
+	daysPerMonth  = decimal.RequireFromString("30.44")
+	monthsPerYear = decimal.NewFromInt(12)
+	weeksPerYear  = decimal.NewFromInt(52)
+	daysPerYear   = decimal.NewFromInt(365)
+)
+
 // Helper methods for plan comparison
-func (s *Service) calculateMonthlyCost(plan Plan) float64 {
+func (s *Service) calculateMonthlyCost(plan Plan) decimal.Decimal {
+	var cost decimal.Decimal
 	switch plan.BillingCycle {
 	case "monthly":
-		return plan.Price
+		cost = plan.Price
 	case "yearly":
-		return plan.Price / 12
+		cost = plan.Price.Div(monthsPerYear)
 	case "weekly":
-		return plan.Price * 4.33 // Average weeks per month
+		cost = plan.Price.Mul(weeksPerMonth)
 	case "daily":
-		return plan.Price * 30.44 // Average days per month
+		cost = plan.Price.Mul(daysPerMonth)
 	default:
-		return plan.Price
+		cost = plan.Price
 	}
+	return money.Round(perInterval(cost, plan), plan.Currency)
 }
 
-func (s *Service) calculateYearlyCost(plan Plan) float64 {
+func (s *Service) calculateYearlyCost(plan Plan) decimal.Decimal {
+	var cost decimal.Decimal
 	switch plan.BillingCycle {
 	case "yearly":
-		return plan.Price
+		cost = plan.Price
 	case "monthly":
-		return plan.Price * 12
+		cost = plan.Price.Mul(monthsPerYear)
 	case "weekly":
-		return plan.Price * 52
+		cost = plan.Price.Mul(weeksPerYear)
 	case "daily":
-		return plan.Price * 365
+		cost = plan.Price.Mul(daysPerYear)
 	default:
-		return plan.Price * 12
+		cost = plan.Price.Mul(monthsPerYear)
 	}
+	return money.Round(perInterval(cost, plan), plan.Currency)
+}
+
+// perInterval spreads a cost priced per billing cycle over a plan billed
+// every several cycles
+func perInterval(cost decimal.Decimal, plan Plan) decimal.Decimal {
+	if plan.BillingInterval <= 1 {
+		return cost
+	}
+	return cost.Div(decimal.NewFromInt(int64(plan.BillingInterval)))
 }
 
 func (s *Service) createFeatureMatrix(plan Plan) map[string]interface{} {
@@ -987,10 +1730,22 @@ func (s *Service) createFeatureMatrix(plan Plan) map[string]interface{} {
 	matrix["name"] = plan.Name
 	matrix["price"] = plan.Price
 	matrix["billing_cycle"] = plan.BillingCycle
-
-	// Add features
-	if plan.Features != nil {
-		for key, value := range plan.Features {
+	matrix["billing_interval"] = plan.BillingInterval
+
+	// Add features, inherited ones included. With a catalog every plan gets
+	// a row for every catalog feature, so plans that leave one unset still
+	// line up.
+	features := plan.features()
+	if !s.catalog.empty() {
+		for _, def := range s.catalog.Definitions() {
+			value, ok := features[def.Key]
+			if !ok {
+				value = def.zero()
+			}
+			matrix[def.Key] = value
+		}
+	} else if features != nil {
+		for key, value := range features {
 			matrix[key] = value
 		}
 	}
@@ -1006,14 +1761,15 @@ func (s *Service) createFeatureMatrix(plan Plan) map[string]interface{} {
 	return matrix
 }
 
-func (s *Service) calculateCostPerFeature(plan Plan, monthlyCost float64) float64 {
-	if plan.Features == nil || len(plan.Features) == 0 {
+func (s *Service) calculateCostPerFeature(plan Plan, monthlyCost decimal.Decimal) decimal.Decimal {
+	features := plan.features()
+	if len(features) == 0 {
 		return monthlyCost
 	}
 
 	// Count boolean features that are true
 	featureCount := 0
-	for _, value := range plan.Features {
+	for _, value := range features {
 		if boolValue, ok := value.(bool); ok && boolValue {
 			featureCount++
 		}
@@ -1023,18 +1779,19 @@ func (s *Service) calculateCostPerFeature(plan Plan, monthlyCost float64) float6
 		return monthlyCost
 	}
 
-	return monthlyCost / float64(featureCount)
+	return money.Round(monthlyCost.Div(decimal.NewFromInt(int64(featureCount))), plan.Currency)
 }
 
-// generatePlanAnalytics creates comprehensive analytics for a plan
-func (s *Service) generatePlanAnalytics(ctx context.Context, plan *Plan) (*PlanAnalytics, error) {
+// generatePlanAnalytics creates comprehensive analytics for a plan.
+// Analytics queries are served from read replicas when configured.
+func (s *Service) generatePlanAnalytics(ctx context.Context, plan *Plan, from, to time.Time) (*PlanAnalytics, error) {
 	analytics := &PlanAnalytics{
 		PlanID:   plan.ID,
 		PlanName: plan.Name,
 	}
 
 	// Get usage statistics
-	usageStats, err := s.getUsageStatistics(ctx, plan.ID)
+	usageStats, err := s.getUsageStatistics(ctx, plan.ID, from, to)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get usage statistics: %w", err)
 	}
@@ -1060,11 +1817,22 @@ func (s *Service) generatePlanAnalytics(ctx context.Context, plan *Plan) (*PlanA
 	return analytics, nil
 }
 
-// getUsageStatistics retrieves usage statistics for a plan
-func (s *Service) getUsageStatistics(ctx context.Context, planID string) (*UsageStatistics, error) {
+// getUsageStatistics retrieves usage statistics for a plan over [from, to).
+// The result is cached, since the ledger aggregation scans every usage
+// entry in the range.
+func (s *Service) getUsageStatistics(ctx context.Context, planID string, from, to time.Time) (*UsageStatistics, error) {
+	ctx = db.WithQueryName(ctx, "plan_usage_statistics")
+
+	stats, _, err := s.usage.Get(ctx, usageCacheID(planID, from, to), func(ctx context.Context) (*UsageStatistics, error) {
+		return s.loadUsageStatistics(ctx, planID, from, to)
+	})
+	return stats, err
+}
+
+func (s *Service) loadUsageStatistics(ctx context.Context, planID string, from, to time.Time) (*UsageStatistics, error) {
 	// Get subscription counts
 	var totalSubs, activeSubs int
-	err := s.db.QueryRowContext(ctx, `
+	err := s.db.Reader().QueryRowContext(ctx, `
 		SELECT 
 			COUNT(*) as total_subscriptions,
 			COUNT(CASE WHEN status = 'active' THEN 1 END) as active_subscriptions
@@ -1075,25 +1843,21 @@ func (s *Service) getUsageStatistics(ctx context.Context, planID string) (*Usage
 		return nil, err
 	}
 
-	// Get average usage (simplified - in real implementation, you'd query usage_logs)
-	avgUsagePerDay := 0.0
-	avgUsagePerMonth := 0.0
-	if activeSubs > 0 {
-		// This is a placeholder - real implementation would calculate from usage_logs
-		avgUsagePerDay = 50.0
-		avgUsagePerMonth = 1500.0
+	buckets, subscribers, err := s.getDailyUsage(ctx, planID, from, to)
+	if err != nil {
+		return nil, err
 	}
 
-	return &UsageStatistics{
-		TotalSubscriptions:   totalSubs,
-		ActiveSubscriptions:  activeSubs,
-		AverageUsagePerDay:   avgUsagePerDay,
-		AverageUsagePerMonth: avgUsagePerMonth,
-	}, nil
+	stats := summarizeUsage(buckets, subscribers, from, to)
+	stats.TotalSubscriptions = totalSubs
+	stats.ActiveSubscriptions = activeSubs
+	return &stats, nil
 }
 
 // getPopularityMetrics retrieves popularity metrics for a plan
 func (s *Service) getPopularityMetrics(ctx context.Context, planID string) (*PopularityMetrics, error) {
+	ctx = db.WithQueryName(ctx, "plan_popularity")
+
 	// Calculate subscription growth (simplified)
 	growth := 0.0
 	retentionRate := 0.0
@@ -1101,7 +1865,7 @@ func (s *Service) getPopularityMetrics(ctx context.Context, planID string) (*Pop
 
 	// Get subscription counts for different time periods
 	var currentMonth, lastMonth int
-	err := s.db.QueryRowContext(ctx, `
+	err := s.db.Reader().QueryRowContext(ctx, `
 		SELECT 
 			COUNT(CASE WHEN created_at >= date_trunc('month', CURRENT_DATE) THEN 1 END) as current_month,
 			COUNT(CASE WHEN created_at >= date_trunc('month', CURRENT_DATE - INTERVAL '1 month') 
@@ -1134,23 +1898,19 @@ func (s *Service) getPopularityMetrics(ctx context.Context, planID string) (*Pop
 	}, nil
 }
 
-// getPerformanceMetrics retrieves performance metrics for a plan
+// getPerformanceMetrics retrieves performance metrics for a plan. Revenue
+// collected in several currencies is reported in the base currency.
 func (s *Service) getPerformanceMetrics(ctx context.Context, planID string) (*PerformanceMetrics, error) {
-	// Get revenue generated - fix column ambiguity by specifying table alias
-	var revenue float64
-	err := s.db.QueryRowContext(ctx, `
-		SELECT COALESCE(SUM(pt.amount), 0)
-		FROM payment_transactions pt
-		JOIN subscriptions s ON pt.subscription_id = s.id
-		WHERE s.plan_id = $1 AND pt.status = 'completed'
-	`, planID).Scan(&revenue)
+	ctx = db.WithQueryName(ctx, "plan_performance")
+
+	revenue, revenueCurrency, ratesAsOf, err := s.getRevenue(ctx, planID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Calculate average lifetime (simplified)
 	avgLifetime := 0.0
-	err = s.db.QueryRowContext(ctx, `
+	err = s.db.Reader().QueryRowContext(ctx, `
 		SELECT COALESCE(AVG(EXTRACT(EPOCH FROM (end_date - start_date))/86400), 0)
 		FROM subscriptions 
 		WHERE plan_id = $1 AND status IN ('cancelled', 'expired')
@@ -1161,11 +1921,82 @@ func (s *Service) getPerformanceMetrics(ctx context.Context, planID string) (*Pe
 
 	return &PerformanceMetrics{
 		RevenueGenerated: revenue,
+		RevenueCurrency:  revenueCurrency,
+		RatesAsOf:        ratesAsOf,
 		AverageLifetime:  avgLifetime,
 		ConversionRate:   0.0, // Placeholder
 	}, nil
 }
 
+// getRevenue sums completed payments for a plan, converting each currency's
+// total into the base currency when more than one currency is involved.
+func (s *Service) getRevenue(ctx context.Context, planID string) (float64, string, *time.Time, error) {
+	rows, err := s.db.Reader().QueryContext(ctx, `
+		SELECT pt.currency, COALESCE(SUM(pt.amount), 0)
+		FROM payment_transactions pt
+		JOIN subscriptions s ON pt.subscription_id = s.id
+		WHERE s.plan_id = $1 AND pt.status = 'completed'
+		GROUP BY pt.currency
+	`, planID)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]decimal.Decimal)
+	for rows.Next() {
+		var currency string
+		var amount decimal.Decimal
+		if err := rows.Scan(&currency, &amount); err != nil {
+			return 0, "", nil, err
+		}
+		totals[strings.ToUpper(currency)] = amount
+	}
+	if err := rows.Err(); err != nil {
+		return 0, "", nil, err
+	}
+
+	switch {
+	case len(totals) == 0:
+		return 0, "", nil, nil
+	case len(totals) == 1:
+		for currency, amount := range totals {
+			revenue, _ := amount.Float64()
+			return revenue, currency, nil, nil
+		}
+	}
+
+	if s.fx == nil {
+		return 0, "", nil, errors.New("revenue spans several currencies and no exchange rate service is configured")
+	}
+	rates, err := s.fx.Rates(ctx)
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("failed to load exchange rates: %w", err)
+	}
+
+	base := s.fx.BaseCurrency()
+	total, err := sumInCurrency(totals, rates, base)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	revenue, _ := total.Float64()
+	asOf := rates.AsOf
+	return revenue, base, &asOf, nil
+}
+
+// sumInCurrency adds up amounts in several currencies, converted into one
+func sumInCurrency(totals map[string]decimal.Decimal, rates *fx.Rates, currency string) (decimal.Decimal, error) {
+	total := decimal.Zero
+	for from, amount := range totals {
+		converted, _, err := rates.Convert(amount, from, currency)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		total = total.Add(converted)
+	}
+	return total, nil
+}
+
 // generateRecommendations creates actionable recommendations based on analytics
 func (s *Service) generateRecommendations(analytics *PlanAnalytics) []string {
 	var recommendations []string
@@ -4,7 +4,9 @@ import (
 	"testing"
 	"time"
 
-	"github.com/go-playground/validator/v10"
+	"scalable-paywall/internal/fx"
+
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,7 +15,7 @@ func TestPlanComparison(t *testing.T) {
 	basicPlan := &Plan{
 		ID:           "plan_basic",
 		Name:         "Basic Plan",
-		Price:        9.99,
+		Price:        decimal.RequireFromString("9.99"),
 		Currency:     "USD",
 		BillingCycle: "monthly",
 		Features: map[string]interface{}{
@@ -30,7 +32,7 @@ func TestPlanComparison(t *testing.T) {
 	proPlan := &Plan{
 		ID:           "plan_pro",
 		Name:         "Pro Plan",
-		Price:        19.99,
+		Price:        decimal.RequireFromString("19.99"),
 		Currency:     "USD",
 		BillingCycle: "monthly",
 		Features: map[string]interface{}{
@@ -48,7 +50,7 @@ func TestPlanComparison(t *testing.T) {
 	enterprisePlan := &Plan{
 		ID:           "plan_enterprise",
 		Name:         "Enterprise Plan",
-		Price:        49.99,
+		Price:        decimal.RequireFromString("49.99"),
 		Currency:     "USD",
 		BillingCycle: "yearly",
 		Features: map[string]interface{}{
@@ -73,9 +75,9 @@ func TestPlanComparison(t *testing.T) {
 		proMonthly := service.calculateMonthlyCost(*proPlan)
 		enterpriseMonthly := service.calculateMonthlyCost(*enterprisePlan)
 
-		assert.Equal(t, 9.99, basicMonthly)
-		assert.Equal(t, 19.99, proMonthly)
-		assert.Equal(t, 49.99/12, enterpriseMonthly)
+		assert.Equal(t, "9.99", basicMonthly.String())
+		assert.Equal(t, "19.99", proMonthly.String())
+		assert.Equal(t, "4.17", enterpriseMonthly.String()) // 49.99/12 rounded to cents
 	})
 
 	// Test yearly cost calculation
@@ -86,9 +88,9 @@ func TestPlanComparison(t *testing.T) {
 		proYearly := service.calculateYearlyCost(*proPlan)
 		enterpriseYearly := service.calculateYearlyCost(*enterprisePlan)
 
-		assert.Equal(t, 9.99*12, basicYearly)
-		assert.Equal(t, 19.99*12, proYearly)
-		assert.Equal(t, 49.99, enterpriseYearly)
+		assert.Equal(t, "119.88", basicYearly.String())
+		assert.Equal(t, "239.88", proYearly.String())
+		assert.Equal(t, "49.99", enterpriseYearly.String())
 	})
 
 	// Test feature matrix creation
@@ -98,7 +100,7 @@ func TestPlanComparison(t *testing.T) {
 		matrix := service.createFeatureMatrix(*proPlan)
 
 		assert.Equal(t, "Pro Plan", matrix["name"])
-		assert.Equal(t, 19.99, matrix["price"])
+		assert.Equal(t, "19.99", matrix["price"].(decimal.Decimal).String())
 		assert.Equal(t, "monthly", matrix["billing_cycle"])
 		assert.Equal(t, true, matrix["feature1"])
 		assert.Equal(t, true, matrix["feature2"])
@@ -111,16 +113,48 @@ func TestPlanComparison(t *testing.T) {
 	t.Run("Cost Per Feature Calculation", func(t *testing.T) {
 		service := &Service{}
 
-		basicCostPerFeature := service.calculateCostPerFeature(*basicPlan, 9.99)
-		proCostPerFeature := service.calculateCostPerFeature(*proPlan, 19.99)
-		enterpriseCostPerFeature := service.calculateCostPerFeature(*enterprisePlan, 49.99/12)
+		basicCostPerFeature := service.calculateCostPerFeature(*basicPlan, decimal.RequireFromString("9.99"))
+		proCostPerFeature := service.calculateCostPerFeature(*proPlan, decimal.RequireFromString("19.99"))
+		enterpriseCostPerFeature := service.calculateCostPerFeature(*enterprisePlan, decimal.RequireFromString("4.17"))
 
-		assert.Equal(t, 9.99, basicCostPerFeature)              // Only 1 true feature
-		assert.Equal(t, 19.99/3, proCostPerFeature)             // 3 true features
-		assert.Equal(t, (49.99/12)/5, enterpriseCostPerFeature) // 5 true features
+		assert.Equal(t, "9.99", basicCostPerFeature.String())      // Only 1 true feature
+		assert.Equal(t, "6.66", proCostPerFeature.String())        // 3 true features
+		assert.Equal(t, "0.83", enterpriseCostPerFeature.String()) // 5 true features
 	})
 }
 
+func TestPlanComparisonInConfiguredBase(t *testing.T) {
+	// The provider quotes against EUR while reports are in USD
+	rates := &fx.Rates{
+		Base: "EUR",
+		AsOf: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Rates: map[string]decimal.Decimal{
+			"USD": decimal.RequireFromString("1.10"),
+			"GBP": decimal.RequireFromString("0.80"),
+		},
+	}
+	plans := []Plan{
+		{ID: "eur", Name: "Euro", Price: decimal.RequireFromString("10.00"), Currency: "EUR", BillingCycle: "monthly"},
+		{ID: "gbp", Name: "Pound", Price: decimal.RequireFromString("10.00"), Currency: "GBP", BillingCycle: "monthly"},
+	}
+
+	comparison, err := (&Service{}).generatePlanComparison(plans, rates, "USD")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "USD", comparison.Summary.Currency)
+	assert.Equal(t, "11", comparison.Plans[0].PriceAnalysis.BaseMonthlyCost.String())
+	assert.Equal(t, "13.75", comparison.Plans[1].PriceAnalysis.BaseMonthlyCost.String())
+	assert.Equal(t, "Euro", comparison.Summary.CheapestPlan)
+	assert.Equal(t, "2.75", comparison.Summary.PriceRange.String())
+
+	revenue, err := sumInCurrency(map[string]decimal.Decimal{
+		"EUR": decimal.RequireFromString("100"),
+		"GBP": decimal.RequireFromString("40"),
+	}, rates, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, "165", revenue.String())
+}
+
 func TestPlanAnalytics(t *testing.T) {
 	// Test usage statistics
 	t.Run("Usage Statistics", func(t *testing.T) {
@@ -170,7 +204,7 @@ func TestPlanAnalytics(t *testing.T) {
 
 func TestPlanValidation(t *testing.T) {
 	service := &Service{
-		validator: validator.New(),
+		validator: newValidator(),
 	}
 
 	tests := []struct {
@@ -184,7 +218,7 @@ func TestPlanValidation(t *testing.T) {
 			request: CreatePlanRequest{
 				Name:         "Premium Plan",
 				Description:  stringPtr("A premium plan with all features"),
-				Price:        29.99,
+				Price:        decimal.RequireFromString("29.99"),
 				Currency:     "EUR",
 				BillingCycle: "yearly",
 				Features: map[string]interface{}{
@@ -201,16 +235,27 @@ func TestPlanValidation(t *testing.T) {
 			name: "Valid plan with minimal fields",
 			request: CreatePlanRequest{
 				Name:         "Basic Plan",
-				Price:        9.99,
+				Price:        decimal.RequireFromString("9.99"),
 				Currency:     "USD",
 				BillingCycle: "monthly",
 			},
 			isValid: true,
 		},
+		{
+			name: "Valid free plan",
+			request: CreatePlanRequest{
+				Name:         "Free Plan",
+				Price:        decimal.Zero,
+				Currency:     "USD",
+				BillingCycle: "monthly",
+				Type:         "free",
+			},
+			isValid: true,
+		},
 		{
 			name: "Invalid - missing name",
 			request: CreatePlanRequest{
-				Price:        9.99,
+				Price:        decimal.RequireFromString("9.99"),
 				Currency:     "USD",
 				BillingCycle: "monthly",
 			},
@@ -221,18 +266,41 @@ func TestPlanValidation(t *testing.T) {
 			name: "Invalid - negative price",
 			request: CreatePlanRequest{
 				Name:         "Invalid Plan",
-				Price:        -5.99,
+				Price:        decimal.RequireFromString("-5.99"),
 				Currency:     "USD",
 				BillingCycle: "monthly",
 			},
 			isValid:  false,
 			errorMsg: "Price must be at least 0",
 		},
+		{
+			name: "Invalid - paid plan missing price",
+			request: CreatePlanRequest{
+				Name:         "Invalid Plan",
+				Price:        decimal.Zero,
+				Currency:     "USD",
+				BillingCycle: "monthly",
+			},
+			isValid:  false,
+			errorMsg: "Paid plans must have a price above 0",
+		},
+		{
+			name: "Invalid - priced free plan",
+			request: CreatePlanRequest{
+				Name:         "Invalid Plan",
+				Price:        decimal.RequireFromString("4.99"),
+				Currency:     "USD",
+				BillingCycle: "monthly",
+				Type:         "free",
+			},
+			isValid:  false,
+			errorMsg: "Free plans must have a price of 0",
+		},
 		{
 			name: "Invalid - wrong currency length",
 			request: CreatePlanRequest{
 				Name:         "Invalid Plan",
-				Price:        9.99,
+				Price:        decimal.RequireFromString("9.99"),
 				Currency:     "US",
 				BillingCycle: "monthly",
 			},
@@ -243,7 +311,7 @@ func TestPlanValidation(t *testing.T) {
 			name: "Invalid - invalid billing cycle",
 			request: CreatePlanRequest{
 				Name:         "Invalid Plan",
-				Price:        9.99,
+				Price:        decimal.RequireFromString("9.99"),
 				Currency:     "USD",
 				BillingCycle: "quarterly",
 			},
@@ -254,7 +322,7 @@ func TestPlanValidation(t *testing.T) {
 			name: "Invalid - negative usage limits",
 			request: CreatePlanRequest{
 				Name:             "Invalid Plan",
-				Price:            9.99,
+				Price:            decimal.RequireFromString("9.99"),
 				Currency:         "USD",
 				BillingCycle:     "monthly",
 				MaxUsagePerDay:   intPtr(-10),
@@ -281,13 +349,36 @@ func TestPlanValidation(t *testing.T) {
 	}
 }
 
+func TestUUIDsOnly(t *testing.T) {
+	ids := []string{"6f1c2a9e-3b8d-4c4f-9a51-0e2b7d8c1f43", "basic", "", "6f1c2a9e-3b8d-4c4f-9a51"}
+
+	assert.Equal(t, []string{"6f1c2a9e-3b8d-4c4f-9a51-0e2b7d8c1f43"}, uuidsOnly(ids))
+	assert.Empty(t, uuidsOnly(nil))
+}
+
+func TestValidateBillingPeriod(t *testing.T) {
+	assert.NoError(t, validateBillingPeriod("monthly", intPtr(3), intPtr(1)))
+	assert.NoError(t, validateBillingPeriod("weekly", intPtr(2), nil))
+	assert.Error(t, validateBillingPeriod("monthly", intPtr(0), nil))
+	assert.Error(t, validateBillingPeriod("yearly", nil, intPtr(32)))
+	assert.Error(t, validateBillingPeriod("weekly", nil, intPtr(1)))
+}
+
+func TestCostPerInterval(t *testing.T) {
+	service := &Service{}
+	quarterly := Plan{Price: decimal.RequireFromString("30"), Currency: "USD", BillingCycle: "monthly", BillingInterval: 3}
+
+	assert.Equal(t, "10", service.calculateMonthlyCost(quarterly).String())
+	assert.Equal(t, "120", service.calculateYearlyCost(quarterly).String())
+}
+
 func TestPlanFeatures(t *testing.T) {
 	// Test plan with complex features
 	plan := &Plan{
 		ID:           "plan_advanced",
 		Name:         "Advanced Plan",
 		Description:  stringPtr("Advanced plan with complex features"),
-		Price:        39.99,
+		Price:        decimal.RequireFromString("39.99"),
 		Currency:     "USD",
 		BillingCycle: "monthly",
 		Features: map[string]interface{}{
@@ -308,7 +399,7 @@ func TestPlanFeatures(t *testing.T) {
 	assert.Equal(t, "plan_advanced", plan.ID)
 	assert.Equal(t, "Advanced Plan", plan.Name)
 	assert.Equal(t, "Advanced plan with complex features", *plan.Description)
-	assert.Equal(t, 39.99, plan.Price)
+	assert.Equal(t, "39.99", plan.Price.String())
 	assert.Equal(t, "USD", plan.Currency)
 	assert.Equal(t, "monthly", plan.BillingCycle)
 
@@ -329,7 +420,7 @@ func TestPlanUpdate(t *testing.T) {
 	plan := &Plan{
 		ID:           "plan_123",
 		Name:         "Original Plan",
-		Price:        19.99,
+		Price:        decimal.RequireFromString("19.99"),
 		Currency:     "USD",
 		BillingCycle: "monthly",
 		IsActive:     true,
@@ -339,7 +430,7 @@ func TestPlanUpdate(t *testing.T) {
 
 	// Test updating multiple fields
 	newName := "Updated Premium Plan"
-	newPrice := 29.99
+	newPrice := decimal.RequireFromString("29.99")
 	newDescription := "Enhanced premium plan with new features"
 	newCurrency := "EUR"
 	newBillingCycle := "yearly"
@@ -380,7 +471,7 @@ func TestPlanUpdate(t *testing.T) {
 
 	// Verify updates
 	assert.Equal(t, "Updated Premium Plan", plan.Name)
-	assert.Equal(t, 29.99, plan.Price)
+	assert.Equal(t, "29.99", plan.Price.String())
 	assert.Equal(t, "Enhanced premium plan with new features", *plan.Description)
 	assert.Equal(t, "EUR", plan.Currency)
 	assert.Equal(t, "yearly", plan.BillingCycle)
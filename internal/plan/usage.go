@@ -0,0 +1,148 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"scalable-paywall/internal/cache"
+)
+
+const (
+	// defaultUsageDays is the usage statistics range when none is given
+	defaultUsageDays = 30
+	// maxUsageDays bounds the range so one request can't scan years of ledger
+	maxUsageDays = 366
+	// usageCacheTTL is how long computed usage statistics are reused
+	usageCacheTTL = 15 * time.Minute
+)
+
+const usageDateLayout = "2006-01-02"
+
+// parseUsageRange turns the from/to query parameters (YYYY-MM-DD, to
+// inclusive) into a half-open UTC range [from, to). Missing bounds default
+// to the defaultUsageDays days up to and including today.
+func parseUsageRange(fromStr, toStr string, now time.Time) (time.Time, time.Time, error) {
+	now = now.UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	if toStr != "" {
+		day, err := time.Parse(usageDateLayout, toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be a date (YYYY-MM-DD)")
+		}
+		to = day.AddDate(0, 0, 1)
+	}
+
+	from := to.AddDate(0, 0, -defaultUsageDays)
+	if fromStr != "" {
+		day, err := time.Parse(usageDateLayout, fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must be a date (YYYY-MM-DD)")
+		}
+		from = day
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+	}
+	if to.Sub(from) > maxUsageDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("time range must not exceed %d days", maxUsageDays)
+	}
+	return from, to, nil
+}
+
+// summarizeUsage builds usage statistics from per-day counts. Every day of
+// [from, to) gets a bucket, so days without usage show as zero. subscribers
+// is the number of subscriptions with usage in the range.
+func summarizeUsage(counts map[string]int, subscribers int, from, to time.Time) UsageStatistics {
+	stats := UsageStatistics{From: from, To: to, Daily: []DailyUsage{}}
+	monthly := make(map[string]int)
+	var months []string
+
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(usageDateLayout)
+		count := counts[date]
+		stats.Daily = append(stats.Daily, DailyUsage{Date: date, Count: count})
+		stats.TotalUsage += count
+
+		// Ties go to the earliest day
+		if count > 0 && (stats.PeakUsageDay == "" || count > counts[stats.PeakUsageDay]) {
+			stats.PeakUsageDay = date
+		}
+
+		month := day.Format("2006-01")
+		if _, ok := monthly[month]; !ok {
+			months = append(months, month)
+		}
+		monthly[month] += count
+	}
+
+	for _, month := range months {
+		if monthly[month] > 0 && (stats.PeakUsageMonth == "" || monthly[month] > monthly[stats.PeakUsageMonth]) {
+			stats.PeakUsageMonth = month
+		}
+	}
+
+	if subscribers > 0 && len(stats.Daily) > 0 {
+		stats.AverageUsagePerDay = float64(stats.TotalUsage) / float64(len(stats.Daily)) / float64(subscribers)
+		perMonth, _ := daysPerMonth.Float64()
+		stats.AverageUsagePerMonth = stats.AverageUsagePerDay * perMonth
+	}
+	return stats
+}
+
+// getDailyUsage counts the plan's usage ledger entries per UTC day in
+// [from, to), and the number of subscriptions they came from.
+func (s *Service) getDailyUsage(ctx context.Context, planID string, from, to time.Time) (map[string]int, int, error) {
+	reader := s.db.Reader()
+
+	rows, err := reader.QueryContext(ctx, `
+		SELECT to_char(date_trunc('day', ul.created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD'), COUNT(*)
+		FROM usage_logs ul
+		JOIN subscriptions s ON s.id = ul.subscription_id
+		WHERE s.plan_id = $1 AND ul.kind = 'usage' AND ul.created_at >= $2 AND ul.created_at < $3
+		GROUP BY 1
+	`, planID, from, to)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var day string
+		var count int
+		if err := rows.Scan(&day, &count); err != nil {
+			return nil, 0, err
+		}
+		counts[day] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var subscribers int
+	err = reader.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT ul.subscription_id)
+		FROM usage_logs ul
+		JOIN subscriptions s ON s.id = ul.subscription_id
+		WHERE s.plan_id = $1 AND ul.kind = 'usage' AND ul.created_at >= $2 AND ul.created_at < $3
+	`, planID, from, to).Scan(&subscribers)
+	if err != nil {
+		return nil, 0, err
+	}
+	return counts, subscribers, nil
+}
+
+func newUsageCache(redis *cache.RedisClient) *cache.ReadThrough[*UsageStatistics] {
+	return cache.NewReadThrough(redis, cache.ReadThroughOptions[*UsageStatistics]{
+		Domain: "plan_usage",
+		Prefix: "plan:usage",
+		TTL:    usageCacheTTL,
+	})
+}
+
+// usageCacheID identifies the usage statistics of a plan over [from, to)
+func usageCacheID(planID string, from, to time.Time) string {
+	return fmt.Sprintf("%s:%s:%s", planID, from.Format(usageDateLayout), to.Format(usageDateLayout))
+}
@@ -0,0 +1,57 @@
+package plan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUsageRange(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 18, 0, 0, 0, time.UTC)
+
+	from, to, err := parseUsageRange("", "", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, time.March, 16, 0, 0, 0, 0, time.UTC), to)
+	assert.Equal(t, 30*24*time.Hour, to.Sub(from))
+
+	from, to, err = parseUsageRange("2024-03-01", "2024-03-01", now)
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, to.Sub(from))
+
+	_, _, err = parseUsageRange("2024-03-05", "2024-03-01", now)
+	assert.Error(t, err)
+	_, _, err = parseUsageRange("2022-01-01", "2024-03-01", now)
+	assert.Error(t, err)
+	_, _, err = parseUsageRange("yesterday", "", now)
+	assert.Error(t, err)
+}
+
+func TestSummarizeUsageFindsPeaks(t *testing.T) {
+	from := time.Date(2024, time.January, 30, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, time.February, 3, 0, 0, 0, 0, time.UTC)
+	counts := map[string]int{
+		"2024-01-30": 10,
+		"2024-01-31": 10,
+		"2024-02-01": 30,
+		"2024-02-02": 2,
+	}
+
+	stats := summarizeUsage(counts, 2, from, to)
+
+	assert.Len(t, stats.Daily, 4)
+	assert.Equal(t, 52, stats.TotalUsage)
+	assert.Equal(t, "2024-02-01", stats.PeakUsageDay)
+	assert.Equal(t, "2024-02", stats.PeakUsageMonth)
+	assert.InDelta(t, 6.5, stats.AverageUsagePerDay, 0.0001)
+}
+
+func TestSummarizeUsageWithoutUsage(t *testing.T) {
+	from := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	stats := summarizeUsage(nil, 0, from, from.AddDate(0, 0, 7))
+
+	assert.Len(t, stats.Daily, 7)
+	assert.Zero(t, stats.AverageUsagePerDay)
+	assert.Empty(t, stats.PeakUsageDay)
+}
@@ -0,0 +1,74 @@
+package plan
+
+import (
+	"context"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+)
+
+// RegisterWarmers has cache warmups preload the active plans, as listed and
+// by ID, and the cfg.HotPlans plans with the most active subscribers, which
+// may include retired plans subscribers were kept on.
+func (s *Service) RegisterWarmers(cfg config.WarmupConfig) {
+	s.cache.RegisterWarmer("plans.active", s.warmActivePlans)
+	if cfg.HotPlans > 0 {
+		s.cache.RegisterWarmer("plans.hot", func(ctx context.Context) (int, error) {
+			return s.warmHotPlans(ctx, cfg.HotPlans)
+		})
+	}
+}
+
+func (s *Service) warmActivePlans(ctx context.Context) (int, error) {
+	generation := s.activePlansGeneration(ctx)
+	plans, err := s.getActivePlans(ctx)
+	if err != nil {
+		return 0, err
+	}
+	s.cacheActivePlans(ctx, plans, generation)
+	for i := range plans {
+		s.cachePlan(ctx, &plans[i])
+	}
+	return len(plans), nil
+}
+
+func (s *Service) warmHotPlans(ctx context.Context, limit int) (int, error) {
+	// Ranked on a replica; the plans themselves are read from the primary,
+	// as they are cached for an hour
+	rows, err := s.db.Reader().QueryContext(db.WithQueryName(ctx, "hot_plans"), `
+		SELECT plan_id FROM subscriptions
+		WHERE status = 'active'
+		GROUP BY plan_id
+		ORDER BY COUNT(*) DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	plans, err := s.getPlansByIDs(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	for _, plan := range plans {
+		plan := plan
+		s.cachePlan(ctx, &plan)
+	}
+	return len(plans), nil
+}
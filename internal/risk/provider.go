@@ -0,0 +1,57 @@
+package risk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// providerChecker asks a third-party scoring service. The attempt is
+// POSTed as JSON with the API key as a bearer token, and the service
+// answers with an assessment: {"decision": "allow|review|block",
+// "score": 0-100, "reasons": [...]}.
+type providerChecker struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func (p *providerChecker) Name() string { return "provider" }
+
+func (p *providerChecker) Assess(ctx context.Context, attempt Attempt) (*Assessment, error) {
+	body, err := json.Marshal(attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("risk provider returned status %d", resp.StatusCode)
+	}
+
+	var assessment Assessment
+	if err := json.NewDecoder(resp.Body).Decode(&assessment); err != nil {
+		return nil, fmt.Errorf("failed to decode risk provider response: %w", err)
+	}
+	if _, ok := severity[assessment.Decision]; !ok {
+		return nil, fmt.Errorf("risk provider returned unknown decision %q", assessment.Decision)
+	}
+	assessment.ReviewID = ""
+	return &assessment, nil
+}
@@ -0,0 +1,56 @@
+package risk
+
+import (
+	"context"
+
+	"github.com/shopspring/decimal"
+)
+
+// Decisions a risk check can reach, from least to most severe.
+const (
+	Allow  = "allow"
+	Review = "review"
+	Block  = "block"
+)
+
+var severity = map[string]int{Allow: 0, Review: 1, Block: 2}
+
+// Attempt is a payment about to be charged, as the risk checks see it.
+// PaymentMethod is the gateway's payment method token, which stands in for
+// the card fingerprint.
+type Attempt struct {
+	UserID        string          `json:"user_id"`
+	IP            string          `json:"ip"`
+	PaymentMethod string          `json:"payment_method"`
+	PlanID        string          `json:"plan_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency"`
+}
+
+// Assessment is a verdict on an attempt. Score runs from 0 (no risk) to
+// 100. ReviewID is set when the attempt was queued for review.
+type Assessment struct {
+	Decision string   `json:"decision"`
+	Score    int      `json:"score"`
+	Reasons  []string `json:"reasons,omitempty"`
+	ReviewID string   `json:"review_id,omitempty"`
+}
+
+// Checker is one risk check run before charging. Checks are registered on
+// the Service, which combines their assessments.
+type Checker interface {
+	Name() string
+	Assess(ctx context.Context, attempt Attempt) (*Assessment, error)
+}
+
+// merge folds b into a: the more severe decision, the higher score and
+// every reason.
+func (a *Assessment) merge(b *Assessment) {
+	if severity[b.Decision] > severity[a.Decision] {
+		a.Decision = b.Decision
+	}
+	if b.Score > a.Score {
+		a.Score = b.Score
+	}
+	a.Reasons = append(a.Reasons, b.Reasons...)
+}
@@ -0,0 +1,39 @@
+package risk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVelocityAssessCount(t *testing.T) {
+	v := &velocityChecker{window: time.Hour, reviewAfter: 5, blockAfter: 10}
+
+	allowed := v.assessCount("user", 3)
+	assert.Equal(t, Allow, allowed.Decision)
+	assert.Equal(t, 27, allowed.Score)
+	assert.Empty(t, allowed.Reasons)
+
+	review := v.assessCount("ip", 6)
+	assert.Equal(t, Review, review.Decision)
+	assert.Equal(t, []string{"6 payment attempts by ip within 1h0m0s"}, review.Reasons)
+
+	blocked := v.assessCount("payment_method", 11)
+	assert.Equal(t, Block, blocked.Decision)
+	assert.Equal(t, 100, blocked.Score)
+}
+
+func TestAssessmentMergeKeepsMostSevere(t *testing.T) {
+	assessment := &Assessment{Decision: Allow}
+	assessment.merge(&Assessment{Decision: Review, Score: 60, Reasons: []string{"velocity"}})
+	assessment.merge(&Assessment{Decision: Allow, Score: 20, Reasons: []string{"provider"}})
+
+	assert.Equal(t, Review, assessment.Decision)
+	assert.Equal(t, 60, assessment.Score)
+	assert.Equal(t, []string{"velocity", "provider"}, assessment.Reasons)
+
+	assessment.merge(&Assessment{Decision: Block, Score: 90})
+	assert.Equal(t, Block, assessment.Decision)
+	assert.Equal(t, 90, assessment.Score)
+}
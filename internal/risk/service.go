@@ -0,0 +1,327 @@
+package risk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/middleware"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// Review states
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// approvalValidity is how long an approved review lets the same user and
+// payment method past review-level checks, for the customer to check out again
+const approvalValidity = 24 * time.Hour
+
+// ErrReviewResolved is returned when resolving a review that is no longer pending
+var ErrReviewResolved = errors.New("risk review already resolved")
+
+// Service runs the registered risk checks before a charge and keeps the
+// queue of payments held for review.
+type Service struct {
+	enabled  bool
+	db       *db.Connection
+	checkers []Checker
+}
+
+// HeldPayment is a payment attempt held for manual review.
+type HeldPayment struct {
+	ID            string          `json:"id" db:"id"`
+	UserID        string          `json:"user_id" db:"user_id"`
+	PaymentMethod string          `json:"payment_method,omitempty" db:"payment_method"`
+	IPAddress     *string         `json:"ip_address,omitempty" db:"ip_address"`
+	PlanID        *string         `json:"plan_id,omitempty" db:"plan_id"`
+	Amount        decimal.Decimal `json:"amount" db:"amount"`
+	Currency      string          `json:"currency" db:"currency"`
+	Score         int             `json:"score" db:"score"`
+	Reasons       []string        `json:"reasons" db:"reasons"`
+	Attempts      int             `json:"attempts" db:"attempts"`
+	Status        string          `json:"status" db:"status"`
+	ReviewedBy    *string         `json:"reviewed_by,omitempty" db:"reviewed_by"`
+	Note          *string         `json:"note,omitempty" db:"note"`
+	ReviewedAt    *time.Time      `json:"reviewed_at,omitempty" db:"reviewed_at"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+type ReviewListResponse struct {
+	Reviews    []HeldPayment `json:"reviews"`
+	Limit      int           `json:"limit"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+type ResolveReviewRequest struct {
+	Decision string `json:"decision" binding:"required,oneof=approve reject"`
+	Note     string `json:"note" binding:"max=1000"`
+}
+
+// NewService registers the velocity check, and the third-party provider
+// when cfg names one. More checks can be added with Register.
+func NewService(cfg config.RiskConfig, db *db.Connection, cache *cache.RedisClient) *Service {
+	s := &Service{enabled: cfg.Enabled, db: db}
+	s.Register(&velocityChecker{
+		cache:       cache,
+		window:      time.Duration(cfg.VelocityWindow) * time.Second,
+		reviewAfter: cfg.ReviewAfter,
+		blockAfter:  cfg.BlockAfter,
+	})
+	if cfg.ProviderURL != "" {
+		s.Register(&providerChecker{
+			url:    cfg.ProviderURL,
+			apiKey: cfg.ProviderAPIKey,
+			client: &http.Client{Timeout: time.Duration(cfg.ProviderTimeout) * time.Second},
+		})
+	}
+	return s
+}
+
+// Register adds a check run on every attempt. It is not safe to call once
+// the service is handling payments.
+func (s *Service) Register(checker Checker) {
+	s.checkers = append(s.checkers, checker)
+}
+
+// Evaluate runs every check on an attempt and combines their verdicts. A
+// check that fails is logged and skipped, so a Redis or provider outage
+// never stops checkout. Earlier reviews then apply: a rejected user or
+// payment method is blocked, and a recent approval for both lets a review
+// verdict through. A review verdict queues the attempt and sets ReviewID.
+func (s *Service) Evaluate(ctx context.Context, attempt Attempt) (*Assessment, error) {
+	assessment := &Assessment{Decision: Allow}
+	if !s.enabled {
+		return assessment, nil
+	}
+
+	for _, checker := range s.checkers {
+		result, err := checker.Assess(ctx, attempt)
+		if err != nil {
+			logrus.Warnf("Risk check %s unavailable: %v", checker.Name(), err)
+			telemetry.RecordRiskDecision(checker.Name(), "error")
+			continue
+		}
+		assessment.merge(result)
+	}
+
+	var rejected, approved bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM risk_reviews
+				WHERE status = 'rejected'
+					AND (user_id::text = $1 OR ($2 <> '' AND payment_method = $2))),
+			EXISTS (SELECT 1 FROM risk_reviews
+				WHERE status = 'approved' AND user_id::text = $1 AND payment_method = $2
+					AND reviewed_at > NOW() - make_interval(secs => $3))
+	`, attempt.UserID, attempt.PaymentMethod, approvalValidity.Seconds()).Scan(&rejected, &approved)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case rejected:
+		assessment.merge(&Assessment{Decision: Block, Score: 100, Reasons: []string{"rejected in an earlier review"}})
+	case approved && assessment.Decision == Review:
+		assessment.Decision = Allow
+	}
+
+	if assessment.Decision == Review {
+		id, err := s.queueReview(ctx, attempt, assessment)
+		if err != nil {
+			return nil, err
+		}
+		assessment.ReviewID = id
+	}
+	telemetry.RecordRiskDecision("combined", assessment.Decision)
+	return assessment, nil
+}
+
+// queueReview holds an attempt for review. Attempts by the same user and
+// payment method while a review is pending are added to it.
+func (s *Service) queueReview(ctx context.Context, attempt Attempt, assessment *Assessment) (string, error) {
+	reasons, err := json.Marshal(assessment.Reasons)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO risk_reviews (user_id, payment_method, ip_address, plan_id, amount, currency, score, reasons)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8)
+		ON CONFLICT (user_id, payment_method) WHERE status = 'pending'
+		DO UPDATE SET ip_address = EXCLUDED.ip_address, plan_id = EXCLUDED.plan_id,
+			amount = EXCLUDED.amount, currency = EXCLUDED.currency,
+			score = GREATEST(risk_reviews.score, EXCLUDED.score), reasons = EXCLUDED.reasons,
+			attempts = risk_reviews.attempts + 1, updated_at = NOW()
+		RETURNING id
+	`, attempt.UserID, attempt.PaymentMethod, attempt.IP, attempt.PlanID, attempt.Amount,
+		attempt.Currency, assessment.Score, string(reasons)).Scan(&id)
+	return id, err
+}
+
+// ListReviews returns the review queue newest first using cursor
+// pagination, optionally filtered by status.
+func (s *Service) ListReviews(c *gin.Context) {
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+
+	status := c.Query("status")
+	if status != "" && status != ReviewPending && status != ReviewApproved && status != ReviewRejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, approved or rejected"})
+		telemetry.RecordPaymentOperation("risk_review_list", "validation_error")
+		return
+	}
+
+	cursor, err := db.ParseCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		telemetry.RecordPaymentOperation("risk_review_list", "validation_error")
+		return
+	}
+
+	reviews, nextCursor, err := s.listReviews(c.Request.Context(), status, cursor, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list risk reviews: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("risk_review_list", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, ReviewListResponse{
+		Reviews:    reviews,
+		Limit:      limit,
+		NextCursor: nextCursor,
+	})
+	telemetry.RecordPaymentOperation("risk_review_list", "success")
+}
+
+// ResolveReview approves or rejects a pending review. Nothing is charged:
+// an approval lets the customer check out again with the same payment
+// method, a rejection blocks the user and the payment method.
+func (s *Service) ResolveReview(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	var req ResolveReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordPaymentOperation("risk_review_resolve", "validation_error")
+		return
+	}
+
+	status := ReviewApproved
+	if req.Decision == "reject" {
+		status = ReviewRejected
+	}
+	review, err := s.resolveReview(ctx, id, status, c.GetHeader(middleware.UserHeader), req.Note)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Risk review not found"})
+		telemetry.RecordPaymentOperation("risk_review_resolve", "not_found")
+		return
+	case errors.Is(err, ErrReviewResolved):
+		c.JSON(http.StatusConflict, gin.H{"error": "Risk review is already " + review.Status})
+		telemetry.RecordPaymentOperation("risk_review_resolve", "invalid_status")
+		return
+	case err != nil:
+		logrus.Errorf("Failed to resolve risk review %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordPaymentOperation("risk_review_resolve", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, review)
+	telemetry.RecordRiskDecision("review", status)
+	telemetry.RecordPaymentOperation("risk_review_resolve", "success")
+}
+
+const reviewColumns = `id, user_id, payment_method, ip_address, plan_id, amount, currency, score,
+	reasons, attempts, status, reviewed_by, note, reviewed_at, created_at, updated_at`
+
+func scanReview(scan func(dest ...interface{}) error) (*HeldPayment, error) {
+	var review HeldPayment
+	var reasons []byte
+	if err := scan(
+		&review.ID, &review.UserID, &review.PaymentMethod, &review.IPAddress, &review.PlanID,
+		&review.Amount, &review.Currency, &review.Score, &reasons, &review.Attempts,
+		&review.Status, &review.ReviewedBy, &review.Note, &review.ReviewedAt,
+		&review.CreatedAt, &review.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(reasons, &review.Reasons); err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// resolveReview records the decision on a pending review. When the review
+// is no longer pending it returns it as it is with ErrReviewResolved.
+func (s *Service) resolveReview(ctx context.Context, id, status, reviewedBy, note string) (*HeldPayment, error) {
+	row := s.db.QueryRowContext(ctx, `
+		UPDATE risk_reviews
+		SET status = $2, reviewed_by = NULLIF($3, ''), note = NULLIF($4, ''),
+			reviewed_at = NOW(), updated_at = NOW()
+		WHERE id::text = $1 AND status = 'pending'
+		RETURNING `+reviewColumns, id, status, reviewedBy, note)
+	review, err := scanReview(row.Scan)
+	if err == sql.ErrNoRows {
+		current, err := scanReview(s.db.QueryRowContext(ctx,
+			`SELECT `+reviewColumns+` FROM risk_reviews WHERE id::text = $1`, id).Scan)
+		if err != nil {
+			return nil, err
+		}
+		return current, ErrReviewResolved
+	}
+	return review, err
+}
+
+func (s *Service) listReviews(ctx context.Context, status string, cursor *db.Cursor, limit int) ([]HeldPayment, string, error) {
+	query := `
+		SELECT ` + reviewColumns + `
+		FROM risk_reviews
+		WHERE ($1 = '' OR status = $1)
+			AND ($2::timestamptz IS NULL OR (created_at, id::text) < ($2, $3))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`
+
+	after, afterID := cursor.After()
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := s.db.Reader().QueryContext(ctx, query, status, after, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var reviews []HeldPayment
+	for rows.Next() {
+		review, err := scanReview(rows.Scan)
+		if err != nil {
+			return nil, "", err
+		}
+		reviews = append(reviews, *review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	reviews, nextCursor := db.NextPage(reviews, limit, func(row HeldPayment) db.Cursor {
+		return db.Cursor{CreatedAt: row.CreatedAt, ID: row.ID}
+	})
+
+	return reviews, nextCursor, nil
+}
@@ -0,0 +1,61 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"scalable-paywall/internal/cache"
+)
+
+// velocityChecker counts payment attempts per user, client IP and payment
+// method in fixed windows in Redis, so the counts hold across instances.
+type velocityChecker struct {
+	cache       *cache.RedisClient
+	window      time.Duration
+	reviewAfter int
+	blockAfter  int
+}
+
+func (v *velocityChecker) Name() string { return "velocity" }
+
+func (v *velocityChecker) Assess(ctx context.Context, attempt Attempt) (*Assessment, error) {
+	windowStart := time.Now().Truncate(v.window)
+	assessment := &Assessment{Decision: Allow}
+	for _, subject := range []struct{ kind, value string }{
+		{"user", attempt.UserID},
+		{"ip", attempt.IP},
+		{"payment_method", attempt.PaymentMethod},
+	} {
+		if subject.value == "" {
+			continue
+		}
+		key := fmt.Sprintf("risk:velocity:%s:%s:%d", subject.kind, subject.value, windowStart.Unix())
+		count, err := v.cache.Incr(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if count == 1 {
+			v.cache.Expire(ctx, key, v.window)
+		}
+		assessment.merge(v.assessCount(subject.kind, int(count)))
+	}
+	return assessment, nil
+}
+
+// assessCount scores count attempts by one subject within the window,
+// reaching 100 once it goes past blockAfter
+func (v *velocityChecker) assessCount(kind string, count int) *Assessment {
+	assessment := &Assessment{Decision: Allow, Score: min(100, count*100/(v.blockAfter+1))}
+	switch {
+	case count > v.blockAfter:
+		assessment.Decision = Block
+		assessment.Score = 100
+	case count > v.reviewAfter:
+		assessment.Decision = Review
+	default:
+		return assessment
+	}
+	assessment.Reasons = []string{fmt.Sprintf("%d payment attempts by %s within %s", count, kind, v.window)}
+	return assessment
+}
@@ -0,0 +1,93 @@
+// Package scrub removes personal data and credentials from log output and
+// error responses: emails, bearer tokens and Authorization headers, API
+// keys, gateway payment identifiers and card numbers.
+package scrub
+
+import (
+	"fmt"
+	"regexp"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/pci"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces scrubbed values
+const Redacted = "[REDACTED]"
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// authHeaderPattern is an Authorization header or field with its value,
+	// including the scheme
+	authHeaderPattern = regexp.MustCompile(`(?i)(authorization["']?\s*[:=]\s*["']?)(?:(?:bearer|basic)\s+)?[^\s"',]+`)
+	bearerPattern     = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]{8,}`)
+	// secretKeyPattern is a Stripe-style secret, restricted or webhook key
+	secretKeyPattern = regexp.MustCompile(`\b(sk|rk|whsec)_[A-Za-z0-9_]{8,}`)
+	// paymentIDPattern is a gateway customer, payment method, token, card,
+	// source or intent ID (with its client secret); the prefix is kept
+	paymentIDPattern = regexp.MustCompile(`\b(cus|pm|tok|card|src|ba|seti|pi)_[A-Za-z0-9]{8,}(?:_secret_[A-Za-z0-9]+)?\b`)
+)
+
+// Scrubber scrubs strings, and log entries including their fields. Fields
+// whose names match one of its patterns are redacted whole.
+type Scrubber struct {
+	fields []*regexp.Regexp
+}
+
+// New builds a Scrubber redacting the fields named by cfg.RedactFields.
+func New(cfg config.LoggingConfig) (*Scrubber, error) {
+	s := &Scrubber{}
+	for _, pattern := range cfg.RedactFields {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact field pattern %q: %w", pattern, err)
+		}
+		s.fields = append(s.fields, re)
+	}
+	return s, nil
+}
+
+// String scrubs s.
+func (s *Scrubber) String(str string) string {
+	str = authHeaderPattern.ReplaceAllString(str, "${1}"+Redacted)
+	str = bearerPattern.ReplaceAllString(str, "${1} "+Redacted)
+	str = secretKeyPattern.ReplaceAllString(str, "${1}_"+Redacted)
+	str = paymentIDPattern.ReplaceAllString(str, "${1}_"+Redacted)
+	str = emailPattern.ReplaceAllString(str, Redacted)
+	return pci.Redact(str)
+}
+
+// RedactsField reports whether a log field named name is redacted whole.
+func (s *Scrubber) RedactsField(name string) bool {
+	for _, re := range s.fields {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Levels makes the Scrubber a logrus hook for every level; add it to a
+// logger with AddHook.
+func (s *Scrubber) Levels() []logrus.Level { return logrus.AllLevels }
+
+// Fire scrubs an entry's message and fields before it is written.
+func (s *Scrubber) Fire(entry *logrus.Entry) error {
+	entry.Message = s.String(entry.Message)
+	for key, value := range entry.Data {
+		if s.RedactsField(key) {
+			entry.Data[key] = Redacted
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = s.String(v)
+		case error:
+			if scrubbed := s.String(v.Error()); scrubbed != v.Error() {
+				entry.Data[key] = scrubbed
+			}
+		}
+	}
+	return nil
+}
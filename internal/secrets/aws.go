@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// awsProvider reads secrets from AWS Secrets Manager by name or ARN using
+// the default credential chain (env, shared config, instance role).
+type awsProvider struct {
+	client *secretsmanager.Client
+}
+
+func newAWSProvider(ctx context.Context, cfg config.AWSSecretsConfig) (*awsProvider, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return &awsProvider{client: secretsmanager.NewFromConfig(awsCfg)}, nil
+}
+
+func (p *awsProvider) Fetch(ctx context.Context, name string) (string, error) {
+	out, err := p.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	if out.SecretString == nil {
+		return "", ErrNotFound
+	}
+	return *out.SecretString, nil
+}
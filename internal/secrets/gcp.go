@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"scalable-paywall/internal/config"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// gcpProvider reads secrets from GCP Secret Manager using application
+// default credentials. Short names resolve to the latest version in the
+// configured project; full resource names are used as-is.
+type gcpProvider struct {
+	client    *secretmanager.Client
+	projectID string
+}
+
+func newGCPProvider(ctx context.Context, cfg config.GCPSecretsConfig) (*gcpProvider, error) {
+	client, err := secretmanager.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCP secret manager client: %w", err)
+	}
+	return &gcpProvider{client: client, projectID: cfg.ProjectID}, nil
+}
+
+func (p *gcpProvider) Fetch(ctx context.Context, name string) (string, error) {
+	if !strings.HasPrefix(name, "projects/") {
+		name = fmt.Sprintf("projects/%s/secrets/%s/versions/latest", p.projectID, name)
+	}
+
+	resp, err := p.client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: name})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return string(resp.GetPayload().GetData()), nil
+}
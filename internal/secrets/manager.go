@@ -0,0 +1,187 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Keys identify the credentials a Manager resolves from the secret store.
+const (
+	PaymentAPIKey        = "payment.api_key"
+	PaymentSecretKey     = "payment.secret_key"
+	PaymentWebhookSecret = "payment.webhook_secret"
+	DatabasePassword     = "database.password"
+	EncryptionKeyring    = "encryption.keyring"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+// Provider fetches the current value of a secret by its provider-specific name.
+type Provider interface {
+	Fetch(ctx context.Context, name string) (string, error)
+}
+
+// NewProvider builds the Provider selected by cfg.Provider. It returns a nil
+// Provider when no secret store is configured.
+func NewProvider(ctx context.Context, cfg config.SecretsConfig) (Provider, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "":
+		return nil, nil
+	case "vault":
+		return newVaultProvider(cfg.Vault)
+	case "aws":
+		return newAWSProvider(ctx, cfg.AWS)
+	case "gcp":
+		return newGCPProvider(ctx, cfg.GCP)
+	default:
+		return nil, fmt.Errorf("unknown secrets provider %q", cfg.Provider)
+	}
+}
+
+// Manager keeps credentials fetched from a Provider and refreshes them
+// periodically so rotated values are picked up without a restart.
+type Manager struct {
+	provider Provider
+	refs     map[string]string
+
+	mu        sync.RWMutex
+	values    map[string]string
+	listeners []func(key, value string)
+}
+
+func NewManager(ctx context.Context, cfg config.SecretsConfig) (*Manager, error) {
+	provider, err := NewProvider(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]string)
+	for key, name := range map[string]string{
+		PaymentAPIKey:        cfg.Refs.PaymentAPIKey,
+		PaymentSecretKey:     cfg.Refs.PaymentSecretKey,
+		PaymentWebhookSecret: cfg.Refs.PaymentWebhookSecret,
+		DatabasePassword:     cfg.Refs.DatabasePassword,
+		EncryptionKeyring:    cfg.Refs.EncryptionKeyring,
+	} {
+		if name != "" {
+			refs[key] = name
+		}
+	}
+
+	return &Manager{
+		provider: provider,
+		refs:     refs,
+		values:   make(map[string]string),
+	}, nil
+}
+
+// Enabled reports whether any credential is sourced from a secret store.
+func (m *Manager) Enabled() bool {
+	return m.provider != nil && len(m.refs) > 0
+}
+
+// Load fetches every referenced secret. All failures are reported together.
+func (m *Manager) Load(ctx context.Context) error {
+	if !m.Enabled() {
+		return nil
+	}
+
+	var errs []error
+	for key := range m.refs {
+		if _, err := m.refresh(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Apply copies loaded secrets into cfg. It is meant for startup, before the
+// config is shared with services; later rotations are delivered via OnRotate.
+func (m *Manager) Apply(cfg *config.Config) {
+	if v, ok := m.Get(PaymentAPIKey); ok {
+		cfg.Payment.APIKey = v
+	}
+	if v, ok := m.Get(PaymentSecretKey); ok {
+		cfg.Payment.SecretKey = v
+	}
+	if v, ok := m.Get(PaymentWebhookSecret); ok {
+		cfg.Payment.WebhookSecret = v
+	}
+	if v, ok := m.Get(DatabasePassword); ok {
+		cfg.Database.Password = v
+	}
+}
+
+// Get returns the latest value for key and whether it came from the store.
+func (m *Manager) Get(key string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// OnRotate registers fn to be called whenever a secret's value changes.
+func (m *Manager) OnRotate(fn func(key, value string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// Run refreshes all secrets every interval until ctx is done. A failed fetch
+// keeps the previous value so a store outage does not drop credentials.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	if !m.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for key := range m.refs {
+			changed, err := m.refresh(ctx, key)
+			if err != nil {
+				logrus.Errorf("Failed to refresh secret %s: %v", key, err)
+				continue
+			}
+			if changed {
+				logrus.Infof("Secret %s rotated", key)
+			}
+		}
+	}
+}
+
+func (m *Manager) refresh(ctx context.Context, key string) (bool, error) {
+	value, err := m.provider.Fetch(ctx, m.refs[key])
+	if err != nil {
+		return false, fmt.Errorf("secret %s: %w", key, err)
+	}
+
+	m.mu.Lock()
+	previous, existed := m.values[key]
+	m.values[key] = value
+	listeners := m.listeners
+	m.mu.Unlock()
+
+	if !existed || previous == value {
+		return false, nil
+	}
+	for _, fn := range listeners {
+		fn(key, value)
+	}
+	return true, nil
+}
@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newVaultManager(t *testing.T, address string) *Manager {
+	t.Helper()
+	m, err := NewManager(context.Background(), config.SecretsConfig{
+		Provider: "vault",
+		Vault:    config.VaultConfig{Address: address, Token: "s.token"},
+		Refs: config.SecretRefsConfig{
+			PaymentAPIKey:    "secret/data/payment#api_key",
+			DatabasePassword: "secret/data/db#password",
+		},
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func TestManagerLoadAndApply(t *testing.T) {
+	vault, server := newFakeVault(t)
+	vault.set("/v1/secret/data/payment", `{"data": {"data": {"api_key": "sk_live_1"}}}`)
+	vault.set("/v1/secret/data/db", `{"data": {"data": {"password": "hunter2"}}}`)
+
+	m := newVaultManager(t, server.URL)
+	assert.True(t, m.Enabled())
+	require.NoError(t, m.Load(context.Background()))
+
+	cfg := &config.Config{}
+	cfg.Payment.SecretKey = "from-config"
+	m.Apply(cfg)
+	assert.Equal(t, "sk_live_1", cfg.Payment.APIKey)
+	assert.Equal(t, "hunter2", cfg.Database.Password)
+	assert.Equal(t, "from-config", cfg.Payment.SecretKey, "unreferenced secrets keep their configured value")
+}
+
+func TestManagerLoadReportsEveryFailure(t *testing.T) {
+	vault, server := newFakeVault(t)
+	vault.set("/v1/secret/data/payment", `{"data": {"data": {"api_key": "sk_live_1"}}}`)
+
+	m := newVaultManager(t, server.URL)
+	err := m.Load(context.Background())
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Contains(t, err.Error(), DatabasePassword)
+
+	value, ok := m.Get(PaymentAPIKey)
+	assert.True(t, ok)
+	assert.Equal(t, "sk_live_1", value)
+}
+
+func TestManagerRunRotatesAndKeepsLastGoodValue(t *testing.T) {
+	vault, server := newFakeVault(t)
+	vault.set("/v1/secret/data/payment", `{"data": {"data": {"api_key": "sk_live_1"}}}`)
+	vault.set("/v1/secret/data/db", `{"data": {"data": {"password": "hunter2"}}}`)
+
+	m := newVaultManager(t, server.URL)
+	require.NoError(t, m.Load(context.Background()))
+
+	var mu sync.Mutex
+	rotated := make(map[string]string)
+	m.OnRotate(func(key, value string) {
+		mu.Lock()
+		defer mu.Unlock()
+		rotated[key] = value
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// A rotated value reaches the listeners; an unchanged one doesn't
+	vault.set("/v1/secret/data/payment", `{"data": {"data": {"api_key": "sk_live_2"}}}`)
+	assert.Eventually(t, func() bool {
+		value, _ := m.Get(PaymentAPIKey)
+		return value == "sk_live_2"
+	}, time.Second, 5*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, map[string]string{PaymentAPIKey: "sk_live_2"}, rotated)
+	mu.Unlock()
+
+	// While the store is down the last values are kept
+	vault.fail(http.StatusServiceUnavailable)
+	time.Sleep(50 * time.Millisecond)
+	value, ok := m.Get(PaymentAPIKey)
+	assert.True(t, ok)
+	assert.Equal(t, "sk_live_2", value)
+	value, _ = m.Get(DatabasePassword)
+	assert.Equal(t, "hunter2", value)
+}
+
+func TestManagerWithoutStore(t *testing.T) {
+	m, err := NewManager(context.Background(), config.SecretsConfig{})
+	require.NoError(t, err)
+	assert.False(t, m.Enabled())
+	assert.NoError(t, m.Load(context.Background()))
+
+	_, err = NewManager(context.Background(), config.SecretsConfig{Provider: "keepass"})
+	assert.EqualError(t, err, `unknown secrets provider "keepass"`)
+}
@@ -0,0 +1,105 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault serves KV responses by path, with the bodies tests set
+type fakeVault struct {
+	mu        sync.Mutex
+	responses map[string]string
+	status    int
+	headers   http.Header
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server) {
+	v := &fakeVault{responses: make(map[string]string), status: http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.headers = r.Header.Clone()
+		body, ok := v.responses[r.URL.Path]
+		switch {
+		case v.status != http.StatusOK:
+			w.WriteHeader(v.status)
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte(body))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return v, server
+}
+
+func (v *fakeVault) set(path, body string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.responses[path] = body
+}
+
+func (v *fakeVault) fail(status int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.status = status
+}
+
+func TestVaultFetch(t *testing.T) {
+	ctx := context.Background()
+	vault, server := newFakeVault(t)
+	vault.set("/v1/secret/data/paywall", `{"data": {"data": {"api_key": "sk_v2", "value": "default"}, "metadata": {"version": 3}}}`)
+	vault.set("/v1/kv/paywall", `{"data": {"api_key": "sk_v1"}}`)
+
+	p, err := newVaultProvider(config.VaultConfig{Address: server.URL + "/", Token: "s.token", Namespace: "billing"})
+	require.NoError(t, err)
+
+	// KV v2 nests the secret under data.data
+	value, err := p.Fetch(ctx, "secret/data/paywall#api_key")
+	require.NoError(t, err)
+	assert.Equal(t, "sk_v2", value)
+	assert.Equal(t, "s.token", vault.headers.Get("X-Vault-Token"))
+	assert.Equal(t, "billing", vault.headers.Get("X-Vault-Namespace"))
+
+	value, err = p.Fetch(ctx, "secret/data/paywall")
+	require.NoError(t, err)
+	assert.Equal(t, "default", value, "the field defaults to value")
+
+	value, err = p.Fetch(ctx, "/kv/paywall#api_key")
+	require.NoError(t, err)
+	assert.Equal(t, "sk_v1", value)
+
+	_, err = p.Fetch(ctx, "kv/paywall#missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = p.Fetch(ctx, "kv/unknown#api_key")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	vault.fail(http.StatusForbidden)
+	_, err = p.Fetch(ctx, "kv/paywall#api_key")
+	assert.EqualError(t, err, "vault returned status 403")
+}
+
+func TestVaultFetchRejectsMalformedResponse(t *testing.T) {
+	vault, server := newFakeVault(t)
+	vault.set("/v1/kv/paywall", `<html>`)
+	p, err := newVaultProvider(config.VaultConfig{Address: server.URL, Token: "s.token"})
+	require.NoError(t, err)
+
+	_, err = p.Fetch(context.Background(), "kv/paywall#api_key")
+	assert.ErrorContains(t, err, "failed to decode vault response")
+}
+
+func TestNewVaultProviderRequiresAddressAndToken(t *testing.T) {
+	_, err := newVaultProvider(config.VaultConfig{Token: "s.token"})
+	assert.Error(t, err)
+	_, err = newVaultProvider(config.VaultConfig{Address: "http://vault:8200"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,374 @@
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/plan"
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/user"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// Scale is how much data a run creates.
+type Scale struct {
+	// Users is the number of users, each with one subscription
+	Users int
+	// HistoryDays is how far back subscriptions may have started
+	HistoryDays int
+	// UsageDays is how many days of usage are logged for live subscriptions
+	UsageDays int
+	// UsagePerDay is the most usage events a subscriber logs in a day
+	UsagePerDay int
+}
+
+// Summary counts what a run created.
+type Summary struct {
+	Plans         int `json:"plans"`
+	Users         int `json:"users"`
+	Subscriptions int `json:"subscriptions"`
+	Transactions  int `json:"transactions"`
+	UsageLogs     int `json:"usage_logs"`
+}
+
+// Seeder fills a development database with sample data. Plans, users and
+// subscriptions are created through their services, so they pass the same
+// validation and get the same snapshots and cache entries as real ones;
+// payments and usage history, which the services only record as they
+// happen, are inserted directly.
+type Seeder struct {
+	db            *db.Connection
+	users         *user.Service
+	plans         *plan.Service
+	subscriptions *subscription.Service
+
+	rand *rand.Rand
+	// tag keeps the emails, usernames and external refs of separate runs
+	// apart, so a database can be seeded more than once
+	tag string
+}
+
+// NewSeeder creates a seeder whose data is drawn from seed; runs with the
+// same seed and scale create the same mix of plans, statuses and usage.
+func NewSeeder(db *db.Connection, users *user.Service, plans *plan.Service, subscriptions *subscription.Service, seed int64) *Seeder {
+	return &Seeder{
+		db:            db,
+		users:         users,
+		plans:         plans,
+		subscriptions: subscriptions,
+		rand:          rand.New(rand.NewSource(seed)),
+		tag:           strconv.FormatInt(time.Now().Unix(), 36),
+	}
+}
+
+// The catalog seeded. Plans already in the database under these names are
+// reused rather than created again.
+var samplePlans = []plan.CreatePlanRequest{
+	{Name: "Free", Price: decimal.Zero, Currency: "USD", BillingCycle: "monthly", Type: plan.PlanTypeFree, MaxUsagePerDay: intPtr(10), DisplayOrder: 0},
+	{Name: "Basic", Price: decimal.RequireFromString("9.99"), Currency: "USD", BillingCycle: "monthly", MaxUsagePerDay: intPtr(100), DisplayOrder: 1},
+	{Name: "Pro", Price: decimal.RequireFromString("19.99"), Currency: "USD", BillingCycle: "monthly", DisplayOrder: 2, Badge: strPtr("Most popular")},
+	{Name: "Pro Yearly", Price: decimal.RequireFromString("199.99"), Currency: "USD", BillingCycle: "yearly", DisplayOrder: 3},
+	{Name: "Team Quarterly", Price: decimal.RequireFromString("149.00"), Currency: "USD", BillingCycle: "monthly", BillingInterval: intPtr(3), DisplayOrder: 4},
+}
+
+var sampleLocales = []struct{ country, timezone string }{
+	{"US", "America/New_York"},
+	{"US", "America/Los_Angeles"},
+	{"GB", "Europe/London"},
+	{"DE", "Europe/Berlin"},
+	{"IN", "Asia/Kolkata"},
+	{"BR", "America/Sao_Paulo"},
+	{"JP", "Asia/Tokyo"},
+	{"AU", "Australia/Sydney"},
+}
+
+var usageActions = []string{"view", "view", "view", "download", "share"}
+
+const (
+	seedPaymentMethod = "pm_card_visa"
+	// freeShare is the share of users left on the free plan
+	freeShare = 0.4
+	// usageBatch is how many usage rows go into one INSERT
+	usageBatch = 500
+	// contentItems is how many distinct content ids usage is spread over
+	contentItems = 50
+)
+
+// Run seeds the catalog and then scale.Users users with a subscription
+// each, their payments and their recent usage.
+func (s *Seeder) Run(ctx context.Context, scale Scale) (*Summary, error) {
+	summary := &Summary{}
+	plans, err := s.seedPlans(ctx, summary)
+	if err != nil {
+		return summary, fmt.Errorf("failed to seed plans: %w", err)
+	}
+
+	var free *plan.Plan
+	var paid []*plan.Plan
+	for _, p := range plans {
+		if p.Type == plan.PlanTypeFree {
+			free = p
+		} else {
+			paid = append(paid, p)
+		}
+	}
+
+	usage := newUsageWriter(s.db)
+	now := time.Now()
+	for i := 0; i < scale.Users; i++ {
+		p := paid[s.rand.Intn(len(paid))]
+		if free != nil && s.rand.Float64() < freeShare {
+			p = free
+		}
+		if err := s.seedSubscriber(ctx, i, p, scale, now, usage, summary); err != nil {
+			return summary, fmt.Errorf("failed to seed user %d: %w", i, err)
+		}
+		if (i+1)%100 == 0 {
+			logrus.Infof("Seeded %d of %d users", i+1, scale.Users)
+		}
+	}
+	if err := usage.flush(ctx); err != nil {
+		return summary, fmt.Errorf("failed to seed usage: %w", err)
+	}
+	summary.UsageLogs = usage.written
+	return summary, nil
+}
+
+// seedPlans creates the sample plans, or finds them when an earlier run
+// did. A free plan that is already active is used instead of a second one.
+func (s *Seeder) seedPlans(ctx context.Context, summary *Summary) ([]*plan.Plan, error) {
+	plans := make([]*plan.Plan, 0, len(samplePlans))
+	for _, req := range samplePlans {
+		if req.Type == plan.PlanTypeFree {
+			existing, err := s.findPlan(ctx, `plan_type = 'free' AND is_active`)
+			if err != nil {
+				return nil, err
+			}
+			if existing != nil {
+				plans = append(plans, existing)
+				continue
+			}
+		}
+
+		p, err := s.plans.ImportPlan(ctx, req)
+		if errors.Is(err, plan.ErrPlanNameExists) {
+			p, err = s.findPlan(ctx, `name = $1`, req.Name)
+		} else if err == nil {
+			summary.Plans++
+		}
+		if err != nil {
+			return nil, fmt.Errorf("plan %s: %w", req.Name, err)
+		}
+		if p != nil {
+			plans = append(plans, p)
+		}
+	}
+	return plans, nil
+}
+
+// findPlan returns the plan matching where, or nil if there is none
+func (s *Seeder) findPlan(ctx context.Context, where string, args ...interface{}) (*plan.Plan, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, price, currency, billing_cycle, billing_interval, plan_type
+		FROM plans WHERE `+where+` ORDER BY created_at LIMIT 1`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	p := &plan.Plan{}
+	if err := rows.Scan(&p.ID, &p.Name, &p.Price, &p.Currency, &p.BillingCycle, &p.BillingInterval, &p.Type); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// seedSubscriber creates user n with a subscription to p that started up to
+// scale.HistoryDays ago, a payment for each period it was billed and usage
+// for its last scale.UsageDays days if it is still live.
+func (s *Seeder) seedSubscriber(ctx context.Context, n int, p *plan.Plan, scale Scale, now time.Time, usage *usageWriter, summary *Summary) error {
+	locale := sampleLocales[s.rand.Intn(len(sampleLocales))]
+	u, err := s.users.ImportUser(ctx, user.CreateUserRequest{
+		Email:    fmt.Sprintf("seed+%s-%d@example.com", s.tag, n),
+		Username: fmt.Sprintf("seed_%s_%d", s.tag, n),
+		Country:  &locale.country,
+		Timezone: &locale.timezone,
+		Metadata: map[string]interface{}{"seeded": true},
+	})
+	if err != nil {
+		return err
+	}
+	summary.Users++
+
+	start := now.AddDate(0, 0, -s.rand.Intn(scale.HistoryDays+1)).Add(-time.Duration(s.rand.Intn(86400)) * time.Second)
+	req := subscription.ImportSubscriptionRequest{
+		UserID:      u.ID,
+		PlanID:      p.ID,
+		Status:      "active",
+		StartDate:   &start,
+		ExternalRef: fmt.Sprintf("seed-%s-%d", s.tag, n),
+		Metadata:    map[string]interface{}{"seeded": true},
+	}
+
+	var billed []period
+	var declined *period
+	if p.Type != plan.PlanTypeFree {
+		cycle := subscription.BillingCycle{Unit: p.BillingCycle, Interval: p.BillingInterval}
+		var end time.Time
+		req.Status, billed, declined, end = s.history(periodsUntil(cycle, start, now), now)
+		req.EndDate = &end
+		req.AutoRenew = req.Status == "active" || req.Status == "past_due"
+		req.PaymentMethod = seedPaymentMethod
+	}
+
+	sub, err := s.subscriptions.ImportSubscription(ctx, req)
+	if err != nil {
+		return err
+	}
+	summary.Subscriptions++
+
+	for _, billedPeriod := range billed {
+		if err := s.insertPayment(ctx, sub, "completed", billedPeriod.start); err != nil {
+			return err
+		}
+		summary.Transactions++
+	}
+	if declined != nil {
+		if err := s.insertPayment(ctx, sub, "failed", declined.start); err != nil {
+			return err
+		}
+		summary.Transactions++
+	}
+
+	if sub.Status != "active" && sub.Status != "past_due" {
+		return nil
+	}
+	for day := 0; day < scale.UsageDays; day++ {
+		dayStart := now.AddDate(0, 0, -day-1)
+		for i := s.rand.Intn(scale.UsagePerDay + 1); i > 0; i-- {
+			at := dayStart.Add(time.Duration(s.rand.Int63n(int64(24 * time.Hour))))
+			if at.Before(sub.StartDate) {
+				continue
+			}
+			content := fmt.Sprintf("content_%d", s.rand.Intn(contentItems)+1)
+			if err := usage.add(ctx, sub.UserID, sub.ID, usageActions[s.rand.Intn(len(usageActions))], content, at); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// period is one billing period of a paid subscription
+type period struct {
+	start, end time.Time
+}
+
+// periodsUntil is every period of cycle starting at start that began before
+// now. The last one is the current period.
+func periodsUntil(cycle subscription.BillingCycle, start, now time.Time) []period {
+	var periods []period
+	for {
+		end := cycle.PeriodEnd(start)
+		periods = append(periods, period{start: start, end: end})
+		if end.After(now) {
+			return periods
+		}
+		start = end
+	}
+}
+
+// history picks a status for a paid subscription with periods and returns
+// the periods it paid for, a declined renewal if it is past due, and its end
+// date. Roughly 70% stay active, 5% fall past due, 15% are cancelled and
+// 10% have expired; ones too young to have renewed stay active.
+func (s *Seeder) history(periods []period, now time.Time) (string, []period, *period, time.Time) {
+	current := len(periods) - 1
+	roll := s.rand.Float64()
+	switch {
+	case roll < 0.70 || current == 0:
+		return "active", periods, nil, periods[current].end
+	case roll < 0.75:
+		// The current period's renewal was declined
+		return "past_due", periods[:current], &periods[current], periods[current-1].end
+	case roll < 0.90:
+		// Cancelled in some period, running on until its end
+		paid := 1 + s.rand.Intn(current+1)
+		return "cancelled", periods[:paid], nil, periods[paid-1].end
+	default:
+		paid := 1 + s.rand.Intn(current)
+		return "expired", periods[:paid], nil, periods[paid-1].end
+	}
+}
+
+func (s *Seeder) insertPayment(ctx context.Context, sub *subscription.Subscription, status string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO payment_transactions (user_id, subscription_id, amount, currency, status,
+			payment_method, gateway_transaction_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+	`, sub.UserID, sub.ID, sub.Amount, sub.Currency, status, seedPaymentMethod, "seed_"+uuid.New().String(), at)
+	return err
+}
+
+// usageWriter batches usage log rows into multi-row inserts
+type usageWriter struct {
+	db      *db.Connection
+	args    []interface{}
+	written int
+}
+
+const usageColumns = 5
+
+func newUsageWriter(db *db.Connection) *usageWriter {
+	return &usageWriter{db: db}
+}
+
+func (w *usageWriter) add(ctx context.Context, userID, subscriptionID, action, contentID string, at time.Time) error {
+	w.args = append(w.args, userID, subscriptionID, action, contentID, at)
+	if len(w.args) >= usageBatch*usageColumns {
+		return w.flush(ctx)
+	}
+	return nil
+}
+
+func (w *usageWriter) flush(ctx context.Context) error {
+	rows := len(w.args) / usageColumns
+	if rows == 0 {
+		return nil
+	}
+	if _, err := w.db.ExecContext(ctx, usageInsert(rows), w.args...); err != nil {
+		return err
+	}
+	w.written += rows
+	w.args = w.args[:0]
+	return nil
+}
+
+// usageInsert is an INSERT of rows usage logs
+func usageInsert(rows int) string {
+	var b strings.Builder
+	b.WriteString("INSERT INTO usage_logs (user_id, subscription_id, action, content_id, created_at) VALUES ")
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		n := i * usageColumns
+		fmt.Fprintf(&b, "($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5)
+	}
+	return b.String()
+}
+
+func intPtr(v int) *int       { return &v }
+func strPtr(v string) *string { return &v }
@@ -0,0 +1,67 @@
+package seed
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/subscription"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeriodsUntil(t *testing.T) {
+	start := time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+	now := time.Date(2026, 4, 15, 0, 0, 0, 0, time.UTC)
+
+	periods := periodsUntil(subscription.BillingCycle{Unit: "monthly", Interval: 1}, start, now)
+	require.Len(t, periods, 3)
+	assert.Equal(t, start, periods[0].start)
+	assert.Equal(t, periods[0].end, periods[1].start)
+	assert.Equal(t, periods[1].end, periods[2].start)
+	assert.True(t, periods[2].end.After(now))
+	assert.False(t, periods[1].end.After(now))
+}
+
+func TestHistoryEndsPaidPeriods(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	periods := periodsUntil(subscription.BillingCycle{Unit: "monthly", Interval: 1}, now.AddDate(-1, 0, -3), now)
+	s := &Seeder{rand: rand.New(rand.NewSource(1))}
+
+	for i := 0; i < 200; i++ {
+		status, billed, declined, end := s.history(periods, now)
+		require.NotEmpty(t, billed, status)
+		assert.Equal(t, billed[len(billed)-1].end, end, status)
+		switch status {
+		case "active":
+			assert.True(t, end.After(now))
+		case "past_due":
+			require.NotNil(t, declined)
+			assert.Equal(t, end, declined.start)
+		case "expired":
+			assert.False(t, end.After(now))
+		}
+		if status != "past_due" {
+			assert.Nil(t, declined, status)
+		}
+	}
+}
+
+func TestHistoryKeepsNewSubscriptionsActive(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	periods := periodsUntil(subscription.BillingCycle{Unit: "yearly", Interval: 1}, now.AddDate(0, -1, 0), now)
+	s := &Seeder{rand: rand.New(rand.NewSource(1))}
+
+	for i := 0; i < 50; i++ {
+		status, billed, _, _ := s.history(periods, now)
+		assert.Equal(t, "active", status)
+		assert.Len(t, billed, 1)
+	}
+}
+
+func TestUsageInsert(t *testing.T) {
+	assert.Equal(t,
+		"INSERT INTO usage_logs (user_id, subscription_id, action, content_id, created_at) VALUES ($1, $2, $3, $4, $5), ($6, $7, $8, $9, $10)",
+		usageInsert(2))
+}
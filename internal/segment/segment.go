@@ -0,0 +1,124 @@
+package segment
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// UsageWindow is how far back usage rules count a user's paywall actions
+const UsageWindow = 30 * 24 * time.Hour
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// Segment is a named group of users defined by rules. Segments are
+// evaluated when asked for, so users move in and out of them as their plan,
+// usage and tenure change.
+type Segment struct {
+	ID          string    `json:"id" db:"id"`
+	Key         string    `json:"key" db:"key"`
+	Name        string    `json:"name" db:"name"`
+	Description *string   `json:"description,omitempty" db:"description"`
+	Rules       Rules     `json:"rules" db:"rules"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Rules a user must all match to be in a segment; unset rules match
+// everyone. PlanIDs matches users whose active subscription is on one of
+// the plans, with "none" for users without one. Tenure is whole days since
+// the user signed up, usage their paywall actions within UsageWindow, and
+// Countries ISO 3166-1 alpha-2 codes.
+type Rules struct {
+	PlanIDs       []string `json:"plan_ids,omitempty"`
+	MinUsage      *int     `json:"min_usage,omitempty" binding:"omitempty,min=0"`
+	MaxUsage      *int     `json:"max_usage,omitempty" binding:"omitempty,min=0"`
+	MinTenureDays *int     `json:"min_tenure_days,omitempty" binding:"omitempty,min=0"`
+	MaxTenureDays *int     `json:"max_tenure_days,omitempty" binding:"omitempty,min=0"`
+	Countries     []string `json:"countries,omitempty"`
+}
+
+// NoPlan in PlanIDs matches users without an active subscription
+const NoPlan = "none"
+
+// Profile is what rules are evaluated against
+type Profile struct {
+	// PlanID is the plan of the user's active subscription, empty if none
+	PlanID     string
+	Usage      int
+	SignedUpAt time.Time
+	Country    string
+}
+
+// normalize uppercases country codes so "de" and "DE" are the same
+func (r *Rules) normalize() {
+	for i, country := range r.Countries {
+		r.Countries[i] = strings.ToUpper(strings.TrimSpace(country))
+	}
+}
+
+// validate checks that the rules restrict something, that ranges are not
+// inverted and that countries are two-letter codes.
+func (r Rules) validate() error {
+	if len(r.PlanIDs) == 0 && r.MinUsage == nil && r.MaxUsage == nil &&
+		r.MinTenureDays == nil && r.MaxTenureDays == nil && len(r.Countries) == 0 {
+		return fmt.Errorf("a segment needs at least one rule")
+	}
+	if r.MinUsage != nil && r.MaxUsage != nil && *r.MinUsage > *r.MaxUsage {
+		return fmt.Errorf("min_usage must not be greater than max_usage")
+	}
+	if r.MinTenureDays != nil && r.MaxTenureDays != nil && *r.MinTenureDays > *r.MaxTenureDays {
+		return fmt.Errorf("min_tenure_days must not be greater than max_tenure_days")
+	}
+	for _, planID := range r.PlanIDs {
+		if planID == "" {
+			return fmt.Errorf("plan_ids must not contain empty IDs")
+		}
+	}
+	for _, country := range r.Countries {
+		if !countryCode.MatchString(country) {
+			return fmt.Errorf("country %q is not an ISO 3166-1 alpha-2 code", country)
+		}
+	}
+	return nil
+}
+
+// Match reports whether a user with profile p matches every rule at now
+func (r Rules) Match(p Profile, now time.Time) bool {
+	if len(r.PlanIDs) > 0 {
+		planID := p.PlanID
+		if planID == "" {
+			planID = NoPlan
+		}
+		if !contains(r.PlanIDs, planID) {
+			return false
+		}
+	}
+	if r.MinUsage != nil && p.Usage < *r.MinUsage {
+		return false
+	}
+	if r.MaxUsage != nil && p.Usage > *r.MaxUsage {
+		return false
+	}
+	tenure := int(now.Sub(p.SignedUpAt) / (24 * time.Hour))
+	if r.MinTenureDays != nil && tenure < *r.MinTenureDays {
+		return false
+	}
+	if r.MaxTenureDays != nil && tenure > *r.MaxTenureDays {
+		return false
+	}
+	if len(r.Countries) > 0 && !contains(r.Countries, p.Country) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package segment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func intPtr(i int) *int { return &i }
+
+func TestMatchRequiresEveryRule(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rules := Rules{
+		PlanIDs:       []string{"pro"},
+		MinUsage:      intPtr(10),
+		MinTenureDays: intPtr(30),
+		Countries:     []string{"DE", "FR"},
+	}
+	loyal := Profile{PlanID: "pro", Usage: 25, SignedUpAt: now.AddDate(0, -3, 0), Country: "DE"}
+	assert.True(t, rules.Match(loyal, now))
+
+	newcomer := loyal
+	newcomer.SignedUpAt = now.AddDate(0, 0, -7)
+	assert.False(t, rules.Match(newcomer, now))
+
+	light := loyal
+	light.Usage = 3
+	assert.False(t, rules.Match(light, now))
+
+	abroad := loyal
+	abroad.Country = "US"
+	assert.False(t, rules.Match(abroad, now))
+
+	basic := loyal
+	basic.PlanID = "basic"
+	assert.False(t, rules.Match(basic, now))
+}
+
+func TestMatchNoPlan(t *testing.T) {
+	now := time.Now()
+	rules := Rules{PlanIDs: []string{NoPlan}, MaxTenureDays: intPtr(14)}
+
+	assert.True(t, rules.Match(Profile{SignedUpAt: now.AddDate(0, 0, -2)}, now))
+	assert.False(t, rules.Match(Profile{PlanID: "pro", SignedUpAt: now.AddDate(0, 0, -2)}, now))
+	assert.False(t, rules.Match(Profile{SignedUpAt: now.AddDate(0, 0, -20)}, now))
+}
+
+func TestValidateRules(t *testing.T) {
+	rules := Rules{Countries: []string{" de", "fr"}}
+	rules.normalize()
+	assert.NoError(t, rules.validate())
+	assert.Equal(t, []string{"DE", "FR"}, rules.Countries)
+
+	assert.Error(t, Rules{}.validate())
+	assert.Error(t, Rules{MinUsage: intPtr(10), MaxUsage: intPtr(5)}.validate())
+	assert.Error(t, Rules{MinTenureDays: intPtr(90), MaxTenureDays: intPtr(30)}.validate())
+	assert.Error(t, Rules{Countries: []string{"DEU"}}.validate())
+	assert.Error(t, Rules{PlanIDs: []string{""}}.validate())
+}
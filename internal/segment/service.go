@@ -0,0 +1,404 @@
+package segment
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// allCacheKey caches every segment, which membership checks evaluate
+const allCacheKey = "segments:all"
+
+// experimentSegmentConstraint keeps targeted segments from being deleted
+const experimentSegmentConstraint = "experiments_segment_fkey"
+
+// membershipTTL is how long a user's segment keys are cached. Pricing and
+// checkout both ask, so a user keeps the same targeted offer between them.
+const membershipTTL = 5 * time.Minute
+
+// Service manages customer segments and works out which ones a user is in,
+// for offers targeted at a segment.
+type Service struct {
+	db    *db.Connection
+	cache *cache.RedisClient
+}
+
+type CreateSegmentRequest struct {
+	Key         string  `json:"key" binding:"required,max=100"`
+	Name        string  `json:"name" binding:"required,max=255"`
+	Description *string `json:"description"`
+	Rules       Rules   `json:"rules"`
+}
+
+type SegmentListResponse struct {
+	Segments   []Segment `json:"segments"`
+	Limit      int       `json:"limit"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// UserSegmentsResponse lists the segments a user is in
+type UserSegmentsResponse struct {
+	UserID   string    `json:"user_id"`
+	Segments []Segment `json:"segments"`
+}
+
+func NewService(db *db.Connection, cache *cache.RedisClient) *Service {
+	return &Service{db: db, cache: cache}
+}
+
+func (s *Service) CreateSegment(c *gin.Context) {
+	var req CreateSegmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordSegmentOperation("create", "validation_error")
+		return
+	}
+
+	req.Rules.normalize()
+	if err := req.Rules.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordSegmentOperation("create", "validation_error")
+		return
+	}
+
+	ctx := c.Request.Context()
+	segment, err := s.createSegment(ctx, req)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Segment with this key already exists"})
+		telemetry.RecordSegmentOperation("create", "conflict")
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to create segment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSegmentOperation("create", "db_error")
+		return
+	}
+	s.invalidate(ctx)
+
+	c.JSON(http.StatusCreated, segment)
+	telemetry.RecordSegmentOperation("create", "success")
+}
+
+func (s *Service) GetSegment(c *gin.Context) {
+	segment, err := s.getSegment(c.Request.Context(), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Segment not found"})
+		telemetry.RecordSegmentOperation("get", "not_found")
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to get segment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSegmentOperation("get", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, segment)
+	telemetry.RecordSegmentOperation("get", "success")
+}
+
+// ListSegments returns segments newest first using cursor pagination.
+func (s *Service) ListSegments(c *gin.Context) {
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+
+	cursor, err := db.ParseCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		telemetry.RecordSegmentOperation("list", "validation_error")
+		return
+	}
+
+	segments, nextCursor, err := s.listSegments(c.Request.Context(), cursor, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list segments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSegmentOperation("list", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, SegmentListResponse{
+		Segments:   segments,
+		Limit:      limit,
+		NextCursor: nextCursor,
+	})
+	telemetry.RecordSegmentOperation("list", "success")
+}
+
+// DeleteSegment deletes a segment no experiment targets; targeted segments
+// answer 409.
+func (s *Service) DeleteSegment(c *gin.Context) {
+	ctx := c.Request.Context()
+	result, err := s.db.ExecContext(ctx, `DELETE FROM segments WHERE id = $1`, c.Param("id"))
+	if db.IsForeignKeyViolation(err, experimentSegmentConstraint) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Segment is targeted by an experiment"})
+		telemetry.RecordSegmentOperation("delete", "conflict")
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to delete segment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSegmentOperation("delete", "db_error")
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Segment not found"})
+		telemetry.RecordSegmentOperation("delete", "not_found")
+		return
+	}
+	s.invalidate(ctx)
+
+	c.Status(http.StatusNoContent)
+	telemetry.RecordSegmentOperation("delete", "success")
+}
+
+// GetUserSegments lists the segments the user in the id path parameter is
+// in right now.
+func (s *Service) GetUserSegments(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.Param("id")
+
+	segments, err := s.all(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to load segments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSegmentOperation("user_segments", "db_error")
+		return
+	}
+
+	profile, err := s.profile(ctx, userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		telemetry.RecordSegmentOperation("user_segments", "not_found")
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to load segment profile of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSegmentOperation("user_segments", "db_error")
+		return
+	}
+
+	now := time.Now()
+	matched := []Segment{}
+	for _, segment := range segments {
+		if segment.Rules.Match(*profile, now) {
+			matched = append(matched, segment)
+		}
+	}
+
+	c.JSON(http.StatusOK, UserSegmentsResponse{UserID: userID, Segments: matched})
+	telemetry.RecordSegmentOperation("user_segments", "success")
+}
+
+// Keys returns the keys of the segments userID is in, cached for
+// membershipTTL. Unknown users are in no segment.
+func (s *Service) Keys(ctx context.Context, userID string) (map[string]bool, error) {
+	cacheKey := s.cache.Key(fmt.Sprintf("segments:user:%s", userID))
+	cached, err := s.cache.Get(ctx, cacheKey)
+	telemetry.RecordCacheLookup("segment", err == nil)
+	if err == nil && cached != "" {
+		var keys map[string]bool
+		if err := json.Unmarshal([]byte(cached), &keys); err == nil {
+			return keys, nil
+		}
+	}
+
+	segments, err := s.all(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	if len(segments) == 0 {
+		return keys, nil
+	}
+
+	profile, err := s.profile(ctx, userID)
+	if err == sql.ErrNoRows {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, segment := range segments {
+		if segment.Rules.Match(*profile, now) {
+			keys[segment.Key] = true
+		}
+	}
+
+	if data, err := json.Marshal(keys); err == nil {
+		if err := s.cache.Set(ctx, cacheKey, string(data), membershipTTL); err != nil {
+			logrus.Warnf("Failed to cache segments of user %s: %v", userID, err)
+		}
+	}
+	return keys, nil
+}
+
+// InSegment reports whether userID is in the segment with key
+func (s *Service) InSegment(ctx context.Context, userID, key string) (bool, error) {
+	keys, err := s.Keys(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return keys[key], nil
+}
+
+// invalidate drops the cached segments after one is created or deleted.
+// Cached memberships expire on their own within membershipTTL.
+func (s *Service) invalidate(ctx context.Context) {
+	if err := s.cache.Del(ctx, s.cache.Key(allCacheKey)); err != nil {
+		logrus.Warnf("Failed to invalidate segments cache: %v", err)
+	}
+}
+
+// profile gathers what rules look at for userID: their active plan, usage
+// within UsageWindow, signup time and country. It returns sql.ErrNoRows if
+// the user does not exist.
+func (s *Service) profile(ctx context.Context, userID string) (*Profile, error) {
+	var p Profile
+	var planID, country sql.NullString
+	err := s.db.Reader().QueryRowContext(ctx, `
+		SELECT
+			(SELECT s.plan_id::text FROM subscriptions s
+			 WHERE s.user_id = u.id AND s.status = 'active' LIMIT 1),
+			(SELECT COUNT(*) FROM usage_logs ul
+			 WHERE ul.user_id = u.id AND ul.kind = 'usage' AND ul.created_at >= $2),
+			u.created_at, u.country
+		FROM users u WHERE u.id = $1
+	`, userID, time.Now().Add(-UsageWindow)).Scan(&planID, &p.Usage, &p.SignedUpAt, &country)
+	if err != nil {
+		return nil, err
+	}
+	p.PlanID = planID.String
+	p.Country = country.String
+	return &p, nil
+}
+
+// all returns every segment, from cache when possible
+func (s *Service) all(ctx context.Context) ([]Segment, error) {
+	cached, err := s.cache.Get(ctx, s.cache.Key(allCacheKey))
+	telemetry.RecordCacheLookup("segment", err == nil)
+	if err == nil && cached != "" {
+		var segments []Segment
+		if err := json.Unmarshal([]byte(cached), &segments); err == nil {
+			return segments, nil
+		}
+	}
+
+	rows, err := s.db.Reader().QueryContext(ctx, `
+		SELECT `+segmentColumns+`
+		FROM segments ORDER BY created_at, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	segments := []Segment{}
+	for rows.Next() {
+		segment, err := scanSegment(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, *segment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(segments); err == nil {
+		if err := s.cache.Set(ctx, s.cache.Key(allCacheKey), string(data), time.Minute); err != nil {
+			logrus.Warnf("Failed to cache segments: %v", err)
+		}
+	}
+	return segments, nil
+}
+
+// segmentColumns lists the columns scanSegment expects, in order
+const segmentColumns = `id, key, name, description, rules, created_at, updated_at`
+
+func scanSegment(scan func(dest ...interface{}) error) (*Segment, error) {
+	var segment Segment
+	var rules []byte
+	if err := scan(&segment.ID, &segment.Key, &segment.Name, &segment.Description, &rules,
+		&segment.CreatedAt, &segment.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(rules, &segment.Rules); err != nil {
+		return nil, err
+	}
+	return &segment, nil
+}
+
+// createSegment inserts a segment. It returns sql.ErrNoRows if the key is
+// taken.
+func (s *Service) createSegment(ctx context.Context, req CreateSegmentRequest) (*Segment, error) {
+	rules, err := json.Marshal(req.Rules)
+	if err != nil {
+		return nil, err
+	}
+	row := s.db.QueryRowContext(ctx, `
+		INSERT INTO segments (key, name, description, rules)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO NOTHING
+		RETURNING `+segmentColumns,
+		req.Key, req.Name, req.Description, rules)
+	return scanSegment(row.Scan)
+}
+
+func (s *Service) getSegment(ctx context.Context, id string) (*Segment, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+segmentColumns+`
+		FROM segments WHERE id = $1
+	`, id)
+	return scanSegment(row.Scan)
+}
+
+func (s *Service) listSegments(ctx context.Context, cursor *db.Cursor, limit int) ([]Segment, string, error) {
+	query := `
+		SELECT ` + segmentColumns + `
+		FROM segments
+		WHERE ($1::timestamptz IS NULL OR (created_at, id::text) < ($1, $2))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`
+
+	after, afterID := cursor.After()
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := s.db.Reader().QueryContext(ctx, query, after, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var segments []Segment
+	for rows.Next() {
+		segment, err := scanSegment(rows.Scan)
+		if err != nil {
+			return nil, "", err
+		}
+		segments = append(segments, *segment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	segments, nextCursor := db.NextPage(segments, limit, func(row Segment) db.Cursor {
+		return db.Cursor{CreatedAt: row.CreatedAt, ID: row.ID}
+	})
+
+	return segments, nextCursor, nil
+}
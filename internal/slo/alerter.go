@@ -0,0 +1,79 @@
+package slo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/notification"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Alerter delivers SLO alerts
+type Alerter interface {
+	Alert(ctx context.Context, alert Alert) error
+}
+
+// NewAlerter returns a webhook alerter when telemetry.slo.webhook_url is
+// set and a log-only alerter otherwise. Webhooks are signed like
+// notification webhooks.
+func NewAlerter(cfg config.SLOConfig) Alerter {
+	if cfg.WebhookURL == "" {
+		return logAlerter{}
+	}
+	return &webhookAlerter{
+		url:    cfg.WebhookURL,
+		secret: cfg.SigningSecret,
+		client: &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}
+}
+
+type logAlerter struct{}
+
+func (logAlerter) Alert(ctx context.Context, alert Alert) error {
+	logrus.Warnf("SLO alert %s for %s %s (%s)", alert.Status, alert.Objective, alert.SLI, alert.Severity)
+	return nil
+}
+
+type webhookAlerter struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func (w *webhookAlerter) Alert(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set(notification.SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("SLO alert webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+// Package slo tracks service level objectives on the domains timed in
+// domain_operation_duration_seconds and raises alerts when their error
+// budgets burn too fast.
+package slo
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	prometheusClient "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// SLIs an objective can set a target for
+const (
+	SLIAvailability = "availability"
+	SLILatency      = "latency"
+)
+
+// Alert statuses
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Alert is posted when an objective's burn rate crosses a burn rate alert's
+// threshold over both its windows, and again when it no longer does.
+type Alert struct {
+	Objective     string    `json:"objective"`
+	Domain        string    `json:"domain"`
+	SLI           string    `json:"sli"`
+	Severity      string    `json:"severity"`
+	Status        string    `json:"status"`
+	Target        float64   `json:"target"`
+	Threshold     float64   `json:"threshold"`
+	LongWindow    int       `json:"long_window"`
+	ShortWindow   int       `json:"short_window"`
+	LongBurnRate  float64   `json:"long_burn_rate"`
+	ShortBurnRate float64   `json:"short_burn_rate"`
+	At            time.Time `json:"at"`
+}
+
+// BurnRate is an objective's burn rate over a window: its bad events' share
+// of all events over the share its target allows
+type BurnRate struct {
+	Objective string  `json:"objective"`
+	SLI       string  `json:"sli"`
+	Target    float64 `json:"target"`
+	Window    int     `json:"window"`
+	Requests  float64 `json:"requests"`
+	Bad       float64 `json:"bad"`
+	BurnRate  float64 `json:"burn_rate"`
+}
+
+type ReportResponse struct {
+	BurnRates []BurnRate `json:"burn_rates"`
+	Firing    []Alert    `json:"firing"`
+}
+
+// counts are an SLI's cumulative events at a sample
+type counts struct {
+	total, bad float64
+}
+
+// sample holds every SLI's counts at one evaluation, keyed by sliKey
+type sample struct {
+	at     time.Time
+	counts map[sliKey]counts
+}
+
+type sliKey struct {
+	objective, sli string
+}
+
+type alertKey struct {
+	objective, sli, severity string
+}
+
+// Evaluator computes the burn rates of the configured objectives from the
+// process's own histograms, so each instance judges the traffic it serves,
+// and raises alerts through an Alerter.
+type Evaluator struct {
+	cfg      config.SLOConfig
+	gatherer prometheusClient.Gatherer
+	alerter  Alerter
+
+	mu      sync.Mutex
+	samples []sample
+	firing  map[alertKey]Alert
+	latest  []BurnRate
+}
+
+// NewEvaluator creates an evaluator reading gatherer, usually
+// prometheus.DefaultGatherer.
+func NewEvaluator(cfg config.SLOConfig, gatherer prometheusClient.Gatherer, alerter Alerter) *Evaluator {
+	return &Evaluator{cfg: cfg, gatherer: gatherer, alerter: alerter, firing: map[alertKey]Alert{}}
+}
+
+// Run evaluates the objectives every telemetry.slo.interval seconds until
+// ctx is done. It returns at once when there are none.
+func (e *Evaluator) Run(ctx context.Context) {
+	if len(e.cfg.Objectives) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(e.cfg.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Evaluate(ctx, time.Now()); err != nil {
+			logrus.Errorf("Failed to evaluate SLOs: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate samples the histograms at now, recomputes the burn rates and
+// sends alerts that started or stopped firing. An alert that can't be sent
+// is tried again at the next evaluation.
+func (e *Evaluator) Evaluate(ctx context.Context, now time.Time) error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	current := sample{at: now, counts: e.count(families)}
+
+	e.mu.Lock()
+	e.samples = append(e.samples, current)
+	e.prune(now)
+	rates := map[sliKey]map[int]BurnRate{}
+	e.latest = e.latest[:0]
+	for _, objective := range e.cfg.Objectives {
+		for _, t := range targets(objective) {
+			key := sliKey{objective.Name, t.sli}
+			rates[key] = map[int]BurnRate{}
+			for _, window := range e.windows() {
+				rate := e.burnRate(key, t.target, window, current)
+				rates[key][window] = rate
+				e.latest = append(e.latest, rate)
+				telemetry.SetSLOBurnRate(objective.Name, t.sli, window, rate.BurnRate)
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	for _, objective := range e.cfg.Objectives {
+		for _, t := range targets(objective) {
+			for _, burn := range e.cfg.BurnRates {
+				long := rates[sliKey{objective.Name, t.sli}][burn.LongWindow]
+				short := rates[sliKey{objective.Name, t.sli}][burn.ShortWindow]
+				alert := Alert{
+					Objective:     objective.Name,
+					Domain:        objective.Domain,
+					SLI:           t.sli,
+					Severity:      burn.Severity,
+					Status:        StatusResolved,
+					Target:        t.target,
+					Threshold:     burn.Threshold,
+					LongWindow:    burn.LongWindow,
+					ShortWindow:   burn.ShortWindow,
+					LongBurnRate:  long.BurnRate,
+					ShortBurnRate: short.BurnRate,
+					At:            now,
+				}
+				if long.BurnRate >= burn.Threshold && short.BurnRate >= burn.Threshold {
+					alert.Status = StatusFiring
+				}
+				e.transition(ctx, alertKey{objective.Name, t.sli, burn.Severity}, alert)
+			}
+		}
+	}
+	return nil
+}
+
+// transition sends alert if its status changed since the last one sent
+func (e *Evaluator) transition(ctx context.Context, key alertKey, alert Alert) {
+	e.mu.Lock()
+	_, firing := e.firing[key]
+	e.mu.Unlock()
+	if firing == (alert.Status == StatusFiring) {
+		return
+	}
+
+	if err := e.alerter.Alert(ctx, alert); err != nil {
+		logrus.Errorf("Failed to send %s SLO alert for %s %s: %v", alert.Status, alert.Objective, alert.SLI, err)
+		return
+	}
+	logrus.Warnf("SLO alert %s: %s %s burning at %.1fx over %ds and %.1fx over %ds (%s)",
+		alert.Status, alert.Objective, alert.SLI, alert.LongBurnRate, alert.LongWindow,
+		alert.ShortBurnRate, alert.ShortWindow, alert.Severity)
+	telemetry.RecordSLOAlert(alert.Objective, alert.Severity, alert.Status)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if alert.Status == StatusFiring {
+		e.firing[key] = alert
+	} else {
+		delete(e.firing, key)
+	}
+}
+
+// Report returns the burn rates of the last evaluation and the alerts
+// firing.
+func (e *Evaluator) Report(c *gin.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	response := ReportResponse{BurnRates: append([]BurnRate{}, e.latest...), Firing: []Alert{}}
+	for _, alert := range e.firing {
+		response.Firing = append(response.Firing, alert)
+	}
+	sort.Slice(response.Firing, func(i, j int) bool {
+		a, b := response.Firing[i], response.Firing[j]
+		if a.Objective != b.Objective {
+			return a.Objective < b.Objective
+		}
+		if a.SLI != b.SLI {
+			return a.SLI < b.SLI
+		}
+		return a.Severity < b.Severity
+	})
+	c.JSON(http.StatusOK, response)
+}
+
+// burnRate compares current with the sample starting window, or the oldest
+// one while there's less history than that
+func (e *Evaluator) burnRate(key sliKey, target float64, window int, current sample) BurnRate {
+	start := current.at.Add(-time.Duration(window) * time.Second)
+	from := e.samples[0]
+	for _, s := range e.samples {
+		if s.at.After(start) {
+			break
+		}
+		from = s
+	}
+
+	rate := BurnRate{Objective: key.objective, SLI: key.sli, Target: target, Window: window}
+	rate.Requests = current.counts[key].total - from.counts[key].total
+	rate.Bad = current.counts[key].bad - from.counts[key].bad
+	if rate.Requests > 0 {
+		rate.BurnRate = rate.Bad / rate.Requests / (1 - target)
+	}
+	return rate
+}
+
+// prune drops samples no window reaches back to, keeping the one starting
+// the longest window
+func (e *Evaluator) prune(now time.Time) {
+	longest := 0
+	for _, window := range e.windows() {
+		if window > longest {
+			longest = window
+		}
+	}
+	start := now.Add(-time.Duration(longest) * time.Second)
+	drop := 0
+	for drop+1 < len(e.samples) && !e.samples[drop+1].at.After(start) {
+		drop++
+	}
+	e.samples = e.samples[drop:]
+}
+
+// windows are the distinct windows of the burn rate alerts
+func (e *Evaluator) windows() []int {
+	var windows []int
+	seen := map[int]bool{}
+	for _, burn := range e.cfg.BurnRates {
+		for _, window := range []int{burn.LongWindow, burn.ShortWindow} {
+			if !seen[window] {
+				seen[window] = true
+				windows = append(windows, window)
+			}
+		}
+	}
+	return windows
+}
+
+// count totals each objective's SLIs from the domain histogram
+func (e *Evaluator) count(families []*dto.MetricFamily) map[sliKey]counts {
+	result := map[sliKey]counts{}
+	for _, family := range families {
+		if family.GetName() != telemetry.DomainDurationMetric {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			histogram := m.GetHistogram()
+			total := float64(histogram.GetSampleCount())
+
+			for _, objective := range e.cfg.Objectives {
+				if !matches(objective, labels["domain"], labels["operation"]) {
+					continue
+				}
+				if objective.Availability > 0 {
+					key := sliKey{objective.Name, SLIAvailability}
+					c := result[key]
+					c.total += total
+					if labels["status"] == "5xx" {
+						c.bad += total
+					}
+					result[key] = c
+				}
+				if objective.LatencyTarget > 0 {
+					key := sliKey{objective.Name, SLILatency}
+					c := result[key]
+					c.total += total
+					c.bad += total - fastCount(histogram, objective.LatencyMS)
+					result[key] = c
+				}
+			}
+		}
+	}
+	return result
+}
+
+// fastCount is how many observations took at most latencyMS, rounded down
+// to a bucket boundary
+func fastCount(histogram *dto.Histogram, latencyMS int) float64 {
+	limit := float64(latencyMS) / 1000
+	fast := 0.0
+	for _, bucket := range histogram.GetBucket() {
+		// Bucket bounds are powers of two times 1ms, not exact in binary
+		if bucket.GetUpperBound() <= limit*(1+1e-9) && !math.IsInf(bucket.GetUpperBound(), 1) {
+			fast = float64(bucket.GetCumulativeCount())
+		}
+	}
+	return fast
+}
+
+type sliTarget struct {
+	sli    string
+	target float64
+}
+
+// targets are the SLIs objective sets a target for
+func targets(objective config.SLOObjectiveConfig) []sliTarget {
+	var t []sliTarget
+	if objective.Availability > 0 {
+		t = append(t, sliTarget{SLIAvailability, objective.Availability})
+	}
+	if objective.LatencyTarget > 0 {
+		t = append(t, sliTarget{SLILatency, objective.LatencyTarget})
+	}
+	return t
+}
+
+// matches reports whether the objective covers operation in domain
+func matches(objective config.SLOObjectiveConfig, domain, operation string) bool {
+	if objective.Domain != domain {
+		return false
+	}
+	if len(objective.Operations) == 0 {
+		return true
+	}
+	for _, op := range objective.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package slo
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/notification"
+	"scalable-paywall/internal/telemetry"
+
+	prometheusClient "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingAlerter struct {
+	alerts []Alert
+	err    error
+}
+
+func (r *recordingAlerter) Alert(ctx context.Context, alert Alert) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+// newHistogram registers a histogram shaped like the domain one on a
+// registry of its own
+func newHistogram(t *testing.T) (*prometheusClient.HistogramVec, *prometheusClient.Registry) {
+	t.Helper()
+	histogram := prometheusClient.NewHistogramVec(prometheusClient.HistogramOpts{
+		Name:    telemetry.DomainDurationMetric,
+		Buckets: prometheusClient.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"domain", "operation", "status"})
+	registry := prometheusClient.NewRegistry()
+	require.NoError(t, registry.Register(histogram))
+	return histogram, registry
+}
+
+func observe(h *prometheusClient.HistogramVec, domain, operation, status string, seconds float64, n int) {
+	for i := 0; i < n; i++ {
+		h.WithLabelValues(domain, operation, status).Observe(seconds)
+	}
+}
+
+func TestEvaluateFiresAndResolvesAvailabilityAlerts(t *testing.T) {
+	histogram, registry := newHistogram(t)
+	alerter := &recordingAlerter{}
+	e := NewEvaluator(config.SLOConfig{
+		Objectives: []config.SLOObjectiveConfig{{Name: "paywall", Domain: "paywall", Availability: 0.99}},
+		BurnRates:  []config.BurnRateConfig{{Severity: "page", LongWindow: 3600, ShortWindow: 300, Threshold: 10}},
+	}, registry, alerter)
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+
+	require.NoError(t, e.Evaluate(ctx, start))
+	assert.Empty(t, alerter.alerts)
+
+	// 20% of requests failing burns a 1% budget 20 times too fast; other
+	// domains don't count
+	observe(histogram, "paywall", "/api/v1/paywall/check", "2xx", 0.005, 80)
+	observe(histogram, "paywall", "/api/v1/paywall/check", "5xx", 0.005, 20)
+	observe(histogram, "plan", "/api/v1/plans", "5xx", 0.005, 100)
+	require.NoError(t, e.Evaluate(ctx, start.Add(time.Minute)))
+	require.Len(t, alerter.alerts, 1)
+	alert := alerter.alerts[0]
+	assert.Equal(t, StatusFiring, alert.Status)
+	assert.Equal(t, "paywall", alert.Objective)
+	assert.Equal(t, SLIAvailability, alert.SLI)
+	assert.Equal(t, "page", alert.Severity)
+	assert.InDelta(t, 20, alert.LongBurnRate, 1e-9)
+	assert.InDelta(t, 20, alert.ShortBurnRate, 1e-9)
+
+	// Still firing: nothing is sent again
+	require.NoError(t, e.Evaluate(ctx, start.Add(2*time.Minute)))
+	assert.Len(t, alerter.alerts, 1)
+
+	// Healthy traffic clears the short window first, resolving the alert
+	// while the long window still burns
+	observe(histogram, "paywall", "/api/v1/paywall/check", "2xx", 0.005, 1000)
+	require.NoError(t, e.Evaluate(ctx, start.Add(10*time.Minute)))
+	require.Len(t, alerter.alerts, 2)
+	assert.Equal(t, StatusResolved, alerter.alerts[1].Status)
+	assert.Zero(t, alerter.alerts[1].ShortBurnRate)
+	assert.Greater(t, alerter.alerts[1].LongBurnRate, 1.0)
+}
+
+func TestEvaluateRetriesUndeliveredAlerts(t *testing.T) {
+	histogram, registry := newHistogram(t)
+	alerter := &recordingAlerter{err: io.ErrUnexpectedEOF}
+	e := NewEvaluator(config.SLOConfig{
+		Objectives: []config.SLOObjectiveConfig{{Name: "checks", Domain: "paywall", LatencyMS: 50, LatencyTarget: 0.9}},
+		BurnRates:  []config.BurnRateConfig{{Severity: "page", LongWindow: 3600, ShortWindow: 300, Threshold: 2}},
+	}, registry, alerter)
+	ctx := context.Background()
+	start := time.Unix(1700000000, 0)
+
+	require.NoError(t, e.Evaluate(ctx, start))
+	// 50ms rounds down to the 32ms bucket, so 40ms requests are slow
+	observe(histogram, "paywall", "/api/v1/paywall/check", "2xx", 0.040, 50)
+	observe(histogram, "paywall", "/api/v1/paywall/check", "2xx", 0.010, 50)
+	require.NoError(t, e.Evaluate(ctx, start.Add(time.Minute)))
+	assert.Empty(t, alerter.alerts)
+
+	alerter.err = nil
+	require.NoError(t, e.Evaluate(ctx, start.Add(2*time.Minute)))
+	require.Len(t, alerter.alerts, 1)
+	assert.Equal(t, SLILatency, alerter.alerts[0].SLI)
+	assert.InDelta(t, 5, alerter.alerts[0].LongBurnRate, 1e-9)
+}
+
+func TestMatches(t *testing.T) {
+	all := config.SLOObjectiveConfig{Domain: "paywall"}
+	assert.True(t, matches(all, "paywall", "/api/v1/paywall/check"))
+	assert.False(t, matches(all, "plan", "/api/v1/plans"))
+
+	some := config.SLOObjectiveConfig{Domain: "paywall", Operations: []string{"/api/v1/paywall/check"}}
+	assert.True(t, matches(some, "paywall", "/api/v1/paywall/check"))
+	assert.False(t, matches(some, "paywall", "/api/v1/paywall/enforce"))
+}
+
+func TestWebhookAlerterSignsAlerts(t *testing.T) {
+	var body []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(notification.SignatureHeader)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	alerter := NewAlerter(config.SLOConfig{WebhookURL: server.URL, SigningSecret: "whsec_slo", Timeout: 5})
+	require.NoError(t, alerter.Alert(context.Background(), Alert{Objective: "paywall", SLI: SLILatency, Status: StatusFiring}))
+
+	var alert Alert
+	require.NoError(t, json.Unmarshal(body, &alert))
+	assert.Equal(t, "paywall", alert.Objective)
+	mac := hmac.New(sha256.New, []byte("whsec_slo"))
+	mac.Write(body)
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), signature)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	alerter = NewAlerter(config.SLOConfig{WebhookURL: failing.URL, Timeout: 5})
+	assert.Error(t, alerter.Alert(context.Background(), Alert{}))
+}
@@ -0,0 +1,87 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ErrNotCancellable is returned when cancelling a subscription that no
+// longer grants access.
+var ErrNotCancellable = errors.New("subscription is not active")
+
+// Cancellation is an immediate cancellation: access ends at CancelledAt
+// instead of at the end of the period the customer paid for.
+type Cancellation struct {
+	Subscription *Subscription
+	PeriodStart  time.Time
+	PeriodEnd    time.Time
+	CancelledAt  time.Time
+}
+
+// UnusedFraction is the share of the paid period left at cancellation,
+// between 0 and 1.
+func (c *Cancellation) UnusedFraction() decimal.Decimal {
+	return unusedFraction(c.PeriodStart, c.PeriodEnd, c.CancelledAt)
+}
+
+// unusedFraction is the share of the period from start to end left at at
+func unusedFraction(start, end, at time.Time) decimal.Decimal {
+	period := end.Sub(start)
+	unused := end.Sub(at)
+	if period <= 0 || unused <= 0 {
+		return decimal.Zero
+	}
+	if unused >= period {
+		return decimal.NewFromInt(1)
+	}
+	return decimal.NewFromInt(int64(unused)).Div(decimal.NewFromInt(int64(period)))
+}
+
+// CancelImmediately cancels an active or past_due subscription and ends its
+// period now. The period being cut short started one billing cycle before
+// the end date, or at the start date for a first period.
+func (s *Service) CancelImmediately(ctx context.Context, id string) (*Cancellation, error) {
+	sub, err := s.getSubscriptionByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Status != "active" && sub.Status != "past_due" {
+		return nil, ErrNotCancellable
+	}
+
+	now := time.Now()
+	cancellation := &Cancellation{
+		Subscription: sub,
+		PeriodStart:  currentPeriodStart(sub),
+		PeriodEnd:    sub.EndDate,
+		CancelledAt:  now,
+	}
+
+	sub.Status = "cancelled"
+	if sub.EndDate.After(now) {
+		sub.EndDate = now
+	}
+	sub.UpdatedAt = now
+	if err := s.updateSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+
+	s.cacheSubscription(ctx, sub)
+	s.declineRetentionOffers(ctx, sub.ID)
+	s.downgradeToFree(ctx, sub)
+	return cancellation, nil
+}
+
+func currentPeriodStart(sub *Subscription) time.Time {
+	cycle := cycleOf(sub)
+	start := cycle.PeriodStart(sub.EndDate)
+	// A first period runs from the start date, up to a full cycle longer
+	// when it was stretched to reach the anchor day
+	if cycle.PeriodStart(start).Before(sub.StartDate) {
+		return sub.StartDate
+	}
+	return start
+}
@@ -0,0 +1,65 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnusedFraction(t *testing.T) {
+	start := time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name        string
+		cancelledAt time.Time
+		want        string
+	}{
+		{"before the period", start.AddDate(0, 0, -1), "1"},
+		{"a third in", start.AddDate(0, 0, 10), "0.6667"},
+		{"halfway", start.AddDate(0, 0, 15), "0.5"},
+		{"after the period", end.Add(time.Hour), "0"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Cancellation{PeriodStart: start, PeriodEnd: end, CancelledAt: tc.cancelledAt}
+			assert.Equal(t, tc.want, c.UnusedFraction().Round(4).String())
+		})
+	}
+}
+
+func TestCurrentPeriodStart(t *testing.T) {
+	renewed := &Subscription{
+		StartDate: time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2024, time.May, 15, 0, 0, 0, 0, time.UTC),
+	}
+	assert.Equal(t, time.Date(2024, time.April, 15, 0, 0, 0, 0, time.UTC), currentPeriodStart(renewed))
+
+	// A first period shorter than a month starts at the start date
+	first := &Subscription{
+		StartDate: time.Date(2024, time.April, 20, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2024, time.May, 15, 0, 0, 0, 0, time.UTC),
+	}
+	assert.Equal(t, first.StartDate, currentPeriodStart(first))
+}
+
+func TestCurrentPeriodStartAligned(t *testing.T) {
+	anchor := 1
+	snapshot := &PlanSnapshot{BillingCycle: "monthly", BillingInterval: 1, BillingAnchorDay: &anchor}
+
+	// The first period was stretched from the 17th to the second anchor day
+	first := &Subscription{
+		StartDate:    time.Date(2024, time.January, 17, 0, 0, 0, 0, time.UTC),
+		EndDate:      time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		PlanSnapshot: snapshot,
+	}
+	assert.Equal(t, first.StartDate, currentPeriodStart(first))
+
+	renewed := &Subscription{
+		StartDate:    first.StartDate,
+		EndDate:      time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC),
+		PlanSnapshot: snapshot,
+	}
+	assert.Equal(t, time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), currentPeriodStart(renewed))
+}
@@ -0,0 +1,111 @@
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// changeLookback is how far back each poll of subscription history reads.
+// Events are stamped when their transaction starts, so one can commit
+// after later ones were already relayed; rereading a window catches it.
+const changeLookback = 30 * time.Second
+
+// EntitlementChange is a change to a user's subscription, recorded in its
+// history: created, status_changed, plan_changed, period_extended or
+// renewal_failed. Status is the subscription's status when it was relayed.
+type EntitlementChange struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id"`
+	SubscriptionID string    `json:"subscription_id"`
+	Event          string    `json:"event"`
+	From           string    `json:"from,omitempty"`
+	To             string    `json:"to,omitempty"`
+	Status         string    `json:"status"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ChangesChannel is the Redis channel a user's entitlement changes are
+// published on
+func ChangesChannel(userID string) string {
+	return fmt.Sprintf("entitlement_changes:%s", userID)
+}
+
+// RelayChanges publishes subscription history to each user's changes
+// channel every interval until ctx is done, dropping their cached
+// entitlement first so listeners that check access see the change.
+// Subscriptions change from many places (renewals, webhooks, sweeps), so
+// their history, which a trigger records for all of them, is the one feed
+// of changes. Every instance may run it; each event is published once.
+func (s *Service) RelayChanges(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.relayChanges(ctx); err != nil && ctx.Err() == nil {
+				logrus.Errorf("Failed to relay entitlement changes: %v", err)
+			}
+		}
+	}
+}
+
+func (s *Service) relayChanges(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.id, s.user_id, e.subscription_id, e.event, COALESCE(e.from_value, ''),
+			COALESCE(e.to_value, ''), s.status, e.created_at
+		FROM subscription_events e
+		JOIN subscriptions s ON s.id = e.subscription_id
+		WHERE e.created_at > NOW() - $1::interval
+		ORDER BY e.created_at, e.id`, fmt.Sprintf("%d seconds", int(changeLookback.Seconds())))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var changes []EntitlementChange
+	for rows.Next() {
+		var change EntitlementChange
+		if err := rows.Scan(&change.ID, &change.UserID, &change.SubscriptionID, &change.Event,
+			&change.From, &change.To, &change.Status, &change.CreatedAt); err != nil {
+			return err
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		// Whichever instance claims the event first publishes it
+		claimed, err := s.cache.SetNX(ctx, changeClaimKey(change.ID), 1, 2*changeLookback)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		s.invalidateEntitlement(ctx, change.UserID)
+
+		message, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		if err := s.cache.Publish(ctx, ChangesChannel(change.UserID), string(message)); err != nil {
+			// Leave it for the next poll
+			s.cache.Del(ctx, changeClaimKey(change.ID))
+			return err
+		}
+	}
+	return nil
+}
+
+func changeClaimKey(eventID string) string {
+	return fmt.Sprintf("entitlement_change:%s", eventID)
+}
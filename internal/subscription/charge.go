@@ -0,0 +1,96 @@
+package subscription
+
+import (
+	"context"
+
+	"scalable-paywall/internal/money"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// Charge is what a new subscription costs, worked out from the plan rather
+// than taken from the client. Price is the plan's list price unless a price
+// experiment the user is in overrides it, in which case Experiment and
+// Variant name the override.
+type Charge struct {
+	ListPrice  decimal.Decimal `json:"list_price"`
+	Price      decimal.Decimal `json:"price"`
+	Tax        decimal.Decimal `json:"tax"`
+	Total      decimal.Decimal `json:"total"`
+	Currency   string          `json:"currency"`
+	Experiment string          `json:"experiment,omitempty"`
+	Variant    string          `json:"variant,omitempty"`
+}
+
+// chargedPlan is the part of a plan pricing and billing a subscription needs
+type chargedPlan struct {
+	ID       string
+	Price    decimal.Decimal
+	Currency string
+	Free     bool
+	Active   bool
+	Cycle    BillingCycle
+}
+
+func (s *Service) getChargedPlan(ctx context.Context, planID string) (*chargedPlan, error) {
+	plan := &chargedPlan{ID: planID}
+	var planType string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT price, COALESCE(currency, 'USD'), plan_type, COALESCE(is_active, true),
+			billing_cycle, billing_interval, COALESCE(billing_anchor_day, 0)
+		FROM plans WHERE id = $1
+	`, planID).Scan(&plan.Price, &plan.Currency, &planType, &plan.Active,
+		&plan.Cycle.Unit, &plan.Cycle.Interval, &plan.Cycle.AnchorDay)
+	if err != nil {
+		return nil, err
+	}
+	plan.Free = planType == "free"
+	return plan, nil
+}
+
+// resolveCharge prices plan for userID. Free plans are never part of a price
+// experiment. Failing to load experiments falls back to the list price.
+func (s *Service) resolveCharge(ctx context.Context, plan *chargedPlan, userID string) Charge {
+	charge := Charge{ListPrice: plan.Price, Price: plan.Price, Currency: plan.Currency}
+	if !plan.Free && s.experiments != nil {
+		assignments, err := s.experiments.Assign(ctx, userID)
+		if err != nil {
+			logrus.Warnf("Failed to load price experiments for user %s: %v", userID, err)
+		}
+		for _, assignment := range assignments {
+			if price, ok := assignment.Variant.Prices[plan.ID]; ok {
+				charge.Price = price
+				charge.Experiment = assignment.Experiment
+				charge.Variant = assignment.Variant.Key
+			}
+		}
+	}
+	return finishCharge(charge)
+}
+
+// finishCharge rounds the price to the currency and adds tax.
+func finishCharge(charge Charge) Charge {
+	charge.Price = money.Round(charge.Price, charge.Currency)
+	charge.Tax = taxOn(charge.Price, charge.Currency)
+	charge.Total = charge.Price.Add(charge.Tax)
+	return charge
+}
+
+// taxOn is the tax added to amount in currency. New subscriptions, plan
+// changes and renewal previews all take their tax from here. Prices are tax
+// inclusive until a tax engine is wired in, so it is zero.
+func taxOn(amount decimal.Decimal, currency string) decimal.Decimal {
+	return decimal.Zero
+}
+
+// MatchesClient reports whether the amount and currency a client sent, if
+// any, agree with the charge. Clients may omit both. Every path that starts
+// a subscription from a client request, CreateSubscription and payment
+// intents alike, charges the resolved Total and rejects a mismatch.
+func (c Charge) MatchesClient(amount *decimal.Decimal, currency string) bool {
+	if amount != nil && !amount.Equal(c.Total) {
+		return false
+	}
+	return currency == "" || currency == c.Currency
+}
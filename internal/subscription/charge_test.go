@@ -0,0 +1,26 @@
+package subscription
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChargeMatchesClient(t *testing.T) {
+	charge := finishCharge(Charge{
+		ListPrice: decimal.RequireFromString("19.99"),
+		Price:     decimal.RequireFromString("14.999"),
+		Currency:  "USD",
+	})
+	assert.Equal(t, "15", charge.Total.String())
+
+	amount := func(s string) *decimal.Decimal {
+		d := decimal.RequireFromString(s)
+		return &d
+	}
+	assert.True(t, charge.MatchesClient(nil, ""))
+	assert.True(t, charge.MatchesClient(amount("15.00"), "USD"))
+	assert.False(t, charge.MatchesClient(amount("19.99"), ""))
+	assert.False(t, charge.MatchesClient(nil, "EUR"))
+}
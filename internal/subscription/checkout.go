@@ -0,0 +1,116 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ErrPlanUnavailable is returned when a plan can't be bought: it is
+// inactive, or free and so needs no checkout.
+var ErrPlanUnavailable = errors.New("plan is not available")
+
+// QuoteCharge prices a paid plan for userID, for checkouts and payment
+// intents that charge before subscribing. It
+// returns sql.ErrNoRows if the plan does not exist and ErrPlanUnavailable if
+// it is inactive or free.
+func (s *Service) QuoteCharge(ctx context.Context, userID, planID string) (Charge, error) {
+	plan, err := s.getChargedPlan(ctx, planID)
+	if err != nil {
+		return Charge{}, err
+	}
+	if !plan.Active || plan.Free {
+		return Charge{}, ErrPlanUnavailable
+	}
+	return s.resolveCharge(ctx, plan, userID), nil
+}
+
+// CheckoutOrder is a subscription bought through a checkout session. Amount
+// is what renewals charge; DiscountPercent comes off the DiscountRenewals
+// renewals after the first charge.
+type CheckoutOrder struct {
+	UserID           string
+	PlanID           string
+	PaymentMethod    string
+	Amount           decimal.Decimal
+	Currency         string
+	AutoRenew        bool
+	DiscountPercent  int
+	DiscountRenewals int
+	// Pending leaves the subscription without access until ActivatePending,
+	// for a first charge that has not settled yet
+	Pending bool
+}
+
+// CreateFromCheckout creates the subscription order paid for and calls
+// record in the same transaction, so the subscription exists only if record
+// succeeds and the checkout that bought it is completed with it. An active
+// subscription replaces the user's free one; it returns ErrAlreadySubscribed
+// if the user has another paid subscription.
+func (s *Service) CreateFromCheckout(ctx context.Context, order CheckoutOrder, record func(tx *sql.Tx, sub *Subscription) error) (*Subscription, error) {
+	plan, err := s.getChargedPlan(ctx, order.PlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sub := &Subscription{
+		ID:               generateID(),
+		UserID:           order.UserID,
+		PlanID:           order.PlanID,
+		Status:           "active",
+		StartDate:        now,
+		EndDate:          plan.Cycle.PeriodEnd(now),
+		AutoRenew:        order.AutoRenew,
+		PaymentMethod:    order.PaymentMethod,
+		Amount:           order.Amount,
+		Currency:         order.Currency,
+		DiscountPercent:  order.DiscountPercent,
+		DiscountRenewals: order.DiscountRenewals,
+		Version:          1,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if sub.DiscountRenewals == 0 {
+		sub.DiscountPercent = 0
+	}
+	write := func(tx *sql.Tx) error {
+		if err := insertSubscription(ctx, tx, sub); err != nil {
+			return err
+		}
+		return record(tx, sub)
+	}
+
+	if order.Pending {
+		// The free subscription stays until the payment settles
+		sub.Status = statusPending
+		err = s.inTx(ctx, write)
+	} else {
+		err = s.replaceFree(ctx, order.UserID, write)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !order.Pending {
+		s.cacheSubscription(ctx, sub)
+		s.recordConversion(ctx, sub)
+	}
+	return sub, nil
+}
+
+func (s *Service) inTx(ctx context.Context, write func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := write(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
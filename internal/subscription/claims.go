@@ -0,0 +1,214 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ErrNotRetryable is returned when a charge retry is asked for a
+// subscription that is not past_due within its grace period, belongs to a
+// blocked user, or is being charged by a renewal worker right now.
+var ErrNotRetryable = errors.New("subscription has no renewal charge to retry")
+
+// billingPausedUsers selects users whose billing is paused because their
+// account is suspended or banned. Their subscriptions are neither renewed,
+// retried nor lapsed until the account is reinstated.
+const billingPausedUsers = `SELECT id FROM users WHERE status IN ('suspended', 'banned')`
+
+// RenewalClaim is a subscription leased to one renewal or dunning worker.
+// Attempts counts the failed charges since the last successful renewal.
+type RenewalClaim struct {
+	Subscription Subscription
+	Attempts     int
+}
+
+// Amount is what the renewal charges: the subscription's amount, less any
+// win-back discount with renewals left.
+func (c RenewalClaim) Amount() decimal.Decimal {
+	sub := c.Subscription
+	if sub.DiscountRenewals > 0 {
+		return discountedAmount(sub.Amount, sub.Currency, sub.DiscountPercent)
+	}
+	return sub.Amount
+}
+
+// ClaimDueRenewals leases up to limit auto-renewing subscriptions that end
+// within lead. Rows locked or leased by another worker are skipped, so
+// workers on several instances each get a disjoint batch, as are those
+// whose last charge is still settling.
+func (s *Service) ClaimDueRenewals(ctx context.Context, limit int, lead, lease time.Duration) ([]RenewalClaim, error) {
+	return s.claim(ctx, `
+		SELECT id FROM subscriptions
+		WHERE status = 'active'
+			AND auto_renew
+			AND end_date <= NOW() + make_interval(secs => $3)
+			AND (claimed_until IS NULL OR claimed_until < NOW())
+			AND pending_charge_id IS NULL
+			AND user_id NOT IN (`+billingPausedUsers+`)
+		ORDER BY end_date
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, limit, lease, lead.Seconds())
+}
+
+// ClaimDunningRetries leases up to limit past_due subscriptions whose next
+// charge retry is due and whose grace period has not ended. Subscriptions
+// that were marked past_due without a renewal attempt are retried at once.
+func (s *Service) ClaimDunningRetries(ctx context.Context, limit int, lease time.Duration) ([]RenewalClaim, error) {
+	return s.claim(ctx, `
+		SELECT s.id FROM subscriptions s
+		JOIN plans p ON p.id = s.plan_id
+		WHERE s.status = 'past_due'
+			AND s.auto_renew
+			AND (s.next_retry_at <= NOW() OR (s.next_retry_at IS NULL AND s.renewal_attempts = 0))
+			AND s.end_date + make_interval(days => p.grace_period_days) > NOW()
+			AND (s.claimed_until IS NULL OR s.claimed_until < NOW())
+			AND s.pending_charge_id IS NULL
+			AND s.user_id NOT IN (`+billingPausedUsers+`)
+		ORDER BY s.next_retry_at NULLS FIRST
+		LIMIT $1
+		FOR UPDATE OF s SKIP LOCKED
+	`, limit, lease)
+}
+
+// ClaimPastDue leases one past_due subscription for a charge retry out of
+// the dunning schedule. It returns sql.ErrNoRows if the subscription does
+// not exist and ErrNotRetryable if it can't be retried now.
+func (s *Service) ClaimPastDue(ctx context.Context, id string, lease time.Duration) (*RenewalClaim, error) {
+	claims, err := s.claim(ctx, `
+		SELECT s.id FROM subscriptions s
+		JOIN plans p ON p.id = s.plan_id
+		WHERE s.id = $3
+			AND s.status = 'past_due'
+			AND s.end_date + make_interval(days => p.grace_period_days) > NOW()
+			AND (s.claimed_until IS NULL OR s.claimed_until < NOW())
+			AND s.pending_charge_id IS NULL
+			AND s.user_id NOT IN (`+billingPausedUsers+`)
+		LIMIT $1
+		FOR UPDATE OF s SKIP LOCKED
+	`, 1, lease, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(claims) == 0 {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM subscriptions WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, sql.ErrNoRows
+		}
+		return nil, ErrNotRetryable
+	}
+	return &claims[0], nil
+}
+
+// ClaimUserRenewals leases the user's auto-renewing subscriptions for a
+// renewal charge now, whatever their end date or dunning schedule: active
+// ones and past_due ones within their grace period. Subscriptions leased by
+// a worker or with a charge still settling are left alone, as are all of a
+// blocked user's.
+func (s *Service) ClaimUserRenewals(ctx context.Context, userID string, lease time.Duration) ([]RenewalClaim, error) {
+	return s.claim(ctx, `
+		SELECT s.id FROM subscriptions s
+		JOIN plans p ON p.id = s.plan_id
+		WHERE s.user_id = $3
+			AND s.auto_renew
+			AND (s.status = 'active'
+				OR (s.status = 'past_due' AND s.end_date + make_interval(days => p.grace_period_days) > NOW()))
+			AND (s.claimed_until IS NULL OR s.claimed_until < NOW())
+			AND s.pending_charge_id IS NULL
+			AND s.user_id NOT IN (`+billingPausedUsers+`)
+		LIMIT $1
+		FOR UPDATE OF s SKIP LOCKED
+	`, maxUserRenewals, lease, userID)
+}
+
+// maxUserRenewals bounds the subscriptions ClaimUserRenewals leases; a user
+// has one active subscription, so this only guards against bad data
+const maxUserRenewals = 10
+
+// claim locks the rows selected by candidates (which takes the batch size as
+// $1) and leases them in the same statement, so the lock is only held for
+// the claim itself rather than for the gateway calls that follow.
+func (s *Service) claim(ctx context.Context, candidates string, limit int, lease time.Duration, args ...interface{}) ([]RenewalClaim, error) {
+	query := `
+		UPDATE subscriptions
+		SET claimed_until = NOW() + make_interval(secs => $2)
+		WHERE id IN (` + candidates + `)
+		RETURNING id, user_id, plan_id, status, start_date, end_date, auto_renew,
+			payment_method, amount, currency, version, created_at, updated_at, renewal_attempts,
+			discount_percent, discount_renewals, plan_snapshot
+	`
+	rows, err := s.db.QueryContext(ctx, query, append([]interface{}{limit, lease.Seconds()}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claims []RenewalClaim
+	for rows.Next() {
+		var claim RenewalClaim
+		var snapshot []byte
+		sub := &claim.Subscription
+		if err := rows.Scan(
+			&sub.ID, &sub.UserID, &sub.PlanID, &sub.Status, &sub.StartDate, &sub.EndDate,
+			&sub.AutoRenew, &sub.PaymentMethod, &sub.Amount, &sub.Currency,
+			&sub.Version, &sub.CreatedAt, &sub.UpdatedAt, &claim.Attempts,
+			&sub.DiscountPercent, &sub.DiscountRenewals, &snapshot); err != nil {
+			return nil, err
+		}
+		var err error
+		if sub.PlanSnapshot, err = decodePlanSnapshot(snapshot); err != nil {
+			return nil, err
+		}
+		claims = append(claims, claim)
+	}
+	return claims, rows.Err()
+}
+
+// CompleteRenewal records a successful renewal charge: the subscription is
+// active until periodEnd, its dunning state and any pause are cleared, one
+// discounted renewal is used up and the claim released.
+func (s *Service) CompleteRenewal(ctx context.Context, id string, periodEnd time.Time) error {
+	return s.finishClaim(ctx, id, `
+		UPDATE subscriptions
+		SET status = 'active', end_date = $2, renewal_attempts = 0, next_retry_at = NULL,
+			paused_from = NULL,
+			discount_percent = CASE WHEN discount_renewals > 1 THEN discount_percent ELSE 0 END,
+			discount_renewals = GREATEST(discount_renewals - 1, 0),
+			claimed_until = NULL, updated_at = NOW(), version = version + 1
+		WHERE id = $1
+	`, periodEnd)
+}
+
+// FailRenewal records a failed renewal charge and moves the subscription into
+// past_due. The charge is retried at retryAt; nil stops retrying and leaves
+// the subscription to expire when its grace period ends.
+func (s *Service) FailRenewal(ctx context.Context, id string, retryAt *time.Time) error {
+	return s.finishClaim(ctx, id, `
+		UPDATE subscriptions
+		SET status = 'past_due', renewal_attempts = renewal_attempts + 1, next_retry_at = $2,
+			claimed_until = NULL, updated_at = NOW(), version = version + 1
+		WHERE id = $1
+	`, retryAt)
+}
+
+// ReleaseClaim gives a subscription back without changing it, e.g. when the
+// gateway is unavailable, so the next sweep on any instance picks it up.
+func (s *Service) ReleaseClaim(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE subscriptions SET claimed_until = NULL WHERE id = $1`, id)
+	return err
+}
+
+func (s *Service) finishClaim(ctx context.Context, id, query string, arg interface{}) error {
+	if _, err := s.db.ExecContext(ctx, query, id, arg); err != nil {
+		return err
+	}
+	s.subscriptions.Forget(ctx, id)
+	return nil
+}
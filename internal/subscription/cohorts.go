@@ -0,0 +1,177 @@
+package subscription
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// CohortReport groups subscriptions into monthly signup cohorts per plan and
+// follows how many are retained in each month since signup.
+type CohortReport struct {
+	Months  int      `json:"months"`
+	PlanID  string   `json:"plan_id,omitempty"`
+	Cohorts []Cohort `json:"cohorts"`
+}
+
+// Cohort is the subscriptions to a plan started in Month (YYYY-MM).
+// Retention[k] covers the k-th month after signup; months still in the
+// future are left out, so younger cohorts have shorter curves.
+type Cohort struct {
+	Month     string            `json:"month"`
+	PlanID    string            `json:"plan_id"`
+	Size      int               `json:"size"`
+	Retention []RetentionPeriod `json:"retention"`
+}
+
+type RetentionPeriod struct {
+	Month    int     `json:"month"`
+	Retained int     `json:"retained"`
+	Rate     float64 `json:"rate"`
+}
+
+// cohortCount is the number of subscriptions of one cohort that churned in
+// ChurnMonth, or are still subscribed when ChurnMonth is nil
+type cohortCount struct {
+	PlanID      string
+	CohortMonth time.Time
+	ChurnMonth  *time.Time
+	Count       int
+}
+
+// GetCohorts serves GET /analytics/cohorts: signup cohorts of the last
+// ?months= months (default 12, at most 36), optionally for one ?plan_id=.
+// A subscription counts as retained in a month if it had not churned
+// (been cancelled or expired) before that month began.
+func (s *Service) GetCohorts(c *gin.Context) {
+	months := 12
+	if monthsStr := c.Query("months"); monthsStr != "" {
+		m, err := strconv.Atoi(monthsStr)
+		if err != nil || m < 1 || m > 36 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "months must be between 1 and 36"})
+			telemetry.RecordSubscriptionOperation("cohorts", "validation_error")
+			return
+		}
+		months = m
+	}
+	planID := c.Query("plan_id")
+
+	now := time.Now().UTC()
+	counts, err := s.cohortCounts(c.Request.Context(), cohortStart(now, months), planID)
+	if err != nil {
+		logrus.Errorf("Failed to compute subscription cohorts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation("cohorts", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, CohortReport{
+		Months:  months,
+		PlanID:  planID,
+		Cohorts: buildCohorts(counts, now),
+	})
+	telemetry.RecordSubscriptionOperation("cohorts", "success")
+}
+
+// cohortStart is the first day of the oldest of the last months months
+func cohortStart(now time.Time, months int) time.Time {
+	return time.Date(now.Year(), now.Month()-time.Month(months-1), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// buildCohorts turns churn counts into retention curves, oldest cohort
+// first and plans in ID order within a month.
+func buildCohorts(counts []cohortCount, now time.Time) []Cohort {
+	type cohortKey struct {
+		month  time.Time
+		planID string
+	}
+	grouped := make(map[cohortKey][]cohortCount)
+	for _, count := range counts {
+		key := cohortKey{month: count.CohortMonth.UTC(), planID: count.PlanID}
+		grouped[key] = append(grouped[key], count)
+	}
+
+	keys := make([]cohortKey, 0, len(grouped))
+	for key := range grouped {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].month.Equal(keys[j].month) {
+			return keys[i].month.Before(keys[j].month)
+		}
+		return keys[i].planID < keys[j].planID
+	})
+
+	cohorts := make([]Cohort, 0, len(keys))
+	for _, key := range keys {
+		cohort := Cohort{Month: key.month.Format("2006-01"), PlanID: key.planID}
+		for _, count := range grouped[key] {
+			cohort.Size += count.Count
+		}
+
+		elapsed := monthsBetween(key.month, now)
+		for k := 0; k <= elapsed; k++ {
+			periodStart := key.month.AddDate(0, k, 0)
+			retained := 0
+			for _, count := range grouped[key] {
+				// Churn during a month still counts the subscription for it
+				if count.ChurnMonth == nil || !count.ChurnMonth.UTC().Before(periodStart) {
+					retained += count.Count
+				}
+			}
+			period := RetentionPeriod{Month: k, Retained: retained}
+			if cohort.Size > 0 {
+				period.Rate = float64(retained) / float64(cohort.Size)
+			}
+			cohort.Retention = append(cohort.Retention, period)
+		}
+		cohorts = append(cohorts, cohort)
+	}
+	return cohorts
+}
+
+func monthsBetween(from, to time.Time) int {
+	return (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+}
+
+// cohortCounts counts subscriptions started since from by plan, signup month
+// and churn month. Cancellation only changes status, so updated_at stands in
+// for the churn date of cancelled subscriptions.
+func (s *Service) cohortCounts(ctx context.Context, from time.Time, planID string) ([]cohortCount, error) {
+	ctx = db.WithQueryName(ctx, "subscription_cohorts")
+
+	rows, err := s.db.Reader().QueryContext(ctx, `
+		SELECT plan_id::text,
+			date_trunc('month', start_date AT TIME ZONE 'UTC') AS cohort_month,
+			CASE WHEN status IN ('cancelled', 'expired')
+				THEN date_trunc('month', LEAST(end_date, updated_at) AT TIME ZONE 'UTC')
+			END AS churn_month,
+			COUNT(*)
+		FROM subscriptions
+		WHERE status NOT IN ('pending', 'pending_payment') AND start_date >= $1
+			AND ($2 = '' OR plan_id::text = $2)
+		GROUP BY 1, 2, 3
+	`, from, planID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []cohortCount
+	for rows.Next() {
+		var count cohortCount
+		if err := rows.Scan(&count.PlanID, &count.CohortMonth, &count.ChurnMonth, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,43 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func month(year int, m time.Month) time.Time {
+	return time.Date(year, m, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func TestBuildCohortsRetentionCurve(t *testing.T) {
+	feb, mar := month(2024, time.February), month(2024, time.March)
+	counts := []cohortCount{
+		{PlanID: "pro", CohortMonth: month(2024, time.January), Count: 6},
+		{PlanID: "pro", CohortMonth: month(2024, time.January), ChurnMonth: &feb, Count: 2},
+		{PlanID: "pro", CohortMonth: month(2024, time.January), ChurnMonth: &mar, Count: 2},
+		{PlanID: "basic", CohortMonth: month(2024, time.March), Count: 3},
+	}
+	now := time.Date(2024, time.March, 15, 0, 0, 0, 0, time.UTC)
+
+	cohorts := buildCohorts(counts, now)
+
+	assert.Len(t, cohorts, 2)
+	assert.Equal(t, "2024-01", cohorts[0].Month)
+	assert.Equal(t, 10, cohorts[0].Size)
+	assert.Equal(t, []RetentionPeriod{
+		{Month: 0, Retained: 10, Rate: 1},
+		{Month: 1, Retained: 10, Rate: 1},
+		{Month: 2, Retained: 8, Rate: 0.8},
+	}, cohorts[0].Retention)
+	assert.Equal(t, "basic", cohorts[1].PlanID)
+	assert.Len(t, cohorts[1].Retention, 1)
+}
+
+func TestCohortStart(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 10, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, month(2024, time.March), cohortStart(now, 1))
+	assert.Equal(t, month(2023, time.April), cohortStart(now, 12))
+}
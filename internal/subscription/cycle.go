@@ -0,0 +1,127 @@
+package subscription
+
+import "time"
+
+// BillingCycle is how often a subscription renews: every Interval units of
+// Unit (daily, weekly, monthly or yearly). Monthly and yearly periods end on
+// AnchorDay of the month, or the month's last day if it is shorter.
+type BillingCycle struct {
+	Unit      string
+	Interval  int
+	AnchorDay int
+}
+
+// cycleOf is the billing cycle sub was bought on. Without an anchor day
+// periods end on the day of the month the subscription started, so a
+// subscription started on the 31st renews on the last day of shorter months
+// and goes back to the 31st after them.
+func cycleOf(sub *Subscription) BillingCycle {
+	cycle := BillingCycle{Unit: "monthly", Interval: 1}
+	if snapshot := sub.PlanSnapshot; snapshot != nil {
+		if snapshot.BillingCycle != "" {
+			cycle.Unit = snapshot.BillingCycle
+		}
+		if snapshot.BillingInterval > 0 {
+			cycle.Interval = snapshot.BillingInterval
+		}
+		if snapshot.BillingAnchorDay != nil {
+			cycle.AnchorDay = *snapshot.BillingAnchorDay
+		}
+	}
+	if cycle.AnchorDay == 0 {
+		cycle.AnchorDay = sub.StartDate.Day()
+	}
+	return cycle
+}
+
+// NextPeriodEnd is when the period following sub's current one ends, for
+// renewals extending it from its end date.
+func (sub *Subscription) NextPeriodEnd() time.Time {
+	return cycleOf(sub).PeriodEnd(sub.EndDate)
+}
+
+// UsageMonth is the monthly usage period containing now: from the last
+// anchor day of sub's billing cycle to the next, at the time of day it
+// started. Usage months follow the anchor whatever the cycle's length, so
+// monthly quotas of a yearly subscription reset on its anchor day every
+// month.
+func (sub *Subscription) UsageMonth(now time.Time) (time.Time, time.Time) {
+	start := sub.StartDate
+	now = now.In(start.Location())
+	month := time.Date(now.Year(), now.Month(), 1, start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+	day := cycleOf(sub).AnchorDay
+	end := anchorIn(month, day)
+	if !end.After(now) {
+		month = month.AddDate(0, 1, 0)
+		end = anchorIn(month, day)
+	}
+	return anchorIn(month.AddDate(0, -1, 0), day), end
+}
+
+// PeriodEnd is when a period starting at start ends. A period starting off
+// the anchor day runs on to the next anchor day, so the first period of an
+// aligned subscription is never shorter than a full one.
+func (c BillingCycle) PeriodEnd(start time.Time) time.Time {
+	end := c.shift(start, 1)
+	if !c.anchored() {
+		return end
+	}
+	anchored := anchorIn(end, c.AnchorDay)
+	if anchored.Before(end) {
+		anchored = anchorIn(addMonths(end, 1), c.AnchorDay)
+	}
+	return anchored
+}
+
+// PeriodStart is when the period ending at end started, for prorating it.
+// It is not clamped to the subscription's start.
+func (c BillingCycle) PeriodStart(end time.Time) time.Time {
+	start := c.shift(end, -1)
+	if !c.anchored() {
+		return start
+	}
+	return anchorIn(start, c.AnchorDay)
+}
+
+func (c BillingCycle) anchored() bool {
+	return c.AnchorDay > 0 && (c.Unit == "monthly" || c.Unit == "yearly")
+}
+
+// shift moves t by n periods. Monthly and yearly shifts clamp to the end of
+// shorter months rather than overflowing into the next one.
+func (c BillingCycle) shift(t time.Time, n int) time.Time {
+	interval := c.Interval
+	if interval < 1 {
+		interval = 1
+	}
+	switch c.Unit {
+	case "daily":
+		return t.AddDate(0, 0, n*interval)
+	case "weekly":
+		return t.AddDate(0, 0, 7*n*interval)
+	case "yearly":
+		return addMonths(t, 12*n*interval)
+	default:
+		return addMonths(t, n*interval)
+	}
+}
+
+// addMonths adds n months to t, clamping the day to the target month's length
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	target := first.AddDate(0, n, 0)
+	return anchorIn(target, t.Day())
+}
+
+// anchorIn is day of t's month at t's time of day, or the month's last day
+// if it has fewer days
+func anchorIn(t time.Time, day int) time.Time {
+	if last := daysIn(t.Year(), t.Month()); day > last {
+		day = last
+	}
+	return time.Date(t.Year(), t.Month(), day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
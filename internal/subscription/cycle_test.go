@@ -0,0 +1,80 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+}
+
+func TestBillingCyclePeriodEnd(t *testing.T) {
+	cases := []struct {
+		name  string
+		cycle BillingCycle
+		start time.Time
+		want  time.Time
+	}{
+		{"monthly", BillingCycle{Unit: "monthly", Interval: 1}, date(2024, time.March, 10), date(2024, time.April, 10)},
+		{"monthly into a shorter month", BillingCycle{Unit: "monthly", Interval: 1}, date(2024, time.January, 31), date(2024, time.February, 29)},
+		{"back to the anchor after a shorter month", BillingCycle{Unit: "monthly", Interval: 1, AnchorDay: 31}, date(2024, time.February, 29), date(2024, time.March, 31)},
+		{"quarterly", BillingCycle{Unit: "monthly", Interval: 3}, date(2024, time.November, 15), date(2025, time.February, 15)},
+		{"yearly", BillingCycle{Unit: "yearly", Interval: 1}, date(2024, time.February, 29), date(2025, time.February, 28)},
+		{"fortnightly", BillingCycle{Unit: "weekly", Interval: 2}, date(2024, time.March, 10), date(2024, time.March, 24)},
+		{"daily", BillingCycle{Unit: "daily", Interval: 1}, date(2024, time.March, 31), date(2024, time.April, 1)},
+		{"aligned first period runs on to the anchor", BillingCycle{Unit: "monthly", Interval: 1, AnchorDay: 1}, date(2024, time.January, 17), date(2024, time.March, 1)},
+		{"aligned renewal", BillingCycle{Unit: "monthly", Interval: 1, AnchorDay: 1}, date(2024, time.March, 1), date(2024, time.April, 1)},
+		{"anchor ignored for weekly", BillingCycle{Unit: "weekly", Interval: 1, AnchorDay: 1}, date(2024, time.March, 10), date(2024, time.March, 17)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.cycle.PeriodEnd(tc.start))
+		})
+	}
+}
+
+func TestBillingCyclePeriodStart(t *testing.T) {
+	monthly := BillingCycle{Unit: "monthly", Interval: 1, AnchorDay: 31}
+	assert.Equal(t, date(2024, time.February, 29), monthly.PeriodStart(date(2024, time.March, 31)))
+
+	quarterly := BillingCycle{Unit: "monthly", Interval: 3, AnchorDay: 1}
+	assert.Equal(t, date(2024, time.January, 1), quarterly.PeriodStart(date(2024, time.April, 1)))
+
+	fortnightly := BillingCycle{Unit: "weekly", Interval: 2}
+	assert.Equal(t, date(2024, time.March, 10), fortnightly.PeriodStart(date(2024, time.March, 24)))
+}
+
+func TestNextPeriodEnd(t *testing.T) {
+	anchor := 1
+	sub := &Subscription{
+		StartDate:    date(2024, time.January, 17),
+		EndDate:      date(2024, time.March, 1),
+		PlanSnapshot: &PlanSnapshot{BillingCycle: "monthly", BillingInterval: 3, BillingAnchorDay: &anchor},
+	}
+	assert.Equal(t, date(2024, time.June, 1), sub.NextPeriodEnd())
+
+	// Subscriptions without a snapshot renew monthly on their anniversary
+	legacy := &Subscription{StartDate: date(2024, time.January, 31), EndDate: date(2024, time.February, 29)}
+	assert.Equal(t, date(2024, time.March, 31), legacy.NextPeriodEnd())
+}
+
+func TestUsageMonth(t *testing.T) {
+	sub := &Subscription{StartDate: date(2024, time.January, 31), EndDate: date(2025, time.January, 31)}
+
+	// Monthly usage resets on the anchor day, clamped in shorter months
+	start, end := sub.UsageMonth(date(2024, time.February, 10))
+	assert.Equal(t, date(2024, time.January, 31), start)
+	assert.Equal(t, date(2024, time.February, 29), end)
+	start, end = sub.UsageMonth(date(2024, time.February, 29))
+	assert.Equal(t, date(2024, time.February, 29), start)
+	assert.Equal(t, date(2024, time.March, 31), end)
+
+	anchor := 1
+	sub.PlanSnapshot = &PlanSnapshot{BillingCycle: "yearly", BillingAnchorDay: &anchor}
+	start, end = sub.UsageMonth(date(2024, time.May, 20))
+	assert.Equal(t, date(2024, time.May, 1), start)
+	assert.Equal(t, date(2024, time.June, 1), end)
+}
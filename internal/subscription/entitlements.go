@@ -0,0 +1,62 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"scalable-paywall/internal/cache"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FeatureRequestsPerMinute is the plan feature holding a subscriber's API
+// rate limit
+const FeatureRequestsPerMinute = "requests_per_minute"
+
+// entitlementCacheTTL bounds how stale a cached entitlement can be when a
+// change bypasses cacheSubscription (renewals, sweeps, webhooks)
+const entitlementCacheTTL = time.Minute
+
+// GetCachedEntitlement is GetEntitlementByUserID through a short-lived
+// cache, for checks made on every request. It returns nil without error when
+// the user has no entitlement.
+func (s *Service) GetCachedEntitlement(ctx context.Context, userID string) (*Entitlement, error) {
+	entitlement, _, err := s.entitlements.Get(ctx, userID, func(ctx context.Context) (*Entitlement, error) {
+		return s.GetEntitlementByUserID(ctx, userID)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return entitlement, err
+}
+
+// RequestsPerMinute returns the API rate limit of the user's plan, or 0 when
+// the user has no entitlement or the plan sets no limit.
+func (s *Service) RequestsPerMinute(ctx context.Context, userID string) (int, error) {
+	entitlement, err := s.GetCachedEntitlement(ctx, userID)
+	if err != nil || entitlement == nil {
+		return 0, err
+	}
+	// Features decoded from JSON hold numbers as float64
+	limit, _ := entitlement.Features[FeatureRequestsPerMinute].(float64)
+	return int(limit), nil
+}
+
+func (s *Service) invalidateEntitlement(ctx context.Context, userID string) {
+	if err := s.entitlements.Forget(ctx, userID); err != nil {
+		logrus.Warnf("Failed to invalidate entitlement for user %s: %v", userID, err)
+	}
+}
+
+// newEntitlementCache caches entitlements by user ID, remembering users
+// without one for as long
+func newEntitlementCache(redis *cache.RedisClient) *cache.ReadThrough[*Entitlement] {
+	return cache.NewReadThrough(redis, cache.ReadThroughOptions[*Entitlement]{
+		Domain:      "entitlement",
+		Prefix:      "entitlement",
+		TTL:         entitlementCacheTTL,
+		NegativeTTL: entitlementCacheTTL,
+	})
+}
@@ -0,0 +1,134 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"scalable-paywall/internal/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+// freePeriodEnd is the end date of free plan subscriptions, which never
+// lapse and are never renewed.
+var freePeriodEnd = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// IsFreePlan reports whether planID is a free plan. It returns
+// sql.ErrNoRows if the plan does not exist.
+func (s *Service) IsFreePlan(ctx context.Context, planID string) (bool, error) {
+	var planType string
+	err := s.db.QueryRowContext(ctx, `SELECT plan_type FROM plans WHERE id = $1`, planID).Scan(&planType)
+	return planType == "free", err
+}
+
+// EnrollFree subscribes the user to the active free plan. It returns nil if
+// there is no active free plan or the user already has an active or
+// past_due subscription.
+func (s *Service) EnrollFree(ctx context.Context, userID string) (*Subscription, error) {
+	sub := &Subscription{
+		ID:      generateID(),
+		UserID:  userID,
+		Status:  "active",
+		EndDate: freePeriodEnd,
+	}
+	var snapshot []byte
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO subscriptions (id, user_id, plan_id, status, start_date, end_date,
+			auto_renew, payment_method, amount, currency, created_at, updated_at, plan_snapshot)
+		SELECT $1, $2, p.id, 'active', NOW(), $3, false, '', 0, p.currency, NOW(), NOW(),
+			`+planSnapshotSQL+`
+		FROM plans p
+		WHERE p.plan_type = 'free' AND p.is_active
+			AND NOT EXISTS (
+				SELECT 1 FROM subscriptions
+				WHERE user_id = $2 AND status IN ('active', 'past_due')
+			)
+		RETURNING plan_id, start_date, amount, currency, version, created_at, updated_at, plan_snapshot
+	`, sub.ID, userID, freePeriodEnd).Scan(&sub.PlanID, &sub.StartDate, &sub.Amount,
+		&sub.Currency, &sub.Version, &sub.CreatedAt, &sub.UpdatedAt, &snapshot)
+	// A concurrent enrollment or subscription won the race
+	if err == sql.ErrNoRows || db.IsUniqueViolation(err, oneActiveIndex) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sub.PlanSnapshot, err = decodePlanSnapshot(snapshot); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// downgradeToFree enrolls the owner of a cancelled or expired paid
+// subscription on the free plan when subscription.downgrade_to_free is set.
+// Failures are logged; the user is simply left without a subscription.
+func (s *Service) downgradeToFree(ctx context.Context, sub *Subscription) {
+	if s.cfg == nil || !s.cfg.DowngradeToFree {
+		return
+	}
+	isFree, err := s.IsFreePlan(ctx, sub.PlanID)
+	if err != nil {
+		logrus.Errorf("Failed to get plan type for subscription %s: %v", sub.ID, err)
+		return
+	}
+	if isFree {
+		return
+	}
+	s.enrollFree(ctx, sub.UserID)
+}
+
+func (s *Service) enrollFree(ctx context.Context, userID string) {
+	free, err := s.EnrollFree(ctx, userID)
+	if err != nil {
+		logrus.Errorf("Failed to downgrade user %s to the free plan: %v", userID, err)
+		return
+	}
+	if free != nil {
+		logrus.Infof("Downgraded user %s to free plan subscription %s", userID, free.ID)
+	}
+}
+
+// replaceFree cancels the user's free plan subscriptions and runs write, which
+// activates a paid subscription, in one transaction: the free subscription
+// must be gone before the paid one can be active, but stays if write fails.
+func (s *Service) replaceFree(ctx context.Context, userID string, write func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE subscriptions SET status = 'cancelled', updated_at = NOW(), version = version + 1
+		WHERE user_id = $1 AND status = 'active'
+			AND plan_id IN (SELECT id FROM plans WHERE plan_type = 'free')
+		RETURNING id
+	`, userID)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := write(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.subscriptions.Forget(ctx, ids...)
+	return nil
+}
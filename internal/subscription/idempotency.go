@@ -0,0 +1,71 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"scalable-paywall/internal/middleware"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// externalRefIndex makes an external_ref unique per user
+const externalRefIndex = "idx_subscriptions_external_ref"
+
+// ReplayedHeader is set on responses that return the subscription created by
+// an earlier request with the same external_ref.
+const ReplayedHeader = "Idempotent-Replayed"
+
+var errDuplicateExternalRef = errors.New("external_ref is already in use")
+
+// respondExistingRef answers a create request whose external_ref the user
+// already used: 200 with that subscription if the request is for the same
+// plan, 409 otherwise. It reports false, having written nothing, when the
+// ref is new.
+func (s *Service) respondExistingRef(c *gin.Context, req CreateSubscriptionRequest) bool {
+	existing, err := s.getSubscriptionByExternalRef(c.Request.Context(), req.UserID, req.ExternalRef)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		logrus.Errorf("Failed to look up subscription by external ref: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation("create", "db_error")
+		return true
+	}
+
+	if existing.PlanID != req.PlanID {
+		c.JSON(http.StatusConflict, gin.H{"error": "external_ref was already used for a different plan"})
+		telemetry.RecordSubscriptionOperation("create", "conflict")
+		return true
+	}
+
+	c.Header(ReplayedHeader, "true")
+	middleware.SetETag(c, existing.Version)
+	c.JSON(http.StatusOK, existing)
+	telemetry.RecordSubscriptionOperation("create", "replayed")
+	return true
+}
+
+// getSubscriptionByExternalRef reads from the primary: a retry may follow
+// the original request too closely for a replica to have it.
+func (s *Service) getSubscriptionByExternalRef(ctx context.Context, userID, externalRef string) (*Subscription, error) {
+	query := `
+		SELECT id, user_id, plan_id, status, start_date, end_date, auto_renew,
+			payment_method, amount, currency, external_ref, version, created_at, updated_at
+		FROM subscriptions WHERE user_id = $1 AND external_ref = $2
+	`
+	var sub Subscription
+	err := s.db.QueryRowContext(ctx, query, userID, externalRef).Scan(
+		&sub.ID, &sub.UserID, &sub.PlanID, &sub.Status, &sub.StartDate, &sub.EndDate,
+		&sub.AutoRenew, &sub.PaymentMethod, &sub.Amount, &sub.Currency, &sub.ExternalRef,
+		&sub.Version, &sub.CreatedAt, &sub.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
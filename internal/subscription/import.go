@@ -0,0 +1,164 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"scalable-paywall/internal/metadata"
+	"scalable-paywall/internal/money"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/shopspring/decimal"
+)
+
+// ErrInvalidImport is returned for an imported subscriber that can't be
+// migrated as given.
+var ErrInvalidImport = errors.New("invalid subscriber")
+
+// ImportSubscriptionRequest is one subscriber migrated from a legacy system.
+// ExternalRef is the subscription's ID there, so a subscriber can't be
+// imported twice. Amount keeps a legacy price and defaults to the plan's.
+// BillingAnchorDay keeps the day of the month a monthly or yearly legacy
+// subscription renews on, which otherwise follows the plan's anchor day or
+// the start date.
+type ImportSubscriptionRequest struct {
+	UserID           string                 `json:"user_id" binding:"required"`
+	PlanID           string                 `json:"plan_id" binding:"required"`
+	Status           string                 `json:"status" binding:"omitempty,oneof=active past_due cancelled expired suspended"`
+	StartDate        *time.Time             `json:"start_date"`
+	EndDate          *time.Time             `json:"end_date"`
+	AutoRenew        bool                   `json:"auto_renew"`
+	PaymentMethod    string                 `json:"payment_method" binding:"omitempty,payment_token"`
+	Amount           *decimal.Decimal       `json:"amount" binding:"omitempty,min=0"`
+	Currency         string                 `json:"currency" binding:"omitempty,len=3"`
+	ExternalRef      string                 `json:"external_ref" binding:"required,max=255"`
+	Metadata         map[string]interface{} `json:"metadata"`
+	BillingAnchorDay *int                   `json:"billing_anchor_day" binding:"omitempty,min=1,max=31"`
+}
+
+// ImportSubscription creates a migrated subscriber's subscription. Unlike
+// CreateSubscription it keeps the legacy status, dates and price, and
+// records no experiment conversion. A row that can't be migrated fails with
+// ErrInvalidImport or ErrAlreadySubscribed; any other error is the
+// database's.
+func (s *Service) ImportSubscription(ctx context.Context, req ImportSubscriptionRequest) (*Subscription, error) {
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	subscriptionMetadata, err := metadata.Merge(nil, req.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	plan, err := s.getChargedPlan(ctx, req.PlanID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: plan %s not found", ErrInvalidImport, req.PlanID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var userExists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, req.UserID).Scan(&userExists); err != nil {
+		return nil, err
+	}
+	if !userExists {
+		return nil, fmt.Errorf("%w: user %s not found", ErrInvalidImport, req.UserID)
+	}
+
+	now := time.Now()
+	sub := &Subscription{
+		ID:            generateID(),
+		UserID:        req.UserID,
+		PlanID:        req.PlanID,
+		Status:        req.Status,
+		StartDate:     now,
+		AutoRenew:     req.AutoRenew,
+		PaymentMethod: req.PaymentMethod,
+		Amount:        plan.Price,
+		Currency:      plan.Currency,
+		ExternalRef:   &req.ExternalRef,
+		Metadata:      subscriptionMetadata,
+		Version:       1,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if sub.Status == "" {
+		sub.Status = "active"
+	}
+	if req.StartDate != nil {
+		sub.StartDate = *req.StartDate
+	}
+	if req.Amount != nil {
+		sub.Amount = *req.Amount
+	}
+	if req.Currency != "" {
+		sub.Currency = req.Currency
+	}
+	if !money.IsValid(sub.Amount, sub.Currency) {
+		return nil, fmt.Errorf("%w: amount has more decimal places than %s allows", ErrInvalidImport, sub.Currency)
+	}
+
+	if plan.Free {
+		if !sub.Amount.IsZero() {
+			return nil, fmt.Errorf("%w: free plan subscriptions must have an amount of 0", ErrInvalidImport)
+		}
+		sub.EndDate = freePeriodEnd
+		sub.AutoRenew = false
+	} else {
+		if req.EndDate == nil {
+			return nil, fmt.Errorf("%w: end_date is required for paid plans", ErrInvalidImport)
+		}
+		sub.EndDate = *req.EndDate
+	}
+	if !sub.EndDate.After(sub.StartDate) {
+		return nil, fmt.Errorf("%w: end_date must be after start_date", ErrInvalidImport)
+	}
+	if req.BillingAnchorDay != nil && (plan.Free || (plan.Cycle.Unit != "monthly" && plan.Cycle.Unit != "yearly")) {
+		return nil, fmt.Errorf("%w: billing_anchor_day needs a paid monthly or yearly plan", ErrInvalidImport)
+	}
+
+	insert := func(tx *sql.Tx) error {
+		if err := insertSubscription(ctx, tx, sub); err != nil {
+			return err
+		}
+		return anchorSubscription(ctx, tx, sub, req.BillingAnchorDay)
+	}
+	if sub.Status == "active" && !plan.Free {
+		// As on upgrade, the paid subscription replaces any free one
+		err = s.replaceFree(ctx, sub.UserID, insert)
+	} else {
+		err = s.inTx(ctx, insert)
+	}
+	if errors.Is(err, errDuplicateExternalRef) {
+		return nil, fmt.Errorf("%w: external_ref %s was already imported", ErrInvalidImport, req.ExternalRef)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.cacheSubscription(ctx, sub)
+	return sub, nil
+}
+
+// anchorSubscription makes sub renew on day of the month, whatever its
+// plan's anchor day, by setting it in the plan snapshot its billing cycle
+// is read from. A nil day leaves sub as it is.
+func anchorSubscription(ctx context.Context, exec execer, sub *Subscription, day *int) error {
+	if day == nil {
+		return nil
+	}
+	if _, err := exec.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET plan_snapshot = jsonb_set(plan_snapshot, '{billing_anchor_day}', to_jsonb($2::int))
+		WHERE id = $1
+	`, sub.ID, *day); err != nil {
+		return err
+	}
+	if sub.PlanSnapshot != nil {
+		sub.PlanSnapshot.BillingAnchorDay = day
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+//go:build integration
+
+package subscription_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/notification"
+	"scalable-paywall/internal/plan"
+	"scalable-paywall/internal/subscription"
+	testenv "scalable-paywall/internal/testing"
+	"scalable-paywall/internal/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var env *testenv.Env
+
+func TestMain(m *testing.M) { os.Exit(testenv.Main(m, &env)) }
+
+func createPlan(t *testing.T, req plan.CreatePlanRequest) *plan.Plan {
+	t.Helper()
+	p, err := env.Plans().ImportPlan(context.Background(), req)
+	require.NoError(t, err)
+	return p
+}
+
+func createUser(t *testing.T, username string) *user.User {
+	t.Helper()
+	u, err := env.Users().ImportUser(context.Background(), user.CreateUserRequest{
+		Email:    username + "@example.com",
+		Username: username,
+	})
+	require.NoError(t, err)
+	return u
+}
+
+// subscribe buys a paid plan for userID as checkout does: the subscription
+// is created pending and activated once its payment succeeded
+func subscribe(t *testing.T, subs *subscription.Service, userID string, p *plan.Plan) (*subscription.Subscription, error) {
+	t.Helper()
+	ctx := context.Background()
+	pending, err := subs.CreatePending(ctx, userID, p.ID, "pm_card_visa", p.Price, p.Currency, true)
+	require.NoError(t, err)
+	if _, err := subs.ActivatePending(ctx, pending.ID); err != nil {
+		return nil, err
+	}
+	sub, err := subs.GetSubscriptionByID(ctx, pending.ID)
+	require.NoError(t, err)
+	return sub, nil
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	pro := createPlan(t, plan.CreatePlanRequest{Name: "Pro", Price: decimal.RequireFromString("19.99"), Currency: "USD", BillingCycle: "monthly"})
+	jane := createUser(t, "jane")
+
+	// Paid plans can't be subscribed to without paying
+	body := fmt.Sprintf(`{"user_id": %q, "plan_id": %q, "payment_method": "pm_card_visa"}`, jane.ID, pro.ID)
+	w := testenv.Serve(subs.CreateSubscription, http.MethodPost, "/api/v1/subscriptions/", body)
+	assert.Equal(t, http.StatusPaymentRequired, w.Code, w.Body.String())
+	_, err := subs.GetActiveSubscriptionByUserID(ctx, jane.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	created, err := subscribe(t, subs, jane.ID, pro)
+	require.NoError(t, err)
+	assert.Equal(t, "active", created.Status)
+	assert.True(t, created.Amount.Equal(pro.Price))
+	assert.True(t, created.EndDate.After(created.StartDate))
+
+	// One active subscription per user
+	_, err = subscribe(t, subs, jane.ID, pro)
+	assert.ErrorIs(t, err, subscription.ErrAlreadySubscribed)
+
+	entitlement, err := subs.GetEntitlementByUserID(ctx, jane.ID)
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, entitlement.Subscription.ID)
+	assert.Equal(t, "paid", entitlement.PlanType)
+
+	w = testenv.Serve(subs.CancelSubscription, http.MethodDelete, "/api/v1/subscriptions/"+created.ID, "",
+		gin.Param{Key: "id", Value: created.ID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	cancelled, err := subs.GetSubscriptionByID(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", cancelled.Status)
+
+	_, err = subs.GetEntitlementByUserID(ctx, jane.ID)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// The history trigger recorded both changes
+	rows, err := env.DB.QueryContext(ctx, `SELECT event FROM subscription_events WHERE subscription_id = $1 ORDER BY created_at`, created.ID)
+	require.NoError(t, err)
+	defer rows.Close()
+	var events []string
+	for rows.Next() {
+		var event string
+		require.NoError(t, rows.Scan(&event))
+		events = append(events, event)
+	}
+	assert.Equal(t, []string{"created", "status_changed"}, events)
+}
+
+// sentNotifications records the notifications sent, failing while failing
+// is set
+type sentNotifications struct {
+	mu      sync.Mutex
+	sent    []notification.Notification
+	failing bool
+}
+
+func (n *sentNotifications) Notify(ctx context.Context, sent notification.Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.failing {
+		return errors.New("webhook unreachable")
+	}
+	n.sent = append(n.sent, sent)
+	return nil
+}
+
+// take returns the notifications sent since it was last called, by
+// subscription
+func (n *sentNotifications) take() map[string]notification.Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	bySubscription := make(map[string]notification.Notification)
+	for _, sent := range n.sent {
+		bySubscription[sent.SubscriptionID] = sent
+	}
+	n.sent = nil
+	return bySubscription
+}
+
+func TestRenewalReminders(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	notifier := &sentNotifications{}
+	subs := subscription.NewService(&env.Config.Subscription, env.DB, env.Cache, notifier, nil)
+	basic := createPlan(t, plan.CreatePlanRequest{Name: "Basic", Price: decimal.RequireFromString("9.99"), Currency: "USD", BillingCycle: "monthly"})
+
+	start := time.Now().AddDate(0, -1, 0)
+	importSubscription := func(username, status string, endsIn time.Duration, autoRenew bool) string {
+		end := time.Now().Add(endsIn)
+		sub, err := subs.ImportSubscription(ctx, subscription.ImportSubscriptionRequest{
+			UserID:      createUser(t, username).ID,
+			PlanID:      basic.ID,
+			Status:      status,
+			StartDate:   &start,
+			EndDate:     &end,
+			AutoRenew:   autoRenew,
+			ExternalRef: "sub_" + username,
+		})
+		require.NoError(t, err)
+		return sub.ID
+	}
+	day := 24 * time.Hour
+	renewing := importSubscription("renewing", "active", 2*day, true)
+	expiring := importSubscription("expiring", "active", 5*day, false)
+	importSubscription("later", "active", 30*day, true)
+	importSubscription("cancelled", "cancelled", 2*day, false)
+
+	// Each due subscription gets the reminder for the shortest lead time it
+	// is within: renewing ones a renewal reminder, the others a warning
+	require.NoError(t, subs.SendRenewalReminders(ctx, []int{7, 3}))
+	sent := notifier.take()
+	require.Len(t, sent, 2)
+	assert.Equal(t, notification.TypeRenewalReminder, sent[renewing].Type)
+	assert.Equal(t, 2, sent[renewing].Data["days_left"])
+	assert.Equal(t, notification.TypeExpiring, sent[expiring].Type)
+	assert.Equal(t, 5, sent[expiring].Data["days_left"])
+
+	// Reminders already sent for the period aren't sent again
+	require.NoError(t, subs.SendRenewalReminders(ctx, []int{7, 3}))
+	assert.Empty(t, notifier.take())
+
+	// A subscription reminded 7 days ahead still gets its 3-day reminder
+	_, err := env.DB.Exec(`UPDATE subscriptions SET end_date = NOW() + INTERVAL '2 days 1 hour' WHERE id = $1`, expiring)
+	require.NoError(t, err)
+	_, err = env.DB.Exec(`UPDATE renewal_reminders SET period_end = s.end_date FROM subscriptions s
+		WHERE s.id = renewal_reminders.subscription_id AND s.id = $1`, expiring)
+	require.NoError(t, err)
+	require.NoError(t, subs.SendRenewalReminders(ctx, []int{7, 3}))
+	sent = notifier.take()
+	require.Len(t, sent, 1)
+	assert.Equal(t, notification.TypeExpiring, sent[expiring].Type)
+
+	// A reminder that couldn't be delivered is sent by the next sweep
+	failed := importSubscription("failed", "active", day, true)
+	notifier.failing = true
+	require.NoError(t, subs.SendRenewalReminders(ctx, []int{7, 3}))
+	notifier.failing = false
+	require.NoError(t, subs.SendRenewalReminders(ctx, []int{7, 3}))
+	sent = notifier.take()
+	require.Len(t, sent, 1)
+	assert.Equal(t, notification.TypeRenewalReminder, sent[failed].Type)
+}
+
+func TestUpgradeReplacesFreeSubscription(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	createPlan(t, plan.CreatePlanRequest{Name: "Free", Price: decimal.Zero, Currency: "USD", BillingCycle: "monthly", Type: plan.PlanTypeFree})
+	basic := createPlan(t, plan.CreatePlanRequest{Name: "Basic", Price: decimal.RequireFromString("9.99"), Currency: "USD", BillingCycle: "monthly"})
+	jane := createUser(t, "jane")
+
+	free, err := subs.EnrollFree(ctx, jane.ID)
+	require.NoError(t, err)
+	require.NotNil(t, free)
+
+	paid, err := subscribe(t, subs, jane.ID, basic)
+	require.NoError(t, err)
+
+	active, err := subs.GetActiveSubscriptionByUserID(ctx, jane.ID)
+	require.NoError(t, err)
+	assert.Equal(t, paid.ID, active.ID)
+
+	replaced, err := subs.GetSubscriptionByID(ctx, free.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", replaced.Status)
+}
+
+func TestEntitlementsInheritParentPlanFeatures(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	basic := createPlan(t, plan.CreatePlanRequest{Name: "Basic", Price: decimal.RequireFromString("9.99"), Currency: "USD", BillingCycle: "monthly",
+		Features: map[string]interface{}{"hd_video": true, "downloads": 10}})
+	pro := createPlan(t, plan.CreatePlanRequest{Name: "Pro", Price: decimal.RequireFromString("19.99"), Currency: "USD", BillingCycle: "monthly",
+		ParentPlanID: &basic.ID, Features: map[string]interface{}{"downloads": 100, "offline": true}})
+	assert.Equal(t, map[string]interface{}{"hd_video": true, "downloads": float64(100), "offline": true}, pro.EffectiveFeatures)
+
+	jane := createUser(t, "jane")
+	_, err := subscribe(t, subs, jane.ID, pro)
+	require.NoError(t, err)
+	entitlement, err := subs.GetEntitlementByUserID(ctx, jane.ID)
+	require.NoError(t, err)
+	assert.Equal(t, true, entitlement.Features["hd_video"])
+	assert.Equal(t, float64(100), entitlement.Features["downloads"])
+
+	// Basic can't inherit from a plan inheriting from it, nor be deleted
+	w := testenv.Serve(env.Plans().UpdatePlan, http.MethodPut, "/api/v1/plans/"+basic.ID,
+		fmt.Sprintf(`{"parent_plan_id": %q}`, pro.ID), gin.Param{Key: "id", Value: basic.ID})
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	w = testenv.Serve(env.Plans().DeletePlan, http.MethodDelete, "/api/v1/plans/"+basic.ID, "",
+		gin.Param{Key: "id", Value: basic.ID})
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+}
+
+func TestImportSubscriptionRejectsDuplicateExternalRef(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	basic := createPlan(t, plan.CreatePlanRequest{Name: "Basic", Price: decimal.RequireFromString("9.99"), Currency: "USD", BillingCycle: "monthly"})
+	jane := createUser(t, "jane")
+
+	start := time.Now().AddDate(0, -2, 0)
+	end := start.AddDate(0, 1, 0)
+	req := subscription.ImportSubscriptionRequest{
+		UserID:      jane.ID,
+		PlanID:      basic.ID,
+		Status:      "expired",
+		StartDate:   &start,
+		EndDate:     &end,
+		ExternalRef: "legacy-42",
+	}
+	imported, err := subs.ImportSubscription(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "expired", imported.Status)
+
+	_, err = subs.ImportSubscription(ctx, req)
+	assert.ErrorIs(t, err, subscription.ErrInvalidImport)
+}
+
+func TestImportSubscriptionKeepsBillingAnchor(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	basic := createPlan(t, plan.CreatePlanRequest{Name: "Basic", Price: decimal.RequireFromString("9.99"), Currency: "USD", BillingCycle: "monthly"})
+	jane := createUser(t, "jane")
+
+	start := time.Date(2026, time.March, 10, 9, 0, 0, 0, time.UTC)
+	end := time.Date(2026, time.April, 1, 9, 0, 0, 0, time.UTC)
+	anchorDay := 1
+	imported, err := subs.ImportSubscription(ctx, subscription.ImportSubscriptionRequest{
+		UserID:           jane.ID,
+		PlanID:           basic.ID,
+		StartDate:        &start,
+		EndDate:          &end,
+		AutoRenew:        true,
+		ExternalRef:      "sub_1NqX",
+		BillingAnchorDay: &anchorDay,
+	})
+	require.NoError(t, err)
+
+	stored, err := subs.GetSubscriptionByID(ctx, imported.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.PlanSnapshot.BillingAnchorDay)
+	assert.Equal(t, 1, *stored.PlanSnapshot.BillingAnchorDay)
+	assert.Equal(t, time.Date(2026, time.May, 1, 9, 0, 0, 0, time.UTC), stored.NextPeriodEnd().UTC())
+}
+
+func TestClaimUserRenewalsLeasesOnce(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	pro := createPlan(t, plan.CreatePlanRequest{Name: "Pro", Price: decimal.RequireFromString("19.99"), Currency: "USD", BillingCycle: "monthly"})
+	jane := createUser(t, "jane")
+	john := createUser(t, "john")
+
+	created, err := subscribe(t, subs, jane.ID, pro)
+	require.NoError(t, err)
+
+	// Claimed although the subscription is nowhere near its renewal date
+	claims, err := subs.ClaimUserRenewals(ctx, jane.ID, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claims, 1)
+	assert.Equal(t, created.ID, claims[0].Subscription.ID)
+	assert.True(t, claims[0].Amount().Equal(pro.Price))
+
+	claims, err = subs.ClaimUserRenewals(ctx, jane.ID, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, claims, "a leased subscription can't be claimed again")
+
+	require.NoError(t, subs.ReleaseClaim(ctx, created.ID))
+	claims, err = subs.ClaimUserRenewals(ctx, jane.ID, time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, claims, 1)
+
+	claims, err = subs.ClaimUserRenewals(ctx, john.ID, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, claims)
+}
+
+func TestCreateFromCheckoutIsAtomicWithRecord(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	pro := createPlan(t, plan.CreatePlanRequest{Name: "Pro", Price: decimal.RequireFromString("19.99"), Currency: "USD", BillingCycle: "monthly"})
+	jane := createUser(t, "jane")
+	order := subscription.CheckoutOrder{
+		UserID:           jane.ID,
+		PlanID:           pro.ID,
+		PaymentMethod:    "pm_card_visa",
+		Amount:           pro.Price,
+		Currency:         "USD",
+		AutoRenew:        true,
+		DiscountPercent:  20,
+		DiscountRenewals: 2,
+	}
+
+	failed := fmt.Errorf("session already completed")
+	_, err := subs.CreateFromCheckout(ctx, order, func(tx *sql.Tx, sub *subscription.Subscription) error { return failed })
+	assert.ErrorIs(t, err, failed)
+	_, err = subs.GetActiveSubscriptionByUserID(ctx, jane.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows, "the subscription is rolled back with the record")
+
+	created, err := subs.CreateFromCheckout(ctx, order, func(tx *sql.Tx, sub *subscription.Subscription) error { return nil })
+	require.NoError(t, err)
+	stored, err := subs.GetSubscriptionByID(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "active", stored.Status)
+	assert.Equal(t, 20, stored.DiscountPercent)
+	assert.Equal(t, 2, stored.DiscountRenewals)
+}
+
+func TestWarmupPreloadsRecentlyUsedSubscriptions(t *testing.T) {
+	env.Reset(t)
+	ctx := context.Background()
+	subs := env.Subscriptions()
+	subs.RegisterWarmers(env.Config.Cache.Warmup)
+	pro := createPlan(t, plan.CreatePlanRequest{Name: "Pro", Price: decimal.RequireFromString("19.99"), Currency: "USD", BillingCycle: "monthly"})
+
+	var ids []string
+	for _, name := range []string{"jane", "john"} {
+		u := createUser(t, name)
+		created, err := subscribe(t, subs, u.ID, pro)
+		require.NoError(t, err)
+		ids = append(ids, created.ID)
+	}
+	// Only jane has used hers lately
+	_, err := env.DB.ExecContext(ctx, `
+		INSERT INTO usage_logs (user_id, subscription_id, action, created_at)
+		SELECT user_id, id, 'view', NOW() - INTERVAL '1 hour' FROM subscriptions WHERE id = $1
+	`, ids[0])
+	require.NoError(t, err)
+
+	env.FlushRedis(t)
+	warmed, err := env.Cache.Warm(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, warmed["subscriptions.recent"])
+
+	cached, err := env.Cache.Exists(ctx, env.Cache.Key("subscription:"+ids[0]), env.Cache.Key("subscription:"+ids[1]))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), cached)
+}
@@ -0,0 +1,29 @@
+package subscription
+
+import (
+	"context"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/jobs"
+)
+
+// Job kinds run by the subscription service.
+const (
+	JobRenewalReminders = "subscription.renewal_reminders"
+	JobLifecycleSweep   = "subscription.lifecycle_sweep"
+)
+
+// RegisterJobs schedules renewal reminders and the lifecycle sweep on runner.
+// Business metrics stay on RunMetricsSweeper: every instance exports its own
+// gauges, so each must refresh them rather than one per cluster.
+func (s *Service) RegisterJobs(runner *jobs.Runner, cfg config.SubscriptionConfig) {
+	if len(cfg.ReminderDays) > 0 {
+		runner.Every(JobRenewalReminders, time.Duration(cfg.ReminderInterval)*time.Second, func(ctx context.Context, job *jobs.Job) error {
+			return s.SendRenewalReminders(ctx, cfg.ReminderDays)
+		})
+	}
+	runner.Every(JobLifecycleSweep, time.Duration(cfg.LifecycleInterval)*time.Second, func(ctx context.Context, job *jobs.Job) error {
+		return s.SweepLapsedSubscriptions(ctx)
+	})
+}
@@ -0,0 +1,187 @@
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"scalable-paywall/internal/db"
+)
+
+// Entitlement is a subscription that still grants access, either within its
+// paid period or within its plan's grace period after it. Free plan
+// entitlements are limited to the plan's daily usage cap. Features are the
+// plan's features. UserStatus is the account's status, which may withhold
+// access the subscription would grant. UserTimezone is the account's IANA
+// timezone, empty if it has none, and UserTenant the tenant it was created
+// under, empty for none. RolloverCap and OveragePercent are the
+// plan's usage rollover and overage grace.
+type Entitlement struct {
+	Subscription     *Subscription
+	GraceUntil       time.Time
+	PlanType         string
+	MaxUsagePerDay   *int
+	MaxUsagePerMonth *int
+	RolloverCap      *int
+	OveragePercent   int
+	Features         map[string]interface{}
+	UserStatus       string
+	UserTimezone     string
+	UserTenant       string
+}
+
+// InGracePeriod reports whether access currently comes from the grace window
+// rather than a paid, active period.
+func (e *Entitlement) InGracePeriod(now time.Time) bool {
+	return e.Subscription.Status == "past_due" || !now.Before(e.Subscription.EndDate)
+}
+
+// GetEntitlementByUserID returns the user's most recent active or past_due
+// subscription whose end date plus its plan's grace period is still ahead,
+// unless it is paused.
+// Plan type, usage limit and features come from the plan snapshot taken at
+// purchase; the grace period follows the live plan, as the lifecycle sweep
+// does.
+func (s *Service) GetEntitlementByUserID(ctx context.Context, userID string) (*Entitlement, error) {
+	query := `
+		SELECT s.id, s.user_id, s.plan_id, s.status, s.start_date, s.end_date, s.auto_renew,
+			s.payment_method, s.amount, s.currency, s.version, s.created_at, s.updated_at,
+			s.end_date + make_interval(days => p.grace_period_days), s.plan_snapshot->>'type',
+			(s.plan_snapshot->>'max_usage_per_day')::int, s.plan_snapshot->'features',
+			COALESCE(u.status, 'active'), COALESCE(u.timezone, ''), COALESCE(u.tenant_id, ''), s.plan_snapshot
+		FROM subscriptions s
+		JOIN plans p ON p.id = s.plan_id
+		JOIN users u ON u.id = s.user_id
+		WHERE s.user_id = $1
+			AND s.status IN ('active', 'past_due')
+			AND s.end_date + make_interval(days => p.grace_period_days) > NOW()
+			AND NOT (s.paused_from IS NOT NULL AND s.paused_from <= NOW() AND s.end_date > NOW())
+		ORDER BY s.created_at DESC LIMIT 1
+	`
+	var sub Subscription
+	var features, snapshot []byte
+	entitlement := Entitlement{Subscription: &sub}
+	err := s.db.QueryRowNamed(ctx, "entitlement_by_user", query, userID).Scan(
+		&sub.ID, &sub.UserID, &sub.PlanID, &sub.Status, &sub.StartDate, &sub.EndDate,
+		&sub.AutoRenew, &sub.PaymentMethod, &sub.Amount, &sub.Currency,
+		&sub.Version, &sub.CreatedAt, &sub.UpdatedAt, &entitlement.GraceUntil,
+		&entitlement.PlanType, &entitlement.MaxUsagePerDay, &features, &entitlement.UserStatus,
+		&entitlement.UserTimezone, &entitlement.UserTenant, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if sub.PlanSnapshot, err = decodePlanSnapshot(snapshot); err != nil {
+		return nil, err
+	}
+	if sub.PlanSnapshot != nil {
+		entitlement.MaxUsagePerMonth = sub.PlanSnapshot.MaxUsagePerMonth
+		entitlement.RolloverCap = sub.PlanSnapshot.RolloverCap
+		entitlement.OveragePercent = sub.PlanSnapshot.OveragePercent
+	}
+	if len(features) > 0 {
+		if err := json.Unmarshal(features, &entitlement.Features); err != nil {
+			return nil, err
+		}
+	}
+	return &entitlement, nil
+}
+
+// MarkPastDue moves an active subscription into past_due, e.g. after a
+// failed renewal charge. It is a no-op for subscriptions in other states.
+func (s *Service) MarkPastDue(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE subscriptions SET status = 'past_due', updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND status = 'active'
+	`, id)
+	if err == nil {
+		s.subscriptions.Forget(ctx, id)
+	}
+	return err
+}
+
+// SweepLapsedSubscriptions moves lapsed subscriptions into past_due and
+// expires past_due subscriptions whose grace period is over, downgrading
+// their users to the free plan if configured.
+func (s *Service) SweepLapsedSubscriptions(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE subscriptions s
+		SET status = CASE
+				WHEN s.end_date + make_interval(days => p.grace_period_days) > NOW() THEN 'past_due'
+				ELSE 'expired'
+			END,
+			updated_at = NOW(),
+			version = s.version + 1
+		FROM plans p
+		WHERE p.id = s.plan_id
+			AND s.end_date <= NOW()
+			AND (s.claimed_until IS NULL OR s.claimed_until < NOW())
+			AND (s.status = 'active'
+				OR (s.status = 'past_due' AND s.end_date + make_interval(days => p.grace_period_days) <= NOW()))
+			AND s.user_id NOT IN (`+billingPausedUsers+`)
+		RETURNING s.id, s.user_id, s.status, p.plan_type
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var ids, expiredUsers []string
+	for rows.Next() {
+		var id, userID, status, planType string
+		if err := rows.Scan(&id, &userID, &status, &planType); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		if status == "expired" && planType != "free" {
+			expiredUsers = append(expiredUsers, userID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	s.subscriptions.Forget(ctx, ids...)
+	if s.cfg != nil && s.cfg.DowngradeToFree {
+		for _, userID := range expiredUsers {
+			s.enrollFree(ctx, userID)
+		}
+	}
+	return nil
+}
+
+// Suspend moves an active or past_due subscription into suspended, e.g.
+// while a chargeback on its payment is open. It reports whether the
+// subscription was suspended by this call.
+func (s *Service) Suspend(ctx context.Context, id string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE subscriptions SET status = 'suspended', updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND status IN ('active', 'past_due')
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	s.subscriptions.Forget(ctx, id)
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Reinstate lifts a suspension: the subscription is active again if its
+// period has not ended meanwhile, expired otherwise. It is a no-op for
+// subscriptions that are not suspended, and returns ErrAlreadySubscribed if
+// the user has since taken out another subscription.
+func (s *Service) Reinstate(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET status = CASE WHEN end_date > NOW() THEN 'active' ELSE 'expired' END,
+			updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND status = 'suspended'
+	`, id)
+	if db.IsUniqueViolation(err, oneActiveIndex) {
+		return ErrAlreadySubscribed
+	}
+	if err == nil {
+		s.subscriptions.Forget(ctx, id)
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package subscription
+
+import (
+	"context"
+	"time"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RunMetricsSweeper periodically recomputes business gauges (active
+// subscriptions, MRR, trial conversions, dispute rates) from the database
+// until ctx is done.
+func (s *Service) RunMetricsSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.refreshBusinessMetrics(ctx); err != nil {
+			logrus.Errorf("Failed to refresh business metrics: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) refreshBusinessMetrics(ctx context.Context) error {
+	// Aggregates tolerate replication lag, so read from a replica
+	reader := s.db.Reader()
+
+	rows, err := reader.QueryContext(ctx, `
+		SELECT plan_id::text, COUNT(*)
+		FROM subscriptions
+		WHERE status = 'active' AND end_date > NOW()
+		GROUP BY plan_id
+	`)
+	if err != nil {
+		return err
+	}
+	active := make(map[string]int)
+	for rows.Next() {
+		var planID string
+		var count int
+		if err := rows.Scan(&planID, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		active[planID] = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Normalize each subscription's amount to a monthly figure using its plan's cycle
+	rows, err = reader.QueryContext(ctx, `
+		SELECT s.currency, COALESCE(SUM(
+			CASE p.billing_cycle
+				WHEN 'yearly' THEN s.amount / 12
+				WHEN 'weekly' THEN s.amount * 4.33
+				WHEN 'daily' THEN s.amount * 30.44
+				ELSE s.amount
+			END), 0)
+		FROM subscriptions s
+		JOIN plans p ON p.id = s.plan_id
+		WHERE s.status = 'active' AND s.end_date > NOW()
+		GROUP BY s.currency
+	`)
+	if err != nil {
+		return err
+	}
+	mrr := make(map[string]float64)
+	for rows.Next() {
+		var currency string
+		var amount float64
+		if err := rows.Scan(&currency, &amount); err != nil {
+			rows.Close()
+			return err
+		}
+		mrr[currency] = amount
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = reader.QueryContext(ctx, `
+		SELECT plan_id::text, COUNT(*)
+		FROM subscriptions
+		WHERE trial_end IS NOT NULL AND trial_end <= NOW() AND status = 'active'
+		GROUP BY plan_id
+	`)
+	if err != nil {
+		return err
+	}
+	conversions := make(map[string]int)
+	for rows.Next() {
+		var planID string
+		var count int
+		if err := rows.Scan(&planID, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		conversions[planID] = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = reader.QueryContext(ctx, `
+		SELECT p.plan_id::text, p.payments, COALESCE(d.disputes, 0)
+		FROM (
+			SELECT s.plan_id, COUNT(*) AS payments
+			FROM payment_transactions pt
+			JOIN subscriptions s ON s.id = pt.subscription_id
+			WHERE pt.status IN ('completed', 'refunded') AND pt.created_at > NOW() - INTERVAL '30 days'
+			GROUP BY s.plan_id
+		) p
+		LEFT JOIN (
+			SELECT plan_id, COUNT(*) AS disputes
+			FROM disputes
+			WHERE created_at > NOW() - INTERVAL '30 days'
+			GROUP BY plan_id
+		) d ON d.plan_id = p.plan_id
+	`)
+	if err != nil {
+		return err
+	}
+	disputeRates := make(map[string]float64)
+	for rows.Next() {
+		var planID string
+		var payments, disputed int
+		if err := rows.Scan(&planID, &payments, &disputed); err != nil {
+			rows.Close()
+			return err
+		}
+		disputeRates[planID] = float64(disputed) / float64(payments)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	telemetry.SetActiveSubscriptions(active)
+	telemetry.SetMonthlyRecurringRevenue(mrr)
+	telemetry.SetTrialConversions(conversions)
+	telemetry.SetDisputeRates(disputeRates)
+	return nil
+}
@@ -0,0 +1,145 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"scalable-paywall/internal/db"
+
+	"github.com/shopspring/decimal"
+)
+
+// Subscriptions awaiting their first payment: pending while a gateway
+// payment is confirmed, pending_payment while a manual invoice is unpaid
+const (
+	statusPending        = "pending"
+	statusPendingPayment = "pending_payment"
+)
+
+// errNotPending rolls back ActivatePending when the subscription was already
+// activated or cancelled
+var errNotPending = errors.New("subscription is not pending")
+
+// CreatePending records a subscription awaiting its first payment. It grants
+// no access until ActivatePending is called once the payment succeeds.
+func (s *Service) CreatePending(ctx context.Context, userID, planID, paymentMethod string, amount decimal.Decimal, currency string, autoRenew bool) (*Subscription, error) {
+	return s.createAwaiting(ctx, statusPending, userID, planID, paymentMethod, amount, currency, autoRenew)
+}
+
+// CreateInvoiced records a subscription paid by a manual invoice. It stays
+// in pending_payment, without access, until ActivateInvoiced is called once
+// the invoice is paid.
+func (s *Service) CreateInvoiced(ctx context.Context, userID, planID, paymentMethod string, amount decimal.Decimal, currency string, autoRenew bool) (*Subscription, error) {
+	return s.createAwaiting(ctx, statusPendingPayment, userID, planID, paymentMethod, amount, currency, autoRenew)
+}
+
+func (s *Service) createAwaiting(ctx context.Context, status, userID, planID, paymentMethod string, amount decimal.Decimal, currency string, autoRenew bool) (*Subscription, error) {
+	plan, err := s.getChargedPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sub := &Subscription{
+		ID:            generateID(),
+		UserID:        userID,
+		PlanID:        planID,
+		Status:        status,
+		StartDate:     now,
+		EndDate:       plan.Cycle.PeriodEnd(now),
+		AutoRenew:     autoRenew,
+		PaymentMethod: paymentMethod,
+		Amount:        amount,
+		Currency:      currency,
+		Version:       1,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := s.createSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// ActivatePending starts a pending subscription's first period from now. It
+// returns false if the subscription was no longer pending, so concurrent
+// confirmations (callback and webhook) activate it only once, and
+// ErrAlreadySubscribed if the user got another paid subscription meanwhile.
+func (s *Service) ActivatePending(ctx context.Context, id string) (bool, error) {
+	return s.activateAwaiting(ctx, id, statusPending)
+}
+
+// ActivateInvoiced starts a pending_payment subscription's first period from
+// now once its invoice is paid, like ActivatePending.
+func (s *Service) ActivateInvoiced(ctx context.Context, id string) (bool, error) {
+	return s.activateAwaiting(ctx, id, statusPendingPayment)
+}
+
+func (s *Service) activateAwaiting(ctx context.Context, id, status string) (bool, error) {
+	var userID, planID string
+	err := s.db.QueryRowContext(ctx, `SELECT user_id, plan_id FROM subscriptions WHERE id = $1`, id).Scan(&userID, &planID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	plan, err := s.getChargedPlan(ctx, planID)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+
+	// The paid subscription replaces any free one the user had
+	sub := &Subscription{ID: id}
+	err = s.replaceFree(ctx, userID, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			UPDATE subscriptions
+			SET status = 'active', start_date = $3, end_date = $4,
+				updated_at = NOW(), version = version + 1
+			WHERE id = $1 AND status = $2
+			RETURNING user_id, plan_id, amount, currency
+		`, id, status, now, plan.Cycle.PeriodEnd(now)).Scan(&sub.UserID, &sub.PlanID, &sub.Amount, &sub.Currency)
+		if err == sql.ErrNoRows {
+			return errNotPending
+		}
+		if db.IsUniqueViolation(err, oneActiveIndex) {
+			return ErrAlreadySubscribed
+		}
+		return err
+	})
+	if errors.Is(err, errNotPending) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.subscriptions.Forget(ctx, id)
+
+	s.recordConversion(ctx, sub)
+	return true, nil
+}
+
+// CancelPending cancels a subscription whose first payment failed.
+func (s *Service) CancelPending(ctx context.Context, id string) error {
+	return s.cancelAwaiting(ctx, id, statusPending)
+}
+
+// CancelInvoiced cancels a pending_payment subscription whose invoice was
+// voided unpaid.
+func (s *Service) CancelInvoiced(ctx context.Context, id string) error {
+	return s.cancelAwaiting(ctx, id, statusPendingPayment)
+}
+
+func (s *Service) cancelAwaiting(ctx context.Context, id, status string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET status = 'cancelled', updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND status = $2
+	`, id, status)
+	if err == nil {
+		s.subscriptions.Forget(ctx, id)
+	}
+	return err
+}
@@ -0,0 +1,237 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"reflect"
+	"time"
+
+	"scalable-paywall/internal/money"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+type ChangePlanRequest struct {
+	PlanID string `json:"plan_id" binding:"required"`
+}
+
+// ChangePlanPreview is what changing a subscription's plan now would cost.
+// Credit is the unused part of the current period at the subscription's
+// amount; Charge is the new plan for the rest of the period, or for a full
+// new period when the billing cycle changes. Total is due today; a credit
+// larger than the charge is returned as Refund.
+type ChangePlanPreview struct {
+	SubscriptionID  string          `json:"subscription_id"`
+	FromPlanID      string          `json:"from_plan_id"`
+	ToPlanID        string          `json:"to_plan_id"`
+	Credit          decimal.Decimal `json:"credit"`
+	Charge          decimal.Decimal `json:"charge"`
+	Tax             decimal.Decimal `json:"tax"`
+	Total           decimal.Decimal `json:"total"`
+	Refund          decimal.Decimal `json:"refund"`
+	Currency        string          `json:"currency"`
+	NextBillingDate time.Time       `json:"next_billing_date"`
+	NextAmount      decimal.Decimal `json:"next_amount"`
+	Entitlements    EntitlementDiff `json:"entitlements"`
+}
+
+// EntitlementDiff is how entitlements change between two plans: features
+// gained, lost and with a different value, and changed usage limits and
+// plan type keyed by their snapshot field.
+type EntitlementDiff struct {
+	Added   map[string]interface{} `json:"added"`
+	Removed map[string]interface{} `json:"removed"`
+	Changed map[string]ValueChange `json:"changed"`
+	Limits  map[string]ValueChange `json:"limits"`
+}
+
+type ValueChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// PreviewPlanChange shows the prorated charge or refund, tax, next billing
+// date and entitlement changes of moving an active subscription to another
+// plan now, without changing anything.
+func (s *Service) PreviewPlanChange(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subscription ID is required"})
+		return
+	}
+
+	var req ChangePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordSubscriptionOperation("change_plan_preview", "validation_error")
+		return
+	}
+
+	ctx := c.Request.Context()
+	subscription, err := s.getSubscriptionByID(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+			telemetry.RecordSubscriptionOperation("change_plan_preview", "not_found")
+			return
+		}
+		logrus.Errorf("Failed to get subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation("change_plan_preview", "db_error")
+		return
+	}
+
+	if subscription.Status != "active" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subscription is not active"})
+		telemetry.RecordSubscriptionOperation("change_plan_preview", "invalid_status")
+		return
+	}
+	if subscription.PlanID == req.PlanID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subscription is already on this plan"})
+		telemetry.RecordSubscriptionOperation("change_plan_preview", "validation_error")
+		return
+	}
+
+	plan, err := s.getChargedPlan(ctx, req.PlanID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Plan not found"})
+		telemetry.RecordSubscriptionOperation("change_plan_preview", "not_found")
+		return
+	}
+	var target *PlanSnapshot
+	if err == nil {
+		target, err = s.planSnapshot(ctx, req.PlanID)
+	}
+	current := subscription.PlanSnapshot
+	if err == nil && current == nil {
+		current, err = s.planSnapshot(ctx, subscription.PlanID)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to load plans for change preview of subscription %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation("change_plan_preview", "db_error")
+		return
+	}
+
+	if !plan.Active {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Plan is not available"})
+		telemetry.RecordSubscriptionOperation("change_plan_preview", "validation_error")
+		return
+	}
+	if !subscription.Amount.IsZero() && !plan.Free && plan.Currency != subscription.Currency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Changing to a plan in another currency is not supported"})
+		telemetry.RecordSubscriptionOperation("change_plan_preview", "validation_error")
+		return
+	}
+
+	charge := s.resolveCharge(ctx, plan, subscription.UserID)
+	preview := previewPlanChange(subscription, plan.Cycle, charge, time.Now())
+	preview.ToPlanID = plan.ID
+	preview.Entitlements = diffEntitlements(current, target)
+
+	c.JSON(http.StatusOK, preview)
+	telemetry.RecordSubscriptionOperation("change_plan_preview", "success")
+}
+
+// previewPlanChange prorates moving sub onto a plan priced at charge and
+// billed every cycle at now. A plan on the same cycle keeps the current
+// period; any other, or any change from a free plan, starts a new one now,
+// crediting what's left of the old.
+func previewPlanChange(sub *Subscription, cycle BillingCycle, charge Charge, now time.Time) *ChangePlanPreview {
+	currency := sub.Currency
+	if sub.Amount.IsZero() {
+		currency = charge.Currency
+	}
+	unused := unusedFraction(currentPeriodStart(sub), sub.EndDate, now)
+	preview := &ChangePlanPreview{
+		SubscriptionID: sub.ID,
+		FromPlanID:     sub.PlanID,
+		Credit:         money.Round(sub.Amount.Mul(unused), currency),
+		Currency:       currency,
+		NextAmount:     charge.Price,
+	}
+
+	// A free subscription has no period to keep
+	current := cycleOf(sub)
+	if !sub.Amount.IsZero() && current.Unit == cycle.Unit && current.Interval == cycle.Interval && unused.IsPositive() {
+		preview.Charge = money.Round(charge.Price.Mul(unused), currency)
+		preview.NextBillingDate = sub.EndDate
+	} else {
+		preview.Charge = charge.Price
+		preview.NextBillingDate = cycle.PeriodEnd(now)
+	}
+
+	due := preview.Charge.Sub(preview.Credit)
+	if due.IsNegative() {
+		preview.Refund = due.Neg()
+		due = decimal.Zero
+	}
+	preview.Tax = taxOn(due, currency)
+	preview.Total = due.Add(preview.Tax)
+	return preview
+}
+
+// diffEntitlements compares the features, usage limits and type of two plan
+// snapshots. Either may be nil for a plan without one.
+func diffEntitlements(from, to *PlanSnapshot) EntitlementDiff {
+	if from == nil {
+		from = &PlanSnapshot{}
+	}
+	if to == nil {
+		to = &PlanSnapshot{}
+	}
+	diff := EntitlementDiff{
+		Added:   map[string]interface{}{},
+		Removed: map[string]interface{}{},
+		Changed: map[string]ValueChange{},
+		Limits:  map[string]ValueChange{},
+	}
+	for name, value := range to.Features {
+		old, ok := from.Features[name]
+		if !ok {
+			diff.Added[name] = value
+		} else if !reflect.DeepEqual(old, value) {
+			diff.Changed[name] = ValueChange{From: old, To: value}
+		}
+	}
+	for name, value := range from.Features {
+		if _, ok := to.Features[name]; !ok {
+			diff.Removed[name] = value
+		}
+	}
+
+	limit := func(name string, from, to *int) {
+		if from == nil && to == nil || from != nil && to != nil && *from == *to {
+			return
+		}
+		// A missing limit is unlimited and encodes as null
+		change := ValueChange{}
+		if from != nil {
+			change.From = *from
+		}
+		if to != nil {
+			change.To = *to
+		}
+		diff.Limits[name] = change
+	}
+	limit("max_usage_per_day", from.MaxUsagePerDay, to.MaxUsagePerDay)
+	limit("max_usage_per_month", from.MaxUsagePerMonth, to.MaxUsagePerMonth)
+	if from.Type != to.Type {
+		diff.Limits["type"] = ValueChange{From: from.Type, To: to.Type}
+	}
+	return diff
+}
+
+// planSnapshot captures the live plan as a new subscription to it would
+func (s *Service) planSnapshot(ctx context.Context, planID string) (*PlanSnapshot, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT `+planSnapshotSQL+` FROM plans p WHERE p.id = $1`, planID).Scan(&data)
+	if err != nil {
+		return nil, err
+	}
+	return decodePlanSnapshot(data)
+}
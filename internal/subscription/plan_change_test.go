@@ -0,0 +1,74 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewPlanChange(t *testing.T) {
+	start := time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)
+	monthly := BillingCycle{Unit: "monthly", Interval: 1}
+	sub := &Subscription{
+		ID:        "sub-1",
+		PlanID:    "plan-basic",
+		StartDate: start,
+		EndDate:   time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC),
+		Amount:    decimal.RequireFromString("10.00"),
+		Currency:  "USD",
+	}
+	halfway := start.Add(15 * 24 * time.Hour)
+
+	upgrade := previewPlanChange(sub, monthly, Charge{Price: decimal.RequireFromString("30.00"), Currency: "USD"}, halfway)
+	assert.Equal(t, "5", upgrade.Credit.String())
+	assert.Equal(t, "15", upgrade.Charge.String())
+	assert.Equal(t, "10", upgrade.Total.String())
+	assert.True(t, upgrade.Refund.IsZero())
+	assert.Equal(t, sub.EndDate, upgrade.NextBillingDate)
+	assert.Equal(t, "30", upgrade.NextAmount.String())
+
+	downgrade := previewPlanChange(sub, monthly, Charge{Price: decimal.RequireFromString("4.00"), Currency: "USD"}, halfway)
+	assert.True(t, downgrade.Total.IsZero())
+	assert.Equal(t, "3", downgrade.Refund.String())
+
+	// A new cycle charges a full period from now
+	yearly := BillingCycle{Unit: "yearly", Interval: 1}
+	annual := previewPlanChange(sub, yearly, Charge{Price: decimal.RequireFromString("100.00"), Currency: "USD"}, halfway)
+	assert.Equal(t, "100", annual.Charge.String())
+	assert.Equal(t, "95", annual.Total.String())
+	assert.Equal(t, halfway.AddDate(1, 0, 0), annual.NextBillingDate)
+
+	free := &Subscription{PlanID: "plan-free", StartDate: start, EndDate: freePeriodEnd, Currency: "USD"}
+	paid := previewPlanChange(free, monthly, Charge{Price: decimal.RequireFromString("9.99"), Currency: "EUR"}, halfway)
+	assert.Equal(t, "9.99", paid.Total.String())
+	assert.Equal(t, "EUR", paid.Currency)
+	assert.Equal(t, halfway.AddDate(0, 1, 0), paid.NextBillingDate)
+}
+
+func TestDiffEntitlements(t *testing.T) {
+	day, more := 100, 1000
+	from := &PlanSnapshot{
+		Type:           "basic",
+		Features:       map[string]interface{}{"hd": false, "downloads": float64(5), "ads": true},
+		MaxUsagePerDay: &day,
+	}
+	to := &PlanSnapshot{
+		Type:             "premium",
+		Features:         map[string]interface{}{"hd": true, "downloads": float64(5), "offline": true},
+		MaxUsagePerMonth: &more,
+	}
+
+	diff := diffEntitlements(from, to)
+	assert.Equal(t, map[string]interface{}{"offline": true}, diff.Added)
+	assert.Equal(t, map[string]interface{}{"ads": true}, diff.Removed)
+	assert.Equal(t, map[string]ValueChange{"hd": {From: false, To: true}}, diff.Changed)
+	assert.Equal(t, map[string]ValueChange{
+		"max_usage_per_day":   {From: 100, To: nil},
+		"max_usage_per_month": {From: nil, To: 1000},
+		"type":                {From: "basic", To: "premium"},
+	}, diff.Limits)
+
+	assert.Empty(t, diffEntitlements(from, from).Limits)
+}
@@ -0,0 +1,129 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"scalable-paywall/internal/notification"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SendRenewalReminders notifies users whose subscriptions end within any of
+// the lead times (in days). Reminders already sent are skipped, so it is safe
+// to run repeatedly.
+func (s *Service) SendRenewalReminders(ctx context.Context, days []int) error {
+	// Shortest lead time first, so a subscription found late only gets the
+	// reminder closest to its end date
+	leadTimes := append([]int(nil), days...)
+	sort.Ints(leadTimes)
+
+	var errs []error
+	for _, d := range leadTimes {
+		if err := s.sendRenewalReminders(ctx, d); err != nil {
+			errs = append(errs, fmt.Errorf("%d-day reminders: %w", d, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Service) sendRenewalReminders(ctx context.Context, days int) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.id, s.user_id, s.plan_id, s.end_date, s.auto_renew, s.amount, s.currency,
+			COALESCE(u.tenant_id, ''), COALESCE(u.locale, '')
+		FROM subscriptions s
+		LEFT JOIN users u ON u.id = s.user_id
+		WHERE s.status = 'active'
+			AND s.end_date > NOW()
+			AND s.end_date <= NOW() + make_interval(days => $1)
+			AND NOT EXISTS (
+				SELECT 1 FROM renewal_reminders r
+				WHERE r.subscription_id = s.id
+					AND r.period_end = s.end_date
+					AND r.days_before <= $1
+			)
+	`, days)
+	if err != nil {
+		return err
+	}
+
+	// The tenant of each subscription's user picks the reminder's templates,
+	// and the user's locale their language
+	var due []Subscription
+	var tenants, locales []string
+	for rows.Next() {
+		var sub Subscription
+		var tenantID, locale string
+		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.PlanID, &sub.EndDate,
+			&sub.AutoRenew, &sub.Amount, &sub.Currency, &tenantID, &locale); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, sub)
+		tenants = append(tenants, tenantID)
+		locales = append(locales, locale)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, sub := range due {
+		if err := s.sendRenewalReminder(ctx, sub, tenants[i], locales[i], days); err != nil {
+			logrus.Errorf("Failed to send renewal reminder for subscription %s: %v", sub.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) sendRenewalReminder(ctx context.Context, sub Subscription, tenantID, locale string, days int) error {
+	// Claim the reminder first so concurrent sweepers don't both send it
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO renewal_reminders (subscription_id, period_end, days_before)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, sub.ID, sub.EndDate, days)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	notificationType := notification.TypeExpiring
+	if sub.AutoRenew {
+		notificationType = notification.TypeRenewalReminder
+	}
+
+	err = s.notifier.Notify(ctx, notification.Notification{
+		Type:           notificationType,
+		TenantID:       tenantID,
+		Locale:         locale,
+		UserID:         sub.UserID,
+		SubscriptionID: sub.ID,
+		Data: map[string]interface{}{
+			"plan_id":    sub.PlanID,
+			"end_date":   sub.EndDate,
+			"days_left":  int(math.Ceil(time.Until(sub.EndDate).Hours() / 24)),
+			"auto_renew": sub.AutoRenew,
+			"amount":     sub.Amount,
+			"currency":   sub.Currency,
+		},
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		// Release the claim so the next sweep retries
+		if _, delErr := s.db.ExecContext(ctx, `
+			DELETE FROM renewal_reminders
+			WHERE subscription_id = $1 AND period_end = $2 AND days_before = $3
+		`, sub.ID, sub.EndDate, days); delErr != nil {
+			logrus.Errorf("Failed to release renewal reminder claim: %v", delErr)
+		}
+		return fmt.Errorf("notify: %w", err)
+	}
+	return nil
+}
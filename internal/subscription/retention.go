@@ -0,0 +1,378 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/money"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// Retention offer types
+const (
+	OfferDiscount = "discount"
+	OfferPause    = "pause"
+)
+
+// offerValidity is how long a presented offer can be accepted
+const offerValidity = 24 * time.Hour
+
+// RetentionOffer is a win-back offer presented to a cancelling subscriber,
+// with its terms as offered.
+type RetentionOffer struct {
+	ID             string     `json:"id"`
+	SubscriptionID string     `json:"subscription_id"`
+	Key            string     `json:"key"`
+	Type           string     `json:"type"`
+	PercentOff     *int       `json:"percent_off,omitempty"`
+	Renewals       *int       `json:"renewals,omitempty"`
+	PauseMonths    *int       `json:"pause_months,omitempty"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RespondedAt    *time.Time `json:"responded_at,omitempty"`
+}
+
+// CancellationOfferResponse answers a cancellation that was held back to
+// present a win-back offer; the subscription is unchanged.
+type CancellationOfferResponse struct {
+	Subscription *Subscription  `json:"subscription"`
+	Offer        RetentionOffer `json:"offer"`
+}
+
+// RetentionReport counts win-back offers presented in the last Days days
+// and how subscribers answered them, per offer.
+type RetentionReport struct {
+	Days   int           `json:"days"`
+	Offers []OfferResult `json:"offers"`
+}
+
+// OfferResult is one offer's outcomes. Offers neither accepted nor
+// declined were left unanswered; SaveRate is Accepted over Presented.
+type OfferResult struct {
+	Key       string  `json:"key"`
+	Presented int     `json:"presented"`
+	Accepted  int     `json:"accepted"`
+	Declined  int     `json:"declined"`
+	SaveRate  float64 `json:"save_rate"`
+}
+
+// eligibleOffer returns the first offer sub qualifies for at now, or nil.
+// A pause only suits subscriptions that would renew.
+func eligibleOffer(offers []config.RetentionOfferConfig, sub *Subscription, now time.Time) *config.RetentionOfferConfig {
+	tenure := int(now.Sub(sub.StartDate) / (24 * time.Hour))
+	for i := range offers {
+		offer := &offers[i]
+		if len(offer.PlanIDs) > 0 && !containsString(offer.PlanIDs, sub.PlanID) {
+			continue
+		}
+		if tenure < offer.MinTenureDays {
+			continue
+		}
+		if offer.Type == OfferPause && !sub.AutoRenew {
+			continue
+		}
+		return offer
+	}
+	return nil
+}
+
+// discountedAmount takes percentOff off amount, rounded to the currency's
+// minor unit
+func discountedAmount(amount decimal.Decimal, currency string, percentOff int) decimal.Decimal {
+	if percentOff <= 0 {
+		return amount
+	}
+	factor := decimal.NewFromInt(int64(100 - percentOff)).Div(decimal.NewFromInt(100))
+	return money.Round(amount.Mul(factor), currency)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// presentRetentionOffer picks the win-back offer for an active paid
+// subscription being cancelled and records it as presented. An offer still
+// open from an earlier attempt is presented again. It returns nil when no
+// offer applies, including within subscription.retention_cooldown_days of
+// accepting one.
+func (s *Service) presentRetentionOffer(ctx context.Context, sub *Subscription) (*RetentionOffer, error) {
+	if s.cfg == nil || len(s.cfg.RetentionOffers) == 0 || sub.Status != "active" {
+		return nil, nil
+	}
+	isFree, err := s.IsFreePlan(ctx, sub.PlanID)
+	if err != nil {
+		return nil, err
+	}
+	if isFree {
+		return nil, nil
+	}
+
+	var accepted bool
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM retention_offers
+			WHERE subscription_id = $1 AND status = 'accepted'
+				AND responded_at > NOW() - make_interval(days => $2)
+		)
+	`, sub.ID, s.cfg.RetentionCooldownDays).Scan(&accepted); err != nil {
+		return nil, err
+	}
+	if accepted {
+		return nil, nil
+	}
+
+	open, err := scanRetentionOffer(s.db.QueryRowContext(ctx, `
+		SELECT `+retentionOfferColumns+`
+		FROM retention_offers
+		WHERE subscription_id = $1 AND status = 'presented' AND created_at > $2
+		ORDER BY created_at DESC LIMIT 1
+	`, sub.ID, time.Now().Add(-offerValidity)).Scan)
+	if err == nil {
+		return open, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	offer := eligibleOffer(s.cfg.RetentionOffers, sub, time.Now())
+	if offer == nil {
+		return nil, nil
+	}
+	var percentOff, renewals, pauseMonths *int
+	if offer.Type == OfferDiscount {
+		percentOff, renewals = &offer.PercentOff, &offer.Renewals
+	} else {
+		pauseMonths = &offer.PauseMonths
+	}
+	presented, err := scanRetentionOffer(s.db.QueryRowContext(ctx, `
+		INSERT INTO retention_offers (subscription_id, user_id, offer_key, offer_type,
+			percent_off, renewals, pause_months)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+retentionOfferColumns,
+		sub.ID, sub.UserID, offer.Key, offer.Type, percentOff, renewals, pauseMonths).Scan)
+	if err != nil {
+		return nil, err
+	}
+	telemetry.RecordRetentionOffer(presented.Key, "presented")
+	return presented, nil
+}
+
+// declineRetentionOffers marks the offers still open for a subscription
+// that was cancelled anyway as declined
+func (s *Service) declineRetentionOffers(ctx context.Context, subscriptionID string) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE retention_offers SET status = 'declined', responded_at = NOW()
+		WHERE subscription_id = $1 AND status = 'presented'
+		RETURNING offer_key
+	`, subscriptionID)
+	if err != nil {
+		logrus.Errorf("Failed to decline retention offers of subscription %s: %v", subscriptionID, err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			logrus.Errorf("Failed to read declined retention offer: %v", err)
+			return
+		}
+		telemetry.RecordRetentionOffer(key, "declined")
+	}
+}
+
+// AcceptRetentionOffer applies the offer in the offer_id path parameter to
+// the subscription it was presented for, which stays active: a discount
+// lowers its next renewal charges, a pause stops access and billing for the
+// offered months once the paid period ends. Offers are accepted at most
+// once and within a day of being presented.
+func (s *Service) AcceptRetentionOffer(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	offerID := c.Param("offer_id")
+
+	offer, err := s.acceptRetentionOffer(ctx, id, offerID)
+	if err == sql.ErrNoRows {
+		current, getErr := s.getRetentionOffer(ctx, id, offerID)
+		if getErr == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Retention offer not found"})
+			telemetry.RecordSubscriptionOperation("accept_offer", "not_found")
+			return
+		}
+		if getErr == nil {
+			message := "Retention offer has expired"
+			if current.Status != "presented" {
+				message = "Retention offer was already " + current.Status
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": message})
+			telemetry.RecordSubscriptionOperation("accept_offer", "conflict")
+			return
+		}
+		err = getErr
+	}
+	if err == ErrNotCancellable {
+		c.JSON(http.StatusConflict, gin.H{"error": "Subscription is not active"})
+		telemetry.RecordSubscriptionOperation("accept_offer", "conflict")
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to accept retention offer %s: %v", offerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation("accept_offer", "db_error")
+		return
+	}
+	telemetry.RecordRetentionOffer(offer.Key, "accepted")
+
+	s.subscriptions.Forget(ctx, id)
+	subscription, err := s.getSubscriptionByID(ctx, id)
+	if err != nil {
+		logrus.Errorf("Failed to get subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation("accept_offer", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+	telemetry.RecordSubscriptionOperation("accept_offer", "success")
+}
+
+// acceptRetentionOffer marks an open offer accepted and applies it in one
+// transaction. It returns sql.ErrNoRows if the offer is not open for the
+// subscription, and ErrNotCancellable if the subscription is no longer
+// active.
+func (s *Service) acceptRetentionOffer(ctx context.Context, subscriptionID, offerID string) (*RetentionOffer, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	offer, err := scanRetentionOffer(tx.QueryRowContext(ctx, `
+		UPDATE retention_offers SET status = 'accepted', responded_at = NOW()
+		WHERE id = $1 AND subscription_id = $2 AND status = 'presented' AND created_at > $3
+		RETURNING `+retentionOfferColumns,
+		offerID, subscriptionID, time.Now().Add(-offerValidity)).Scan)
+	if err != nil {
+		return nil, err
+	}
+
+	var result sql.Result
+	if offer.Type == OfferDiscount {
+		result, err = tx.ExecContext(ctx, `
+			UPDATE subscriptions
+			SET discount_percent = $2, discount_renewals = $3, updated_at = NOW(), version = version + 1
+			WHERE id = $1 AND status = 'active'
+		`, subscriptionID, *offer.PercentOff, *offer.Renewals)
+	} else {
+		// Billing resumes, and the next renewal is charged, at the new end date
+		result, err = tx.ExecContext(ctx, `
+			UPDATE subscriptions
+			SET paused_from = end_date, end_date = end_date + make_interval(months => $2),
+				updated_at = NOW(), version = version + 1
+			WHERE id = $1 AND status = 'active'
+		`, subscriptionID, *offer.PauseMonths)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNotCancellable
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return offer, nil
+}
+
+func (s *Service) getRetentionOffer(ctx context.Context, subscriptionID, offerID string) (*RetentionOffer, error) {
+	return scanRetentionOffer(s.db.QueryRowContext(ctx, `
+		SELECT `+retentionOfferColumns+`
+		FROM retention_offers WHERE id = $1 AND subscription_id = $2
+	`, offerID, subscriptionID).Scan)
+}
+
+// retentionOfferColumns lists the columns scanRetentionOffer expects, in order
+const retentionOfferColumns = `id, subscription_id, offer_key, offer_type, percent_off, renewals,
+	pause_months, status, created_at, responded_at`
+
+func scanRetentionOffer(scan func(dest ...interface{}) error) (*RetentionOffer, error) {
+	var o RetentionOffer
+	if err := scan(&o.ID, &o.SubscriptionID, &o.Key, &o.Type, &o.PercentOff, &o.Renewals,
+		&o.PauseMonths, &o.Status, &o.CreatedAt, &o.RespondedAt); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// GetRetentionAnalytics serves GET /analytics/retention: win-back offers
+// presented in the last ?days= days (default 30, at most 366) with how many
+// were accepted or declined and the save rate, per offer.
+func (s *Service) GetRetentionAnalytics(c *gin.Context) {
+	days := 30
+	if daysStr := c.Query("days"); daysStr != "" {
+		d, err := strconv.Atoi(daysStr)
+		if err != nil || d < 1 || d > 366 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 366"})
+			telemetry.RecordSubscriptionOperation("retention_analytics", "validation_error")
+			return
+		}
+		days = d
+	}
+
+	rows, err := s.db.Reader().QueryContext(c.Request.Context(), `
+		SELECT offer_key, COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'accepted'),
+			COUNT(*) FILTER (WHERE status = 'declined')
+		FROM retention_offers
+		WHERE created_at >= NOW() - make_interval(days => $1)
+		GROUP BY offer_key
+		ORDER BY offer_key
+	`, days)
+	if err != nil {
+		logrus.Errorf("Failed to compute retention analytics: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation("retention_analytics", "db_error")
+		return
+	}
+	defer rows.Close()
+
+	report := RetentionReport{Days: days, Offers: []OfferResult{}}
+	for rows.Next() {
+		var result OfferResult
+		if err := rows.Scan(&result.Key, &result.Presented, &result.Accepted, &result.Declined); err != nil {
+			logrus.Errorf("Failed to read retention analytics: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			telemetry.RecordSubscriptionOperation("retention_analytics", "db_error")
+			return
+		}
+		if result.Presented > 0 {
+			result.SaveRate = float64(result.Accepted) / float64(result.Presented)
+		}
+		report.Offers = append(report.Offers, result)
+	}
+	if err := rows.Err(); err != nil {
+		logrus.Errorf("Failed to read retention analytics: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation("retention_analytics", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+	telemetry.RecordSubscriptionOperation("retention_analytics", "success")
+}
@@ -0,0 +1,49 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEligibleOfferPicksFirstMatch(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	offers := []config.RetentionOfferConfig{
+		{Key: "pro_save", Type: OfferDiscount, PercentOff: 30, Renewals: 2, PlanIDs: []string{"pro"}},
+		{Key: "loyal_save", Type: OfferDiscount, PercentOff: 20, Renewals: 3, MinTenureDays: 90},
+		{Key: "pause_1", Type: OfferPause, PauseMonths: 1},
+	}
+
+	pro := &Subscription{PlanID: "pro", StartDate: now.AddDate(0, 0, -10), AutoRenew: true}
+	assert.Equal(t, "pro_save", eligibleOffer(offers, pro, now).Key)
+
+	loyal := &Subscription{PlanID: "basic", StartDate: now.AddDate(0, -6, 0), AutoRenew: true}
+	assert.Equal(t, "loyal_save", eligibleOffer(offers, loyal, now).Key)
+
+	recent := &Subscription{PlanID: "basic", StartDate: now.AddDate(0, 0, -10), AutoRenew: true}
+	assert.Equal(t, "pause_1", eligibleOffer(offers, recent, now).Key)
+
+	// Nothing to pause for a subscription that won't renew
+	recent.AutoRenew = false
+	assert.Nil(t, eligibleOffer(offers, recent, now))
+}
+
+func TestDiscountedAmount(t *testing.T) {
+	assert.Equal(t, "14.99", discountedAmount(decimal.RequireFromString("19.99"), "USD", 25).String())
+	assert.Equal(t, "750", discountedAmount(decimal.NewFromInt(1000), "JPY", 25).String())
+	assert.Equal(t, "19.99", discountedAmount(decimal.RequireFromString("19.99"), "USD", 0).String())
+}
+
+func TestRenewalClaimAmount(t *testing.T) {
+	claim := RenewalClaim{Subscription: Subscription{
+		Amount: decimal.NewFromInt(20), Currency: "USD", DiscountPercent: 50, DiscountRenewals: 1,
+	}}
+	assert.Equal(t, "10", claim.Amount().String())
+
+	claim.Subscription.DiscountRenewals = 0
+	assert.Equal(t, "20", claim.Amount().String())
+}
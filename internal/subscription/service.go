@@ -3,63 +3,139 @@ package subscription
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
 	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/experiment"
+	"scalable-paywall/internal/metadata"
+	"scalable-paywall/internal/middleware"
+	"scalable-paywall/internal/money"
+	"scalable-paywall/internal/notification"
+	_ "scalable-paywall/internal/pci" // payment_token binding tag, log redaction
 	"scalable-paywall/internal/telemetry"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 )
 
+// ErrVersionConflict is returned when a subscription changed between being
+// read and written.
+var ErrVersionConflict = errors.New("subscription was modified concurrently")
+
+// ErrAlreadySubscribed is returned when a write would give a user a second
+// active subscription.
+var ErrAlreadySubscribed = errors.New("user already has an active subscription")
+
+// oneActiveIndex is the unique index allowing one active subscription per user
+const oneActiveIndex = "idx_subscriptions_one_active"
+
 type Service struct {
+	cfg   *config.SubscriptionConfig
 	db    *db.Connection
 	cache *cache.RedisClient
+	// subscriptions caches subscriptions by ID, entitlements by user ID
+	subscriptions *cache.ReadThrough[*Subscription]
+	entitlements  *cache.ReadThrough[*Entitlement]
+	notifier      notification.Notifier
+	experiments   *experiment.Service
 }
 
 type Subscription struct {
-	ID            string    `json:"id" db:"id"`
-	UserID        string    `json:"user_id" db:"user_id"`
-	PlanID        string    `json:"plan_id" db:"plan_id"`
-	Status        string    `json:"status" db:"status"`
-	StartDate     time.Time `json:"start_date" db:"start_date"`
-	EndDate       time.Time `json:"end_date" db:"end_date"`
-	AutoRenew     bool      `json:"auto_renew" db:"auto_renew"`
-	PaymentMethod string    `json:"payment_method" db:"payment_method"`
-	Amount        float64   `json:"amount" db:"amount"`
-	Currency      string    `json:"currency" db:"currency"`
-	CreatedAt     time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+	ID            string                 `json:"id" db:"id"`
+	UserID        string                 `json:"user_id" db:"user_id"`
+	PlanID        string                 `json:"plan_id" db:"plan_id"`
+	Status        string                 `json:"status" db:"status"`
+	StartDate     time.Time              `json:"start_date" db:"start_date"`
+	EndDate       time.Time              `json:"end_date" db:"end_date"`
+	AutoRenew     bool                   `json:"auto_renew" db:"auto_renew"`
+	PaymentMethod string                 `json:"payment_method" db:"payment_method"`
+	Amount        decimal.Decimal        `json:"amount" db:"amount"`
+	Currency      string                 `json:"currency" db:"currency"`
+	ExternalRef   *string                `json:"external_ref,omitempty" db:"external_ref"`
+	PlanSnapshot  *PlanSnapshot          `json:"plan_snapshot,omitempty" db:"plan_snapshot"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty" db:"metadata"`
+	// An accepted win-back offer: DiscountPercent off the next
+	// DiscountRenewals renewals, or a pause from PausedFrom until EndDate
+	DiscountPercent  int        `json:"discount_percent,omitempty" db:"discount_percent"`
+	DiscountRenewals int        `json:"discount_renewals,omitempty" db:"discount_renewals"`
+	PausedFrom       *time.Time `json:"paused_from,omitempty" db:"paused_from"`
+	Version          int        `json:"version" db:"version"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
 }
 
+// CreateSubscriptionRequest may carry the caller's order ID as ExternalRef;
+// retrying with the same ref returns the subscription already created.
+// The amount charged comes from the plan. Amount and Currency are optional
+// and, when sent, must match it.
 type CreateSubscriptionRequest struct {
-	UserID        string  `json:"user_id" binding:"required"`
-	PlanID        string  `json:"plan_id" binding:"required"`
-	PaymentMethod string  `json:"payment_method" binding:"required"`
-	Amount        float64 `json:"amount" binding:"required"`
-	Currency      string  `json:"currency" binding:"required"`
-	AutoRenew     bool    `json:"auto_renew"`
+	UserID        string                 `json:"user_id" binding:"required"`
+	PlanID        string                 `json:"plan_id" binding:"required"`
+	PaymentMethod string                 `json:"payment_method" binding:"omitempty,payment_token"`
+	Amount        *decimal.Decimal       `json:"amount" binding:"omitempty,min=0"`
+	Currency      string                 `json:"currency"`
+	AutoRenew     bool                   `json:"auto_renew"`
+	ExternalRef   string                 `json:"external_ref" binding:"omitempty,max=255"`
+	Metadata      map[string]interface{} `json:"metadata"`
 }
 
+// CreateSubscriptionResponse is the new subscription with how its amount
+// was worked out.
+type CreateSubscriptionResponse struct {
+	*Subscription
+	Charge Charge `json:"charge"`
+}
+
+// UpdateSubscriptionRequest merges Metadata into the subscription's: keys
+// are set, and keys set to null removed.
 type UpdateSubscriptionRequest struct {
-	Status        *string  `json:"status"`
-	AutoRenew     *bool    `json:"auto_renew"`
-	PaymentMethod *string  `json:"payment_method"`
-	Amount        *float64 `json:"amount"`
-	Currency      *string  `json:"currency"`
+	Status        *string                `json:"status"`
+	AutoRenew     *bool                  `json:"auto_renew"`
+	PaymentMethod *string                `json:"payment_method" binding:"omitempty,payment_token"`
+	Amount        *decimal.Decimal       `json:"amount" binding:"omitempty,min=0"`
+	Currency      *string                `json:"currency"`
+	Metadata      map[string]interface{} `json:"metadata"`
+}
+
+// RenewalPreview describes the charge the next renewal will make.
+type RenewalPreview struct {
+	SubscriptionID string          `json:"subscription_id"`
+	RenewsAt       time.Time       `json:"renews_at"`
+	AutoRenew      bool            `json:"auto_renew"`
+	Amount         decimal.Decimal `json:"amount"`
+	Tax            decimal.Decimal `json:"tax"`
+	Total          decimal.Decimal `json:"total"`
+	Currency       string          `json:"currency"`
+	PaymentMethod  string          `json:"payment_method"`
+}
+
+type SubscriptionListResponse struct {
+	Subscriptions []Subscription `json:"subscriptions"`
+	Limit         int            `json:"limit"`
+	NextCursor    string         `json:"next_cursor,omitempty"`
 }
 
-func NewService(db *db.Connection, cache *cache.RedisClient) *Service {
+func NewService(cfg *config.SubscriptionConfig, db *db.Connection, cache *cache.RedisClient, notifier notification.Notifier, experiments *experiment.Service) *Service {
 	return &Service{
-		db:    db,
-		cache: cache,
+		cfg:           cfg,
+		db:            db,
+		cache:         cache,
+		subscriptions: newSubscriptionCache(cache),
+		entitlements:  newEntitlementCache(cache),
+		notifier:      notifier,
+		experiments:   experiments,
 	}
 }
 
+// CreateSubscription subscribes a user to a free plan. Paid plans answer
+// 402: they are bought through a payment intent or checkout session, whose
+// subscription activates only once the payment succeeds.
 func (s *Service) CreateSubscription(c *gin.Context) {
 	var req CreateSubscriptionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -67,6 +143,47 @@ func (s *Service) CreateSubscription(c *gin.Context) {
 		telemetry.RecordSubscriptionOperation("create", "validation_error")
 		return
 	}
+	subscriptionMetadata, err := metadata.Merge(nil, req.Metadata)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordSubscriptionOperation("create", "validation_error")
+		return
+	}
+
+	plan, err := s.getChargedPlan(c.Request.Context(), req.PlanID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Plan not found"})
+			telemetry.RecordSubscriptionOperation("create", "validation_error")
+			return
+		}
+		logrus.Errorf("Failed to get plan: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation("create", "db_error")
+		return
+	}
+	// A retry of a request that already succeeded gets the same subscription
+	if req.ExternalRef != "" && s.respondExistingRef(c, req) {
+		return
+	}
+
+	if !plan.Active {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Plan is not available"})
+		telemetry.RecordSubscriptionOperation("create", "validation_error")
+		return
+	}
+	if !plan.Free {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Paid plans are subscribed to through POST /payments/intents or a checkout session"})
+		telemetry.RecordSubscriptionOperation("create", "payment_required")
+		return
+	}
+
+	charge := s.resolveCharge(c.Request.Context(), plan, req.UserID)
+	if !charge.MatchesClient(req.Amount, req.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount or currency does not match the plan price", "charge": charge})
+		telemetry.RecordSubscriptionOperation("create", "price_mismatch")
+		return
+	}
 
 	// Check if user already has an active subscription
 	existing, err := s.GetActiveSubscriptionByUserID(c.Request.Context(), req.UserID)
@@ -83,23 +200,40 @@ func (s *Service) CreateSubscription(c *gin.Context) {
 		return
 	}
 
-	// Create subscription
+	// Free plans need no payment method and never lapse
+	now := time.Now()
 	subscription := &Subscription{
 		ID:            generateID(),
 		UserID:        req.UserID,
 		PlanID:        req.PlanID,
 		Status:        "active",
-		StartDate:     time.Now(),
-		EndDate:       time.Now().AddDate(0, 1, 0), // 1 month
-		AutoRenew:     req.AutoRenew,
+		StartDate:     now,
+		EndDate:       freePeriodEnd,
+		AutoRenew:     false,
 		PaymentMethod: req.PaymentMethod,
-		Amount:        req.Amount,
-		Currency:      req.Currency,
+		Amount:        charge.Total,
+		Currency:      charge.Currency,
+		Metadata:      subscriptionMetadata,
+		Version:       1,
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
 	}
+	if req.ExternalRef != "" {
+		subscription.ExternalRef = &req.ExternalRef
+	}
 
+	// The check above is only a fast path; oneActiveIndex settles races
 	if err := s.createSubscription(c.Request.Context(), subscription); err != nil {
+		// A concurrent request with the same ref got there first
+		if req.ExternalRef != "" && (errors.Is(err, ErrAlreadySubscribed) || errors.Is(err, errDuplicateExternalRef)) &&
+			s.respondExistingRef(c, req) {
+			return
+		}
+		if errors.Is(err, ErrAlreadySubscribed) {
+			c.JSON(http.StatusConflict, gin.H{"error": "User already has an active subscription"})
+			telemetry.RecordSubscriptionOperation("create", "conflict")
+			return
+		}
 		logrus.Errorf("Failed to create subscription: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		telemetry.RecordSubscriptionOperation("create", "db_error")
@@ -109,7 +243,8 @@ func (s *Service) CreateSubscription(c *gin.Context) {
 	// Cache the subscription
 	s.cacheSubscription(c.Request.Context(), subscription)
 
-	c.JSON(http.StatusCreated, subscription)
+	middleware.SetETag(c, subscription.Version)
+	c.JSON(http.StatusCreated, CreateSubscriptionResponse{Subscription: subscription, Charge: charge})
 	telemetry.RecordSubscriptionOperation("create", "success")
 }
 
@@ -120,16 +255,9 @@ func (s *Service) GetSubscription(c *gin.Context) {
 		return
 	}
 
-	// Try cache first
-	cached, err := s.getCachedSubscription(c.Request.Context(), id)
-	if err == nil && cached != nil {
-		c.JSON(http.StatusOK, cached)
-		telemetry.RecordSubscriptionOperation("get", "cache_hit")
-		return
-	}
-
-	// Get from database
-	subscription, err := s.getSubscriptionByID(c.Request.Context(), id)
+	subscription, hit, err := s.subscriptions.Get(c.Request.Context(), id, func(ctx context.Context) (*Subscription, error) {
+		return s.getSubscriptionByID(ctx, id)
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
@@ -142,11 +270,13 @@ func (s *Service) GetSubscription(c *gin.Context) {
 		return
 	}
 
-	// Cache the subscription
-	s.cacheSubscription(c.Request.Context(), subscription)
-
+	middleware.SetETag(c, subscription.Version)
 	c.JSON(http.StatusOK, subscription)
-	telemetry.RecordSubscriptionOperation("get", "success")
+	if hit {
+		telemetry.RecordSubscriptionOperation("get", "cache_hit")
+	} else {
+		telemetry.RecordSubscriptionOperation("get", "success")
+	}
 }
 
 func (s *Service) UpdateSubscription(c *gin.Context) {
@@ -156,6 +286,13 @@ func (s *Service) UpdateSubscription(c *gin.Context) {
 		return
 	}
 
+	expectedVersion, checkVersion, err := middleware.IfMatchVersion(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordSubscriptionOperation("update", "validation_error")
+		return
+	}
+
 	var req UpdateSubscriptionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -177,6 +314,11 @@ func (s *Service) UpdateSubscription(c *gin.Context) {
 		return
 	}
 
+	if checkVersion && expectedVersion != subscription.Version {
+		respondVersionConflict(c, "update", http.StatusPreconditionFailed, subscription.Version)
+		return
+	}
+
 	// Update fields
 	if req.Status != nil {
 		subscription.Status = *req.Status
@@ -193,11 +335,35 @@ func (s *Service) UpdateSubscription(c *gin.Context) {
 	if req.Currency != nil {
 		subscription.Currency = *req.Currency
 	}
+	if req.Metadata != nil {
+		merged, err := metadata.Merge(subscription.Metadata, req.Metadata)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			telemetry.RecordSubscriptionOperation("update", "validation_error")
+			return
+		}
+		subscription.Metadata = merged
+	}
+
+	if !money.IsValid(subscription.Amount, subscription.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Amount has more decimal places than %s allows", subscription.Currency)})
+		telemetry.RecordSubscriptionOperation("update", "validation_error")
+		return
+	}
 
 	subscription.UpdatedAt = time.Now()
 
 	// Update in database
 	if err := s.updateSubscription(c.Request.Context(), subscription); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			s.respondCurrentVersion(c, "update", id)
+			return
+		}
+		if errors.Is(err, ErrAlreadySubscribed) {
+			c.JSON(http.StatusConflict, gin.H{"error": "User already has an active subscription"})
+			telemetry.RecordSubscriptionOperation("update", "conflict")
+			return
+		}
 		logrus.Errorf("Failed to update subscription: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		telemetry.RecordSubscriptionOperation("update", "db_error")
@@ -207,6 +373,7 @@ func (s *Service) UpdateSubscription(c *gin.Context) {
 	// Update cache
 	s.cacheSubscription(c.Request.Context(), subscription)
 
+	middleware.SetETag(c, subscription.Version)
 	c.JSON(http.StatusOK, subscription)
 	telemetry.RecordSubscriptionOperation("update", "success")
 }
@@ -232,11 +399,29 @@ func (s *Service) CancelSubscription(c *gin.Context) {
 		return
 	}
 
+	// With ?offer=true, hold the cancellation back if a win-back offer
+	// applies. Cancelling again without it declines the offer.
+	if c.Query("offer") == "true" {
+		offer, err := s.presentRetentionOffer(c.Request.Context(), subscription)
+		if err != nil {
+			logrus.Errorf("Failed to find retention offer for subscription %s: %v", id, err)
+		}
+		if offer != nil {
+			c.JSON(http.StatusOK, CancellationOfferResponse{Subscription: subscription, Offer: *offer})
+			telemetry.RecordSubscriptionOperation("cancel", "offer_presented")
+			return
+		}
+	}
+
 	// Cancel subscription
 	subscription.Status = "cancelled"
 	subscription.UpdatedAt = time.Now()
 
 	if err := s.updateSubscription(c.Request.Context(), subscription); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			s.respondCurrentVersion(c, "cancel", id)
+			return
+		}
 		logrus.Errorf("Failed to cancel subscription: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		telemetry.RecordSubscriptionOperation("cancel", "db_error")
@@ -246,6 +431,10 @@ func (s *Service) CancelSubscription(c *gin.Context) {
 	// Update cache
 	s.cacheSubscription(c.Request.Context(), subscription)
 
+	s.declineRetentionOffers(c.Request.Context(), id)
+	s.downgradeToFree(c.Request.Context(), subscription)
+
+	middleware.SetETag(c, subscription.Version)
 	c.JSON(http.StatusOK, subscription)
 	telemetry.RecordSubscriptionOperation("cancel", "success")
 }
@@ -279,10 +468,14 @@ func (s *Service) RenewSubscription(c *gin.Context) {
 	}
 
 	// Extend subscription
-	subscription.EndDate = subscription.EndDate.AddDate(0, 1, 0) // Add 1 month
+	subscription.EndDate = subscription.NextPeriodEnd()
 	subscription.UpdatedAt = time.Now()
 
 	if err := s.updateSubscription(c.Request.Context(), subscription); err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			s.respondCurrentVersion(c, "renew", id)
+			return
+		}
 		logrus.Errorf("Failed to renew subscription: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		telemetry.RecordSubscriptionOperation("renew", "db_error")
@@ -292,97 +485,329 @@ func (s *Service) RenewSubscription(c *gin.Context) {
 	// Update cache
 	s.cacheSubscription(c.Request.Context(), subscription)
 
+	middleware.SetETag(c, subscription.Version)
 	c.JSON(http.StatusOK, subscription)
 	telemetry.RecordSubscriptionOperation("renew", "success")
 }
 
+// RenewalPreview shows the amount, tax and payment method the next renewal
+// will charge.
+func (s *Service) RenewalPreview(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subscription ID is required"})
+		return
+	}
+
+	subscription, err := s.getSubscriptionByID(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+			telemetry.RecordSubscriptionOperation("renewal_preview", "not_found")
+			return
+		}
+		logrus.Errorf("Failed to get subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation("renewal_preview", "db_error")
+		return
+	}
+
+	if subscription.Status != "active" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subscription is not active"})
+		telemetry.RecordSubscriptionOperation("renewal_preview", "invalid_status")
+		return
+	}
+
+	amount := subscription.Amount
+	if subscription.DiscountRenewals > 0 {
+		amount = discountedAmount(amount, subscription.Currency, subscription.DiscountPercent)
+	}
+	tax := taxOn(amount, subscription.Currency)
+
+	c.JSON(http.StatusOK, RenewalPreview{
+		SubscriptionID: subscription.ID,
+		RenewsAt:       subscription.EndDate,
+		AutoRenew:      subscription.AutoRenew,
+		Amount:         amount,
+		Tax:            tax,
+		Total:          amount.Add(tax),
+		Currency:       subscription.Currency,
+		PaymentMethod:  subscription.PaymentMethod,
+	})
+	telemetry.RecordSubscriptionOperation("renewal_preview", "success")
+}
+
+// ListSubscriptions returns subscriptions newest first using cursor pagination.
+// Optional filters: user_id, status.
+func (s *Service) ListSubscriptions(c *gin.Context) {
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+
+	cursor, err := db.ParseCursor(c.Query("cursor"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		telemetry.RecordSubscriptionOperation("list", "validation_error")
+		return
+	}
+
+	subscriptions, nextCursor, err := s.listSubscriptions(c.Request.Context(), c.Query("user_id"), c.Query("status"), cursor, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list subscriptions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation("list", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, SubscriptionListResponse{
+		Subscriptions: subscriptions,
+		Limit:         limit,
+		NextCursor:    nextCursor,
+	})
+	telemetry.RecordSubscriptionOperation("list", "success")
+}
+
 // Helper methods
 func (s *Service) createSubscription(ctx context.Context, sub *Subscription) error {
+	return insertSubscription(ctx, s.db, sub)
+}
+
+// execer is satisfied by both the connection and a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertSubscription stores sub with a snapshot of its plan taken in the
+// same statement
+func insertSubscription(ctx context.Context, exec execer, sub *Subscription) error {
 	query := `
 		INSERT INTO subscriptions (id, user_id, plan_id, status, start_date, end_date, 
-			auto_renew, payment_method, amount, currency, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			auto_renew, payment_method, amount, currency, external_ref, metadata, created_at,
+			updated_at, discount_percent, discount_renewals, plan_snapshot)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16,
+			(SELECT ` + planSnapshotSQL + ` FROM plans p WHERE p.id = $3))
+		RETURNING plan_snapshot
 	`
-	_, err := s.db.ExecContext(ctx, query, sub.ID, sub.UserID, sub.PlanID, sub.Status,
+	encoded, err := metadata.Encode(sub.Metadata)
+	if err != nil {
+		return err
+	}
+	var snapshot []byte
+	err = exec.QueryRowContext(ctx, query, sub.ID, sub.UserID, sub.PlanID, sub.Status,
 		sub.StartDate, sub.EndDate, sub.AutoRenew, sub.PaymentMethod, sub.Amount,
-		sub.Currency, sub.CreatedAt, sub.UpdatedAt)
+		sub.Currency, sub.ExternalRef, encoded, sub.CreatedAt, sub.UpdatedAt,
+		sub.DiscountPercent, sub.DiscountRenewals).Scan(&snapshot)
+	if err == nil {
+		sub.PlanSnapshot, err = decodePlanSnapshot(snapshot)
+	}
+	if db.IsUniqueViolation(err, oneActiveIndex) {
+		return ErrAlreadySubscribed
+	}
+	if db.IsUniqueViolation(err, externalRefIndex) {
+		return errDuplicateExternalRef
+	}
 	return err
 }
 
+// GetSubscriptionByID reads a subscription from the database, bypassing the
+// cache. It returns sql.ErrNoRows if there is none.
+func (s *Service) GetSubscriptionByID(ctx context.Context, id string) (*Subscription, error) {
+	return s.getSubscriptionByID(ctx, id)
+}
+
+const subscriptionColumns = `id, user_id, plan_id, status, start_date, end_date, auto_renew,
+	payment_method, amount, currency, external_ref, version, created_at, updated_at,
+	plan_snapshot, metadata, discount_percent, discount_renewals, paused_from`
+
 func (s *Service) getSubscriptionByID(ctx context.Context, id string) (*Subscription, error) {
 	query := `
-		SELECT id, user_id, plan_id, status, start_date, end_date, auto_renew,
-			payment_method, amount, currency, created_at, updated_at
+		SELECT ` + subscriptionColumns + `
 		FROM subscriptions WHERE id = $1
 	`
+	return scanSubscription(s.db.QueryRowNamed(ctx, "subscription_by_id", query, id).Scan)
+}
+
+// scanSubscription reads a row of subscriptionColumns
+func scanSubscription(scan func(dest ...interface{}) error) (*Subscription, error) {
 	var sub Subscription
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
+	var snapshot, data []byte
+	err := scan(
 		&sub.ID, &sub.UserID, &sub.PlanID, &sub.Status, &sub.StartDate, &sub.EndDate,
-		&sub.AutoRenew, &sub.PaymentMethod, &sub.Amount, &sub.Currency,
-		&sub.CreatedAt, &sub.UpdatedAt)
+		&sub.AutoRenew, &sub.PaymentMethod, &sub.Amount, &sub.Currency, &sub.ExternalRef,
+		&sub.Version, &sub.CreatedAt, &sub.UpdatedAt, &snapshot, &data,
+		&sub.DiscountPercent, &sub.DiscountRenewals, &sub.PausedFrom)
 	if err != nil {
 		return nil, err
 	}
+	if sub.PlanSnapshot, err = decodePlanSnapshot(snapshot); err != nil {
+		return nil, err
+	}
+	if sub.Metadata, err = metadata.Decode(data); err != nil {
+		return nil, err
+	}
 	return &sub, nil
 }
 
 func (s *Service) GetActiveSubscriptionByUserID(ctx context.Context, userID string) (*Subscription, error) {
 	query := `
 		SELECT id, user_id, plan_id, status, start_date, end_date, auto_renew,
-			payment_method, amount, currency, created_at, updated_at
+			payment_method, amount, currency, external_ref, version, created_at, updated_at
 		FROM subscriptions 
 		WHERE user_id = $1 AND status = 'active' AND end_date > NOW()
 		ORDER BY created_at DESC LIMIT 1
 	`
 	var sub Subscription
-	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+	err := s.db.QueryRowNamed(ctx, "active_subscription_by_user", query, userID).Scan(
 		&sub.ID, &sub.UserID, &sub.PlanID, &sub.Status, &sub.StartDate, &sub.EndDate,
-		&sub.AutoRenew, &sub.PaymentMethod, &sub.Amount, &sub.Currency,
-		&sub.CreatedAt, &sub.UpdatedAt)
+		&sub.AutoRenew, &sub.PaymentMethod, &sub.Amount, &sub.Currency, &sub.ExternalRef,
+		&sub.Version, &sub.CreatedAt, &sub.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &sub, nil
 }
 
+func (s *Service) listSubscriptions(ctx context.Context, userID, status string, cursor *db.Cursor, limit int) ([]Subscription, string, error) {
+	query := `
+		SELECT id, user_id, plan_id, status, start_date, end_date, auto_renew,
+			payment_method, amount, currency, external_ref, version, created_at, updated_at,
+			metadata
+		FROM subscriptions
+		WHERE ($1 = '' OR user_id::text = $1)
+			AND ($2 = '' OR status = $2)
+			AND ($3::timestamptz IS NULL OR (created_at, id::text) < ($3, $4))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5
+	`
+
+	after, afterID := cursor.After()
+
+	// Fetch one extra row to know whether another page exists
+	rows, err := s.db.Reader().QueryContext(ctx, query, userID, status, after, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		var sub Subscription
+		var data []byte
+		if err := rows.Scan(
+			&sub.ID, &sub.UserID, &sub.PlanID, &sub.Status, &sub.StartDate, &sub.EndDate,
+			&sub.AutoRenew, &sub.PaymentMethod, &sub.Amount, &sub.Currency, &sub.ExternalRef,
+			&sub.Version, &sub.CreatedAt, &sub.UpdatedAt, &data); err != nil {
+			return nil, "", err
+		}
+		if sub.Metadata, err = metadata.Decode(data); err != nil {
+			return nil, "", err
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	subscriptions, nextCursor := db.NextPage(subscriptions, limit, func(row Subscription) db.Cursor {
+		return db.Cursor{CreatedAt: row.CreatedAt, ID: row.ID}
+	})
+
+	return subscriptions, nextCursor, nil
+}
+
+// updateSubscription writes sub if its version is still current and bumps
+// the version, returning ErrVersionConflict if another writer got there first.
 func (s *Service) updateSubscription(ctx context.Context, sub *Subscription) error {
 	query := `
 		UPDATE subscriptions 
 		SET status = $1, start_date = $2, end_date = $3, auto_renew = $4,
-			payment_method = $5, amount = $6, currency = $7, updated_at = $8
-		WHERE id = $9
+			payment_method = $5, amount = $6, currency = $7, metadata = $8, updated_at = $9,
+			version = version + 1
+		WHERE id = $10 AND version = $11
 	`
-	_, err := s.db.ExecContext(ctx, query, sub.Status, sub.StartDate, sub.EndDate,
-		sub.AutoRenew, sub.PaymentMethod, sub.Amount, sub.Currency, sub.UpdatedAt, sub.ID)
-	return err
+	encoded, err := metadata.Encode(sub.Metadata)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx, query, sub.Status, sub.StartDate, sub.EndDate,
+		sub.AutoRenew, sub.PaymentMethod, sub.Amount, sub.Currency, encoded, sub.UpdatedAt,
+		sub.ID, sub.Version)
+	if db.IsUniqueViolation(err, oneActiveIndex) {
+		return ErrAlreadySubscribed
+	}
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVersionConflict
+	}
+	sub.Version++
+	return nil
 }
 
-func (s *Service) cacheSubscription(ctx context.Context, sub *Subscription) {
-	key := fmt.Sprintf("subscription:%s", sub.ID)
-	data, err := json.Marshal(sub)
+// respondCurrentVersion answers a write that lost a compare-and-set race with
+// 409 and the version now stored.
+func (s *Service) respondCurrentVersion(c *gin.Context, op, id string) {
+	current, err := s.getSubscriptionByID(c.Request.Context(), id)
 	if err != nil {
-		logrus.Errorf("Failed to marshal subscription for cache: %v", err)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+			telemetry.RecordSubscriptionOperation(op, "not_found")
+			return
+		}
+		logrus.Errorf("Failed to get subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordSubscriptionOperation(op, "db_error")
 		return
 	}
+	s.subscriptions.Forget(c.Request.Context(), id)
+	respondVersionConflict(c, op, http.StatusConflict, current.Version)
+}
 
-	// Cache for 1 hour
-	if err := s.cache.Set(ctx, key, string(data), time.Hour); err != nil {
-		logrus.Errorf("Failed to cache subscription: %v", err)
-	}
+func respondVersionConflict(c *gin.Context, op string, status, currentVersion int) {
+	middleware.SetETag(c, currentVersion)
+	c.JSON(status, gin.H{
+		"error":           "Subscription was modified by another request",
+		"current_version": currentVersion,
+	})
+	telemetry.RecordSubscriptionOperation(op, "conflict")
 }
 
-func (s *Service) getCachedSubscription(ctx context.Context, id string) (*Subscription, error) {
-	key := fmt.Sprintf("subscription:%s", id)
-	data, err := s.cache.Get(ctx, key)
-	if err != nil {
-		return nil, err
-	}
+const (
+	// subscriptionCacheTTL is how long a subscription read by ID stays
+	// cached; writes refresh or drop it
+	subscriptionCacheTTL = time.Hour
+	// missingSubscriptionTTL is how long an unknown subscription ID is
+	// remembered
+	missingSubscriptionTTL = time.Minute
+)
 
-	var sub Subscription
-	if err := json.Unmarshal([]byte(data), &sub); err != nil {
-		return nil, err
+// newSubscriptionCache caches subscriptions by ID. Not every write caches
+// the plan snapshot; those entries are refreshed from the database.
+func newSubscriptionCache(redis *cache.RedisClient) *cache.ReadThrough[*Subscription] {
+	return cache.NewReadThrough(redis, cache.ReadThroughOptions[*Subscription]{
+		Domain:      "subscription",
+		Prefix:      "subscription",
+		TTL:         subscriptionCacheTTL,
+		NegativeTTL: missingSubscriptionTTL,
+		Valid:       func(sub *Subscription) bool { return sub.PlanSnapshot != nil },
+	})
+}
+
+func (s *Service) cacheSubscription(ctx context.Context, sub *Subscription) {
+	s.subscriptions.Set(ctx, sub.ID, sub)
+	s.invalidateEntitlement(ctx, sub.UserID)
+}
+
+// recordConversion credits a new paid subscription to the price experiment
+// variants its user was shown. Failures are logged only.
+func (s *Service) recordConversion(ctx context.Context, sub *Subscription) {
+	if err := s.experiments.RecordConversion(ctx, sub.UserID, sub.PlanID, sub.ID, sub.Amount, sub.Currency); err != nil {
+		logrus.Errorf("Failed to record experiment conversion for subscription %s: %v", sub.ID, err)
 	}
-	return &sub, nil
 }
 
 func generateID() string {
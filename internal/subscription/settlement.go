@@ -0,0 +1,115 @@
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"scalable-paywall/internal/db"
+)
+
+// HoldRenewal records a renewal charge the gateway accepted but has not
+// settled yet, e.g. a bank debit, and releases the claim. With grantAccess
+// the subscription is active until periodEnd right away and falls back to
+// its current end date if the charge fails; otherwise it keeps its current
+// end date until the charge settles. Either way it is not claimed again
+// while the charge is pending.
+func (s *Service) HoldRenewal(ctx context.Context, id, chargeID string, periodEnd time.Time, grantAccess bool) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET pending_charge_id = $2, pending_period_end = $3, pending_prior_end = end_date,
+			end_date = CASE WHEN $4::boolean THEN $3 ELSE end_date END,
+			status = CASE WHEN $4::boolean THEN 'active' ELSE status END,
+			claimed_until = NULL, updated_at = NOW(), version = version + 1
+		WHERE id = $1
+	`, id, chargeID, periodEnd, grantAccess)
+	if err != nil {
+		return err
+	}
+	s.subscriptions.Forget(ctx, id)
+	return nil
+}
+
+// SettleRenewal completes the renewal paid by the pending charge chargeID,
+// as CompleteRenewal would have when the charge was made. A subscription
+// that lapsed while the charge settled is active again; one cancelled or
+// suspended meanwhile only loses the pending charge. It returns false if no
+// subscription waits on the charge, and ErrAlreadySubscribed if the user
+// has since taken out another subscription.
+func (s *Service) SettleRenewal(ctx context.Context, chargeID string) (bool, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE subscriptions
+		SET status = CASE WHEN status IN ('active', 'past_due', 'expired') THEN 'active' ELSE status END,
+			end_date = pending_period_end, renewal_attempts = 0, next_retry_at = NULL,
+			paused_from = NULL,
+			discount_percent = CASE WHEN discount_renewals > 1 THEN discount_percent ELSE 0 END,
+			discount_renewals = GREATEST(discount_renewals - 1, 0),
+			pending_charge_id = NULL, pending_period_end = NULL, pending_prior_end = NULL,
+			updated_at = NOW(), version = version + 1
+		WHERE pending_charge_id = $1
+		RETURNING id
+	`, chargeID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if db.IsUniqueViolation(err, oneActiveIndex) {
+		return false, ErrAlreadySubscribed
+	}
+	if err != nil {
+		return false, err
+	}
+	s.subscriptions.Forget(ctx, id)
+	return true, nil
+}
+
+// FailPendingRenewal undoes the renewal paid by the pending charge chargeID
+// after the charge failed: the subscription goes back to its previous end
+// date and into past_due, where dunning retries the charge as for any
+// failed payment. It returns the subscription's ID, or "" if none waits on
+// the charge.
+func (s *Service) FailPendingRenewal(ctx context.Context, chargeID string) (string, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE subscriptions
+		SET status = CASE WHEN status IN ('active', 'past_due') THEN 'past_due' ELSE status END,
+			end_date = pending_prior_end,
+			pending_charge_id = NULL, pending_period_end = NULL, pending_prior_end = NULL,
+			updated_at = NOW(), version = version + 1
+		WHERE pending_charge_id = $1
+		RETURNING id
+	`, chargeID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	s.subscriptions.Forget(ctx, id)
+	return id, nil
+}
+
+// RevokeUnpaid cancels a subscription that was activated before its first
+// payment settled once that payment fails, downgrading the user to the free
+// plan if configured. It is a no-op unless the subscription is active or
+// past_due.
+func (s *Service) RevokeUnpaid(ctx context.Context, id string) error {
+	var userID string
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE subscriptions
+		SET status = 'cancelled', end_date = LEAST(end_date, NOW()), updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND status IN ('active', 'past_due')
+		RETURNING user_id
+	`, id).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.subscriptions.Forget(ctx, id)
+	if s.cfg != nil && s.cfg.DowngradeToFree {
+		s.enrollFree(ctx, userID)
+	}
+	return nil
+}
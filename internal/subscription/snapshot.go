@@ -0,0 +1,50 @@
+package subscription
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PlanSnapshot is the plan as it was when the subscription was bought.
+// Entitlements come from it rather than the live plan, so editing a plan
+// doesn't change what existing subscribers paid for. Features are the
+// plan's effective features, those it inherits included.
+type PlanSnapshot struct {
+	Name             string                 `json:"name"`
+	Price            decimal.Decimal        `json:"price"`
+	Currency         string                 `json:"currency"`
+	BillingCycle     string                 `json:"billing_cycle"`
+	BillingInterval  int                    `json:"billing_interval"`
+	BillingAnchorDay *int                   `json:"billing_anchor_day"`
+	Type             string                 `json:"type"`
+	Features         map[string]interface{} `json:"features"`
+	MaxUsagePerDay   *int                   `json:"max_usage_per_day"`
+	MaxUsagePerMonth *int                   `json:"max_usage_per_month"`
+	GracePeriodDays  int                    `json:"grace_period_days"`
+	RolloverCap      *int                   `json:"usage_rollover_cap"`
+	OveragePercent   int                    `json:"usage_overage_percent"`
+	CapturedAt       time.Time              `json:"captured_at"`
+}
+
+// planSnapshotSQL builds a plan_snapshot from the plans row aliased p
+const planSnapshotSQL = `jsonb_build_object(
+	'name', p.name, 'price', p.price, 'currency', p.currency, 'billing_cycle', p.billing_cycle,
+	'billing_interval', p.billing_interval, 'billing_anchor_day', p.billing_anchor_day,
+	'type', p.plan_type, 'features', plan_effective_features(p.id),
+	'max_usage_per_day', p.max_usage_per_day, 'max_usage_per_month', p.max_usage_per_month,
+	'grace_period_days', p.grace_period_days,
+	'usage_rollover_cap', p.usage_rollover_cap, 'usage_overage_percent', p.usage_overage_percent,
+	'captured_at', NOW())`
+
+func decodePlanSnapshot(data []byte) (*PlanSnapshot, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var snapshot PlanSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
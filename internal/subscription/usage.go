@@ -0,0 +1,41 @@
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// RecordUsage appends an allowed paywall action to the usage_logs ledger,
+// which plan analytics aggregate. subscriptionID may be empty.
+func (s *Service) RecordUsage(ctx context.Context, userID, subscriptionID, action, contentID string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO usage_logs (user_id, subscription_id, action, content_id)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4)
+	`, userID, subscriptionID, action, contentID)
+	return err
+}
+
+// RecordFeatureUsage appends amount uses of a metered plan feature to the
+// usage ledger
+func (s *Service) RecordFeatureUsage(ctx context.Context, userID, subscriptionID, feature string, amount int) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO usage_logs (user_id, subscription_id, action, metadata)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, jsonb_build_object('amount', $4::int))
+	`, userID, subscriptionID, feature, amount)
+	return err
+}
+
+// RecordUsageAdjustment appends a quota change made by support (a reset or
+// a boost) to the usage ledger. Adjustments are kept apart from usage so
+// they don't count towards plan analytics.
+func (s *Service) RecordUsageAdjustment(ctx context.Context, userID, subscriptionID, action string, details map[string]interface{}) error {
+	metadata, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO usage_logs (user_id, subscription_id, action, metadata, kind)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, 'adjustment')
+	`, userID, subscriptionID, action, string(metadata))
+	return err
+}
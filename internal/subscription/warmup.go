@@ -0,0 +1,76 @@
+package subscription
+
+import (
+	"context"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+)
+
+// RegisterWarmers has cache warmups preload the cfg.RecentSubscriptions
+// subscriptions used most recently within the last cfg.RecentWindow hours,
+// those the paywall and account pages are about to read.
+func (s *Service) RegisterWarmers(cfg config.WarmupConfig) {
+	if cfg.RecentSubscriptions <= 0 {
+		return
+	}
+	window := time.Duration(cfg.RecentWindow) * time.Hour
+	s.cache.RegisterWarmer("subscriptions.recent", func(ctx context.Context) (int, error) {
+		return s.warmRecentSubscriptions(ctx, window, cfg.RecentSubscriptions)
+	})
+}
+
+func (s *Service) warmRecentSubscriptions(ctx context.Context, window time.Duration, limit int) (int, error) {
+	ids, err := s.recentlyUsedSubscriptions(ctx, time.Now().Add(-window), limit)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	// Read from the primary, as they are cached for an hour
+	rows, err := s.db.QueryContext(db.WithQueryName(ctx, "warm_subscriptions"), `
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions WHERE id::text = ANY($1)
+	`, ids)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	warmed := 0
+	for rows.Next() {
+		sub, err := scanSubscription(rows.Scan)
+		if err != nil {
+			return warmed, err
+		}
+		s.subscriptions.Set(ctx, sub.ID, sub)
+		warmed++
+	}
+	return warmed, rows.Err()
+}
+
+// recentlyUsedSubscriptions returns the IDs of up to limit subscriptions
+// with usage since, most recently used first. It reads a replica.
+func (s *Service) recentlyUsedSubscriptions(ctx context.Context, since time.Time, limit int) ([]string, error) {
+	rows, err := s.db.Reader().QueryContext(db.WithQueryName(ctx, "recently_used_subscriptions"), `
+		SELECT subscription_id FROM usage_logs
+		WHERE created_at > $1 AND subscription_id IS NOT NULL AND kind = 'usage'
+		GROUP BY subscription_id
+		ORDER BY MAX(created_at) DESC
+		LIMIT $2
+	`, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
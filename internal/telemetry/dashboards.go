@@ -0,0 +1,176 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Grafana dashboard model, as much of it as the RED dashboards use
+type (
+	dashboard struct {
+		UID           string     `json:"uid"`
+		Title         string     `json:"title"`
+		Tags          []string   `json:"tags"`
+		SchemaVersion int        `json:"schemaVersion"`
+		Refresh       string     `json:"refresh"`
+		Time          timeRange  `json:"time"`
+		Templating    templating `json:"templating"`
+		Panels        []panel    `json:"panels"`
+	}
+
+	timeRange struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	templating struct {
+		List []variable `json:"list"`
+	}
+
+	variable struct {
+		Name  string `json:"name"`
+		Label string `json:"label"`
+		Type  string `json:"type"`
+		Query string `json:"query"`
+	}
+
+	panel struct {
+		ID          int         `json:"id"`
+		Type        string      `json:"type"`
+		Title       string      `json:"title"`
+		Description string      `json:"description"`
+		Datasource  datasource  `json:"datasource"`
+		GridPos     gridPos     `json:"gridPos"`
+		FieldConfig fieldConfig `json:"fieldConfig"`
+		Targets     []target    `json:"targets"`
+	}
+
+	datasource struct {
+		Type string `json:"type"`
+		UID  string `json:"uid"`
+	}
+
+	gridPos struct {
+		H int `json:"h"`
+		W int `json:"w"`
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+
+	fieldConfig struct {
+		Defaults fieldDefaults `json:"defaults"`
+	}
+
+	fieldDefaults struct {
+		Unit string `json:"unit"`
+	}
+
+	target struct {
+		RefID        string     `json:"refId"`
+		Datasource   datasource `json:"datasource"`
+		Expr         string     `json:"expr"`
+		LegendFormat string     `json:"legendFormat"`
+		Exemplar     bool       `json:"exemplar"`
+	}
+)
+
+// prometheus is the dashboards' data source, picked when they're opened
+var prometheus = datasource{Type: "prometheus", UID: "${datasource}"}
+
+// Dashboard returns the Grafana dashboard JSON of domain: request rate,
+// error ratio and latency quantiles (with trace exemplars) per operation
+// from domain_operation_duration_seconds, and the domain's operation
+// counter. cmd/dashboards writes them to deploy/grafana/dashboards.
+func Dashboard(domain string) ([]byte, error) {
+	for _, m := range domainMetrics {
+		if m.Domain == domain {
+			return json.MarshalIndent(m.dashboard(), "", "  ")
+		}
+	}
+	return nil, fmt.Errorf("unknown metrics domain %q", domain)
+}
+
+func (m domainMetric) dashboard() dashboard {
+	selector := fmt.Sprintf(`domain=%q`, m.Domain)
+	rate := func(suffix, extra string) string {
+		return fmt.Sprintf(`rate(%s_%s{%s%s}[$__rate_interval])`, DomainDurationMetric, suffix, selector, extra)
+	}
+	quantile := func(q float64) target {
+		return target{
+			Expr:         fmt.Sprintf(`histogram_quantile(%g, sum by (le, operation) (%s))`, q, rate("bucket", "")),
+			LegendFormat: fmt.Sprintf("p%g {{operation}}", q*100),
+			Exemplar:     true,
+		}
+	}
+
+	type spec struct {
+		Title, Description, Unit string
+		Targets                  []target
+	}
+	specs := []spec{
+		{
+			Title:       "Rate",
+			Description: "Requests per second by operation",
+			Unit:        "reqps",
+			Targets: []target{{
+				Expr:         fmt.Sprintf(`sum by (operation) (%s)`, rate("count", "")),
+				LegendFormat: "{{operation}}",
+			}},
+		},
+		{
+			Title:       "Errors",
+			Description: "Share of requests answered with a 5xx status, by operation",
+			Unit:        "percentunit",
+			Targets: []target{{
+				Expr: fmt.Sprintf(`sum by (operation) (%s) / sum by (operation) (%s)`,
+					rate("count", `,status="5xx"`), rate("count", "")),
+				LegendFormat: "{{operation}}",
+			}},
+		},
+		{
+			Title:       "Duration",
+			Description: "Latency quantiles by operation; exemplars link to the traces of sampled requests",
+			Unit:        "s",
+			Targets:     []target{quantile(0.5), quantile(0.95), quantile(0.99)},
+		},
+		{
+			Title:       "Operations",
+			Description: fmt.Sprintf("%s per second by %s", m.Counter, m.CounterLabel),
+			Unit:        "ops",
+			Targets: []target{{
+				Expr:         fmt.Sprintf(`sum by (%s) (rate(%s[$__rate_interval]))`, m.CounterLabel, m.Counter),
+				LegendFormat: fmt.Sprintf("{{%s}}", m.CounterLabel),
+			}},
+		},
+	}
+
+	d := dashboard{
+		UID:           "paywall-red-" + m.Domain,
+		Title:         m.Title + " (RED)",
+		Tags:          []string{"scalable-paywall", "red", m.Domain},
+		SchemaVersion: 38,
+		Refresh:       "30s",
+		Time:          timeRange{From: "now-6h", To: "now"},
+		Templating: templating{List: []variable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		}},
+	}
+	// Two panels a row, half the width each
+	for i, s := range specs {
+		for j := range s.Targets {
+			s.Targets[j].RefID = string(rune('A' + j))
+			s.Targets[j].Datasource = prometheus
+		}
+		d.Panels = append(d.Panels, panel{
+			ID:          i + 1,
+			Type:        "timeseries",
+			Title:       s.Title,
+			Description: s.Description,
+			Datasource:  prometheus,
+			GridPos:     gridPos{H: 8, W: 12, X: 12 * (i % 2), Y: 8 * (i / 2)},
+			FieldConfig: fieldConfig{Defaults: fieldDefaults{Unit: s.Unit}},
+			Targets:     s.Targets,
+		})
+	}
+	return d
+}
@@ -0,0 +1,100 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	prometheusClient "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Domains whose operations are timed in domain_operation_duration_seconds
+const (
+	DomainPlan         = "plan"
+	DomainSubscription = "subscription"
+	DomainPaywall      = "paywall"
+	DomainPayment      = "payment"
+)
+
+// domainMetric describes a domain for its RED dashboard: the operation
+// counter kept alongside its latencies and the label splitting it
+type domainMetric struct {
+	Domain       string
+	Title        string
+	Counter      string
+	CounterLabel string
+}
+
+// domainMetrics are the domains with a latency histogram and dashboard
+var domainMetrics = []domainMetric{
+	{Domain: DomainPlan, Title: "Plans", Counter: "plan_operations_total", CounterLabel: "operation"},
+	{Domain: DomainSubscription, Title: "Subscriptions", Counter: "subscription_operations_total", CounterLabel: "operation"},
+	{Domain: DomainPaywall, Title: "Paywall", Counter: "paywall_checks_total", CounterLabel: "result"},
+	{Domain: DomainPayment, Title: "Payments", Counter: "payment_operations_total", CounterLabel: "operation"},
+}
+
+// Domains returns the domains timed in domain_operation_duration_seconds
+func Domains() []string {
+	domains := make([]string, len(domainMetrics))
+	for i, m := range domainMetrics {
+		domains[i] = m.Domain
+	}
+	return domains
+}
+
+// DomainDurationMetric is the histogram DomainMiddleware records into, by
+// domain, operation and status
+const DomainDurationMetric = "domain_operation_duration_seconds"
+
+// exemplarLabel names the trace ID on exemplars, as Grafana links them
+const exemplarLabel = "trace_id"
+
+var domainDuration = prometheusClient.NewHistogramVec(
+	prometheusClient.HistogramOpts{
+		Name:    DomainDurationMetric,
+		Help:    "Operation duration in seconds by domain, operation and status class (2xx, 4xx or 5xx), with trace ID exemplars",
+		Buckets: prometheusClient.ExponentialBuckets(0.001, 2, 15),
+	},
+	[]string{"domain", "operation", "status"},
+)
+
+func init() {
+	prometheusClient.MustRegister(domainDuration)
+}
+
+// DomainMiddleware times the requests of a route group belonging to domain,
+// by route and status class, attaching the trace ID of sampled requests as
+// an exemplar. Mount it after TracingMiddleware so the span is known.
+func DomainMiddleware(domain string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		operation := c.FullPath()
+		if operation == "" {
+			operation = "unmatched"
+		}
+		status := fmt.Sprintf("%dxx", c.Writer.Status()/100)
+		ObserveDomainOperation(c.Request.Context(), domain, operation, status, time.Since(start))
+	}
+}
+
+// ObserveDomainOperation records an operation's latency in domain, with the
+// trace ID carried by ctx as an exemplar when the trace is sampled.
+func ObserveDomainOperation(ctx context.Context, domain, operation, status string, duration time.Duration) {
+	observeWithExemplar(ctx, domainDuration.WithLabelValues(domain, operation, status), duration.Seconds())
+}
+
+// observeWithExemplar observes value, linking the sampled trace carried by
+// ctx. Unsampled traces aren't exported, so they would be dead links.
+func observeWithExemplar(ctx context.Context, observer prometheusClient.Observer, value float64) {
+	span := trace.SpanContextFromContext(ctx)
+	exemplar, ok := observer.(prometheusClient.ExemplarObserver)
+	if !ok || !span.IsSampled() {
+		observer.Observe(value)
+		return
+	}
+	exemplar.ObserveWithExemplar(value, prometheusClient.Labels{exemplarLabel: span.TraceID().String()})
+}
@@ -0,0 +1,66 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	prometheusClient "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The packaged dashboards must be regenerated with cmd/dashboards when the
+// metric definitions change
+func TestPackagedDashboardsUpToDate(t *testing.T) {
+	for _, domain := range Domains() {
+		want, err := Dashboard(domain)
+		require.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join("..", "..", "deploy", "grafana", "dashboards", domain+".json"))
+		require.NoError(t, err)
+		assert.JSONEq(t, string(want), string(got), "%s.json is stale; run go run ./cmd/dashboards", domain)
+	}
+
+	_, err := Dashboard("billing")
+	assert.Error(t, err)
+}
+
+func TestObserveDomainOperationExemplar(t *testing.T) {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	sampled := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled,
+	}))
+
+	ObserveDomainOperation(sampled, DomainPaywall, "/test/sampled", "2xx", 3*time.Millisecond)
+	ObserveDomainOperation(context.Background(), DomainPaywall, "/test/untraced", "5xx", 3*time.Millisecond)
+
+	families, err := prometheusClient.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	exemplars := map[string]string{}
+	for _, family := range families {
+		if family.GetName() != DomainDurationMetric {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			var operation string
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "operation" {
+					operation = label.GetValue()
+				}
+			}
+			for _, bucket := range m.GetHistogram().GetBucket() {
+				if e := bucket.GetExemplar(); e != nil {
+					exemplars[operation] = e.GetLabel()[0].GetValue()
+				}
+			}
+		}
+	}
+	assert.Equal(t, traceID.String(), exemplars["/test/sampled"])
+	assert.NotContains(t, exemplars, "/test/untraced")
+}
@@ -0,0 +1,116 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	prometheusClient "github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolStats is a snapshot of one connection pool. Waits and timeouts are
+// totals since the pool was opened.
+type PoolStats struct {
+	Pool         string  `json:"pool"`
+	Kind         string  `json:"kind"`
+	MaxOpen      int     `json:"max_open"`
+	Open         int     `json:"open"`
+	InUse        int     `json:"in_use"`
+	Idle         int     `json:"idle"`
+	WaitCount    int64   `json:"wait_count"`
+	WaitDuration float64 `json:"wait_duration_seconds"`
+	Timeouts     int64   `json:"timeouts"`
+}
+
+// PoolSource reports the pools it owns; *db.Connection reports its primary
+// and replicas, *cache.RedisClient its Redis pool.
+type PoolSource interface {
+	PoolStats() []PoolStats
+}
+
+var (
+	poolConnections = prometheusClient.NewGaugeVec(
+		prometheusClient.GaugeOpts{
+			Name: "pool_connections",
+			Help: "Connections of a database or Redis pool by state (max_open, open, in_use, idle)",
+		},
+		[]string{"pool", "kind", "state"},
+	)
+
+	poolWaits = prometheusClient.NewGaugeVec(
+		prometheusClient.GaugeOpts{
+			Name: "pool_wait_count",
+			Help: "Total number of times a caller waited for a pooled connection",
+		},
+		[]string{"pool", "kind"},
+	)
+
+	poolWaitDuration = prometheusClient.NewGaugeVec(
+		prometheusClient.GaugeOpts{
+			Name: "pool_wait_duration_seconds",
+			Help: "Total time callers spent waiting for a pooled connection",
+		},
+		[]string{"pool", "kind"},
+	)
+
+	poolTimeouts = prometheusClient.NewGaugeVec(
+		prometheusClient.GaugeOpts{
+			Name: "pool_timeouts",
+			Help: "Total number of times a caller gave up waiting for a pooled connection",
+		},
+		[]string{"pool", "kind"},
+	)
+)
+
+func init() {
+	prometheusClient.MustRegister(poolConnections)
+	prometheusClient.MustRegister(poolWaits)
+	prometheusClient.MustRegister(poolWaitDuration)
+	prometheusClient.MustRegister(poolTimeouts)
+}
+
+// RunPoolSampler exports the stats of sources' pools every interval until
+// ctx is done.
+func RunPoolSampler(ctx context.Context, interval time.Duration, sources ...PoolSource) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		setPoolStats(collectPoolStats(sources))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PoolsHandler serves GET /debug/pools: the current stats of sources' pools,
+// read at request time rather than from the last sample.
+func PoolsHandler(sources ...PoolSource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"pools": collectPoolStats(sources)})
+	}
+}
+
+func collectPoolStats(sources []PoolSource) []PoolStats {
+	var stats []PoolStats
+	for _, source := range sources {
+		stats = append(stats, source.PoolStats()...)
+	}
+	return stats
+}
+
+func setPoolStats(stats []PoolStats) {
+	for _, s := range stats {
+		poolConnections.WithLabelValues(s.Pool, s.Kind, "max_open").Set(float64(s.MaxOpen))
+		poolConnections.WithLabelValues(s.Pool, s.Kind, "open").Set(float64(s.Open))
+		poolConnections.WithLabelValues(s.Pool, s.Kind, "in_use").Set(float64(s.InUse))
+		poolConnections.WithLabelValues(s.Pool, s.Kind, "idle").Set(float64(s.Idle))
+		poolWaits.WithLabelValues(s.Pool, s.Kind).Set(float64(s.WaitCount))
+		poolWaitDuration.WithLabelValues(s.Pool, s.Kind).Set(s.WaitDuration)
+		poolTimeouts.WithLabelValues(s.Pool, s.Kind).Set(float64(s.Timeouts))
+	}
+}
@@ -2,18 +2,22 @@ package telemetry
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"strconv"
 	"time"
 
 	"scalable-paywall/internal/config"
 
 	"github.com/gin-gonic/gin"
 	prometheusClient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
 	otelPrometheus "go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 )
 
@@ -51,6 +55,13 @@ var (
 		[]string{"result"},
 	)
 
+	paywallEventsDropped = prometheusClient.NewCounter(
+		prometheusClient.CounterOpts{
+			Name: "paywall_events_dropped_total",
+			Help: "Total number of paywall analytics events dropped because the buffer was full or a write failed",
+		},
+	)
+
 	paymentOperations = prometheusClient.NewCounterVec(
 		prometheusClient.CounterOpts{
 			Name: "payment_operations_total",
@@ -74,6 +85,201 @@ var (
 		},
 		[]string{"operation", "status"},
 	)
+
+	// Business metrics
+	activeSubscriptions = prometheusClient.NewGaugeVec(
+		prometheusClient.GaugeOpts{
+			Name: "active_subscriptions",
+			Help: "Number of active subscriptions by plan",
+		},
+		[]string{"plan_id"},
+	)
+
+	monthlyRecurringRevenue = prometheusClient.NewGaugeVec(
+		prometheusClient.GaugeOpts{
+			Name: "monthly_recurring_revenue",
+			Help: "Monthly recurring revenue of active subscriptions by currency",
+		},
+		[]string{"currency"},
+	)
+
+	trialConversions = prometheusClient.NewGaugeVec(
+		prometheusClient.GaugeOpts{
+			Name: "trial_conversions",
+			Help: "Number of subscriptions still active after their trial ended, by plan",
+		},
+		[]string{"plan_id"},
+	)
+
+	dunningEvents = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "dunning_events_total",
+			Help: "Total number of dunning events",
+		},
+		[]string{"event"},
+	)
+
+	retentionOffers = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "retention_offers_total",
+			Help: "Total number of win-back offers presented, accepted and declined, by offer",
+		},
+		[]string{"offer", "outcome"},
+	)
+
+	disputes = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "disputes_total",
+			Help: "Total number of payment disputes by plan and status (opened, won, lost)",
+		},
+		[]string{"plan_id", "status"},
+	)
+
+	disputeRate = prometheusClient.NewGaugeVec(
+		prometheusClient.GaugeOpts{
+			Name: "dispute_rate",
+			Help: "Disputes opened over the last 30 days per completed payment, by plan",
+		},
+		[]string{"plan_id"},
+	)
+
+	experimentOperations = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "experiment_operations_total",
+			Help: "Total number of experiment operations",
+		},
+		[]string{"operation", "status"},
+	)
+
+	experimentEvents = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "experiment_events_total",
+			Help: "Total number of experiment exposures and conversions by experiment and variant",
+		},
+		[]string{"experiment", "variant", "event"},
+	)
+
+	segmentOperations = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "segment_operations_total",
+			Help: "Total number of customer segment operations",
+		},
+		[]string{"operation", "status"},
+	)
+
+	riskDecisions = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "risk_decisions_total",
+			Help: "Total number of payment risk decisions by check and decision",
+		},
+		[]string{"check", "decision"},
+	)
+
+	securityEvents = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "security_events_total",
+			Help: "Total number of security events, such as failed sign-ins and lockouts",
+		},
+		[]string{"event"},
+	)
+
+	sloBurnRate = prometheusClient.NewGaugeVec(
+		prometheusClient.GaugeOpts{
+			Name: "slo_burn_rate",
+			Help: "Error budget burn rate of an SLO objective's SLI over a window in seconds, as of the last evaluation",
+		},
+		[]string{"objective", "sli", "window"},
+	)
+
+	sloAlerts = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "slo_alerts_total",
+			Help: "Total number of SLO alerts sent by objective, severity and status (firing or resolved)",
+		},
+		[]string{"objective", "severity", "status"},
+	)
+
+	panics = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "http_panics_total",
+			Help: "Total number of requests whose handler panicked, by route",
+		},
+		[]string{"route"},
+	)
+
+	jobRuns = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "job_runs_total",
+			Help: "Total number of background job runs by kind and outcome",
+		},
+		[]string{"kind", "status"},
+	)
+
+	jobDuration = prometheusClient.NewHistogramVec(
+		prometheusClient.HistogramOpts{
+			Name:    "job_duration_seconds",
+			Help:    "Background job run duration in seconds",
+			Buckets: prometheusClient.DefBuckets,
+		},
+		[]string{"kind"},
+	)
+
+	cacheLookups = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "cache_lookups_total",
+			Help: "Total number of cache lookups by domain and result (hit or miss)",
+		},
+		[]string{"domain", "result"},
+	)
+
+	dbInterrupted = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "db_queries_interrupted_total",
+			Help: "Total number of database calls cut short, by operation and reason (statement_timeout, deadline or cancelled)",
+		},
+		[]string{"operation", "reason"},
+	)
+
+	dbQueryDuration = prometheusClient.NewHistogramVec(
+		prometheusClient.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Database query duration in seconds by operation and query name",
+			Buckets: prometheusClient.ExponentialBuckets(0.001, 2, 15),
+		},
+		[]string{"operation", "query"},
+	)
+
+	redisCommands = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "redis_commands_total",
+			Help: "Total number of Redis commands by command, key prefix and result (hit, miss, ok or error)",
+		},
+		[]string{"command", "prefix", "result"},
+	)
+
+	redisCommandDuration = prometheusClient.NewHistogramVec(
+		prometheusClient.HistogramOpts{
+			Name:    "redis_command_duration_seconds",
+			Help:    "Redis command duration in seconds by command and key prefix",
+			Buckets: prometheusClient.ExponentialBuckets(0.0005, 2, 12),
+		},
+		[]string{"command", "prefix"},
+	)
+
+	redisDegraded = prometheusClient.NewGauge(
+		prometheusClient.GaugeOpts{
+			Name: "redis_degraded",
+			Help: "1 while Redis is marked unreachable and commands fail without reaching it, 0 otherwise",
+		},
+	)
+
+	cacheWarmed = prometheusClient.NewCounterVec(
+		prometheusClient.CounterOpts{
+			Name: "cache_warmup_entries_total",
+			Help: "Total number of values preloaded into the cache by warmer",
+		},
+		[]string{"warmer"},
+	)
 )
 
 func init() {
@@ -81,13 +287,39 @@ func init() {
 	prometheusClient.MustRegister(httpRequestDuration)
 	prometheusClient.MustRegister(subscriptionOperations)
 	prometheusClient.MustRegister(paywallChecks)
+	prometheusClient.MustRegister(paywallEventsDropped)
 	prometheusClient.MustRegister(paymentOperations)
 	prometheusClient.MustRegister(userOperations)
 	prometheusClient.MustRegister(planOperations)
+	prometheusClient.MustRegister(activeSubscriptions)
+	prometheusClient.MustRegister(monthlyRecurringRevenue)
+	prometheusClient.MustRegister(trialConversions)
+	prometheusClient.MustRegister(dunningEvents)
+	prometheusClient.MustRegister(retentionOffers)
+	prometheusClient.MustRegister(disputes)
+	prometheusClient.MustRegister(disputeRate)
+	prometheusClient.MustRegister(experimentOperations)
+	prometheusClient.MustRegister(experimentEvents)
+	prometheusClient.MustRegister(segmentOperations)
+	prometheusClient.MustRegister(riskDecisions)
+	prometheusClient.MustRegister(securityEvents)
+	prometheusClient.MustRegister(cacheLookups)
+	prometheusClient.MustRegister(dbInterrupted)
+	prometheusClient.MustRegister(dbQueryDuration)
+	prometheusClient.MustRegister(redisCommands)
+	prometheusClient.MustRegister(redisCommandDuration)
+	prometheusClient.MustRegister(redisDegraded)
+	prometheusClient.MustRegister(cacheWarmed)
+	prometheusClient.MustRegister(sloBurnRate)
+	prometheusClient.MustRegister(sloAlerts)
+	prometheusClient.MustRegister(panics)
+	prometheusClient.MustRegister(jobRuns)
+	prometheusClient.MustRegister(jobDuration)
 }
 
 type Provider struct {
 	metricsProvider *metric.MeterProvider
+	tracerProvider  *sdktrace.TracerProvider
 }
 
 func InitProvider(cfg config.TelemetryConfig) (*Provider, error) {
@@ -122,12 +354,29 @@ func InitProvider(cfg config.TelemetryConfig) (*Provider, error) {
 	// Set global meter provider
 	otel.SetMeterProvider(metricsProvider)
 
-	return &Provider{
+	provider := &Provider{
 		metricsProvider: metricsProvider,
-	}, nil
+	}
+
+	// Create tracer provider
+	if cfg.Tracing.Enabled {
+		tracerProvider, err := initTracing(cfg.Tracing, res)
+		if err != nil {
+			return nil, err
+		}
+		provider.tracerProvider = tracerProvider
+	}
+
+	return provider, nil
 }
 
 func (p *Provider) Shutdown(ctx context.Context) error {
+	// Flush pending spans before tearing down metrics
+	if p.tracerProvider != nil {
+		if err := p.tracerProvider.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
 	if p.metricsProvider != nil {
 		return p.metricsProvider.Shutdown(ctx)
 	}
@@ -151,12 +400,22 @@ func GinMiddleware() gin.HandlerFunc {
 		}
 
 		httpRequestsTotal.WithLabelValues(method, endpoint, status).Inc()
-		httpRequestDuration.WithLabelValues(method, endpoint).Observe(duration)
+		observeWithExemplar(c.Request.Context(), httpRequestDuration.WithLabelValues(method, endpoint), duration)
 	})
 }
 
+// RegisterDBStats exports connection pool statistics for db under the given name
+func RegisterDBStats(db *sql.DB, name string) error {
+	return prometheusClient.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// MetricsHandler serves the metrics, in the OpenMetrics format to scrapers
+// asking for it, as exemplars need
 func MetricsHandler() gin.HandlerFunc {
-	return gin.WrapH(promhttp.Handler())
+	return gin.WrapH(promhttp.InstrumentMetricHandler(
+		prometheusClient.DefaultRegisterer,
+		promhttp.HandlerFor(prometheusClient.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	))
 }
 
 // Helper functions for recording business metrics
@@ -168,6 +427,11 @@ func RecordPaywallCheck(result string) {
 	paywallChecks.WithLabelValues(result).Inc()
 }
 
+// RecordPaywallEventsDropped counts paywall analytics events that were lost
+func RecordPaywallEventsDropped(n int) {
+	paywallEventsDropped.Add(float64(n))
+}
+
 func RecordPaymentOperation(operation, status string) {
 	paymentOperations.WithLabelValues(operation, status).Inc()
 }
@@ -179,3 +443,144 @@ func RecordUserOperation(operation, status string) {
 func RecordPlanOperation(operation, status string) {
 	planOperations.WithLabelValues(operation, status).Inc()
 }
+
+// SetActiveSubscriptions replaces the per-plan active subscription gauges
+func SetActiveSubscriptions(counts map[string]int) {
+	activeSubscriptions.Reset()
+	for planID, count := range counts {
+		activeSubscriptions.WithLabelValues(planID).Set(float64(count))
+	}
+}
+
+// SetMonthlyRecurringRevenue replaces the per-currency MRR gauges
+func SetMonthlyRecurringRevenue(revenue map[string]float64) {
+	monthlyRecurringRevenue.Reset()
+	for currency, amount := range revenue {
+		monthlyRecurringRevenue.WithLabelValues(currency).Set(amount)
+	}
+}
+
+// SetTrialConversions replaces the per-plan trial conversion gauges
+func SetTrialConversions(counts map[string]int) {
+	trialConversions.Reset()
+	for planID, count := range counts {
+		trialConversions.WithLabelValues(planID).Set(float64(count))
+	}
+}
+
+func RecordDunningEvent(event string) {
+	dunningEvents.WithLabelValues(event).Inc()
+}
+
+// RecordRetentionOffer counts a win-back offer outcome: presented, accepted
+// or declined. The save rate is accepted over presented.
+func RecordRetentionOffer(offer, outcome string) {
+	retentionOffers.WithLabelValues(offer, outcome).Inc()
+}
+
+// RecordDispute counts a dispute event; status is opened, won or lost.
+func RecordDispute(planID, status string) {
+	disputes.WithLabelValues(planID, status).Inc()
+}
+
+// SetDisputeRates replaces the per-plan dispute rate gauges
+func SetDisputeRates(rates map[string]float64) {
+	disputeRate.Reset()
+	for planID, rate := range rates {
+		disputeRate.WithLabelValues(planID).Set(rate)
+	}
+}
+
+func RecordExperimentOperation(operation, status string) {
+	experimentOperations.WithLabelValues(operation, status).Inc()
+}
+
+func RecordSegmentOperation(operation, status string) {
+	segmentOperations.WithLabelValues(operation, status).Inc()
+}
+
+// RecordRiskDecision counts a risk decision: check is a checker's name
+// (decision error when it failed), combined for the verdict on a payment, or
+// review for a manual review's outcome.
+func RecordRiskDecision(check, decision string) {
+	riskDecisions.WithLabelValues(check, decision).Inc()
+}
+
+// RecordPanic counts a request whose handler panicked
+func RecordPanic(route string) {
+	panics.WithLabelValues(route).Inc()
+}
+
+// RecordSecurityEvent counts a security event, e.g. login_failed,
+// account_locked or ip_throttled.
+func RecordSecurityEvent(event string) {
+	securityEvents.WithLabelValues(event).Inc()
+}
+
+// RecordExperimentEvent counts a first exposure or conversion of a user in
+// an experiment variant; event is exposure or conversion.
+func RecordExperimentEvent(experiment, variant, event string) {
+	experimentEvents.WithLabelValues(experiment, variant, event).Inc()
+}
+
+// RecordJobRun counts a background job run; status is succeeded, retry or dead.
+func RecordJobRun(kind, status string, duration time.Duration) {
+	jobRuns.WithLabelValues(kind, status).Inc()
+	jobDuration.WithLabelValues(kind).Observe(duration.Seconds())
+}
+
+// RecordCacheLookup counts a cache hit or miss for a domain; the hit ratio
+// is hits / (hits + misses) per domain label.
+func RecordCacheLookup(domain string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(domain, result).Inc()
+}
+
+// RecordRedisCommand counts a Redis command and its latency; result is hit
+// or miss for reads and ok or error otherwise, so the hit ratio per prefix
+// is hits / (hits + misses).
+func RecordRedisCommand(command, prefix, result string, duration time.Duration) {
+	redisCommands.WithLabelValues(command, prefix, result).Inc()
+	redisCommandDuration.WithLabelValues(command, prefix).Observe(duration.Seconds())
+}
+
+// RecordCacheWarmup counts the values a cache warmer preloaded
+func RecordCacheWarmup(warmer string, entries int) {
+	cacheWarmed.WithLabelValues(warmer).Add(float64(entries))
+}
+
+// SetRedisDegraded reports whether Redis is marked unreachable
+func SetRedisDegraded(degraded bool) {
+	if degraded {
+		redisDegraded.Set(1)
+	} else {
+		redisDegraded.Set(0)
+	}
+}
+
+// RecordDBInterrupted counts a database call cut short: reason is
+// statement_timeout when the per-operation timeout fired, deadline when the
+// caller's deadline did, or cancelled when the caller gave up.
+func RecordDBInterrupted(operation, reason string) {
+	dbInterrupted.WithLabelValues(operation, reason).Inc()
+}
+
+// RecordDBQuery observes a database call's latency; query is its statement
+// or WithQueryName name, or unnamed.
+func RecordDBQuery(operation, query string, duration time.Duration) {
+	dbQueryDuration.WithLabelValues(operation, query).Observe(duration.Seconds())
+}
+
+// SetSLOBurnRate sets an SLO objective's burn rate for sli over window
+// seconds
+func SetSLOBurnRate(objective, sli string, window int, rate float64) {
+	sloBurnRate.WithLabelValues(objective, sli, strconv.Itoa(window)).Set(rate)
+}
+
+// RecordSLOAlert counts an SLO alert sent; status is firing or resolved.
+func RecordSLOAlert(objective, severity, status string) {
+	sloAlerts.WithLabelValues(objective, severity, status).Inc()
+}
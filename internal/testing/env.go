@@ -0,0 +1,292 @@
+//go:build integration
+
+// Package testenv runs the services against real Postgres and Redis for
+// integration tests. Start launches both in containers with testcontainers,
+// applies the migrations and returns an Env whose constructors build
+// services wired the way the server wires them. Tests using it carry the
+// integration build tag and need Docker:
+//
+//	go test -tags integration ./...
+package testenv
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/apikey"
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/encryption"
+	"scalable-paywall/internal/featureflag"
+	"scalable-paywall/internal/jobs"
+	"scalable-paywall/internal/notification"
+	"scalable-paywall/internal/payment"
+	"scalable-paywall/internal/paywall"
+	"scalable-paywall/internal/plan"
+	"scalable-paywall/internal/risk"
+	"scalable-paywall/internal/seed"
+	"scalable-paywall/internal/subscription"
+	"scalable-paywall/internal/user"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	tcredis "github.com/testcontainers/testcontainers-go/modules/redis"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Images started by Start, matching the versions deployed
+const (
+	postgresImage = "postgres:15-alpine"
+	redisImage    = "redis:7-alpine"
+)
+
+// Env is a migrated database and an empty Redis, with the configuration
+// pointing at them.
+type Env struct {
+	Config *config.Config
+	DB     *db.Connection
+	Cache  *cache.RedisClient
+
+	redis      *redis.Client
+	containers []testcontainers.Container
+}
+
+// Start launches Postgres and Redis containers and migrates the database.
+// The configuration is the server's defaults with the database and cache
+// pointed at the containers; tests may adjust it before building services.
+func Start(ctx context.Context) (*Env, error) {
+	env := &Env{}
+	if err := env.start(ctx); err != nil {
+		env.Close()
+		return nil, err
+	}
+	return env, nil
+}
+
+func (e *Env) start(ctx context.Context) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	e.Config = cfg
+
+	pg, err := postgres.RunContainer(ctx,
+		testcontainers.WithImage(postgresImage),
+		postgres.WithDatabase("paywall"),
+		postgres.WithUsername("paywall"),
+		postgres.WithPassword("paywall"),
+		testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").
+			WithOccurrence(2).WithStartupTimeout(time.Minute)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start postgres: %w", err)
+	}
+	e.containers = append(e.containers, pg)
+	if cfg.Database.Host, cfg.Database.Port, err = endpoint(ctx, pg, "5432/tcp"); err != nil {
+		return err
+	}
+	cfg.Database.User = "paywall"
+	cfg.Database.Password = "paywall"
+	cfg.Database.DBName = "paywall"
+	cfg.Database.SSLMode = "disable"
+	cfg.Database.ReplicaHosts = nil
+
+	rd, err := tcredis.RunContainer(ctx, testcontainers.WithImage(redisImage))
+	if err != nil {
+		return fmt.Errorf("failed to start redis: %w", err)
+	}
+	e.containers = append(e.containers, rd)
+	if cfg.Cache.Host, cfg.Cache.Port, err = endpoint(ctx, rd, "6379/tcp"); err != nil {
+		return err
+	}
+	cfg.Cache.Password = ""
+	cfg.Cache.DB = 0
+
+	if e.DB, err = db.NewConnection(cfg.Database); err != nil {
+		return err
+	}
+	if _, err := e.DB.Migrate(ctx); err != nil {
+		return err
+	}
+	if e.Cache, err = cache.NewRedisClient(cfg.Cache); err != nil {
+		return err
+	}
+	e.redis = redis.NewClient(&redis.Options{Addr: fmt.Sprintf("%s:%d", cfg.Cache.Host, cfg.Cache.Port)})
+	return nil
+}
+
+func endpoint(ctx context.Context, container testcontainers.Container, port nat.Port) (string, int, error) {
+	host, err := container.Host(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	mapped, err := container.MappedPort(ctx, port)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, mapped.Int(), nil
+}
+
+// Close disconnects from and removes the containers.
+func (e *Env) Close() {
+	if e.Cache != nil {
+		e.Cache.Close()
+	}
+	if e.redis != nil {
+		e.redis.Close()
+	}
+	if e.DB != nil {
+		e.DB.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, container := range e.containers {
+		container.Terminate(ctx)
+	}
+}
+
+// Main starts an Env for a package's tests and removes it when they are
+// done, for use from TestMain:
+//
+//	var env *testenv.Env
+//
+//	func TestMain(m *testing.M) { os.Exit(testenv.Main(m, &env)) }
+func Main(m *testing.M, env **Env) int {
+	gin.SetMode(gin.TestMode)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	started, err := Start(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start test environment: %v\n", err)
+		return 1
+	}
+	defer started.Close()
+	*env = started
+	return m.Run()
+}
+
+// Reset empties every table and Redis so a test starts from a freshly
+// migrated database.
+func (e *Env) Reset(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+
+	rows, err := e.DB.QueryContext(ctx, `SELECT tablename FROM pg_tables WHERE schemaname = 'public' AND tablename <> 'schema_migrations'`)
+	if err != nil {
+		t.Fatalf("failed to list tables: %v", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			t.Fatalf("failed to list tables: %v", err)
+		}
+		tables = append(tables, `"`+table+`"`)
+	}
+	rows.Close()
+	if len(tables) > 0 {
+		if _, err := e.DB.ExecContext(ctx, `TRUNCATE `+strings.Join(tables, ", ")+` RESTART IDENTITY CASCADE`); err != nil {
+			t.Fatalf("failed to truncate tables: %v", err)
+		}
+	}
+	e.FlushRedis(t)
+}
+
+// FlushRedis empties Redis, as if it had restarted without persistence.
+func (e *Env) FlushRedis(t *testing.T) {
+	t.Helper()
+	if err := e.redis.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatalf("failed to flush redis: %v", err)
+	}
+}
+
+// Users builds the user service.
+func (e *Env) Users() *user.Service {
+	keyring, err := encryption.NewKeyring(e.Config.Encryption)
+	if err != nil {
+		panic(err)
+	}
+	return user.NewService(&e.Config.Auth, e.DB, e.Cache, keyring)
+}
+
+// APIKeys builds the API key service.
+func (e *Env) APIKeys() *apikey.Service {
+	keyring, err := encryption.NewKeyring(e.Config.Encryption)
+	if err != nil {
+		panic(err)
+	}
+	return apikey.NewService(e.Config.Auth.RequestSigning, e.DB, e.Cache, keyring)
+}
+
+// Plans builds the plan service, without currency conversion or pricing
+// experiments.
+func (e *Env) Plans() *plan.Service {
+	return plan.NewService(&e.Config.Pricing, e.Config.Features, e.DB, e.Cache, nil, nil)
+}
+
+// Subscriptions builds the subscription service. Notifications are sent
+// through the configured notifier, which logs them by default.
+func (e *Env) Subscriptions() *subscription.Service {
+	return subscription.NewService(&e.Config.Subscription, e.DB, e.Cache, notification.NewNotifier(e.Config.Notification, notification.NewTemplates(e.DB, nil)), nil)
+}
+
+// Flags builds the feature flag service.
+func (e *Env) Flags() *featureflag.Service {
+	return featureflag.NewService(e.Config.FeatureFlags, e.Cache)
+}
+
+// Queue builds a job queue on the test database. Nothing runs its jobs
+// unless the test starts a runner.
+func (e *Env) Queue() *jobs.Queue {
+	return jobs.NewQueue(e.DB, e.Config.Jobs.MaxAttempts)
+}
+
+// Payments builds the payment service on subscriptions. It charges through
+// the simulated gateway unless the configuration routes elsewhere.
+func (e *Env) Payments(subscriptions *subscription.Service) *payment.Service {
+	keyring, err := encryption.NewKeyring(e.Config.Encryption)
+	if err != nil {
+		panic(err)
+	}
+	return payment.NewService(&e.Config.Payment, e.DB, e.Cache, e.Queue(), e.Flags(), subscriptions,
+		risk.NewService(e.Config.Payment.Risk, e.DB, e.Cache), keyring)
+}
+
+// Paywall builds the paywall service on subscriptions. Its events are
+// buffered but not written, as no recorder is running.
+func (e *Env) Paywall(subscriptions *subscription.Service) *paywall.Service {
+	cfg := e.Config.Paywall
+	return paywall.NewService(e.Cache, subscriptions, e.Flags(), paywall.NewEventRecorder(&cfg, e.DB),
+		paywall.NewAbuseDetector(cfg.Abuse, e.DB, e.Cache),
+		cfg.RateLimit, cfg.Usage, cfg.Stream, cfg.Leases, cfg.DegradedPolicy, e.Config.Features)
+}
+
+// Seeder builds a seeder drawing its data from seed.
+func (e *Env) Seeder(randSeed int64) *seed.Seeder {
+	return seed.NewSeeder(e.DB, e.Users(), e.Plans(), e.Subscriptions(), randSeed)
+}
+
+// Serve calls handler with a request for method and path carrying body as
+// JSON, and returns the response. params are the route's path parameters.
+func Serve(handler gin.HandlerFunc, method, path, body string, params ...gin.Param) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		c.Request.Header.Set("Content-Type", "application/json")
+	}
+	c.Params = params
+	handler(c)
+	return recorder
+}
@@ -0,0 +1,314 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// userSortColumns are the columns ListUsers can sort by
+var userSortColumns = map[string]string{
+	"created_at": "u.created_at",
+	"updated_at": "u.updated_at",
+	"email":      "u.email",
+	"username":   "u.username",
+	"status":     "u.status",
+}
+
+// recentInvoices is how many invoices the user detail view includes
+const recentInvoices = 20
+
+type UserListResponse struct {
+	Users []User `json:"users"`
+	Total int    `json:"total"`
+	Page  int    `json:"page"`
+	Limit int    `json:"limit"`
+	Sort  string `json:"sort"`
+}
+
+// userFilter narrows ListUsers. Zero values match everything. Email and
+// username match case-insensitive substrings, except that encrypted emails
+// only match the whole address; subscriptionStatus and planID
+// match users with any subscription in that status or on that plan (the
+// same subscription when both are given).
+type userFilter struct {
+	email              string
+	username           string
+	status             string
+	subscriptionStatus string
+	planID             string
+}
+
+// UserDetail is a user with whatever the include parameter asked for
+type UserDetail struct {
+	*User
+	Subscription *UserSubscription `json:"subscription,omitempty"`
+	Invoices     []Invoice         `json:"invoices,omitempty"`
+}
+
+// UserSubscription is the user's current subscription: the active one, or
+// the most recent one if none is active
+type UserSubscription struct {
+	ID        string          `json:"id"`
+	PlanID    string          `json:"plan_id"`
+	PlanName  string          `json:"plan_name"`
+	Status    string          `json:"status"`
+	StartDate time.Time       `json:"start_date"`
+	EndDate   time.Time       `json:"end_date"`
+	AutoRenew bool            `json:"auto_renew"`
+	Amount    decimal.Decimal `json:"amount"`
+	Currency  string          `json:"currency"`
+}
+
+// Invoice is a charge to the user; the payment transaction doubles as the
+// invoice, as for refunds
+type Invoice struct {
+	ID             string          `json:"id"`
+	SubscriptionID *string         `json:"subscription_id,omitempty"`
+	Amount         decimal.Decimal `json:"amount"`
+	RefundedAmount decimal.Decimal `json:"refunded_amount"`
+	Currency       string          `json:"currency"`
+	Status         string          `json:"status"`
+	PaymentMethod  *string         `json:"payment_method,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// ListUsers searches users for admins. Filters: email, username, status,
+// subscription_status, plan_id. Sorted by sort (created_at, updated_at,
+// email, username or status, prefixed with - for descending; default
+// -created_at) and paginated by page and limit. Sorting by email is not
+// possible while emails are encrypted.
+func (s *Service) ListUsers(c *gin.Context) {
+	page := 1
+	limit := db.PageLimit(c.Query("limit"), db.DefaultPageLimit, db.MaxPageLimit)
+	if pageStr := c.Query("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	sort := c.DefaultQuery("sort", "-created_at")
+	orderBy, err := parseUserSort(sort)
+	if err == nil && s.keyring.EncryptsEmail() && strings.TrimPrefix(sort, "-") == "email" {
+		err = fmt.Errorf("cannot sort by %q while emails are encrypted", "email")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordUserOperation("admin_list", "validation_error")
+		return
+	}
+
+	filter := userFilter{
+		email:              c.Query("email"),
+		username:           c.Query("username"),
+		status:             c.Query("status"),
+		subscriptionStatus: c.Query("subscription_status"),
+		planID:             c.Query("plan_id"),
+	}
+	users, total, err := s.listUsers(c.Request.Context(), filter, orderBy, page, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordUserOperation("admin_list", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, UserListResponse{
+		Users: users,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+		Sort:  sort,
+	})
+	telemetry.RecordUserOperation("admin_list", "success")
+}
+
+// GetUserDetail returns a user for admins, with its current subscription
+// and most recent invoices when include names them
+// (include=subscription,invoices).
+func (s *Service) GetUserDetail(c *gin.Context) {
+	ctx := c.Request.Context()
+	includeSubscription, includeInvoices, err := parseUserInclude(c.Query("include"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordUserOperation("admin_get", "validation_error")
+		return
+	}
+
+	user, err := s.getUserByID(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		telemetry.RecordUserOperation("admin_get", "not_found")
+		return
+	}
+
+	detail := UserDetail{User: user}
+	if includeSubscription {
+		detail.Subscription, err = s.currentSubscription(ctx, user.ID)
+		if err != nil && err != sql.ErrNoRows {
+			logrus.Errorf("Failed to get subscription for user %s: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			telemetry.RecordUserOperation("admin_get", "db_error")
+			return
+		}
+	}
+	if includeInvoices {
+		detail.Invoices, err = s.listInvoices(ctx, user.ID, recentInvoices)
+		if err != nil {
+			logrus.Errorf("Failed to list invoices for user %s: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			telemetry.RecordUserOperation("admin_get", "db_error")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, detail)
+	telemetry.RecordUserOperation("admin_get", "success")
+}
+
+// parseUserSort turns a sort parameter such as "-created_at" into an ORDER
+// BY clause, with id breaking ties so pages are stable
+func parseUserSort(sort string) (string, error) {
+	direction := "ASC"
+	field := sort
+	if strings.HasPrefix(sort, "-") {
+		direction = "DESC"
+		field = sort[1:]
+	}
+	column, ok := userSortColumns[field]
+	if !ok {
+		return "", fmt.Errorf("cannot sort by %q", field)
+	}
+	return fmt.Sprintf("%s %s, u.id %s", column, direction, direction), nil
+}
+
+// parseUserInclude reads a comma-separated include parameter
+func parseUserInclude(include string) (subscription, invoices bool, err error) {
+	if include == "" {
+		return false, false, nil
+	}
+	for _, part := range strings.Split(include, ",") {
+		switch strings.TrimSpace(part) {
+		case "subscription":
+			subscription = true
+		case "invoices":
+			invoices = true
+		default:
+			return false, false, fmt.Errorf("cannot include %q", part)
+		}
+	}
+	return subscription, invoices, nil
+}
+
+// escapeLike quotes LIKE wildcards so a search matches them literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (s *Service) listUsers(ctx context.Context, filter userFilter, orderBy string, page, limit int) ([]User, int, error) {
+	where := `
+		WHERE ($1 = '' OR ($6 = '' AND u.email ILIKE '%' || $1 || '%') OR u.email_hash = $6)
+			AND ($2 = '' OR u.username ILIKE '%' || $2 || '%')
+			AND ($3 = '' OR u.status = $3)
+			AND (($4 = '' AND $5 = '') OR EXISTS (
+				SELECT 1 FROM subscriptions s
+				WHERE s.user_id = u.id
+					AND ($4 = '' OR s.status = $4)
+					AND ($5 = '' OR s.plan_id::text = $5)
+			))
+	`
+	var emailHash string
+	if filter.email != "" && s.keyring.EncryptsEmail() {
+		emailHash = s.keyring.BlindIndex(normalizeEmail(filter.email))
+	}
+	args := []interface{}{escapeLike(filter.email), escapeLike(filter.username),
+		filter.status, filter.subscriptionStatus, filter.planID, emailHash}
+	reader := s.db.Reader()
+
+	var total int
+	if err := reader.QueryRowContext(ctx, `SELECT COUNT(*) FROM users u `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT u.id, u.email, u.username, u.status, u.status_reason, u.status_changed_at,
+			u.country, u.metadata, u.created_at, u.updated_at, u.timezone, u.vat_id, u.vat_checked_at,
+			u.tenant_id, u.locale, u.role, u.totp_enabled_at IS NOT NULL, u.allowed_cidrs
+		FROM users u ` + where + `
+		ORDER BY ` + orderBy + `
+		LIMIT $7 OFFSET $8
+	`
+	rows, err := reader.QueryContext(ctx, query, append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := s.scanUser(rows.Scan)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (s *Service) currentSubscription(ctx context.Context, userID string) (*UserSubscription, error) {
+	var sub UserSubscription
+	err := s.db.Reader().QueryRowContext(ctx, `
+		SELECT s.id, s.plan_id, p.name, s.status, s.start_date, s.end_date, s.auto_renew,
+			s.amount, COALESCE(s.currency, 'USD')
+		FROM subscriptions s
+		JOIN plans p ON p.id = s.plan_id
+		WHERE s.user_id = $1
+		ORDER BY s.status = 'active' DESC, s.created_at DESC
+		LIMIT 1
+	`, userID).Scan(&sub.ID, &sub.PlanID, &sub.PlanName, &sub.Status, &sub.StartDate,
+		&sub.EndDate, &sub.AutoRenew, &sub.Amount, &sub.Currency)
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (s *Service) listInvoices(ctx context.Context, userID string, limit int) ([]Invoice, error) {
+	rows, err := s.db.Reader().QueryContext(ctx, `
+		SELECT id, subscription_id, amount, refunded_amount, COALESCE(currency, 'USD'), status,
+			payment_method, created_at
+		FROM payment_transactions
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invoices []Invoice
+	for rows.Next() {
+		var inv Invoice
+		if err := rows.Scan(&inv.ID, &inv.SubscriptionID, &inv.Amount, &inv.RefundedAmount,
+			&inv.Currency, &inv.Status, &inv.PaymentMethod, &inv.CreatedAt); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+	return invoices, rows.Err()
+}
@@ -0,0 +1,42 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUserSort(t *testing.T) {
+	orderBy, err := parseUserSort("-created_at")
+	assert.NoError(t, err)
+	assert.Equal(t, "u.created_at DESC, u.id DESC", orderBy)
+
+	orderBy, err = parseUserSort("email")
+	assert.NoError(t, err)
+	assert.Equal(t, "u.email ASC, u.id ASC", orderBy)
+
+	for _, sort := range []string{"", "-", "password", "email; DROP TABLE users"} {
+		_, err = parseUserSort(sort)
+		assert.Error(t, err, sort)
+	}
+}
+
+func TestParseUserInclude(t *testing.T) {
+	subscription, invoices, err := parseUserInclude("")
+	assert.NoError(t, err)
+	assert.False(t, subscription)
+	assert.False(t, invoices)
+
+	subscription, invoices, err = parseUserInclude("subscription, invoices")
+	assert.NoError(t, err)
+	assert.True(t, subscription)
+	assert.True(t, invoices)
+
+	_, _, err = parseUserInclude("subscription,payments")
+	assert.Error(t, err)
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, "alice", escapeLike("alice"))
+	assert.Equal(t, `100\%\_off\\`, escapeLike(`100%_off\`))
+}
@@ -0,0 +1,83 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+)
+
+// captchaURLs are the siteverify endpoints of the supported providers
+var captchaURLs = map[string]string{
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// CaptchaVerifier checks a CAPTCHA token solved by the client at remoteIP.
+// It returns false for a token that doesn't pass, and an error only when
+// the check couldn't be made.
+type CaptchaVerifier interface {
+	Verify(ctx context.Context, token, remoteIP string) (bool, error)
+}
+
+// siteVerifier checks tokens with the siteverify API hCaptcha, reCAPTCHA
+// and Turnstile share
+type siteVerifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// newCaptchaVerifier returns the verifier of the configured provider, or
+// nil when none is
+func newCaptchaVerifier(cfg config.CaptchaConfig) CaptchaVerifier {
+	provider := strings.ToLower(cfg.Provider)
+	if provider == "" {
+		return nil
+	}
+	endpoint := cfg.VerifyURL
+	if endpoint == "" {
+		endpoint = captchaURLs[provider]
+	}
+	return &siteVerifier{
+		url:    endpoint,
+		secret: cfg.Secret,
+		client: &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}
+}
+
+func (v *siteVerifier) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	form := url.Values{}
+	form.Set("secret", v.secret)
+	form.Set("response", token)
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("CAPTCHA verification returned %d", resp.StatusCode)
+	}
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("failed to decode CAPTCHA verification: %w", err)
+	}
+	return result.Success, nil
+}
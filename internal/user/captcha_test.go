@@ -0,0 +1,52 @@
+package user
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCaptchaVerifier(t *testing.T) {
+	assert.Nil(t, newCaptchaVerifier(config.CaptchaConfig{}))
+
+	v := newCaptchaVerifier(config.CaptchaConfig{Provider: "Turnstile", Secret: "0x4AAAA", Timeout: 5})
+	require.NotNil(t, v)
+	assert.Equal(t, "https://challenges.cloudflare.com/turnstile/v0/siteverify", v.(*siteVerifier).url)
+}
+
+func TestSiteVerifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "0x4AAAA", r.PostForm.Get("secret"))
+		assert.Equal(t, "203.0.113.7", r.PostForm.Get("remoteip"))
+		switch r.PostForm.Get("response") {
+		case "solved":
+			w.Write([]byte(`{"success": true}`))
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+		}
+	}))
+	defer server.Close()
+
+	v := newCaptchaVerifier(config.CaptchaConfig{Provider: "hcaptcha", VerifyURL: server.URL, Secret: "0x4AAAA", Timeout: 5})
+	ctx := context.Background()
+
+	passed, err := v.Verify(ctx, "solved", "203.0.113.7")
+	require.NoError(t, err)
+	assert.True(t, passed)
+
+	passed, err = v.Verify(ctx, "guessed", "203.0.113.7")
+	require.NoError(t, err)
+	assert.False(t, passed)
+
+	_, err = v.Verify(ctx, "broken", "203.0.113.7")
+	assert.Error(t, err)
+}
@@ -0,0 +1,57 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"scalable-paywall/internal/metadata"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// ErrInvalidUser is returned by ImportUser for a request that fails
+// CreateUser's validation.
+var ErrInvalidUser = errors.New("invalid user")
+
+// ImportUser creates an active user outside a request, under the same rules
+// as CreateUser. A taken email or username fails with ErrEmailTaken or
+// ErrUsernameTaken; any other error is the database's.
+func (s *Service) ImportUser(ctx context.Context, req CreateUserRequest) (*User, error) {
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUser, err)
+	}
+	userMetadata, err := metadata.Merge(nil, req.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUser, err)
+	}
+
+	now := time.Now()
+	user := &User{
+		ID:           generateUUID(),
+		Email:        normalizeEmail(req.Email),
+		Username:     req.Username,
+		Status:       StatusActive,
+		Role:         RoleUser,
+		AllowedCIDRs: []string{},
+		Country:      req.Country,
+		Timezone:     req.Timezone,
+		Locale:       req.Locale,
+		Metadata:     userMetadata,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := s.createUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	s.cacheUser(ctx, user)
+	return user, nil
+}
+
+// GetUserByEmail reads the user with an email, in any case, bypassing the
+// cache. It returns sql.ErrNoRows if there is none.
+func (s *Service) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return s.getUserByEmail(ctx, email)
+}
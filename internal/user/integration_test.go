@@ -0,0 +1,264 @@
+//go:build integration
+
+package user_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	testenv "scalable-paywall/internal/testing"
+	"scalable-paywall/internal/user"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var env *testenv.Env
+
+func TestMain(m *testing.M) { os.Exit(testenv.Main(m, &env)) }
+
+func createSession(users *user.Service, userID, captchaToken string) (int, http.Header) {
+	body := fmt.Sprintf(`{"user_id": %q, "captcha_token": %q}`, userID, captchaToken)
+	w := testenv.Serve(users.CreateSession, http.MethodPost, "/api/v1/users/sessions", body)
+	return w.Code, w.Header()
+}
+
+// serveWithSession calls a handler as the holder of a session token
+func serveWithSession(handler gin.HandlerFunc, token, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("Authorization", "Bearer "+token)
+	handler(c)
+	return recorder
+}
+
+// totpCode is the current code of a base32 TOTP secret, as an
+// authenticator app shows it
+func totpCode(t *testing.T, secret string) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+// enrollTwoFactor turns on two-factor authentication for a user and
+// returns the code that confirmed it and their recovery codes
+func enrollTwoFactor(t *testing.T, users *user.Service, userID string) (string, []string) {
+	t.Helper()
+	id := gin.Param{Key: "id", Value: userID}
+	w := testenv.Serve(users.EnrollTwoFactor, http.MethodPost, "/api/v1/users/"+userID+"/two-factor", "", id)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var enrollment user.TwoFactorEnrollment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &enrollment))
+	assert.Contains(t, enrollment.OTPAuthURL, "secret="+enrollment.Secret)
+
+	confirmed := totpCode(t, enrollment.Secret)
+	body := fmt.Sprintf(`{"totp_code": %q}`, confirmed)
+	w = testenv.Serve(users.ConfirmTwoFactor, http.MethodPost, "/api/v1/users/"+userID+"/two-factor/confirm", body, id)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var codes user.RecoveryCodes
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &codes))
+	return confirmed, codes.RecoveryCodes
+}
+
+// fakeCaptcha passes the token "solved"
+type fakeCaptcha struct{}
+
+func (fakeCaptcha) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	return token == "solved", nil
+}
+
+func TestCreateSessionLocksOutAccountAfterFailures(t *testing.T) {
+	env.Reset(t)
+	users := env.Users()
+	const missing = "00000000-0000-0000-0000-0000000000aa"
+
+	for i := 0; i < env.Config.Auth.Lockout.MaxAccountFailures; i++ {
+		code, _ := createSession(users, missing, "")
+		assert.Equal(t, http.StatusNotFound, code)
+	}
+	code, header := createSession(users, missing, "")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, fmt.Sprint(env.Config.Auth.Lockout.LockoutDuration), header.Get("Retry-After"))
+
+	// Other accounts from the same IP are still let in
+	jane, err := users.ImportUser(context.Background(), user.CreateUserRequest{Email: "jane@example.com", Username: "jane"})
+	require.NoError(t, err)
+	code, _ = createSession(users, jane.ID, "")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestCreateSessionAsksForCaptchaAfterFailures(t *testing.T) {
+	env.Reset(t)
+	users := env.Users()
+	users.SetCaptchaVerifier(fakeCaptcha{})
+	jane, err := users.ImportUser(context.Background(), user.CreateUserRequest{Email: "jane@example.com", Username: "jane"})
+	require.NoError(t, err)
+
+	for i := 0; i < env.Config.Auth.Lockout.CaptchaAfter; i++ {
+		code, _ := createSession(users, "00000000-0000-0000-0000-0000000000aa", "")
+		assert.Equal(t, http.StatusNotFound, code)
+	}
+
+	// The IP's failures now call for a CAPTCHA whichever account it asks for
+	code, _ := createSession(users, jane.ID, "")
+	assert.Equal(t, http.StatusPreconditionRequired, code)
+	code, _ = createSession(users, jane.ID, "guessed")
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = createSession(users, jane.ID, "solved")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestCreateSessionRequiresSecondFactor(t *testing.T) {
+	env.Reset(t)
+	users := env.Users()
+	ctx := context.Background()
+	jane, err := users.ImportUser(ctx, user.CreateUserRequest{Email: "jane@example.com", Username: "jane"})
+	require.NoError(t, err)
+	_, recoveryCodes := enrollTwoFactor(t, users, jane.ID)
+	assert.Len(t, recoveryCodes, env.Config.Auth.TwoFactor.RecoveryCodes)
+
+	code, _ := createSession(users, jane.ID, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	signIn := func(recoveryCode string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"user_id": %q, "recovery_code": %q}`, jane.ID, recoveryCode)
+		return testenv.Serve(users.CreateSession, http.MethodPost, "/api/v1/users/sessions", body)
+	}
+	w := signIn(strings.ToUpper(recoveryCodes[0]))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var session user.UserSession
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &session))
+	assert.NotNil(t, session.VerifiedAt)
+
+	// Recovery codes work once
+	assert.Equal(t, http.StatusUnauthorized, signIn(recoveryCodes[0]).Code)
+
+	// The session just verified passes step-up
+	passed := false
+	w = serveWithSession(func(c *gin.Context) {
+		users.RequireStepUp(c)
+		passed = !c.IsAborted()
+	}, session.Token, "")
+	assert.True(t, passed, w.Body.String())
+}
+
+func TestSessionsOutliveRedis(t *testing.T) {
+	env.Reset(t)
+	users := env.Users()
+	jane, err := users.ImportUser(context.Background(), user.CreateUserRequest{Email: "jane@example.com", Username: "jane"})
+	require.NoError(t, err)
+
+	body := fmt.Sprintf(`{"user_id": %q}`, jane.ID)
+	w := testenv.Serve(users.CreateSession, http.MethodPost, "/api/v1/users/sessions", body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var session user.UserSession
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &session))
+
+	validate := func() (int, string) {
+		userID := ""
+		w := serveWithSession(func(c *gin.Context) {
+			users.ValidateSession(c)
+			userID = c.GetString("user_id")
+		}, session.Token, "")
+		return w.Code, userID
+	}
+
+	// Losing the cached copy falls back to the database
+	env.FlushRedis(t)
+	code, userID := validate()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, jane.ID, userID)
+
+	// Suspending the user deletes it there too
+	id := gin.Param{Key: "id", Value: jane.ID}
+	w = testenv.Serve(users.SuspendUser, http.MethodPost, "/api/v1/users/"+jane.ID+"/suspend", `{"reason": "chargebacks"}`, id)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	env.FlushRedis(t)
+	code, _ = validate()
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestStepUpRejectsUsedCode(t *testing.T) {
+	env.Reset(t)
+	users := env.Users()
+	jane, err := users.ImportUser(context.Background(), user.CreateUserRequest{Email: "jane@example.com", Username: "jane"})
+	require.NoError(t, err)
+	confirmed, _ := enrollTwoFactor(t, users, jane.ID)
+
+	// The code that confirmed enrollment is used up
+	body := fmt.Sprintf(`{"user_id": %q, "totp_code": %q}`, jane.ID, confirmed)
+	w := testenv.Serve(users.CreateSession, http.MethodPost, "/api/v1/users/sessions", body)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serveWithSession(users.StepUp, "unknown", `{"totp_code": "123456"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestCreateSessionRequiresAdminsToEnroll(t *testing.T) {
+	env.Reset(t)
+	cfg := env.Config.Auth
+	cfg.TwoFactor.RequireForAdmins = true
+	users := user.NewService(&cfg, env.DB, env.Cache, nil)
+	ctx := context.Background()
+	admin, err := users.ImportUser(ctx, user.CreateUserRequest{Email: "ops@example.com", Username: "ops"})
+	require.NoError(t, err)
+
+	w := testenv.Serve(users.UpdateUser, http.MethodPut, "/api/v1/users/"+admin.ID, `{"role": "admin"}`, gin.Param{Key: "id", Value: admin.ID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	code, _ := createSession(users, admin.ID, "")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	_, recoveryCodes := enrollTwoFactor(t, users, admin.ID)
+	body := fmt.Sprintf(`{"recovery_code": %q}`, recoveryCodes[0])
+	w = testenv.Serve(users.DisableTwoFactor, http.MethodDelete, "/api/v1/users/"+admin.ID+"/two-factor", body, gin.Param{Key: "id", Value: admin.ID})
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestCreateSessionEnforcesAllowlist(t *testing.T) {
+	env.Reset(t)
+	cfg := env.Config.Auth
+	cfg.AdminCIDRs = []string{"203.0.113.0/24"}
+	users := user.NewService(&cfg, env.DB, env.Cache, nil)
+	admin, err := users.ImportUser(context.Background(), user.CreateUserRequest{Email: "ops@example.com", Username: "ops"})
+	require.NoError(t, err)
+	update := func(body string) {
+		w := testenv.Serve(users.UpdateUser, http.MethodPut, "/api/v1/users/"+admin.ID, body, gin.Param{Key: "id", Value: admin.ID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// Requests come from 192.0.2.1, outside the admins' allowlist
+	code, _ := createSession(users, admin.ID, "")
+	assert.Equal(t, http.StatusOK, code)
+	update(`{"role": "admin"}`)
+	code, _ = createSession(users, admin.ID, "")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// Their own allowlist replaces the admins'
+	update(`{"allowed_cidrs": ["192.0.2.0/24"]}`)
+	code, _ = createSession(users, admin.ID, "")
+	assert.Equal(t, http.StatusOK, code)
+
+	w := testenv.Serve(users.UpdateUser, http.MethodPut, "/api/v1/users/"+admin.ID, `{"allowed_cidrs": ["office"]}`, gin.Param{Key: "id", Value: admin.ID})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,180 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lockoutMemory is how long an account's lockouts count toward doubling
+// its next one
+const lockoutMemory = 24 * time.Hour
+
+// Why a session creation is refused before the account is looked at
+const (
+	reasonIPThrottled   = "ip_throttled"
+	reasonAccountLocked = "account_locked"
+)
+
+// loginCheckScript reads the failures of the IP in KEYS[1] and the account
+// in KEYS[2], with the time left on each count and on the account's lock in
+// KEYS[3], in milliseconds.
+var loginCheckScript = cache.NewScript(`
+local ip = tonumber(redis.call('GET', KEYS[1]) or '0')
+local account = tonumber(redis.call('GET', KEYS[2]) or '0')
+return {ip, redis.call('PTTL', KEYS[1]), account, redis.call('PTTL', KEYS[3])}
+`)
+
+// loginFailScript counts a failure of the IP in KEYS[1] and the account in
+// KEYS[2], each count expiring ARGV[1] milliseconds after its first
+// failure. At ARGV[2] account failures the account is locked in KEYS[3]
+// for ARGV[3] milliseconds doubled for each lockout counted in KEYS[4]
+// over the last ARGV[5] milliseconds, at most ARGV[4], and its failures
+// start over. It returns the IP's failures, the account's and the lock
+// set, 0 for none.
+var loginFailScript = cache.NewScript(`
+local ip = redis.call('INCR', KEYS[1])
+if ip == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+local account = redis.call('INCR', KEYS[2])
+if account == 1 then
+	redis.call('PEXPIRE', KEYS[2], ARGV[1])
+end
+local lock = 0
+if account >= tonumber(ARGV[2]) then
+	local lockouts = redis.call('INCR', KEYS[4])
+	redis.call('PEXPIRE', KEYS[4], ARGV[5])
+	lock = math.min(tonumber(ARGV[3]) * 2 ^ math.min(lockouts - 1, 30), tonumber(ARGV[4]))
+	redis.call('SET', KEYS[3], lockouts, 'PX', math.floor(lock))
+	redis.call('DEL', KEYS[2])
+end
+return {ip, account, math.floor(lock)}
+`)
+
+// loginGuard throttles failed session creations per client IP and per
+// account, counted in Redis so the limits hold across instances. It fails
+// open: when Redis is unavailable nothing is throttled.
+type loginGuard struct {
+	cfg   config.LockoutConfig
+	cache *cache.RedisClient
+}
+
+// loginState is what earlier failures impose on a session creation:
+// refusal for retryAfter, for reason, or a CAPTCHA to solve
+type loginState struct {
+	retryAfter time.Duration
+	reason     string
+	captcha    bool
+}
+
+func newLoginGuard(cfg config.LockoutConfig, cache *cache.RedisClient) *loginGuard {
+	return &loginGuard{cfg: cfg, cache: cache}
+}
+
+func loginKeys(ip, account string) []string {
+	return []string{
+		fmt.Sprintf("login:failures:ip:%s", ip),
+		fmt.Sprintf("login:failures:account:%s", account),
+		fmt.Sprintf("login:lock:%s", account),
+		fmt.Sprintf("login:lockouts:%s", account),
+	}
+}
+
+// check reads what earlier failures of ip and account impose
+func (g *loginGuard) check(ctx context.Context, ip, account string) loginState {
+	if g == nil || !g.cfg.Enabled {
+		return loginState{}
+	}
+	result, err := g.cache.RunScript(ctx, loginCheckScript, loginKeys(ip, account)[:3])
+	if err != nil {
+		logrus.Warnf("Login throttling unavailable: %v", err)
+		return loginState{}
+	}
+	values, ok := int64s(result, 4)
+	if !ok {
+		logrus.Warnf("Unexpected login check reply: %v", result)
+		return loginState{}
+	}
+	ipFailures, ipTTL, accountFailures, lockTTL := values[0], values[1], values[2], values[3]
+
+	if lockTTL > 0 {
+		return loginState{retryAfter: time.Duration(lockTTL) * time.Millisecond, reason: reasonAccountLocked}
+	}
+	if ipFailures >= int64(g.cfg.MaxIPFailures) && ipTTL > 0 {
+		return loginState{retryAfter: time.Duration(ipTTL) * time.Millisecond, reason: reasonIPThrottled}
+	}
+	after := int64(g.cfg.CaptchaAfter)
+	return loginState{captcha: after > 0 && (ipFailures >= after || accountFailures >= after)}
+}
+
+// fail counts a failed session creation from ip for account, locking the
+// account once it has failed too often
+func (g *loginGuard) fail(ctx context.Context, ip, account string) {
+	telemetry.RecordSecurityEvent("login_failed")
+	if g == nil || !g.cfg.Enabled {
+		return
+	}
+	second := time.Second.Milliseconds()
+	result, err := g.cache.RunScript(ctx, loginFailScript, loginKeys(ip, account),
+		int64(g.cfg.Window)*second, g.cfg.MaxAccountFailures,
+		int64(g.cfg.LockoutDuration)*second, int64(g.cfg.MaxLockoutDuration)*second,
+		lockoutMemory.Milliseconds())
+	if err != nil {
+		logrus.Warnf("Failed to count login failure: %v", err)
+		return
+	}
+	values, ok := int64s(result, 3)
+	if !ok {
+		logrus.Warnf("Unexpected login failure reply: %v", result)
+		return
+	}
+	if values[0] == int64(g.cfg.MaxIPFailures) {
+		telemetry.RecordSecurityEvent(reasonIPThrottled)
+		logrus.Warnf("Throttling session creation from %s after %d failures", ip, values[0])
+	}
+	if lock := time.Duration(values[2]) * time.Millisecond; lock > 0 {
+		telemetry.RecordSecurityEvent(reasonAccountLocked)
+		logrus.Warnf("Locked account %s for %s after repeated failed session creations, the last from %s", account, lock, ip)
+	}
+}
+
+// succeed forgets account's failures and lockouts; the IP's still count
+func (g *loginGuard) succeed(ctx context.Context, ip, account string) {
+	if g == nil || !g.cfg.Enabled {
+		return
+	}
+	keys := loginKeys(ip, account)
+	if err := g.cache.Del(ctx, keys[1], keys[3]); err != nil {
+		logrus.Warnf("Failed to reset login failures of %s: %v", account, err)
+	}
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds for Retry-After
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// int64s reads a script's reply of n integers
+func int64s(result interface{}, n int) ([]int64, bool) {
+	values, _ := result.([]interface{})
+	if len(values) != n {
+		return nil, false
+	}
+	out := make([]int64, n)
+	for i, value := range values {
+		v, ok := value.(int64)
+		if !ok {
+			return nil, false
+		}
+		out[i] = v
+	}
+	return out, true
+}
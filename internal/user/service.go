@@ -3,58 +3,149 @@ package user
 import (
 	"context"
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"scalable-paywall/internal/cache"
+	"scalable-paywall/internal/config"
 	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/encryption"
+	"scalable-paywall/internal/metadata"
+	"scalable-paywall/internal/middleware"
 	"scalable-paywall/internal/telemetry"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
+// Unique constraints on users, named by PostgreSQL's defaults
+const (
+	emailConstraint     = "users_email_key"
+	emailHashConstraint = "users_email_hash_key"
+	usernameConstraint  = "users_username_key"
+)
+
+var (
+	ErrEmailTaken    = errors.New("email already in use")
+	ErrUsernameTaken = errors.New("username already taken")
+)
+
 type Service struct {
-	db    *db.Connection
-	cache *cache.RedisClient
+	db         *db.Connection
+	cache      *cache.RedisClient
+	users      *cache.ReadThrough[*User]
+	keyring    *encryption.Keyring
+	login      *loginGuard
+	captcha    CaptchaVerifier
+	twoFactor  config.TwoFactorConfig
+	adminCIDRs []string
 }
 
+// Role is what a user may administer
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+// User is an account. TenantID is the X-Tenant-ID it was created under,
+// whose templates its notifications use. StatusReason says why an admin
+// last changed its status, e.g. why it was suspended. Country is an ISO 3166-1 alpha-2
+// code that customer segments can target. Timezone is an IANA name; daily
+// usage limits reset at midnight in it. Locale is a BCP 47 tag its
+// notifications are translated into. VATID is the EU VAT ID the
+// customer gave at checkout as a business, as VIES confirmed it at
+// VATCheckedAt. Metadata is the integrator's, see package metadata.
+// TwoFactorEnabled says whether signing in takes a TOTP code.
+// AllowedCIDRs are the addresses the user may sign in and use sessions
+// from, any when empty.
 type User struct {
-	ID        string    `json:"id" db:"id"`
-	Email     string    `json:"email" db:"email"`
-	Username  string    `json:"username" db:"username"`
-	Status    string    `json:"status" db:"status"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID               string                 `json:"id" db:"id"`
+	Email            string                 `json:"email" db:"email"`
+	Username         string                 `json:"username" db:"username"`
+	TenantID         string                 `json:"tenant_id,omitempty" db:"tenant_id"`
+	Status           Status                 `json:"status" db:"status"`
+	Role             Role                   `json:"role" db:"role"`
+	StatusReason     *string                `json:"status_reason,omitempty" db:"status_reason"`
+	StatusChangedAt  *time.Time             `json:"status_changed_at,omitempty" db:"status_changed_at"`
+	Country          *string                `json:"country,omitempty" db:"country"`
+	Timezone         *string                `json:"timezone,omitempty" db:"timezone"`
+	Locale           *string                `json:"locale,omitempty" db:"locale"`
+	VATID            *string                `json:"vat_id,omitempty" db:"vat_id"`
+	VATCheckedAt     *time.Time             `json:"vat_checked_at,omitempty" db:"vat_checked_at"`
+	Metadata         map[string]interface{} `json:"metadata" db:"metadata"`
+	TwoFactorEnabled bool                   `json:"two_factor_enabled" db:"-"`
+	AllowedCIDRs     []string               `json:"allowed_cidrs" db:"allowed_cidrs"`
+	CreatedAt        time.Time              `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time              `json:"updated_at" db:"updated_at"`
 }
 
 type CreateUserRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Username string `json:"username" binding:"required,min=3,max=50"`
+	Email    string                 `json:"email" binding:"required,email"`
+	Username string                 `json:"username" binding:"required,min=3,max=50"`
+	Country  *string                `json:"country" binding:"omitempty,iso3166_1_alpha2"`
+	Timezone *string                `json:"timezone" binding:"omitempty,timezone"`
+	Locale   *string                `json:"locale" binding:"omitempty,bcp47_language_tag"`
+	Metadata map[string]interface{} `json:"metadata"`
 }
 
+// UpdateUserRequest merges Metadata into the user's: keys are set, and keys
+// set to null removed.
 type UpdateUserRequest struct {
-	Email    *string `json:"email,omitempty"`
-	Username *string `json:"username,omitempty"`
-	Status   *string `json:"status,omitempty"`
+	Email        *string                `json:"email,omitempty"`
+	Username     *string                `json:"username,omitempty"`
+	Status       *Status                `json:"status,omitempty" binding:"omitempty,oneof=active suspended banned pending_verification"`
+	Role         *Role                  `json:"role,omitempty" binding:"omitempty,oneof=user admin"`
+	AllowedCIDRs *[]string              `json:"allowed_cidrs,omitempty" binding:"omitempty,max=100"`
+	Country      *string                `json:"country,omitempty" binding:"omitempty,iso3166_1_alpha2"`
+	Timezone     *string                `json:"timezone,omitempty" binding:"omitempty,timezone"`
+	Locale       *string                `json:"locale,omitempty" binding:"omitempty,bcp47_language_tag"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// UserSession is a signed-in user. VerifiedAt is when the session last
+// verified the user's second factor, at sign-in or stepping up.
+// AllowedCIDRs are the addresses it may be used from, any when empty.
 type UserSession struct {
-	UserID    string    `json:"user_id"`
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_at"`
+	UserID       string     `json:"user_id"`
+	Token        string     `json:"token"`
+	IssuedAt     time.Time  `json:"issued_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	VerifiedAt   *time.Time `json:"verified_at,omitempty"`
+	AllowedCIDRs []string   `json:"allowed_cidrs,omitempty"`
 }
 
-func NewService(db *db.Connection, cache *cache.RedisClient) *Service {
+// NewService creates the user service. Emails are stored encrypted when
+// keyring encrypts them; a nil keyring stores them as they are. cfg
+// throttles session creation, picks the CAPTCHA asked for after failures
+// and configures two-factor authentication and the admins' allowlist.
+func NewService(cfg *config.AuthConfig, db *db.Connection, cache *cache.RedisClient, keyring *encryption.Keyring) *Service {
 	return &Service{
-		db:    db,
-		cache: cache,
+		db:         db,
+		cache:      cache,
+		users:      newUserCache(cache),
+		keyring:    keyring,
+		login:      newLoginGuard(cfg.Lockout, cache),
+		captcha:    newCaptchaVerifier(cfg.Captcha),
+		twoFactor:  cfg.TwoFactor,
+		adminCIDRs: cfg.AdminCIDRs,
 	}
 }
 
+// SetCaptchaVerifier replaces the configured CAPTCHA provider, e.g. with
+// an in-house challenge. A nil verifier asks for no CAPTCHA.
+func (s *Service) SetCaptchaVerifier(v CaptchaVerifier) {
+	s.captcha = v
+}
+
 func (s *Service) CreateUser(c *gin.Context) {
 	var req CreateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -62,8 +153,16 @@ func (s *Service) CreateUser(c *gin.Context) {
 		telemetry.RecordUserOperation("create", "validation_error")
 		return
 	}
+	req.Email = normalizeEmail(req.Email)
+	userMetadata, err := metadata.Merge(nil, req.Metadata)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordUserOperation("create", "validation_error")
+		return
+	}
 
-	// Check if user already exists
+	// Check if user already exists. The unique constraints catch whatever
+	// races past these checks.
 	existing, err := s.getUserByEmail(c.Request.Context(), req.Email)
 	if err == nil && existing != nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "User with this email already exists"})
@@ -80,15 +179,26 @@ func (s *Service) CreateUser(c *gin.Context) {
 
 	// Create user
 	user := &User{
-		ID:        generateUUID(),
-		Email:     req.Email,
-		Username:  req.Username,
-		Status:    "active",
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:           generateUUID(),
+		Email:        req.Email,
+		Username:     req.Username,
+		TenantID:     middleware.TenantID(c),
+		Status:       StatusActive,
+		Role:         RoleUser,
+		AllowedCIDRs: []string{},
+		Country:      req.Country,
+		Timezone:     req.Timezone,
+		Locale:       req.Locale,
+		Metadata:     userMetadata,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
 	}
 
 	if err := s.createUser(c.Request.Context(), user); err != nil {
+		if respondTaken(c, err) {
+			telemetry.RecordUserOperation("create", "conflict")
+			return
+		}
 		logrus.Errorf("Failed to create user: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		telemetry.RecordUserOperation("create", "db_error")
@@ -109,27 +219,21 @@ func (s *Service) GetUser(c *gin.Context) {
 		return
 	}
 
-	// Try cache first
-	cached, err := s.getCachedUser(c.Request.Context(), id)
-	if err == nil && cached != nil {
-		c.JSON(http.StatusOK, cached)
-		telemetry.RecordUserOperation("get", "cache_hit")
-		return
-	}
-
-	// Get from database
-	user, err := s.getUserByID(c.Request.Context(), id)
+	user, hit, err := s.users.Get(c.Request.Context(), id, func(ctx context.Context) (*User, error) {
+		return s.getUserByID(ctx, id)
+	})
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		telemetry.RecordUserOperation("get", "not_found")
 		return
 	}
 
-	// Cache the user
-	s.cacheUser(c.Request.Context(), user)
-
 	c.JSON(http.StatusOK, user)
-	telemetry.RecordUserOperation("get", "success")
+	if hit {
+		telemetry.RecordUserOperation("get", "cache_hit")
+	} else {
+		telemetry.RecordUserOperation("get", "success")
+	}
 }
 
 func (s *Service) UpdateUser(c *gin.Context) {
@@ -156,67 +260,200 @@ func (s *Service) UpdateUser(c *gin.Context) {
 
 	// Update fields
 	if req.Email != nil {
-		user.Email = *req.Email
+		user.Email = normalizeEmail(*req.Email)
 	}
 	if req.Username != nil {
 		user.Username = *req.Username
 	}
-	if req.Status != nil {
+	if req.Country != nil {
+		user.Country = req.Country
+	}
+	if req.Timezone != nil {
+		user.Timezone = req.Timezone
+	}
+	if req.Locale != nil {
+		user.Locale = req.Locale
+	}
+	// Sessions are bound to the allowlist in force when they began
+	revoke := false
+	if req.Role != nil {
+		revoke = *req.Role != user.Role
+		user.Role = *req.Role
+	}
+	if req.AllowedCIDRs != nil {
+		if _, err := middleware.ParseCIDRs(*req.AllowedCIDRs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			telemetry.RecordUserOperation("update", "validation_error")
+			return
+		}
+		revoke = true
+		user.AllowedCIDRs = *req.AllowedCIDRs
+	}
+	if req.Metadata != nil {
+		merged, err := metadata.Merge(user.Metadata, req.Metadata)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			telemetry.RecordUserOperation("update", "validation_error")
+			return
+		}
+		user.Metadata = merged
+	}
+	statusChanged := req.Status != nil && *req.Status != user.Status
+	if statusChanged {
+		now := time.Now()
 		user.Status = *req.Status
+		user.StatusReason = nil
+		user.StatusChangedAt = &now
 	}
 
 	user.UpdatedAt = time.Now()
 
 	// Update in database
 	if err := s.updateUser(c.Request.Context(), user); err != nil {
+		if respondTaken(c, err) {
+			telemetry.RecordUserOperation("update", "conflict")
+			return
+		}
 		logrus.Errorf("Failed to update user: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		telemetry.RecordUserOperation("update", "db_error")
 		return
 	}
 
-	// Update cache
-	s.cacheUser(c.Request.Context(), user)
+	// Update cache, revoking sessions if the user was blocked
+	if statusChanged {
+		s.applyStatus(c.Request.Context(), user)
+	} else {
+		s.cacheUser(c.Request.Context(), user)
+	}
+	if revoke {
+		s.revokeSessions(c.Request.Context(), user.ID)
+	}
 
 	c.JSON(http.StatusOK, user)
 	telemetry.RecordUserOperation("update", "success")
 }
 
+// CreateSession issues a session token for a user. Failures are counted
+// per client IP and per user ID: past the configured limits the IP is
+// refused, the account locked for a growing time, or a CAPTCHA asked for in
+// captcha_token. Users with two-factor authentication give a totp_code or
+// recovery_code too.
 func (s *Service) CreateSession(c *gin.Context) {
 	var req struct {
-		UserID string `json:"user_id" binding:"required"`
+		UserID       string `json:"user_id" binding:"required"`
+		CaptchaToken string `json:"captcha_token"`
+		SecondFactor
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	ctx := c.Request.Context()
+	// Forwarded addresses only count from server.trusted_proxies, so a
+	// client can't dodge the per-IP lockout by sending its own
+	ip := c.ClientIP()
+	state := s.login.check(ctx, ip, req.UserID)
+	if state.retryAfter > 0 {
+		telemetry.RecordSecurityEvent(state.reason + "_refused")
+		c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(state.retryAfter)))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, try again later"})
+		return
+	}
+	if state.captcha && s.captcha != nil {
+		if req.CaptchaToken == "" {
+			telemetry.RecordSecurityEvent("captcha_required")
+			c.JSON(http.StatusPreconditionRequired, gin.H{"error": "CAPTCHA required", "captcha_required": true})
+			return
+		}
+		passed, err := s.captcha.Verify(ctx, req.CaptchaToken, ip)
+		if err != nil {
+			// Like the counters, the check fails open
+			logrus.Warnf("CAPTCHA verification unavailable: %v", err)
+		} else if !passed {
+			telemetry.RecordSecurityEvent("captcha_failed")
+			s.login.fail(ctx, ip, req.UserID)
+			c.JSON(http.StatusForbidden, gin.H{"error": "CAPTCHA verification failed", "captcha_required": true})
+			return
+		}
+	}
+
 	// Verify user exists
-	user, err := s.getUserByID(c.Request.Context(), req.UserID)
+	user, err := s.getUserByID(ctx, req.UserID)
 	if err != nil {
+		s.login.fail(ctx, ip, req.UserID)
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
+	if user.Status.Blocked() {
+		s.login.fail(ctx, ip, req.UserID)
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("User is %s", user.Status)})
+		return
+	}
+	if !middleware.IPAllowed(ip, s.allowedCIDRs(user)) {
+		telemetry.RecordSecurityEvent("ip_not_allowed")
+		logrus.Warnf("Refused session for user %s from %s outside their allowlist", user.ID, ip)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access from this address is not allowed"})
+		return
+	}
+	now := time.Now()
+	var verifiedAt *time.Time
+	switch {
+	case user.TwoFactorEnabled && req.SecondFactor.empty():
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Two-factor code required", "two_factor_required": true})
+		return
+	case user.TwoFactorEnabled:
+		if !s.checkSecondFactor(c, user.ID, req.SecondFactor, "session") {
+			return
+		}
+		verifiedAt = &now
+	case s.twoFactorRequired(user):
+		telemetry.RecordSecurityEvent("two_factor_enrollment_required")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for admins", "two_factor_enrollment_required": true})
+		return
+	}
+	s.login.succeed(ctx, ip, req.UserID)
 
 	// Generate session token
 	token := generateSessionToken()
 	session := &UserSession{
-		UserID:    user.ID,
-		Token:     token,
-		ExpiresAt: time.Now().Add(24 * time.Hour), // 24 hour session
+		UserID:       user.ID,
+		Token:        token,
+		IssuedAt:     now,
+		ExpiresAt:    now.Add(sessionTTL),
+		VerifiedAt:   verifiedAt,
+		AllowedCIDRs: s.allowedCIDRs(user),
 	}
 
-	// Store session in cache
-	s.cacheSession(c.Request.Context(), session)
+	if err := s.saveSession(ctx, session); err != nil {
+		logrus.Errorf("Failed to store session for user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
 
 	c.JSON(http.StatusOK, session)
 }
 
 func (s *Service) ValidateSession(c *gin.Context) {
+	session, status, problem := s.requestSession(c)
+	if session == nil {
+		c.AbortWithStatusJSON(status, gin.H{"error": problem})
+		return
+	}
+
+	// Set user ID in context for downstream handlers
+	c.Set("user_id", session.UserID)
+	c.Next()
+}
+
+// requestSession reads the session of the request's bearer token. Without
+// a valid one it returns nil and the status and message to refuse the
+// request with.
+func (s *Service) requestSession(c *gin.Context) (*UserSession, int, string) {
 	token := c.GetHeader("Authorization")
 	if token == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization token required"})
-		return
+		return nil, http.StatusUnauthorized, "Authorization token required"
 	}
 
 	// Remove "Bearer " prefix if present
@@ -224,138 +461,247 @@ func (s *Service) ValidateSession(c *gin.Context) {
 		token = token[7:]
 	}
 
-	// Get session from cache
-	session, err := s.getCachedSession(c.Request.Context(), token)
-	if err != nil || session == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
-		return
+	session, err := s.getSession(c.Request.Context(), token)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logrus.Errorf("Failed to read session: %v", err)
+		}
+		return nil, http.StatusUnauthorized, "Invalid or expired session"
 	}
 
 	// Check if session has expired
 	if time.Now().After(session.ExpiresAt) {
-		s.cache.Delete(c.Request.Context(), fmt.Sprintf("session:%s", token))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
-		return
+		s.dropSession(c.Request.Context(), token)
+		return nil, http.StatusUnauthorized, "Session expired"
 	}
 
-	// Set user ID in context for downstream handlers
-	c.Set("user_id", session.UserID)
-	c.Next()
+	// Sessions issued before the user was suspended or banned are revoked
+	if s.sessionRevoked(c.Request.Context(), session) {
+		s.dropSession(c.Request.Context(), token)
+		return nil, http.StatusUnauthorized, "Session revoked"
+	}
+
+	if !middleware.IPAllowed(c.ClientIP(), session.AllowedCIDRs) {
+		telemetry.RecordSecurityEvent("ip_not_allowed")
+		return nil, http.StatusForbidden, "Access from this address is not allowed"
+	}
+	return session, 0, ""
+}
+
+// allowedCIDRs is the allowlist a user's sessions are held to: their own,
+// else the admins' for admins
+func (s *Service) allowedCIDRs(user *User) []string {
+	if len(user.AllowedCIDRs) == 0 && user.Role == RoleAdmin {
+		return s.adminCIDRs
+	}
+	return user.AllowedCIDRs
 }
 
 // Helper methods
 func (s *Service) createUser(ctx context.Context, user *User) error {
 	query := `
-		INSERT INTO users (id, email, username, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (id, email, email_hash, username, status, country, metadata, created_at, updated_at,
+			timezone, tenant_id, locale)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 	`
-	_, err := s.db.ExecContext(ctx, query, user.ID, user.Email, user.Username,
-		user.Status, user.CreatedAt, user.UpdatedAt)
-	return err
+	encoded, err := metadata.Encode(user.Metadata)
+	if err != nil {
+		return err
+	}
+	email, emailHash, err := s.storedEmail(user.Email)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, query, user.ID, email, emailHash, user.Username,
+		string(user.Status), user.Country, encoded, user.CreatedAt, user.UpdatedAt, user.Timezone, user.TenantID,
+		user.Locale)
+	return uniqueError(err)
 }
 
-func (s *Service) getUserByID(ctx context.Context, id string) (*User, error) {
-	query := `
-		SELECT id, email, username, status, created_at, updated_at
-		FROM users WHERE id = $1
-	`
-	var user User
-	err := s.db.QueryRowContext(ctx, query, id).Scan(
-		&user.ID, &user.Email, &user.Username, &user.Status,
-		&user.CreatedAt, &user.UpdatedAt)
+// storedEmail returns an email as it is stored, with its blind index when
+// emails are encrypted
+func (s *Service) storedEmail(email string) (string, *string, error) {
+	if !s.keyring.EncryptsEmail() {
+		return email, nil, nil
+	}
+	sealed, err := s.keyring.Encrypt(email, encryption.UserEmail)
 	if err != nil {
+		return "", nil, err
+	}
+	hash := s.keyring.BlindIndex(email)
+	return sealed, &hash, nil
+}
+
+// userColumns lists the columns scanUser expects, in order
+const userColumns = `id, email, username, status, status_reason, status_changed_at,
+	country, metadata, created_at, updated_at, timezone, vat_id, vat_checked_at, tenant_id, locale,
+	role, totp_enabled_at IS NOT NULL, allowed_cidrs`
+
+func (s *Service) scanUser(scan func(dest ...interface{}) error) (*User, error) {
+	var user User
+	var data, cidrs []byte
+	if err := scan(&user.ID, &user.Email, &user.Username, &user.Status, &user.StatusReason,
+		&user.StatusChangedAt, &user.Country, &data, &user.CreatedAt, &user.UpdatedAt,
+		&user.Timezone, &user.VATID, &user.VATCheckedAt, &user.TenantID, &user.Locale,
+		&user.Role, &user.TwoFactorEnabled, &cidrs); err != nil {
+		return nil, err
+	}
+	var err error
+	if user.Metadata, err = metadata.Decode(data); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(cidrs, &user.AllowedCIDRs); err != nil {
+		return nil, err
+	}
+	if user.Email, err = s.keyring.Decrypt(user.Email, encryption.UserEmail); err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+func (s *Service) getUserByID(ctx context.Context, id string) (*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users WHERE id = $1
+	`
+	return s.scanUser(s.db.QueryRowContext(ctx, query, id).Scan)
+}
+
 func (s *Service) getUserByEmail(ctx context.Context, email string) (*User, error) {
+	email = normalizeEmail(email)
+	if s.keyring.EncryptsEmail() {
+		// Rows stored before emails were encrypted have no hash yet
+		query := `
+			SELECT ` + userColumns + `
+			FROM users WHERE email_hash = $1 OR (email_hash IS NULL AND email = $2)
+			LIMIT 1
+		`
+		return s.scanUser(s.db.QueryRowContext(ctx, query, s.keyring.BlindIndex(email), email).Scan)
+	}
+
 	query := `
-		SELECT id, email, username, status, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users WHERE email = $1
 	`
-	var user User
-	err := s.db.QueryRowContext(ctx, query, email).Scan(
-		&user.ID, &user.Email, &user.Username, &user.Status,
-		&user.CreatedAt, &user.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	// email is CITEXT, so this matches regardless of case
+	return s.scanUser(s.db.QueryRowContext(ctx, query, email).Scan)
 }
 
 func (s *Service) getUserByUsername(ctx context.Context, username string) (*User, error) {
 	query := `
-		SELECT id, email, username, status, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users WHERE username = $1
 	`
-	var user User
-	err := s.db.QueryRowContext(ctx, query, username).Scan(
-		&user.ID, &user.Email, &user.Username, &user.Status,
-		&user.CreatedAt, &user.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return s.scanUser(s.db.QueryRowContext(ctx, query, username).Scan)
 }
 
 func (s *Service) updateUser(ctx context.Context, user *User) error {
 	query := `
 		UPDATE users 
-		SET email = $1, username = $2, status = $3, updated_at = $4
-		WHERE id = $5
+		SET email = $1, email_hash = $2, username = $3, status = $4, status_reason = $5,
+			status_changed_at = $6, country = $7, metadata = $8, updated_at = $9, timezone = $11,
+			locale = $12, role = $13, allowed_cidrs = $14
+		WHERE id = $10
 	`
-	_, err := s.db.ExecContext(ctx, query, user.Email, user.Username,
-		user.Status, user.UpdatedAt, user.ID)
-	return err
-}
-
-func (s *Service) cacheUser(ctx context.Context, user *User) {
-	key := fmt.Sprintf("user:%s", user.ID)
-	data, err := json.Marshal(user)
+	encoded, err := metadata.Encode(user.Metadata)
 	if err != nil {
-		logrus.Errorf("Failed to marshal user for cache: %v", err)
-		return
+		return err
+	}
+	email, emailHash, err := s.storedEmail(user.Email)
+	if err != nil {
+		return err
+	}
+	cidrs, err := json.Marshal(allowlist(user.AllowedCIDRs))
+	if err != nil {
+		return err
 	}
+	_, err = s.db.ExecContext(ctx, query, email, emailHash, user.Username, string(user.Status),
+		user.StatusReason, user.StatusChangedAt, user.Country, encoded, user.UpdatedAt, user.ID,
+		user.Timezone, user.Locale, string(user.Role), string(cidrs))
+	return uniqueError(err)
+}
 
-	// Cache for 1 hour
-	if err := s.cache.Set(ctx, key, string(data), time.Hour); err != nil {
-		logrus.Errorf("Failed to cache user: %v", err)
+// uniqueError maps unique constraint violations on users to ErrEmailTaken
+// and ErrUsernameTaken
+func uniqueError(err error) error {
+	switch {
+	case db.IsUniqueViolation(err, emailConstraint), db.IsUniqueViolation(err, emailHashConstraint):
+		return ErrEmailTaken
+	case db.IsUniqueViolation(err, usernameConstraint):
+		return ErrUsernameTaken
 	}
+	return err
 }
 
-func (s *Service) getCachedUser(ctx context.Context, id string) (*User, error) {
-	key := fmt.Sprintf("user:%s", id)
-	data, err := s.cache.Get(ctx, key)
-	if err != nil {
-		return nil, err
+// respondTaken answers 409 if err is ErrEmailTaken or ErrUsernameTaken
+func respondTaken(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, ErrEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "User with this email already exists"})
+	case errors.Is(err, ErrUsernameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Username already taken"})
+	default:
+		return false
 	}
+	return true
+}
 
-	var user User
-	if err := json.Unmarshal([]byte(data), &user); err != nil {
-		return nil, err
+// allowlist stores a missing allowlist as an empty one
+func allowlist(cidrs []string) []string {
+	if cidrs == nil {
+		return []string{}
 	}
-	return &user, nil
+	return cidrs
+}
+
+// normalizeEmail trims and lowercases an email as stored
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// userCacheTTL is how long a user read by ID stays cached
+const userCacheTTL = time.Hour
+
+func newUserCache(redis *cache.RedisClient) *cache.ReadThrough[*User] {
+	return cache.NewReadThrough(redis, cache.ReadThroughOptions[*User]{
+		Domain: "user",
+		Prefix: "user",
+		TTL:    userCacheTTL,
+	})
+}
+
+func (s *Service) cacheUser(ctx context.Context, user *User) {
+	s.users.Set(ctx, user.ID, user)
 }
 
 func (s *Service) cacheSession(ctx context.Context, session *UserSession) {
-	key := fmt.Sprintf("session:%s", session.Token)
+	// Skipped while Redis is down; the database holds the session
+	if s.cache.Degraded() {
+		return
+	}
 	data, err := json.Marshal(session)
 	if err != nil {
 		logrus.Errorf("Failed to marshal session for cache: %v", err)
 		return
 	}
 
-	// Cache until session expires
-	ttl := session.ExpiresAt.Sub(time.Now())
-	if err := s.cache.Set(ctx, key, string(data), ttl); err != nil {
+	// Cache until session expires, for at most sessionCacheTTL
+	ttl := time.Until(session.ExpiresAt)
+	if ttl > sessionCacheTTL {
+		ttl = sessionCacheTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	if err := s.cache.Set(ctx, sessionKey(session.Token), string(data), ttl); err != nil {
 		logrus.Errorf("Failed to cache session: %v", err)
 	}
 }
 
 func (s *Service) getCachedSession(ctx context.Context, token string) (*UserSession, error) {
-	key := fmt.Sprintf("session:%s", token)
+	key := sessionKey(token)
 	data, err := s.cache.Get(ctx, key)
+	telemetry.RecordCacheLookup("session", err == nil)
 	if err != nil {
 		return nil, err
 	}
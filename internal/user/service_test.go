@@ -0,0 +1,19 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	assert.Equal(t, "alice@example.com", normalizeEmail("  Alice@Example.COM\n"))
+	assert.Equal(t, "bob@example.com", normalizeEmail("bob@example.com"))
+}
+
+func TestStatusBlocked(t *testing.T) {
+	assert.True(t, StatusSuspended.Blocked())
+	assert.True(t, StatusBanned.Blocked())
+	assert.False(t, StatusActive.Blocked())
+	assert.False(t, StatusPendingVerification.Blocked())
+}
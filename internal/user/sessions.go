@@ -0,0 +1,124 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sessionCacheTTL caps how long a session is cached; the database holds it
+// until it expires. A revocation made while Redis is down reaches sessions
+// cached before the outage when their cached copy expires.
+const sessionCacheTTL = 5 * time.Minute
+
+func sessionKey(token string) string {
+	return fmt.Sprintf("session:%s", token)
+}
+
+// hashSessionToken is how a session token is stored
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// saveSession stores a new session, dropping the user's expired ones, and
+// caches it
+func (s *Service) saveSession(ctx context.Context, session *UserSession) error {
+	cidrs, err := json.Marshal(session.AllowedCIDRs)
+	if err != nil {
+		return err
+	}
+	if session.AllowedCIDRs == nil {
+		cidrs = []byte("[]")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM user_sessions WHERE user_id = $1 AND expires_at < NOW()
+	`, session.UserID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_sessions (token_hash, user_id, issued_at, expires_at, verified_at, allowed_cidrs)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, hashSessionToken(session.Token), session.UserID, session.IssuedAt, session.ExpiresAt,
+		session.VerifiedAt, string(cidrs)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.cacheSession(ctx, session)
+	return nil
+}
+
+// markVerified records that the session verified its second factor at
+// session.VerifiedAt
+func (s *Service) markVerified(ctx context.Context, session *UserSession) error {
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE user_sessions SET verified_at = $2 WHERE token_hash = $1
+	`, hashSessionToken(session.Token), session.VerifiedAt); err != nil {
+		return err
+	}
+	s.cacheSession(ctx, session)
+	return nil
+}
+
+// getSession reads the session of token from the cache, else from the
+// database, which also serves sessions while Redis is down. It returns
+// sql.ErrNoRows when there is none or its user is suspended or banned.
+func (s *Service) getSession(ctx context.Context, token string) (*UserSession, error) {
+	if !s.cache.Degraded() {
+		if session, err := s.getCachedSession(ctx, token); err == nil {
+			return session, nil
+		}
+	}
+
+	var session UserSession
+	var cidrs []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT s.user_id, s.issued_at, s.expires_at, s.verified_at, s.allowed_cidrs
+		FROM user_sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = $1 AND u.status NOT IN ('suspended', 'banned')
+	`, hashSessionToken(token)).Scan(&session.UserID, &session.IssuedAt, &session.ExpiresAt,
+		&session.VerifiedAt, &cidrs)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(cidrs, &session.AllowedCIDRs); err != nil {
+		return nil, err
+	}
+	session.Token = token
+
+	s.cacheSession(ctx, &session)
+	return &session, nil
+}
+
+// dropSession deletes an expired or revoked session
+func (s *Service) dropSession(ctx context.Context, token string) {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM user_sessions WHERE token_hash = $1`, hashSessionToken(token)); err != nil {
+		logrus.Errorf("Failed to delete session: %v", err)
+	}
+	if !s.cache.Degraded() {
+		s.cache.Del(ctx, sessionKey(token))
+	}
+}
+
+// deleteSessions deletes every session the user holds, as revoking them
+// does in the database
+func (s *Service) deleteSessions(ctx context.Context, userID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM user_sessions WHERE user_id = $1`, userID)
+	return err
+}
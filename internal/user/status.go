@@ -0,0 +1,172 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Status is a user's account state
+type Status string
+
+const (
+	StatusActive              Status = "active"
+	StatusSuspended           Status = "suspended"
+	StatusBanned              Status = "banned"
+	StatusPendingVerification Status = "pending_verification"
+)
+
+// sessionTTL is how long a session lasts
+const sessionTTL = 24 * time.Hour
+
+var ErrStatusConflict = errors.New("user is not in a state that allows this")
+
+// Blocked reports whether the account is shut off: its sessions are
+// revoked, its billing is paused and the paywall denies it access.
+func (s Status) Blocked() bool {
+	return s == StatusSuspended || s == StatusBanned
+}
+
+// BlockedKey is set in the cache while a user is blocked, so checks that
+// serve cached results (such as the paywall's) can deny at once
+func BlockedKey(userID string) string {
+	return fmt.Sprintf("user:blocked:%s", userID)
+}
+
+// sessionsRevokedKey holds the time before which the user's sessions are
+// no longer valid
+func sessionsRevokedKey(userID string) string {
+	return fmt.Sprintf("sessions_revoked:%s", userID)
+}
+
+type SuspendUserRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// SuspendUser suspends an active or unverified user: their sessions are
+// revoked, renewals and dunning retries skip their subscriptions, and the
+// paywall denies them. Banned or already suspended users answer 409.
+func (s *Service) SuspendUser(c *gin.Context) {
+	var req SuspendUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		telemetry.RecordUserOperation("suspend", "validation_error")
+		return
+	}
+
+	s.transition(c, "suspend", StatusSuspended, &req.Reason, StatusActive, StatusPendingVerification)
+}
+
+// UnsuspendUser makes a suspended user active again. Their subscriptions
+// resume billing; a renewal that fell due meanwhile is charged by the next
+// renewal run. Users that are not suspended answer 409.
+func (s *Service) UnsuspendUser(c *gin.Context) {
+	s.transition(c, "unsuspend", StatusActive, nil, StatusSuspended)
+}
+
+// transition moves the user in the id path parameter to status if they are
+// currently in one of from, and responds with the updated user
+func (s *Service) transition(c *gin.Context, operation string, status Status, reason *string, from ...Status) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	user, err := s.setStatus(ctx, id, status, reason, from...)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		telemetry.RecordUserOperation(operation, "not_found")
+		return
+	case errors.Is(err, ErrStatusConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("User is %s", user.Status)})
+		telemetry.RecordUserOperation(operation, "invalid_status")
+		return
+	case err != nil:
+		logrus.Errorf("Failed to %s user %s: %v", operation, id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordUserOperation(operation, "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+	telemetry.RecordUserOperation(operation, "success")
+}
+
+// setStatus moves a user to status if they are in one of from. When they
+// aren't it returns the user as they are with ErrStatusConflict.
+func (s *Service) setStatus(ctx context.Context, id string, status Status, reason *string, from ...Status) (*User, error) {
+	allowed := make([]string, len(from))
+	for i, f := range from {
+		allowed[i] = string(f)
+	}
+
+	row := s.db.QueryRowContext(ctx, `
+		UPDATE users
+		SET status = $2, status_reason = $3, status_changed_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = ANY($4)
+		RETURNING `+userColumns+`
+	`, id, string(status), reason, allowed)
+	user, err := s.scanUser(row.Scan)
+	if err == sql.ErrNoRows {
+		current, err := s.getUserByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return current, ErrStatusConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.applyStatus(ctx, user)
+	return user, nil
+}
+
+// applyStatus brings the cache in line with a user's new status. Blocking
+// a user revokes the sessions they hold; billing is paused by the renewal
+// queries themselves, which skip blocked users.
+func (s *Service) applyStatus(ctx context.Context, user *User) {
+	if user.Status.Blocked() {
+		if err := s.cache.Set(ctx, BlockedKey(user.ID), string(user.Status), 0); err != nil {
+			logrus.Errorf("Failed to mark user %s blocked: %v", user.ID, err)
+		}
+		s.revokeSessions(ctx, user.ID)
+	} else if err := s.cache.Del(ctx, BlockedKey(user.ID)); err != nil {
+		logrus.Errorf("Failed to unblock user %s: %v", user.ID, err)
+	}
+	s.cacheUser(ctx, user)
+}
+
+// revokeSessions ends the sessions the user holds: their rows are deleted
+// and their cached copies refused
+func (s *Service) revokeSessions(ctx context.Context, userID string) {
+	if err := s.deleteSessions(ctx, userID); err != nil {
+		logrus.Errorf("Failed to delete sessions of user %s: %v", userID, err)
+	}
+	revokedAt := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := s.cache.Set(ctx, sessionsRevokedKey(userID), revokedAt, sessionTTL); err != nil {
+		logrus.Errorf("Failed to revoke sessions of user %s: %v", userID, err)
+	}
+}
+
+// sessionRevoked reports whether the session was issued before its user's
+// sessions were last revoked
+func (s *Service) sessionRevoked(ctx context.Context, session *UserSession) bool {
+	data, err := s.cache.Get(ctx, sessionsRevokedKey(session.UserID))
+	if err != nil {
+		return false
+	}
+	revokedAt, err := strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		return false
+	}
+	return session.IssuedAt.UnixNano() <= revokedAt
+}
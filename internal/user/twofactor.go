@@ -0,0 +1,595 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/encryption"
+	"scalable-paywall/internal/telemetry"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// TOTP parameters (RFC 6238), the defaults every authenticator app supports
+const (
+	totpPeriod      = 30
+	totpDigits      = 6
+	totpSecretBytes = 20
+)
+
+// recoveryCodeAlphabet has 32 characters, leaving out i, l, o and 1,
+// which are easily mistaken for others
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz023456789"
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// SecondFactor is a TOTP code from the user's authenticator app or one of
+// their recovery codes
+type SecondFactor struct {
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (f SecondFactor) empty() bool {
+	return f.TOTPCode == "" && f.RecoveryCode == ""
+}
+
+// TwoFactorEnrollment is the secret to add to an authenticator app, also as
+// an otpauth:// URL to show as a QR code
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+}
+
+// RecoveryCodes are handed out once, when two-factor authentication is
+// enabled or the codes regenerated; only their hashes are stored
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// totpState is a user's stored TOTP state
+type totpState struct {
+	secret   string
+	enabled  bool
+	lastStep int64
+}
+
+// EnrollTwoFactor starts two-factor enrollment for the user in the id path
+// parameter with a new secret, replacing any enrollment not yet
+// confirmed. Users with two-factor authentication enabled answer 409.
+func (s *Service) EnrollTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+	user, ok := s.twoFactorUser(c, "2fa_enroll")
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		telemetry.RecordUserOperation("2fa_enroll", "conflict")
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		logrus.Errorf("Failed to generate TOTP secret: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordUserOperation("2fa_enroll", "error")
+		return
+	}
+	sealed, err := s.keyring.Encrypt(secret, encryption.UserTOTPSecret)
+	if err != nil {
+		logrus.Errorf("Failed to encrypt TOTP secret of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordUserOperation("2fa_enroll", "error")
+		return
+	}
+	// The condition keeps a concurrent confirmation from being overwritten
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE users SET totp_secret = $2, totp_last_step = NULL, updated_at = NOW()
+		WHERE id = $1 AND totp_enabled_at IS NULL
+	`, user.ID, sealed)
+	if err != nil {
+		logrus.Errorf("Failed to store TOTP secret of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordUserOperation("2fa_enroll", "db_error")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		telemetry.RecordUserOperation("2fa_enroll", "conflict")
+		return
+	}
+
+	c.JSON(http.StatusCreated, TwoFactorEnrollment{
+		Secret:     secret,
+		OTPAuthURL: otpauthURL(s.twoFactor.Issuer, user.Email, secret),
+	})
+	telemetry.RecordUserOperation("2fa_enroll", "success")
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the user proves
+// their app holds the enrolled secret with a code from it, and returns
+// their recovery codes.
+func (s *Service) ConfirmTwoFactor(c *gin.Context) {
+	var req SecondFactor
+	if err := c.ShouldBindJSON(&req); err != nil || req.TOTPCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "totp_code is required"})
+		telemetry.RecordUserOperation("2fa_confirm", "validation_error")
+		return
+	}
+	ctx := c.Request.Context()
+	user, ok := s.twoFactorUser(c, "2fa_confirm")
+	if !ok {
+		return
+	}
+	state, err := s.getTOTP(ctx, user.ID)
+	if err != nil {
+		logrus.Errorf("Failed to read two-factor state of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordUserOperation("2fa_confirm", "db_error")
+		return
+	}
+	if state.enabled || state.secret == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "No two-factor enrollment to confirm"})
+		telemetry.RecordUserOperation("2fa_confirm", "conflict")
+		return
+	}
+	step, ok := matchTOTP(state.secret, req.TOTPCode, time.Now(), s.twoFactor.Skew, 0)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid two-factor code"})
+		telemetry.RecordUserOperation("2fa_confirm", "invalid_code")
+		return
+	}
+
+	codes, err := s.enableTwoFactor(ctx, user.ID, step)
+	if err != nil {
+		logrus.Errorf("Failed to enable two-factor authentication of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordUserOperation("2fa_confirm", "db_error")
+		return
+	}
+	s.forgetUser(ctx, user.ID)
+
+	telemetry.RecordSecurityEvent("two_factor_enabled")
+	c.JSON(http.StatusOK, RecoveryCodes{RecoveryCodes: codes})
+	telemetry.RecordUserOperation("2fa_confirm", "success")
+}
+
+// DisableTwoFactor turns two-factor authentication off, given a code or a
+// recovery code. Admins can't while it is required for them.
+func (s *Service) DisableTwoFactor(c *gin.Context) {
+	var req SecondFactor
+	if err := c.ShouldBindJSON(&req); err != nil || req.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "totp_code or recovery_code is required"})
+		telemetry.RecordUserOperation("2fa_disable", "validation_error")
+		return
+	}
+	ctx := c.Request.Context()
+	user, ok := s.twoFactorUser(c, "2fa_disable")
+	if !ok {
+		return
+	}
+	if !user.TwoFactorEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is not enabled"})
+		telemetry.RecordUserOperation("2fa_disable", "conflict")
+		return
+	}
+	if s.twoFactorRequired(user) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is required for admins"})
+		telemetry.RecordUserOperation("2fa_disable", "conflict")
+		return
+	}
+	if !s.checkSecondFactor(c, user.ID, req, "2fa_disable") {
+		return
+	}
+
+	if err := s.disableTwoFactor(ctx, user.ID); err != nil {
+		logrus.Errorf("Failed to disable two-factor authentication of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordUserOperation("2fa_disable", "db_error")
+		return
+	}
+	s.forgetUser(ctx, user.ID)
+
+	telemetry.RecordSecurityEvent("two_factor_disabled")
+	c.Status(http.StatusNoContent)
+	telemetry.RecordUserOperation("2fa_disable", "success")
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, given a code
+// or one of the old recovery codes.
+func (s *Service) RegenerateRecoveryCodes(c *gin.Context) {
+	var req SecondFactor
+	if err := c.ShouldBindJSON(&req); err != nil || req.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "totp_code or recovery_code is required"})
+		telemetry.RecordUserOperation("2fa_recovery_codes", "validation_error")
+		return
+	}
+	ctx := c.Request.Context()
+	user, ok := s.twoFactorUser(c, "2fa_recovery_codes")
+	if !ok {
+		return
+	}
+	if !user.TwoFactorEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is not enabled"})
+		telemetry.RecordUserOperation("2fa_recovery_codes", "conflict")
+		return
+	}
+	if !s.checkSecondFactor(c, user.ID, req, "2fa_recovery_codes") {
+		return
+	}
+
+	codes, err := s.regenerateRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		logrus.Errorf("Failed to regenerate recovery codes of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordUserOperation("2fa_recovery_codes", "db_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, RecoveryCodes{RecoveryCodes: codes})
+	telemetry.RecordUserOperation("2fa_recovery_codes", "success")
+}
+
+// StepUp re-verifies the second factor of the request's session, which
+// then passes RequireStepUp for the configured time.
+func (s *Service) StepUp(c *gin.Context) {
+	session, status, problem := s.requestSession(c)
+	if session == nil {
+		c.JSON(status, gin.H{"error": problem})
+		return
+	}
+	var req SecondFactor
+	if err := c.ShouldBindJSON(&req); err != nil || req.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "totp_code or recovery_code is required"})
+		telemetry.RecordUserOperation("2fa_step_up", "validation_error")
+		return
+	}
+	ctx := c.Request.Context()
+	user, err := s.getUserByID(ctx, session.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
+		telemetry.RecordUserOperation("2fa_step_up", "not_found")
+		return
+	}
+	if !user.TwoFactorEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is not enabled"})
+		telemetry.RecordUserOperation("2fa_step_up", "conflict")
+		return
+	}
+	if !s.checkSecondFactor(c, user.ID, req, "2fa_step_up") {
+		return
+	}
+
+	now := time.Now()
+	session.VerifiedAt = &now
+	if err := s.markVerified(ctx, session); err != nil {
+		logrus.Errorf("Failed to record step-up of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordUserOperation("2fa_step_up", "db_error")
+		return
+	}
+
+	telemetry.RecordSecurityEvent("step_up")
+	c.JSON(http.StatusOK, session)
+	telemetry.RecordUserOperation("2fa_step_up", "success")
+}
+
+// RequireStepUp guards sensitive operations such as refunds. The
+// request's session must have verified its user's second factor, at
+// sign-in or with StepUp, within the configured time; users without
+// two-factor authentication pass unless it is required of them.
+func (s *Service) RequireStepUp(c *gin.Context) {
+	session, status, problem := s.requestSession(c)
+	if session == nil {
+		c.AbortWithStatusJSON(status, gin.H{"error": problem})
+		return
+	}
+	user, err := s.getUserByID(c.Request.Context(), session.UserID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
+		return
+	}
+	if !user.TwoFactorEnabled {
+		if s.twoFactorRequired(user) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for admins"})
+			return
+		}
+		c.Set("user_id", session.UserID)
+		c.Next()
+		return
+	}
+	ttl := time.Duration(s.twoFactor.StepUpTTL) * time.Second
+	if session.VerifiedAt == nil || time.Since(*session.VerifiedAt) > ttl {
+		telemetry.RecordSecurityEvent("step_up_required")
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Step-up authentication required", "step_up_required": true})
+		return
+	}
+	c.Set("user_id", session.UserID)
+	c.Next()
+}
+
+// twoFactorRequired reports whether user may not go without two-factor
+// authentication
+func (s *Service) twoFactorRequired(user *User) bool {
+	return user.Role == RoleAdmin && s.twoFactor.RequireForAdmins
+}
+
+// twoFactorUser reads the user in the id path parameter, answering 404 if
+// there is none
+func (s *Service) twoFactorUser(c *gin.Context, operation string) (*User, bool) {
+	user, err := s.getUserByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		telemetry.RecordUserOperation(operation, "not_found")
+		return nil, false
+	}
+	return user, true
+}
+
+// checkSecondFactor verifies factor for userID, answering the request if
+// it doesn't pass. Wrong codes count as failed logins, so guessing them
+// is throttled like guessing accounts.
+func (s *Service) checkSecondFactor(c *gin.Context, userID string, factor SecondFactor, operation string) bool {
+	ctx := c.Request.Context()
+	ip := c.ClientIP()
+	if state := s.login.check(ctx, ip, userID); state.retryAfter > 0 {
+		telemetry.RecordSecurityEvent(state.reason + "_refused")
+		c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(state.retryAfter)))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, try again later"})
+		telemetry.RecordUserOperation(operation, "throttled")
+		return false
+	}
+	passed, err := s.verifySecondFactor(ctx, userID, factor)
+	if err != nil {
+		logrus.Errorf("Failed to verify second factor of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		telemetry.RecordUserOperation(operation, "db_error")
+		return false
+	}
+	if !passed {
+		telemetry.RecordSecurityEvent("two_factor_failed")
+		s.login.fail(ctx, ip, userID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		telemetry.RecordUserOperation(operation, "invalid_code")
+		return false
+	}
+	return true
+}
+
+// verifySecondFactor checks a TOTP code or recovery code of a user with
+// two-factor authentication enabled, using it up: a code's time step is
+// not accepted again, and a recovery code only once.
+func (s *Service) verifySecondFactor(ctx context.Context, userID string, factor SecondFactor) (bool, error) {
+	if factor.TOTPCode != "" {
+		state, err := s.getTOTP(ctx, userID)
+		if err != nil || !state.enabled {
+			return false, err
+		}
+		step, ok := matchTOTP(state.secret, factor.TOTPCode, time.Now(), s.twoFactor.Skew, state.lastStep)
+		if !ok {
+			return false, nil
+		}
+		// A concurrent request may have used the same code
+		result, err := s.db.ExecContext(ctx, `
+			UPDATE users SET totp_last_step = $2
+			WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)
+		`, userID, step)
+		if err != nil {
+			return false, err
+		}
+		n, _ := result.RowsAffected()
+		return n > 0, nil
+	}
+	if factor.RecoveryCode == "" {
+		return false, nil
+	}
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, hashRecoveryCode(factor.RecoveryCode))
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	telemetry.RecordSecurityEvent("recovery_code_used")
+	logrus.Infof("User %s signed in with a recovery code", userID)
+	return true, nil
+}
+
+func (s *Service) getTOTP(ctx context.Context, userID string) (*totpState, error) {
+	var state totpState
+	var secret sql.NullString
+	var lastStep sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_step
+		FROM users WHERE id = $1
+	`, userID).Scan(&secret, &state.enabled, &lastStep)
+	if err != nil {
+		return nil, err
+	}
+	if state.secret, err = s.keyring.Decrypt(secret.String, encryption.UserTOTPSecret); err != nil {
+		return nil, err
+	}
+	state.lastStep = lastStep.Int64
+	return &state, nil
+}
+
+// enableTwoFactor confirms the user's enrollment at the code's time step
+// and returns their new recovery codes
+func (s *Service) enableTwoFactor(ctx context.Context, userID string, step int64) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW()
+		WHERE id = $1
+	`, userID, step); err != nil {
+		return nil, err
+	}
+	codes, err := s.replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// regenerateRecoveryCodes replaces the user's recovery codes and returns
+// the new ones
+func (s *Service) regenerateRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := s.replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// disableTwoFactor drops the user's TOTP secret and recovery codes
+func (s *Service) disableTwoFactor(ctx context.Context, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceRecoveryCodes swaps the user's recovery codes for new ones
+func (s *Service) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string) ([]string, error) {
+	codes := make([]string, s.twoFactor.RecoveryCodes)
+	hashes := make([]string, len(codes))
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i], hashes[i] = code, hashRecoveryCode(code)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO recovery_codes (user_id, code_hash)
+		SELECT $1, UNNEST($2::text[])
+	`, userID, hashes)
+	return codes, err
+}
+
+// forgetUser drops the cached user after a change to its two-factor state
+func (s *Service) forgetUser(ctx context.Context, userID string) {
+	if err := s.users.Forget(ctx, userID); err != nil {
+		logrus.Warnf("Failed to drop cached user %s: %v", userID, err)
+	}
+}
+
+// generateTOTPSecret returns a random base32 TOTP secret
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// otpauthURL is the key URI authenticator apps import, usually from a QR
+// code
+func otpauthURL(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(totpPeriod))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// totpCode is the code for a secret key at a time step (RFC 4226 HOTP)
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// matchTOTP finds the time step within skew steps of now whose code is
+// code, skipping steps up to lastStep, which were used already
+func matchTOTP(secret, code string, now time.Time, skew int, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - int64(skew); step <= current+int64(skew); step++ {
+		if step <= lastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// generateRecoveryCode returns a code like "k7pqx-m2hvd"
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := make([]byte, 0, 11)
+	for i, c := range b {
+		if i == 5 {
+			code = append(code, '-')
+		}
+		code = append(code, recoveryCodeAlphabet[c&31])
+	}
+	return string(code), nil
+}
+
+// hashRecoveryCode hashes a recovery code as typed, ignoring case, spaces
+// and dashes
+func hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
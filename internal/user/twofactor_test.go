@@ -0,0 +1,84 @@
+package user
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfcSecret)
+	require.NoError(t, err)
+	// The RFC's 8-digit codes, cut to their last 6 digits
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		assert.Equal(t, want, totpCode(key, unix/totpPeriod), unix)
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := now.Unix() / totpPeriod
+
+	matched, ok := matchTOTP(rfcSecret, "081804", now, 1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, step, matched)
+
+	// A code from the previous step passes within the skew only
+	_, ok = matchTOTP(rfcSecret, "081804", now.Add(totpPeriod*time.Second), 1, 0)
+	assert.True(t, ok)
+	_, ok = matchTOTP(rfcSecret, "081804", now.Add(totpPeriod*time.Second), 0, 0)
+	assert.False(t, ok)
+
+	// A step used already isn't accepted again
+	_, ok = matchTOTP(rfcSecret, "081804", now, 1, step)
+	assert.False(t, ok)
+
+	_, ok = matchTOTP(rfcSecret, "081805", now, 1, 0)
+	assert.False(t, ok)
+	_, ok = matchTOTP(rfcSecret, "81804", now, 1, 0)
+	assert.False(t, ok)
+	_, ok = matchTOTP("not base32!", "081804", now, 1, 0)
+	assert.False(t, ok)
+}
+
+func TestOTPAuthURL(t *testing.T) {
+	u, err := url.Parse(otpauthURL("Subscription API", "jane@example.com", rfcSecret))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Subscription API:jane@example.com", u.Path)
+	assert.Equal(t, rfcSecret, u.Query().Get("secret"))
+	assert.Equal(t, "Subscription API", u.Query().Get("issuer"))
+	assert.Equal(t, "6", u.Query().Get("digits"))
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	require.NoError(t, err)
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+	assert.Len(t, key, totpSecretBytes)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	code, err := generateRecoveryCode()
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[a-hj-km-np-z02-9]{5}-[a-hj-km-np-z02-9]{5}$`), code)
+
+	assert.Equal(t, hashRecoveryCode("k7pqx-m2hvd"), hashRecoveryCode(" K7PQX M2HVD"))
+	assert.Equal(t, hashRecoveryCode("k7pqx-m2hvd"), hashRecoveryCode("k7pqxm2hvd"))
+	assert.NotEqual(t, hashRecoveryCode("k7pqx-m2hvd"), hashRecoveryCode("k7pqx-m2hve"))
+}
@@ -0,0 +1,72 @@
+// Package vat checks EU VAT IDs with the VIES service and decides whether a
+// sale to a business is reverse charged.
+package vat
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Tax treatments of a sale. A reverse charged sale carries no VAT; the
+// business buying accounts for it in its own country.
+const (
+	TreatmentStandard      = "standard"
+	TreatmentReverseCharge = "reverse_charge"
+)
+
+// ErrMalformed is returned for a VAT ID that isn't an EU member state
+// prefix followed by 2 to 12 letters or digits.
+var ErrMalformed = errors.New("VAT ID is malformed")
+
+// memberStates maps VAT ID prefixes to ISO country codes. They match
+// except for Greece, whose VAT IDs start with EL.
+var memberStates = map[string]string{
+	"AT": "AT", "BE": "BE", "BG": "BG", "CY": "CY", "CZ": "CZ", "DE": "DE",
+	"DK": "DK", "EE": "EE", "EL": "GR", "ES": "ES", "FI": "FI", "FR": "FR",
+	"HR": "HR", "HU": "HU", "IE": "IE", "IT": "IT", "LT": "LT", "LU": "LU",
+	"LV": "LV", "MT": "MT", "NL": "NL", "PL": "PL", "PT": "PT", "RO": "RO",
+	"SE": "SE", "SI": "SI", "SK": "SK",
+}
+
+var vatNumber = regexp.MustCompile(`^[0-9A-Z+*]{2,12}$`)
+
+// ID is a VAT ID split into its member state prefix and number
+type ID struct {
+	Prefix string
+	Number string
+}
+
+// Parse reads a VAT ID as customers write it, ignoring case, spaces, dots
+// and dashes, e.g. "de 123.456.789".
+func Parse(raw string) (ID, error) {
+	id := strings.ToUpper(strings.NewReplacer(" ", "", ".", "", "-", "").Replace(raw))
+	if len(id) < 2 {
+		return ID{}, ErrMalformed
+	}
+	prefix, number := id[:2], id[2:]
+	if _, ok := memberStates[prefix]; !ok || !vatNumber.MatchString(number) {
+		return ID{}, ErrMalformed
+	}
+	return ID{Prefix: prefix, Number: number}, nil
+}
+
+func (id ID) String() string {
+	return id.Prefix + id.Number
+}
+
+// Country is the ISO code of the member state that issued the ID
+func (id ID) Country() string {
+	return memberStates[id.Prefix]
+}
+
+// Treatment is how a sale by a seller registered in sellerCountry to the
+// holder of a valid VAT ID from buyerCountry is taxed: reverse charged
+// across member states, standard within one. A seller without a country
+// or a buyer without a valid ID is always taxed as standard.
+func Treatment(sellerCountry, buyerCountry string) string {
+	if sellerCountry == "" || buyerCountry == "" || buyerCountry == sellerCountry {
+		return TreatmentStandard
+	}
+	return TreatmentReverseCharge
+}
@@ -0,0 +1,32 @@
+package vat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	id, err := Parse("de 123.456-789")
+	require.NoError(t, err)
+	assert.Equal(t, ID{Prefix: "DE", Number: "123456789"}, id)
+	assert.Equal(t, "DE123456789", id.String())
+	assert.Equal(t, "DE", id.Country())
+
+	greek, err := Parse("EL094259216")
+	require.NoError(t, err)
+	assert.Equal(t, "GR", greek.Country())
+
+	for _, raw := range []string{"", "D", "GB123456789", "US12345", "DE1", "DE1234567890123", "DE12345_678"} {
+		_, err := Parse(raw)
+		assert.ErrorIs(t, err, ErrMalformed, raw)
+	}
+}
+
+func TestTreatment(t *testing.T) {
+	assert.Equal(t, TreatmentReverseCharge, Treatment("DE", "FR"))
+	assert.Equal(t, TreatmentStandard, Treatment("DE", "DE"))
+	assert.Equal(t, TreatmentStandard, Treatment("DE", ""))
+	assert.Equal(t, TreatmentStandard, Treatment("", "FR"))
+}
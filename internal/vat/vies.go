@@ -0,0 +1,122 @@
+package vat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+)
+
+// ErrUnavailable is returned when VIES or the member state's own registry
+// it asks can't answer right now; the ID may still be valid.
+var ErrUnavailable = errors.New("VIES is unavailable")
+
+// unavailableErrors are the VIES errors that go away when asked again later
+var unavailableErrors = map[string]bool{
+	"SERVICE_UNAVAILABLE":       true,
+	"MS_UNAVAILABLE":            true,
+	"TIMEOUT":                   true,
+	"MS_MAX_CONCURRENT_REQ":     true,
+	"GLOBAL_MAX_CONCURRENT_REQ": true,
+}
+
+// Check is VIES' answer for a VAT ID. Name and Address are the registered
+// business's, where its member state shares them.
+type Check struct {
+	VATID     string    `json:"vat_id"`
+	Country   string    `json:"country"`
+	Valid     bool      `json:"valid"`
+	Name      string    `json:"name,omitempty"`
+	Address   string    `json:"address,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Client asks the VIES REST API whether VAT IDs are registered
+type Client struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewClient(cfg config.VATConfig) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(cfg.VIESURL, "/"),
+		client:  &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+	}
+}
+
+type viesRequest struct {
+	CountryCode string `json:"countryCode"`
+	VATNumber   string `json:"vatNumber"`
+}
+
+// viesResponse covers both answers of check-vat-number: the check, or a
+// list of errors when it could not be made
+type viesResponse struct {
+	Valid         bool   `json:"valid"`
+	Name          string `json:"name"`
+	Address       string `json:"address"`
+	UserError     string `json:"userError"`
+	ErrorWrappers []struct {
+		Error string `json:"error"`
+	} `json:"errorWrappers"`
+}
+
+// Check asks VIES about id. An ID VIES doesn't know is returned as a check
+// that isn't valid; ErrUnavailable means it should be asked again later.
+func (c *Client) Check(ctx context.Context, id ID) (*Check, error) {
+	body, err := json.Marshal(viesRequest{CountryCode: id.Prefix, VATNumber: id.Number})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/check-vat-number", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	var answer viesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, fmt.Errorf("%w: status %d", ErrUnavailable, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("failed to decode VIES response: %w", err)
+	}
+	for _, wrapper := range answer.ErrorWrappers {
+		if unavailableErrors[wrapper.Error] {
+			return nil, fmt.Errorf("%w: %s", ErrUnavailable, wrapper.Error)
+		}
+		if wrapper.Error == "INVALID_INPUT" {
+			return &Check{VATID: id.String(), Country: id.Country(), CheckedAt: time.Now()}, nil
+		}
+		return nil, fmt.Errorf("VIES returned error %s", wrapper.Error)
+	}
+	if unavailableErrors[answer.UserError] {
+		return nil, fmt.Errorf("%w: %s", ErrUnavailable, answer.UserError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("VIES returned status %d", resp.StatusCode)
+	}
+
+	check := &Check{VATID: id.String(), Country: id.Country(), Valid: answer.Valid, CheckedAt: time.Now()}
+	// Member states that don't share them answer "---"
+	if answer.Valid && answer.Name != "---" {
+		check.Name = answer.Name
+	}
+	if answer.Valid && answer.Address != "---" {
+		check.Address = answer.Address
+	}
+	return check, nil
+}
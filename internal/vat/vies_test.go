@@ -0,0 +1,63 @@
+package vat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func viesServer(t *testing.T, status int, answer string) *Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/check-vat-number", r.URL.Path)
+		var req viesRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, viesRequest{CountryCode: "FR", VATNumber: "40303265045"}, req)
+		w.WriteHeader(status)
+		w.Write([]byte(answer))
+	}))
+	t.Cleanup(server.Close)
+	return NewClient(config.VATConfig{VIESURL: server.URL + "/", Timeout: 5})
+}
+
+func TestCheck(t *testing.T) {
+	id := ID{Prefix: "FR", Number: "40303265045"}
+
+	client := viesServer(t, http.StatusOK, `{"valid": true, "name": "SA ODIGEO", "address": "---", "userError": "VALID"}`)
+	check, err := client.Check(context.Background(), id)
+	require.NoError(t, err)
+	assert.True(t, check.Valid)
+	assert.Equal(t, "FR40303265045", check.VATID)
+	assert.Equal(t, "FR", check.Country)
+	assert.Equal(t, "SA ODIGEO", check.Name)
+	assert.Empty(t, check.Address)
+
+	client = viesServer(t, http.StatusOK, `{"valid": false, "name": "---", "userError": "INVALID"}`)
+	check, err = client.Check(context.Background(), id)
+	require.NoError(t, err)
+	assert.False(t, check.Valid)
+	assert.Empty(t, check.Name)
+
+	client = viesServer(t, http.StatusBadRequest, `{"actionSucceed": false, "errorWrappers": [{"error": "INVALID_INPUT"}]}`)
+	check, err = client.Check(context.Background(), id)
+	require.NoError(t, err)
+	assert.False(t, check.Valid)
+
+	client = viesServer(t, http.StatusInternalServerError, `{"actionSucceed": false, "errorWrappers": [{"error": "MS_UNAVAILABLE"}]}`)
+	_, err = client.Check(context.Background(), id)
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	client = viesServer(t, http.StatusOK, `{"valid": false, "userError": "MS_MAX_CONCURRENT_REQ"}`)
+	_, err = client.Check(context.Background(), id)
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	client = viesServer(t, http.StatusBadGateway, `<html>Bad Gateway</html>`)
+	_, err = client.Check(context.Background(), id)
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
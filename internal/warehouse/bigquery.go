@@ -0,0 +1,128 @@
+package warehouse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"scalable-paywall/internal/config"
+
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// bigQueryTypes are the BigQuery types of the column types
+var bigQueryTypes = map[ColumnType]string{
+	String:    "STRING",
+	Timestamp: "TIMESTAMP",
+	Numeric:   "NUMERIC",
+	Int:       "INT64",
+	Bool:      "BOOL",
+	JSON:      "JSON",
+}
+
+// bigQuerySink streams rows into a BigQuery dataset using application
+// default credentials. Each row carries its Row.ID as insert ID, so
+// BigQuery drops a retried insert of it made soon after the first.
+type bigQuerySink struct {
+	service   *bigquery.Service
+	projectID string
+	dataset   string
+}
+
+func newBigQuerySink(ctx context.Context, cfg config.BigQueryWarehouseConfig) (*bigQuerySink, error) {
+	service, err := bigquery.NewService(ctx, option.WithScopes(bigquery.BigqueryInsertdataScope, bigquery.BigqueryScope))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery client: %w", err)
+	}
+	return &bigQuerySink{service: service, projectID: cfg.ProjectID, dataset: cfg.Dataset}, nil
+}
+
+func (s *bigQuerySink) EnsureTable(ctx context.Context, t Table) error {
+	existing, err := s.service.Tables.Get(s.projectID, s.dataset, t.Name).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if err != nil && (!errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound) {
+		return err
+	}
+	if existing == nil {
+		_, err := s.service.Tables.Insert(s.projectID, s.dataset, &bigquery.Table{
+			TableReference: &bigquery.TableReference{ProjectId: s.projectID, DatasetId: s.dataset, TableId: t.Name},
+			Schema:         &bigquery.TableSchema{Fields: bigQueryFields(t.columns())},
+		}).Context(ctx).Do()
+		return err
+	}
+
+	var fields []*bigquery.TableFieldSchema
+	if existing.Schema != nil {
+		fields = existing.Schema.Fields
+	}
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	missing := missingColumns(t.columns(), names)
+	if len(missing) == 0 {
+		return nil
+	}
+	// New columns can only be added as NULLABLE, which is the default
+	_, err = s.service.Tables.Patch(s.projectID, s.dataset, t.Name, &bigquery.Table{
+		Schema: &bigquery.TableSchema{Fields: append(fields, bigQueryFields(missing)...)},
+	}).Context(ctx).Do()
+	return err
+}
+
+func (s *bigQuerySink) Insert(ctx context.Context, t Table, rows []Row) error {
+	req := &bigquery.TableDataInsertAllRequest{Rows: make([]*bigquery.TableDataInsertAllRequestRows, 0, len(rows))}
+	for _, row := range rows {
+		values, err := bigQueryValues(t, row)
+		if err != nil {
+			return err
+		}
+		req.Rows = append(req.Rows, &bigquery.TableDataInsertAllRequestRows{InsertId: row.ID, Json: values})
+	}
+	resp, err := s.service.Tabledata.InsertAll(s.projectID, s.dataset, t.Name, req).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	if len(resp.InsertErrors) > 0 {
+		first := resp.InsertErrors[0]
+		reason := ""
+		if len(first.Errors) > 0 {
+			reason = first.Errors[0].Message
+		}
+		return fmt.Errorf("%d rows rejected, row %d: %s", len(resp.InsertErrors), first.Index, reason)
+	}
+	return nil
+}
+
+// bigQueryValues encodes a row for insertAll, which takes JSON columns as
+// JSON text
+func bigQueryValues(t Table, row Row) (map[string]bigquery.JsonValue, error) {
+	values := make(map[string]bigquery.JsonValue, len(row.Values))
+	for _, c := range t.columns() {
+		value, ok := row.Values[c.Name]
+		if !ok || value == nil {
+			continue
+		}
+		if c.Type == JSON {
+			text, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			value = string(text)
+		}
+		values[c.Name] = value
+	}
+	return values, nil
+}
+
+func bigQueryFields(columns []Column) []*bigquery.TableFieldSchema {
+	fields := make([]*bigquery.TableFieldSchema, 0, len(columns))
+	for _, c := range columns {
+		fields = append(fields, &bigquery.TableFieldSchema{Name: c.Name, Type: bigQueryTypes[c.Type], Mode: "NULLABLE"})
+	}
+	return fields
+}
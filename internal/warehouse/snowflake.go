@@ -0,0 +1,249 @@
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+)
+
+// snowflakeTypes are the Snowflake types of the column types
+var snowflakeTypes = map[ColumnType]string{
+	String:    "VARCHAR",
+	Timestamp: "TIMESTAMP_TZ",
+	Numeric:   "NUMBER(19,4)",
+	Int:       "NUMBER(38,0)",
+	Bool:      "BOOLEAN",
+	JSON:      "VARIANT",
+}
+
+// snowflakeSink runs statements through the Snowflake SQL API, signed in
+// with a JWT from the user's key pair.
+type snowflakeSink struct {
+	client  *http.Client
+	baseURL string
+	cfg     config.SnowflakeWarehouseConfig
+	key     *rsa.PrivateKey
+}
+
+func newSnowflakeSink(cfg config.SnowflakeWarehouseConfig) (*snowflakeSink, error) {
+	key, err := parseRSAKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid warehouse.snowflake.private_key: %w", err)
+	}
+	return &snowflakeSink{
+		client:  &http.Client{Timeout: 90 * time.Second},
+		baseURL: fmt.Sprintf("https://%s.snowflakecomputing.com", cfg.Account),
+		cfg:     cfg,
+		key:     key,
+	}, nil
+}
+
+func (s *snowflakeSink) EnsureTable(ctx context.Context, t Table) error {
+	defs := make([]string, 0, len(t.columns()))
+	for _, c := range t.columns() {
+		defs = append(defs, c.Name+" "+snowflakeTypes[c.Type])
+	}
+	if _, err := s.exec(ctx, "CREATE TABLE IF NOT EXISTS "+t.Name+" ("+strings.Join(defs, ", ")+")"); err != nil {
+		return err
+	}
+
+	data, err := s.exec(ctx, "SELECT column_name FROM information_schema.columns WHERE table_schema = ? AND table_name = ?",
+		strings.ToUpper(s.cfg.Schema), strings.ToUpper(t.Name))
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(data))
+	for _, row := range data {
+		if len(row) > 0 && row[0] != nil {
+			names = append(names, *row[0])
+		}
+	}
+	for _, c := range missingColumns(t.columns(), names) {
+		if _, err := s.exec(ctx, "ALTER TABLE "+t.Name+" ADD COLUMN "+c.Name+" "+snowflakeTypes[c.Type]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Insert sends the batch as one JSON array and spreads it into rows in
+// Snowflake, so a batch is a single statement with a single binding.
+func (s *snowflakeSink) Insert(ctx context.Context, t Table, rows []Row) error {
+	values := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		values = append(values, row.Values)
+	}
+	batch, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, snowflakeInsert(t), string(batch))
+	return err
+}
+
+// snowflakeInsert inserts the rows of a JSON array bound as its parameter
+func snowflakeInsert(t Table) string {
+	names := make([]string, 0, len(t.columns()))
+	exprs := make([]string, 0, len(t.columns()))
+	for _, c := range t.columns() {
+		names = append(names, c.Name)
+		expr := `v.value:"` + c.Name + `"`
+		if c.Type != JSON {
+			expr += "::" + snowflakeTypes[c.Type]
+		}
+		exprs = append(exprs, expr)
+	}
+	return "INSERT INTO " + t.Name + " (" + strings.Join(names, ", ") + ") SELECT " + strings.Join(exprs, ", ") +
+		" FROM TABLE(FLATTEN(INPUT => PARSE_JSON(?))) v"
+}
+
+type snowflakeBinding struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type snowflakeStatement struct {
+	Statement string                      `json:"statement"`
+	Timeout   int                         `json:"timeout"`
+	Database  string                      `json:"database"`
+	Schema    string                      `json:"schema"`
+	Warehouse string                      `json:"warehouse"`
+	Role      string                      `json:"role,omitempty"`
+	Bindings  map[string]snowflakeBinding `json:"bindings,omitempty"`
+}
+
+type snowflakeResult struct {
+	Code               string      `json:"code"`
+	Message            string      `json:"message"`
+	StatementHandle    string      `json:"statementHandle"`
+	StatementStatusURL string      `json:"statementStatusUrl"`
+	Data               [][]*string `json:"data"`
+}
+
+// exec runs a statement with text bindings and returns its result rows,
+// waiting for it when Snowflake answers that it is still running
+func (s *snowflakeSink) exec(ctx context.Context, statement string, bindings ...string) ([][]*string, error) {
+	body := snowflakeStatement{
+		Statement: statement,
+		Timeout:   60,
+		Database:  s.cfg.Database,
+		Schema:    s.cfg.Schema,
+		Warehouse: s.cfg.Warehouse,
+		Role:      s.cfg.Role,
+	}
+	if len(bindings) > 0 {
+		body.Bindings = make(map[string]snowflakeBinding, len(bindings))
+		for i, value := range bindings {
+			body.Bindings[fmt.Sprint(i+1)] = snowflakeBinding{Type: "TEXT", Value: value}
+		}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	result, status, err := s.call(ctx, http.MethodPost, "/api/v2/statements", payload)
+	for err == nil && status == http.StatusAccepted {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+		result, status, err = s.call(ctx, http.MethodGet, result.StatementStatusURL, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("snowflake returned %d: %s %s", status, result.Code, result.Message)
+	}
+	return result.Data, nil
+}
+
+func (s *snowflakeSink) call(ctx context.Context, method, path string, payload []byte) (*snowflakeResult, int, error) {
+	token, err := s.token(time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Snowflake-Authorization-Token-Type", "KEYPAIR_JWT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	result := &snowflakeResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to decode snowflake response: %w", err)
+	}
+	return result, resp.StatusCode, nil
+}
+
+// token is the key-pair JWT Snowflake expects: issued by the account and
+// user qualified with the public key's fingerprint, valid for an hour
+func (s *snowflakeSink) token(now time.Time) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := sha256.Sum256(der)
+	// The account is named without its region or cloud
+	account := strings.ToUpper(strings.SplitN(s.cfg.Account, ".", 2)[0])
+	subject := account + "." + strings.ToUpper(s.cfg.User)
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": subject + ".SHA256:" + base64.StdEncoding.EncodeToString(fingerprint[:]),
+		"sub": subject,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(nil, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func parseRSAKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return key, nil
+}
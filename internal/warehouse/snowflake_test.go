@@ -0,0 +1,86 @@
+package warehouse
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"scalable-paywall/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSnowflakeSink(t *testing.T) *snowflakeSink {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	sink, err := newSnowflakeSink(config.SnowflakeWarehouseConfig{
+		Account:    "acme-eu.eu-central-1",
+		User:       "paywall_sync",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		Database:   "ANALYTICS",
+		Schema:     "PAYWALL",
+		Warehouse:  "LOAD_WH",
+	})
+	require.NoError(t, err)
+	return sink
+}
+
+func TestSnowflakeToken(t *testing.T) {
+	sink := newTestSnowflakeSink(t)
+	now := time.Unix(1767225600, 0)
+
+	token, err := sink.token(now)
+	require.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	assert.Equal(t, "ACME-EU.PAYWALL_SYNC", claims["sub"])
+	assert.True(t, strings.HasPrefix(claims["iss"].(string), "ACME-EU.PAYWALL_SYNC.SHA256:"))
+	assert.Equal(t, float64(now.Add(time.Hour).Unix()), claims["exp"])
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(&sink.key.PublicKey, crypto.SHA256, digest[:], signature))
+}
+
+func TestSnowflakeInsert(t *testing.T) {
+	var got snowflakeStatement
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/statements", r.URL.Path)
+		assert.Equal(t, "KEYPAIR_JWT", r.Header.Get("X-Snowflake-Authorization-Token-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.Write([]byte(`{"code":"090001","message":"Statement executed successfully.","data":[["1"]]}`))
+	}))
+	defer server.Close()
+	sink := newTestSnowflakeSink(t)
+	sink.baseURL = server.URL
+
+	rows := []Row{{ID: "evt-1@2026-03-04T05:06:07Z", Values: map[string]interface{}{"id": "evt-1", "to_plan_id": "plan-pro"}}}
+	require.NoError(t, sink.Insert(context.Background(), Tables["plan_changes"], rows))
+
+	assert.Equal(t, `INSERT INTO plan_changes (id, subscription_id, from_plan_id, to_plan_id, created_at, _synced_at) `+
+		`SELECT v.value:"id"::VARCHAR, v.value:"subscription_id"::VARCHAR, v.value:"from_plan_id"::VARCHAR, v.value:"to_plan_id"::VARCHAR, `+
+		`v.value:"created_at"::TIMESTAMP_TZ, v.value:"_synced_at"::TIMESTAMP_TZ FROM TABLE(FLATTEN(INPUT => PARSE_JSON(?))) v`, got.Statement)
+	assert.Equal(t, "LOAD_WH", got.Warehouse)
+	assert.JSONEq(t, `[{"id":"evt-1","to_plan_id":"plan-pro"}]`, got.Bindings["1"].Value)
+}
@@ -0,0 +1,211 @@
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"scalable-paywall/internal/config"
+	"scalable-paywall/internal/db"
+	"scalable-paywall/internal/jobs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JobSync replicates new and changed rows to the warehouse.
+const JobSync = "warehouse.sync"
+
+// maxBatchesPerRun bounds one run of a table so a first sync of a large
+// table is worked off over several runs instead of holding a worker
+const maxBatchesPerRun = 100
+
+// Sink is a warehouse the sync writes to.
+type Sink interface {
+	// EnsureTable creates t, or adds the columns it lacks
+	EnsureTable(ctx context.Context, t Table) error
+	// Insert appends rows to t
+	Insert(ctx context.Context, t Table, rows []Row) error
+}
+
+// Row is one version of a source row: ID names the version so a sink can
+// drop a retried insert of it, and Values holds it by column name.
+type Row struct {
+	ID     string
+	Values map[string]interface{}
+}
+
+// NewSink builds the Sink selected by cfg.Provider. It returns a nil Sink
+// when no warehouse is configured.
+func NewSink(ctx context.Context, cfg config.WarehouseConfig) (Sink, error) {
+	switch strings.ToLower(cfg.Provider) {
+	case "":
+		return nil, nil
+	case "bigquery":
+		return newBigQuerySink(ctx, cfg.BigQuery)
+	case "snowflake":
+		return newSnowflakeSink(cfg.Snowflake)
+	default:
+		return nil, fmt.Errorf("unknown warehouse provider %q", cfg.Provider)
+	}
+}
+
+// watermark is the last row synced of a table
+type watermark struct {
+	at time.Time
+	id string
+}
+
+// syncStart is the watermark of a table not synced yet
+var syncStart = watermark{at: time.Unix(0, 0).UTC(), id: "00000000-0000-0000-0000-000000000000"}
+
+// Syncer replicates tables to a warehouse incrementally: each run appends
+// the rows changed since the watermark the last one stopped at.
+type Syncer struct {
+	conn *db.Connection
+	sink Sink
+	cfg  config.WarehouseConfig
+}
+
+func NewSyncer(conn *db.Connection, sink Sink, cfg config.WarehouseConfig) *Syncer {
+	return &Syncer{conn: conn, sink: sink, cfg: cfg}
+}
+
+// RegisterJobs schedules the sync job when a warehouse is configured
+func (s *Syncer) RegisterJobs(runner *jobs.Runner) {
+	if s.sink == nil {
+		return
+	}
+	runner.Every(JobSync, time.Duration(s.cfg.Interval)*time.Second, func(ctx context.Context, job *jobs.Job) error {
+		return s.Run(ctx)
+	})
+}
+
+// Run syncs every configured table once. A failing table doesn't stop the
+// others; the first error is returned after all have run.
+func (s *Syncer) Run(ctx context.Context) error {
+	var firstErr error
+	until := time.Now().Add(-time.Duration(s.cfg.Lag) * time.Second)
+	for _, name := range s.cfg.Tables {
+		n, err := s.sync(ctx, name, until)
+		if n > 0 {
+			logrus.Infof("Synced %d rows of %s to the warehouse", n, name)
+		}
+		if err != nil {
+			logrus.Errorf("Warehouse sync of %s failed: %v", name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// sync brings the table's schema up to date, then inserts its changes
+// before until batch by batch, moving the watermark after each. A run
+// stops early when another moved the watermark first.
+func (s *Syncer) sync(ctx context.Context, name string, until time.Time) (int, error) {
+	t, ok := Tables[name]
+	if !ok {
+		return 0, fmt.Errorf("no warehouse table %q", name)
+	}
+	if err := s.sink.EnsureTable(ctx, t); err != nil {
+		return 0, fmt.Errorf("failed to update schema: %w", err)
+	}
+	from, err := s.watermark(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for i := 0; i < maxBatchesPerRun && ctx.Err() == nil; i++ {
+		rows, last, err := s.read(ctx, t, from, until)
+		if err != nil || len(rows) == 0 {
+			return total, err
+		}
+		if err := s.sink.Insert(ctx, t, rows); err != nil {
+			return total, fmt.Errorf("failed to insert %d rows: %w", len(rows), err)
+		}
+		advanced, err := s.advance(ctx, name, from, last)
+		if err != nil || !advanced {
+			return total, err
+		}
+		total += len(rows)
+		from = last
+		if len(rows) < s.cfg.BatchSize {
+			return total, nil
+		}
+	}
+	return total, ctx.Err()
+}
+
+// read fetches the batch of rows changed after from and before until, and
+// the watermark of the last. Rows are read from a replica when configured.
+func (s *Syncer) read(ctx context.Context, t Table, from watermark, until time.Time) ([]Row, watermark, error) {
+	result, err := s.conn.Reader().QueryContext(ctx, t.query(), until, from.at, from.id, s.cfg.BatchSize)
+	if err != nil {
+		return nil, watermark{}, err
+	}
+	defer result.Close()
+
+	now := time.Now().UTC()
+	var rows []Row
+	var last watermark
+	for result.Next() {
+		var data string
+		if err := result.Scan(&last.id, &last.at, &data); err != nil {
+			return nil, watermark{}, err
+		}
+		row, err := decodeRow(last, data, now)
+		if err != nil {
+			return nil, watermark{}, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, last, result.Err()
+}
+
+// decodeRow reads a row object, keeping numbers as written
+func decodeRow(w watermark, data string, syncTime time.Time) (Row, error) {
+	values := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return Row{}, err
+	}
+	values[syncedAt] = syncTime.Format(time.RFC3339Nano)
+	return Row{ID: w.id + "@" + w.at.UTC().Format(time.RFC3339Nano), Values: values}, nil
+}
+
+func (s *Syncer) watermark(ctx context.Context, name string) (watermark, error) {
+	var w watermark
+	err := s.conn.QueryRowContext(ctx,
+		`SELECT last_changed_at, last_id::text FROM warehouse_watermarks WHERE table_name = $1`, name).Scan(&w.at, &w.id)
+	if err == sql.ErrNoRows {
+		return syncStart, nil
+	}
+	return w, err
+}
+
+// advance moves the table's watermark from one row to the next, unless it
+// is no longer at from because another run got there first
+func (s *Syncer) advance(ctx context.Context, name string, from, to watermark) (bool, error) {
+	result, err := s.conn.ExecContext(ctx, `
+		INSERT INTO warehouse_watermarks (table_name, last_changed_at, last_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (table_name) DO UPDATE
+		SET last_changed_at = EXCLUDED.last_changed_at, last_id = EXCLUDED.last_id, updated_at = NOW()
+		WHERE warehouse_watermarks.last_changed_at = $4 AND warehouse_watermarks.last_id = $5::uuid
+	`, name, to.at, to.id, from.at, from.id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if n == 0 && err == nil {
+		logrus.Warnf("Warehouse watermark of %s moved during the run; stopping", name)
+	}
+	return n > 0, err
+}
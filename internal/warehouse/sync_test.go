@@ -0,0 +1,56 @@
+package warehouse
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableQuery(t *testing.T) {
+	query := Tables["plan_changes"].query()
+
+	assert.Contains(t, query, "json_build_object('id', id, 'subscription_id', subscription_id, 'from_plan_id', from_value, 'to_plan_id', to_value, 'created_at', created_at)")
+	assert.Contains(t, query, "WHERE created_at < $1 AND (created_at, id) > ($2, $3::uuid) AND event = 'plan_changed'")
+	assert.Contains(t, query, "ORDER BY created_at, id")
+}
+
+func TestTablesHaveIDAndWatermarkColumns(t *testing.T) {
+	for name, table := range Tables {
+		assert.Equal(t, name, table.Name)
+		names := map[string]bool{}
+		for _, c := range table.Columns {
+			names[c.Name] = true
+		}
+		assert.True(t, names["id"], name)
+		assert.True(t, names[table.Watermark], name)
+	}
+}
+
+func TestDecodeRow(t *testing.T) {
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	synced := at.Add(time.Minute)
+
+	row, err := decodeRow(watermark{at: at, id: "sub-1"}, `{"id":"sub-1","amount":"9.9900","discount_percent":10,"metadata":{"source":"app"}}`, synced)
+	require.NoError(t, err)
+
+	assert.Equal(t, "sub-1@2026-03-04T05:06:07Z", row.ID)
+	assert.Equal(t, "9.9900", row.Values["amount"])
+	assert.Equal(t, json.Number("10"), row.Values["discount_percent"])
+	assert.Equal(t, "2026-03-04T05:07:07Z", row.Values[syncedAt])
+
+	values, err := bigQueryValues(Tables["subscriptions"], row)
+	require.NoError(t, err)
+	assert.Equal(t, `{"source":"app"}`, values["metadata"])
+	assert.NotContains(t, values, "trial_end")
+}
+
+func TestMissingColumns(t *testing.T) {
+	missing := missingColumns(Tables["plan_changes"].columns(), []string{"ID", "SUBSCRIPTION_ID", "FROM_PLAN_ID", "created_at"})
+
+	require.Len(t, missing, 2)
+	assert.Equal(t, "to_plan_id", missing[0].Name)
+	assert.Equal(t, syncedAt, missing[1].Name)
+}
@@ -0,0 +1,142 @@
+package warehouse
+
+import "strings"
+
+// ColumnType is the warehouse type of a synced column; each sink maps it
+// to its own SQL type.
+type ColumnType int
+
+const (
+	String ColumnType = iota
+	Timestamp
+	Numeric
+	Int
+	Bool
+	JSON
+)
+
+// Column is a warehouse column and the SQL reading it from the source
+// table, the column of the same name when Expr is empty.
+type Column struct {
+	Name string
+	Type ColumnType
+	Expr string
+}
+
+// Table is a warehouse table kept in step with a source table. Rows are
+// read in Watermark order, the column that changes whenever a row does;
+// Where narrows the source rows.
+type Table struct {
+	Name      string
+	Source    string
+	Watermark string
+	Where     string
+	Columns   []Column
+}
+
+// syncedAt records when a row version reached the warehouse. Changed rows
+// are appended again, so the latest row version of an id has the greatest
+// watermark.
+const syncedAt = "_synced_at"
+
+// Tables are the tables the sync can replicate, by name. Payment gateway
+// responses and other personal data stay out of the warehouse.
+var Tables = map[string]Table{
+	"subscriptions": {
+		Name:      "subscriptions",
+		Source:    "subscriptions",
+		Watermark: "updated_at",
+		Columns: []Column{
+			{Name: "id", Type: String},
+			{Name: "user_id", Type: String},
+			{Name: "plan_id", Type: String},
+			{Name: "status", Type: String},
+			{Name: "start_date", Type: Timestamp},
+			{Name: "end_date", Type: Timestamp},
+			{Name: "trial_end", Type: Timestamp},
+			{Name: "auto_renew", Type: Bool},
+			{Name: "amount", Type: Numeric, Expr: "amount::text"},
+			{Name: "currency", Type: String},
+			{Name: "discount_percent", Type: Int},
+			{Name: "metadata", Type: JSON},
+			{Name: "created_at", Type: Timestamp},
+			{Name: "updated_at", Type: Timestamp},
+		},
+	},
+	"transactions": {
+		Name:      "transactions",
+		Source:    "payment_transactions",
+		Watermark: "updated_at",
+		Columns: []Column{
+			{Name: "id", Type: String},
+			{Name: "subscription_id", Type: String},
+			{Name: "user_id", Type: String},
+			{Name: "status", Type: String},
+			{Name: "amount", Type: Numeric, Expr: "amount::text"},
+			{Name: "refunded_amount", Type: Numeric, Expr: "refunded_amount::text"},
+			{Name: "credited_amount", Type: Numeric, Expr: "credited_amount::text"},
+			{Name: "currency", Type: String},
+			{Name: "payment_method", Type: String},
+			{Name: "decline_code", Type: String},
+			{Name: "created_at", Type: Timestamp},
+			{Name: "updated_at", Type: Timestamp},
+		},
+	},
+	"plan_changes": {
+		Name:      "plan_changes",
+		Source:    "subscription_events",
+		Watermark: "created_at",
+		Where:     "event = 'plan_changed'",
+		Columns: []Column{
+			{Name: "id", Type: String},
+			{Name: "subscription_id", Type: String},
+			{Name: "from_plan_id", Type: String, Expr: "from_value"},
+			{Name: "to_plan_id", Type: String, Expr: "to_value"},
+			{Name: "created_at", Type: Timestamp},
+		},
+	},
+}
+
+// columns are the table's columns in the warehouse, its own and syncedAt
+func (t Table) columns() []Column {
+	return append(append([]Column{}, t.Columns...), Column{Name: syncedAt, Type: Timestamp})
+}
+
+// query reads the next batch of rows after a watermark: $1 is the time
+// changes are read up to, $2 and $3 the watermark and id after which to
+// start and $4 the batch size. Each row is an object of its columns.
+func (t Table) query() string {
+	pairs := make([]string, 0, len(t.Columns))
+	for _, c := range t.Columns {
+		expr := c.Expr
+		if expr == "" {
+			expr = c.Name
+		}
+		pairs = append(pairs, "'"+c.Name+"', "+expr)
+	}
+	where := t.Watermark + " < $1 AND (" + t.Watermark + ", id) > ($2, $3::uuid)"
+	if t.Where != "" {
+		where += " AND " + t.Where
+	}
+	return `SELECT id::text, ` + t.Watermark + `, json_build_object(` + strings.Join(pairs, ", ") + `)::text
+		FROM ` + t.Source + `
+		WHERE ` + where + `
+		ORDER BY ` + t.Watermark + `, id
+		LIMIT $4`
+}
+
+// missingColumns are the columns not among names, compared without case
+// as warehouses fold unquoted names
+func missingColumns(columns []Column, names []string) []Column {
+	have := make(map[string]bool, len(names))
+	for _, name := range names {
+		have[strings.ToLower(name)] = true
+	}
+	var missing []Column
+	for _, c := range columns {
+		if !have[c.Name] {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}